@@ -24,178 +24,310 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
-	"net"
 	"os"
 	"os/signal"
-	"readpebble/internal/storage"
-	"readpebble/pkg/client"
+	"readpebble/internal/acl"
+	"readpebble/internal/auth"
+	"readpebble/internal/blob"
+	"readpebble/internal/collection"
+	"readpebble/internal/dirlock"
+	"readpebble/internal/durability"
+	"readpebble/internal/embed"
+	"readpebble/internal/server"
 	"strings"
-	"sync"
 	"syscall"
+	"time"
 
 	"github.com/cockroachdb/pebble"
 )
 
-const (
-	redisOK     = "+OK\r\n"
-	redisNil    = "$-1\r\n"
-	redisPrefix = "*"
-)
+func main() {
+	addr := flag.String("addr", ":6379", "address for RESP clients")
+	adminAddr := flag.String("admin-addr", "", "separate listener for operational commands, e.g. 127.0.0.1:6380 or unix:/tmp/vecble.sock")
+	adminLocalOnly := flag.Bool("admin-local-only", true, "refuse admin connections from non-loopback addresses")
+	adminPassword := flag.String("admin-password", "", "password required with AUTH on the admin listener")
+	authBackend := flag.String("auth-backend", "", "authentication backend for AUTH: password, file, ldap, jwt or acl")
+	aclFile := flag.String("aclfile", "", "ACL file restricting each user's commands, keys and channels, loaded at startup and written by ACL SAVE")
+	auditLog := flag.String("audit-log", "", "file security events are appended to, the server log when empty")
+	requirePass := flag.String("requirepass", "", "password for the password auth backend")
+	authFile := flag.String("auth-file", "", "user file for the file auth backend, one \"username sha256\" per line")
+	ldapAddr := flag.String("ldap-addr", "", "LDAP server for the ldap auth backend, ldaps:// for TLS")
+	ldapBindDN := flag.String("ldap-bind-dn", "", "DN template bound as, e.g. uid=%s,ou=people,dc=example,dc=com")
+	jwtSecret := flag.String("jwt-secret", "", "HS256 key for the jwt auth backend")
+	jwtIssuer := flag.String("jwt-issuer", "", "required iss claim of JWT tokens")
+	jwtAudience := flag.String("jwt-audience", "", "required aud claim of JWT tokens")
+	httpAddr := flag.String("http-addr", "", "address for metrics and the admin API, disabled when empty")
+	metricsLabelLimit := flag.Int("metrics-label-limit", 100, "collections and tenants given their own metric labels before the rest are reported as \"other\"")
+	dashboard := flag.Bool("dashboard", false, "serve the admin web UI from the HTTP listener")
+	pprofEnabled := flag.Bool("pprof", false, "expose net/http/pprof on the HTTP listener")
+	pprofToken := flag.String("pprof-token", "", "bearer token required for the pprof endpoints")
+	rebind := flag.Bool("rebind-on-failure", false, "re-create the listener if accepting connections fails")
+	clusterEnabled := flag.Bool("cluster-enabled", false, "reject multi-key commands whose keys hash to different cluster slots")
+	clusterShards := flag.String("cluster-shards", "", "comma-separated addresses of the other cluster nodes VSEARCH fans out to")
+	shardTimeout := flag.Duration("shard-timeout", 500*time.Millisecond, "how long a cluster-wide VSEARCH waits for each shard")
+	replicaOf := flag.String("replicaof", "", "replicate from a Redis master given as \"host port\"")
+	replicationBacklog := flag.Int64("replication-backlog", 1<<20, "bytes of the stream sent to replicas kept for replicas resuming after a disconnection")
+	replicationWindow := flag.Int64("replication-window", 4<<20, "bytes of the master's stream a replica may receive ahead of applying it before it stops reading")
+	durabilityMode := flag.String("durability", "always", "when writes reply: always (after an fsync), batched (after an fsync shared within -sync-window) or none")
+	durabilityOverrides := flag.String("durability-override", "", "per-command durability, e.g. vadd=batched,set=none")
+	syncWindow := flag.Duration("sync-window", 2*time.Millisecond, "how long batched writes wait to share an fsync")
+	backgroundReadRate := flag.Int64("background-read-rate", 0, "bytes per second maintenance reads such as MIGRATE may use, 0 for unlimited")
+	backgroundCPUJobs := flag.Int("background-cpu-jobs", 0, "maintenance jobs such as index builds that may use the CPUs at once, 0 for a quarter of GOMAXPROCS")
+	backgroundIOJobs := flag.Int("background-io-jobs", 2, "maintenance jobs such as checkpoints, expiry sweeps and backups that may use the disk at once")
+	minFreeDisk := flag.Int64("min-free-disk", 256<<20, "free bytes in the data directory below which writes are rejected with -NOSPACE until space is freed, 0 to disable")
+	hedgeAfter := flag.Duration("hedge-after", 0, "issue a second read for GETs slower than this, 0 to disable")
+	handoffTimeout := flag.Duration("handoff-timeout", time.Minute, "how long a process taking over from another waits for it to release the data directory")
+	forceRecover := flag.Bool("force-recover", false, "take over a data directory whose lock was left by a process on another host")
+	shadowAddr := flag.String("shadow-addr", "", "instance successful writes are also forwarded to, asynchronously, to try out a migration")
+	shadowQueue := flag.Int("shadow-queue", 10000, "writes that may wait for the shadow before new ones are dropped")
+	shadowCompareInterval := flag.Duration("shadow-compare-interval", time.Minute, "how often sampled keys are compared with the shadow, 0 to compare only on SHADOW COMPARE")
+	shadowSamples := flag.Int("shadow-samples", 100, "keys sampled by each comparison with the shadow")
+	embedderURL := flag.String("embedder-url", "", "OpenAI-compatible embeddings endpoint VADDTEXT and EMBED use, disabled when empty")
+	embedderModel := flag.String("embedder-model", "", "model requested from the embedding provider")
+	embedderAPIKey := flag.String("embedder-api-key", "", "bearer token for the embedding provider")
+	embedderTimeout := flag.Duration("embedder-timeout", 2*time.Second, "how long a call to the embedding provider may take")
+	embedderFailures := flag.Int("embedder-failure-threshold", 5, "failed calls in a row after which the embedding provider is no longer called until -embedder-cooldown passes")
+	embedderCooldown := flag.Duration("embedder-cooldown", 30*time.Second, "how long the embedding provider is left alone after failing")
+	embedderFallback := flag.String("embedder-fallback", "reject", "what VADDTEXT does while the embedding provider is unavailable: reject, queue (in memory) or defer (store the point for the embedding workers)")
+	embedderQueue := flag.Int("embedder-queue", 1000, "writes the queue fallback holds")
+	embedWorkers := flag.Int("embed-workers", 4, "deferred points embedded at once")
+	embedMaxAttempts := flag.Int("embed-max-attempts", 10, "times embedding a deferred point is tried before it is dropped")
+	embedCache := flag.Bool("embed-cache", false, "keep the vectors texts are embedded into, so each text is sent to the embedding provider once")
+	embeddingsEndpoint := flag.Bool("embeddings-endpoint", false, "serve an OpenAI-compatible POST /v1/embeddings on the HTTP listener, backed by the embedding provider")
+	blobChunkSize := flag.Int("blob-chunk-size", blob.DefaultChunkSize, "bytes BLOB PUT splits documents into, each stored once however many documents contain it")
+	blobOffload := flag.String("blob-offload", "", "object store (file:///dir, or an http(s):// URL taking PUT, GET and DELETE) the chunks of large documents are stored in, disabled when empty")
+	blobOffloadToken := flag.String("blob-offload-token", "", "bearer token for the -blob-offload object store")
+	blobOffloadThreshold := flag.Int64("blob-offload-threshold", 4<<20, "documents of this many bytes or more are offloaded to -blob-offload")
+	indexMemoryBudget := flag.Int64("index-memory-budget", 0, "bytes the HNSW graphs of the collections may take in memory before the least recently searched are demoted to disk, 0 for no limit")
+	collectionsManifest := flag.String("collections-manifest", "", "YAML manifest of the collections to have, applied at startup as by APPLY")
+	scriptTimeout := flag.Duration("script-timeout", 5*time.Second, "how long an EVAL script may run, blocking every other command, before it fails")
+	captureFile := flag.String("capture-file", "", "file the commands of clients are captured to from startup, for vecble-replay to replay against another instance")
+	migrateLegacyVectors := flag.String("migrate-legacy-vectors", "", "collection the vectors stored as plain float64 keys by earlier versions are migrated into from startup")
+	migrateLegacyUntyped := flag.Bool("migrate-legacy-untyped", false, "also migrate untyped keys, written before keys had a type, that hold float64 vectors")
+	autoCreateCollections := flag.Bool("auto-create-collections", false, "make VADD to a collection that does not exist create it with the dimension of the vector added")
+	autoCreateMetric := flag.String("auto-create-metric", "l2", "metric of auto-created collections: l2, cosine or ip")
+	autoCreateIndex := flag.String("auto-create-index", "flat", "index of auto-created collections: flat or hnsw")
+	maxCommandSize := flag.Int64("max-command-size", 512<<20, "bytes a command may take as sent before it is rejected with \"request too large\" while being read")
+	slowlogLogSlowerThan := flag.Int64("slowlog-log-slower-than", 10000, "microseconds a command must take to be recorded in the slow log, 0 to record every command, negative to record none")
+	slowlogMaxLen := flag.Int("slowlog-max-len", 128, "how many of the latest slow commands the slow log keeps")
+	maxMemory := flag.Int64("maxmemory", 0, "bytes of heap above which writes are refused with -OOM, 0 for no limit")
+	notifyKeyspaceEvents := flag.String("notify-keyspace-events", "", "keyspace events published to Pub/Sub, with the flags of the Redis setting, e.g. KEA")
+	maxScanJobs := flag.Int("max-scan-jobs", 4, "how many analytic scans VANALYZE may run at once")
+	softValueSize := flag.Int64("soft-value-size", 0, "bytes of a written value above which the write is warned about, in the reply's RESP3 attribute and the log, 0 to disable")
+	softCollectionPoints := flag.Int64("soft-collection-points", 0, "points of a collection above which writes to it are warned about, 0 to disable")
+	softFilterConditions := flag.Int("soft-filter-conditions", 0, "conditions of a FILTER above which the search is warned about, 0 to disable")
+	dataDir := flag.String("data-dir", "pebble_data", "Pebble data directory")
+	configFile := flag.String("config", "", "file of settings, one \"flag value\" per line, that flags given on the command line override and CONFIG REWRITE updates")
+	flag.Parse()
 
-var (
-	db   *pebble.DB
-	lock sync.RWMutex
-)
+	if *configFile != "" {
+		settings, err := server.ReadConfigFile(*configFile)
+		if err != nil {
+			log.Fatalf("Failed to read config file: %v", err)
+		}
+		explicit := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		for _, setting := range settings {
+			if explicit[setting.Name] {
+				continue
+			}
+			if err := flag.Set(setting.Name, setting.Value); err != nil {
+				log.Fatalf("Invalid setting %s in config file: %v", setting.Name, err)
+			}
+		}
+	}
 
-func main() {
-	var err error
-	db, err = pebble.Open("pebble_data", &pebble.Options{})
+	mode, err := durability.ParseMode(*durabilityMode)
 	if err != nil {
-		log.Fatalf("Failed to open Pebble DB: %v", err)
+		log.Fatal(err)
 	}
-	defer db.Close()
-	storage := storage.NewStorage(db)
-	client := client.NewClient(&storage)
-	arr := []float64{1.1, 2.1, 3.1, 4.1}
-	client.Insert("1", arr)
-	res := client.Get("1")
-	fmt.Println(res)
-	return
-	listener, err := net.Listen("tcp", ":6379")
+	overrides, err := durability.ParseOverrides(*durabilityOverrides)
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		log.Fatal(err)
 	}
-	log.Println("Redis-compatible server running on :6379")
-	// Handle SIGTERM for graceful shutdown
-	sigCh := make(chan os.Signal, 1)
-	quitCh := make(chan os.Signal)
-	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
 
-	var wg sync.WaitGroup
-	go func() {
-		<-sigCh
-		log.Println("Received shutdown signal, closing server...")
-		close(quitCh)    // Notify all goroutines to stop
-		listener.Close() // Stop accepting new connections
-		wg.Wait()        // Wait for all connections to close
-		db.Flush()
-		log.Println("Server shutdown complete")
-		os.Exit(0)
-	}()
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Printf("Failed to accept connection: %v", err)
-			continue
-		}
-		wg.Add(1)
-		go handleConnection(conn, &wg)
+	fallback, err := embed.ParseFallback(*embedderFallback)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-}
-
-func handleConnection(conn net.Conn, wg *sync.WaitGroup) {
-	defer func() {
-		log.Printf("Client disconnected: %s", conn.RemoteAddr().String())
-		conn.Close()
-		wg.Done()
-	}()
-
-	reader := bufio.NewReader(conn)
-	// conn.Write([]byte("+Hello! Welcome to Pebble-Redis.\r\n"))
-
-	for {
-		cmd, args, err := parseRESP(reader)
-		if err != nil {
-			conn.Write([]byte("-ERR Parse error\r\n"))
-			return
-		}
-		response := handleCommand(cmd, args)
-		conn.Write([]byte(response))
+	metric, err := collection.ParseMetric(*autoCreateMetric)
+	if err != nil {
+		log.Fatal(err)
 	}
-}
-
-func parseRESP(reader *bufio.Reader) (string, []string, error) {
-	// Read the first line to determine the command type
-	line, err := reader.ReadString('\n')
+	index, err := collection.ParseIndexType(*autoCreateIndex)
 	if err != nil {
-		return "", []string{}, err
+		log.Fatal(err)
+	}
+	events, err := server.ParseKeyspaceEvents(*notifyKeyspaceEvents)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	log.Printf("Command: %q", line)
-	line = strings.TrimSpace(line)
-	log.Printf("Line: %q", line)
-
-	// Handle simple strings (single-line commands like PING)
-	if !strings.HasPrefix(line, "*") {
-		parts := strings.Fields(line)
-		if len(parts) == 0 {
-			return "", nil, fmt.Errorf("empty command")
+	var offload blob.ObjectStore
+	if *blobOffload != "" {
+		offload, err = blob.OpenObjectStore(*blobOffload, *blobOffloadToken)
+		if err != nil {
+			log.Fatal(err)
 		}
-		return parts[0], parts[1:], nil
 	}
 
-	// Handle RESP arrays (multi-line commands like SET key value)
-	numArgs := 0
-	fmt.Sscanf(line, "*%d", &numArgs)
+	var shards []string
+	if *clusterShards != "" {
+		shards = strings.Split(*clusterShards, ",")
+	}
 
-	args := make([]string, 0, numArgs)
-	for i := 0; i < numArgs; i++ {
-		_, err := reader.ReadString('\n') // Read length (skip it)
+	var aclStore *acl.Store
+	if *aclFile != "" {
+		store, err := acl.LoadFile(*aclFile)
 		if err != nil {
-			return "", nil, err
+			log.Fatalf("Failed to load ACL file: %v", err)
 		}
-		arg, err := reader.ReadString('\n') // Read actual argument
-		if err != nil {
-			return "", nil, err
+		aclStore = store
+	}
+
+	var authenticator auth.Authenticator
+	if *authBackend == "acl" {
+		if aclStore == nil {
+			log.Fatal("The acl auth backend needs -aclfile")
 		}
-		args = append(args, strings.TrimSpace(arg))
+		authenticator = aclStore
+	} else {
+		authenticator, err = auth.New(auth.Config{
+			Backend:     *authBackend,
+			Password:    *requirePass,
+			File:        *authFile,
+			LDAPAddr:    *ldapAddr,
+			LDAPBindDN:  *ldapBindDN,
+			JWTSecret:   *jwtSecret,
+			JWTIssuer:   *jwtIssuer,
+			JWTAudience: *jwtAudience,
+		})
+	}
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
 	}
 
-	if len(args) == 0 {
-		return "", nil, fmt.Errorf("invalid command format")
+	listeners, err := server.InheritedListeners()
+	if err != nil {
+		log.Fatalf("Failed to inherit listeners: %v", err)
+	}
+	lock, err := dirlock.Acquire(*dataDir, *forceRecover)
+	// During a handoff the previous process holds the directory until it
+	// has drained its connections.
+	var locked *dirlock.LockedError
+	for deadline := time.Now().Add(*handoffTimeout); errors.As(err, &locked) && listeners != nil && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		lock, err = dirlock.Acquire(*dataDir, *forceRecover)
+	}
+	if err != nil {
+		log.Fatalf("Failed to lock data directory: %v", err)
+	}
+	defer lock.Release()
+	if lock.Recovered != nil {
+		log.Printf("Recovered data directory lock left by %s", lock.Recovered)
 	}
 
-	return strings.ToLower(args[0]), args[1:], nil
-}
+	db, err := pebble.Open(*dataDir, &pebble.Options{})
+	if err != nil {
+		log.Fatalf("Failed to open Pebble DB: %v", err)
+	}
+	defer db.Close()
 
-func handleCommand(cmd string, args []string) string {
-	log.Printf("Executing command: %s, Args: %v", cmd, args)
+	srv := server.NewServer(db, server.Config{
+		Addr:                     *addr,
+		AdminAddr:                *adminAddr,
+		AdminLocalOnly:           *adminLocalOnly,
+		AdminPassword:            *adminPassword,
+		Authenticator:            authenticator,
+		ACL:                      aclStore,
+		AuditLog:                 *auditLog,
+		HTTPAddr:                 *httpAddr,
+		MetricsLabelLimit:        *metricsLabelLimit,
+		Dashboard:                *dashboard,
+		Pprof:                    *pprofEnabled,
+		PprofToken:               *pprofToken,
+		RebindOnFailure:          *rebind,
+		ClusterEnabled:           *clusterEnabled,
+		ClusterShards:            shards,
+		ShardTimeout:             *shardTimeout,
+		ReplicaOf:                *replicaOf,
+		ReplicationWindow:        *replicationWindow,
+		ReplicationBacklog:       *replicationBacklog,
+		Durability:               mode,
+		DurabilityOverrides:      overrides,
+		SyncWindow:               *syncWindow,
+		BackgroundReadRate:       *backgroundReadRate,
+		BackgroundCPUJobs:        *backgroundCPUJobs,
+		BackgroundIOJobs:         *backgroundIOJobs,
+		DataDir:                  *dataDir,
+		MinFreeDisk:              *minFreeDisk,
+		HedgeAfter:               *hedgeAfter,
+		Listeners:                listeners,
+		ShadowAddr:               *shadowAddr,
+		ShadowQueue:              *shadowQueue,
+		ShadowCompareInterval:    *shadowCompareInterval,
+		ShadowSamples:            *shadowSamples,
+		EmbedderURL:              *embedderURL,
+		EmbedderModel:            *embedderModel,
+		EmbedderAPIKey:           *embedderAPIKey,
+		EmbedderTimeout:          *embedderTimeout,
+		EmbedderFailureThreshold: *embedderFailures,
+		EmbedderCooldown:         *embedderCooldown,
+		EmbedderFallback:         fallback,
+		EmbedderQueue:            *embedderQueue,
+		EmbedWorkers:             *embedWorkers,
+		EmbedMaxAttempts:         *embedMaxAttempts,
+		EmbedCache:               *embedCache,
+		EmbeddingsEndpoint:       *embeddingsEndpoint,
+		BlobChunkSize:            *blobChunkSize,
+		BlobOffload:              offload,
+		BlobOffloadThreshold:     *blobOffloadThreshold,
+		CollectionsManifest:      *collectionsManifest,
+		IndexMemoryBudget:        *indexMemoryBudget,
+		ScriptTimeout:            *scriptTimeout,
+		CaptureFile:              *captureFile,
+		MigrateLegacyVectors:     *migrateLegacyVectors,
+		MigrateLegacyUntyped:     *migrateLegacyUntyped,
+		AutoCreateCollections:    *autoCreateCollections,
+		AutoCreateMetric:         metric,
+		AutoCreateIndex:          index,
+		SoftValueSize:            *softValueSize,
+		MaxCommandSize:           *maxCommandSize,
+		SlowlogLogSlowerThan:     *slowlogLogSlowerThan,
+		SlowlogMaxLen:            *slowlogMaxLen,
+		MaxMemory:                *maxMemory,
+		NotifyKeyspaceEvents:     events,
+		MaxScanJobs:              *maxScanJobs,
+		SoftCollectionPoints:     *softCollectionPoints,
+		SoftFilterConditions:     *softFilterConditions,
+		ConfigFile:               *configFile,
+	})
 
-	switch cmd {
-	case "ping":
-		return "+PONG\r\n"
-	case "set":
-		if len(args) != 2 {
-			return "-ERR wrong number of arguments for 'set' command\r\n"
-		}
-		key := args[0]
-		value := args[1]
-		err := db.Set([]byte(key), []byte(value), &pebble.WriteOptions{
-			Sync: false,
-		})
-		if err != nil {
-			return "-ERR Failed to set key: " + err.Error() + "\r\n"
-		}
-		return "+OK\r\n"
-	case "get":
-		if len(args) != 1 {
-			return "-ERR wrong number of arguments for 'get' command\r\n"
-		}
-		res, closer, err := db.Get([]byte(args[0]))
-		if err != nil {
-			if err == pebble.ErrNotFound {
-				return "$-1\r\n" // RESP representation for nil
+	// Handle SIGTERM for graceful shutdown and SIGUSR2 to hand off to a
+	// new binary
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigCh {
+			if sig == syscall.SIGUSR2 {
+				if _, err := srv.Handoff(); err != nil {
+					log.Printf("Handoff failed: %v", err)
+					continue
+				}
+				return
 			}
-			return "-ERR Failed to get key: " + err.Error() + "\r\n"
+			log.Println("Received shutdown signal, closing server...")
+			srv.Shutdown()
+			return
 		}
-		defer closer.Close()
-		return fmt.Sprintf("$%d\r\n%s\r\n", len(res), res)
+	}()
 
-	default:
-		return "-ERR unknown command\r\n"
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
+	db.Flush()
+	log.Println("Server shutdown complete")
 }
@@ -0,0 +1,180 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Command vecble-replay replays the commands captured from a server, with
+// CAPTURE START or -capture-file, against another instance, at their
+// original pace or faster, for load testing and for checking an upgrade
+// against real traffic.
+//
+// The commands of each captured connection are sent in order on a
+// connection of their own, so that connection state such as the name
+// set by CLIENT SETNAME or the level set by READCONSISTENCY carries over.
+// Pub/Sub subscriptions are not replayed.
+//
+//	vecble-replay -file traffic.cap -addr localhost:6380 -speed 2
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"readpebble/internal/capture"
+	"readpebble/pkg/client"
+)
+
+// skipped are the commands not replayed: the client negotiates its own
+// protocol, and subscribing would turn the connection over to messages.
+var skipped = map[string]bool{
+	"hello": true, "subscribe": true, "psubscribe": true, "unsubscribe": true, "punsubscribe": true,
+}
+
+// stats are the results of the commands a worker replayed.
+type stats struct {
+	sent      int
+	errors    int
+	failed    int
+	latencies []time.Duration
+}
+
+func main() {
+	file := flag.String("file", "", "capture file to replay")
+	addr := flag.String("addr", "localhost:6379", "address of the instance to replay against")
+	username := flag.String("username", "", "user to authenticate as")
+	password := flag.String("password", "", "password to authenticate with")
+	speed := flag.Float64("speed", 1, "pace relative to the capture, 2 for twice as fast, 0 to send commands as fast as possible")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for each reply")
+	flag.Parse()
+	if *file == "" || *speed < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	reader := capture.NewReader(f)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var total stats
+	workers := make(map[int64]chan []string)
+	worker := func(queue chan []string) {
+		defer wg.Done()
+		target := client.NewRemoteClient(client.Options{
+			Addr:        *addr,
+			Username:    *username,
+			Password:    *password,
+			PoolSize:    1,
+			ReadTimeout: *timeout,
+			// A command retried after the connection broke might run
+			// twice.
+			MaxRetries: -1,
+		})
+		defer target.Close()
+		var own stats
+		for args := range queue {
+			start := time.Now()
+			_, err := target.Do(args...)
+			own.latencies = append(own.latencies, time.Since(start))
+			own.sent++
+			var serverErr client.Error
+			switch {
+			case errors.As(err, &serverErr):
+				own.errors++
+			case err != nil:
+				own.failed++
+				log.Printf("%s failed: %v", args[0], err)
+			}
+		}
+		mutex.Lock()
+		total.sent += own.sent
+		total.errors += own.errors
+		total.failed += own.failed
+		total.latencies = append(total.latencies, own.latencies...)
+		mutex.Unlock()
+	}
+
+	var first time.Time
+	var maxLag time.Duration
+	var skips int
+	start := time.Now()
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", *file, err)
+		}
+		if skipped[strings.ToLower(record.Args[0])] {
+			skips++
+			continue
+		}
+		if first.IsZero() {
+			first = record.Time
+		}
+		if *speed > 0 {
+			due := start.Add(time.Duration(float64(record.Time.Sub(first)) / *speed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			} else if -wait > maxLag {
+				maxLag = -wait
+			}
+		}
+		queue, ok := workers[record.Conn]
+		if !ok {
+			queue = make(chan []string, 1024)
+			workers[record.Conn] = queue
+			wg.Add(1)
+			go worker(queue)
+		}
+		queue <- record.Args
+	}
+	for _, queue := range workers {
+		close(queue)
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	fmt.Printf("replayed %d commands from %d connections in %s (%.0f/s)\n",
+		total.sent, len(workers), elapsed.Round(time.Millisecond), float64(total.sent)/elapsed.Seconds())
+	fmt.Printf("error replies: %d, failed: %d, skipped: %d\n", total.errors, total.failed, skips)
+	if *speed > 0 {
+		fmt.Printf("max lag behind the capture's pace: %s\n", maxLag.Round(time.Millisecond))
+	}
+	if n := len(total.latencies); n > 0 {
+		slices.Sort(total.latencies)
+		fmt.Printf("latency p50: %s, p99: %s, max: %s\n",
+			total.latencies[n/2], total.latencies[n*99/100], total.latencies[n-1])
+	}
+}
@@ -2,7 +2,10 @@ module readpebble
 
 go 1.22.3
 
-require github.com/cockroachdb/pebble v1.1.4
+require (
+	github.com/cockroachdb/pebble v1.1.4
+	github.com/yuin/gopher-lua v1.1.1
+)
 
 require (
 	github.com/DataDog/zstd v1.4.5 // indirect
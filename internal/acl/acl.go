@@ -0,0 +1,480 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package acl holds users and the Redis-style rules deciding which commands
+// and keys each of them may use.
+package acl
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"readpebble/internal/auth"
+	"readpebble/internal/glob"
+)
+
+// commandRule allows or denies a command ("get", "config|get") or a
+// category ("@read"). Rules are applied in order, so the last one matching
+// a command decides.
+type commandRule struct {
+	allow bool
+	name  string
+}
+
+// User is a set of ACL rules, as given to ACL SETUSER or in an ACL file.
+type User struct {
+	Name      string
+	Enabled   bool
+	NoPass    bool
+	passwords [][sha256.Size]byte
+	commands  []commandRule
+	keys      []string
+	channels  []string
+}
+
+// NewUser returns a disabled user that may run nothing, like a user
+// created by ACL SETUSER without rules. As in Redis 7, it may use no Pub/Sub
+// channel until given allchannels or channel patterns.
+func NewUser(name string) *User {
+	return &User{Name: name}
+}
+
+// Apply applies rules to u in order.
+func (u *User) Apply(rules ...string) error {
+	for _, rule := range rules {
+		if err := u.apply(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *User) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.Enabled = true
+	case "off":
+		u.Enabled = false
+	case "nopass":
+		u.NoPass = true
+		u.passwords = nil
+	case "resetpass":
+		u.NoPass = false
+		u.passwords = nil
+	case "allkeys":
+		u.keys = []string{"*"}
+	case "resetkeys":
+		u.keys = nil
+	case "allchannels":
+		u.channels = []string{"*"}
+	case "resetchannels":
+		u.channels = nil
+	case "allcommands":
+		u.commands = []commandRule{{allow: true, name: "@all"}}
+	case "nocommands":
+		u.commands = nil
+	case "reset":
+		*u = User{Name: u.Name}
+	case "":
+		return fmt.Errorf("syntax error in ACL rule %q", rule)
+	default:
+		switch rule[0] {
+		case '>':
+			u.passwords = append(u.passwords, sha256.Sum256([]byte(rule[1:])))
+			u.NoPass = false
+		case '#':
+			hash, err := hex.DecodeString(rule[1:])
+			if err != nil || len(hash) != sha256.Size {
+				return fmt.Errorf("invalid password hash in %q", rule)
+			}
+			u.passwords = append(u.passwords, [sha256.Size]byte(hash))
+			u.NoPass = false
+		case '~':
+			u.keys = append(u.keys, rule[1:])
+		case '&':
+			u.channels = append(u.channels, rule[1:])
+		case '+', '-':
+			if len(rule) < 2 {
+				return fmt.Errorf("syntax error in ACL rule %q", rule)
+			}
+			name := strings.ToLower(rule[1:])
+			if strings.HasPrefix(name, "@") && !knownCategories[name[1:]] {
+				return fmt.Errorf("unknown command category %q", name)
+			}
+			u.commands = append(u.commands, commandRule{allow: rule[0] == '+', name: name})
+		default:
+			return fmt.Errorf("syntax error in ACL rule %q", rule)
+		}
+	}
+	return nil
+}
+
+// knownCategories are the categories commands may be tagged with.
+var knownCategories = map[string]bool{
+	"all": true, "read": true, "write": true, "admin": true, "dangerous": true,
+	"fast": true, "slow": true, "keyspace": true, "string": true, "connection": true,
+	"hash": true, "list": true, "set": true, "sortedset": true, "bitmap": true,
+	"stream": true, "blocking": true, "vector": true, "pubsub": true, "scripting": true,
+}
+
+// Categories returns the names of the command categories, sorted.
+func Categories() []string {
+	names := make([]string, 0, len(knownCategories))
+	for name := range knownCategories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CanRun reports whether u may run cmd, called with subcommand sub (which
+// may be empty) and tagged with categories (without the leading @).
+func (u *User) CanRun(cmd, sub string, categories []string) bool {
+	allowed := false
+	for _, rule := range u.commands {
+		if rule.matches(cmd, sub, categories) {
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+func (r commandRule) matches(cmd, sub string, categories []string) bool {
+	if name, ok := strings.CutPrefix(r.name, "@"); ok {
+		if name == "all" {
+			return true
+		}
+		for _, category := range categories {
+			if category == name {
+				return true
+			}
+		}
+		return false
+	}
+	if parent, child, ok := strings.Cut(r.name, "|"); ok {
+		return parent == cmd && strings.EqualFold(child, sub)
+	}
+	return r.name == cmd
+}
+
+// CanAccess reports whether key matches one of u's key patterns.
+func (u *User) CanAccess(key string) bool {
+	for _, pattern := range u.keys {
+		if glob.Match(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessChannel reports whether u may publish or subscribe to channel,
+// which must match one of u's channel patterns. With pattern set, channel is
+// a pattern to PSUBSCRIBE to, which must instead be one of them, unless u
+// has allchannels, as it could otherwise match channels u may not use.
+func (u *User) CanAccessChannel(channel string, pattern bool) bool {
+	for _, allowed := range u.channels {
+		if allowed == "*" || (!pattern && glob.Match(allowed, channel)) || (pattern && allowed == channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flags returns the flags of u as ACL GETUSER lists them.
+func (u *User) Flags() []string {
+	flags := []string{"off"}
+	if u.Enabled {
+		flags[0] = "on"
+	}
+	if u.NoPass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// PasswordHashes returns the SHA-256 hashes of u's passwords in hex.
+func (u *User) PasswordHashes() []string {
+	hashes := make([]string, len(u.passwords))
+	for i, hash := range u.passwords {
+		hashes[i] = hex.EncodeToString(hash[:])
+	}
+	return hashes
+}
+
+// Commands returns the command rules of u, such as "+@all -debug", or
+// "-@all" if it may run nothing.
+func (u *User) Commands() string {
+	if len(u.commands) == 0 {
+		return "-@all"
+	}
+	rules := make([]string, len(u.commands))
+	for i, rule := range u.commands {
+		rules[i] = "-" + rule.name
+		if rule.allow {
+			rules[i] = "+" + rule.name
+		}
+	}
+	return strings.Join(rules, " ")
+}
+
+// Keys returns the key patterns of u.
+func (u *User) Keys() []string {
+	return u.keys
+}
+
+// Channels returns the Pub/Sub channel patterns of u.
+func (u *User) Channels() []string {
+	return u.channels
+}
+
+// Rules returns rules that give a new user the permissions of u, as ACL
+// LIST and ACL SAVE write them. Passwords are given by their hashes.
+func (u *User) Rules() []string {
+	rules := u.Flags()
+	for _, hash := range u.PasswordHashes() {
+		rules = append(rules, "#"+hash)
+	}
+	for _, pattern := range u.keys {
+		rules = append(rules, "~"+pattern)
+	}
+	for _, pattern := range u.channels {
+		rules = append(rules, "&"+pattern)
+	}
+	return append(rules, strings.Fields(u.Commands())...)
+}
+
+// clone returns a copy of u that rules can be applied to without changing
+// u.
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = append([][sha256.Size]byte{}, u.passwords...)
+	c.commands = append([]commandRule{}, u.commands...)
+	c.keys = append([]string{}, u.keys...)
+	c.channels = append([]string{}, u.channels...)
+	return &c
+}
+
+func (u *User) checkPassword(password string) bool {
+	if u.NoPass {
+		return true
+	}
+	sum := sha256.Sum256([]byte(password))
+	ok := false
+	for _, hash := range u.passwords {
+		if subtle.ConstantTimeCompare(sum[:], hash[:]) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// Store holds the users by name. Users are never changed once in the
+// store, but replaced, so that a User may be used without holding the lock.
+type Store struct {
+	mutex sync.RWMutex
+	users map[string]*User
+	// path is the ACL file the store was loaded from, which Save writes.
+	path string
+}
+
+// NewStore returns a store with only the default user, which may run every
+// command on every key and use every channel without a password, as in a
+// fresh Redis.
+func NewStore() *Store {
+	return &Store{users: defaultUsers()}
+}
+
+func defaultUsers() map[string]*User {
+	user := NewUser(auth.DefaultUser)
+	user.Apply("on", "nopass", "allkeys", "allchannels", "allcommands")
+	return map[string]*User{user.Name: user}
+}
+
+// LoadFile reads users from an ACL file, one per line in the Redis format
+//
+//	user <name> [rule ...]
+//
+// Blank lines and lines starting with # are ignored. Users not in the file
+// keep their defaults.
+func LoadFile(path string) (*Store, error) {
+	users, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{users: users, path: path}, nil
+}
+
+func readFile(path string) (map[string]*User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := defaultUsers()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"user <name> [rule ...]\"", path, line)
+		}
+		user := NewUser(fields[1])
+		if err := user.Apply(fields[2:]...); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		users[user.Name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Path returns the ACL file of s, empty if it was not loaded from one.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load replaces the users of s with those of its ACL file, as ACL LOAD
+// does, keeping them if the file cannot be read.
+func (s *Store) Load() error {
+	if s.path == "" {
+		return errors.New("no ACL file is configured")
+	}
+	users, err := readFile(s.path)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.users = users
+	s.mutex.Unlock()
+	return nil
+}
+
+// Save writes the users of s to its ACL file, replacing it atomically.
+func (s *Store) Save() error {
+	if s.path == "" {
+		return errors.New("no ACL file is configured")
+	}
+	var b strings.Builder
+	for _, user := range s.Users() {
+		fmt.Fprintf(&b, "user %s %s\n", user.Name, strings.Join(user.Rules(), " "))
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// SetUser applies rules to the user called name, as ACL SETUSER does,
+// creating it if there is none. The rules are applied all or none.
+func (s *Store) SetUser(name string, rules ...string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user := NewUser(name)
+	if existing := s.users[name]; existing != nil {
+		user = existing.clone()
+	}
+	if err := user.Apply(rules...); err != nil {
+		return err
+	}
+	s.users[name] = user
+	return nil
+}
+
+// DelUser removes the users called names, returning how many there were.
+// The default user cannot be removed.
+func (s *Store) DelUser(names ...string) (int, error) {
+	for _, name := range names {
+		if name == auth.DefaultUser {
+			return 0, errors.New("the 'default' user cannot be removed")
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for _, name := range names {
+		if s.users[name] != nil {
+			delete(s.users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Users returns the users of s, sorted by name.
+func (s *Store) Users() []*User {
+	s.mutex.RLock()
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	s.mutex.RUnlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// User returns the user called name, or nil if there is none.
+func (s *Store) User(name string) *User {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.users[name]
+}
+
+// Authenticate lets the store act as an auth backend, checking the
+// passwords given in the ACL rules.
+func (s *Store) Authenticate(username, password string) (string, error) {
+	user := s.User(username)
+	if user == nil || !user.Enabled || !user.checkPassword(password) {
+		return "", auth.ErrInvalidCredentials
+	}
+	return username, nil
+}
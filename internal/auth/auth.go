@@ -0,0 +1,107 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package auth verifies the credentials clients send with AUTH against a
+// configurable backend: a static password, a file of users, an LDAP
+// directory or signed JWT bearer tokens.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidCredentials is returned when the backend rejects the
+// credentials, as opposed to failing to check them.
+var ErrInvalidCredentials = errors.New("invalid username-password pair or user is disabled")
+
+// DefaultUser is the username AUTH implies when only a password is given.
+const DefaultUser = "default"
+
+// Authenticator checks a username and password and returns the name the
+// client is known by from then on, which for token backends comes from the
+// token rather than the username argument.
+type Authenticator interface {
+	Authenticate(username, password string) (string, error)
+}
+
+// Config selects and configures a backend.
+type Config struct {
+	// Backend is one of "", "password", "file", "ldap" or "jwt". Empty
+	// disables authentication.
+	Backend string
+	// Password is the shared secret of the "password" backend.
+	Password string
+	// File is the user file of the "file" backend.
+	File string
+	// LDAPAddr is the host:port of the directory, with an "ldaps://" prefix
+	// for TLS.
+	LDAPAddr string
+	// LDAPBindDN is the DN bound as, with %s replaced by the username, e.g.
+	// "uid=%s,ou=people,dc=example,dc=com".
+	LDAPBindDN string
+	// JWTSecret is the HMAC key tokens must be signed with (HS256).
+	JWTSecret string
+	// JWTIssuer, when set, must match the token's "iss" claim.
+	JWTIssuer string
+	// JWTAudience, when set, must be listed in the token's "aud" claim.
+	JWTAudience string
+	// Timeout bounds calls to remote backends.
+	Timeout time.Duration
+}
+
+// New returns the backend described by config, or nil when authentication
+// is disabled.
+func New(config Config) (Authenticator, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	switch config.Backend {
+	case "":
+		return nil, nil
+	case "password":
+		if config.Password == "" {
+			return nil, errors.New("auth: password backend needs a password")
+		}
+		return NewStatic(config.Password), nil
+	case "file":
+		file, err := LoadFile(config.File)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	case "ldap":
+		if config.LDAPAddr == "" || config.LDAPBindDN == "" {
+			return nil, errors.New("auth: ldap backend needs an address and a bind DN template")
+		}
+		return &LDAP{Addr: config.LDAPAddr, BindDN: config.LDAPBindDN, Timeout: config.Timeout}, nil
+	case "jwt":
+		if config.JWTSecret == "" {
+			return nil, errors.New("auth: jwt backend needs a secret")
+		}
+		return &JWT{Secret: []byte(config.JWTSecret), Issuer: config.JWTIssuer, Audience: config.JWTAudience}, nil
+	default:
+		return nil, fmt.Errorf("auth: unknown backend %q", config.Backend)
+	}
+}
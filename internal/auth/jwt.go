@@ -0,0 +1,113 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// JWT accepts HS256-signed bearer tokens passed as the AUTH password. The
+// client is known by the token's "sub" claim; the username argument is
+// ignored so that plain AUTH <token> works.
+type JWT struct {
+	Secret   []byte
+	Issuer   string
+	Audience string
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+}
+
+func (j *JWT) Authenticate(username, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidCredentials
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return "", ErrInvalidCredentials
+	}
+	mac := hmac.New(sha256.New, j.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || subtle.ConstantTimeCompare(signature, mac.Sum(nil)) != 1 {
+		return "", ErrInvalidCredentials
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", ErrInvalidCredentials
+	}
+	now := time.Now().Unix()
+	if claims.Subject == "" || (claims.ExpiresAt != 0 && now >= claims.ExpiresAt) || now < claims.NotBefore {
+		return "", ErrInvalidCredentials
+	}
+	if j.Issuer != "" && claims.Issuer != j.Issuer {
+		return "", ErrInvalidCredentials
+	}
+	if j.Audience != "" && !hasAudience(claims.Audience, j.Audience) {
+		return "", ErrInvalidCredentials
+	}
+	return claims.Subject, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// hasAudience reports whether aud, a string or a list of strings, contains
+// want.
+func hasAudience(aud json.RawMessage, want string) bool {
+	var single string
+	if json.Unmarshal(aud, &single) == nil {
+		return single == want
+	}
+	var list []string
+	if json.Unmarshal(aud, &list) != nil {
+		return false
+	}
+	for _, a := range list {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
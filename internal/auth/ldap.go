@@ -0,0 +1,197 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+)
+
+// LDAP authenticates with a simple bind as the DN built from BindDN and the
+// username. Only the bind result is used; group membership is not checked.
+type LDAP struct {
+	// Addr is host:port, prefixed with "ldaps://" to use TLS.
+	Addr    string
+	BindDN  string
+	Timeout time.Duration
+}
+
+func (l *LDAP) Authenticate(username, password string) (string, error) {
+	// An empty password would make the bind anonymous, which servers
+	// accept for any DN.
+	if username == "" || password == "" {
+		return "", ErrInvalidCredentials
+	}
+	conn, err := l.dial()
+	if err != nil {
+		return "", fmt.Errorf("ldap: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(l.Timeout))
+
+	dn := fmt.Sprintf(l.BindDN, escapeDN(username))
+	bind := berTLV(0x60, berInt(3), berTLV(0x04, []byte(dn)), berTLV(0x80, []byte(password)))
+	if _, err := conn.Write(berTLV(0x30, berInt(1), bind)); err != nil {
+		return "", fmt.Errorf("ldap: %w", err)
+	}
+	code, err := readBindResult(bufio.NewReader(conn))
+	if err != nil {
+		return "", fmt.Errorf("ldap: %w", err)
+	}
+	// Unbind: [APPLICATION 2] NULL.
+	conn.Write(berTLV(0x30, berInt(2), []byte{0x42, 0x00}))
+
+	switch code {
+	case ldapResultSuccess:
+		return username, nil
+	case ldapResultInvalidCredentials:
+		return "", ErrInvalidCredentials
+	default:
+		return "", fmt.Errorf("ldap: bind failed with result code %d", code)
+	}
+}
+
+func (l *LDAP) dial() (net.Conn, error) {
+	if addr, ok := strings.CutPrefix(l.Addr, "ldaps://"); ok {
+		host, _, _ := net.SplitHostPort(addr)
+		dialer := &net.Dialer{Timeout: l.Timeout}
+		return tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	}
+	return net.DialTimeout("tcp", strings.TrimPrefix(l.Addr, "ldap://"), l.Timeout)
+}
+
+// readBindResult reads an LDAPMessage holding a BindResponse and returns
+// its result code.
+func readBindResult(r *bufio.Reader) (int, error) {
+	tag, msg, err := readTLV(r)
+	if err != nil {
+		return 0, err
+	}
+	if tag != 0x30 {
+		return 0, errors.New("malformed response")
+	}
+	body := bufio.NewReader(bytes.NewReader(msg))
+	if tag, _, err = readTLV(body); err != nil || tag != 0x02 {
+		return 0, errors.New("malformed message id")
+	}
+	tag, op, err := readTLV(body)
+	if err != nil || tag != 0x61 {
+		return 0, errors.New("expected a bind response")
+	}
+	tag, code, err := readTLV(bufio.NewReader(bytes.NewReader(op)))
+	if err != nil || tag != 0x0a || len(code) == 0 {
+		return 0, errors.New("malformed result code")
+	}
+	result := 0
+	for _, b := range code {
+		result = result<<8 | int(b)
+	}
+	return result, nil
+}
+
+func readTLV(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return 0, nil, errors.New("unsupported BER length")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return 0, nil, err
+	}
+	return tag, value, nil
+}
+
+// berTLV encodes a BER element whose value is the concatenation of parts.
+func berTLV(tag byte, parts ...[]byte) []byte {
+	var value []byte
+	for _, part := range parts {
+		value = append(value, part...)
+	}
+	out := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, value...)
+}
+
+func berInt(n byte) []byte {
+	return []byte{0x02, 0x01, n}
+}
+
+// escapeDN escapes a value for use in a distinguished name (RFC 4514), so a
+// username cannot add RDNs of its own.
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
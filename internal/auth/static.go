@@ -0,0 +1,103 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Static accepts a single shared password for any username, like Redis'
+// requirepass.
+type Static struct {
+	password []byte
+}
+
+func NewStatic(password string) *Static {
+	return &Static{password: []byte(password)}
+}
+
+func (s *Static) Authenticate(username, password string) (string, error) {
+	if subtle.ConstantTimeCompare([]byte(password), s.password) != 1 {
+		return "", ErrInvalidCredentials
+	}
+	return username, nil
+}
+
+// File authenticates against users listed one per line as
+//
+//	username sha256-hex-of-password
+//
+// Blank lines and lines starting with # are ignored.
+type File struct {
+	users map[string][]byte
+}
+
+func LoadFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"username sha256\"", path, line)
+		}
+		hash, err := hex.DecodeString(fields[1])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-256 password hash", path, line)
+		}
+		users[fields[0]] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &File{users: users}, nil
+}
+
+func (f *File) Authenticate(username, password string) (string, error) {
+	sum := sha256.Sum256([]byte(password))
+	hash, ok := f.users[username]
+	if !ok {
+		// Compare anyway so unknown users take as long as known ones.
+		hash = make([]byte, sha256.Size)
+	}
+	if subtle.ConstantTimeCompare(sum[:], hash) != 1 || !ok {
+		return "", ErrInvalidCredentials
+	}
+	return username, nil
+}
@@ -0,0 +1,449 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package blob stores the original documents points are made from (PDFs,
+// text, images), so that search results can lead back to them. Blobs are
+// content-addressed: a blob's ID is the SHA-256 of its content, and it is
+// split into chunks stored once each under their own hash, so the same
+// document, or the same pages of two revisions, take space once. Chunks of
+// large blobs may be offloaded to an object store.
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/durability"
+)
+
+// Blobs live in the reserved keyspace, next to the metadata of the storage
+// package and the collections:
+//
+//	\x00b:m<id>      manifest of a blob (JSON, see manifest)
+//	\x00b:r<hash>    reference count of a chunk (uint64), then its location
+//	                 (1 byte: local or remote)
+//	\x00b:d<hash>    content of a local chunk
+//
+// <id> and <hash> are raw SHA-256 digests. A chunk is referenced once by
+// every blob it appears in, however many times it repeats there, and is
+// deleted with its last reference. Remote chunks are stored in the object
+// store under "chunks/<hash in hex>".
+const (
+	manifestPrefix = "\x00b:m"
+	refPrefix      = "\x00b:r"
+	dataPrefix     = "\x00b:d"
+
+	locationLocal  byte = 'l'
+	locationRemote byte = 'r'
+)
+
+// DefaultChunkSize is the size blobs are split into unless configured
+// otherwise.
+const DefaultChunkSize = 1 << 20
+
+var (
+	ErrNotFound  = errors.New("no such blob")
+	ErrInvalidID = errors.New("blob IDs are 64 hexadecimal digits")
+	// ErrCorrupt is returned when a chunk is missing or does not match its
+	// hash.
+	ErrCorrupt = errors.New("blob chunk missing or corrupt")
+)
+
+// Config tunes a Store.
+type Config struct {
+	// ChunkSize is the size blobs are split into, DefaultChunkSize if
+	// zero.
+	ChunkSize int
+	// Remote, if set, is where the chunks of blobs of OffloadThreshold
+	// bytes or more are stored.
+	Remote           ObjectStore
+	OffloadThreshold int64
+}
+
+// Store keeps blobs in Pebble, and in Remote if configured. Writes are
+// serialized, since they update the reference counts of shared chunks.
+type Store struct {
+	db        *pebble.DB
+	committer *durability.Committer
+	config    Config
+
+	mutex sync.Mutex
+}
+
+// Info describes a blob.
+type Info struct {
+	ID          string
+	Size        int64
+	ContentType string
+	// Chunks is how many chunks the blob is made of, and Offloaded how many
+	// of them are stored remotely.
+	Chunks    int
+	Offloaded int
+	// Refs is how many times the blob was put and not deleted since.
+	Refs    int64
+	Created time.Time
+}
+
+type manifest struct {
+	Size        int64    `json:"size"`
+	ContentType string   `json:"content_type,omitempty"`
+	Chunks      []string `json:"chunks"`
+	Refs        int64    `json:"refs"`
+	Created     int64    `json:"created"`
+}
+
+func New(db *pebble.DB, committer *durability.Committer, config Config) *Store {
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = DefaultChunkSize
+	}
+	return &Store{db: db, committer: committer, config: config}
+}
+
+// ParseID decodes the hexadecimal ID of a blob.
+func ParseID(id string) ([]byte, error) {
+	digest, err := hex.DecodeString(id)
+	if err != nil || len(digest) != sha256.Size {
+		return nil, ErrInvalidID
+	}
+	return digest, nil
+}
+
+func manifestKey(id []byte) []byte {
+	return append([]byte(manifestPrefix), id...)
+}
+
+func refKey(hash []byte) []byte {
+	return append([]byte(refPrefix), hash...)
+}
+
+func dataKey(hash []byte) []byte {
+	return append([]byte(dataPrefix), hash...)
+}
+
+func remoteName(hash []byte) string {
+	return "chunks/" + hex.EncodeToString(hash)
+}
+
+// Put stores data, or adds a reference to it if it is already stored, and
+// returns its ID.
+func (s *Store) Put(ctx context.Context, data []byte, contentType string, mode durability.Mode) (string, error) {
+	sum := sha256.Sum256(data)
+	id := sum[:]
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, exists, err := s.loadManifest(s.db, id)
+	if err != nil {
+		return "", err
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if exists {
+		m.Refs++
+		if contentType != "" {
+			m.ContentType = contentType
+		}
+		if err := setManifest(batch, id, m); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(id), s.committer.Commit(batch, mode)
+	}
+
+	m = manifest{Size: int64(len(data)), ContentType: contentType, Refs: 1, Created: time.Now().UnixMilli()}
+	offload := s.config.Remote != nil && int64(len(data)) >= s.config.OffloadThreshold
+	// A chunk repeated within the blob is referenced once. An empty blob
+	// is a single empty chunk.
+	seen := make(map[[sha256.Size]byte]bool)
+	for start := 0; ; start += s.config.ChunkSize {
+		end := min(start+s.config.ChunkSize, len(data))
+		chunk := data[start:end]
+		hash := sha256.Sum256(chunk)
+		m.Chunks = append(m.Chunks, hex.EncodeToString(hash[:]))
+		if !seen[hash] {
+			seen[hash] = true
+			if err := s.addRef(ctx, batch, hash[:], chunk, offload); err != nil {
+				return "", err
+			}
+		}
+		if end == len(data) {
+			break
+		}
+	}
+	if err := setManifest(batch, id, m); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), s.committer.Commit(batch, mode)
+}
+
+// addRef adds a reference to the chunk with the given hash, storing chunk
+// first if it is not stored yet: remotely if offload is set, so that the
+// chunk exists before anything refers to it.
+func (s *Store) addRef(ctx context.Context, batch *pebble.Batch, hash, chunk []byte, offload bool) error {
+	refs, location, err := loadRef(s.db, hash)
+	if err != nil {
+		return err
+	}
+	if refs == 0 {
+		location = locationLocal
+		if offload {
+			if err := s.config.Remote.Put(ctx, remoteName(hash), chunk); err != nil {
+				return fmt.Errorf("offloading chunk: %w", err)
+			}
+			location = locationRemote
+		} else {
+			batch.Set(dataKey(hash), chunk, nil)
+		}
+	}
+	setRef(batch, hash, refs+1, location)
+	return nil
+}
+
+// Get returns the content of the blob with the given ID.
+func (s *Store) Get(ctx context.Context, id string) ([]byte, Info, error) {
+	digest, err := ParseID(id)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	// Reading from a snapshot keeps a concurrent Delete from removing
+	// local chunks half way through.
+	snap := s.db.NewSnapshot()
+	defer snap.Close()
+	m, exists, err := s.loadManifest(snap, digest)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if !exists {
+		return nil, Info{}, ErrNotFound
+	}
+	data := make([]byte, 0, m.Size)
+	for _, chunk := range m.Chunks {
+		hash, err := hex.DecodeString(chunk)
+		if err != nil {
+			return nil, Info{}, ErrCorrupt
+		}
+		content, err := s.readChunk(ctx, snap, hash)
+		if err != nil {
+			return nil, Info{}, err
+		}
+		data = append(data, content...)
+	}
+	if sum := sha256.Sum256(data); !bytes.Equal(sum[:], digest) {
+		return nil, Info{}, ErrCorrupt
+	}
+	info, err := s.info(snap, id, m)
+	return data, info, err
+}
+
+func (s *Store) readChunk(ctx context.Context, snap *pebble.Snapshot, hash []byte) ([]byte, error) {
+	refs, location, err := loadRef(snap, hash)
+	if err != nil {
+		return nil, err
+	}
+	if refs == 0 {
+		return nil, ErrCorrupt
+	}
+	if location == locationRemote {
+		if s.config.Remote == nil {
+			return nil, errors.New("chunk is offloaded but no object store is configured")
+		}
+		data, err := s.config.Remote.Get(ctx, remoteName(hash))
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, ErrCorrupt
+		}
+		return data, err
+	}
+	value, closer, err := snap.Get(dataKey(hash))
+	if err == pebble.ErrNotFound {
+		return nil, ErrCorrupt
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return append([]byte{}, value...), nil
+}
+
+// Stat describes the blob with the given ID.
+func (s *Store) Stat(id string) (Info, error) {
+	digest, err := ParseID(id)
+	if err != nil {
+		return Info{}, err
+	}
+	snap := s.db.NewSnapshot()
+	defer snap.Close()
+	m, exists, err := s.loadManifest(snap, digest)
+	if err != nil {
+		return Info{}, err
+	}
+	if !exists {
+		return Info{}, ErrNotFound
+	}
+	return s.info(snap, id, m)
+}
+
+func (s *Store) info(r pebble.Reader, id string, m manifest) (Info, error) {
+	info := Info{
+		ID:          id,
+		Size:        m.Size,
+		ContentType: m.ContentType,
+		Chunks:      len(m.Chunks),
+		Refs:        m.Refs,
+		Created:     time.UnixMilli(m.Created),
+	}
+	for _, chunk := range m.Chunks {
+		hash, err := hex.DecodeString(chunk)
+		if err != nil {
+			return Info{}, ErrCorrupt
+		}
+		_, location, err := loadRef(r, hash)
+		if err != nil {
+			return Info{}, err
+		}
+		if location == locationRemote {
+			info.Offloaded++
+		}
+	}
+	return info, nil
+}
+
+// Delete drops a reference to the blob with the given ID, reporting
+// whether it existed. The blob is deleted with its last reference, and so
+// are the chunks no other blob refers to. Remote chunks are deleted after
+// the blob is gone locally, so a failure leaves unreferenced objects
+// behind rather than a blob with missing chunks.
+func (s *Store) Delete(ctx context.Context, id string, mode durability.Mode) (bool, error) {
+	digest, err := ParseID(id)
+	if err != nil {
+		return false, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, exists, err := s.loadManifest(s.db, digest)
+	if err != nil || !exists {
+		return false, err
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if m.Refs > 1 {
+		m.Refs--
+		if err := setManifest(batch, digest, m); err != nil {
+			return false, err
+		}
+		return true, s.committer.Commit(batch, mode)
+	}
+
+	batch.Delete(manifestKey(digest), nil)
+	var remote [][]byte
+	seen := make(map[string]bool)
+	for _, chunk := range m.Chunks {
+		if seen[chunk] {
+			continue
+		}
+		seen[chunk] = true
+		hash, err := hex.DecodeString(chunk)
+		if err != nil {
+			return false, ErrCorrupt
+		}
+		refs, location, err := loadRef(s.db, hash)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case refs > 1:
+			setRef(batch, hash, refs-1, location)
+		case location == locationRemote:
+			batch.Delete(refKey(hash), nil)
+			remote = append(remote, hash)
+		default:
+			batch.Delete(refKey(hash), nil)
+			batch.Delete(dataKey(hash), nil)
+		}
+	}
+	if err := s.committer.Commit(batch, mode); err != nil {
+		return false, err
+	}
+	if len(remote) > 0 && s.config.Remote != nil {
+		for _, hash := range remote {
+			if err := s.config.Remote.Delete(ctx, remoteName(hash)); err != nil {
+				return true, fmt.Errorf("deleting offloaded chunk: %w", err)
+			}
+		}
+	}
+	return true, nil
+}
+
+func (s *Store) loadManifest(r pebble.Reader, id []byte) (manifest, bool, error) {
+	value, closer, err := r.Get(manifestKey(id))
+	if err == pebble.ErrNotFound {
+		return manifest{}, false, nil
+	}
+	if err != nil {
+		return manifest{}, false, err
+	}
+	defer closer.Close()
+	var m manifest
+	if err := json.Unmarshal(value, &m); err != nil {
+		return manifest{}, false, fmt.Errorf("malformed blob manifest: %w", err)
+	}
+	return m, true, nil
+}
+
+func setManifest(batch *pebble.Batch, id []byte, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return batch.Set(manifestKey(id), data, nil)
+}
+
+// loadRef returns the reference count and location of a chunk, zero if it
+// is not stored.
+func loadRef(r pebble.Reader, hash []byte) (uint64, byte, error) {
+	value, closer, err := r.Get(refKey(hash))
+	if err == pebble.ErrNotFound {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer closer.Close()
+	if len(value) != 9 {
+		return 0, 0, ErrCorrupt
+	}
+	return binary.BigEndian.Uint64(value), value[8], nil
+}
+
+func setRef(batch *pebble.Batch, hash []byte, refs uint64, location byte) {
+	value := binary.BigEndian.AppendUint64(nil, refs)
+	batch.Set(refKey(hash), append(value, location), nil)
+}
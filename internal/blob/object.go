@@ -0,0 +1,192 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrObjectNotFound is returned by ObjectStore.Get for a missing object.
+var ErrObjectNotFound = errors.New("no such object")
+
+// ObjectStore is where chunks are offloaded to. Objects are written once
+// under names derived from their content, so a Put may safely be repeated,
+// and several servers, such as a master and its replicas, may share one.
+type ObjectStore interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	// Delete removes the named object. Deleting a missing object is not
+	// an error.
+	Delete(ctx context.Context, name string) error
+}
+
+// OpenObjectStore returns the object store at rawURL: a directory for
+// file:// URLs, and for http:// and https:// URLs a server storing objects
+// with PUT, GET and DELETE under the URL, such as a bucket endpoint or a
+// WebDAV share. token, if set, is sent to it as a bearer token.
+func OpenObjectStore(rawURL, token string) (ObjectStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("object store URL %q has no path", rawURL)
+		}
+		return &Dir{Root: u.Path}, nil
+	case "http", "https":
+		return &HTTP{
+			URL:    strings.TrimSuffix(rawURL, "/"),
+			Token:  token,
+			Client: &http.Client{Timeout: time.Minute},
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported object store URL %q: use file://, http:// or https://", rawURL)
+}
+
+// Dir stores objects as files under Root.
+type Dir struct {
+	Root string
+}
+
+func (d *Dir) path(name string) string {
+	return filepath.Join(d.Root, filepath.FromSlash(name))
+}
+
+// Put writes the object to a temporary file first, so that a crash never
+// leaves a partial object under its final name.
+func (d *Dir) Put(_ context.Context, name string, data []byte) error {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (d *Dir) Get(_ context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return data, err
+}
+
+func (d *Dir) Delete(_ context.Context, name string) error {
+	err := os.Remove(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// HTTP stores objects on a server under URL/<name>.
+type HTTP struct {
+	URL string
+	// Token, if set, is sent as a bearer token.
+	Token  string
+	Client *http.Client
+}
+
+func (h *HTTP) do(ctx context.Context, method, name string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.URL+"/"+name, reader)
+	if err != nil {
+		return nil, err
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	return h.Client.Do(req)
+}
+
+func (h *HTTP) Put(ctx context.Context, name string, data []byte) error {
+	res, err := h.do(ctx, http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("object store replied %s to PUT %s", res.Status, name)
+	}
+	return nil
+}
+
+func (h *HTTP) Get(ctx context.Context, name string) ([]byte, error) {
+	res, err := h.do(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrObjectNotFound
+	case res.StatusCode/100 != 2:
+		return nil, fmt.Errorf("object store replied %s to GET %s", res.Status, name)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func (h *HTTP) Delete(ctx context.Context, name string) error {
+	res, err := h.do(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode/100 != 2 && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("object store replied %s to DELETE %s", res.Status, name)
+	}
+	return nil
+}
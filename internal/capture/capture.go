@@ -0,0 +1,129 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package capture reads and writes captures of the commands a server
+// received, which vecble-replay replays against another instance.
+//
+// A capture is a sequence of RESP arrays of bulk strings, one per command:
+// the time the command was received, in nanoseconds since the Unix epoch,
+// the ID of the connection it came on, then the command and its arguments.
+// RESP keeps binary arguments intact, and a capture cut short by a crash
+// is only missing its last record.
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"readpebble/internal/resp"
+)
+
+// Record is a captured command.
+type Record struct {
+	Time time.Time
+	// Conn is the ID of the connection, whose commands are replayed in
+	// order on a connection of their own.
+	Conn int64
+	// Args are the command name and its arguments.
+	Args []string
+}
+
+// Writer appends records to a capture file.
+type Writer struct {
+	file *os.File
+	w    *bufio.Writer
+	enc  resp.Writer
+}
+
+// Create creates the capture file path, truncating it if it exists.
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// Write buffers r, which Flush writes out.
+func (w *Writer) Write(r Record) error {
+	w.enc.Reset()
+	w.enc.Array(2 + len(r.Args))
+	w.enc.BulkString(strconv.FormatInt(r.Time.UnixNano(), 10))
+	w.enc.BulkString(strconv.FormatInt(r.Conn, 10))
+	for _, arg := range r.Args {
+		w.enc.BulkString(arg)
+	}
+	_, err := w.w.Write(w.enc.Bytes())
+	return err
+}
+
+// Flush writes the buffered records to the file.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Close flushes the buffered records and closes the file.
+func (w *Writer) Close() error {
+	err := w.w.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Reader reads the records of a capture.
+type Reader struct {
+	r *resp.Reader
+}
+
+// NewReader returns a Reader reading a capture from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: resp.NewReader(r)}
+}
+
+// Next returns the next record, or io.EOF after the last one. A record
+// cut short at the end of the capture also ends it with io.EOF.
+func (r *Reader) Next() (Record, error) {
+	fields, err := r.r.ReadCommand()
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		return Record{}, io.EOF
+	case err != nil:
+		return Record{}, err
+	case len(fields) < 3:
+		return Record{}, fmt.Errorf("capture record has %d fields, want at least 3", len(fields))
+	}
+	nanos, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid capture record time %q", fields[0])
+	}
+	conn, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid capture record connection %q", fields[1])
+	}
+	return Record{Time: time.Unix(0, nanos), Conn: conn, Args: fields[2:]}, nil
+}
@@ -0,0 +1,262 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An Analyzer turns text into the terms of a text index (see textindex.go).
+// It is described by a comma-separated list of steps, applied in this order
+// whatever order they are listed in:
+//
+//	lowercase           fold case
+//	stopwords:<lang>    drop the common words of a language
+//	stem:<lang>         reduce words to their stem
+//	edge_ngram:<m>-<n>  also index the prefixes of each term of m to n
+//	                    characters, for prefix search
+//
+// A language name on its own, e.g. english, stands for lowercase,
+// stopwords:<lang>, stem:<lang>. Text is split into words at anything that
+// is not a letter or digit. Edge n-grams are only produced when indexing:
+// at query time each word must match a whole term or, with edge_ngram, a
+// prefix of one. The languages are english, german and french, with light
+// stemmers that strip inflections rather than full Snowball stemmers.
+type Analyzer struct {
+	spec      string
+	lowercase bool
+	stopwords map[string]bool
+	stem      func(string) string
+	minGram   int
+	maxGram   int
+}
+
+// defaultAnalyzer is used for MATCH on fields without a text index.
+var defaultAnalyzer = &Analyzer{spec: "lowercase", lowercase: true}
+
+// ParseAnalyzer parses an analyzer description. An empty one is lowercase.
+func ParseAnalyzer(spec string) (*Analyzer, error) {
+	if strings.TrimSpace(spec) == "" {
+		return defaultAnalyzer, nil
+	}
+	a := &Analyzer{spec: spec}
+	for _, step := range strings.Split(spec, ",") {
+		name, arg, _ := strings.Cut(strings.ToLower(strings.TrimSpace(step)), ":")
+		switch name {
+		case "lowercase":
+			a.lowercase = true
+		case "stopwords":
+			words, ok := stopwords[arg]
+			if !ok {
+				return nil, fmt.Errorf("analyzer: unknown stopword language %q", arg)
+			}
+			a.stopwords = words
+		case "stem":
+			stem, ok := stemmers[arg]
+			if !ok {
+				return nil, fmt.Errorf("analyzer: unknown stemmer language %q", arg)
+			}
+			a.stem = stem
+		case "edge_ngram":
+			lo, hi, _ := strings.Cut(arg, "-")
+			min, err1 := strconv.Atoi(lo)
+			max, err2 := strconv.Atoi(hi)
+			if err1 != nil || err2 != nil || min < 1 || max < min {
+				return nil, fmt.Errorf("analyzer: edge_ngram expects <min>-<max>, got %q", arg)
+			}
+			a.minGram, a.maxGram = min, max
+		default:
+			if _, ok := stemmers[name]; !ok || arg != "" {
+				return nil, fmt.Errorf("analyzer: unknown step %q", step)
+			}
+			a.lowercase, a.stopwords, a.stem = true, stopwords[name], stemmers[name]
+		}
+	}
+	return a, nil
+}
+
+func (a *Analyzer) String() string {
+	return a.spec
+}
+
+// terms returns the terms of text at query time: its words, less stopwords,
+// stemmed.
+func (a *Analyzer) terms(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	terms := words[:0]
+	for _, word := range words {
+		if a.lowercase {
+			word = strings.ToLower(word)
+		}
+		if a.stopwords[strings.ToLower(word)] {
+			continue
+		}
+		if a.stem != nil {
+			word = a.stem(word)
+		}
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// indexTerms returns the distinct terms text is indexed under: its query
+// terms and their edge n-grams.
+func (a *Analyzer) indexTerms(text string) map[string]struct{} {
+	terms := make(map[string]struct{})
+	for _, term := range a.terms(text) {
+		terms[term] = struct{}{}
+		if a.maxGram == 0 {
+			continue
+		}
+		runes := []rune(term)
+		for n := a.minGram; n <= a.maxGram && n < len(runes); n++ {
+			terms[string(runes[:n])] = struct{}{}
+		}
+	}
+	return terms
+}
+
+var stemmers = map[string]func(string) string{
+	"english": stemEnglish,
+	"german":  stemGerman,
+	"french":  stemFrench,
+}
+
+// stemEnglish strips plurals, -ed, -ing and -ly, and folds a few
+// derivational suffixes, much like the first steps of the Porter stemmer.
+func stemEnglish(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "sses") || strings.HasSuffix(word, "xes") ||
+		strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		word = word[:len(word)-1]
+	}
+	for _, suffix := range []string{"ing", "ed"} {
+		stem, ok := strings.CutSuffix(word, suffix)
+		if !ok || len(stem) < 3 || !strings.ContainsAny(stem, "aeiouy") {
+			continue
+		}
+		// running -> run, but falling -> fall.
+		if n := len(stem); stem[n-1] == stem[n-2] && !strings.ContainsRune("aeiouylsz", rune(stem[n-1])) {
+			stem = stem[:n-1]
+		}
+		word = stem
+		break
+	}
+	for _, r := range [][2]string{
+		{"ational", "ate"}, {"ization", "ize"}, {"fulness", "ful"}, {"iveness", "ive"},
+		{"ousness", "ous"}, {"ment", ""}, {"ness", ""}, {"ly", ""},
+	} {
+		if stem, ok := strings.CutSuffix(word, r[0]); ok && len(stem) >= 3 {
+			return stem + r[1]
+		}
+	}
+	return word
+}
+
+// stemGerman folds umlauts and strips inflectional endings, following
+// Caumanns' light stemmer.
+func stemGerman(word string) string {
+	word = strings.NewReplacer("ä", "a", "ö", "o", "ü", "u", "ß", "ss").Replace(word)
+	n := len(word)
+	switch {
+	case n > 5 && strings.HasSuffix(word, "ern"):
+		word = word[:n-3]
+	case n > 4 && (strings.HasSuffix(word, "em") || strings.HasSuffix(word, "en") ||
+		strings.HasSuffix(word, "er") || strings.HasSuffix(word, "es")):
+		word = word[:n-2]
+	case n > 3 && strings.HasSuffix(word, "e"):
+		word = word[:n-1]
+	case n > 3 && strings.HasSuffix(word, "s") && strings.ContainsRune("bdfghklmnrt", rune(word[n-2])):
+		word = word[:n-1]
+	}
+	n = len(word)
+	switch {
+	case n > 5 && strings.HasSuffix(word, "est"):
+		word = word[:n-3]
+	case n > 4 && (strings.HasSuffix(word, "er") || strings.HasSuffix(word, "en")):
+		word = word[:n-2]
+	case n > 5 && strings.HasSuffix(word, "st") && strings.ContainsRune("bdfghklmnt", rune(word[n-3])):
+		word = word[:n-2]
+	}
+	return word
+}
+
+// stemFrench folds accents and strips plurals, feminine endings and common
+// derivational suffixes.
+func stemFrench(word string) string {
+	word = strings.NewReplacer(
+		"à", "a", "â", "a", "ç", "c", "é", "e", "è", "e", "ê", "e", "ë", "e",
+		"î", "i", "ï", "i", "ô", "o", "ù", "u", "û", "u", "ü", "u",
+	).Replace(word)
+	if len(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "aux"):
+		word = word[:len(word)-3] + "al"
+	case strings.HasSuffix(word, "s") || strings.HasSuffix(word, "x"):
+		word = word[:len(word)-1]
+	}
+	for _, suffix := range []string{"issement", "ement", "ation", "ateur", "atrice", "ment", "euse", "eur", "ite"} {
+		if stem, ok := strings.CutSuffix(word, suffix); ok && len(stem) >= 3 {
+			word = stem
+			break
+		}
+	}
+	if len(word) > 3 && strings.HasSuffix(word, "e") {
+		word = word[:len(word)-1]
+	}
+	return word
+}
+
+var stopwords = map[string]map[string]bool{
+	"english": wordSet("a an and are as at be but by for from has have he her his i if in into is it its " +
+		"no not of on or our she so such that the their them then there these they this to was we were " +
+		"which will with you your"),
+	"german": wordSet("aber alle als am an auch auf aus bei bin bis das dass dem den der des die doch du " +
+		"ein eine einem einen einer eines er es fur hat ich ihr im in ist ja kann mit nach nicht noch nur " +
+		"oder sich sie sind so uber um und uns von vor war wie wir wird zu zum zur für über"),
+	"french": wordSet("a au aux avec ce ces dans de des du elle en est et il ils je la le les leur lui ma " +
+		"mais me mes mon ne nous on ou par pas pour qu que qui sa se ses son sur ta te tes toi ton tu un " +
+		"une vos votre vous y à été être"),
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
@@ -0,0 +1,130 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Change is one step taken, or planned, to bring the collections in line
+// with a manifest (see Apply).
+type Change struct {
+	Collection string
+	// Action is "create" for a collection the manifest adds and "index"
+	// for fields it indexes on an existing one.
+	Action string
+	// Fields are the fields an "index" change indexes.
+	Fields []string
+}
+
+func (c Change) String() string {
+	if c.Action == "index" {
+		return fmt.Sprintf("index %s %s", c.Collection, strings.Join(c.Fields, ","))
+	}
+	return c.Action + " " + c.Collection
+}
+
+// Apply reconciles the collections with infos, the collections declared
+// by a manifest: missing ones are created and fields the manifest indexes
+// that an existing collection does not are indexed from its points. The
+// collections a manifest does not mention are left alone, as are indexes
+// it no longer lists, which filters may still rely on.
+//
+// The dimension, metric, tenant and index of a collection are fixed once
+// it exists. If the manifest declares others, Apply fails before changing
+// anything, as it does for invalid settings. With dryRun, the changes are
+// returned without being made.
+func (m *Manager) Apply(infos []Info, dryRun bool) ([]Change, error) {
+	var changes []Change
+	for i := range infos {
+		info := &infos[i]
+		if err := info.check(); err != nil {
+			return nil, fmt.Errorf("collection %q: %w", info.Name, err)
+		}
+		if slices.ContainsFunc(infos[:i], func(other Info) bool { return other.Name == info.Name }) {
+			return nil, fmt.Errorf("collection %q is declared twice", info.Name)
+		}
+		c, err := m.Get(info.Name)
+		if err == ErrNotFound {
+			changes = append(changes, Change{Collection: info.Name, Action: "create"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.mutex.RLock()
+		actual := c.Info
+		c.mutex.RUnlock()
+		if err := fixedSettingsMatch(actual, *info); err != nil {
+			return nil, fmt.Errorf("collection %q: %w", info.Name, err)
+		}
+		if added := mergeFields(slices.Clip(actual.Fields), info.Fields)[len(actual.Fields):]; len(added) > 0 {
+			changes = append(changes, Change{Collection: info.Name, Action: "index", Fields: added})
+		}
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	for i, change := range changes {
+		var err error
+		if change.Action == "create" {
+			info := infos[slices.IndexFunc(infos, func(info Info) bool { return info.Name == change.Collection })]
+			err = m.Create(info)
+		} else {
+			err = m.IndexFields(change.Collection, change.Fields)
+		}
+		if err != nil {
+			return changes[:i], fmt.Errorf("%s: %w", change, err)
+		}
+	}
+	return changes, nil
+}
+
+// fixedSettingsMatch returns an error naming the first setting of a
+// collection that cannot change once it exists and differs between actual
+// and declared.
+func fixedSettingsMatch(actual, declared Info) error {
+	actual.Index.setDefaults()
+	switch {
+	case actual.Dimension != declared.Dimension:
+		return fmt.Errorf("dimension is %d, not %d", actual.Dimension, declared.Dimension)
+	case actual.Metric != declared.Metric:
+		return fmt.Errorf("metric is %s, not %s", actual.Metric, declared.Metric)
+	case actual.Tenant != declared.Tenant:
+		return fmt.Errorf("tenant is %q, not %q", actual.Tenant, declared.Tenant)
+	case actual.Index != declared.Index:
+		return fmt.Errorf("index is %s, not %s", formatIndex(actual.Index), formatIndex(declared.Index))
+	}
+	return nil
+}
+
+func formatIndex(p IndexParams) string {
+	if p.Type != IndexHNSW {
+		return string(p.Type)
+	}
+	return fmt.Sprintf("hnsw (M %d, EF_CONSTRUCTION %d, EF_SEARCH %d)", p.M, p.EFConstruction, p.EFSearch)
+}
@@ -0,0 +1,75 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"fmt"
+)
+
+// VectorOp is an element-wise operation over the vectors of stored points.
+type VectorOp int
+
+const (
+	// OpSum adds the vectors.
+	OpSum VectorOp = iota
+	// OpAverage averages the vectors, giving their centroid.
+	OpAverage
+	// OpDifference subtracts every other vector from the first.
+	OpDifference
+)
+
+// Combine applies op to the vectors of the points with the given keys,
+// which are read together so that concurrent writes cannot leave the result
+// mixing old and new vectors.
+func (m *Manager) Combine(collection string, op VectorOp, keys []string) ([]float64, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no points to combine")
+	}
+	result := make([]float64, c.Dimension)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for i, key := range keys {
+		id, ok := c.ids[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrPointNotFound, key)
+		}
+		sign := 1.0
+		if op == OpDifference && i > 0 {
+			sign = -1
+		}
+		for j, x := range c.points[id].vector {
+			result[j] += sign * x
+		}
+	}
+	if op == OpAverage {
+		for j := range result {
+			result[j] /= float64(len(keys))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,137 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"readpebble/internal/durability"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+func randomVectors(n, dim int) [][]float64 {
+	r := rand.New(rand.NewSource(1))
+	vectors := make([][]float64, n)
+	for i := range vectors {
+		vectors[i] = make([]float64, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = r.Float64()
+		}
+	}
+	return vectors
+}
+
+// newBenchCollection returns a collection of n random points kept in an
+// in-memory Pebble store.
+func newBenchCollection(b *testing.B, index IndexType, n, dim int) (*Manager, *Collection) {
+	b.Helper()
+	db, err := pebble.Open("", &pebble.Options{FS: vfs.NewMem()})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	m := NewManager(db, durability.NewCommitter(db, time.Millisecond))
+	if err := m.Load(); err != nil {
+		b.Fatal(err)
+	}
+	info := Info{Name: "bench", Dimension: dim, Metric: MetricL2, Index: IndexParams{Type: index}}
+	if err := m.Create(info); err != nil {
+		b.Fatal(err)
+	}
+	for i, vector := range randomVectors(n, dim) {
+		if err := m.Upsert("bench", "p"+strconv.Itoa(i), vector, nil, durability.None); err != nil {
+			b.Fatal(err)
+		}
+	}
+	c, err := m.Get("bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return m, c
+}
+
+func BenchmarkDistance(b *testing.B) {
+	for _, metric := range []Metric{MetricL2, MetricIP, MetricCosine} {
+		distance := distanceFunc(metric)
+		for _, dim := range []int{128, 768} {
+			vectors := randomVectors(2, dim)
+			b.Run(fmt.Sprintf("%s-%d", metric, dim), func(b *testing.B) {
+				b.SetBytes(int64(2 * 8 * dim))
+				var sum float64
+				for i := 0; i < b.N; i++ {
+					sum += distance(vectors[0], vectors[1])
+				}
+				_ = sum
+			})
+		}
+	}
+}
+
+func BenchmarkCheckpoint(b *testing.B) {
+	for _, index := range []IndexType{IndexFlat, IndexHNSW} {
+		_, c := newBenchCollection(b, index, 2000, 64)
+		c.mutex.Lock()
+		data := c.encodeCheckpoint(1, c.index)
+		c.mutex.Unlock()
+		b.Run("encode-"+string(index), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.mutex.Lock()
+				c.encodeCheckpoint(1, c.index)
+				c.mutex.Unlock()
+			}
+		})
+		b.Run("decode-"+string(index), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := newCollection(c.Info).decodeCheckpoint(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	query := randomVectors(1, 128)[0]
+	for _, index := range []IndexType{IndexFlat, IndexHNSW} {
+		m, _ := newBenchCollection(b, index, 2000, 128)
+		b.Run(string(index), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := m.Search("bench", query, SearchOptions{K: 10}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// The HNSW graphs can be held to a memory budget, so that a server with
+// many collections does not run out of memory. When the graphs take more
+// than the budget, those of the collections searched least recently are
+// demoted: checkpointed and dropped from memory. A demoted collection keeps
+// its points, and writes to it go on as before, its op log recording how
+// the graph must change. The next search reloads the graph from the
+// checkpoint and replays the op log onto it.
+
+// SetIndexBudget caps the memory of the HNSW graphs, as enforced by
+// EnforceIndexBudget, or removes the cap if bytes is 0.
+func (m *Manager) SetIndexBudget(bytes int64) {
+	m.indexBudget.Store(bytes)
+}
+
+// IndexBudget returns the cap on the memory of the HNSW graphs, 0 if none.
+func (m *Manager) IndexBudget() int64 {
+	return m.indexBudget.Load()
+}
+
+// IndexUsage is the memory taken by the graph of a collection.
+type IndexUsage struct {
+	Name string
+	// Bytes is an estimate, 0 while the graph is demoted.
+	Bytes        int64
+	Demoted      bool
+	LastSearched time.Time
+}
+
+// IndexUsage returns the memory the graph of every hnsw collection takes,
+// sorted by name.
+func (m *Manager) IndexUsage() []IndexUsage {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var usages []IndexUsage
+	for _, c := range m.collections {
+		if c.Index.Type != IndexHNSW {
+			continue
+		}
+		u := IndexUsage{Name: c.Name}
+		if searched := c.searched.Load(); searched > 0 {
+			u.LastSearched = time.UnixMilli(searched)
+		}
+		c.mutex.RLock()
+		if c.index != nil {
+			u.Bytes = c.index.memory()
+		}
+		u.Demoted = c.demoted
+		c.mutex.RUnlock()
+		usages = append(usages, u)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Name < usages[j].Name })
+	return usages
+}
+
+// IndexDemotions returns how many graphs were demoted since startup.
+func (m *Manager) IndexDemotions() int64 {
+	return m.demotions.Load()
+}
+
+// IndexReloads returns how many demoted graphs searches reloaded since
+// startup.
+func (m *Manager) IndexReloads() int64 {
+	return m.reloads.Load()
+}
+
+// EnforceIndexBudget demotes the graphs of the collections searched least
+// recently until the others fit in the budget. The collection searched
+// last keeps its graph, so that a budget too small for it alone does not
+// make each of its searches reload it.
+func (m *Manager) EnforceIndexBudget() error {
+	budget := m.indexBudget.Load()
+	if budget <= 0 {
+		return nil
+	}
+	usages := m.IndexUsage()
+	var total int64
+	for _, u := range usages {
+		total += u.Bytes
+	}
+	if total <= budget {
+		return nil
+	}
+	sort.SliceStable(usages, func(i, j int) bool { return usages[i].LastSearched.Before(usages[j].LastSearched) })
+	for _, u := range usages[:len(usages)-1] {
+		if total <= budget {
+			break
+		}
+		if u.Demoted {
+			continue
+		}
+		c, err := m.Get(u.Name)
+		if err != nil {
+			continue
+		}
+		if err := m.demote(c); err != nil {
+			return fmt.Errorf("collection %q: %w", c.Name, err)
+		}
+		total -= u.Bytes
+	}
+	return nil
+}
+
+// demote checkpoints the graph of c and drops it from memory.
+func (m *Manager) demote(c *Collection) error {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	if _, err := m.Get(c.Name); err != nil || c.index == nil {
+		// Dropped or demoted since EnforceIndexBudget listed it.
+		return nil
+	}
+	// Even without writes since the last checkpoint, there may be none yet
+	// holding the graph.
+	if err := m.writeCheckpoint(c); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.index, c.demoted = nil, true
+	c.mutex.Unlock()
+	m.demotions.Add(1)
+	return nil
+}
+
+// promote reloads the graph of c if it was demoted.
+func (m *Manager) promote(c *Collection) error {
+	if !c.isDemoted() {
+		return nil
+	}
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	if !c.demoted {
+		return nil
+	}
+	index, err := m.reloadIndex(c)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.index, c.demoted = index, false
+	c.mutex.Unlock()
+	m.reloads.Add(1)
+	return nil
+}
+
+func (c *Collection) isDemoted() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.demoted
+}
+
+// reloadIndex returns the graph of a demoted collection, read from its
+// checkpoint and brought up to date with the op log entries after it, or
+// rebuilt from the points if the checkpoint cannot be read. The manager's
+// writeMutex must be held.
+func (m *Manager) reloadIndex(c *Collection) (*hnsw, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	index := newHNSW(c.Index, c.Metric, func(id uint64) []float64 { return c.points[id].vector })
+	seq, ok, err := m.decodeCheckpointGraph(c, index)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		index = newHNSW(c.Index, c.Metric, index.vector)
+		for id := range c.points {
+			index.insert(id)
+		}
+		return index, nil
+	}
+
+	iter, err := m.db.NewIter(&pebble.IterOptions{
+		LowerBound: opLogKey(c.ID, seq+1),
+		UpperBound: prefixEnd(append(collectionSpace(c.ID), tagOpLog)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var touched []uint64
+	seen := make(map[uint64]bool)
+	for iter.First(); iter.Valid(); iter.Next() {
+		if len(iter.Value()) != 9 {
+			return nil, fmt.Errorf("op log entry %q: %w", iter.Key(), errMalformedKey)
+		}
+		if id := binary.BigEndian.Uint64(iter.Value()[1:]); !seen[id] {
+			seen[id] = true
+			touched = append(touched, id)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	// The entries only name the points; what counts is what they hold now.
+	// The points deleted since must all be out of the graph before any is
+	// inserted, as inserting reads the vectors of the nodes it visits.
+	for _, id := range touched {
+		index.remove(id)
+	}
+	for _, id := range touched {
+		if c.points[id] != nil {
+			index.insert(id)
+		}
+	}
+	return index, nil
+}
+
+// decodeCheckpointGraph loads the graph of the checkpoint of c into index
+// and returns the op log sequence it covers. ok is false if there is no
+// checkpoint or it holds no usable graph.
+func (m *Manager) decodeCheckpointGraph(c *Collection, index *hnsw) (seq uint64, ok bool, err error) {
+	value, found, err := m.get(checkpointKey(c.ID))
+	if err != nil || !found {
+		return 0, false, err
+	}
+	seq, data, err := decodeCheckpointPoints(value, c.Dimension, nil)
+	if err != nil || len(data) == 0 || data[0] != 1 {
+		return 0, false, nil
+	}
+	if rest, err := index.decode(data[1:]); err != nil || len(rest) != 0 {
+		return 0, false, nil
+	}
+	return seq, true, nil
+}
@@ -0,0 +1,267 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Every indexed field keeps a HyperLogLog sketch of the values written to
+// it, so its number of distinct values can be estimated without scanning
+// its index. Sketches are updated in memory on every write and saved with
+// the index checkpoint (see oplog.go):
+//
+//	\x00p <coll> h   the sequence the sketches are as of, then for each
+//	                 field its name and registers
+//
+// At startup the op log is replayed into them like into the points. A
+// sketch cannot forget values, so values of deleted or rewritten points are
+// still counted; a field without a saved sketch gets one built from its
+// index, which holds only the current values.
+const (
+	// sketchPrecision is the number of index bits of a sketch, for
+	// 2^sketchPrecision registers and a standard error of about 1.6%.
+	sketchPrecision = 12
+	sketchRegisters = 1 << sketchPrecision
+)
+
+var errBadSketches = errors.New("malformed cardinality sketches")
+
+// sketch is a HyperLogLog sketch.
+type sketch [sketchRegisters]uint8
+
+// add adds a value, given its index encoding.
+func (s *sketch) add(value []byte) {
+	h := hashValue(value)
+	i := h >> (64 - sketchPrecision)
+	rank := uint8(bits.LeadingZeros64(h<<sketchPrecision|1<<(sketchPrecision-1)) + 1)
+	s[i] = max(s[i], rank)
+}
+
+// estimate returns the estimated number of distinct values added, with the
+// small range correction of the original HyperLogLog paper.
+func (s *sketch) estimate() int64 {
+	const m = float64(sketchRegisters)
+	sum, zeros := 0.0, 0
+	for _, r := range s {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(e))
+}
+
+// hashValue is 64-bit FNV-1a followed by the MurmurHash3 finalizer, which
+// FNV needs for its high bits to be usable as register indexes.
+func hashValue(b []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// fieldSketches are the sketches of the indexed fields of a collection.
+type fieldSketches struct {
+	mutex    sync.Mutex
+	sketches map[string]*sketch
+}
+
+// addPayload adds the values of fields in payload to their sketches.
+func (c *Collection) addPayload(fields []string, payload []byte) {
+	if len(fields) == 0 || payload == nil {
+		return
+	}
+	var doc interface{}
+	if json.Unmarshal(payload, &doc) != nil {
+		return
+	}
+	c.sketches.mutex.Lock()
+	defer c.sketches.mutex.Unlock()
+	for _, field := range fields {
+		s := c.sketches.sketches[field]
+		if s == nil {
+			s = new(sketch)
+			if c.sketches.sketches == nil {
+				c.sketches.sketches = make(map[string]*sketch)
+			}
+			c.sketches.sketches[field] = s
+		}
+		anyValue(lookupField(doc, field), func(v interface{}) bool {
+			if key, ok := appendIndexValue(nil, v); ok {
+				s.add(key)
+			}
+			return false
+		})
+	}
+}
+
+// Cardinality returns the estimated number of distinct values of each of
+// fields, which must be indexed, or of every indexed field if fields is
+// empty. It may overestimate after points are deleted or rewritten.
+func (m *Manager) Cardinality(collection string, fields ...string) (map[string]int64, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		fields = c.indexedFields()
+	}
+	for _, field := range fields {
+		if !c.indexed(field) {
+			return nil, fmt.Errorf("field %q is not indexed", field)
+		}
+	}
+	c.sketches.mutex.Lock()
+	defer c.sketches.mutex.Unlock()
+	counts := make(map[string]int64, len(fields))
+	for _, field := range fields {
+		if s := c.sketches.sketches[field]; s != nil {
+			counts[field] = s.estimate()
+		} else {
+			counts[field] = 0
+		}
+	}
+	return counts, nil
+}
+
+// encodeSketches serializes the sketches of c as of op log sequence seq.
+func (c *Collection) encodeSketches(seq uint64) []byte {
+	c.sketches.mutex.Lock()
+	defer c.sketches.mutex.Unlock()
+	data := binary.BigEndian.AppendUint64(nil, seq)
+	data = binary.AppendUvarint(data, uint64(len(c.sketches.sketches)))
+	for field, s := range c.sketches.sketches {
+		data = binary.AppendUvarint(data, uint64(len(field)))
+		data = append(data, field...)
+		data = append(data, s[:]...)
+	}
+	return data
+}
+
+// decodeSketches restores the sketches of c if they are as of op log
+// sequence seq.
+func (c *Collection) decodeSketches(data []byte, seq uint64) error {
+	if len(data) < 8 {
+		return errBadSketches
+	}
+	if binary.BigEndian.Uint64(data) != seq {
+		return nil
+	}
+	data = data[8:]
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errBadSketches
+	}
+	data = data[n:]
+	sketches := make(map[string]*sketch, count)
+	for i := uint64(0); i < count; i++ {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < length+sketchRegisters {
+			return errBadSketches
+		}
+		field := string(data[n : n+int(length)])
+		data = data[n+int(length):]
+		s := new(sketch)
+		copy(s[:], data)
+		data = data[sketchRegisters:]
+		sketches[field] = s
+	}
+	if len(data) != 0 {
+		return errBadSketches
+	}
+	c.sketches.mutex.Lock()
+	c.sketches.sketches = sketches
+	c.sketches.mutex.Unlock()
+	return nil
+}
+
+// loadSketches restores the saved sketches of c, as of its checkpoint.
+func (m *Manager) loadSketches(c *Collection) error {
+	value, found, err := m.get(sketchesKey(c.ID))
+	if err != nil || !found {
+		return err
+	}
+	if err := c.decodeSketches(value, c.checkpointed.Load()); err != nil {
+		// Only costs building them from the indexes.
+		c.sketches.mutex.Lock()
+		c.sketches.sketches = nil
+		c.sketches.mutex.Unlock()
+	}
+	return nil
+}
+
+// buildSketches builds a sketch from the index of every indexed field of c
+// that lacks one.
+func (m *Manager) buildSketches(c *Collection) error {
+	for _, field := range c.Fields {
+		c.sketches.mutex.Lock()
+		_, ok := c.sketches.sketches[field]
+		c.sketches.mutex.Unlock()
+		if ok {
+			continue
+		}
+		s := new(sketch)
+		prefix := fieldIndexPrefix(c.ID, field)
+		iter, err := m.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+		if err != nil {
+			return err
+		}
+		for iter.First(); iter.Valid(); iter.Next() {
+			key := iter.Key()
+			if len(key) >= len(prefix)+9 && key[len(prefix)] != valuePresent {
+				s.add(key[len(prefix) : len(key)-8])
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+		c.sketches.mutex.Lock()
+		if c.sketches.sketches == nil {
+			c.sketches.sketches = make(map[string]*sketch)
+		}
+		c.sketches.sketches[field] = s
+		c.sketches.mutex.Unlock()
+	}
+	return nil
+}
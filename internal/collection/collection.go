@@ -0,0 +1,789 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package collection stores named collections of vectors with optional JSON
+// payloads in Pebble, and keeps every collection in memory for search.
+package collection
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"readpebble/internal/durability"
+	"readpebble/internal/storage"
+
+	"github.com/cockroachdb/pebble"
+)
+
+var (
+	ErrNotFound      = errors.New("no such collection")
+	ErrExists        = errors.New("collection already exists")
+	ErrPointNotFound = errors.New("no such point")
+	ErrInvalidName   = errors.New("collection names must be non-empty and may not contain ':'")
+)
+
+// Metric is the distance used to rank search results.
+type Metric string
+
+const (
+	MetricL2     Metric = "l2"
+	MetricCosine Metric = "cosine"
+	MetricIP     Metric = "ip"
+)
+
+func ParseMetric(s string) (Metric, error) {
+	switch metric := Metric(strings.ToLower(s)); metric {
+	case MetricL2, MetricCosine, MetricIP:
+		return metric, nil
+	}
+	return "", fmt.Errorf("unknown metric %q", s)
+}
+
+// Info describes a collection. It is stored as JSON under the collection's
+// metadata key.
+type Info struct {
+	// ID names the collection in its point keys. It is assigned on create
+	// and never reused.
+	ID        uint64 `json:"id"`
+	Name      string `json:"name"`
+	Dimension int    `json:"dimension"`
+	Metric    Metric `json:"metric"`
+	Tenant    string `json:"tenant,omitempty"`
+	// Index selects and tunes the search index. Collections created before
+	// indexes were configurable have none and use a flat index.
+	Index IndexParams `json:"index"`
+	// Fields are the payload fields with a secondary index, which filters
+	// use to avoid checking every point.
+	Fields []string `json:"fields,omitempty"`
+	// Text are the payload fields with a text index, for MATCH filters.
+	Text []TextField `json:"text,omitempty"`
+	// Schema, if set, is the JSON schema payloads must match (see Schema).
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// OutlierThreshold, if positive, flags written points further from the
+	// centroid than the mean distance plus this many standard deviations
+	// (see outlier.go).
+	OutlierThreshold float64 `json:"outlier_threshold,omitempty"`
+}
+
+// Collection is the in-memory copy of a collection's vectors.
+type Collection struct {
+	Info
+
+	mutex sync.RWMutex
+	// points is keyed by internal point ID; ids maps the users' keys to
+	// those IDs.
+	points map[uint64]*point
+	ids    map[string]uint64
+	// lastPoint is the most recently assigned point ID. It is only changed
+	// with the manager's writeMutex held.
+	lastPoint uint64
+	// index is the HNSW graph of an hnsw collection, nil for flat ones and
+	// while demoted is set (see budget.go). Both only change with the
+	// manager's writeMutex held as well as c.mutex.
+	index   *hnsw
+	demoted bool
+	// searched is when the collection was last searched, in Unix
+	// milliseconds. Unlike for points, a coarse time would leave the
+	// collections searched within the same minute unordered.
+	searched atomic.Int64
+	// schema is the parsed Info.Schema. It is only changed with the
+	// manager's writeMutex held, along with Info.Fields.
+	schema *Schema
+	// analyzers are the analyzers of the Text fields.
+	analyzers map[string]*Analyzer
+	// lastOp is the sequence number of the latest op log entry and
+	// checkpointed that of the latest entry covered by a checkpoint.
+	lastOp       atomic.Uint64
+	checkpointed atomic.Uint64
+	// logicalBytes is the size of the live keys and values of the
+	// collection's points, before any storage overhead.
+	logicalBytes atomic.Int64
+	// statsCache holds the planner statistics of the indexed fields.
+	statsCache statsCache
+	// sketches estimate the distinct values of the indexed fields.
+	sketches fieldSketches
+	// outliers tracks the centroid if OutlierThreshold is set.
+	outliers outlierDetector
+	// deferred counts the points waiting to be embedded (see deferred.go).
+	deferred atomic.Int64
+}
+
+type point struct {
+	// key is the ID the user gave the point.
+	key    string
+	vector []float64
+	// size is the number of bytes of the point's keys and values.
+	size int64
+	// lastSearched is when the point was last returned by a search, in
+	// coarse unix seconds, so the least recently searched points can be
+	// evicted first.
+	lastSearched atomic.Int64
+}
+
+// accessGranularity is the resolution of lastSearched. Updates within the
+// same window are skipped to keep searches from writing on every hit.
+const accessGranularity = time.Minute
+
+func coarseNow() int64 {
+	return time.Now().Truncate(accessGranularity).Unix()
+}
+
+func newPoint(key string, vector []float64, size int64) *point {
+	p := &point{key: key, vector: vector, size: size}
+	p.lastSearched.Store(coarseNow())
+	return p
+}
+
+func newCollection(info Info) *Collection {
+	info.Index.setDefaults()
+	c := &Collection{
+		Info:   info,
+		points: make(map[uint64]*point),
+		ids:    make(map[string]uint64),
+	}
+	if info.Schema != nil {
+		// Checked when the schema was set.
+		c.schema, _ = ParseSchema(info.Schema)
+	}
+	for _, text := range info.Text {
+		// Checked by Create.
+		a, _ := ParseAnalyzer(text.Analyzer)
+		if c.analyzers == nil {
+			c.analyzers = make(map[string]*Analyzer)
+		}
+		c.analyzers[text.Field] = a
+	}
+	if info.Index.Type == IndexHNSW {
+		c.index = newHNSW(info.Index, info.Metric, func(id uint64) []float64 { return c.points[id].vector })
+	}
+	return c
+}
+
+// put adds or replaces a point. c.mutex must be held.
+func (c *Collection) put(id uint64, p *point) {
+	if old, ok := c.points[id]; ok {
+		c.logicalBytes.Add(-old.size)
+		if c.OutlierThreshold > 0 {
+			c.outliers.add(old.vector, -1)
+		}
+	}
+	if c.OutlierThreshold > 0 {
+		c.outliers.add(p.vector, 1)
+	}
+	c.points[id] = p
+	c.ids[p.key] = id
+	c.logicalBytes.Add(p.size)
+	if c.index != nil {
+		c.index.insert(id)
+	}
+}
+
+// remove deletes a point. c.mutex must be held.
+func (c *Collection) remove(id uint64) {
+	if p, ok := c.points[id]; ok {
+		if c.index != nil {
+			c.index.remove(id)
+		}
+		c.logicalBytes.Add(-p.size)
+		if c.OutlierThreshold > 0 {
+			c.outliers.add(p.vector, -1)
+		}
+		delete(c.points, id)
+		delete(c.ids, p.key)
+	}
+}
+
+// pointSize is the number of bytes of the keys and values of a point: its
+// vector, payload and key fields and its key-to-ID mapping.
+func (c *Collection) pointSize(id uint64, key string, vector, payload []byte) int64 {
+	size := len(vectorKey(c.ID, id)) + len(vector) +
+		len(pointKeyKey(c.ID, id)) + len(key) +
+		len(mappingKey(c.ID, key)) + 8
+	if payload != nil {
+		size += len(payloadKey(c.ID, id)) + len(payload)
+	}
+	return int64(size)
+}
+
+// deletePoint adds the deletion of every key of a point, including its
+// secondary index entries, to batch. The manager's writeMutex must be held.
+func (m *Manager) deletePoint(batch *pebble.Batch, c *Collection, id uint64, key string) error {
+	if c.hasIndexes() {
+		payload, _, err := m.get(payloadKey(c.ID, id))
+		if err != nil {
+			return err
+		}
+		c.indexPayload(batch, id, payload, false)
+	}
+	batch.Delete(vectorKey(c.ID, id), nil)
+	batch.Delete(payloadKey(c.ID, id), nil)
+	batch.Delete(pointKeyKey(c.ID, id), nil)
+	batch.Delete(mappingKey(c.ID, key), nil)
+	batch.Delete(outlierKey(c.ID, id), nil)
+	c.logOp(batch, opDelete, id)
+	return nil
+}
+
+// LogicalBytes returns the size of the collection's point keys and values.
+func (c *Collection) LogicalBytes() int64 {
+	return c.logicalBytes.Load()
+}
+
+func (p *point) touch(now int64) {
+	if p.lastSearched.Load() != now {
+		p.lastSearched.Store(now)
+	}
+}
+
+// Len returns the number of points in c.
+func (c *Collection) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.points)
+}
+
+// Manager owns every collection and tenant. Mutations are serialized so the
+// in-memory copies are updated in the same order as Pebble; reads only take
+// the lock of the collection they read.
+type Manager struct {
+	db        *pebble.DB
+	committer *durability.Committer
+
+	writeMutex sync.Mutex
+
+	mutex       sync.RWMutex
+	collections map[string]*Collection
+	tenants     map[string]*Tenant
+	// lastID is the ID of the most recently created collection.
+	lastID uint64
+
+	evicted  atomic.Int64
+	degraded atomic.Int64
+	outliers atomic.Int64
+
+	// indexBudget caps the memory of the HNSW graphs, 0 for no cap.
+	indexBudget atomic.Int64
+	demotions   atomic.Int64
+	reloads     atomic.Int64
+}
+
+func NewManager(db *pebble.DB, committer *durability.Committer) *Manager {
+	return &Manager{
+		db:          db,
+		committer:   committer,
+		collections: make(map[string]*Collection),
+		tenants:     make(map[string]*Tenant),
+	}
+}
+
+// Load reads every tenant, collection and point from Pebble.
+func (m *Manager) Load() error {
+	var lastID uint64
+	value, closer, err := m.db.Get(collectionIDKey)
+	switch err {
+	case nil:
+		if len(value) != 8 {
+			closer.Close()
+			return fmt.Errorf("collection ID sequence is %d bytes, want 8", len(value))
+		}
+		lastID = binary.BigEndian.Uint64(value)
+		closer.Close()
+	case pebble.ErrNotFound:
+	default:
+		return err
+	}
+	tenants := make(map[string]*Tenant)
+	collections := make(map[string]*Collection)
+
+	iter, err := m.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(tenantPrefix),
+		UpperBound: prefixEnd([]byte(tenantPrefix)),
+	})
+	if err != nil {
+		return err
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		var tenant Tenant
+		if err := json.Unmarshal(iter.Value(), &tenant); err != nil {
+			// The key is only valid until the iterator is closed.
+			err = fmt.Errorf("tenant %q: %w", iter.Key()[len(tenantPrefix):], err)
+			iter.Close()
+			return err
+		}
+		tenants[tenant.Name] = &tenant
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	iter, err = m.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(collectionPrefix),
+		UpperBound: prefixEnd([]byte(collectionPrefix)),
+	})
+	if err != nil {
+		return err
+	}
+	var infos []Info
+	for iter.First(); iter.Valid(); iter.Next() {
+		var info Info
+		if err := json.Unmarshal(iter.Value(), &info); err != nil {
+			err = fmt.Errorf("collection %q: %w", iter.Key()[len(collectionPrefix):], err)
+			iter.Close()
+			return err
+		}
+		infos = append(infos, info)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		c, err := m.loadCollection(info)
+		if err != nil {
+			return fmt.Errorf("collection %q: %w", info.Name, err)
+		}
+		if err := m.countDeferred(c); err != nil {
+			return fmt.Errorf("collection %q: %w", info.Name, err)
+		}
+		collections[info.Name] = c
+	}
+	m.mutex.Lock()
+	m.lastID, m.tenants, m.collections = lastID, tenants, collections
+	m.mutex.Unlock()
+	return nil
+}
+
+// Reload replaces every tenant and collection with the ones in Pebble, once
+// the keyspace was replaced underneath the manager, as by a replica loading
+// its master's snapshot.
+func (m *Manager) Reload() error {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	return m.Load()
+}
+
+// scanPoints builds the index of c from its points.
+func (m *Manager) scanPoints(c *Collection) error {
+	prefix := pointsPrefix(c.ID)
+	iter, err := m.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	// A point's key and payload fields sort just before its vector.
+	var key string
+	var payload []byte
+	for iter.First(); iter.Valid(); iter.Next() {
+		_, id, field, err := parseFieldKey(iter.Key())
+		if err != nil {
+			return fmt.Errorf("key %q: %w", iter.Key(), err)
+		}
+		switch field {
+		case fieldKey:
+			key, payload = string(iter.Value()), nil
+			continue
+		case fieldPayload:
+			payload = iter.Value()
+			continue
+		}
+		vector, err := storage.DecodeVector(iter.Value())
+		if err != nil {
+			return fmt.Errorf("point %q: %w", key, err)
+		}
+		c.put(id, newPoint(key, vector, c.pointSize(id, key, iter.Value(), payload)))
+		c.lastPoint = max(c.lastPoint, id)
+		key, payload = "", nil
+	}
+	return iter.Error()
+}
+
+// Create adds an empty collection.
+func (m *Manager) Create(info Info) error {
+	if err := info.check(); err != nil {
+		return err
+	}
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	if _, err := m.Get(info.Name); err == nil {
+		return ErrExists
+	}
+	info.ID = m.lastID + 1
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	batch := m.db.NewBatch()
+	defer batch.Close()
+	batch.Set(collectionKey(info.Name), data, nil)
+	batch.Set(collectionIDKey, binary.BigEndian.AppendUint64(nil, info.ID), nil)
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+	m.lastID = info.ID
+	m.mutex.Lock()
+	m.collections[info.Name] = newCollection(info)
+	m.mutex.Unlock()
+	return nil
+}
+
+// check validates a new collection's settings, filling in the defaults.
+func (info *Info) check() error {
+	if info.Name == "" || strings.Contains(info.Name, ":") {
+		return ErrInvalidName
+	}
+	if info.Dimension <= 0 {
+		return errors.New("dimension must be positive")
+	}
+	if info.Metric == "" {
+		info.Metric = MetricL2
+	}
+	info.Index.setDefaults()
+	if info.Index.Type != IndexHNSW && (info.Index.M != 0 || info.Index.EFConstruction != 0 || info.Index.EFSearch != 0) {
+		return errors.New("M, EF_CONSTRUCTION and EF_SEARCH apply to hnsw indexes only")
+	}
+	if info.OutlierThreshold < 0 || math.IsNaN(info.OutlierThreshold) {
+		return errors.New("outlier threshold must not be negative")
+	}
+	if info.Schema != nil {
+		schema, err := ParseSchema(info.Schema)
+		if err != nil {
+			return err
+		}
+		info.Fields = mergeFields(info.Fields, schema.IndexedFields())
+	}
+	for i, field := range info.Fields {
+		if !validFieldPath(field) {
+			return fmt.Errorf("invalid field %q", field)
+		}
+		if slices.Contains(info.Fields[:i], field) {
+			return fmt.Errorf("field %q is indexed twice", field)
+		}
+	}
+	for i, text := range info.Text {
+		if !validFieldPath(text.Field) {
+			return fmt.Errorf("invalid field %q", text.Field)
+		}
+		if slices.ContainsFunc(info.Text[:i], func(t TextField) bool { return t.Field == text.Field }) {
+			return fmt.Errorf("field %q has two text indexes", text.Field)
+		}
+		if _, err := ParseAnalyzer(text.Analyzer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drop deletes a collection and all of its points.
+func (m *Manager) Drop(name string) error {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	c, err := m.Get(name)
+	if err != nil {
+		return err
+	}
+	// A single range tombstone drops every point no matter how many there
+	// are; the space comes back once the span is compacted (see Span).
+	start, end := c.Span()
+	batch := m.db.NewBatch()
+	if err := batch.DeleteRange(start, end, nil); err != nil {
+		batch.Close()
+		return err
+	}
+	batch.Delete(collectionKey(name), nil)
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	delete(m.collections, name)
+	m.mutex.Unlock()
+	return nil
+}
+
+// Get returns the collection called name.
+func (m *Manager) Get(name string) (*Collection, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	c, ok := m.collections[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+// List returns every collection sorted by name.
+func (m *Manager) List() []Info {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	infos := make([]Info, 0, len(m.collections))
+	for _, c := range m.collections {
+		c.mutex.RLock()
+		infos = append(infos, c.Info)
+		c.mutex.RUnlock()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Evicted returns how many points were evicted to keep tenants within
+// their quota since startup.
+func (m *Manager) Evicted() int64 {
+	return m.evicted.Load()
+}
+
+// Upsert stores a point, replacing any point with the same key. payload, if
+// not nil, must be a JSON document. Adding a point to a tenant at its quota
+// either fails with ErrQuotaExceeded or evicts the tenant's least recently
+// searched points, depending on the tenant's policy. mode is how durable the
+// write is once Upsert returns.
+func (m *Manager) Upsert(collection, key string, vector []float64, payload []byte, mode durability.Mode) error {
+	return m.upsert(collection, key, vector, payload, mode, time.Time{})
+}
+
+// upsert is Upsert, which, if deferredAt is not zero, only replaces the
+// point deferred then (see UpsertDeferred).
+func (m *Manager) upsert(collection, key string, vector []float64, payload []byte, mode durability.Mode, deferredAt time.Time) error {
+	c, err := m.Get(collection)
+	if err != nil {
+		return err
+	}
+	if len(vector) != c.Dimension {
+		return fmt.Errorf("vector has %d dimensions, collection %q expects %d", len(vector), c.Name, c.Dimension)
+	}
+	if payload != nil && !json.Valid(payload) {
+		return errors.New("payload is not valid JSON")
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	if c.schema != nil {
+		if err := c.schema.Validate(payload); err != nil {
+			return err
+		}
+	}
+
+	batch := m.db.NewBatch()
+	defer batch.Close()
+	undeferred, err := m.undefer(batch, c, key, deferredAt)
+	if err != nil {
+		return err
+	}
+	c.mutex.RLock()
+	id, exists := c.ids[key]
+	c.mutex.RUnlock()
+	if !exists {
+		id = c.lastPoint + 1
+		encoded := binary.BigEndian.AppendUint64(nil, id)
+		batch.Set(pointKeyKey(c.ID, id), []byte(key), nil)
+		batch.Set(mappingKey(c.ID, key), encoded, nil)
+		batch.Set(pointSequenceKey(c.ID), encoded, nil)
+	}
+	value := storage.EncodeVector(vector)
+	batch.Set(vectorKey(c.ID, id), value, nil)
+	var distance float64
+	outlier := false
+	if c.OutlierThreshold > 0 {
+		var score float64
+		var replaced []float64
+		c.mutex.RLock()
+		if exists {
+			replaced = c.points[id].vector
+		}
+		distance, score = c.outlierScore(vector, replaced)
+		c.mutex.RUnlock()
+		if outlier = score > c.OutlierThreshold; outlier {
+			batch.Set(outlierKey(c.ID, id), binary.BigEndian.AppendUint64(nil, math.Float64bits(score)), nil)
+		} else if exists {
+			batch.Delete(outlierKey(c.ID, id), nil)
+		}
+	}
+	if exists && c.hasIndexes() {
+		old, _, err := m.get(payloadKey(c.ID, id))
+		if err != nil {
+			return err
+		}
+		c.indexPayload(batch, id, old, false)
+	}
+	c.indexPayload(batch, id, payload, true)
+	if payload != nil {
+		batch.Set(payloadKey(c.ID, id), payload, nil)
+	} else {
+		batch.Delete(payloadKey(c.ID, id), nil)
+	}
+	c.logOp(batch, opUpsert, id)
+
+	var victims []victim
+	if !exists && c.Tenant != "" {
+		if victims, err = m.makeRoom(c.Tenant); err != nil {
+			return err
+		}
+		for _, v := range victims {
+			if err := m.deletePoint(batch, v.collection, v.id, v.key); err != nil {
+				return err
+			}
+		}
+	}
+	if err := m.committer.Commit(batch, mode); err != nil {
+		return err
+	}
+	c.addPayload(c.Fields, payload)
+
+	for _, v := range victims {
+		v.collection.mutex.Lock()
+		v.collection.remove(v.id)
+		v.collection.mutex.Unlock()
+	}
+	m.evicted.Add(int64(len(victims)))
+	if undeferred {
+		c.deferred.Add(-1)
+	}
+	if outlier {
+		m.outliers.Add(1)
+	}
+	c.mutex.Lock()
+	c.lastPoint = max(c.lastPoint, id)
+	if c.OutlierThreshold > 0 && !outlier {
+		// Outliers would widen what counts as normal.
+		c.outliers.observe(distance)
+	}
+	c.put(id, newPoint(key, vector, c.pointSize(id, key, value, payload)))
+	c.mutex.Unlock()
+	return nil
+}
+
+// Point returns the vector and payload of a point. payload is nil when the
+// point has none.
+func (m *Manager) Point(collection, key string) ([]float64, []byte, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mutex.RLock()
+	id, ok := c.ids[key]
+	p := c.points[id]
+	c.mutex.RUnlock()
+	if !ok {
+		return nil, nil, ErrPointNotFound
+	}
+	value, closer, err := m.db.Get(payloadKey(c.ID, id))
+	if err == pebble.ErrNotFound {
+		return p.vector, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closer.Close()
+	return p.vector, append([]byte{}, value...), nil
+}
+
+// Export calls fn with the key and payload of every point of a collection,
+// in the order they were first added, as of when it is called: it reads
+// from a snapshot, so writes made meanwhile are not seen. payload is nil
+// when the point has none, and only valid until fn returns. Points whose
+// text is still to be embedded are not points yet and are left out.
+func (m *Manager) Export(collection string, fn func(key string, payload []byte) error) error {
+	c, err := m.Get(collection)
+	if err != nil {
+		return err
+	}
+	snap := m.db.NewSnapshot()
+	defer snap.Close()
+	prefix := pointsPrefix(c.ID)
+	iter, err := snap.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	// As in scanPoints, a point's key and payload fields come before its
+	// vector, which ends the point.
+	var key string
+	var payload []byte
+	for iter.First(); iter.Valid(); iter.Next() {
+		_, _, field, err := parseFieldKey(iter.Key())
+		if err != nil {
+			return fmt.Errorf("key %q: %w", iter.Key(), err)
+		}
+		switch field {
+		case fieldKey:
+			key, payload = string(iter.Value()), nil
+		case fieldPayload:
+			payload = iter.Value()
+		case fieldVector:
+			if err := fn(key, payload); err != nil {
+				return err
+			}
+			key, payload = "", nil
+		}
+	}
+	return iter.Error()
+}
+
+// Delete removes points by key and returns how many existed.
+func (m *Manager) Delete(collection string, mode durability.Mode, keys ...string) (int, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return 0, err
+	}
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	batch := m.db.NewBatch()
+	defer batch.Close()
+	var deleted []uint64
+	var deletedKeys []string
+	seen := make(map[string]bool, len(keys))
+	c.mutex.RLock()
+	for _, key := range keys {
+		if id, ok := c.ids[key]; ok && !seen[key] {
+			seen[key] = true
+			deleted = append(deleted, id)
+			deletedKeys = append(deletedKeys, key)
+		}
+	}
+	c.mutex.RUnlock()
+	for i, id := range deleted {
+		if err := m.deletePoint(batch, c, id, deletedKeys[i]); err != nil {
+			return 0, err
+		}
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+	if err := m.committer.Commit(batch, mode); err != nil {
+		return 0, err
+	}
+	c.mutex.Lock()
+	for _, id := range deleted {
+		c.remove(id)
+	}
+	c.mutex.Unlock()
+	return len(deleted), nil
+}
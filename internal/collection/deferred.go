@@ -0,0 +1,298 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/durability"
+)
+
+// A point whose text is still to be embedded, because the embedding
+// provider was unavailable or because the client did not want to wait for
+// it, is kept without a vector, flagged for later embedding:
+//
+//	\x00p <coll> d <key>   deferred point (JSON)
+//
+// Together, the deferred points of every collection make up the embedding
+// queue the server's workers drain. A deferred point is not part of the
+// collection until it gets its vector: Upsert of the same key replaces it,
+// whether it comes from a worker or from the client writing the point
+// again.
+
+// ErrDeferredReplaced is returned by UpsertDeferred when the deferred point
+// was replaced or removed while its text was being embedded.
+var ErrDeferredReplaced = errors.New("deferred point was replaced")
+
+// DeferredPoint is a point waiting for its text to be embedded.
+type DeferredPoint struct {
+	Collection string          `json:"-"`
+	Key        string          `json:"-"`
+	Text       string          `json:"text"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	// QueuedAt is when the point was deferred. It tells a point apart from
+	// one deferred later with the same key.
+	QueuedAt time.Time `json:"queued_at"`
+	// Attempts is how many times embedding the text failed, RetryAt when
+	// it may be tried again and Error why the last attempt failed.
+	Attempts int       `json:"attempts,omitempty"`
+	RetryAt  time.Time `json:"retry_at"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// QueueStats summarizes the deferred points of every collection.
+type QueueStats struct {
+	Pending int
+	// Retrying is how many of them failed to embed at least once, and
+	// Waiting how many are waiting for their RetryAt.
+	Retrying int
+	Waiting  int
+	// Oldest is when the longest waiting point was deferred, zero if
+	// there are none.
+	Oldest time.Time
+}
+
+func deferredKey(collection uint64, key string) []byte {
+	return append(append(collectionSpace(collection), tagDeferred), key...)
+}
+
+// Defer stores a point of collection without its vector, to be embedded
+// from text later. It replaces any deferred point with the same key.
+func (m *Manager) Defer(collection, key, text string, payload []byte, mode durability.Mode) error {
+	c, err := m.Get(collection)
+	if err != nil {
+		return err
+	}
+	if payload != nil && !json.Valid(payload) {
+		return errors.New("payload is not valid JSON")
+	}
+	value, err := json.Marshal(DeferredPoint{Text: text, Payload: payload, QueuedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	if c.schema != nil {
+		if err := c.schema.Validate(payload); err != nil {
+			return err
+		}
+	}
+	dkey := deferredKey(c.ID, key)
+	_, exists, err := m.get(dkey)
+	if err != nil {
+		return err
+	}
+	batch := m.db.NewBatch()
+	defer batch.Close()
+	batch.Set(dkey, value, nil)
+	if err := m.committer.Commit(batch, mode); err != nil {
+		return err
+	}
+	if !exists {
+		c.deferred.Add(1)
+	}
+	return nil
+}
+
+// UpsertDeferred adds the deferred point p to its collection with the
+// vector its text was embedded into, unless it was replaced in the
+// meantime, in which case it fails with ErrDeferredReplaced.
+func (m *Manager) UpsertDeferred(p DeferredPoint, vector []float64, mode durability.Mode) error {
+	return m.upsert(p.Collection, p.Key, vector, p.Payload, mode, p.QueuedAt)
+}
+
+// RetryDeferred records that embedding p failed with reason and that it
+// should be tried again at retryAt. It does nothing if p was replaced.
+func (m *Manager) RetryDeferred(p DeferredPoint, retryAt time.Time, reason string) error {
+	c, err := m.Get(p.Collection)
+	if err != nil {
+		return err
+	}
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	current, exists, err := m.loadDeferred(c, p.Key)
+	if err != nil || !exists || !current.QueuedAt.Equal(p.QueuedAt) {
+		return err
+	}
+	current.Attempts++
+	current.RetryAt = retryAt
+	current.Error = reason
+	value, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return m.db.Set(deferredKey(c.ID, p.Key), value, pebble.NoSync)
+}
+
+// DropDeferred deletes the deferred point p, for one that cannot be
+// embedded or added. It does nothing if p was replaced.
+func (m *Manager) DropDeferred(p DeferredPoint) error {
+	c, err := m.Get(p.Collection)
+	if err != nil {
+		return err
+	}
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	current, exists, err := m.loadDeferred(c, p.Key)
+	if err != nil || !exists || !current.QueuedAt.Equal(p.QueuedAt) {
+		return err
+	}
+	if err := m.db.Delete(deferredKey(c.ID, p.Key), pebble.Sync); err != nil {
+		return err
+	}
+	c.deferred.Add(-1)
+	return nil
+}
+
+func (m *Manager) loadDeferred(c *Collection, key string) (DeferredPoint, bool, error) {
+	value, exists, err := m.get(deferredKey(c.ID, key))
+	if err != nil || !exists {
+		return DeferredPoint{}, false, err
+	}
+	var p DeferredPoint
+	if err := json.Unmarshal(value, &p); err != nil {
+		return DeferredPoint{}, false, fmt.Errorf("deferred point %q: %w", key, err)
+	}
+	p.Collection, p.Key = c.Name, key
+	return p, true, nil
+}
+
+// undefer deletes the deferred point key of c in batch, reporting whether
+// there was one. If queuedAt is not zero, the deferred point must be the
+// one queued then, or undefer fails with ErrDeferredReplaced. m.writeMutex
+// must be held.
+func (m *Manager) undefer(batch *pebble.Batch, c *Collection, key string, queuedAt time.Time) (bool, error) {
+	if c.deferred.Load() == 0 && queuedAt.IsZero() {
+		return false, nil
+	}
+	p, exists, err := m.loadDeferred(c, key)
+	if err != nil {
+		return false, err
+	}
+	if !queuedAt.IsZero() && (!exists || !p.QueuedAt.Equal(queuedAt)) {
+		return false, ErrDeferredReplaced
+	}
+	if !exists {
+		return false, nil
+	}
+	batch.Delete(deferredKey(c.ID, key), nil)
+	return true, nil
+}
+
+// Deferred returns up to limit deferred points for which keep, if not nil,
+// returns true, collection by collection, and how many deferred points
+// there are in total.
+func (m *Manager) Deferred(limit int, keep func(DeferredPoint) bool) ([]DeferredPoint, int, error) {
+	var points []DeferredPoint
+	total := 0
+	err := m.scanDeferred(func(c *Collection) bool {
+		n := int(c.deferred.Load())
+		total += n
+		return n > 0 && len(points) < limit
+	}, func(p DeferredPoint) bool {
+		if keep == nil || keep(p) {
+			points = append(points, p)
+		}
+		return len(points) < limit
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return points, total, nil
+}
+
+// DeferredStats summarizes the deferred points of every collection as of
+// now.
+func (m *Manager) DeferredStats(now time.Time) (QueueStats, error) {
+	var stats QueueStats
+	err := m.scanDeferred(func(c *Collection) bool {
+		return c.deferred.Load() > 0
+	}, func(p DeferredPoint) bool {
+		stats.Pending++
+		if p.Attempts > 0 {
+			stats.Retrying++
+		}
+		if p.RetryAt.After(now) {
+			stats.Waiting++
+		}
+		if stats.Oldest.IsZero() || p.QueuedAt.Before(stats.Oldest) {
+			stats.Oldest = p.QueuedAt
+		}
+		return true
+	})
+	return stats, err
+}
+
+// scanDeferred calls fn with the deferred points of the collections for
+// which scan returns true, until fn returns false.
+func (m *Manager) scanDeferred(scan func(*Collection) bool, fn func(DeferredPoint) bool) error {
+	for _, info := range m.List() {
+		c, err := m.Get(info.Name)
+		if err != nil || !scan(c) {
+			continue
+		}
+		prefix := append(collectionSpace(c.ID), tagDeferred)
+		iter, err := m.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+		if err != nil {
+			return err
+		}
+		more := true
+		for iter.First(); more && iter.Valid(); iter.Next() {
+			var p DeferredPoint
+			if err := json.Unmarshal(iter.Value(), &p); err != nil {
+				iter.Close()
+				return fmt.Errorf("deferred point %q: %w", iter.Key()[len(prefix):], err)
+			}
+			p.Collection, p.Key = c.Name, string(iter.Key()[len(prefix):])
+			more = fn(p)
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+	return nil
+}
+
+// countDeferred counts the deferred points of c at startup.
+func (m *Manager) countDeferred(c *Collection) error {
+	prefix := append(collectionSpace(c.ID), tagDeferred)
+	iter, err := m.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		c.deferred.Add(1)
+	}
+	return iter.Error()
+}
@@ -0,0 +1,181 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// FacetCount is the number of matching points holding a value.
+type FacetCount struct {
+	// Value is a string, float64 or bool.
+	Value interface{}
+	Count int
+}
+
+// Facets counts, for each of fields, how many points of collection matching
+// filter, which may be nil, hold each value of the field, for filter UIs.
+// A point holding an array counts once for each distinct element. Only the
+// limit most common values of each field are returned, most common first
+// and ties in value order. Indexed fields are counted from their index,
+// others from the payloads of the matching points.
+func (m *Manager) Facets(collection string, filter *Filter, fields []string, limit int) (map[string][]FacetCount, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if !validFieldPath(field) {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+	}
+	plan, err := m.plan(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.RLock()
+	mt := &matcher{m: m, c: c, plan: plan}
+	matched := make(map[uint64]struct{})
+	for _, id := range mt.ids() {
+		if mt.match(id) {
+			matched[id] = struct{}{}
+		}
+	}
+	c.mutex.RUnlock()
+	if mt.err != nil {
+		return nil, mt.err
+	}
+
+	counts := make(map[string]map[string]*FacetCount, len(fields))
+	add := func(field string, v interface{}) {
+		key, ok := appendIndexValue(nil, v)
+		if !ok {
+			return
+		}
+		if counts[field][string(key)] == nil {
+			counts[field][string(key)] = &FacetCount{Value: v}
+		}
+		counts[field][string(key)].Count++
+	}
+	var unindexed []string
+	for _, field := range fields {
+		if counts[field] != nil {
+			continue
+		}
+		counts[field] = make(map[string]*FacetCount)
+		if !c.indexed(field) {
+			unindexed = append(unindexed, field)
+			continue
+		}
+		if err := m.facetIndex(c, field, matched, func(v interface{}) { add(field, v) }); err != nil {
+			return nil, err
+		}
+	}
+	if len(unindexed) > 0 {
+		for id := range matched {
+			payload, _, err := m.get(payloadKey(c.ID, id))
+			if err != nil {
+				return nil, err
+			}
+			var doc interface{}
+			if payload == nil || json.Unmarshal(payload, &doc) != nil {
+				continue
+			}
+			for _, field := range unindexed {
+				seen := make(map[string]bool)
+				anyValue(lookupField(doc, field), func(v interface{}) bool {
+					if key, ok := appendIndexValue(nil, v); ok && !seen[string(key)] {
+						seen[string(key)] = true
+						add(field, v)
+					}
+					return false
+				})
+			}
+		}
+	}
+
+	facets := make(map[string][]FacetCount, len(counts))
+	for field, values := range counts {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := values[keys[i]], values[keys[j]]
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return keys[i] < keys[j]
+		})
+		if limit > 0 && len(keys) > limit {
+			keys = keys[:limit]
+		}
+		facets[field] = make([]FacetCount, len(keys))
+		for i, key := range keys {
+			facets[field][i] = *values[key]
+		}
+	}
+	return facets, nil
+}
+
+// facetIndex calls fn with the value of every index entry of field that
+// belongs to a point in ids.
+func (m *Manager) facetIndex(c *Collection, field string, ids map[uint64]struct{}, fn func(interface{})) error {
+	prefix := fieldIndexPrefix(c.ID, field)
+	iter, err := m.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if len(key) < len(prefix)+9 {
+			continue
+		}
+		if _, ok := ids[binary.BigEndian.Uint64(key[len(key)-8:])]; !ok {
+			continue
+		}
+		if v, ok := decodeIndexValue(key[len(prefix) : len(key)-8]); ok {
+			fn(v)
+		}
+	}
+	return iter.Error()
+}
+
+// FormatFacetValue renders a FacetCount value as text.
+func FormatFacetValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
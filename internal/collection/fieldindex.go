@@ -0,0 +1,317 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"slices"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// The payload fields listed in Info.Fields have a secondary index, kept in
+// the collection's space and written in the same batch as the payload:
+//
+//	\x00p <coll> f <len> <field> <value> <point>
+//
+// with one key, without a value, per point and distinct scalar the field
+// holds (every element of an array), plus a presence entry whose value is
+// just the tag 'e' for every point that has the field at all. Values are
+// encoded so that byte order is value order within a type: a type tag, then
+// for numbers the float64 bits with the sign flipped (all bits for negative
+// numbers), for strings the bytes with 0x00 escaped as 0x00 0xff and
+// terminated by 0x00 0x01, and for booleans a single byte.
+const (
+	valuePresent byte = 'e'
+	valueBool    byte = 'b'
+	valueNumber  byte = 'n'
+	valueString  byte = 's'
+)
+
+func fieldIndexPrefix(collection uint64, field string) []byte {
+	key := append(collectionSpace(collection), tagFieldIndex)
+	key = binary.AppendUvarint(key, uint64(len(field)))
+	return append(key, field...)
+}
+
+// appendIndexValue appends the encoding of a scalar to key. ok is false for
+// values that are not indexed: objects, arrays and null.
+func appendIndexValue(key []byte, v interface{}) (_ []byte, ok bool) {
+	switch v := v.(type) {
+	case float64:
+		bits := math.Float64bits(v)
+		if bits>>63 == 1 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return binary.BigEndian.AppendUint64(append(key, valueNumber), bits), true
+	case string:
+		key = append(key, valueString)
+		for i := 0; i < len(v); i++ {
+			if v[i] == 0 {
+				key = append(key, 0, 0xff)
+			} else {
+				key = append(key, v[i])
+			}
+		}
+		return append(key, 0, 1), true
+	case bool:
+		if v {
+			return append(key, valueBool, 1), true
+		}
+		return append(key, valueBool, 0), true
+	}
+	return key, false
+}
+
+// decodeIndexValue decodes a value encoded by appendIndexValue. ok is false
+// for the presence entry and malformed values.
+func decodeIndexValue(b []byte) (_ interface{}, ok bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	switch b[0] {
+	case valueNumber:
+		if len(b) == 9 {
+			return decodeIndexNumber(b[1:]), true
+		}
+	case valueBool:
+		if len(b) == 2 {
+			return b[1] == 1, true
+		}
+	case valueString:
+		s := make([]byte, 0, len(b))
+		for i := 1; i+1 < len(b); i++ {
+			if b[i] != 0 {
+				s = append(s, b[i])
+				continue
+			}
+			if b[i+1] == 1 {
+				return string(s), i+2 == len(b)
+			}
+			s = append(s, 0)
+			i++
+		}
+	}
+	return nil, false
+}
+
+// valueTag returns the type tag a scalar is encoded with.
+func valueTag(v interface{}) byte {
+	switch v.(type) {
+	case float64:
+		return valueNumber
+	case string:
+		return valueString
+	default:
+		return valueBool
+	}
+}
+
+// indexedFields returns the fields with a secondary index.
+func (c *Collection) indexedFields() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.Fields
+}
+
+// indexed reports whether field has a secondary index.
+func (c *Collection) indexed(field string) bool {
+	return slices.Contains(c.indexedFields(), field)
+}
+
+// indexPayload adds to batch the secondary and text index entries of a
+// point's payload, or their deletion if set is false. The manager's
+// writeMutex must be held.
+func (c *Collection) indexPayload(batch *pebble.Batch, id uint64, payload []byte, set bool) {
+	c.indexFields(batch, id, payload, c.Fields, set)
+	c.indexText(batch, id, payload, set)
+}
+
+// indexFields is indexPayload for the given fields only.
+func (c *Collection) indexFields(batch *pebble.Batch, id uint64, payload []byte, fields []string, set bool) {
+	if len(fields) == 0 || payload == nil {
+		return
+	}
+	var doc interface{}
+	if json.Unmarshal(payload, &doc) != nil {
+		return
+	}
+	write := func(key []byte) {
+		key = binary.BigEndian.AppendUint64(key, id)
+		if set {
+			batch.Set(key, nil, nil)
+		} else {
+			batch.Delete(key, nil)
+		}
+	}
+	for _, field := range fields {
+		value := lookupField(doc, field)
+		if value == nil {
+			continue
+		}
+		prefix := fieldIndexPrefix(c.ID, field)
+		write(append(prefix, valuePresent))
+		anyValue(value, func(v interface{}) bool {
+			if key, ok := appendIndexValue(append([]byte{}, prefix...), v); ok {
+				write(key)
+			}
+			return false
+		})
+	}
+}
+
+// scanFieldIndex adds to ids the points of the index entries in
+// [lower, upper).
+func (m *Manager) scanFieldIndex(lower, upper []byte, ids map[uint64]struct{}) error {
+	iter, err := m.db.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if len(key) >= 8 {
+			ids[binary.BigEndian.Uint64(key[len(key)-8:])] = struct{}{}
+		}
+	}
+	return iter.Error()
+}
+
+// lookupCondition returns the points matching cond according to the
+// secondary indexes. usable is false if no index can narrow cond down, and
+// exact is false if the points are only candidates: a superset of the
+// matches that must still be checked against their payloads.
+func (m *Manager) lookupCondition(c *Collection, cond condition) (ids map[uint64]struct{}, usable, exact bool, err error) {
+	scan := func(bounds ...[2][]byte) (map[uint64]struct{}, bool, bool, error) {
+		ids := make(map[uint64]struct{})
+		for _, b := range bounds {
+			if err := m.scanFieldIndex(b[0], b[1], ids); err != nil {
+				return nil, false, false, err
+			}
+		}
+		return ids, true, true, nil
+	}
+	encode := func(field string, v interface{}) []byte {
+		key, _ := appendIndexValue(fieldIndexPrefix(c.ID, field), v)
+		return key
+	}
+	typeRange := func(field string, v interface{}) [2][]byte {
+		start := append(fieldIndexPrefix(c.ID, field), valueTag(v))
+		return [2][]byte{start, prefixEnd(start)}
+	}
+
+	switch cond := cond.(type) {
+	case *compareCondition:
+		if !c.indexed(cond.field) || cond.op == "!=" {
+			return nil, false, false, nil
+		}
+		value, all := encode(cond.field, cond.value), typeRange(cond.field, cond.value)
+		switch cond.op {
+		case "=":
+			return scan([2][]byte{value, prefixEnd(value)})
+		case "<":
+			return scan([2][]byte{all[0], value})
+		case "<=":
+			return scan([2][]byte{all[0], prefixEnd(value)})
+		case ">":
+			return scan([2][]byte{prefixEnd(value), all[1]})
+		default:
+			return scan([2][]byte{value, all[1]})
+		}
+	case *inCondition:
+		if !c.indexed(cond.field) {
+			return nil, false, false, nil
+		}
+		bounds := make([][2][]byte, len(cond.values))
+		for i, v := range cond.values {
+			value := encode(cond.field, v)
+			bounds[i] = [2][]byte{value, prefixEnd(value)}
+		}
+		return scan(bounds...)
+	case *rangeCondition:
+		if !c.indexed(cond.field) {
+			return nil, false, false, nil
+		}
+		if valueTag(cond.lo) != valueTag(cond.hi) {
+			return map[uint64]struct{}{}, true, true, nil
+		}
+		return scan([2][]byte{encode(cond.field, cond.lo), prefixEnd(encode(cond.field, cond.hi))})
+	case *existsCondition:
+		if !c.indexed(cond.field) {
+			return nil, false, false, nil
+		}
+		present := append(fieldIndexPrefix(c.ID, cond.field), valuePresent)
+		return scan([2][]byte{present, prefixEnd(present)})
+	case *matchCondition:
+		if c.analyzer(cond.field) == nil {
+			return nil, false, false, nil
+		}
+		ids, err := m.lookupText(c, cond)
+		return ids, err == nil, err == nil, err
+	case andCondition:
+		// Intersect whatever the indexes can answer; the rest is checked
+		// against the payloads of the candidates.
+		exact = true
+		for _, sub := range cond {
+			subIDs, subUsable, subExact, err := m.lookupCondition(c, sub)
+			if err != nil {
+				return nil, false, false, err
+			}
+			if !subUsable {
+				exact = false
+				continue
+			}
+			exact = exact && subExact
+			if ids == nil {
+				ids = subIDs
+				continue
+			}
+			for id := range ids {
+				if _, ok := subIDs[id]; !ok {
+					delete(ids, id)
+				}
+			}
+		}
+		return ids, ids != nil, exact && ids != nil, nil
+	case orCondition:
+		ids, exact = make(map[uint64]struct{}), true
+		for _, sub := range cond {
+			subIDs, subUsable, subExact, err := m.lookupCondition(c, sub)
+			if err != nil || !subUsable {
+				return nil, false, false, err
+			}
+			exact = exact && subExact
+			for id := range subIDs {
+				ids[id] = struct{}{}
+			}
+		}
+		return ids, true, exact, nil
+	}
+	// NOT and GEO_RADIUS are always checked against the payloads.
+	return nil, false, false, nil
+}
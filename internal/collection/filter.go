@@ -0,0 +1,722 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filters restrict VSEARCH, VCOUNT and VSCROLL to the points whose payload
+// matches an expression:
+//
+//	expr    = or
+//	or      = and { OR and }
+//	and     = not { AND not }
+//	not     = NOT not | primary
+//	primary = "(" expr ")"
+//	        | field op value                  op is = != < <= > >=
+//	        | field IN "(" value { "," value } ")"
+//	        | field BETWEEN value AND value   inclusive range
+//	        | EXISTS "(" field ")"
+//	        | GEO_RADIUS "(" field "," lat "," lon "," meters ")"
+//	        | MATCH "(" field "," 'string' ")"
+//	value   = number | 'string' | "string" | TRUE | FALSE
+//
+// Keywords are case-insensitive. A field is a dotted path into the JSON
+// payload, e.g. author.name. A condition on a field holding an array
+// matches if any element matches. Comparisons only match values of the same
+// type, so a point without the field, or with a string where a number is
+// expected, matches neither a = 1 nor a != 1. GEO_RADIUS expects the field
+// to hold an object with lat and lon in degrees. MATCH matches text holding
+// every word of the string once both are analyzed, with the analyzer of the
+// field's text index if it has one (see Analyzer) and lowercase otherwise.
+type Filter struct {
+	root   condition
+	source string
+}
+
+func (f *Filter) String() string {
+	return f.source
+}
+
+// condition is a node of a filter expression.
+type condition interface {
+	match(doc interface{}) bool
+}
+
+type (
+	andCondition []condition
+	orCondition  []condition
+	notCondition struct{ condition }
+
+	compareCondition struct {
+		field string
+		op    string
+		value interface{}
+	}
+	inCondition struct {
+		field  string
+		values []interface{}
+	}
+	rangeCondition struct {
+		field  string
+		lo, hi interface{}
+	}
+	existsCondition struct {
+		field string
+	}
+	geoCondition struct {
+		field              string
+		lat, lon, distance float64
+	}
+	matchCondition struct {
+		field    string
+		query    string
+		analyzer *Analyzer
+	}
+)
+
+// ParseFilter parses a filter expression.
+func ParseFilter(s string) (*Filter, error) {
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("filter: unexpected %q", p.tokens[p.pos].text)
+	}
+	return &Filter{root: root, source: s}, nil
+}
+
+// Match reports whether a JSON payload matches f. A nil payload only
+// matches conditions that hold for missing fields, such as NOT EXISTS(a).
+func (f *Filter) Match(payload []byte) bool {
+	var doc interface{}
+	if payload != nil && json.Unmarshal(payload, &doc) != nil {
+		return false
+	}
+	return f.root.match(doc)
+}
+
+// Conditions returns how many conditions f has, counting AND, OR and NOT
+// as well as the conditions they combine, as a measure of what matching it
+// costs.
+func (f *Filter) Conditions() int {
+	return countConditions(f.root)
+}
+
+func countConditions(cond condition) int {
+	n := 1
+	switch cond := cond.(type) {
+	case andCondition:
+		for _, sub := range cond {
+			n += countConditions(sub)
+		}
+	case orCondition:
+		for _, sub := range cond {
+			n += countConditions(sub)
+		}
+	case notCondition:
+		n += countConditions(cond.condition)
+	}
+	return n
+}
+
+func (c andCondition) match(doc interface{}) bool {
+	for _, sub := range c {
+		if !sub.match(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c orCondition) match(doc interface{}) bool {
+	for _, sub := range c {
+		if sub.match(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c notCondition) match(doc interface{}) bool {
+	return !c.condition.match(doc)
+}
+
+func (c *compareCondition) match(doc interface{}) bool {
+	return anyValue(lookupField(doc, c.field), func(v interface{}) bool {
+		cmp, ok := compareValues(v, c.value)
+		if !ok {
+			return false
+		}
+		switch c.op {
+		case "=":
+			return cmp == 0
+		case "!=":
+			return cmp != 0
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	})
+}
+
+func (c *inCondition) match(doc interface{}) bool {
+	return anyValue(lookupField(doc, c.field), func(v interface{}) bool {
+		for _, want := range c.values {
+			if cmp, ok := compareValues(v, want); ok && cmp == 0 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (c *rangeCondition) match(doc interface{}) bool {
+	return anyValue(lookupField(doc, c.field), func(v interface{}) bool {
+		lo, ok := compareValues(v, c.lo)
+		if !ok || lo < 0 {
+			return false
+		}
+		hi, ok := compareValues(v, c.hi)
+		return ok && hi <= 0
+	})
+}
+
+func (c *existsCondition) match(doc interface{}) bool {
+	return lookupField(doc, c.field) != nil
+}
+
+func (c *geoCondition) match(doc interface{}) bool {
+	return anyValue(lookupField(doc, c.field), func(v interface{}) bool {
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		lat, ok1 := object["lat"].(float64)
+		lon, ok2 := object["lon"].(float64)
+		return ok1 && ok2 && haversine(c.lat, c.lon, lat, lon) <= c.distance
+	})
+}
+
+func (c *matchCondition) match(doc interface{}) bool {
+	a := c.analyzer
+	if a == nil {
+		a = defaultAnalyzer
+	}
+	query := a.terms(c.query)
+	if len(query) == 0 {
+		return false
+	}
+	terms := textTerms(a, lookupField(doc, c.field))
+	for _, term := range query {
+		if _, ok := terms[term]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// formatCondition renders cond in filter syntax.
+func formatCondition(cond condition) string {
+	join := func(subs []condition, op string) string {
+		parts := make([]string, len(subs))
+		for i, sub := range subs {
+			parts[i] = formatCondition(sub)
+			if _, ok := sub.(orCondition); ok {
+				parts[i] = "(" + parts[i] + ")"
+			}
+		}
+		return strings.Join(parts, " "+op+" ")
+	}
+	switch cond := cond.(type) {
+	case andCondition:
+		return join(cond, "AND")
+	case orCondition:
+		return join(cond, "OR")
+	case notCondition:
+		inner := formatCondition(cond.condition)
+		switch cond.condition.(type) {
+		case andCondition, orCondition:
+			inner = "(" + inner + ")"
+		}
+		return "NOT " + inner
+	case *compareCondition:
+		return cond.field + " " + cond.op + " " + formatValue(cond.value)
+	case *inCondition:
+		values := make([]string, len(cond.values))
+		for i, v := range cond.values {
+			values[i] = formatValue(v)
+		}
+		return cond.field + " IN (" + strings.Join(values, ", ") + ")"
+	case *rangeCondition:
+		return cond.field + " BETWEEN " + formatValue(cond.lo) + " AND " + formatValue(cond.hi)
+	case *existsCondition:
+		return "EXISTS(" + cond.field + ")"
+	case *geoCondition:
+		return fmt.Sprintf("GEO_RADIUS(%s, %g, %g, %g)", cond.field, cond.lat, cond.lon, cond.distance)
+	case *matchCondition:
+		return "MATCH(" + cond.field + ", " + formatValue(cond.query) + ")"
+	}
+	return "?"
+}
+
+// FormatFilterValue renders v, a float64, string or bool, as a literal of
+// a filter expression.
+func FormatFilterValue(v interface{}) string {
+	return formatValue(v)
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	return "?"
+}
+
+// lookupField follows a dotted path into a decoded JSON document, returning
+// nil if any part of it is missing.
+func lookupField(doc interface{}, field string) interface{} {
+	for _, name := range strings.Split(field, ".") {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = object[name]
+	}
+	return doc
+}
+
+// anyValue applies fn to v, or to each element if v is an array.
+func anyValue(v interface{}, fn func(interface{}) bool) bool {
+	if array, ok := v.([]interface{}); ok {
+		for _, element := range array {
+			if fn(element) {
+				return true
+			}
+		}
+		return false
+	}
+	return v != nil && fn(v)
+}
+
+// compareValues orders two scalars of the same type. ok is false if their
+// types differ or cannot be ordered.
+func compareValues(a, b interface{}) (cmp int, ok bool) {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	case bool:
+		b, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		if a == b {
+			return 0, true
+		}
+		if b {
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+const earthRadiusMeters = 6371008.8
+
+// haversine returns the great-circle distance in meters between two points
+// given in degrees.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenSymbol
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+}
+
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("filter: unterminated string")
+			}
+			tokens = append(tokens, filterToken{tokenString, b.String()})
+			i = j + 1
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && (s[j] == '.' || s[j] == 'e' || s[j] == 'E' || s[j] >= '0' && s[j] <= '9' ||
+				(s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokenNumber, s[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokenWord, s[i:j]})
+			i = j
+		case strings.HasPrefix(s[i:], "!=") || strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			tokens = append(tokens, filterToken{tokenSymbol, s[i : i+2]})
+			i += 2
+		case strings.IndexByte("=<>(),", c) >= 0:
+			tokens = append(tokens, filterToken{tokenSymbol, s[i : i+1]})
+			i++
+		default:
+			return nil, fmt.Errorf("filter: unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return filterToken{kind: tokenSymbol}
+}
+
+// keyword consumes the next token if it is the keyword word.
+func (p *filterParser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// symbol consumes the next token if it is the symbol sym.
+func (p *filterParser) symbol(sym string) bool {
+	if t := p.peek(); t.kind == tokenSymbol && t.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(sym string) error {
+	if !p.symbol(sym) {
+		return p.unexpected("'" + sym + "'")
+	}
+	return nil
+}
+
+func (p *filterParser) unexpected(want string) error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("filter: expected %s at end of expression", want)
+	}
+	return fmt.Errorf("filter: expected %s, got %q", want, p.tokens[p.pos].text)
+}
+
+func (p *filterParser) or() (condition, error) {
+	first, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	terms := orCondition{first}
+	for p.keyword("or") {
+		next, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, next)
+	}
+	if len(terms) == 1 {
+		return first, nil
+	}
+	return terms, nil
+}
+
+func (p *filterParser) and() (condition, error) {
+	first, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	terms := andCondition{first}
+	for p.keyword("and") {
+		next, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, next)
+	}
+	if len(terms) == 1 {
+		return first, nil
+	}
+	return terms, nil
+}
+
+func (p *filterParser) not() (condition, error) {
+	if p.keyword("not") {
+		inner, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition{inner}, nil
+	}
+	return p.primary()
+}
+
+func (p *filterParser) primary() (condition, error) {
+	if p.symbol("(") {
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+	if p.keyword("exists") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		field, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		return &existsCondition{field: field}, p.expect(")")
+	}
+	if p.keyword("geo_radius") {
+		return p.geoRadius()
+	}
+	if p.keyword("match") {
+		return p.matchText()
+	}
+	field, err := p.field()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.keyword("in"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		c := &inCondition{field: field}
+		for {
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			c.values = append(c.values, value)
+			if !p.symbol(",") {
+				break
+			}
+		}
+		return c, p.expect(")")
+	case p.keyword("between"):
+		lo, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("and") {
+			return nil, p.unexpected("AND")
+		}
+		hi, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return &rangeCondition{field: field, lo: lo, hi: hi}, nil
+	}
+	op := p.peek()
+	if op.kind != tokenSymbol || !comparisonOps[op.text] {
+		return nil, p.unexpected("a comparison")
+	}
+	p.pos++
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return &compareCondition{field: field, op: op.text, value: value}, nil
+}
+
+func (p *filterParser) geoRadius() (condition, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	field, err := p.field()
+	if err != nil {
+		return nil, err
+	}
+	var args [3]float64
+	for i := range args {
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("filter: GEO_RADIUS expects numbers")
+		}
+		args[i] = n
+	}
+	if args[2] < 0 {
+		return nil, fmt.Errorf("filter: GEO_RADIUS radius must not be negative")
+	}
+	return &geoCondition{field: field, lat: args[0], lon: args[1], distance: args[2]}, p.expect(")")
+}
+
+func (p *filterParser) matchText() (condition, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	field, err := p.field()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	query, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("filter: MATCH expects a string")
+	}
+	return &matchCondition{field: field, query: query}, p.expect(")")
+}
+
+var comparisonOps = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+var filterKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "between": true,
+	"exists": true, "geo_radius": true, "match": true, "true": true, "false": true,
+}
+
+func (p *filterParser) field() (string, error) {
+	t := p.peek()
+	if t.kind != tokenWord || filterKeywords[strings.ToLower(t.text)] || !validFieldPath(t.text) {
+		return "", p.unexpected("a field")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *filterParser) value() (interface{}, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("filter: invalid number %q", t.text)
+		}
+		p.pos++
+		return n, nil
+	case t.kind == tokenString:
+		p.pos++
+		return t.text, nil
+	case p.keyword("true"):
+		return true, nil
+	case p.keyword("false"):
+		return false, nil
+	}
+	return nil, p.unexpected("a value")
+}
+
+// validFieldPath reports whether field is a dotted path without empty parts.
+func validFieldPath(field string) bool {
+	for _, name := range strings.Split(field, ".") {
+		if name == "" {
+			return false
+		}
+	}
+	return true
+}
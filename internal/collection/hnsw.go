@@ -0,0 +1,464 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// IndexType is how a collection finds the nearest points to a query.
+type IndexType string
+
+const (
+	// IndexFlat compares the query with every point: exact, and fine for
+	// small collections.
+	IndexFlat IndexType = "flat"
+	// IndexHNSW searches a hierarchical navigable small world graph:
+	// approximate, with recall traded for speed through ef.
+	IndexHNSW IndexType = "hnsw"
+)
+
+func ParseIndexType(s string) (IndexType, error) {
+	switch index := IndexType(strings.ToLower(s)); index {
+	case IndexFlat, IndexHNSW:
+		return index, nil
+	}
+	return "", fmt.Errorf("unknown index %q", s)
+}
+
+// IndexParams configures a collection's index. Zero values take defaults.
+type IndexParams struct {
+	Type IndexType `json:"type,omitempty"`
+	// M is the number of neighbours each HNSW node keeps per layer (twice
+	// that on the bottom layer).
+	M int `json:"m,omitempty"`
+	// EFConstruction is the candidate list size used when inserting.
+	EFConstruction int `json:"ef_construction,omitempty"`
+	// EFSearch is the default candidate list size used when searching.
+	EFSearch int `json:"ef_search,omitempty"`
+}
+
+func (p *IndexParams) setDefaults() {
+	if p.Type == "" {
+		p.Type = IndexFlat
+	}
+	if p.Type != IndexHNSW {
+		return
+	}
+	if p.M <= 0 {
+		p.M = 16
+	}
+	if p.EFConstruction <= 0 {
+		p.EFConstruction = 200
+	}
+	if p.EFSearch <= 0 {
+		p.EFSearch = 64
+	}
+}
+
+// hnsw is a hierarchical navigable small world graph over the points of a
+// collection, addressed by internal point ID. It is guarded by the
+// collection's mutex: mutations need it exclusively, searches shared.
+type hnsw struct {
+	params    IndexParams
+	levelMult float64
+	distance  func(a, b []float64) float64
+	vector    func(id uint64) []float64
+	rng       *rand.Rand
+
+	nodes    map[uint64]*hnswNode
+	entry    uint64
+	maxLevel int
+
+	// nsPerEF is a moving average of the bottom-layer search time per unit
+	// of ef, used to size ef to a deadline.
+	nsPerEF atomic.Int64
+}
+
+type hnswNode struct {
+	// neighbors holds the links of each layer the node is on.
+	neighbors [][]uint64
+}
+
+func newHNSW(params IndexParams, metric Metric, vector func(uint64) []float64) *hnsw {
+	return &hnsw{
+		params:    params,
+		levelMult: 1 / math.Log(float64(params.M)),
+		distance:  distanceFunc(metric),
+		vector:    vector,
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		nodes:     make(map[uint64]*hnswNode),
+	}
+}
+
+// hnswNodeBytes is roughly what a node of the graph takes besides its
+// links: its map entry, struct and the slice header of each layer it is
+// likely to be on.
+const hnswNodeBytes = 96
+
+// memory estimates the bytes the graph takes, assuming each node has a
+// full set of links on the bottom layer, which holds most of them.
+func (h *hnsw) memory() int64 {
+	return int64(len(h.nodes)) * int64(hnswNodeBytes+8*2*h.params.M)
+}
+
+type scored struct {
+	id       uint64
+	distance float64
+}
+
+// insert adds a point to the graph, replacing it if present.
+func (h *hnsw) insert(id uint64) {
+	if _, ok := h.nodes[id]; ok {
+		h.remove(id)
+	}
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelMult)
+	node := &hnswNode{neighbors: make([][]uint64, level+1)}
+	if len(h.nodes) == 0 {
+		h.nodes[id] = node
+		h.entry, h.maxLevel = id, level
+		return
+	}
+	h.nodes[id] = node
+
+	query := h.vector(id)
+	entry := scored{h.entry, h.distance(query, h.vector(h.entry))}
+	for l := h.maxLevel; l > level; l-- {
+		entry = h.greedy(query, entry, l)
+	}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates, _ := h.searchLayer(query, entry, h.params.EFConstruction, l, time.Time{})
+		node.neighbors[l] = h.closest(candidates, h.params.M)
+		for _, neighbor := range node.neighbors[l] {
+			h.link(neighbor, id, l)
+		}
+		entry = candidates[0]
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+}
+
+// link adds a link from node to target on layer l, dropping the node's
+// farthest link if it has too many.
+func (h *hnsw) link(node, target uint64, l int) {
+	n := h.nodes[node]
+	n.neighbors[l] = append(n.neighbors[l], target)
+	limit := h.maxLinks(l)
+	if len(n.neighbors[l]) <= limit {
+		return
+	}
+	origin := h.vector(node)
+	candidates := make([]scored, 0, len(n.neighbors[l]))
+	for _, id := range n.neighbors[l] {
+		if h.onLayer(id, l) {
+			candidates = append(candidates, scored{id, h.distance(origin, h.vector(id))})
+		}
+	}
+	n.neighbors[l] = h.closest(candidates, limit)
+}
+
+// maxLinks returns how many links a node keeps on layer l.
+func (h *hnsw) maxLinks(l int) int {
+	if l == 0 {
+		return 2 * h.params.M
+	}
+	return h.params.M
+}
+
+func (h *hnsw) closest(candidates []scored, n int) []uint64 {
+	slices.SortFunc(candidates, func(a, b scored) int { return compareDistance(a.distance, b.distance) })
+	ids := make([]uint64, 0, min(n, len(candidates)))
+	for _, c := range candidates[:min(n, len(candidates))] {
+		ids = append(ids, c.id)
+	}
+	return ids
+}
+
+// remove unlinks a point from the graph. Its neighbours that linked back
+// to it are linked to its other neighbours instead, so that the points
+// only reached through it stay reachable. Links to it that its neighbours
+// did not reciprocate are left behind and skipped by searches.
+func (h *hnsw) remove(id uint64) {
+	node, ok := h.nodes[id]
+	if !ok {
+		return
+	}
+	delete(h.nodes, id)
+	for l, neighbors := range node.neighbors {
+		for _, neighbor := range neighbors {
+			n, ok := h.nodes[neighbor]
+			if !ok || len(n.neighbors) <= l {
+				continue
+			}
+			linked := len(n.neighbors[l])
+			n.neighbors[l] = slices.DeleteFunc(n.neighbors[l], func(x uint64) bool { return x == id })
+			if len(n.neighbors[l]) < linked {
+				h.reconnect(neighbor, neighbors, l)
+			}
+		}
+	}
+	if id != h.entry {
+		return
+	}
+	h.maxLevel = 0
+	for other, n := range h.nodes {
+		if level := len(n.neighbors) - 1; level >= h.maxLevel {
+			h.entry, h.maxLevel = other, level
+		}
+	}
+}
+
+// reconnect relinks node on layer l, after it lost a link to a removed
+// point, to the closest of its remaining links and of the removed point's
+// links.
+func (h *hnsw) reconnect(node uint64, orphans []uint64, l int) {
+	n := h.nodes[node]
+	origin := h.vector(node)
+	seen := map[uint64]bool{node: true}
+	candidates := make([]scored, 0, len(n.neighbors[l])+len(orphans))
+	for _, id := range slices.Concat(n.neighbors[l], orphans) {
+		if seen[id] || !h.onLayer(id, l) {
+			continue
+		}
+		seen[id] = true
+		candidates = append(candidates, scored{id, h.distance(origin, h.vector(id))})
+	}
+	n.neighbors[l] = h.closest(candidates, h.maxLinks(l))
+}
+
+// onLayer reports whether id is in the graph on layer l. Links left behind
+// by remove may point to nodes that are gone, or that were re-inserted on
+// fewer layers.
+func (h *hnsw) onLayer(id uint64, l int) bool {
+	node, ok := h.nodes[id]
+	return ok && len(node.neighbors) > l
+}
+
+// greedy walks layer l towards query and returns the closest node found.
+func (h *hnsw) greedy(query []float64, entry scored, l int) scored {
+	for changed := true; changed; {
+		changed = false
+		for _, neighbor := range h.nodes[entry.id].neighbors[l] {
+			if !h.onLayer(neighbor, l) {
+				continue
+			}
+			if d := h.distance(query, h.vector(neighbor)); d < entry.distance {
+				entry, changed = scored{neighbor, d}, true
+			}
+		}
+	}
+	return entry
+}
+
+// searchDeadlineCheck is how many nodes a search visits between deadline
+// checks.
+const searchDeadlineCheck = 64
+
+// searchLayer returns up to ef nodes of layer l closest to query, closest
+// first. A search that runs past a non-zero deadline stops early and
+// reports it did.
+func (h *hnsw) searchLayer(query []float64, entry scored, ef, l int, deadline time.Time) ([]scored, bool) {
+	visited := map[uint64]bool{entry.id: true}
+	candidates := &scoredHeap{less: func(a, b float64) bool { return a < b }}
+	results := &scoredHeap{less: func(a, b float64) bool { return a > b }}
+	heap.Push(candidates, entry)
+	heap.Push(results, entry)
+	stopped := false
+	for visits := 0; candidates.Len() > 0; visits++ {
+		if !deadline.IsZero() && visits%searchDeadlineCheck == searchDeadlineCheck-1 && time.Now().After(deadline) {
+			stopped = true
+			break
+		}
+		current := heap.Pop(candidates).(scored)
+		if results.Len() >= ef && current.distance > results.items[0].distance {
+			break
+		}
+		for _, neighbor := range h.nodes[current.id].neighbors[l] {
+			if visited[neighbor] {
+				continue
+			}
+			visited[neighbor] = true
+			if !h.onLayer(neighbor, l) {
+				continue
+			}
+			d := h.distance(query, h.vector(neighbor))
+			if results.Len() < ef || d < results.items[0].distance {
+				heap.Push(candidates, scored{neighbor, d})
+				heap.Push(results, scored{neighbor, d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	found := results.items
+	slices.SortFunc(found, func(a, b scored) int { return compareDistance(a.distance, b.distance) })
+	return found, stopped
+}
+
+// search returns the k nodes closest to query. With a deadline, ef is first
+// lowered to what past searches suggest fits in the time left, and the
+// search stops when the deadline passes; either makes degraded true.
+func (h *hnsw) search(query []float64, k, ef int, deadline time.Time) ([]scored, bool) {
+	if len(h.nodes) == 0 || k <= 0 {
+		return nil, false
+	}
+	ef = max(ef, k)
+	degraded := false
+	entry := scored{h.entry, h.distance(query, h.vector(h.entry))}
+	for l := h.maxLevel; l > 0; l-- {
+		entry = h.greedy(query, entry, l)
+	}
+	if !deadline.IsZero() {
+		if perEF := h.nsPerEF.Load(); perEF > 0 {
+			if fit := int(time.Until(deadline).Nanoseconds() / perEF); fit < ef {
+				ef, degraded = max(fit, k), true
+			}
+		}
+	}
+	start := time.Now()
+	found, stopped := h.searchLayer(query, entry, ef, 0, deadline)
+	if !stopped {
+		sample := time.Since(start).Nanoseconds() / int64(ef)
+		if old := h.nsPerEF.Load(); old > 0 {
+			sample = (old*7 + sample) / 8
+		}
+		h.nsPerEF.Store(max(sample, 1))
+	}
+	return found[:min(k, len(found))], degraded || stopped
+}
+
+func compareDistance(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// scoredHeap is a heap of scored nodes ordered by less on distance.
+type scoredHeap struct {
+	items []scored
+	less  func(a, b float64) bool
+}
+
+func (h *scoredHeap) Len() int           { return len(h.items) }
+func (h *scoredHeap) Less(i, j int) bool { return h.less(h.items[i].distance, h.items[j].distance) }
+func (h *scoredHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *scoredHeap) Push(x interface{}) { h.items = append(h.items, x.(scored)) }
+func (h *scoredHeap) Pop() interface{} {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
+
+// encode appends the graph to data: the entry point and, for every node,
+// its ID and its links per layer, sorted and delta-encoded.
+func (h *hnsw) encode(data []byte) []byte {
+	data = binary.BigEndian.AppendUint64(data, h.entry)
+	data = binary.AppendUvarint(data, uint64(len(h.nodes)))
+	for id, node := range h.nodes {
+		data = binary.BigEndian.AppendUint64(data, id)
+		data = binary.AppendUvarint(data, uint64(len(node.neighbors)))
+		for _, neighbors := range node.neighbors {
+			sorted := slices.Clone(neighbors)
+			slices.Sort(sorted)
+			data = binary.AppendUvarint(data, uint64(len(sorted)))
+			var previous uint64
+			for _, neighbor := range sorted {
+				data = binary.AppendUvarint(data, neighbor-previous)
+				previous = neighbor
+			}
+		}
+	}
+	return data
+}
+
+// decode reads a graph written by encode, returning the rest of data.
+func (h *hnsw) decode(data []byte) ([]byte, error) {
+	uvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, errBadCheckpoint
+		}
+		data = data[n:]
+		return v, nil
+	}
+	if len(data) < 8 {
+		return nil, errBadCheckpoint
+	}
+	h.entry = binary.BigEndian.Uint64(data)
+	data = data[8:]
+	count, err := uvarint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < count; i++ {
+		if len(data) < 8 {
+			return nil, errBadCheckpoint
+		}
+		id := binary.BigEndian.Uint64(data)
+		data = data[8:]
+		layers, err := uvarint()
+		if err != nil || layers == 0 || layers > 64 {
+			return nil, errBadCheckpoint
+		}
+		node := &hnswNode{neighbors: make([][]uint64, layers)}
+		for l := range node.neighbors {
+			n, err := uvarint()
+			if err != nil || n > uint64(len(data)) {
+				return nil, errBadCheckpoint
+			}
+			var previous uint64
+			for j := uint64(0); j < n; j++ {
+				delta, err := uvarint()
+				if err != nil {
+					return nil, err
+				}
+				previous += delta
+				node.neighbors[l] = append(node.neighbors[l], previous)
+			}
+		}
+		h.nodes[id] = node
+		h.maxLevel = max(h.maxLevel, len(node.neighbors)-1)
+	}
+	if _, ok := h.nodes[h.entry]; !ok && len(h.nodes) > 0 {
+		return nil, errBadCheckpoint
+	}
+	if len(h.nodes) > 0 {
+		h.maxLevel = len(h.nodes[h.entry].neighbors) - 1
+	}
+	return data, nil
+}
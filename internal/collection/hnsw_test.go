@@ -0,0 +1,79 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestHNSWRecallAfterRemovals removes most of the points of a sparse graph
+// and checks that searches still find the nearest of the rest, which they
+// cannot if the neighbours of removed points are left unlinked.
+func TestHNSWRecallAfterRemovals(t *testing.T) {
+	const n, dim, k, queries = 2000, 16, 10, 100
+	vectors := randomVectors(n, dim)
+	params := IndexParams{Type: IndexHNSW, M: 6, EFConstruction: 50, EFSearch: 20}
+	params.setDefaults()
+	h := newHNSW(params, MetricL2, func(id uint64) []float64 { return vectors[id] })
+	for id := range vectors {
+		h.insert(uint64(id))
+	}
+	r := rand.New(rand.NewSource(2))
+	removed := make(map[uint64]bool)
+	for _, i := range r.Perm(n)[:n*9/10] {
+		h.remove(uint64(i))
+		removed[uint64(i)] = true
+	}
+
+	distance := distanceFunc(MetricL2)
+	hits := 0
+	for q := 0; q < queries; q++ {
+		query := make([]float64, dim)
+		for i := range query {
+			query[i] = r.Float64()
+		}
+		var exact []scored
+		for id, vector := range vectors {
+			if !removed[uint64(id)] {
+				exact = append(exact, scored{uint64(id), distance(query, vector)})
+			}
+		}
+		want := h.closest(exact, k)
+		found, _ := h.search(query, k, params.EFSearch, time.Time{})
+		for _, s := range found {
+			if removed[s.id] {
+				t.Fatalf("search found removed point %d", s.id)
+			}
+			if slices.Contains(want, s.id) {
+				hits++
+			}
+		}
+	}
+	if recall := float64(hits) / (queries * k); recall < 0.8 {
+		t.Fatalf("recall %.2f after removals, want at least 0.8", recall)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Collections live in a reserved part of the keyspace, behind a 0x00 byte
+// the server refuses at the start of the keys commands name:
+//
+//	\x00c:<collection>                       collection metadata (JSON)
+//	\x00t:<tenant>                           tenant settings (JSON)
+//	\x00s:collection                         last collection ID (uint64)
+//	\x00p <coll> a <point>                   outlier flag (see outlier.go)
+//	\x00p <coll> d <key>                     deferred point (see deferred.go)
+//	\x00p <coll> f <field> <value> <point>   field index entry (see fieldindex.go)
+//	\x00p <coll> h                           cardinality sketches (see cardinality.go)
+//	\x00p <coll> i <point> <field>           point field
+//	\x00p <coll> k <key>                     point ID of a key (uint64)
+//	\x00p <coll> o <seq>                     index op log entry (see oplog.go)
+//	\x00p <coll> s                           last point ID of the collection (uint64)
+//	\x00p <coll> t <field> <term> <point>    text index entry (see textindex.go)
+//	\x00p <coll> x                           index checkpoint (see oplog.go)
+//
+// <coll> is the collection ID as a uvarint, which is self-delimiting, so
+// everything a collection stores is contiguous and shares one short prefix.
+// Points are addressed by an internal uint64 ID, big-endian so the fields of
+// a point sort together, rather than by the user's key: the key is stored
+// once as the point's key field and in the key-to-ID mapping. DecodeKey
+// renders any of these keys for tooling.
+const (
+	collectionPrefix = "\x00c:"
+	tenantPrefix     = "\x00t:"
+	sequencePrefix   = "\x00s:"
+	pointPrefix      = "\x00p"
+
+	tagOutlier    byte = 'a'
+	tagDeferred   byte = 'd'
+	tagFieldIndex byte = 'f'
+	tagSketches   byte = 'h'
+	tagPoint      byte = 'i'
+	tagMapping    byte = 'k'
+	tagOpLog      byte = 'o'
+	tagSequence   byte = 's'
+	tagTextIndex  byte = 't'
+	tagCheckpoint byte = 'x'
+
+	// Fields sort in this order, so a scan sees a point's key and payload
+	// before its vector.
+	fieldKey     byte = 'k'
+	fieldPayload byte = 'p'
+	fieldVector  byte = 'v'
+)
+
+var collectionIDKey = []byte(sequencePrefix + "collection")
+
+func collectionKey(name string) []byte {
+	return []byte(collectionPrefix + name)
+}
+
+func tenantKey(name string) []byte {
+	return []byte(tenantPrefix + name)
+}
+
+// collectionSpace is the prefix of every key a collection stores.
+func collectionSpace(collection uint64) []byte {
+	return binary.AppendUvarint([]byte(pointPrefix), collection)
+}
+
+// pointsPrefix is the prefix of the point fields of a collection.
+func pointsPrefix(collection uint64) []byte {
+	return append(collectionSpace(collection), tagPoint)
+}
+
+func fieldKeyOf(collection, point uint64, field byte) []byte {
+	key := binary.BigEndian.AppendUint64(pointsPrefix(collection), point)
+	return append(key, field)
+}
+
+func vectorKey(collection, point uint64) []byte {
+	return fieldKeyOf(collection, point, fieldVector)
+}
+
+func payloadKey(collection, point uint64) []byte {
+	return fieldKeyOf(collection, point, fieldPayload)
+}
+
+func pointKeyKey(collection, point uint64) []byte {
+	return fieldKeyOf(collection, point, fieldKey)
+}
+
+func mappingKey(collection uint64, key string) []byte {
+	return append(append(collectionSpace(collection), tagMapping), key...)
+}
+
+func pointSequenceKey(collection uint64) []byte {
+	return append(collectionSpace(collection), tagSequence)
+}
+
+func opLogKey(collection, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(append(collectionSpace(collection), tagOpLog), seq)
+}
+
+func checkpointKey(collection uint64) []byte {
+	return append(collectionSpace(collection), tagCheckpoint)
+}
+
+func outlierKey(collection, point uint64) []byte {
+	return binary.BigEndian.AppendUint64(append(collectionSpace(collection), tagOutlier), point)
+}
+
+func sketchesKey(collection uint64) []byte {
+	return append(collectionSpace(collection), tagSketches)
+}
+
+var errMalformedKey = errors.New("malformed collection key")
+
+// parseFieldKey splits a point field key into its collection ID, point ID
+// and field.
+func parseFieldKey(key []byte) (collection, point uint64, field byte, err error) {
+	collection, rest, err := parseSpaceKey(key)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if len(rest) != 10 || rest[0] != tagPoint {
+		return 0, 0, 0, errMalformedKey
+	}
+	return collection, binary.BigEndian.Uint64(rest[1:9]), rest[9], nil
+}
+
+// parseSpaceKey splits a key of a collection's space into the collection
+// ID and the rest of the key.
+func parseSpaceKey(key []byte) (uint64, []byte, error) {
+	if len(key) < len(pointPrefix) || string(key[:len(pointPrefix)]) != pointPrefix {
+		return 0, nil, errMalformedKey
+	}
+	collection, n := binary.Uvarint(key[len(pointPrefix):])
+	if n <= 0 {
+		return 0, nil, errMalformedKey
+	}
+	rest := key[len(pointPrefix)+n:]
+	if len(rest) == 0 {
+		return 0, nil, errMalformedKey
+	}
+	return collection, rest, nil
+}
+
+// DecodeKey describes a key of the collection keyspace in readable form, for
+// debugging tools. It fails for keys outside the keyspace.
+func DecodeKey(key []byte) (string, error) {
+	s := string(key)
+	switch {
+	case len(s) > len(collectionPrefix) && s[:len(collectionPrefix)] == collectionPrefix:
+		return "collection " + strconv.Quote(s[len(collectionPrefix):]), nil
+	case len(s) > len(tenantPrefix) && s[:len(tenantPrefix)] == tenantPrefix:
+		return "tenant " + strconv.Quote(s[len(tenantPrefix):]), nil
+	case len(s) > len(sequencePrefix) && s[:len(sequencePrefix)] == sequencePrefix:
+		return "sequence " + strconv.Quote(s[len(sequencePrefix):]), nil
+	}
+	collection, rest, err := parseSpaceKey(key)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a collection key", key)
+	}
+	switch rest[0] {
+	case tagMapping:
+		return fmt.Sprintf("mapping collection=%d key=%s", collection, strconv.Quote(string(rest[1:]))), nil
+	case tagSequence:
+		if len(rest) == 1 {
+			return fmt.Sprintf("sequence collection=%d", collection), nil
+		}
+	case tagCheckpoint:
+		if len(rest) == 1 {
+			return fmt.Sprintf("checkpoint collection=%d", collection), nil
+		}
+	case tagOutlier:
+		if len(rest) == 9 {
+			return fmt.Sprintf("outlier collection=%d point=%d", collection, binary.BigEndian.Uint64(rest[1:])), nil
+		}
+	case tagDeferred:
+		return fmt.Sprintf("deferred collection=%d key=%s", collection, strconv.Quote(string(rest[1:]))), nil
+	case tagSketches:
+		if len(rest) == 1 {
+			return fmt.Sprintf("sketches collection=%d", collection), nil
+		}
+	case tagFieldIndex:
+		length, n := binary.Uvarint(rest[1:])
+		if n > 0 && uint64(len(rest)-1-n) >= length+8 {
+			field := string(rest[1+n : 1+n+int(length)])
+			point := binary.BigEndian.Uint64(rest[len(rest)-8:])
+			return fmt.Sprintf("field index collection=%d field=%s point=%d", collection, strconv.Quote(field), point), nil
+		}
+	case tagTextIndex:
+		length, n := binary.Uvarint(rest[1:])
+		if n > 0 && uint64(len(rest)-1-n) >= length+8 {
+			field := string(rest[1+n : 1+n+int(length)])
+			point := binary.BigEndian.Uint64(rest[len(rest)-8:])
+			return fmt.Sprintf("text index collection=%d field=%s point=%d", collection, strconv.Quote(field), point), nil
+		}
+	case tagOpLog:
+		if len(rest) == 9 {
+			return fmt.Sprintf("oplog collection=%d seq=%d", collection, binary.BigEndian.Uint64(rest[1:])), nil
+		}
+	case tagPoint:
+		if _, point, field, err := parseFieldKey(key); err == nil {
+			name := "field " + strconv.QuoteRune(rune(field))
+			switch field {
+			case fieldKey:
+				name = "key"
+			case fieldPayload:
+				name = "payload"
+			case fieldVector:
+				name = "vector"
+			}
+			return fmt.Sprintf("point collection=%d point=%d %s", collection, point, name), nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a collection key", key)
+}
+
+// Span returns the key range holding everything c stores, for compacting it
+// away after a drop.
+func (c *Collection) Span() (start, end []byte) {
+	prefix := collectionSpace(c.ID)
+	return prefix, prefixEnd(prefix)
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
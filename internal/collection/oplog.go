@@ -0,0 +1,345 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"readpebble/internal/storage"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// The in-memory index of a collection is restored at startup from its last
+// checkpoint plus the op log. Every write that changes the index records the
+// change as an op log entry in the same batch as the data, so a crash can
+// never leave the two disagreeing: replaying the entries after the
+// checkpoint brings the index up to date without scanning every point.
+// Checkpoint persists the index and trims the entries it covers.
+//
+// An op log entry is the op byte followed by the point ID. Replaying an entry
+// re-reads the point from Pebble, so replay is idempotent and entries that
+// a crash left behind a checkpoint do no harm.
+const (
+	opUpsert byte = 'u'
+	opDelete byte = 'd'
+)
+
+var errBadCheckpoint = errors.New("malformed index checkpoint")
+
+// logOp adds an index mutation to batch. The manager's writeMutex must be
+// held.
+func (c *Collection) logOp(batch *pebble.Batch, op byte, id uint64) {
+	seq := c.lastOp.Add(1)
+	batch.Set(opLogKey(c.ID, seq), binary.BigEndian.AppendUint64([]byte{op}, id), nil)
+}
+
+// PendingOps returns the number of op log entries not yet covered by a
+// checkpoint, across every collection.
+func (m *Manager) PendingOps() int64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var pending int64
+	for _, c := range m.collections {
+		pending += int64(c.lastOp.Load() - c.checkpointed.Load())
+	}
+	return pending
+}
+
+// Checkpoint persists the index of every collection written since its last
+// checkpoint and deletes the op log entries the checkpoints cover. Writes to
+// a collection wait while it is checkpointed.
+func (m *Manager) Checkpoint() error {
+	m.mutex.RLock()
+	collections := make([]*Collection, 0, len(m.collections))
+	for _, c := range m.collections {
+		collections = append(collections, c)
+	}
+	m.mutex.RUnlock()
+
+	for _, c := range collections {
+		if err := m.checkpoint(c); err != nil {
+			return fmt.Errorf("collection %q: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) checkpoint(c *Collection) error {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	if _, err := m.Get(c.Name); err != nil {
+		// Dropped since Checkpoint listed it.
+		return nil
+	}
+	if c.lastOp.Load() == c.checkpointed.Load() {
+		return nil
+	}
+	return m.writeCheckpoint(c)
+}
+
+// writeCheckpoint checkpoints c and trims its op log. The manager's
+// writeMutex must be held.
+func (m *Manager) writeCheckpoint(c *Collection) error {
+	seq := c.lastOp.Load()
+	index := c.index
+	if c.demoted {
+		// The op log only brings the graph of the last checkpoint up to
+		// date until it is trimmed, so the new one must hold the graph too.
+		var err error
+		if index, err = m.reloadIndex(c); err != nil {
+			return err
+		}
+	}
+	c.mutex.RLock()
+	data := c.encodeCheckpoint(seq, index)
+	c.mutex.RUnlock()
+
+	batch := m.db.NewBatch()
+	defer batch.Close()
+	batch.Set(checkpointKey(c.ID), data, nil)
+	batch.Set(sketchesKey(c.ID), c.encodeSketches(seq), nil)
+	if err := batch.DeleteRange(opLogKey(c.ID, 0), opLogKey(c.ID, seq+1), nil); err != nil {
+		return err
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+	c.checkpointed.Store(seq)
+	return nil
+}
+
+// encodeCheckpoint serializes the index as the op log sequence it covers,
+// the points, each as its ID, key, last search time, size and vector, and
+// the HNSW graph index if the collection has one. c.mutex must be held.
+func (c *Collection) encodeCheckpoint(seq uint64, index *hnsw) []byte {
+	data := binary.BigEndian.AppendUint64(nil, seq)
+	data = binary.AppendUvarint(data, uint64(len(c.points)))
+	for id, p := range c.points {
+		data = binary.BigEndian.AppendUint64(data, id)
+		data = binary.AppendUvarint(data, uint64(len(p.key)))
+		data = append(data, p.key...)
+		data = binary.AppendVarint(data, p.lastSearched.Load())
+		data = binary.AppendUvarint(data, uint64(p.size))
+		data = append(data, storage.EncodeVector(p.vector)...)
+	}
+	if index != nil {
+		data = index.encode(append(data, 1))
+	}
+	return data
+}
+
+// decodeCheckpoint loads the points of a checkpoint into c, which must be
+// empty.
+func (c *Collection) decodeCheckpoint(data []byte) error {
+	// The graph is restored as saved rather than rebuilt point by point.
+	index := c.index
+	c.index = nil
+	seq, data, err := decodeCheckpointPoints(data, c.Dimension, func(id uint64, p *point) {
+		c.put(id, p)
+		c.lastPoint = max(c.lastPoint, id)
+	})
+	if err != nil {
+		return err
+	}
+	c.index = index
+	switch {
+	case len(data) == 0 && index != nil:
+		// Written before the collection had a graph.
+		for id := range c.points {
+			index.insert(id)
+		}
+	case len(data) > 0 && data[0] == 1 && index != nil:
+		rest, err := index.decode(data[1:])
+		if err != nil || len(rest) != 0 || len(index.nodes) != len(c.points) {
+			return errBadCheckpoint
+		}
+	case len(data) != 0:
+		return errBadCheckpoint
+	}
+	c.lastOp.Store(seq)
+	c.checkpointed.Store(seq)
+	return nil
+}
+
+// decodeCheckpointPoints reads the op log sequence and the points of a
+// checkpoint of a collection of dim dimensions, passing each point to fn,
+// and returns the rest of data. With a nil fn, the points are skipped.
+func decodeCheckpointPoints(data []byte, dim int, fn func(id uint64, p *point)) (uint64, []byte, error) {
+	if len(data) < 8 {
+		return 0, nil, errBadCheckpoint
+	}
+	seq := binary.BigEndian.Uint64(data)
+	data = data[8:]
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, errBadCheckpoint
+	}
+	data = data[n:]
+	vectorSize := dim * 8
+	for i := uint64(0); i < count; i++ {
+		if len(data) < 8 {
+			return 0, nil, errBadCheckpoint
+		}
+		id := binary.BigEndian.Uint64(data)
+		data = data[8:]
+		keyLen, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < keyLen {
+			return 0, nil, errBadCheckpoint
+		}
+		key := data[n : n+int(keyLen)]
+		data = data[n+int(keyLen):]
+		searched, n := binary.Varint(data)
+		if n <= 0 {
+			return 0, nil, errBadCheckpoint
+		}
+		data = data[n:]
+		size, n := binary.Uvarint(data)
+		if n <= 0 || len(data)-n < vectorSize {
+			return 0, nil, errBadCheckpoint
+		}
+		encoded := data[n : n+vectorSize]
+		data = data[n+vectorSize:]
+		if fn == nil {
+			continue
+		}
+		vector, err := storage.DecodeVector(encoded)
+		if err != nil {
+			return 0, nil, err
+		}
+		p := &point{key: string(key), vector: vector, size: int64(size)}
+		p.lastSearched.Store(searched)
+		fn(id, p)
+	}
+	return seq, data, nil
+}
+
+// loadCollection restores the index of a collection from its checkpoint
+// and op log, falling back to scanning its points when there is no usable
+// checkpoint.
+func (m *Manager) loadCollection(info Info) (*Collection, error) {
+	c := newCollection(info)
+	value, closer, err := m.db.Get(checkpointKey(c.ID))
+	switch err {
+	case nil:
+		err = c.decodeCheckpoint(value)
+		closer.Close()
+		if err == nil {
+			err = m.loadSketches(c)
+		} else {
+			// The points are the source of truth; a checkpoint that can't
+			// be read only costs a scan.
+			c = newCollection(info)
+			err = m.scanPoints(c)
+		}
+	case pebble.ErrNotFound:
+		err = m.scanPoints(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Sketches are built before the replay, which would otherwise create
+	// them with only the values it replays.
+	if err := m.buildSketches(c); err != nil {
+		return nil, err
+	}
+	if err := m.replayOps(c); err != nil {
+		return nil, err
+	}
+	// Points created and deleted again leave no trace but the sequence.
+	value, found, err := m.get(pointSequenceKey(c.ID))
+	if err != nil {
+		return nil, err
+	}
+	if found && len(value) == 8 {
+		c.lastPoint = max(c.lastPoint, binary.BigEndian.Uint64(value))
+	}
+	if c.OutlierThreshold > 0 {
+		c.resetOutlierStats()
+	}
+	return c, nil
+}
+
+// replayOps applies the op log entries after the checkpoint to the index.
+func (m *Manager) replayOps(c *Collection) error {
+	iter, err := m.db.NewIter(&pebble.IterOptions{
+		LowerBound: opLogKey(c.ID, c.checkpointed.Load()+1),
+		UpperBound: prefixEnd(append(collectionSpace(c.ID), tagOpLog)),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if len(value) != 9 {
+			return fmt.Errorf("op log entry %q: %w", key, errMalformedKey)
+		}
+		if err := m.refreshPoint(c, binary.BigEndian.Uint64(value[1:])); err != nil {
+			return err
+		}
+		c.lastOp.Store(binary.BigEndian.Uint64(key[len(key)-8:]))
+	}
+	return iter.Error()
+}
+
+// refreshPoint makes the index entry of a point match what Pebble holds.
+func (m *Manager) refreshPoint(c *Collection, id uint64) error {
+	value, found, err := m.get(vectorKey(c.ID, id))
+	if err != nil || !found {
+		c.remove(id)
+		return err
+	}
+	vector, err := storage.DecodeVector(value)
+	if err != nil {
+		return fmt.Errorf("point %d: %w", id, err)
+	}
+	key, _, err := m.get(pointKeyKey(c.ID, id))
+	if err != nil {
+		return err
+	}
+	payload, _, err := m.get(payloadKey(c.ID, id))
+	if err != nil {
+		return err
+	}
+	c.put(id, newPoint(string(key), vector, c.pointSize(id, string(key), value, payload)))
+	c.lastPoint = max(c.lastPoint, id)
+	c.addPayload(c.Fields, payload)
+	return nil
+}
+
+// get returns a copy of the value of key.
+func (m *Manager) get(key []byte) ([]byte, bool, error) {
+	value, closer, err := m.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer closer.Close()
+	return append([]byte{}, value...), true, nil
+}
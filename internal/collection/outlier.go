@@ -0,0 +1,184 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// A collection with an OutlierThreshold flags points whose vector is
+// unusually far from the centroid of the collection when written, a cheap
+// guard against an embedding pipeline gone wrong. Each flagged point has a
+// key holding how far out it was, in standard deviations, written in the
+// same batch as the point:
+//
+//	\x00p <coll> a <point>   outlier score (float64)
+//
+// The centroid is kept exact as points come and go. The distances are
+// summarized by their mean and variance, computed from every point at
+// startup and then updated with the distance of each written point as it
+// was written.
+const (
+	// outlierMinPoints is how many points a collection needs before any
+	// is flagged, so that the first few do not define normal.
+	outlierMinPoints = 100
+)
+
+// outlierDetector tracks the centroid of a collection and the distribution
+// of distances to it. c.mutex guards it.
+type outlierDetector struct {
+	sum   []float64
+	count int
+	// n, mean and m2 are Welford's running statistics of the distances.
+	n, mean, m2 float64
+}
+
+func (d *outlierDetector) add(vector []float64, sign float64) {
+	if d.sum == nil {
+		d.sum = make([]float64, len(vector))
+	}
+	for i, x := range vector {
+		d.sum[i] += sign * x
+	}
+	d.count += int(sign)
+}
+
+// observe adds a distance to the statistics.
+func (d *outlierDetector) observe(distance float64) {
+	d.n++
+	delta := distance - d.mean
+	d.mean += delta / d.n
+	d.m2 += delta * (distance - d.mean)
+}
+
+// outlierDistance is the distance outliers are measured by: that of the
+// collection, except that inner product, not being a distance, is replaced
+// by L2.
+func outlierDistance(metric Metric) func(a, b []float64) float64 {
+	if metric == MetricIP {
+		return l2Distance
+	}
+	return distanceFunc(metric)
+}
+
+// outlierScore returns the distance of vector to the centroid and how many
+// standard deviations that is above the mean distance, 0 while there are
+// too few points to tell. replaced, if not nil, is the vector it replaces,
+// left out of the centroid. c.mutex must be held.
+func (c *Collection) outlierScore(vector, replaced []float64) (distance, score float64) {
+	d := &c.outliers
+	count := d.count
+	if replaced != nil {
+		count--
+	}
+	if count <= 0 {
+		return 0, 0
+	}
+	centroid := make([]float64, len(d.sum))
+	for i, x := range d.sum {
+		if replaced != nil {
+			x -= replaced[i]
+		}
+		centroid[i] = x / float64(count)
+	}
+	distance = outlierDistance(c.Metric)(vector, centroid)
+	if count < outlierMinPoints || d.n < 2 {
+		return distance, 0
+	}
+	std := math.Sqrt(d.m2 / (d.n - 1))
+	if std == 0 {
+		return distance, 0
+	}
+	return distance, (distance - d.mean) / std
+}
+
+// resetOutlierStats recomputes the distance statistics from every point.
+// c.mutex must be held.
+func (c *Collection) resetOutlierStats() {
+	d := &c.outliers
+	d.n, d.mean, d.m2 = 0, 0, 0
+	if d.count == 0 {
+		return
+	}
+	centroid := make([]float64, len(d.sum))
+	for i, x := range d.sum {
+		centroid[i] = x / float64(d.count)
+	}
+	distance := outlierDistance(c.Metric)
+	for _, p := range c.points {
+		d.observe(distance(p.vector, centroid))
+	}
+}
+
+// Outlier is a point flagged as an outlier when it was written.
+type Outlier struct {
+	Key string
+	// Score is how many standard deviations its distance to the centroid
+	// was above the mean.
+	Score float64
+}
+
+// Outliers returns up to limit of the points of collection flagged as
+// outliers, in the order they were created, and how many there are.
+func (m *Manager) Outliers(collection string, limit int) ([]Outlier, int, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, 0, err
+	}
+	prefix := append(collectionSpace(c.ID), tagOutlier)
+	iter, err := m.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer iter.Close()
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var outliers []Outlier
+	total := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if len(key) != len(prefix)+8 || len(value) != 8 {
+			continue
+		}
+		p, ok := c.points[binary.BigEndian.Uint64(key[len(prefix):])]
+		if !ok {
+			continue
+		}
+		total++
+		if len(outliers) < limit {
+			score := math.Float64frombits(binary.BigEndian.Uint64(value))
+			outliers = append(outliers, Outlier{Key: p.key, Score: score})
+		}
+	}
+	return outliers, total, iter.Error()
+}
+
+// OutliersFlagged returns how many written points were flagged as outliers
+// since startup.
+func (m *Manager) OutliersFlagged() int64 {
+	return m.outliers.Load()
+}
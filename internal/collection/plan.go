@@ -0,0 +1,277 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// indexSelectivity is the largest fraction of a collection's points a
+// condition may be expected to match for the planner to look it up in a
+// secondary index. Less selective conditions are cheaper to check against
+// the payloads of the points visited, and for hnsw collections leave enough
+// matches among the nearest neighbours for post-filtering to find k.
+const indexSelectivity = 0.3
+
+// queryPlan is how a filtered read finds its points. When the secondary
+// indexes can answer selective parts of the filter, only the candidates they
+// return are visited, and the payload of each is checked unless the indexes
+// answered the whole filter. Otherwise every point is visited and checked
+// against its payload, which for hnsw collections means searching for more
+// neighbours than wanted and dropping those that do not match
+// (post-filtering). The choice relies on the estimates of stats.go.
+type queryPlan struct {
+	filter *Filter
+	// candidates are the points the indexes narrowed the filter down to,
+	// nil to visit every point.
+	candidates map[uint64]struct{}
+	// exact is set when candidates match the filter without checking
+	// their payloads.
+	exact bool
+	// explain describes the plan and the estimates behind it.
+	explain []string
+}
+
+func (p *queryPlan) explainf(format string, args ...interface{}) {
+	p.explain = append(p.explain, fmt.Sprintf(format, args...))
+}
+
+// plan chooses how to apply filter, which may be nil, to c. Each condition
+// of a top-level AND is looked up in an index if it can be and is expected
+// to be selective, most selective first; the others are checked against
+// the payloads of the candidates.
+func (m *Manager) plan(c *Collection, filter *Filter) (*queryPlan, error) {
+	if filter == nil {
+		p := &queryPlan{exact: true}
+		p.explainf("strategy: all points")
+		return p, nil
+	}
+	c.bindAnalyzers(filter.root)
+	stats, err := m.stats(c)
+	if err != nil {
+		return nil, err
+	}
+	points := float64(c.Len())
+	p := &queryPlan{filter: filter}
+	rows, _ := stats.estimate(filter.root, points)
+	p.explainf("filter: %s", formatCondition(filter.root))
+	p.explainf("points: %d, estimated matches: %.0f", int(points), math.Min(rows, points))
+
+	conjuncts := []condition{filter.root}
+	if and, ok := filter.root.(andCondition); ok {
+		conjuncts = and
+	}
+	type lookup struct {
+		cond condition
+		rows float64
+	}
+	var lookups []lookup
+	residual := false
+	for _, cond := range conjuncts {
+		rows, indexable := stats.estimate(cond, points)
+		switch {
+		case !indexable:
+			residual = true
+			p.explainf("check %s: no index", formatCondition(cond))
+		case rows > points*indexSelectivity:
+			residual = true
+			p.explainf("check %s: estimated %.0f matches, not selective enough for an index", formatCondition(cond), rows)
+		default:
+			lookups = append(lookups, lookup{cond, rows})
+		}
+	}
+	if len(lookups) == 0 {
+		p.explainf("strategy: post-filter, checking the payload of every point visited")
+		return p, nil
+	}
+	slices.SortStableFunc(lookups, func(a, b lookup) int { return compareDistance(a.rows, b.rows) })
+
+	exact := !residual
+	for _, l := range lookups {
+		ids, _, lookupExact, err := m.lookupCondition(c, l.cond)
+		if err != nil {
+			return nil, err
+		}
+		exact = exact && lookupExact
+		p.explainf("index lookup %s: estimated %.0f, found %d", formatCondition(l.cond), l.rows, len(ids))
+		if p.candidates == nil {
+			p.candidates = ids
+			continue
+		}
+		for id := range p.candidates {
+			if _, ok := ids[id]; !ok {
+				delete(p.candidates, id)
+			}
+		}
+	}
+	p.exact = exact
+	if exact {
+		p.explainf("strategy: index lookup, %d matches", len(p.candidates))
+	} else {
+		p.explainf("strategy: index lookup, then checking the payloads of %d candidates", len(p.candidates))
+	}
+	return p, nil
+}
+
+// Explain describes how filter would be applied to collection, one line
+// per step, including how a search would use it.
+func (m *Manager) Explain(collection string, filter *Filter) ([]string, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, err
+	}
+	p, err := m.plan(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	graph := c.Index.Type == IndexHNSW
+	switch {
+	case p.candidates != nil:
+		p.explainf("search: exact scan of the candidates")
+	case graph && c.isDemoted():
+		p.explainf("search: hnsw graph, demoted to disk to keep within the index memory budget and reloaded first")
+	case graph && !p.exact:
+		p.explainf("search: hnsw graph, asking for %dx the neighbours wanted until enough match", postFilterOversampling)
+	case graph:
+		p.explainf("search: hnsw graph")
+	default:
+		p.explainf("search: exact scan of every point")
+	}
+	return p.explain, nil
+}
+
+// matcher checks points against a plan, reading their payloads when
+// needed. It keeps the first error reading a payload, after which no point
+// matches.
+type matcher struct {
+	m    *Manager
+	c    *Collection
+	plan *queryPlan
+	err  error
+}
+
+func (mt *matcher) match(id uint64) bool {
+	if mt.plan.candidates != nil {
+		if _, ok := mt.plan.candidates[id]; !ok {
+			return false
+		}
+	}
+	if mt.plan.exact {
+		return true
+	}
+	if mt.err != nil {
+		return false
+	}
+	payload, _, err := mt.m.get(payloadKey(mt.c.ID, id))
+	if err != nil {
+		mt.err = err
+		return false
+	}
+	return mt.plan.filter.Match(payload)
+}
+
+// ids returns the points the plan visits that are still in c, in ID
+// order. c.mutex must be held.
+func (mt *matcher) ids() []uint64 {
+	var ids []uint64
+	if mt.plan.candidates != nil {
+		ids = make([]uint64, 0, len(mt.plan.candidates))
+		for id := range mt.plan.candidates {
+			if _, ok := mt.c.points[id]; ok {
+				ids = append(ids, id)
+			}
+		}
+	} else {
+		ids = make([]uint64, 0, len(mt.c.points))
+		for id := range mt.c.points {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Count returns the number of points of collection matching filter, or of
+// all points if filter is nil.
+func (m *Manager) Count(collection string, filter *Filter) (int, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return 0, err
+	}
+	plan, err := m.plan(c, filter)
+	if err != nil {
+		return 0, err
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if plan.candidates == nil && plan.exact {
+		return len(c.points), nil
+	}
+	mt := &matcher{m: m, c: c, plan: plan}
+	n := 0
+	for _, id := range mt.ids() {
+		if mt.match(id) {
+			n++
+		}
+	}
+	return n, mt.err
+}
+
+// Scroll pages through the points of collection matching filter, which may
+// be nil, in an order that is stable across calls. It returns the keys of
+// up to count points after cursor, 0 for the first page, and the cursor of
+// the next page, 0 once there are no more points.
+func (m *Manager) Scroll(collection string, cursor uint64, count int, filter *Filter) ([]string, uint64, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, 0, err
+	}
+	if count <= 0 {
+		return nil, cursor, nil
+	}
+	plan, err := m.plan(c, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	mt := &matcher{m: m, c: c, plan: plan}
+	ids := mt.ids()
+	i, _ := slices.BinarySearch(ids, cursor+1)
+	var keys []string
+	for ; i < len(ids) && len(keys) < count; i++ {
+		if mt.match(ids[i]) {
+			keys = append(keys, c.points[ids[i]].key)
+		}
+	}
+	if mt.err != nil {
+		return nil, 0, mt.err
+	}
+	if i == len(ids) {
+		return keys, 0, nil
+	}
+	return keys, ids[i-1], nil
+}
@@ -0,0 +1,210 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/storage"
+)
+
+// PointSnapshot is a view of the points of a collection as of when it was
+// taken, for analytic scans that run for long and must not see the writes
+// made meanwhile. Until it is closed, it keeps compactions from reclaiming
+// the data it reads.
+type PointSnapshot struct {
+	// Info is the collection the snapshot is of.
+	Info Info
+	// Len is how many points the collection had when it was taken.
+	Len  int
+	snap *pebble.Snapshot
+}
+
+// SnapshotPoints returns a snapshot of the points of a collection, which
+// must be closed once done with.
+func (m *Manager) SnapshotPoints(collection string) (*PointSnapshot, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, err
+	}
+	return &PointSnapshot{Info: c.Info, Len: c.Len(), snap: m.db.NewSnapshot()}, nil
+}
+
+// Close releases the snapshot.
+func (p *PointSnapshot) Close() error {
+	return p.snap.Close()
+}
+
+// Scan calls fn with up to limit points from cursor on, in the order they
+// were first added, and returns the cursor to go on from, 0 once there are
+// no more points. The first call passes cursor 0. vector and payload are
+// only valid until fn returns; payload is nil when the point has none.
+func (p *PointSnapshot) Scan(cursor uint64, limit int, fn func(key string, vector []float64, payload []byte)) (uint64, error) {
+	prefix := pointsPrefix(p.Info.ID)
+	lower := binary.BigEndian.AppendUint64(append([]byte{}, prefix...), cursor)
+	iter, err := p.snap.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	// As in scanPoints, a point's key and payload fields come before its
+	// vector, which ends the point.
+	var key string
+	var payload []byte
+	n := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		_, id, field, err := parseFieldKey(iter.Key())
+		if err != nil {
+			return 0, fmt.Errorf("key %q: %w", iter.Key(), err)
+		}
+		switch field {
+		case fieldKey:
+			if n == limit {
+				return id, nil
+			}
+			key, payload = string(iter.Value()), nil
+		case fieldPayload:
+			payload = iter.Value()
+		case fieldVector:
+			vector, err := storage.DecodeVector(iter.Value())
+			if err != nil {
+				return 0, fmt.Errorf("point %q: %w", key, err)
+			}
+			fn(key, vector, payload)
+			key, payload = "", nil
+			n++
+		}
+	}
+	return 0, iter.Error()
+}
+
+// Analysis is a computation over the points of a collection, which an
+// analytic scan adds one at a time.
+type Analysis interface {
+	Add(key string, vector []float64, payload []byte)
+}
+
+// NormStats summarizes the L2 norms of vectors, those with a component that
+// is not a finite number aside.
+type NormStats struct {
+	Points    int64
+	Zero      int64
+	NonFinite int64
+	Min, Max  float64
+	// mean and m2 are the running mean and sum of squared differences
+	// from it of Welford's algorithm, as in outlierDetector.
+	mean, m2 float64
+}
+
+func (s *NormStats) Add(_ string, vector []float64, _ []byte) {
+	norm := math.Sqrt(dot(vector, vector))
+	if math.IsNaN(norm) || math.IsInf(norm, 0) {
+		s.NonFinite++
+		return
+	}
+	if norm == 0 {
+		s.Zero++
+	}
+	if s.Points == 0 || norm < s.Min {
+		s.Min = norm
+	}
+	if s.Points == 0 || norm > s.Max {
+		s.Max = norm
+	}
+	s.Points++
+	delta := norm - s.mean
+	s.mean += delta / float64(s.Points)
+	s.m2 += delta * (norm - s.mean)
+}
+
+// Mean returns the mean norm.
+func (s *NormStats) Mean() float64 {
+	return s.mean
+}
+
+// StdDev returns the standard deviation of the norms.
+func (s *NormStats) StdDev() float64 {
+	if s.Points == 0 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.Points))
+}
+
+// ExactNeighbors finds the points nearest to a query by comparing it to
+// every point, as a search without an index would, to re-score the results
+// of the index against.
+type ExactNeighbors struct {
+	query    []float64
+	k        int
+	distance func(a, b []float64) float64
+	// farthest holds the nearest points so far, the farthest of them on
+	// top.
+	farthest resultHeap
+}
+
+// NewExactNeighbors returns an ExactNeighbors finding the k points nearest
+// to query by metric.
+func NewExactNeighbors(metric Metric, query []float64, k int) *ExactNeighbors {
+	return &ExactNeighbors{query: query, k: k, distance: distanceFunc(metric)}
+}
+
+func (e *ExactNeighbors) Add(key string, vector []float64, _ []byte) {
+	if len(vector) != len(e.query) {
+		return
+	}
+	distance := e.distance(e.query, vector)
+	if len(e.farthest) < e.k {
+		heap.Push(&e.farthest, Result{ID: key, Score: distance})
+	} else if len(e.farthest) > 0 && distance < e.farthest[0].Score {
+		e.farthest[0] = Result{ID: key, Score: distance}
+		heap.Fix(&e.farthest, 0)
+	}
+}
+
+// Results returns the nearest points found, nearest first.
+func (e *ExactNeighbors) Results() []Result {
+	results := slices.Clone(e.farthest)
+	slices.SortStableFunc(results, func(a, b Result) int { return compareDistance(a.Score, b.Score) })
+	return results
+}
+
+// resultHeap is a heap of results, the farthest on top.
+type resultHeap []Result
+
+func (h resultHeap) Len() int            { return len(h) }
+func (h resultHeap) Less(i, j int) bool  { return h[i].Score > h[j].Score }
+func (h resultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x interface{}) { *h = append(*h, x.(Result)) }
+func (h *resultHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,378 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// ErrInvalidPayload is returned for a payload that does not match the
+// collection's schema.
+var ErrInvalidPayload = errors.New("payload does not match schema")
+
+// Schema is the subset of JSON Schema a collection can check payloads
+// against: type (object, array, string, number, integer, boolean or null),
+// properties, required, additionalProperties, items, enum, minimum,
+// maximum, minLength and maxLength. Other keywords, such as title or
+// description, are accepted and ignored. A property may also set "index":
+// true to have a secondary index kept for it, as if listed with FIELD.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Index                bool               `json:"index,omitempty"`
+}
+
+var schemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// ParseSchema parses and checks a schema document.
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.check("schema"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Schema) check(path string) error {
+	if s.Type != "" && !slices.Contains(schemaTypes, s.Type) {
+		return fmt.Errorf("invalid schema: %s: unknown type %q", path, s.Type)
+	}
+	for name, property := range s.Properties {
+		if property == nil || name == "" || strings.Contains(name, ".") {
+			return fmt.Errorf("invalid schema: %s: invalid property %q", path, name)
+		}
+		if err := property.check(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+	return nil
+}
+
+// IndexedFields returns the dotted paths of the properties marked "index",
+// sorted.
+func (s *Schema) IndexedFields() []string {
+	var fields []string
+	var walk func(prefix string, s *Schema)
+	walk = func(prefix string, s *Schema) {
+		for name, property := range s.Properties {
+			path := prefix + name
+			if property.Index {
+				fields = append(fields, path)
+			}
+			walk(path+".", property)
+		}
+	}
+	walk("", s)
+	sort.Strings(fields)
+	return fields
+}
+
+// Validate checks a payload against s, describing the first mismatch. A
+// point without a payload is checked as an empty object.
+func (s *Schema) Validate(payload []byte) error {
+	var doc interface{} = map[string]interface{}{}
+	if payload != nil {
+		if err := json.Unmarshal(payload, &doc); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+	}
+	if err := s.validate("payload", doc); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	return nil
+}
+
+func (s *Schema) validate(path string, v interface{}) error {
+	if s.Type != "" && !hasType(v, s.Type) {
+		return fmt.Errorf("%s: expected %s, got %s", path, s.Type, typeName(v))
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e interface{}) bool { return jsonEqual(e, v) }) {
+		return fmt.Errorf("%s: %s is not one of the allowed values", path, formatJSON(v))
+	}
+	switch v := v.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: %g is less than the minimum of %g", path, v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s: %g is greater than the maximum of %g", path, v, *s.Maximum)
+		}
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s: shorter than %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s: longer than %d characters", path, *s.MaxLength)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, element := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), element); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required field %q", path, name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected field %q", path, name)
+				}
+				continue
+			}
+			if err := property.validate(path+"."+name, v[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func hasType(v interface{}, want string) bool {
+	if want == "integer" {
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	}
+	return typeName(v) == want
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return formatJSON(a) == formatJSON(b)
+}
+
+func formatJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// SchemaSource returns the JSON schema of c, or nil if it has none.
+func (c *Collection) SchemaSource() []byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.Schema
+}
+
+// mergeFields returns fields followed by those of more it lacks.
+func mergeFields(fields, more []string) []string {
+	for _, field := range more {
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// SetSchema replaces the schema of a collection, or removes it if data is
+// nil. Fields the new schema indexes are indexed from the existing points;
+// indexes are never dropped, as filters may still rely on them. Existing
+// points are kept even if they do not match; invalid is how many do not.
+func (m *Manager) SetSchema(collection string, data []byte) (invalid int, err error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return 0, err
+	}
+	var schema *Schema
+	if data != nil {
+		if schema, err = ParseSchema(data); err != nil {
+			return 0, err
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, data); err != nil {
+			return 0, err
+		}
+		data = compact.Bytes()
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	fields := c.Fields
+	if schema != nil {
+		fields = mergeFields(slices.Clip(fields), schema.IndexedFields())
+	}
+	added := fields[len(c.Fields):]
+	batch := m.db.NewBatch()
+	defer batch.Close()
+	if schema != nil {
+		if invalid, err = m.indexPoints(batch, c, added, schema); err != nil {
+			return 0, err
+		}
+	}
+
+	info := c.Info
+	info.Fields, info.Schema = fields, data
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return 0, err
+	}
+	batch.Set(collectionKey(c.Name), encoded, nil)
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return 0, err
+	}
+	c.mutex.Lock()
+	c.Fields, c.Schema, c.schema = fields, data, schema
+	c.mutex.Unlock()
+	if err := m.fieldsAdded(c, added); err != nil {
+		return 0, err
+	}
+	return invalid, nil
+}
+
+// IndexFields adds secondary indexes on fields to a collection, indexing
+// its existing points. Fields already indexed are skipped.
+func (m *Manager) IndexFields(collection string, fields []string) error {
+	for _, field := range fields {
+		if !validFieldPath(field) {
+			return fmt.Errorf("invalid field %q", field)
+		}
+	}
+	c, err := m.Get(collection)
+	if err != nil {
+		return err
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	all := mergeFields(slices.Clip(c.Fields), fields)
+	added := all[len(c.Fields):]
+	if len(added) == 0 {
+		return nil
+	}
+	batch := m.db.NewBatch()
+	defer batch.Close()
+	if _, err := m.indexPoints(batch, c, added, nil); err != nil {
+		return err
+	}
+	info := c.Info
+	info.Fields = all
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	batch.Set(collectionKey(c.Name), encoded, nil)
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.Fields = all
+	c.mutex.Unlock()
+	return m.fieldsAdded(c, added)
+}
+
+// indexPoints adds to batch the index entries of fields for every point of
+// c. If schema is set, it also returns how many payloads do not match it.
+// The manager's writeMutex must be held.
+func (m *Manager) indexPoints(batch *pebble.Batch, c *Collection, fields []string, schema *Schema) (invalid int, err error) {
+	prefix := pointsPrefix(c.ID)
+	iter, err := m.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	// As in scanPoints, a point's payload sorts just before its vector.
+	var payload []byte
+	for iter.First(); iter.Valid(); iter.Next() {
+		_, id, field, err := parseFieldKey(iter.Key())
+		if err != nil {
+			return 0, fmt.Errorf("key %q: %w", iter.Key(), err)
+		}
+		switch field {
+		case fieldKey:
+			payload = nil
+		case fieldPayload:
+			payload = slices.Clone(iter.Value())
+		default:
+			if schema != nil && schema.Validate(payload) != nil {
+				invalid++
+			}
+			c.indexFields(batch, id, payload, fields, true)
+			payload = nil
+		}
+	}
+	return invalid, iter.Error()
+}
+
+// fieldsAdded rebuilds the sketches and drops the planner statistics of c
+// once the added fields are indexed.
+func (m *Manager) fieldsAdded(c *Collection, added []string) error {
+	if err := m.buildSketches(c); err != nil {
+		return err
+	}
+	if len(added) > 0 {
+		c.statsCache.mutex.Lock()
+		c.statsCache.stats = nil
+		c.statsCache.mutex.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// postFilterOversampling is how many times more neighbours than wanted an
+// hnsw search asks for when the matches are filtered afterwards.
+const postFilterOversampling = 4
+
+// Result is a search hit. Score is a distance: lower is closer for every
+// metric, inner product being negated.
+type Result struct {
+	ID    string
+	Score float64
+}
+
+// SearchOptions tunes a search.
+type SearchOptions struct {
+	// K is the number of results wanted.
+	K int
+	// EF overrides the collection's EFSearch for hnsw collections.
+	EF int
+	// Deadline, if not zero, is when the search must finish by. The search
+	// then lowers its effort to fit and stops at the deadline, reporting
+	// its results as degraded.
+	Deadline time.Time
+	// Filter, if set, restricts the search to the points it matches.
+	Filter *Filter
+}
+
+// Search returns the opts.K points of collection closest to query, closest
+// first, and marks them as recently searched. degraded reports that a
+// deadline cut the search short, so recall may be lower than usual.
+func (m *Manager) Search(collection string, query []float64, opts SearchOptions) (results []Result, degraded bool, err error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(query) != c.Dimension {
+		return nil, false, fmt.Errorf("query has %d dimensions, collection %q expects %d", len(query), c.Name, c.Dimension)
+	}
+	if opts.K <= 0 {
+		return nil, false, nil
+	}
+	c.searched.Store(time.Now().UnixMilli())
+	if err := m.promote(c); err != nil {
+		return nil, false, err
+	}
+
+	plan, err := m.plan(c, opts.Filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	mt := &matcher{m: m, c: c, plan: plan}
+	var hits []scored
+	switch {
+	case plan.candidates != nil:
+		hits, degraded = c.flatSearch(query, opts.K, opts.Deadline, mt)
+	case c.index != nil:
+		ef := opts.EF
+		if ef <= 0 {
+			ef = c.Index.EFSearch
+		}
+		hits, degraded = c.indexSearch(query, opts.K, ef, opts.Deadline, mt)
+	default:
+		hits, degraded = c.flatSearch(query, opts.K, opts.Deadline, mt)
+	}
+	if mt.err != nil {
+		return nil, false, mt.err
+	}
+	if degraded {
+		m.degraded.Add(1)
+	}
+
+	now := coarseNow()
+	results = make([]Result, len(hits))
+	for i, hit := range hits {
+		p := c.points[hit.id]
+		p.touch(now)
+		results[i] = Result{ID: p.key, Score: hit.distance}
+	}
+	return results, degraded, nil
+}
+
+// Rank returns the points of collection among keys that match filter,
+// which may be nil, with their distance to query, closest first, or with
+// a nil query in the order of keys and a score of 0. Keys without a point
+// are left out, and so are repeats.
+func (m *Manager) Rank(collection string, query []float64, keys []string, filter *Filter) ([]Result, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, err
+	}
+	if query != nil && len(query) != c.Dimension {
+		return nil, fmt.Errorf("query has %d dimensions, collection %q expects %d", len(query), c.Name, c.Dimension)
+	}
+	plan, err := m.plan(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	mt := &matcher{m: m, c: c, plan: plan}
+	distance := distanceFunc(c.Metric)
+	results := make([]Result, 0, len(keys))
+	seen := make(map[uint64]bool, len(keys))
+	for _, key := range keys {
+		id, ok := c.ids[key]
+		if !ok || seen[id] || !mt.match(id) {
+			continue
+		}
+		seen[id] = true
+		r := Result{ID: key}
+		if query != nil {
+			r.Score = distance(query, c.points[id].vector)
+		}
+		results = append(results, r)
+	}
+	if mt.err != nil {
+		return nil, mt.err
+	}
+	if query != nil {
+		slices.SortStableFunc(results, func(a, b Result) int { return compareDistance(a.Score, b.Score) })
+	}
+	return results, nil
+}
+
+// flatSearch compares query with every point the plan of mt visits and
+// matches. c.mutex must be held.
+func (c *Collection) flatSearch(query []float64, k int, deadline time.Time, mt *matcher) ([]scored, bool) {
+	distance := distanceFunc(c.Metric)
+	h := &scoredHeap{less: func(a, b float64) bool { return a > b }}
+	visits, stopped := 0, false
+	points := c.points
+	if mt.plan.candidates != nil {
+		points = make(map[uint64]*point, len(mt.plan.candidates))
+		for id := range mt.plan.candidates {
+			if p, ok := c.points[id]; ok {
+				points[id] = p
+			}
+		}
+	}
+	for id, p := range points {
+		if visits++; !deadline.IsZero() && visits%searchDeadlineCheck == 0 && time.Now().After(deadline) {
+			stopped = true
+			break
+		}
+		if !mt.plan.exact && !mt.match(id) {
+			continue
+		}
+		d := distance(query, p.vector)
+		if h.Len() < k {
+			heap.Push(h, scored{id, d})
+		} else if d < h.items[0].distance {
+			h.items[0] = scored{id, d}
+			heap.Fix(h, 0)
+		}
+	}
+	slices.SortFunc(h.items, func(a, b scored) int { return compareDistance(a.distance, b.distance) })
+	return h.items, stopped
+}
+
+// indexSearch searches the HNSW graph. With a filter the indexes could not
+// narrow down, it asks the graph for several times k neighbours and keeps
+// the matching ones, asking again for more until k match or the whole
+// collection was searched. c.mutex must be held.
+func (c *Collection) indexSearch(query []float64, k, ef int, deadline time.Time, mt *matcher) ([]scored, bool) {
+	if mt.plan.exact {
+		return c.index.search(query, k, ef, deadline)
+	}
+	for want := k * postFilterOversampling; ; want *= postFilterOversampling {
+		hits, degraded := c.index.search(query, want, max(ef, want), deadline)
+		matched := make([]scored, 0, k)
+		for _, hit := range hits {
+			if len(matched) < k && mt.match(hit.id) {
+				matched = append(matched, hit)
+			}
+		}
+		if len(matched) == k || len(hits) < want || want >= len(c.points) || degraded || mt.err != nil {
+			return matched, degraded
+		}
+	}
+}
+
+// Degraded returns how many searches were cut short by their deadline
+// since startup.
+func (m *Manager) Degraded() int64 {
+	return m.degraded.Load()
+}
+
+func distanceFunc(metric Metric) func(a, b []float64) float64 {
+	switch metric {
+	case MetricCosine:
+		return cosineDistance
+	case MetricIP:
+		return func(a, b []float64) float64 { return -dot(a, b) }
+	default:
+		return l2Distance
+	}
+}
+
+func l2Distance(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		diff := a[i] - b[i]
+		sum += diff * diff
+	}
+	return math.Sqrt(sum)
+}
+
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func cosineDistance(a, b []float64) float64 {
+	normA, normB := math.Sqrt(dot(a, a)), math.Sqrt(dot(b, b))
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot(a, b)/(normA*normB)
+}
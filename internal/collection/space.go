@@ -0,0 +1,67 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+// SpaceUsage compares the logical size of a collection with the space its
+// keys take on disk. Amplification above 1 is storage overhead: obsolete
+// versions and tombstones not yet compacted away, plus block and index
+// overhead; below 1 means compression is winning.
+type SpaceUsage struct {
+	Name          string
+	Tenant        string
+	Points        int
+	LogicalBytes  int64
+	DiskBytes     uint64
+	Amplification float64
+}
+
+// SpaceUsage reports the space usage of every collection, sorted by name.
+// Disk usage is Pebble's estimate for the collection's key range, which only
+// counts flushed data.
+func (m *Manager) SpaceUsage() ([]SpaceUsage, error) {
+	infos := m.List()
+	usages := make([]SpaceUsage, 0, len(infos))
+	for _, info := range infos {
+		c, err := m.Get(info.Name)
+		if err != nil {
+			continue
+		}
+		disk, err := m.db.EstimateDiskUsage(c.Span())
+		if err != nil {
+			return nil, err
+		}
+		usage := SpaceUsage{
+			Name:         c.Name,
+			Tenant:       c.Tenant,
+			Points:       c.Len(),
+			LogicalBytes: c.LogicalBytes(),
+			DiskBytes:    disk,
+		}
+		if usage.LogicalBytes > 0 {
+			usage.Amplification = float64(disk) / float64(usage.LogicalBytes)
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
@@ -0,0 +1,343 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+)
+
+const (
+	// histogramBuckets is the number of equi-depth buckets kept for the
+	// numbers of an indexed field, and commonValues the number of most
+	// common values whose frequency is kept exactly.
+	histogramBuckets = 32
+	commonValues     = 16
+	// statsMinChanges and statsStaleFraction bound how many writes a
+	// collection takes before its statistics are recomputed.
+	statsMinChanges    = 100
+	statsStaleFraction = 0.1
+	// defaultSelectivity is the fraction of points assumed to match a
+	// condition the statistics say nothing about.
+	defaultSelectivity = 1.0 / 3
+)
+
+// fieldStats summarizes the secondary index of a payload field for the
+// planner.
+type fieldStats struct {
+	// present is the number of points that have the field.
+	present int64
+	// entries and distinct count the index entries and distinct values of
+	// each type, by value tag.
+	entries  map[byte]int64
+	distinct map[byte]int64
+	// common counts the entries of the most common values, by encoded
+	// value.
+	common map[string]int64
+	// bounds are the upper bounds of equi-depth buckets over the field's
+	// numbers, in increasing order.
+	bounds []float64
+}
+
+// collectionStats are the statistics of every indexed field of a
+// collection, as of an op log sequence number. The statistics of a text
+// index are those of a field holding its terms as strings.
+type collectionStats struct {
+	fields map[string]*fieldStats
+	text   map[string]*fieldStats
+	asOf   uint64
+}
+
+// statsCache holds the statistics of a collection, computed on demand from
+// its secondary indexes.
+type statsCache struct {
+	mutex sync.Mutex
+	stats *collectionStats
+}
+
+// stats returns the statistics of c, recomputing them once enough
+// writes happened since they were computed.
+func (m *Manager) stats(c *Collection) (*collectionStats, error) {
+	c.statsCache.mutex.Lock()
+	defer c.statsCache.mutex.Unlock()
+	seq := c.lastOp.Load()
+	if s := c.statsCache.stats; s != nil {
+		stale := max(statsMinChanges, uint64(float64(c.Len())*statsStaleFraction))
+		if seq-s.asOf < stale {
+			return s, nil
+		}
+	}
+	s := &collectionStats{fields: make(map[string]*fieldStats), text: make(map[string]*fieldStats), asOf: seq}
+	for _, field := range c.indexedFields() {
+		fs, err := m.analyzeIndex(fieldIndexPrefix(c.ID, field))
+		if err != nil {
+			return nil, err
+		}
+		s.fields[field] = fs
+	}
+	for _, text := range c.Text {
+		fs, err := m.analyzeIndex(textIndexPrefix(c.ID, text.Field))
+		if err != nil {
+			return nil, err
+		}
+		s.text[text.Field] = fs
+	}
+	c.statsCache.stats = s
+	return s, nil
+}
+
+// analyzeIndex scans the index of a field, given its prefix. Index keys are
+// sorted by value, so distinct values are counted by comparing each key with
+// the previous one.
+func (m *Manager) analyzeIndex(prefix []byte) (*fieldStats, error) {
+	iter, err := m.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	fs := &fieldStats{entries: make(map[byte]int64), distinct: make(map[byte]int64), common: make(map[string]int64)}
+	var numbers []float64
+	var last []byte
+	var run int64
+	// endRun keeps the value that was just counted if it is among the most
+	// common so far.
+	endRun := func() {
+		if run == 0 {
+			return
+		}
+		if len(fs.common) < commonValues {
+			fs.common[string(last)] = run
+			return
+		}
+		rarest, rarestRun := "", run
+		for value, n := range fs.common {
+			if n < rarestRun {
+				rarest, rarestRun = value, n
+			}
+		}
+		if rarestRun < run {
+			delete(fs.common, rarest)
+			fs.common[string(last)] = run
+		}
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if len(key) < len(prefix)+9 {
+			continue
+		}
+		value := key[len(prefix) : len(key)-8]
+		tag := value[0]
+		if tag == valuePresent {
+			fs.present++
+			continue
+		}
+		fs.entries[tag]++
+		if !bytes.Equal(value, last) {
+			endRun()
+			fs.distinct[tag]++
+			last, run = append(last[:0], value...), 0
+		}
+		run++
+		if tag == valueNumber && len(value) == 9 {
+			numbers = append(numbers, decodeIndexNumber(value[1:]))
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	endRun()
+	if len(numbers) > 0 {
+		buckets := min(histogramBuckets, len(numbers))
+		fs.bounds = make([]float64, buckets)
+		for i := range fs.bounds {
+			fs.bounds[i] = numbers[(i+1)*len(numbers)/buckets-1]
+		}
+	}
+	return fs, nil
+}
+
+func decodeIndexNumber(b []byte) float64 {
+	bits := binary.BigEndian.Uint64(b)
+	if bits>>63 == 1 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+// below estimates the fraction of the field's numbers that are less than v,
+// or at most v if inclusive, interpolating within the bucket v falls in.
+func (fs *fieldStats) below(v float64, inclusive bool) float64 {
+	if len(fs.bounds) == 0 {
+		return defaultSelectivity
+	}
+	i, found := slices.BinarySearch(fs.bounds, v)
+	if found && inclusive {
+		for i < len(fs.bounds) && fs.bounds[i] == v {
+			i++
+		}
+		return float64(i) / float64(len(fs.bounds))
+	}
+	if i == len(fs.bounds) {
+		return 1
+	}
+	lo := fs.bounds[0]
+	if i > 0 {
+		lo = fs.bounds[i-1]
+	}
+	within := 0.0
+	if hi := fs.bounds[i]; hi > lo && v > lo {
+		within = (v - lo) / (hi - lo)
+	}
+	if i == 0 && v < lo {
+		return 0
+	}
+	return (float64(i) + within) / float64(len(fs.bounds))
+}
+
+// equal estimates the index entries holding v: exactly for the most common
+// values, and otherwise assuming the other values of its type are equally
+// frequent.
+func (fs *fieldStats) equal(v interface{}) float64 {
+	key, _ := appendIndexValue(nil, v)
+	if n, ok := fs.common[string(key)]; ok {
+		return float64(n)
+	}
+	tag := valueTag(v)
+	entries, distinct := fs.entries[tag], fs.distinct[tag]
+	for value, n := range fs.common {
+		if value[0] == tag {
+			entries -= n
+			distinct--
+		}
+	}
+	if distinct <= 0 {
+		return 0
+	}
+	return float64(entries) / float64(distinct)
+}
+
+// estimate returns the number of points expected to match cond and whether
+// the secondary indexes can answer it.
+func (s *collectionStats) estimate(cond condition, points float64) (rows float64, indexable bool) {
+	field := func(name string) *fieldStats { return s.fields[name] }
+	switch cond := cond.(type) {
+	case *compareCondition:
+		fs := field(cond.field)
+		if fs == nil {
+			return points * defaultSelectivity, false
+		}
+		if cond.op == "!=" {
+			return math.Max(0, float64(fs.present)-fs.equal(cond.value)), false
+		}
+		if cond.op == "=" {
+			return fs.equal(cond.value), true
+		}
+		n, ok := cond.value.(float64)
+		if !ok {
+			return float64(fs.entries[valueTag(cond.value)]) * defaultSelectivity, true
+		}
+		total := float64(fs.entries[valueNumber])
+		switch cond.op {
+		case "<":
+			return total * fs.below(n, false), true
+		case "<=":
+			return total * fs.below(n, true), true
+		case ">":
+			return total * (1 - fs.below(n, true)), true
+		default:
+			return total * (1 - fs.below(n, false)), true
+		}
+	case *inCondition:
+		fs := field(cond.field)
+		if fs == nil {
+			return points * defaultSelectivity, false
+		}
+		for _, v := range cond.values {
+			rows += fs.equal(v)
+		}
+		return rows, true
+	case *rangeCondition:
+		fs := field(cond.field)
+		if fs == nil {
+			return points * defaultSelectivity, false
+		}
+		if valueTag(cond.lo) != valueTag(cond.hi) {
+			return 0, true
+		}
+		lo, ok1 := cond.lo.(float64)
+		hi, ok2 := cond.hi.(float64)
+		if !ok1 || !ok2 {
+			return float64(fs.entries[valueTag(cond.lo)]) * defaultSelectivity, true
+		}
+		return float64(fs.entries[valueNumber]) * math.Max(0, fs.below(hi, true)-fs.below(lo, false)), true
+	case *existsCondition:
+		if fs := field(cond.field); fs != nil {
+			return float64(fs.present), true
+		}
+		return points * defaultSelectivity, false
+	case *matchCondition:
+		fs := s.text[cond.field]
+		if fs == nil || cond.analyzer == nil {
+			return points * defaultSelectivity, false
+		}
+		// As for AND, terms are assumed independent.
+		terms := cond.analyzer.terms(cond.query)
+		if len(terms) == 0 {
+			return 0, true
+		}
+		rows = points
+		for _, term := range terms {
+			rows *= math.Min(1, fs.equal(term)/math.Max(points, 1))
+		}
+		return rows, true
+	case andCondition:
+		// Conditions are assumed independent.
+		fraction, indexable := 1.0, false
+		for _, sub := range cond {
+			subRows, subIndexable := s.estimate(sub, points)
+			fraction *= math.Min(1, subRows/math.Max(points, 1))
+			indexable = indexable || subIndexable
+		}
+		return fraction * points, indexable
+	case orCondition:
+		indexable = true
+		for _, sub := range cond {
+			subRows, subIndexable := s.estimate(sub, points)
+			rows += subRows
+			indexable = indexable && subIndexable
+		}
+		return math.Min(rows, points), indexable
+	case notCondition:
+		subRows, _ := s.estimate(cond.condition, points)
+		return math.Max(0, points-subRows), false
+	}
+	return points * defaultSelectivity, false
+}
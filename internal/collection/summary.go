@@ -0,0 +1,161 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+)
+
+// Summaries describe the data of a collection for monitoring its quality,
+// such as vectors that went unnormalized or payloads missing a field. They
+// are computed from a sample of the points rather than kept up to date, so
+// that writes pay nothing for them.
+const (
+	// summarySample is how many vectors a summary looks at, and
+	// summaryPayloadSample how many of their payloads, which unlike the
+	// vectors are read from disk.
+	summarySample        = 10000
+	summaryPayloadSample = 1000
+)
+
+// Summary describes the points of a collection, from a sample of them.
+type Summary struct {
+	Points  int
+	Sampled int
+	// Norms are the percentiles of the L2 norms of the sampled vectors,
+	// NormPercentiles lists which.
+	Norms       []float64
+	MeanNorm    float64
+	ZeroVectors int
+	// Dimensions describes each component of the sampled vectors. It is
+	// only filled in on request.
+	Dimensions []DimensionSummary
+	// PayloadsSampled is how many payloads were sampled, WithPayload how
+	// many points among them have one and Fields how many have each top
+	// level payload field.
+	PayloadsSampled int
+	WithPayload     int
+	Fields          map[string]int
+}
+
+// NormPercentiles are the percentiles of Summary.Norms.
+var NormPercentiles = []float64{0, 1, 5, 25, 50, 75, 95, 99, 100}
+
+// DimensionSummary describes the values of one vector component.
+type DimensionSummary struct {
+	Min, Max, Mean, StdDev float64
+}
+
+// Summarize samples the points of collection. dimensions requests a summary
+// of each vector component, which for large dimensions makes for a long
+// reply.
+func (m *Manager) Summarize(collection string, dimensions bool) (*Summary, error) {
+	c, err := m.Get(collection)
+	if err != nil {
+		return nil, err
+	}
+	s := &Summary{Fields: make(map[string]int)}
+	var norms []float64
+	var ids []uint64
+	var sum, sumSquares []float64
+	if dimensions {
+		s.Dimensions = make([]DimensionSummary, c.Dimension)
+		sum = make([]float64, c.Dimension)
+		sumSquares = make([]float64, c.Dimension)
+	}
+	c.mutex.RLock()
+	s.Points = len(c.points)
+	// Map iteration order is unspecified, which makes the first points
+	// visited a cheap, if not uniform, sample.
+	for id, p := range c.points {
+		if len(norms) == summarySample {
+			break
+		}
+		norm := 0.0
+		for i, x := range p.vector {
+			norm += x * x
+			if dimensions {
+				d := &s.Dimensions[i]
+				if len(norms) == 0 || x < d.Min {
+					d.Min = x
+				}
+				if len(norms) == 0 || x > d.Max {
+					d.Max = x
+				}
+				sum[i] += x
+				sumSquares[i] += x * x
+			}
+		}
+		norms = append(norms, math.Sqrt(norm))
+		if len(ids) < summaryPayloadSample {
+			ids = append(ids, id)
+		}
+	}
+	c.mutex.RUnlock()
+
+	s.Sampled = len(norms)
+	if s.Sampled == 0 {
+		return s, nil
+	}
+	for i := range s.Dimensions {
+		n := float64(s.Sampled)
+		mean := sum[i] / n
+		s.Dimensions[i].Mean = mean
+		s.Dimensions[i].StdDev = math.Sqrt(max(0, sumSquares[i]/n-mean*mean))
+	}
+	total := 0.0
+	for _, norm := range norms {
+		total += norm
+		if norm == 0 {
+			s.ZeroVectors++
+		}
+	}
+	s.MeanNorm = total / float64(s.Sampled)
+	slices.Sort(norms)
+	for _, p := range NormPercentiles {
+		s.Norms = append(s.Norms, norms[int(math.Round(p/100*float64(len(norms)-1)))])
+	}
+
+	for _, id := range ids {
+		payload, found, err := m.get(payloadKey(c.ID, id))
+		if err != nil {
+			return nil, err
+		}
+		s.PayloadsSampled++
+		if !found {
+			continue
+		}
+		s.WithPayload++
+		var doc map[string]json.RawMessage
+		if json.Unmarshal(payload, &doc) != nil {
+			continue
+		}
+		for field := range doc {
+			s.Fields[field]++
+		}
+	}
+	return s, nil
+}
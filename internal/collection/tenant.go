@@ -0,0 +1,151 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/pebble"
+)
+
+var ErrQuotaExceeded = errors.New("tenant vector quota exceeded")
+
+// Policy decides what happens when a tenant at its quota adds a point.
+type Policy string
+
+const (
+	// PolicyReject refuses the write.
+	PolicyReject Policy = "reject"
+	// PolicyEvictLRS evicts the tenant's least recently searched points to
+	// make room.
+	PolicyEvictLRS Policy = "evict-lrs"
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch policy := Policy(s); policy {
+	case PolicyReject, PolicyEvictLRS:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown quota policy %q", s)
+}
+
+// Tenant groups collections under a shared vector quota.
+type Tenant struct {
+	Name string `json:"name"`
+	// Quota is the maximum number of points across the tenant's
+	// collections. Zero means unlimited.
+	Quota  int64  `json:"quota"`
+	Policy Policy `json:"policy"`
+}
+
+// SetTenant creates or updates a tenant. Lowering a quota below the current
+// usage does not evict anything until the next write.
+func (m *Manager) SetTenant(tenant Tenant) error {
+	if tenant.Name == "" {
+		return errors.New("tenant name must not be empty")
+	}
+	if tenant.Policy == "" {
+		tenant.Policy = PolicyReject
+	}
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	data, err := json.Marshal(tenant)
+	if err != nil {
+		return err
+	}
+	if err := m.db.Set(tenantKey(tenant.Name), data, pebble.Sync); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.tenants[tenant.Name] = &tenant
+	m.mutex.Unlock()
+	return nil
+}
+
+// Tenant returns the settings of a tenant and its current number of points.
+// Tenants that were never configured have no quota.
+func (m *Manager) Tenant(name string) (Tenant, int64) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	tenant := Tenant{Name: name, Policy: PolicyReject}
+	if t, ok := m.tenants[name]; ok {
+		tenant = *t
+	}
+	return tenant, m.usageLocked(name)
+}
+
+func (m *Manager) usageLocked(tenant string) int64 {
+	var usage int64
+	for _, c := range m.collections {
+		if c.Tenant == tenant {
+			usage += int64(c.Len())
+		}
+	}
+	return usage
+}
+
+type victim struct {
+	collection *Collection
+	id         uint64
+	key        string
+	searched   int64
+}
+
+// makeRoom returns the points to evict so the tenant can take one more
+// point, or ErrQuotaExceeded if its policy is to reject. Eviction frees an
+// extra 1% of the quota so that a tenant writing at its limit does not scan
+// all of its points on every insert. Must be called with writeMutex held.
+func (m *Manager) makeRoom(tenant string) ([]victim, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	t, ok := m.tenants[tenant]
+	if !ok || t.Quota <= 0 {
+		return nil, nil
+	}
+	usage := m.usageLocked(tenant)
+	if usage < t.Quota {
+		return nil, nil
+	}
+	if t.Policy != PolicyEvictLRS {
+		return nil, ErrQuotaExceeded
+	}
+
+	var candidates []victim
+	for _, c := range m.collections {
+		if c.Tenant != tenant {
+			continue
+		}
+		c.mutex.RLock()
+		for id, p := range c.points {
+			candidates = append(candidates, victim{collection: c, id: id, key: p.key, searched: p.lastSearched.Load()})
+		}
+		c.mutex.RUnlock()
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].searched < candidates[j].searched })
+	n := usage - t.Quota + 1 + t.Quota/100
+	return candidates[:min(n, int64(len(candidates)))], nil
+}
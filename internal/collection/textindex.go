@@ -0,0 +1,157 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package collection
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// The payload fields listed in Info.Text have a text index, an inverted
+// index from the terms their analyzer produces to the points holding them:
+//
+//	\x00p <coll> t <len> <field> <term> <point>
+//
+// with one key, without a value, per point and distinct term, the term
+// encoded like a string field index value. A field holding an array of
+// strings is indexed under the terms of every element. MATCH conditions
+// look the terms of their query up, so unlike other conditions they are
+// answered without reading payloads whenever the field has a text index.
+
+// TextField is a payload field with a text index.
+type TextField struct {
+	Field string `json:"field"`
+	// Analyzer describes how the field is split into terms, see Analyzer.
+	Analyzer string `json:"analyzer,omitempty"`
+}
+
+func textIndexPrefix(collection uint64, field string) []byte {
+	key := append(collectionSpace(collection), tagTextIndex)
+	key = binary.AppendUvarint(key, uint64(len(field)))
+	return append(key, field...)
+}
+
+func textIndexKey(collection uint64, field, term string) []byte {
+	key, _ := appendIndexValue(textIndexPrefix(collection, field), term)
+	return key
+}
+
+// analyzer returns the analyzer of a text indexed field, or nil if field has
+// no text index.
+func (c *Collection) analyzer(field string) *Analyzer {
+	return c.analyzers[field]
+}
+
+// hasIndexes reports whether the payloads of c are indexed at all, in which
+// case changing a payload means reading the old one to unindex it. The
+// manager's writeMutex must be held.
+func (c *Collection) hasIndexes() bool {
+	return len(c.Fields) > 0 || len(c.Text) > 0
+}
+
+// indexText adds to batch the text index entries of a point's payload, or
+// their deletion if set is false.
+func (c *Collection) indexText(batch *pebble.Batch, id uint64, payload []byte, set bool) {
+	if len(c.Text) == 0 || payload == nil {
+		return
+	}
+	var doc interface{}
+	if json.Unmarshal(payload, &doc) != nil {
+		return
+	}
+	for _, text := range c.Text {
+		for term := range textTerms(c.analyzer(text.Field), lookupField(doc, text.Field)) {
+			key := binary.BigEndian.AppendUint64(textIndexKey(c.ID, text.Field, term), id)
+			if set {
+				batch.Set(key, nil, nil)
+			} else {
+				batch.Delete(key, nil)
+			}
+		}
+	}
+}
+
+// textTerms returns the index terms of the strings in value.
+func textTerms(a *Analyzer, value interface{}) map[string]struct{} {
+	terms := make(map[string]struct{})
+	anyValue(value, func(v interface{}) bool {
+		if s, ok := v.(string); ok {
+			for term := range a.indexTerms(s) {
+				terms[term] = struct{}{}
+			}
+		}
+		return false
+	})
+	return terms
+}
+
+// bindAnalyzers sets the analyzer of the MATCH conditions of cond to that
+// of the field's text index, if it has one.
+func (c *Collection) bindAnalyzers(cond condition) {
+	switch cond := cond.(type) {
+	case andCondition:
+		for _, sub := range cond {
+			c.bindAnalyzers(sub)
+		}
+	case orCondition:
+		for _, sub := range cond {
+			c.bindAnalyzers(sub)
+		}
+	case notCondition:
+		c.bindAnalyzers(cond.condition)
+	case *matchCondition:
+		if a := c.analyzer(cond.field); a != nil {
+			cond.analyzer = a
+		}
+	}
+}
+
+// lookupText returns the points holding every term of the query of cond,
+// which must be on a text indexed field.
+func (m *Manager) lookupText(c *Collection, cond *matchCondition) (map[uint64]struct{}, error) {
+	var ids map[uint64]struct{}
+	for _, term := range c.analyzer(cond.field).terms(cond.query) {
+		key := textIndexKey(c.ID, cond.field, term)
+		termIDs := make(map[uint64]struct{})
+		if err := m.scanFieldIndex(key, prefixEnd(key), termIDs); err != nil {
+			return nil, err
+		}
+		if ids == nil {
+			ids = termIDs
+			continue
+		}
+		for id := range ids {
+			if _, ok := termIDs[id]; !ok {
+				delete(ids, id)
+			}
+		}
+	}
+	if ids == nil {
+		// A query of stopwords only matches nothing.
+		ids = map[uint64]struct{}{}
+	}
+	return ids, nil
+}
@@ -0,0 +1,147 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package dirlock makes sure only one vecble process uses a data directory
+// at a time, and says which process holds it when another tries.
+package dirlock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// FileName is the lock file created in the data directory.
+const FileName = "vecble.lock"
+
+// Owner identifies the process holding a data directory.
+type Owner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("pid %d on %s (since %s)", o.PID, o.Host, o.Started.Format(time.RFC3339))
+}
+
+// LockedError is returned when another live process holds the directory.
+type LockedError struct {
+	Dir   string
+	Owner Owner
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("data directory %s is in use by %s", e.Dir, e.Owner)
+}
+
+// ForeignError is returned when the lock file was last written from another
+// host. File locks are not reliable across hosts on network filesystems, so
+// that process may still be running; -force-recover takes over anyway.
+type ForeignError struct {
+	Dir   string
+	Owner Owner
+}
+
+func (e *ForeignError) Error() string {
+	return fmt.Sprintf("data directory %s was last opened by %s; if that process is gone, restart with -force-recover", e.Dir, e.Owner)
+}
+
+// Lock is a held data directory lock.
+type Lock struct {
+	file *os.File
+	// Recovered is set when the lock was taken over from a process that
+	// did not release it, i.e. after a crash.
+	Recovered *Owner
+}
+
+// Acquire locks dir for this process. A lock file left behind by a process
+// on this host that is no longer running is recovered automatically; one
+// written from another host is only taken over with force.
+func Acquire(dir string, force bool) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, FileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	previous, hadOwner := readOwner(file)
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, &LockedError{Dir: dir, Owner: previous}
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	host, _ := os.Hostname()
+	lock := &Lock{file: file}
+	if hadOwner {
+		if previous.Host != host && !force {
+			syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+			file.Close()
+			return nil, &ForeignError{Dir: dir, Owner: previous}
+		}
+		lock.Recovered = &previous
+	}
+	owner := Owner{PID: os.Getpid(), Host: host, Started: time.Now()}
+	data, _ := json.Marshal(owner)
+	if err := file.Truncate(0); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+func readOwner(file *os.File) (Owner, bool) {
+	var owner Owner
+	data := make([]byte, 4096)
+	n, _ := file.ReadAt(data, 0)
+	if n == 0 || json.Unmarshal(data[:n], &owner) != nil {
+		return Owner{}, false
+	}
+	return owner, true
+}
+
+// Release clears the owner and unlocks the directory. A clean release
+// leaves an empty lock file, so the next process can tell a crash, which
+// leaves the owner behind, from a clean shutdown.
+func (l *Lock) Release() error {
+	l.file.Truncate(0)
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	return l.file.Close()
+}
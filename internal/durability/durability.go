@@ -0,0 +1,163 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package durability commits Pebble batches with a chosen trade-off between
+// durability and throughput, grouping the WAL fsyncs of concurrent writers
+// that can wait a few milliseconds.
+package durability
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Mode is how durable a write is when its command replies.
+type Mode int
+
+const (
+	// Always fsyncs the WAL before every write returns.
+	Always Mode = iota
+	// Batched returns once a WAL fsync shared with the writes of the same
+	// window has completed: writes are as durable as with Always, at the
+	// cost of up to a window of latency.
+	Batched
+	// None returns without waiting for an fsync; a crash loses the writes
+	// the operating system had not yet flushed.
+	None
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Batched:
+		return "batched"
+	case None:
+		return "none"
+	default:
+		return "always"
+	}
+}
+
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "", "always":
+		return Always, nil
+	case "batched":
+		return Batched, nil
+	case "none":
+		return None, nil
+	}
+	return Always, fmt.Errorf("unknown durability %q: expected always, batched or none", s)
+}
+
+// ParseOverrides parses comma-separated command=mode pairs, e.g.
+// "vadd=batched,set=none".
+func ParseOverrides(s string) (map[string]Mode, error) {
+	overrides := make(map[string]Mode)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		cmd, mode, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid durability override %q: expected command=mode", pair)
+		}
+		m, err := ParseMode(mode)
+		if err != nil {
+			return nil, err
+		}
+		overrides[strings.ToLower(cmd)] = m
+	}
+	return overrides, nil
+}
+
+// Committer commits batches, sharing one WAL fsync between the Batched
+// writes of each window.
+type Committer struct {
+	db     *pebble.DB
+	window time.Duration
+
+	mutex   sync.Mutex
+	pending *group
+
+	syncs atomic.Int64
+}
+
+// group is the set of writes waiting for the same fsync.
+type group struct {
+	done chan struct{}
+	err  error
+}
+
+func NewCommitter(db *pebble.DB, window time.Duration) *Committer {
+	return &Committer{db: db, window: window}
+}
+
+// Commit commits batch and returns once it is as durable as mode asks.
+func (c *Committer) Commit(batch *pebble.Batch, mode Mode) error {
+	switch mode {
+	case Always:
+		return batch.Commit(pebble.Sync)
+	case None:
+		return batch.Commit(pebble.NoSync)
+	}
+	if err := batch.Commit(pebble.NoSync); err != nil {
+		return err
+	}
+	return c.waitSync()
+}
+
+// waitSync joins the group of the current window, starting one if none is
+// open, and waits for its fsync. The fsync covers every write committed
+// before it, so every write committed before joining the group.
+func (c *Committer) waitSync() error {
+	c.mutex.Lock()
+	g := c.pending
+	if g == nil {
+		g = &group{done: make(chan struct{})}
+		c.pending = g
+		time.AfterFunc(c.window, func() { c.sync(g) })
+	}
+	c.mutex.Unlock()
+	<-g.done
+	return g.err
+}
+
+func (c *Committer) sync(g *group) {
+	c.mutex.Lock()
+	c.pending = nil
+	c.mutex.Unlock()
+	// An empty synced LogData fsyncs the WAL without writing anything.
+	g.err = c.db.LogData(nil, pebble.Sync)
+	c.syncs.Add(1)
+	close(g.done)
+}
+
+// Syncs returns the number of grouped fsyncs since startup.
+func (c *Committer) Syncs() int64 {
+	return c.syncs.Load()
+}
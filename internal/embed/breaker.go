@@ -0,0 +1,119 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package embed
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// Open refuses every call until the cooldown has passed.
+	Open
+	// HalfOpen lets a single trial call through, whose outcome closes or
+	// reopens the breaker.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker opens after a run of failed calls, so that callers stop waiting
+// on a provider that is down, and probes it again after a cooldown.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mutex    sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// trial is set while the half-open breaker's trial call is running.
+	trial bool
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a call may be made. Every allowed call must be
+// followed by Record.
+func (b *Breaker) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = HalfOpen
+		b.trial = true
+		return true
+	case HalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// Record records the outcome of an allowed call.
+func (b *Breaker) Record(ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if ok {
+		b.state, b.failures, b.trial = Closed, 0, false
+		return
+	}
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt, b.trial = Open, time.Now(), false
+	}
+}
+
+// State returns the state of the breaker. An open breaker whose cooldown
+// has passed reports HalfOpen, as the next call would probe the provider.
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == Open && time.Since(b.openedAt) >= b.cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
@@ -0,0 +1,105 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package embed
+
+import (
+	"crypto/sha256"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/storage"
+)
+
+// cachePrefix is the reserved keyspace the cache keeps vectors in, under
+// the SHA-256 of the model and the text:
+//
+//	\x00v:<hash>    vector (see storage.EncodeVector)
+const cachePrefix = "\x00v:"
+
+// Cache keeps the vectors texts were embedded into in Pebble, so that a
+// text embedded once, by any client, is not sent to the provider again.
+// Entries are keyed by model as well, so switching models starts afresh.
+type Cache struct {
+	db    *pebble.DB
+	model string
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func NewCache(db *pebble.DB, model string) *Cache {
+	return &Cache{db: db, model: model}
+}
+
+func (c *Cache) key(text string) []byte {
+	h := sha256.New()
+	h.Write([]byte(c.model))
+	h.Write([]byte{0})
+	h.Write([]byte(text))
+	return h.Sum([]byte(cachePrefix))
+}
+
+// Lookup returns the cached vectors of texts, nil for those not cached.
+func (c *Cache) Lookup(texts []string) ([][]float64, error) {
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		value, closer, err := c.db.Get(c.key(text))
+		if err == pebble.ErrNotFound {
+			c.misses.Add(1)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		vectors[i], err = storage.DecodeVector(value)
+		closer.Close()
+		if err != nil {
+			return nil, err
+		}
+		c.hits.Add(1)
+	}
+	return vectors, nil
+}
+
+// Store caches the vectors of texts. Losing the latest entries in a crash
+// only costs calls to the provider, so they are not synced.
+func (c *Cache) Store(texts []string, vectors [][]float64) error {
+	batch := c.db.NewBatch()
+	defer batch.Close()
+	for i, text := range texts {
+		batch.Set(c.key(text), storage.EncodeVector(vectors[i]), nil)
+	}
+	return batch.Commit(pebble.NoSync)
+}
+
+// Hits returns how many texts were found in the cache, and Misses how
+// many were not.
+func (c *Cache) Hits() int64 {
+	return c.hits.Load()
+}
+
+func (c *Cache) Misses() int64 {
+	return c.misses.Load()
+}
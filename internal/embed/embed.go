@@ -0,0 +1,227 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package embed turns text into vectors with an external embedding
+// provider, behind a circuit breaker so that a slow or failing provider
+// fails fast instead of holding up the clients waiting on it.
+package embed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnavailable is returned without calling the provider while the circuit
+// breaker is open.
+var ErrUnavailable = errors.New("embedding provider unavailable")
+
+// Provider embeds texts, returning one vector per text in order.
+type Provider interface {
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// HTTP is a provider speaking the OpenAI embeddings API, which most
+// hosted and self-hosted embedding servers implement.
+type HTTP struct {
+	// URL is the embeddings endpoint, e.g.
+	// https://api.openai.com/v1/embeddings.
+	URL   string
+	Model string
+	// APIKey, if set, is sent as a bearer token.
+	APIKey string
+	Client *http.Client
+}
+
+type httpRequest struct {
+	Model string   `json:"model,omitempty"`
+	Input []string `json:"input"`
+}
+
+type httpResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (h *HTTP) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	body, err := json.Marshal(httpRequest{Model: h.Model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("provider replied %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	var decoded httpResponse
+	if err := json.NewDecoder(res.Body).Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("invalid provider response: %w", err)
+	}
+	vectors := make([][]float64, len(texts))
+	for _, d := range decoded.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("provider returned embedding %d for %d texts", d.Index, len(texts))
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("provider returned no embedding for text %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+// Config tunes an Embedder.
+type Config struct {
+	// Timeout bounds each call to the provider; a call that takes longer
+	// fails and counts against the breaker.
+	Timeout time.Duration
+	// FailureThreshold is how many calls in a row may fail before the
+	// breaker opens.
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before letting a trial
+	// call through.
+	Cooldown time.Duration
+}
+
+// Embedder calls a provider through a circuit breaker.
+type Embedder struct {
+	provider Provider
+	timeout  time.Duration
+	breaker  *Breaker
+
+	calls    atomic.Int64
+	failures atomic.Int64
+	rejected atomic.Int64
+}
+
+func New(provider Provider, config Config) *Embedder {
+	if config.Timeout <= 0 {
+		config.Timeout = 2 * time.Second
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = 30 * time.Second
+	}
+	return &Embedder{
+		provider: provider,
+		timeout:  config.Timeout,
+		breaker:  NewBreaker(config.FailureThreshold, config.Cooldown),
+	}
+}
+
+// Embed embeds texts, failing with ErrUnavailable while the breaker is
+// open.
+func (e *Embedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	if !e.breaker.Allow() {
+		e.rejected.Add(1)
+		return nil, ErrUnavailable
+	}
+	e.calls.Add(1)
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	vectors, err := e.provider.Embed(ctx, texts)
+	e.breaker.Record(err == nil)
+	if err != nil {
+		e.failures.Add(1)
+		return nil, fmt.Errorf("embedding failed: %w", err)
+	}
+	return vectors, nil
+}
+
+// State returns the state of the breaker.
+func (e *Embedder) State() State {
+	return e.breaker.State()
+}
+
+// Calls, Failures and Rejected return how many calls were made to the
+// provider, how many of them failed, and how many were refused by the open
+// breaker since startup.
+func (e *Embedder) Calls() int64    { return e.calls.Load() }
+func (e *Embedder) Failures() int64 { return e.failures.Load() }
+func (e *Embedder) Rejected() int64 { return e.rejected.Load() }
+
+// Fallback is what happens to a write whose text cannot be embedded.
+type Fallback int
+
+const (
+	// Reject fails the write.
+	Reject Fallback = iota
+	// Queue keeps the write in memory and applies it once the provider
+	// is back, losing it if the server stops first.
+	Queue
+	// Defer stores the point without a vector, flagged for later
+	// embedding.
+	Defer
+)
+
+func (f Fallback) String() string {
+	switch f {
+	case Queue:
+		return "queue"
+	case Defer:
+		return "defer"
+	default:
+		return "reject"
+	}
+}
+
+func ParseFallback(s string) (Fallback, error) {
+	switch strings.ToLower(s) {
+	case "", "reject":
+		return Reject, nil
+	case "queue":
+		return Queue, nil
+	case "defer":
+		return Defer, nil
+	}
+	return Reject, fmt.Errorf("unknown embedder fallback %q: expected reject, queue or defer", s)
+}
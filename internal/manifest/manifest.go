@@ -0,0 +1,218 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package manifest reads collection manifests: YAML documents declaring
+// the collections a server should have, for deployment tools to keep in
+// version control and apply with APPLY or at startup (see
+// collection.Manager.Apply).
+//
+//	collections:
+//	  - name: docs
+//	    dim: 384
+//	    metric: cosine
+//	    index:
+//	      type: hnsw
+//	      m: 16
+//	    fields: [category, price]
+//
+// Only the subset of YAML such documents need is understood: block
+// mappings and sequences, flow sequences of scalars, plain and quoted
+// scalars, and comments.
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"readpebble/internal/collection"
+)
+
+// LoadFile reads the manifest at path.
+func LoadFile(path string) ([]collection.Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return infos, nil
+}
+
+// Parse returns the collections a manifest declares. Unknown settings are
+// errors, so that a typo does not go unnoticed.
+func Parse(data []byte) ([]collection.Info, error) {
+	root, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	if root.kind == nullNode {
+		return nil, nil
+	}
+	if err := expectKeys(root, "collections"); err != nil {
+		return nil, err
+	}
+	list := root.get("collections")
+	if list == nil || list.kind == nullNode {
+		return nil, nil
+	}
+	if list.kind != sequenceNode {
+		return nil, fmt.Errorf("line %d: collections must be a list", list.line)
+	}
+	infos := make([]collection.Info, 0, len(list.items))
+	for _, item := range list.items {
+		info, err := parseCollection(item)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func parseCollection(n *node) (collection.Info, error) {
+	var info collection.Info
+	if n.kind != mappingNode {
+		return info, fmt.Errorf("line %d: a collection must be a mapping", n.line)
+	}
+	if err := expectKeys(n, "name", "dim", "metric", "tenant", "index", "fields"); err != nil {
+		return info, err
+	}
+	var err error
+	if info.Name, err = scalar(n, "name", true); err != nil {
+		return info, err
+	}
+	if info.Dimension, err = positive(n, "dim", true); err != nil {
+		return info, err
+	}
+	metric, err := scalar(n, "metric", false)
+	if err != nil {
+		return info, err
+	}
+	if metric != "" {
+		if info.Metric, err = collection.ParseMetric(metric); err != nil {
+			return info, fmt.Errorf("line %d: %w", n.get("metric").line, err)
+		}
+	}
+	if info.Tenant, err = scalar(n, "tenant", false); err != nil {
+		return info, err
+	}
+	if info.Index, err = parseIndex(n.get("index")); err != nil {
+		return info, err
+	}
+	if fields := n.get("fields"); fields != nil && fields.kind != nullNode {
+		if fields.kind != sequenceNode {
+			return info, fmt.Errorf("line %d: fields must be a list", fields.line)
+		}
+		for _, field := range fields.items {
+			if field.kind != scalarNode {
+				return info, fmt.Errorf("line %d: fields must be a list of field names", field.line)
+			}
+			info.Fields = append(info.Fields, field.value)
+		}
+	}
+	return info, nil
+}
+
+// parseIndex parses the index of a collection, either just its type or a
+// mapping with its type and parameters.
+func parseIndex(n *node) (collection.IndexParams, error) {
+	var params collection.IndexParams
+	if n == nil || n.kind == nullNode {
+		return params, nil
+	}
+	typ := n.value
+	if n.kind == mappingNode {
+		if err := expectKeys(n, "type", "m", "ef_construction", "ef_search"); err != nil {
+			return params, err
+		}
+		var err error
+		if typ, err = scalar(n, "type", true); err != nil {
+			return params, err
+		}
+		for _, p := range []struct {
+			name  string
+			value *int
+		}{{"m", &params.M}, {"ef_construction", &params.EFConstruction}, {"ef_search", &params.EFSearch}} {
+			if *p.value, err = positive(n, p.name, false); err != nil {
+				return params, err
+			}
+		}
+	} else if n.kind != scalarNode {
+		return params, fmt.Errorf("line %d: index must be a type or a mapping", n.line)
+	}
+	var err error
+	if params.Type, err = collection.ParseIndexType(typ); err != nil {
+		return params, fmt.Errorf("line %d: %w", n.line, err)
+	}
+	return params, nil
+}
+
+// expectKeys returns an error if mapping n has a key not in keys.
+func expectKeys(n *node, keys ...string) error {
+	if n.kind != mappingNode {
+		return fmt.Errorf("line %d: expected a mapping", n.line)
+	}
+next:
+	for i, key := range n.keys {
+		for _, k := range keys {
+			if key == k {
+				continue next
+			}
+		}
+		return fmt.Errorf("line %d: unknown setting %q", n.values[i].line, key)
+	}
+	return nil
+}
+
+// scalar returns the string value of key in mapping n, or "" if it is
+// missing and not required.
+func scalar(n *node, key string, required bool) (string, error) {
+	value := n.get(key)
+	if value == nil || value.kind == nullNode {
+		if required {
+			return "", fmt.Errorf("line %d: %s is required", n.line, key)
+		}
+		return "", nil
+	}
+	if value.kind != scalarNode {
+		return "", fmt.Errorf("line %d: %s must be a single value", value.line, key)
+	}
+	return value.value, nil
+}
+
+// positive returns the positive integer value of key in mapping n, or 0
+// if it is missing and not required.
+func positive(n *node, key string, required bool) (int, error) {
+	s, err := scalar(n, key, required)
+	if err != nil || s == "" {
+		return 0, err
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("line %d: %s must be a positive integer", n.get(key).line, key)
+	}
+	return v, nil
+}
@@ -0,0 +1,330 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type nodeKind int
+
+const (
+	nullNode nodeKind = iota
+	scalarNode
+	sequenceNode
+	mappingNode
+)
+
+// node is a parsed YAML value. line is where it starts, for errors.
+type node struct {
+	kind  nodeKind
+	line  int
+	value string
+	items []*node
+	// keys and values are the entries of a mapping, in document order.
+	keys   []string
+	values []*node
+}
+
+// get returns the value of key in a mapping, or nil.
+func (n *node) get(key string) *node {
+	for i, k := range n.keys {
+		if k == key {
+			return n.values[i]
+		}
+	}
+	return nil
+}
+
+// line is a line holding something other than a comment.
+type line struct {
+	number int
+	indent int
+	text   string
+}
+
+type parser struct {
+	lines []line
+	pos   int
+}
+
+// parseYAML parses the subset of YAML manifests use. An empty document
+// parses as a null node.
+func parseYAML(data []byte) (*node, error) {
+	p := &parser{}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripComment(strings.TrimSuffix(text, "\r")), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" && len(p.lines) == 0 {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs may not be used for indentation", i+1)
+		}
+		p.lines = append(p.lines, line{number: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return &node{kind: nullNode, line: 1}, nil
+	}
+	root, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+	return root, nil
+}
+
+// stripComment removes a comment from a line: a '#' at its start or after
+// a space, outside of quotes.
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// block parses the value starting at the current line, which is indented
+// by indent.
+func (p *parser) block(indent int) (*node, error) {
+	l := p.lines[p.pos]
+	if isItem(l.text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok, err := splitKey(l); err != nil || ok {
+		if err != nil {
+			return nil, err
+		}
+		return p.mapping(indent)
+	}
+	p.pos++
+	return parseScalar(l.text, l.number)
+}
+
+func isItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// sequence parses the "- " items indented by indent.
+func (p *parser) sequence(indent int) (*node, error) {
+	n := &node{kind: sequenceNode, line: p.lines[p.pos].number}
+	for p.pos < len(p.lines) {
+		l := &p.lines[p.pos]
+		if l.indent < indent || l.indent == indent && !isItem(l.text) {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.number)
+		}
+		var item *node
+		var err error
+		if rest := strings.TrimLeft(l.text[1:], " "); rest == "" {
+			p.pos++
+			item, err = p.nested(indent, l.number, false)
+		} else {
+			// The item's value starts on the same line, as if on its own
+			// line indented past the "- ", so it may be a mapping whose
+			// other keys follow on the next lines.
+			l.indent += len(l.text) - len(rest)
+			l.text = rest
+			item, err = p.block(l.indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, item)
+	}
+	return n, nil
+}
+
+// mapping parses the "key: value" entries indented by indent.
+func (p *parser) mapping(indent int) (*node, error) {
+	n := &node{kind: mappingNode, line: p.lines[p.pos].number}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.number)
+		}
+		key, rest, ok, err := splitKey(l)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.number)
+		}
+		if n.get(key) != nil {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.number, key)
+		}
+		p.pos++
+		var value *node
+		if rest == "" {
+			value, err = p.nested(indent, l.number, true)
+		} else {
+			value, err = parseScalar(rest, l.number)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+		n.values = append(n.values, value)
+	}
+	return n, nil
+}
+
+// nested parses the value of a key or item left empty on its own line,
+// which is on the next lines if they are indented further than indent. The
+// value of a key may also be a sequence indented as much as the key.
+func (p *parser) nested(indent, number int, key bool) (*node, error) {
+	if p.pos < len(p.lines) {
+		next := p.lines[p.pos]
+		if next.indent > indent || key && next.indent == indent && isItem(next.text) {
+			return p.block(next.indent)
+		}
+	}
+	return &node{kind: nullNode, line: number}, nil
+}
+
+// splitKey splits a "key: value" line, reporting whether it is one.
+func splitKey(l line) (key, rest string, ok bool, err error) {
+	text := l.text
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		if end < 0 {
+			return "", "", false, fmt.Errorf("line %d: unterminated string", l.number)
+		}
+		if end+1 == len(text) || text[end+1] != ':' {
+			return "", "", false, nil
+		}
+		k, err := parseScalar(text[:end+1], l.number)
+		if err != nil {
+			return "", "", false, err
+		}
+		return k.value, strings.TrimLeft(text[end+2:], " "), true, nil
+	}
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false, nil
+	}
+	if i := strings.Index(text, ": "); i >= 0 {
+		return strings.TrimRight(text[:i], " "), strings.TrimLeft(text[i+2:], " "), true, nil
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimRight(text[:len(text)-1], " "), "", true, nil
+	}
+	return "", "", false, nil
+}
+
+// closingQuote returns the index of the quote closing the string text
+// starts with, or -1.
+func closingQuote(text string) int {
+	for i := 1; i < len(text); i++ {
+		switch {
+		case text[0] == '"' && text[i] == '\\':
+			i++
+		case text[i] == text[0] && text[0] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == text[0]:
+			return i
+		}
+	}
+	return -1
+}
+
+// parseScalar parses a value written on one line: a plain or quoted
+// string, null, or a flow sequence of those such as [a, "b"].
+func parseScalar(text string, number int) (*node, error) {
+	switch {
+	case text == "~" || text == "null" || text == "Null" || text == "NULL":
+		return &node{kind: nullNode, line: number}, nil
+	case text[0] == '"' || text[0] == '\'':
+		if closingQuote(text) != len(text)-1 {
+			return nil, fmt.Errorf("line %d: invalid quoted string %s", number, text)
+		}
+		if text[0] == '\'' {
+			return &node{kind: scalarNode, line: number, value: strings.ReplaceAll(text[1:len(text)-1], "''", "'")}, nil
+		}
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid quoted string %s", number, text)
+		}
+		return &node{kind: scalarNode, line: number, value: value}, nil
+	case text[0] == '[':
+		return parseFlowSequence(text, number)
+	case text[0] == '{' || text[0] == '|' || text[0] == '>' || text[0] == '&' || text[0] == '*':
+		return nil, fmt.Errorf("line %d: unsupported YAML %q", number, text)
+	}
+	return &node{kind: scalarNode, line: number, value: text}, nil
+}
+
+func parseFlowSequence(text string, number int) (*node, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("line %d: flow sequences must be on one line", number)
+	}
+	n := &node{kind: sequenceNode, line: number}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	for inner != "" {
+		end := strings.IndexByte(inner, ',')
+		if inner[0] == '"' || inner[0] == '\'' {
+			if end = closingQuote(inner); end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", number)
+			}
+			end++
+			if rest := strings.TrimLeft(inner[end:], " "); rest != "" && rest[0] != ',' {
+				return nil, fmt.Errorf("line %d: expected ',' after %s", number, inner[:end])
+			}
+		}
+		if end < 0 {
+			end = len(inner)
+		}
+		item := strings.TrimSpace(inner[:end])
+		if item == "" || item[0] == '[' {
+			return nil, fmt.Errorf("line %d: invalid flow sequence %s", number, text)
+		}
+		value, err := parseScalar(item, number)
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, value)
+		inner = strings.TrimSpace(inner[end:])
+		inner = strings.TrimSpace(strings.TrimPrefix(inner, ","))
+	}
+	return n, nil
+}
@@ -0,0 +1,333 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package metrics is a small registry of counters and gauges that can be
+// rendered in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+type family struct {
+	name   string
+	help   string
+	kind   metricType
+	series map[string]*series
+}
+
+type series struct {
+	labels string
+	value  float64
+	fn     func() float64
+	// suffix is appended to the family name, for the _bucket, _sum and
+	// _count series of histograms, and order sorts the series of a family
+	// when it is rendered, keeping histogram buckets in ascending order.
+	suffix string
+	order  string
+}
+
+type Registry struct {
+	mutex      sync.RWMutex
+	families   map[string]*family
+	collectors []GaugeCollector
+}
+
+// GaugeCollector produces gauges whose set of series is only known at read
+// time, such as one series per collection. It calls emit once per series.
+type GaugeCollector func(emit func(name, help string, value float64, labels ...string))
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	registry *Registry
+	series   *series
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	c.registry.mutex.Lock()
+	c.series.value += delta
+	c.registry.mutex.Unlock()
+}
+
+// Value returns the current value.
+func (c *Counter) Value() float64 {
+	c.registry.mutex.Lock()
+	defer c.registry.mutex.Unlock()
+	return c.series.value
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	registry *Registry
+	series   *series
+}
+
+func (g *Gauge) Set(value float64) {
+	g.registry.mutex.Lock()
+	g.series.value = value
+	g.registry.mutex.Unlock()
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.registry.mutex.Lock()
+	g.series.value += delta
+	g.registry.mutex.Unlock()
+}
+
+// Histogram counts observations into cumulative buckets by upper bound,
+// along with their sum and count.
+type Histogram struct {
+	registry *Registry
+	bounds   []float64
+	// buckets has one more series than bounds, for +Inf.
+	buckets []*series
+	sum     *series
+	count   *series
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.registry.mutex.Lock()
+	for i := len(h.bounds) - 1; i >= 0 && value <= h.bounds[i]; i-- {
+		h.buckets[i].value++
+	}
+	h.buckets[len(h.bounds)].value++
+	h.sum.value += value
+	h.count.value++
+	h.registry.mutex.Unlock()
+}
+
+// Counter returns the counter with the given name and label pairs
+// ("key", "value", ...), creating it on first use.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{registry: r, series: r.series(name, help, typeCounter, labels)}
+}
+
+// Gauge returns the gauge with the given name and label pairs, creating it on
+// first use.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{registry: r, series: r.series(name, help, typeGauge, labels)}
+}
+
+// Histogram returns the histogram with the given name, ascending bucket
+// upper bounds and label pairs, creating it on first use. Every series of
+// a histogram must use the same bounds.
+func (r *Registry) Histogram(name, help string, bounds []float64, labels ...string) *Histogram {
+	h := &Histogram{registry: r, bounds: bounds, buckets: make([]*series, 0, len(bounds)+1)}
+	base := formatLabels(labels)
+	for i := 0; i <= len(bounds); i++ {
+		le := math.Inf(1)
+		if i < len(bounds) {
+			le = bounds[i]
+		}
+		bucketLabels := append(labels[:len(labels):len(labels)], "le", formatValue(le))
+		h.buckets = append(h.buckets, r.seriesWith(name, help, typeHistogram, bucketLabels, "_bucket", fmt.Sprintf("%s_bucket%04d", base, i)))
+	}
+	h.sum = r.seriesWith(name, help, typeHistogram, labels, "_sum", base+"_sum")
+	h.count = r.seriesWith(name, help, typeHistogram, labels, "_count", base+"_count")
+	return h
+}
+
+// GaugeFunc registers a gauge whose value is computed by fn at read time.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64, labels ...string) {
+	s := r.series(name, help, typeGauge, labels)
+	r.mutex.Lock()
+	s.fn = fn
+	r.mutex.Unlock()
+}
+
+// CounterFunc registers a counter whose value is computed by fn at read
+// time, for totals kept by another package.
+func (r *Registry) CounterFunc(name, help string, fn func() float64, labels ...string) {
+	s := r.series(name, help, typeCounter, labels)
+	r.mutex.Lock()
+	s.fn = fn
+	r.mutex.Unlock()
+}
+
+// RegisterCollector adds a collector run on every read of the registry.
+func (r *Registry) RegisterCollector(collector GaugeCollector) {
+	r.mutex.Lock()
+	r.collectors = append(r.collectors, collector)
+	r.mutex.Unlock()
+}
+
+// gather returns the registered families together with the ones produced
+// by the collectors. Collectors run without the registry lock held, so they
+// may read other metrics.
+func (r *Registry) gather() map[string]*family {
+	r.mutex.RLock()
+	collectors := r.collectors
+	families := make(map[string]*family, len(r.families))
+	for name, f := range r.families {
+		families[name] = f
+	}
+	r.mutex.RUnlock()
+
+	collected := make(map[string]*family)
+	for _, collect := range collectors {
+		collect(func(name, help string, value float64, labels ...string) {
+			f, ok := collected[name]
+			if !ok {
+				f = &family{name: name, help: help, kind: typeGauge, series: make(map[string]*series)}
+				collected[name] = f
+			}
+			key := formatLabels(labels)
+			f.series[key] = &series{labels: key, value: value, order: key}
+		})
+	}
+	// Registered metrics win over collected ones of the same name.
+	for name, f := range collected {
+		if _, ok := families[name]; !ok {
+			families[name] = f
+		}
+	}
+	return families
+}
+
+func (r *Registry) series(name, help string, kind metricType, labels []string) *series {
+	key := formatLabels(labels)
+	return r.seriesWith(name, help, kind, labels, "", key)
+}
+
+func (r *Registry) seriesWith(name, help string, kind metricType, labels []string, suffix, order string) *series {
+	key := suffix + formatLabels(labels)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{name: name, help: help, kind: kind, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: formatLabels(labels), suffix: suffix, order: order}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Sample is a single value of a metric series.
+type Sample struct {
+	Name   string  `json:"name"`
+	Labels string  `json:"labels,omitempty"`
+	Value  float64 `json:"value"`
+}
+
+// Snapshot returns the current value of every series, sorted by name and
+// labels.
+func (r *Registry) Snapshot() []Sample {
+	families := r.gather()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	samples := []Sample{}
+	for _, f := range families {
+		for _, s := range f.series {
+			samples = append(samples, Sample{Name: f.name + s.suffix, Labels: s.labels, Value: s.read()})
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Name != samples[j].Name {
+			return samples[i].Name < samples[j].Name
+		}
+		return samples[i].Labels < samples[j].Labels
+	})
+	return samples
+}
+
+// WritePrometheus renders every metric in the Prometheus text format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	families := r.gather()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := families[name]
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind); err != nil {
+			return err
+		}
+		list := make([]*series, 0, len(f.series))
+		for _, s := range f.series {
+			list = append(list, s)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].order < list[j].order })
+		for _, s := range list {
+			if _, err := fmt.Fprintf(w, "%s%s%s %s\n", f.name, s.suffix, s.labels, formatValue(s.read())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *series) read() float64 {
+	if s.fn != nil {
+		return s.fn()
+	}
+	return s.value
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", value)
+}
@@ -0,0 +1,278 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	opFunction2    = 0xf5
+	opModuleAux    = 0xf7
+	opIdle         = 0xf8
+	opFreq         = 0xf9
+	opAux          = 0xfa
+	opResizeDB     = 0xfb
+	opExpireTimeMs = 0xfc
+	opExpireTime   = 0xfd
+	opSelectDB     = 0xfe
+	opEOF          = 0xff
+)
+
+// Value types that are skipped rather than decoded. Types stored as a single
+// encoded blob (ziplist, intset, listpack, ...) are listed separately from
+// the ones stored as a sequence of strings.
+var (
+	blobTypes = map[byte]bool{9: true, 10: true, 11: true, 12: true, 13: true, 16: true, 17: true, 20: true}
+	// stringsPerElement is the number of strings following each element of
+	// the length-prefixed collection types.
+	stringsPerElement = map[byte]int{1: 1, 2: 1, 4: 2, 14: 1}
+)
+
+// TypeAux is the Type of the entries holding the auxiliary fields of a
+// file, a name in Key and a value in Value, which have no database.
+const TypeAux = opAux
+
+// Entry is a key read from an RDB file. Value is only set for strings and
+// auxiliary fields; for other types the value is skipped and Type tells
+// what was there.
+type Entry struct {
+	DB       int
+	Key      []byte
+	Type     byte
+	Value    []byte
+	ExpireAt time.Time
+}
+
+// Parse reads an RDB file from r and calls fn for every key and auxiliary
+// field it contains. It stops at the EOF opcode, leaving r positioned after
+// the trailing checksum.
+func Parse(r io.Reader, fn func(Entry) error) error {
+	rd := NewReader(r)
+	header, err := rd.ReadBytes(9)
+	if err != nil {
+		return err
+	}
+	if string(header[:5]) != "REDIS" {
+		return fmt.Errorf("rdb: bad header %q", header)
+	}
+
+	db := 0
+	var expireAt time.Time
+	for {
+		op, err := rd.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opEOF:
+			_, err := rd.ReadBytes(8)
+			return err
+		case opSelectDB:
+			n, _, err := rd.ReadLength()
+			if err != nil {
+				return err
+			}
+			db = int(n)
+		case opResizeDB:
+			if _, _, err := rd.ReadLength(); err != nil {
+				return err
+			}
+			if _, _, err := rd.ReadLength(); err != nil {
+				return err
+			}
+		case opAux:
+			entry := Entry{DB: -1, Type: TypeAux}
+			if entry.Key, err = rd.ReadString(); err != nil {
+				return err
+			}
+			if entry.Value, err = rd.ReadString(); err != nil {
+				return err
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		case opExpireTime:
+			raw, err := rd.ReadBytes(4)
+			if err != nil {
+				return err
+			}
+			expireAt = time.Unix(int64(binary.LittleEndian.Uint32(raw)), 0)
+		case opExpireTimeMs:
+			raw, err := rd.ReadBytes(8)
+			if err != nil {
+				return err
+			}
+			expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(raw)))
+		case opFreq:
+			if _, err := rd.ReadByte(); err != nil {
+				return err
+			}
+		case opIdle:
+			if _, _, err := rd.ReadLength(); err != nil {
+				return err
+			}
+		case opFunction2:
+			if _, err := rd.ReadString(); err != nil {
+				return err
+			}
+		case opModuleAux:
+			return fmt.Errorf("%w: module data", ErrUnsupported)
+		default:
+			entry := Entry{DB: db, Type: op, ExpireAt: expireAt}
+			expireAt = time.Time{}
+			if entry.Key, err = rd.ReadString(); err != nil {
+				return err
+			}
+			if entry.Value, err = rd.readValue(op); err != nil {
+				return err
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readValue returns the value of a string, and consumes the value of any
+// other supported type without keeping it.
+func (r *Reader) readValue(valueType byte) ([]byte, error) {
+	if valueType == TypeString {
+		return r.ReadString()
+	}
+	if blobTypes[valueType] {
+		_, err := r.ReadString()
+		return nil, err
+	}
+	n, _, err := r.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+	switch valueType {
+	case 3: // Sorted set with scores as length-prefixed text.
+		for i := uint64(0); i < n; i++ {
+			if _, err := r.ReadString(); err != nil {
+				return nil, err
+			}
+			size, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			// 253, 254 and 255 encode NaN and the infinities inline.
+			if size < 253 {
+				if _, err := r.ReadBytes(int(size)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
+	case 5: // Sorted set with binary scores.
+		for i := uint64(0); i < n; i++ {
+			if _, err := r.ReadString(); err != nil {
+				return nil, err
+			}
+			if _, err := r.ReadBytes(8); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case 18: // Quicklist of listpacks.
+		for i := uint64(0); i < n; i++ {
+			if _, _, err := r.ReadLength(); err != nil {
+				return nil, err
+			}
+			if _, err := r.ReadString(); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	perElement, ok := stringsPerElement[valueType]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnsupported, valueType)
+	}
+	for i := uint64(0); i < n*uint64(perElement); i++ {
+		if _, err := r.ReadString(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Writer writes an RDB file holding string keys in database 0, and
+// auxiliary fields, in the format Parse reads.
+type Writer struct {
+	w   *bufio.Writer
+	crc uint64
+	buf []byte
+}
+
+// NewWriter starts an RDB file on w by writing its header.
+func NewWriter(w io.Writer) (*Writer, error) {
+	wr := &Writer{w: bufio.NewWriter(w)}
+	header := fmt.Appendf(nil, "REDIS%04d", Version)
+	return wr, wr.write(append(header, opSelectDB, 0))
+}
+
+// WriteString writes a string key. A zero expireAt means the key does not
+// expire.
+func (w *Writer) WriteString(key, value []byte, expireAt time.Time) error {
+	buf := w.buf[:0]
+	if !expireAt.IsZero() {
+		buf = binary.LittleEndian.AppendUint64(append(buf, opExpireTimeMs), uint64(expireAt.UnixMilli()))
+	}
+	buf = AppendString(AppendString(append(buf, TypeString), key), value)
+	w.buf = buf
+	return w.write(buf)
+}
+
+// WriteAux writes an auxiliary field, which Redis ignores unless it knows
+// its name.
+func (w *Writer) WriteAux(name, value []byte) error {
+	buf := AppendString(AppendString(append(w.buf[:0], opAux), name), value)
+	w.buf = buf
+	return w.write(buf)
+}
+
+// Close ends the file with the EOF opcode and the checksum, and flushes it.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.write([]byte{opEOF}); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(binary.LittleEndian.AppendUint64(nil, w.crc)); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+func (w *Writer) write(p []byte) error {
+	w.crc = CRC64(w.crc, p)
+	_, err := w.w.Write(p)
+	return err
+}
@@ -0,0 +1,272 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package rdb implements the parts of the Redis RDB serialization format that
+// vecble needs to exchange data with Redis: the DUMP/RESTORE payload and the
+// snapshot a Redis master sends to its replicas.
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"strconv"
+)
+
+// Version is the RDB version written into DUMP payloads. Redis refuses to
+// RESTORE payloads newer than its own, so an old version keeps vecble dumps
+// loadable by any Redis >= 5.
+const Version = 9
+
+const (
+	TypeString = 0
+
+	encodingInt8  = 0
+	encodingInt16 = 1
+	encodingInt32 = 2
+	encodingLZF   = 3
+)
+
+var (
+	ErrBadChecksum = errors.New("DUMP payload version or checksum are wrong")
+	ErrUnsupported = errors.New("unsupported RDB value type")
+
+	// Redis uses the Jones polynomial in its reflected form, with no
+	// initial or final inversion.
+	crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+)
+
+// CRC64 returns the checksum Redis appends to DUMP payloads and RDB files.
+func CRC64(crc uint64, data []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, data)
+}
+
+// EncodeDump serializes value the way DUMP does for a Redis string.
+func EncodeDump(value []byte) []byte {
+	payload := []byte{TypeString}
+	payload = AppendString(payload, value)
+	payload = binary.LittleEndian.AppendUint16(payload, Version)
+	return binary.LittleEndian.AppendUint64(payload, CRC64(0, payload))
+}
+
+// DecodeDump verifies a DUMP payload and returns the string it holds.
+func DecodeDump(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, ErrBadChecksum
+	}
+	body, footer := payload[:len(payload)-8], payload[len(payload)-8:]
+	version := binary.LittleEndian.Uint16(body[len(body)-2:])
+	if version > 12 || CRC64(0, body) != binary.LittleEndian.Uint64(footer) {
+		return nil, ErrBadChecksum
+	}
+	r := NewReader(bytes.NewReader(body[:len(body)-2]))
+	valueType, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if valueType != TypeString {
+		return nil, fmt.Errorf("%w %d", ErrUnsupported, valueType)
+	}
+	return r.ReadString()
+}
+
+// AppendLength appends an RDB length encoding of n.
+func AppendLength(buf []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(buf, byte(n))
+	case n < 1<<14:
+		return append(buf, byte(n>>8)|0x40, byte(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(buf, 0x80), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0x81), n)
+	}
+}
+
+// AppendString appends an uncompressed RDB string.
+func AppendString(buf []byte, s []byte) []byte {
+	return append(AppendLength(buf, uint64(len(s))), s...)
+}
+
+// Reader decodes RDB primitives from a stream.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &Reader{r: br}
+	}
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+func (r *Reader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (r *Reader) ReadBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errors.New("rdb: negative length")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ReadLength decodes a length. encoded reports that the value is instead a
+// special string encoding (integer or LZF) identified by the returned number.
+func (r *Reader) ReadLength() (n uint64, encoded bool, err error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			raw, err := r.ReadBytes(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(raw)), false, nil
+		case 0x81:
+			raw, err := r.ReadBytes(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(raw), false, nil
+		}
+		return 0, false, fmt.Errorf("rdb: unknown length encoding %#x", b)
+	default:
+		return uint64(b & 0x3f), true, nil
+	}
+}
+
+// ReadString decodes a string in any of its RDB encodings.
+func (r *Reader) ReadString() ([]byte, error) {
+	n, encoded, err := r.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return r.ReadBytes(int(n))
+	}
+	switch n {
+	case encodingInt8:
+		b, err := r.ReadBytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int8(b[0])), 10), nil
+	case encodingInt16:
+		b, err := r.ReadBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(b))), 10), nil
+	case encodingInt32:
+		b, err := r.ReadBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(b))), 10), nil
+	case encodingLZF:
+		compressedLen, _, err := r.ReadLength()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, _, err := r.ReadLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := r.ReadBytes(int(compressedLen))
+		if err != nil {
+			return nil, err
+		}
+		return decompressLZF(compressed, int(rawLen))
+	}
+	return nil, fmt.Errorf("rdb: unknown string encoding %d", n)
+}
+
+// decompressLZF expands data compressed with liblzf, as used by Redis for
+// long strings when rdbcompression is enabled.
+func decompressLZF(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// Literal run of ctrl+1 bytes.
+			end := i + ctrl + 1
+			if end > len(in) {
+				return nil, errors.New("rdb: corrupt LZF literal")
+			}
+			out = append(out, in[i:end]...)
+			i = end
+			continue
+		}
+		// Back reference.
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errors.New("rdb: corrupt LZF back reference")
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errors.New("rdb: corrupt LZF back reference")
+		}
+		ref := len(out) - ((ctrl & 0x1f) << 8) - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("rdb: corrupt LZF back reference")
+		}
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, fmt.Errorf("rdb: LZF expanded to %d bytes, expected %d", len(out), outLen)
+	}
+	return out, nil
+}
@@ -0,0 +1,306 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrorReply is an error reply decoded by ReadReply.
+type ErrorReply string
+
+func (e ErrorReply) Error() string {
+	return string(e)
+}
+
+// ErrProtocol is returned for input that is not valid RESP.
+var ErrProtocol = errors.New("protocol error")
+
+// ErrCommandTooLarge is returned by ReadCommand for a command larger than
+// MaxCommandSize, which it skipped, so the next command can be read.
+var ErrCommandTooLarge = errors.New("request too large")
+
+// maxBulkLength bounds the bulk strings read where MaxCommandSize does
+// not, as the default proto-max-bulk-len of Redis does, so that no length
+// read from the stream is allocated unchecked.
+const maxBulkLength = 512 << 20
+
+// Reader decodes RESP from a stream.
+type Reader struct {
+	r *bufio.Reader
+	// MaxCommandSize, if positive, is the most bytes ReadCommand reads a
+	// command of, counting its headers and line breaks.
+	MaxCommandSize int64
+	// OnAttribute, if set, is called with the key and value pairs of the
+	// RESP3 attributes ReadReply skips.
+	OnAttribute func(pairs []interface{})
+}
+
+// NewReader returns a Reader reading from r, which it buffers unless r is a
+// *bufio.Reader already.
+func NewReader(r io.Reader) *Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &Reader{r: br}
+	}
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Buffered returns the number of bytes read from the stream but not decoded
+// yet, so a server can tell whether more commands are pipelined.
+func (r *Reader) Buffered() int {
+	return r.r.Buffered()
+}
+
+// readLine reads a line and strips its line break. Clients sending inline
+// commands may end lines with a bare \n.
+func (r *Reader) readLine() (string, error) {
+	return r.readLimitedLine(0)
+}
+
+// readLimitedLine reads a line as readLine does, but if it is longer than
+// limit bytes, unless limit is 0, reads past it without keeping it and
+// returns ErrCommandTooLarge.
+func (r *Reader) readLimitedLine(limit int64) (string, error) {
+	var line []byte
+	tooLarge := false
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		if limit > 0 && int64(len(line)+len(chunk)) > limit {
+			tooLarge = true
+			line = nil
+		}
+		if !tooLarge {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	if tooLarge {
+		return "", ErrCommandTooLarge
+	}
+	return strings.TrimSuffix(string(line[:len(line)-1]), "\r"), nil
+}
+
+// ReadCommand reads a command: an array of bulk strings, or an inline
+// command, a line of space-separated words as typed in a terminal. A
+// command larger than MaxCommandSize is read past as soon as its headers
+// tell, without keeping its arguments, and ErrCommandTooLarge returned.
+func (r *Reader) ReadCommand() ([]string, error) {
+	line, err := r.readLimitedLine(r.MaxCommandSize)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%w: empty command", ErrProtocol)
+		}
+		return args, nil
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("%w: invalid multibulk length %q", ErrProtocol, line)
+	}
+	// The count is not trusted for more than a modest allocation.
+	args := make([]string, 0, min(count, 1024))
+	size := int64(len(line) + 2)
+	tooLarge := false
+	for i := 0; i < count; i++ {
+		header, err := r.readLimitedLine(r.MaxCommandSize)
+		if errors.Is(err, ErrCommandTooLarge) {
+			return nil, fmt.Errorf("%w: bulk string header too long", ErrProtocol)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("%w: expected a bulk string, got %q", ErrProtocol, header)
+		}
+		// Lengths are checked against what is left of the budget before
+		// being added to the size, which they could otherwise overflow.
+		limit := int64(maxBulkLength)
+		if r.MaxCommandSize > 0 {
+			limit = max(r.MaxCommandSize, limit)
+		}
+		n, err := bulkLength(header[1:], limit)
+		if err != nil {
+			return nil, err
+		}
+		if r.MaxCommandSize > 0 && !tooLarge {
+			size += int64(len(header) + 2)
+			if n+2 > r.MaxCommandSize-size {
+				tooLarge, args = true, nil
+			} else {
+				size += n + 2
+			}
+		}
+		if tooLarge {
+			// The rest of the command is read past, so that the stream
+			// stays in step.
+			if _, err := r.r.Discard(int(n + 2)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		arg, err := r.readBulkBody(n)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if tooLarge {
+		return nil, ErrCommandTooLarge
+	}
+	return args, nil
+}
+
+// bulkLength parses the length in the header of a bulk string, which may
+// be at most limit. Longer ones are not read past, since no client sends
+// them but to exhaust the server.
+func bulkLength(size string, limit int64) (int64, error) {
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: invalid bulk length %q", ErrProtocol, size)
+	}
+	if n > limit || n > math.MaxInt-2 {
+		return 0, fmt.Errorf("%w: bulk length %d exceeds %d", ErrProtocol, n, limit)
+	}
+	return n, nil
+}
+
+// readBulk reads the body of a bulk string whose header announced size
+// bytes.
+func (r *Reader) readBulk(size string) (string, error) {
+	n, err := bulkLength(size, maxBulkLength)
+	if err != nil {
+		return "", err
+	}
+	return r.readBulkBody(n)
+}
+
+// readBulkBody reads the n bytes of the body of a bulk string and the line
+// break ending it. It reads by length rather than up to the next line
+// break, since bulk strings such as vectors and DUMP payloads are binary.
+func (r *Reader) readBulkBody(n int64) (string, error) {
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return "", err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return string(buf[:n]), nil
+}
+
+// ReadReply reads a RESP2 or RESP3 reply. Simple strings and bulk strings
+// are returned as string, integers as int64, doubles as float64, booleans
+// as bool, arrays, sets, pushes and maps (flattened to key, value pairs) as
+// []interface{} and null replies as nil. An error reply is returned as an
+// ErrorReply error, or as an ErrorReply element within an aggregate.
+// Attributes are passed to OnAttribute and skipped.
+func (r *Reader) ReadReply() (interface{}, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("%w: empty reply line", ErrProtocol)
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, ErrorReply(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		if line == "$-1" {
+			return nil, nil
+		}
+		return r.readBulk(line[1:])
+	case '_':
+		return nil, nil
+	case '#':
+		return line[1:] == "t", nil
+	case ',':
+		return strconv.ParseFloat(line[1:], 64)
+	case '|':
+		attrs, err := r.readAggregate(line[1:], 2)
+		if err != nil {
+			return nil, err
+		}
+		if r.OnAttribute != nil {
+			pairs, _ := attrs.([]interface{})
+			r.OnAttribute(pairs)
+		}
+		return r.ReadReply()
+	case '%':
+		return r.readAggregate(line[1:], 2)
+	case '*', '~', '>':
+		return r.readAggregate(line[1:], 1)
+	default:
+		return nil, fmt.Errorf("%w: unknown reply type %q", ErrProtocol, line[0])
+	}
+}
+
+// readAggregate reads an array-like reply whose header announced size
+// entries of perEntry elements each.
+func (r *Reader) readAggregate(size string, perEntry int) (interface{}, error) {
+	count, err := strconv.Atoi(size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid aggregate length %q", ErrProtocol, size)
+	}
+	if count < 0 {
+		return nil, nil
+	}
+	if count > math.MaxInt/perEntry {
+		return nil, fmt.Errorf("%w: invalid aggregate length %q", ErrProtocol, size)
+	}
+	count *= perEntry
+	// The count is not trusted for more than a modest allocation.
+	items := make([]interface{}, 0, min(count, 1024))
+	for i := 0; i < count; i++ {
+		item, err := r.ReadReply()
+		if err != nil {
+			var reply ErrorReply
+			if !errors.As(err, &reply) {
+				return nil, err
+			}
+			item = reply
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
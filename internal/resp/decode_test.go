@@ -0,0 +1,142 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package resp
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadReply(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{"simple", "+OK\r\n", "OK"},
+		{"integer", ":-42\r\n", int64(-42)},
+		{"bulk", "$5\r\nhello\r\n", "hello"},
+		{"nil bulk", "$-1\r\n", nil},
+		{"nil array", "*-1\r\n", nil},
+		{"null", "_\r\n", nil},
+		{"boolean", "#t\r\n", true},
+		{"double", ",1.5\r\n", 1.5},
+		{"array", "*2\r\n$1\r\na\r\n:1\r\n", []interface{}{"a", int64(1)}},
+		{"error element", "*2\r\n-ERR bad\r\n+OK\r\n", []interface{}{ErrorReply("ERR bad"), "OK"}},
+		{"map", "%1\r\n+key\r\n*1\r\n:2\r\n", []interface{}{"key", []interface{}{int64(2)}}},
+		{"attribute", "|1\r\n+ttl\r\n:3\r\n+OK\r\n", "OK"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NewReader(strings.NewReader(test.data)).ReadReply()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadReplyError(t *testing.T) {
+	_, err := NewReader(strings.NewReader("-WRONGTYPE no\r\n")).ReadReply()
+	var reply ErrorReply
+	if !errors.As(err, &reply) || reply != "WRONGTYPE no" {
+		t.Fatalf("got %v, want the error reply", err)
+	}
+}
+
+// TestReadReplyAggregateLengths feeds the decoder aggregate lengths no
+// peer could send the elements of, which must not be allocated up front.
+func TestReadReplyAggregateLengths(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"huge array", "*9223372036854775807\r\n:1\r\n", io.EOF},
+		{"huge map", "%4611686018427387903\r\n+key\r\n", io.EOF},
+		{"overflowing map", "%4611686018427387904\r\n", ErrProtocol},
+		{"bad length", "*x\r\n", ErrProtocol},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewReader(strings.NewReader(test.data)).ReadReply(); !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+// TestReadCommandBulkLimits feeds the decoder bulk lengths over the limits,
+// including one that overflows the size of the command, which must be
+// refused without allocating what they announce.
+func TestReadCommandBulkLimits(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		data  string
+		limit int64
+	}{
+		{"overflow", "*2\r\n$3\r\nGET\r\n$9223372036854775807\r\nabc\r\n", 0},
+		{"overflow-limited", "*2\r\n$3\r\nGET\r\n$9223372036854775807\r\nabc\r\n", 1 << 20},
+		{"over-hard-cap", "*2\r\n$3\r\nGET\r\n$1073741824\r\nabc\r\n", 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			commands := NewReader(strings.NewReader(test.data))
+			commands.MaxCommandSize = test.limit
+			if _, err := commands.ReadCommand(); !errors.Is(err, ErrProtocol) {
+				t.Fatalf("got %v, want %v", err, ErrProtocol)
+			}
+		})
+	}
+}
+
+// TestReadCommandSkipsTooLarge checks that a command over MaxCommandSize is
+// read past, however it is over, leaving the stream at the next command.
+func TestReadCommandSkipsTooLarge(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+	}{
+		{"argument", "*2\r\n$3\r\nGET\r\n$32\r\n" + strings.Repeat("k", 32) + "\r\n"},
+		{"arguments after", "*3\r\n$3\r\nSET\r\n$32\r\n" + strings.Repeat("k", 32) + "\r\n$1\r\nv\r\n"},
+		{"argument past the buffer", "*2\r\n$3\r\nGET\r\n$100000\r\n" + strings.Repeat("k", 100000) + "\r\n"},
+		{"many arguments", "*6\r\n" + strings.Repeat("$1\r\nk\r\n", 6)},
+		{"inline", "GET " + strings.Repeat("k", 32) + "\r\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			commands := NewReader(strings.NewReader(test.data + "*1\r\n$4\r\nPING\r\n"))
+			commands.MaxCommandSize = 24
+			if _, err := commands.ReadCommand(); !errors.Is(err, ErrCommandTooLarge) {
+				t.Fatalf("got %v, want %v", err, ErrCommandTooLarge)
+			}
+			args, err := commands.ReadCommand()
+			if err != nil || len(args) != 1 || args[0] != "PING" {
+				t.Fatalf("next command: got %q, %v", args, err)
+			}
+		})
+	}
+}
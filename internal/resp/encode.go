@@ -0,0 +1,191 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package resp encodes and decodes the Redis serialization protocol (RESP2
+// and the RESP3 types vecble uses).
+//
+// Replies are built as strings, which is what command handlers return: the
+// package functions encode a single value, and a Writer builds aggregates
+// element by element. A Reader decodes commands on the server side and
+// replies on the client side from a stream.
+package resp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Common replies.
+const (
+	OK   = "+OK\r\n"
+	Pong = "+PONG\r\n"
+	// Nil is the RESP2 null bulk string, and NilArray the null array.
+	Nil      = "$-1\r\n"
+	NilArray = "*-1\r\n"
+)
+
+// lineBreaks replaces the characters a simple string or error cannot hold.
+var lineBreaks = strings.NewReplacer("\r", " ", "\n", " ")
+
+// SimpleString encodes s as a simple string. Line breaks in s are replaced
+// with spaces.
+func SimpleString(s string) string {
+	return "+" + lineBreaks.Replace(s) + "\r\n"
+}
+
+// Error encodes an error reply. msg starts with the error code, such as ERR
+// or WRONGTYPE, followed by a space and the message. Line breaks in msg are
+// replaced with spaces.
+func Error(msg string) string {
+	return "-" + lineBreaks.Replace(msg) + "\r\n"
+}
+
+// Errorf is Error with a formatted message.
+func Errorf(format string, args ...interface{}) string {
+	return Error(fmt.Sprintf(format, args...))
+}
+
+// Integer encodes n as an integer.
+func Integer(n int64) string {
+	return ":" + strconv.FormatInt(n, 10) + "\r\n"
+}
+
+// BulkString encodes s as a bulk string, which may hold any bytes.
+func BulkString(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+// Array encodes an array of already encoded elements.
+func Array(elems ...string) string {
+	var w Writer
+	w.Array(len(elems))
+	for _, elem := range elems {
+		w.Raw(elem)
+	}
+	return w.String()
+}
+
+// StringArray encodes an array of bulk strings.
+func StringArray(items []string) string {
+	var w Writer
+	w.Array(len(items))
+	for _, item := range items {
+		w.BulkString(item)
+	}
+	return w.String()
+}
+
+// Writer builds a reply. An aggregate is written as its header, from Array
+// or Map, followed by its elements. The zero Writer is ready to use.
+type Writer struct {
+	b []byte
+}
+
+// SimpleString appends a simple string.
+func (w *Writer) SimpleString(s string) {
+	w.b = append(w.b, '+')
+	w.b = append(w.b, lineBreaks.Replace(s)...)
+	w.b = append(w.b, '\r', '\n')
+}
+
+// Error appends an error reply, as an element of an aggregate.
+func (w *Writer) Error(msg string) {
+	w.b = append(w.b, '-')
+	w.b = append(w.b, lineBreaks.Replace(msg)...)
+	w.b = append(w.b, '\r', '\n')
+}
+
+// Integer appends an integer.
+func (w *Writer) Integer(n int64) {
+	w.b = append(w.b, ':')
+	w.b = strconv.AppendInt(w.b, n, 10)
+	w.b = append(w.b, '\r', '\n')
+}
+
+// BulkString appends a bulk string.
+func (w *Writer) BulkString(s string) {
+	w.b = append(w.b, '$')
+	w.b = strconv.AppendInt(w.b, int64(len(s)), 10)
+	w.b = append(w.b, '\r', '\n')
+	w.b = append(w.b, s...)
+	w.b = append(w.b, '\r', '\n')
+}
+
+// Nil appends a null bulk string.
+func (w *Writer) Nil() {
+	w.b = append(w.b, Nil...)
+}
+
+// Array appends the header of an array of n elements.
+func (w *Writer) Array(n int) {
+	w.header('*', n)
+}
+
+// Map appends the header of a RESP3 map of n key and value pairs.
+func (w *Writer) Map(n int) {
+	w.header('%', n)
+}
+
+// Push appends the header of a RESP3 push of n elements, which carries
+// data the client did not ask for, such as Pub/Sub messages.
+func (w *Writer) Push(n int) {
+	w.header('>', n)
+}
+
+// Attribute appends the header of a RESP3 attribute of n key and value
+// pairs, which precedes the reply it annotates.
+func (w *Writer) Attribute(n int) {
+	w.header('|', n)
+}
+
+func (w *Writer) header(kind byte, n int) {
+	w.b = append(w.b, kind)
+	w.b = strconv.AppendInt(w.b, int64(n), 10)
+	w.b = append(w.b, '\r', '\n')
+}
+
+// Raw appends an already encoded value.
+func (w *Writer) Raw(value string) {
+	w.b = append(w.b, value...)
+}
+
+// Len returns the number of bytes written so far.
+func (w *Writer) Len() int {
+	return len(w.b)
+}
+
+// Reset empties the Writer, keeping its buffer for what is written next.
+func (w *Writer) Reset() {
+	w.b = w.b[:0]
+}
+
+// String returns the reply.
+func (w *Writer) String() string {
+	return string(w.b)
+}
+
+// Bytes returns the reply, which aliases the Writer's buffer.
+func (w *Writer) Bytes() []byte {
+	return w.b
+}
@@ -0,0 +1,237 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"readpebble/internal/acl"
+	"readpebble/internal/auth"
+	"readpebble/internal/resp"
+)
+
+// checkACL enforces the ACL rules of the connection's user: the command
+// must be allowed by name or category, every key it touches must match one
+// of the user's key patterns and every channel it publishes or subscribes
+// to one of its channel patterns. Denials are recorded in the audit log.
+func (s *Server) checkACL(c *connection, spec *commandSpec, args []string) string {
+	if s.config.ACL == nil || c.replication || spec.Name == "auth" || spec.Name == "hello" {
+		return ""
+	}
+	username := c.username()
+	deny := func(reason, key, channel string) {
+		s.audit.record(auditEvent{
+			Event:   "acl-denied",
+			User:    username,
+			Addr:    c.conn.RemoteAddr().String(),
+			Command: spec.Name,
+			Key:     key,
+			Channel: channel,
+			Reason:  reason,
+		})
+	}
+
+	user := s.config.ACL.User(username)
+	if user == nil || !user.Enabled {
+		deny("user", "", "")
+		return resp.Errorf("NOPERM User %s has no permissions to run the '%s' command", username, spec.Name)
+	}
+	sub := ""
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	if !user.CanRun(spec.Name, sub, spec.Categories) {
+		deny("command", "", "")
+		return resp.Errorf("NOPERM User %s has no permissions to run the '%s' command", username, spec.Name)
+	}
+	keys, _ := getKeys(spec.Name, args)
+	for _, key := range keys {
+		if !user.CanAccess(key) {
+			deny("key", key, "")
+			return resp.Error("NOPERM No permissions to access a key")
+		}
+	}
+	channels, pattern := channelArgs(spec.Name, args)
+	for _, channel := range channels {
+		if !user.CanAccessChannel(channel, pattern) {
+			deny("channel", "", channel)
+			return resp.Error("NOPERM No permissions to access a channel")
+		}
+	}
+	return ""
+}
+
+// channelArgs returns the channels cmd, called with args, publishes or
+// subscribes to, and whether they are patterns.
+func channelArgs(cmd string, args []string) ([]string, bool) {
+	switch cmd {
+	case "publish":
+		return args[:1], false
+	case "subscribe":
+		return args, false
+	case "psubscribe":
+		return args, true
+	}
+	return nil, false
+}
+
+// username returns the user c authenticated as, the default user if it
+// did not.
+func (c *connection) username() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.user == "" {
+		return auth.DefaultUser
+	}
+	return c.user
+}
+
+// aclCommand implements ACL WHOAMI, ACL CAT [category], ACL SETUSER
+// username [rule ...], ACL GETUSER username, ACL DELUSER username
+// [username ...], ACL LIST, ACL USERS, ACL SAVE and ACL LOAD, the last two
+// writing the users to the ACL file and reading them back from it. Only
+// WHOAMI and CAT work without an ACL file.
+func (s *Server) aclCommand(c *connection, args []string) string {
+	sub := strings.ToLower(args[0])
+	switch sub {
+	case "whoami":
+		if len(args) != 1 {
+			return resp.Error("ERR wrong number of arguments for 'acl|whoami' command")
+		}
+		return resp.BulkString(c.username())
+	case "cat":
+		if len(args) == 1 {
+			return resp.StringArray(acl.Categories())
+		}
+		category := strings.ToLower(args[1])
+		if !slices.Contains(acl.Categories(), category) {
+			return resp.Error("ERR Unknown category '" + args[1] + "'")
+		}
+		var names []string
+		for _, name := range commandNames() {
+			if category == "all" || commandTable[name].hasCategory(category) {
+				names = append(names, name)
+			}
+		}
+		return resp.StringArray(names)
+	}
+	store := s.config.ACL
+	if store == nil {
+		return resp.Error("ERR ACL is not enabled, start the server with an ACL file")
+	}
+	var reply string
+	switch sub {
+	case "setuser":
+		if len(args) < 2 {
+			return resp.Error("ERR wrong number of arguments for 'acl|setuser' command")
+		}
+		if err := store.SetUser(args[1], args[2:]...); err != nil {
+			return resp.Error("ERR Error in ACL SETUSER modifier: " + err.Error())
+		}
+		reply = resp.OK
+	case "getuser":
+		if len(args) != 2 {
+			return resp.Error("ERR wrong number of arguments for 'acl|getuser' command")
+		}
+		return aclUser(c, store.User(args[1]))
+	case "deluser":
+		if len(args) < 2 {
+			return resp.Error("ERR wrong number of arguments for 'acl|deluser' command")
+		}
+		n, err := store.DelUser(args[1:]...)
+		if err != nil {
+			return resp.Error("ERR " + err.Error())
+		}
+		reply = resp.Integer(int64(n))
+	case "list":
+		var lines []string
+		for _, user := range store.Users() {
+			lines = append(lines, "user "+user.Name+" "+strings.Join(user.Rules(), " "))
+		}
+		return resp.StringArray(lines)
+	case "users":
+		var names []string
+		for _, user := range store.Users() {
+			names = append(names, user.Name)
+		}
+		return resp.StringArray(names)
+	case "save":
+		if err := store.Save(); err != nil {
+			return resp.Error("ERR There was an error trying to save the ACLs: " + err.Error())
+		}
+		return resp.OK
+	case "load":
+		if err := store.Load(); err != nil {
+			return resp.Error("ERR Error loading ACLs: " + err.Error())
+		}
+		reply = resp.OK
+	default:
+		return resp.Error("ERR unknown ACL subcommand '" + args[0] + "'")
+	}
+	s.audit.record(auditEvent{
+		Event:   "acl-changed",
+		User:    c.username(),
+		Addr:    c.conn.RemoteAddr().String(),
+		Command: "acl|" + sub,
+	})
+	return reply
+}
+
+// aclUser replies to ACL GETUSER with the rules of user, nil if there is
+// no such user.
+func aclUser(c *connection, user *acl.User) string {
+	if user == nil {
+		return resp.Nil
+	}
+	var w resp.Writer
+	resp3 := c.protocol >= 3
+	writeMapHeader(&w, resp3, 5)
+	for _, field := range []struct {
+		name   string
+		values []string
+	}{{"flags", user.Flags()}, {"passwords", user.PasswordHashes()}} {
+		w.BulkString(field.name)
+		w.Array(len(field.values))
+		for _, value := range field.values {
+			w.BulkString(value)
+		}
+	}
+	w.BulkString("commands")
+	w.BulkString(user.Commands())
+	w.BulkString("keys")
+	w.BulkString(prefixJoin("~", user.Keys()))
+	w.BulkString("channels")
+	w.BulkString(prefixJoin("&", user.Channels()))
+	return w.String()
+}
+
+// prefixJoin joins patterns with spaces, each with prefix.
+func prefixJoin(prefix string, patterns []string) string {
+	prefixed := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		prefixed[i] = prefix + pattern
+	}
+	return strings.Join(prefixed, " ")
+}
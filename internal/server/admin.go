@@ -0,0 +1,65 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"log"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+)
+
+// checkAdmin returns an error reply if c may not run spec: operational
+// commands, flagged "admin", on the data listener when an admin listener
+// exists.
+func (s *Server) checkAdmin(c *connection, spec *commandSpec) string {
+	if !c.admin && spec.hasFlag("admin") && s.adminSeparated() {
+		return resp.Error("ERR '" + spec.Name + "' is only available on the admin listener")
+	}
+	return ""
+}
+
+// shutdown stops the server. It runs in the background since Shutdown waits
+// for every connection, including this one, to finish.
+func (s *Server) shutdown(c *connection) string {
+	log.Printf("SHUTDOWN requested by %s", c.conn.RemoteAddr())
+	go s.Shutdown()
+	return resp.OK
+}
+
+// backup writes a consistent Pebble checkpoint to the given directory, which
+// must not exist yet, as a background job: it waits for the other jobs
+// using the disk to leave room for it.
+func (s *Server) backup(args []string) string {
+	dir := strings.TrimSpace(args[0])
+	err := s.runJob(jobBackup, func() error { return s.db.Checkpoint(dir, pebble.WithFlushedWAL()) })
+	if err != nil {
+		return resp.Error("ERR Failed to write backup: " + err.Error())
+	}
+	s.saved()
+	log.Printf("Backup written to %s", dir)
+	return resp.OK
+}
@@ -0,0 +1,84 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"log"
+	"strings"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/manifest"
+	"readpebble/internal/resp"
+)
+
+// apply implements APPLY [DRYRUN] manifest, bringing the collections in
+// line with a YAML manifest (see package manifest) so that deployment
+// tools can declare them rather than script VCREATE. It replies with the
+// changes made, such as "create docs" or "index docs category,price", or
+// with DRYRUN those that would be, and an empty array if there are none.
+func (s *Server) apply(args []string) string {
+	dryRun := false
+	if len(args) == 2 && strings.EqualFold(args[0], "dryrun") {
+		dryRun, args = true, args[1:]
+	}
+	if len(args) != 1 {
+		return resp.Error("ERR syntax error")
+	}
+	infos, err := manifest.Parse([]byte(args[0]))
+	if err != nil {
+		return resp.Error("ERR invalid manifest: " + err.Error())
+	}
+	var changes []collection.Change
+	apply := func() (err error) {
+		changes, err = s.collections.Apply(infos, dryRun)
+		return err
+	}
+	if dryRun {
+		err = apply()
+	} else {
+		// Indexing the fields of existing points is a background job.
+		err = s.runJob(jobIndexBuild, apply)
+	}
+	if err != nil {
+		return collectionError(err)
+	}
+	applied := make([]string, len(changes))
+	for i, change := range changes {
+		applied[i] = change.String()
+	}
+	return resp.StringArray(applied)
+}
+
+// applyManifestFile applies the manifest at path, logging the changes.
+func (s *Server) applyManifestFile(path string) error {
+	infos, err := manifest.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	changes, err := s.collections.Apply(infos, false)
+	for _, change := range changes {
+		log.Printf("Collections manifest: %s", change)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"readpebble/internal/metrics"
+)
+
+// auditEvent is a security-relevant event, written as one JSON line.
+type auditEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	User    string    `json:"user"`
+	Addr    string    `json:"addr"`
+	Command string    `json:"command"`
+	Key     string    `json:"key,omitempty"`
+	Channel string    `json:"channel,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// auditLog records security events to a dedicated file, or to the server
+// log when none is configured.
+type auditLog struct {
+	mutex    sync.Mutex
+	file     *os.File
+	registry *metrics.Registry
+}
+
+func newAuditLog(registry *metrics.Registry) *auditLog {
+	return &auditLog{registry: registry}
+}
+
+func (a *auditLog) open(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	a.file = file
+	a.mutex.Unlock()
+	return nil
+}
+
+func (a *auditLog) close() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+func (a *auditLog) record(event auditEvent) {
+	event.Time = time.Now()
+	a.registry.Counter("vecble_audit_events_total", "Security events recorded, by event.", "event", event.Event).Inc()
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode audit event: %v", err)
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file == nil {
+		log.Printf("Audit: %s", line)
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write audit event: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"log"
+
+	"readpebble/internal/auth"
+	"readpebble/internal/resp"
+)
+
+// authenticator returns the backend that checks AUTH on c, or nil when c
+// does not need to authenticate.
+func (s *Server) authenticator(c *connection) auth.Authenticator {
+	if c.admin && s.adminAuth != nil {
+		return s.adminAuth
+	}
+	return s.config.Authenticator
+}
+
+// checkAuth refuses everything but AUTH and HELLO until c authenticated
+// with the backend configured for its listener.
+func (s *Server) checkAuth(c *connection, spec *commandSpec) string {
+	if c.authenticated || spec.Name == "auth" || spec.Name == "hello" || s.authenticator(c) == nil {
+		return ""
+	}
+	return resp.Error("NOAUTH Authentication required.")
+}
+
+// auth implements AUTH password and AUTH username password.
+func (s *Server) auth(c *connection, args []string) string {
+	if len(args) > 2 {
+		return resp.Error("ERR syntax error")
+	}
+	authenticator := s.authenticator(c)
+	if authenticator == nil {
+		return resp.Error("ERR AUTH called without any password configured")
+	}
+	username, password := auth.DefaultUser, args[0]
+	if len(args) == 2 {
+		username, password = args[0], args[1]
+	}
+	user, err := authenticator.Authenticate(username, password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		log.Printf("Failed AUTH for user %q from %s", username, c.conn.RemoteAddr())
+		return resp.Error("WRONGPASS " + err.Error())
+	}
+	if err != nil {
+		log.Printf("Authentication backend failed for %s: %v", c.conn.RemoteAddr(), err)
+		return resp.Error("TRYAGAIN authentication backend unavailable")
+	}
+	c.mutex.Lock()
+	c.authenticated = true
+	c.user = user
+	c.mutex.Unlock()
+	return resp.OK
+}
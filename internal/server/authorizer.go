@@ -0,0 +1,84 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"sync"
+
+	"readpebble/internal/resp"
+)
+
+// Authorizer decides whether user may run cmd, the lower-case command name,
+// on keys, for applications embedding the server that enforce authorization
+// of their own. It is called for every command a client or script runs,
+// after the ACL allowed it, with a context canceled once the client
+// disconnects. A non-nil error denies the command, replying NOPERM with the
+// error's message.
+type Authorizer func(ctx context.Context, user, cmd string, keys []string) error
+
+// authorizers are the Authorizers registered with RegisterAuthorizer.
+type authorizers struct {
+	mutex sync.RWMutex
+	list  []Authorizer
+}
+
+// RegisterAuthorizer adds authorize to the checks every command must pass.
+// Authorizers run in the order they were registered and the first to
+// return an error denies the command. Commands replicated from a master and
+// AUTH and HELLO are not checked.
+func (s *Server) RegisterAuthorizer(authorize Authorizer) {
+	s.authorizers.mutex.Lock()
+	s.authorizers.list = append(s.authorizers.list, authorize)
+	s.authorizers.mutex.Unlock()
+}
+
+// checkAuthorizers runs the registered Authorizers on the command. Denials
+// are recorded in the audit log like those of the ACL.
+func (s *Server) checkAuthorizers(c *connection, spec *commandSpec, args []string) string {
+	if c.replication || spec.Name == "auth" || spec.Name == "hello" {
+		return ""
+	}
+	s.authorizers.mutex.RLock()
+	list := s.authorizers.list
+	s.authorizers.mutex.RUnlock()
+	if len(list) == 0 {
+		return ""
+	}
+	username := c.username()
+	keys, _ := getKeys(spec.Name, args)
+	for _, authorize := range list {
+		if err := authorize(c.context(), username, spec.Name, keys); err != nil {
+			s.audit.record(auditEvent{
+				Event:   "authorizer-denied",
+				User:    username,
+				Addr:    c.conn.RemoteAddr().String(),
+				Command: spec.Name,
+				Reason:  err.Error(),
+			})
+			return resp.Error("NOPERM " + err.Error())
+		}
+	}
+	return ""
+}
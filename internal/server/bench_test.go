@@ -0,0 +1,168 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+
+	"readpebble/internal/resp"
+)
+
+// newBenchServer returns a server over an in-memory Pebble store, without
+// listeners, so that commands can be dispatched directly.
+func newBenchServer(b testing.TB) *Server {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	db, err := pebble.Open("", &pebble.Options{FS: vfs.NewMem()})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	s := NewServer(db, Config{})
+	if err := s.collections.Load(); err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+func encodeCommand(args ...string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return buf.Bytes()
+}
+
+func randomVector(r *rand.Rand, dim int) []string {
+	vector := make([]string, dim)
+	for i := range vector {
+		vector[i] = strconv.FormatFloat(r.Float64(), 'f', 6, 64)
+	}
+	return vector
+}
+
+func BenchmarkParseRESP(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	r := rand.New(rand.NewSource(1))
+	for _, bench := range []struct {
+		name string
+		args []string
+	}{
+		{"inline", nil},
+		{"get", []string{"GET", "key:000001"}},
+		{"set", []string{"SET", "key:000001", string(bytes.Repeat([]byte("v"), 256))}},
+		{"vadd-128", append([]string{"VADD", "docs", "key:000001"}, randomVector(r, 128)...)},
+	} {
+		data := []byte("PING\r\n")
+		if bench.args != nil {
+			data = encodeCommand(bench.args...)
+		}
+		b.Run(bench.name, func(b *testing.B) {
+			reader := bytes.NewReader(data)
+			buffered := bufio.NewReader(reader)
+			commands := resp.NewReader(buffered)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				reader.Reset(data)
+				buffered.Reset(reader)
+				if _, _, err := readCommand(commands); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDispatch runs commands through handleCommand, including the
+// spec, ACL and durability handling around them, but not the network.
+func BenchmarkDispatch(b *testing.B) {
+	const points = 2000
+	r := rand.New(rand.NewSource(1))
+	c := &connection{protocol: 2}
+
+	b.Run("set", func(b *testing.B) {
+		s := newBenchServer(b)
+		value := string(bytes.Repeat([]byte("v"), 256))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s.handleCommand(c, "set", []string{"key:" + strconv.Itoa(i%points), value})
+		}
+	})
+	b.Run("get", func(b *testing.B) {
+		s := newBenchServer(b)
+		for i := 0; i < points; i++ {
+			s.handleCommand(c, "set", []string{"key:" + strconv.Itoa(i), "value"})
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.handleCommand(c, "get", []string{"key:" + strconv.Itoa(i%points)})
+		}
+	})
+	for _, index := range []string{"flat", "hnsw"} {
+		b.Run("vadd-"+index, func(b *testing.B) {
+			s := newBenchServer(b)
+			s.handleCommand(c, "vcreate", []string{"docs", "DIM", "128", "INDEX", index})
+			vectors := make([][]string, points)
+			for i := range vectors {
+				vectors[i] = randomVector(r, 128)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.handleCommand(c, "vadd", append([]string{"docs", "p" + strconv.Itoa(i%points)}, vectors[i%points]...))
+			}
+		})
+		b.Run("vsearch-"+index, func(b *testing.B) {
+			s := newBenchServer(b)
+			s.handleCommand(c, "vcreate", []string{"docs", "DIM", "128", "INDEX", index})
+			for i := 0; i < points; i++ {
+				s.handleCommand(c, "vadd", append([]string{"docs", "p" + strconv.Itoa(i)}, randomVector(r, 128)...))
+			}
+			query := append([]string{"docs", "10"}, randomVector(r, 128)...)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if reply := s.handleCommand(c, "vsearch", query); reply[0] == '-' {
+					b.Fatal(reply)
+				}
+			}
+		})
+	}
+}
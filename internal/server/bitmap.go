@@ -0,0 +1,345 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// maxBitOffset is the largest bit offset SETBIT may set, the last bit of a
+// string of maxStringSize bytes.
+const maxBitOffset = maxStringSize*8 - 1
+
+// Bits are numbered from the most significant bit of the first byte, as
+// in Redis, so bit 0 of "\x80" is set.
+
+// setBit implements SETBIT key offset value, setting or clearing the bit
+// at offset and replying with its previous value. A string shorter than
+// offset is padded with zero bytes first.
+func (s *Server) setBit(args []string) string {
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return resp.Error("ERR bit offset is not an integer or out of range")
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return resp.Error("ERR bit is not an integer or out of range")
+	}
+	defer s.keyLocks.lock(args[0])()
+	meta, value, _, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	buf := []byte(value)
+	i, mask := offset/8, byte(0x80>>(offset%8))
+	if need := int(i) + 1; need > len(buf) {
+		buf = append(buf, make([]byte, need-len(buf))...)
+	}
+	old := buf[i]&mask != 0
+	if args[2] == "1" {
+		buf[i] |= mask
+	} else {
+		buf[i] &^= mask
+	}
+	if err := s.storeString("setbit", []byte(args[0]), string(buf), meta); err != nil {
+		return stringError(err)
+	}
+	if old {
+		return resp.Integer(1)
+	}
+	return resp.Integer(0)
+}
+
+// getBit implements GETBIT key offset, replying 0 for bits past the end of
+// the string.
+func (s *Server) getBit(args []string) string {
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return resp.Error("ERR bit offset is not an integer or out of range")
+	}
+	_, value, _, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	if i := offset / 8; i < int64(len(value)) && value[i]&(0x80>>(offset%8)) != 0 {
+		return resp.Integer(1)
+	}
+	return resp.Integer(0)
+}
+
+// bitCount implements BITCOUNT key [start end [BYTE|BIT]], replying with
+// the number of set bits of the string, or of its bytes, or bits with BIT,
+// from start to end inclusive, which count from the end when negative.
+func (s *Server) bitCount(args []string) string {
+	if len(args) != 1 && len(args) != 3 && len(args) != 4 {
+		return resp.Error("ERR syntax error")
+	}
+	_, value, _, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	data := []byte(value)
+	if len(args) == 1 {
+		return resp.Integer(popCount(data))
+	}
+	lo, hi, reply := parseBitRange(args[1:], len(value))
+	if reply != "" {
+		return reply
+	}
+	if lo > hi {
+		return resp.Integer(0)
+	}
+	// Whole bytes are counted a word at a time, and the bits of the bytes
+	// the range starts and ends within one by one.
+	var n int64
+	for lo <= hi && lo%8 != 0 {
+		n += int64(bitAt(data, lo))
+		lo++
+	}
+	for hi >= lo && hi%8 != 7 {
+		n += int64(bitAt(data, hi))
+		hi--
+	}
+	if lo <= hi {
+		n += popCount(data[lo/8 : hi/8+1])
+	}
+	return resp.Integer(n)
+}
+
+// bitPos implements BITPOS key bit [start [end [BYTE|BIT]]], replying with
+// the position of the first bit set to bit from start to end, counted as
+// by BITCOUNT, or -1 if there is none. Looking for a clear bit without an
+// end finds the first bit past the string, since a string reads as zeros
+// beyond its end.
+func (s *Server) bitPos(args []string) string {
+	if args[1] != "0" && args[1] != "1" {
+		return resp.Error("ERR The bit argument must be 1 or 0.")
+	}
+	if len(args) > 5 {
+		return resp.Error("ERR syntax error")
+	}
+	want := args[1] == "1"
+	_, value, exists, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	if !exists {
+		if want {
+			return resp.Integer(-1)
+		}
+		return resp.Integer(0)
+	}
+	rangeArgs := args[2:]
+	if len(rangeArgs) == 1 {
+		rangeArgs = append(rangeArgs, "-1")
+	}
+	lo, hi := int64(0), int64(len(value))*8-1
+	if len(rangeArgs) > 0 {
+		var reply string
+		if lo, hi, reply = parseBitRange(rangeArgs, len(value)); reply != "" {
+			return reply
+		}
+	}
+	if lo > hi {
+		return resp.Integer(-1)
+	}
+	data := []byte(value)
+	// The bytes that are all the other bit are skipped a word at a time.
+	skip := uint64(0)
+	if !want {
+		skip = ^skip
+	}
+	for pos := lo; pos <= hi; {
+		if pos%64 == 0 && pos+63 <= hi && binary.BigEndian.Uint64(data[pos/8:]) == skip {
+			pos += 64
+			continue
+		}
+		if (bitAt(data, pos) == 1) == want {
+			return resp.Integer(pos)
+		}
+		pos++
+	}
+	if !want && len(args) < 4 {
+		return resp.Integer(hi + 1)
+	}
+	return resp.Integer(-1)
+}
+
+// bitOp implements BITOP AND|OR|XOR|NOT destkey key [key ...], storing
+// the result of the operation on the strings at the keys at destkey and
+// replying with its length, that of the longest string. Keys that do not
+// exist read as empty strings, and shorter strings as padded with zero
+// bytes. NOT takes a single key. The strings are combined a word at a
+// time, and an empty result deletes destkey.
+func (s *Server) bitOp(args []string) string {
+	op := strings.ToLower(args[0])
+	dest, keys := args[1], args[2:]
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(keys) != 1 {
+			return resp.Error("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return resp.Error("ERR syntax error")
+	}
+	defer s.keyLocks.lock(dest)()
+	values := make([][]byte, len(keys))
+	size := 0
+	for i, key := range keys {
+		_, value, _, err := s.loadString([]byte(key))
+		if err != nil {
+			return stringError(err)
+		}
+		values[i] = []byte(value)
+		size = max(size, len(value))
+	}
+	result := make([]byte, size)
+	if op == "not" {
+		for i := 0; i < size; i++ {
+			result[i] = ^values[0][i]
+		}
+	} else {
+		copy(result, values[0])
+		for _, value := range values[1:] {
+			combineBits(op, result, value)
+		}
+	}
+
+	key := []byte(dest)
+	meta, exists, err := s.lookup(key)
+	if err != nil {
+		return stringError(err)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	switch {
+	case size > 0:
+		// Like SET, the destination loses whatever it held and its expiry.
+		if exists {
+			storage.DeleteElements(batch, key, meta)
+		}
+		batch.Set(key, result, nil)
+		storage.SetMeta(batch, key, storage.NewMeta(storage.ObjecTypeString))
+	case exists:
+		storage.DeleteValue(batch, key, meta)
+	default:
+		return resp.Integer(0)
+	}
+	if err := s.committer.Commit(batch, s.writeMode("bitop")); err != nil {
+		return stringError(err)
+	}
+	return resp.Integer(int64(size))
+}
+
+// combineBits applies op, "and", "or" or "xor", to result and value, a
+// word at a time, storing the outcome in result. value is padded with
+// zero bytes to the length of result.
+func combineBits(op string, result, value []byte) {
+	i := 0
+	for ; i+8 <= len(value); i += 8 {
+		a, b := binary.LittleEndian.Uint64(result[i:]), binary.LittleEndian.Uint64(value[i:])
+		switch op {
+		case "and":
+			a &= b
+		case "or":
+			a |= b
+		case "xor":
+			a ^= b
+		}
+		binary.LittleEndian.PutUint64(result[i:], a)
+	}
+	for ; i < len(result); i++ {
+		var b byte
+		if i < len(value) {
+			b = value[i]
+		}
+		switch op {
+		case "and":
+			result[i] &= b
+		case "or":
+			result[i] |= b
+		case "xor":
+			result[i] ^= b
+		}
+	}
+}
+
+// parseBitRange parses the start, end and optional BYTE or BIT unit of
+// BITCOUNT and BITPOS into the first and last bit of the range in a
+// string of n bytes, clamped to the string. It returns an error reply if
+// they are invalid.
+func parseBitRange(args []string, n int) (int64, int64, string) {
+	start, err1 := strconv.ParseInt(args[0], 10, 64)
+	end, err2 := strconv.ParseInt(args[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, resp.Error("ERR value is not an integer or out of range")
+	}
+	size := int64(n)
+	unitBits := int64(8)
+	if len(args) == 3 {
+		switch strings.ToLower(args[2]) {
+		case "byte":
+		case "bit":
+			size, unitBits = size*8, 1
+		default:
+			return 0, 0, resp.Error("ERR syntax error")
+		}
+	}
+	if start < 0 {
+		start = max(start+size, 0)
+	}
+	if end < 0 {
+		end = max(end+size, 0)
+	}
+	end = min(end, size-1)
+	if start > end {
+		return 1, 0, ""
+	}
+	return start * unitBits, end*unitBits + unitBits - 1, ""
+}
+
+// bitAt returns the bit at pos of value.
+func bitAt(value []byte, pos int64) int {
+	return int(value[pos/8]>>(7-pos%8)) & 1
+}
+
+// popCount returns the number of set bits of value, counted a word at a
+// time.
+func popCount(value []byte) int64 {
+	var n int
+	i := 0
+	for ; i+8 <= len(value); i += 8 {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(value[i:]))
+	}
+	for ; i < len(value); i++ {
+		n += bits.OnesCount8(value[i])
+	}
+	return int64(n)
+}
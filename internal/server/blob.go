@@ -0,0 +1,130 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"readpebble/internal/blob"
+	"readpebble/internal/resp"
+)
+
+// blobCommand implements BLOB PUT data [TYPE content-type], replying with
+// the ID of the stored document, BLOB GET id, BLOB INFO id and BLOB DELETE
+// id. Points refer to a document by keeping its ID in their payload. Every
+// PUT of a document adds a reference to it and every DELETE drops one, so
+// that a document shared by several points is kept until the last of them
+// lets it go.
+func (s *Server) blobCommand(args []string) string {
+	sub := strings.ToLower(args[0])
+	switch sub {
+	case "put":
+		return s.blobPut(args[1:])
+	case "get", "info", "delete":
+		if len(args) != 2 {
+			return resp.Error("ERR wrong number of arguments for 'blob|" + sub + "' command")
+		}
+	default:
+		return resp.Error("ERR unknown BLOB subcommand '" + args[0] + "'")
+	}
+
+	id := args[1]
+	ctx := context.Background()
+	switch sub {
+	case "get":
+		data, _, err := s.blobs.Get(ctx, id)
+		if errors.Is(err, blob.ErrNotFound) {
+			return resp.Nil
+		}
+		if err != nil {
+			return blobError(err)
+		}
+		return resp.BulkString(string(data))
+	case "info":
+		info, err := s.blobs.Stat(id)
+		if errors.Is(err, blob.ErrNotFound) {
+			return resp.Nil
+		}
+		if err != nil {
+			return blobError(err)
+		}
+		return blobInfo(info)
+	}
+	deleted, err := s.blobs.Delete(ctx, id, s.writeMode("blob"))
+	if err != nil {
+		return blobError(err)
+	}
+	if !deleted {
+		return resp.Integer(0)
+	}
+	return resp.Integer(1)
+}
+
+func (s *Server) blobPut(args []string) string {
+	if len(args) != 1 && len(args) != 3 {
+		return resp.Error("ERR wrong number of arguments for 'blob|put' command")
+	}
+	var contentType string
+	if len(args) == 3 {
+		if strings.ToLower(args[1]) != "type" {
+			return resp.Error("ERR syntax error")
+		}
+		contentType = args[2]
+	}
+	id, err := s.blobs.Put(context.Background(), []byte(args[0]), contentType, s.writeMode("blob"))
+	if err != nil {
+		return blobError(err)
+	}
+	return resp.BulkString(id)
+}
+
+func blobError(err error) string {
+	if errors.Is(err, blob.ErrInvalidID) {
+		return resp.Error("ERR " + err.Error())
+	}
+	return resp.Error("ERR Failed to access blob: " + err.Error())
+}
+
+// blobInfo renders info as field-value pairs.
+func blobInfo(info blob.Info) string {
+	var w resp.Writer
+	w.Array(14)
+	w.BulkString("id")
+	w.BulkString(info.ID)
+	w.BulkString("size")
+	w.Integer(info.Size)
+	w.BulkString("content_type")
+	w.BulkString(info.ContentType)
+	w.BulkString("chunks")
+	w.Integer(int64(info.Chunks))
+	w.BulkString("offloaded_chunks")
+	w.Integer(int64(info.Offloaded))
+	w.BulkString("refs")
+	w.Integer(info.Refs)
+	w.BulkString("created_ms")
+	w.Integer(info.Created.UnixMilli())
+	return w.String()
+}
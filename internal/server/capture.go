@@ -0,0 +1,222 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"readpebble/internal/capture"
+	"readpebble/internal/resp"
+)
+
+// captureQueue bounds the commands waiting to be written to a capture. A
+// capture falling further behind drops commands rather than slowing down
+// the clients, and counts them.
+const captureQueue = 1 << 16
+
+// uncapturedCommands are left out of captures: AUTH for its password,
+// CAPTURE itself, and the commands replicas attach with.
+var uncapturedCommands = map[string]bool{
+	"auth": true, "capture": true, "psync": true, "sync": true, "replconf": true,
+}
+
+// captureSession records the commands of client connections to a file
+// (see package capture), for vecble-replay to replay against another
+// instance, as set up by CAPTURE START or CaptureFile. Commands are
+// queued as they are received and written by a goroutine of their own.
+type captureSession struct {
+	path    string
+	started time.Time
+	writer  *capture.Writer
+	queue   chan capture.Record
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+
+	recorded atomic.Int64
+	dropped  atomic.Int64
+
+	mutex sync.Mutex
+	// err is the error that ended the capture early, if any.
+	err error
+}
+
+// startCapture starts capturing commands to path.
+func (s *Server) startCapture(path string) error {
+	s.captureMutex.Lock()
+	defer s.captureMutex.Unlock()
+	if cs := s.capture.Load(); cs != nil {
+		return fmt.Errorf("already capturing to %s", cs.path)
+	}
+	writer, err := capture.Create(path)
+	if err != nil {
+		return err
+	}
+	cs := &captureSession{
+		path:    path,
+		started: time.Now(),
+		writer:  writer,
+		queue:   make(chan capture.Record, captureQueue),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	s.capture.Store(cs)
+	s.goTracked(subsystemCapture, func() { cs.run(s.quitCh) })
+	log.Printf("Capturing commands to %s", path)
+	return nil
+}
+
+// stopCapture stops the running capture once the commands queued are
+// written, returning it, or nil if there was none.
+func (s *Server) stopCapture() *captureSession {
+	s.captureMutex.Lock()
+	defer s.captureMutex.Unlock()
+	cs := s.capture.Swap(nil)
+	if cs == nil {
+		return nil
+	}
+	close(cs.stopCh)
+	<-cs.doneCh
+	log.Printf("Captured %d commands to %s", cs.recorded.Load(), cs.path)
+	return cs
+}
+
+// captureReceived queues cmd, received on c, if commands are captured.
+func (s *Server) captureReceived(c *connection, cmd string, args []string) {
+	cs := s.capture.Load()
+	if cs == nil || uncapturedCommands[cmd] {
+		return
+	}
+	if cmd == "hello" && len(args) > 1 {
+		// Only the protocol version: the rest may hold a password.
+		args = args[:1]
+	}
+	record := capture.Record{Time: time.Now(), Conn: c.id, Args: append([]string{cmd}, args...)}
+	select {
+	case cs.queue <- record:
+	default:
+		cs.dropped.Add(1)
+	}
+}
+
+// run writes the queued commands until the capture is stopped or the
+// server stops, flushing them whenever the queue empties. Commands are no
+// longer written once writing failed.
+func (cs *captureSession) run(quitCh chan struct{}) {
+	defer close(cs.doneCh)
+	write := func(record capture.Record) {
+		if cs.failed() {
+			return
+		}
+		if err := cs.writer.Write(record); err != nil {
+			cs.fail(err)
+			return
+		}
+		cs.recorded.Add(1)
+	}
+loop:
+	for {
+		select {
+		case record := <-cs.queue:
+			write(record)
+			if len(cs.queue) == 0 && !cs.failed() {
+				if err := cs.writer.Flush(); err != nil {
+					cs.fail(err)
+				}
+			}
+		case <-cs.stopCh:
+			break loop
+		case <-quitCh:
+			break loop
+		}
+	}
+	for n := len(cs.queue); n > 0; n-- {
+		write(<-cs.queue)
+	}
+	if err := cs.writer.Close(); err != nil && !cs.failed() {
+		cs.fail(err)
+	}
+}
+
+func (cs *captureSession) fail(err error) {
+	log.Printf("Capture to %s failed: %v", cs.path, err)
+	cs.mutex.Lock()
+	cs.err = err
+	cs.mutex.Unlock()
+}
+
+func (cs *captureSession) failed() bool {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.err != nil
+}
+
+// captureCommand implements CAPTURE START path, capturing the
+// commands of client connections to the file path, which is replaced if
+// it exists, and CAPTURE STOP, replying with how many were captured.
+func (s *Server) captureCommand(args []string) string {
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "start" && len(args) == 2:
+		if err := s.startCapture(args[1]); err != nil {
+			return resp.Error("ERR Failed to start capture: " + err.Error())
+		}
+		return resp.OK
+	case sub == "stop" && len(args) == 1:
+		cs := s.stopCapture()
+		if cs == nil {
+			return resp.Error("ERR no capture running")
+		}
+		if cs.err != nil {
+			return resp.Error("ERR Capture failed: " + cs.err.Error())
+		}
+		return resp.Integer(cs.recorded.Load())
+	case sub == "start" || sub == "stop":
+		return resp.Error("ERR wrong number of arguments for 'capture|" + sub + "' command")
+	}
+	return resp.Error("ERR unknown CAPTURE subcommand '" + args[0] + "'")
+}
+
+// infoCapture reports the running capture.
+func (s *Server) infoCapture(b *strings.Builder) {
+	cs := s.capture.Load()
+	if cs == nil {
+		b.WriteString("capture_enabled:0\r\n")
+		return
+	}
+	b.WriteString("capture_enabled:1\r\n")
+	fmt.Fprintf(b, "capture_file:%s\r\n", cs.path)
+	fmt.Fprintf(b, "capture_started:%d\r\n", cs.started.Unix())
+	fmt.Fprintf(b, "capture_recorded:%d\r\n", cs.recorded.Load())
+	fmt.Fprintf(b, "capture_dropped:%d\r\n", cs.dropped.Load())
+	fmt.Fprintf(b, "capture_queued:%d\r\n", len(cs.queue))
+	cs.mutex.Lock()
+	if cs.err != nil {
+		fmt.Fprintf(b, "capture_error:%s\r\n", cs.err)
+	}
+	cs.mutex.Unlock()
+}
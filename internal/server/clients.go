@@ -0,0 +1,366 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"readpebble/internal/resp"
+)
+
+// clientInfo is a point-in-time view of a connection for listings.
+type clientInfo struct {
+	ID          int64     `json:"id"`
+	Addr        string    `json:"addr"`
+	LocalAddr   string    `json:"local_addr"`
+	Name        string    `json:"name,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Protocol    int       `json:"protocol"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastCommand string    `json:"last_command"`
+	LastActive  time.Time `json:"last_active"`
+	// Replica is set for the connections of replicas.
+	Replica bool `json:"replica,omitempty"`
+	NoEvict bool `json:"no_evict,omitempty"`
+	// Channels and Patterns count the Pub/Sub subscriptions, and
+	// PendingMessages the messages published but not sent yet.
+	Channels        int `json:"channels"`
+	Patterns        int `json:"patterns"`
+	PendingMessages int `json:"pending_messages"`
+	// QueryBuffer and OutputBuffer are the bytes of commands received but
+	// not run yet, and of replies not sent yet, after the last command.
+	QueryBuffer  int `json:"query_buffer"`
+	OutputBuffer int `json:"output_buffer"`
+}
+
+// clientType returns the type CLIENT LIST TYPE and CLIENT KILL TYPE match.
+func (info *clientInfo) clientType() string {
+	switch {
+	case info.Replica:
+		return "replica"
+	case info.Channels+info.Patterns > 0:
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// line formats info as a line of CLIENT LIST.
+func (info *clientInfo) line(now time.Time) string {
+	flags := ""
+	if info.Replica {
+		flags += "S"
+	}
+	if info.Channels+info.Patterns > 0 {
+		flags += "P"
+	}
+	if info.NoEvict {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	user := info.User
+	if user == "" {
+		user = "default"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d qbuf=%d obl=%d oll=%d cmd=%s user=%s resp=%d",
+		info.ID, info.Addr, info.LocalAddr, info.Name,
+		int64(now.Sub(info.CreatedAt).Seconds()), int64(now.Sub(info.LastActive).Seconds()),
+		flags, info.Channels, info.Patterns, info.QueryBuffer, info.OutputBuffer, info.PendingMessages,
+		info.LastCommand, user, info.Protocol)
+}
+
+// clientRegistry tracks every open connection.
+type clientRegistry struct {
+	mutex   sync.RWMutex
+	nextID  int64
+	clients map[int64]*connection
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{
+		clients: make(map[int64]*connection),
+	}
+}
+
+func (r *clientRegistry) add(c *connection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.nextID++
+	c.id = r.nextID
+	r.clients[c.id] = c
+}
+
+func (r *clientRegistry) remove(c *connection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.clients, c.id)
+}
+
+func (r *clientRegistry) count() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.clients)
+}
+
+// connections returns every open connection, sorted by ID.
+func (r *clientRegistry) connections() []*connection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	conns := make([]*connection, 0, len(r.clients))
+	for _, c := range r.clients {
+		conns = append(conns, c)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	return conns
+}
+
+// clientInfo returns the view of c for listings.
+func (s *Server) clientInfo(c *connection) clientInfo {
+	info := c.info()
+	info.Channels, info.Patterns, info.PendingMessages = s.pubsub.counts(c)
+	return info
+}
+
+// clientList returns the view of every open connection, sorted by ID.
+func (s *Server) clientList() []clientInfo {
+	conns := s.clients.connections()
+	infos := make([]clientInfo, len(conns))
+	for i, c := range conns {
+		infos[i] = s.clientInfo(c)
+	}
+	return infos
+}
+
+// client implements CLIENT ID, CLIENT INFO, CLIENT LIST [TYPE type] [ID
+// id [id ...]], CLIENT SETNAME name, CLIENT GETNAME, CLIENT KILL and
+// CLIENT NO-EVICT ON|OFF. No-evict is only reported by CLIENT LIST:
+// vecble does not evict clients to bound their memory yet.
+func (s *Server) client(c *connection, args []string) string {
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "id" && len(args) == 1:
+		return resp.Integer(c.id)
+	case sub == "info" && len(args) == 1:
+		info := s.clientInfo(c)
+		return resp.BulkString(info.line(time.Now()) + "\n")
+	case sub == "list":
+		return s.clientListCommand(args[1:])
+	case sub == "setname" && len(args) == 2:
+		for _, r := range args[1] {
+			if r < '!' || r > '~' {
+				return resp.Error("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+		}
+		c.mutex.Lock()
+		c.name = args[1]
+		c.mutex.Unlock()
+		return resp.OK
+	case sub == "getname" && len(args) == 1:
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.name == "" {
+			return resp.Nil
+		}
+		return resp.BulkString(c.name)
+	case sub == "kill" && len(args) >= 2:
+		return s.clientKill(c, args[1:])
+	case sub == "no-evict" && len(args) == 2:
+		var noEvict bool
+		switch strings.ToLower(args[1]) {
+		case "on":
+			noEvict = true
+		case "off":
+		default:
+			return resp.Error("ERR syntax error")
+		}
+		c.mutex.Lock()
+		c.noEvict = noEvict
+		c.mutex.Unlock()
+		return resp.OK
+	case sub == "id" || sub == "info" || sub == "setname" || sub == "getname" || sub == "kill" || sub == "no-evict":
+		return resp.Error("ERR wrong number of arguments for 'client|" + sub + "' command")
+	}
+	return resp.Error("ERR unknown CLIENT subcommand '" + args[0] + "'")
+}
+
+// clientListCommand implements CLIENT LIST [TYPE type] [ID id [id ...]].
+func (s *Server) clientListCommand(args []string) string {
+	var filter clientFilter
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "type") && i+1 < len(args):
+			i++
+			if err := filter.setType(args[i]); err != "" {
+				return err
+			}
+		case strings.EqualFold(args[i], "id") && i+1 < len(args):
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil || id <= 0 {
+					return resp.Error("ERR Invalid client ID")
+				}
+				filter.ids = append(filter.ids, id)
+			}
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+	var b strings.Builder
+	now := time.Now()
+	for _, info := range s.clientList() {
+		if filter.match(&info, now) {
+			b.WriteString(info.line(now))
+			b.WriteByte('\n')
+		}
+	}
+	return resp.BulkString(b.String())
+}
+
+// clientKill implements CLIENT KILL addr, closing the connection from
+// addr, and CLIENT KILL [ID id] [ADDR addr] [LADDR addr] [USER user]
+// [TYPE type] [MAXAGE seconds] [SKIPME yes|no], closing the connections
+// matching every filter given and replying with how many there were.
+// Connections killing themselves close once the reply is sent.
+func (s *Server) clientKill(c *connection, args []string) string {
+	filter := clientFilter{skip: c.id}
+	legacy := len(args) == 1
+	if legacy {
+		filter.addr = args[0]
+		filter.skip = 0
+	} else {
+		if len(args)%2 != 0 {
+			return resp.Error("ERR syntax error")
+		}
+		for i := 0; i < len(args); i += 2 {
+			value := args[i+1]
+			switch strings.ToLower(args[i]) {
+			case "id":
+				id, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || id <= 0 {
+					return resp.Error("ERR client-id should be greater than 0")
+				}
+				filter.ids = append(filter.ids, id)
+			case "addr":
+				filter.addr = value
+			case "laddr":
+				filter.localAddr = value
+			case "user":
+				filter.user = value
+			case "type":
+				if err := filter.setType(value); err != "" {
+					return err
+				}
+			case "maxage":
+				age, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || age < 0 {
+					return resp.Error("ERR value is not an integer or out of range")
+				}
+				filter.maxAge = time.Duration(age) * time.Second
+			case "skipme":
+				switch strings.ToLower(value) {
+				case "yes":
+					filter.skip = c.id
+				case "no":
+					filter.skip = 0
+				default:
+					return resp.Error("ERR syntax error")
+				}
+			default:
+				return resp.Error("ERR syntax error")
+			}
+		}
+	}
+	killed := 0
+	now := time.Now()
+	for _, conn := range s.clients.connections() {
+		info := s.clientInfo(conn)
+		if conn.id == filter.skip || !filter.match(&info, now) {
+			continue
+		}
+		killed++
+		if conn == c {
+			c.killed = true
+		} else {
+			conn.close()
+		}
+	}
+	if legacy {
+		if killed == 0 {
+			return resp.Error("ERR No such client")
+		}
+		return resp.OK
+	}
+	return resp.Integer(int64(killed))
+}
+
+// clientFilter selects the connections of CLIENT LIST and CLIENT KILL.
+// Zero fields match every connection.
+type clientFilter struct {
+	ids        []int64
+	addr       string
+	localAddr  string
+	user       string
+	clientType string
+	// maxAge matches connections older than it.
+	maxAge time.Duration
+	// skip is the ID of a connection never matched.
+	skip int64
+}
+
+func (f *clientFilter) setType(value string) string {
+	switch t := strings.ToLower(value); t {
+	case "normal", "replica", "pubsub", "master":
+		f.clientType = t
+	case "slave":
+		f.clientType = "replica"
+	default:
+		return resp.Error("ERR Unknown client type '" + value + "'")
+	}
+	return ""
+}
+
+func (f *clientFilter) match(info *clientInfo, now time.Time) bool {
+	user := info.User
+	if user == "" {
+		user = "default"
+	}
+	switch {
+	case len(f.ids) > 0 && !slices.Contains(f.ids, info.ID):
+	case f.addr != "" && f.addr != info.Addr:
+	case f.localAddr != "" && f.localAddr != info.LocalAddr:
+	case f.user != "" && f.user != user:
+	case f.clientType != "" && f.clientType != info.clientType():
+	case f.maxAge > 0 && now.Sub(info.CreatedAt) < f.maxAge:
+	default:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"strings"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/slot"
+)
+
+// checkSlots rejects, in cluster mode, a command whose keys hash to more
+// than one slot, since those keys could live on different nodes and the
+// command could not be applied atomically.
+func (s *Server) checkSlots(spec *commandSpec, args []string) string {
+	if !s.config.ClusterEnabled {
+		return ""
+	}
+	keys, err := getKeys(spec.Name, args)
+	if err != nil || len(keys) < 2 {
+		return ""
+	}
+	first := slot.ForKey(keys[0])
+	for _, key := range keys[1:] {
+		if slot.ForKey(key) != first {
+			return resp.Error("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+	return ""
+}
+
+// cluster implements CLUSTER KEYSLOT key.
+func (s *Server) cluster(args []string) string {
+	switch strings.ToLower(args[0]) {
+	case "keyslot":
+		if len(args) != 2 {
+			return resp.Error("ERR wrong number of arguments for 'cluster|keyslot' command")
+		}
+		return resp.Integer(int64(slot.ForKey(args[1])))
+	default:
+		return resp.Error("ERR unknown CLUSTER subcommand '" + args[0] + "'")
+	}
+}
@@ -0,0 +1,467 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+func (s *Server) handleCommand(c *connection, cmd string, args []string) string {
+	spec, ok := commandTable[cmd]
+	if !ok {
+		return resp.Error("ERR unknown command '" + cmd + "'")
+	}
+	if !spec.validArity(args) {
+		return resp.Error("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	if reply := s.checkAuth(c, spec); reply != "" {
+		return reply
+	}
+	if reply := s.checkACL(c, spec, args); reply != "" {
+		return reply
+	}
+	if reply := s.checkAuthorizers(c, spec, args); reply != "" {
+		return reply
+	}
+	if reply := s.checkAdmin(c, spec); reply != "" {
+		return reply
+	}
+	if reply := checkReservedKeys(spec, args); reply != "" {
+		return reply
+	}
+	if c.subscribedMode() && !subscribedCommands[cmd] {
+		return resp.Error("ERR Can't execute '" + cmd + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+	}
+	if reply := s.checkLoading(c, spec); reply != "" {
+		return reply
+	}
+	if reply := s.checkSlots(spec, args); reply != "" {
+		return reply
+	}
+	if spec.writes(args) && !c.replication && s.isReplica() {
+		return resp.Error("READONLY You can't write against a read only replica.")
+	}
+	if reply := s.checkDiskSpace(c, spec, args); reply != "" {
+		return reply
+	}
+	if reply := s.checkMemory(c, spec, args); reply != "" {
+		return reply
+	}
+	if reply := s.checkConsistency(c, spec); reply != "" {
+		return reply
+	}
+
+	// Scripts run alone (see script.go): every other command holds
+	// scriptLock shared, while the commands scripts call run under the
+	// script's hold.
+	if !c.scripting && !scriptCommands[cmd] {
+		s.scriptLock.RLock()
+		defer s.scriptLock.RUnlock()
+	}
+	// Writes are propagated before they give up the write order, so that
+	// replicas apply them in the order they were committed in.
+	if spec.writes(args) && !c.replication {
+		keys, _ := getKeys(cmd, args)
+		held := c.order
+		c.order = s.order.hold(keys)
+		defer func() {
+			c.order.release()
+			c.order = held
+		}()
+	}
+	reply := spec.handler(s, c, args)
+	if !strings.HasPrefix(reply, "-") {
+		s.checkSoftLimits(c, spec, args)
+	}
+	if s.shouldShadow(spec, args, reply) {
+		s.shadow.forward(cmd, args)
+	}
+	if s.shouldPropagate(c, spec, args, reply) {
+		s.propagate(cmd, args, reply)
+	}
+	if spec.writes(args) && !strings.HasPrefix(reply, "-") {
+		s.notifyCommand(spec, args)
+	}
+	return reply
+}
+
+// commandHandler runs a command, given its arguments without the command
+// name, and returns the reply.
+type commandHandler func(s *Server, c *connection, args []string) string
+
+// withArgs adapts a handler that does not need the connection.
+func withArgs(f func(*Server, []string) string) commandHandler {
+	return func(s *Server, _ *connection, args []string) string {
+		return f(s, args)
+	}
+}
+
+// withName adapts a handler shared by several commands, which it tells
+// apart by name.
+func withName(name string, f func(*Server, string, []string) string) commandHandler {
+	return func(s *Server, _ *connection, args []string) string {
+		return f(s, name, args)
+	}
+}
+
+// setOp returns the handler of a set combining command.
+func setOp(cmd string) commandHandler {
+	return func(s *Server, c *connection, args []string) string {
+		return s.combineSets(c, cmd, args)
+	}
+}
+
+// vectorOp returns the handler of a vector arithmetic command.
+func vectorOp(cmd string, op collection.VectorOp) commandHandler {
+	return func(s *Server, _ *connection, args []string) string {
+		return s.vectorArithmetic(cmd, op, args)
+	}
+}
+
+// commandHandlers maps every command in commands.json to its handler. They
+// are attached to commandTable by init rather than by loadCommandTable,
+// since handlers such as COMMAND read the table themselves.
+var commandHandlers = map[string]commandHandler{
+	"append":          withArgs((*Server).appendCommand),
+	"apply":           withArgs((*Server).apply),
+	"acl":             (*Server).aclCommand,
+	"auth":            (*Server).auth,
+	"backup":          withArgs((*Server).backup),
+	"bitcount":        withArgs((*Server).bitCount),
+	"bitop":           withArgs((*Server).bitOp),
+	"bitpos":          withArgs((*Server).bitPos),
+	"blob":            withArgs((*Server).blobCommand),
+	"capture":         withArgs((*Server).captureCommand),
+	"client":          (*Server).client,
+	"cluster":         withArgs((*Server).cluster),
+	"command":         (*Server).command,
+	"config":          withArgs((*Server).configCommand),
+	"copy":            withArgs((*Server).copyCommand),
+	"dbsize":          withArgs(func(s *Server, _ []string) string { return s.dbsize() }),
+	"debug":           withArgs((*Server).debug),
+	"decr":            withName("decr", (*Server).incr),
+	"decrby":          withName("decrby", (*Server).incr),
+	"del":             withArgs((*Server).del),
+	"digest":          withArgs((*Server).digest),
+	"eval":            func(s *Server, c *connection, args []string) string { return s.eval(c, args, false) },
+	"evalsha":         func(s *Server, c *connection, args []string) string { return s.eval(c, args, true) },
+	"exists":          withArgs((*Server).exists),
+	"embed":           withArgs((*Server).embedCommand),
+	"expire":          withName("expire", (*Server).expire),
+	"expireat":        withName("expireat", (*Server).expire),
+	"dump":            withArgs((*Server).dump),
+	"fcall":           func(s *Server, c *connection, args []string) string { return s.fcall(c, args, false) },
+	"fcall_ro":        func(s *Server, c *connection, args []string) string { return s.fcall(c, args, true) },
+	"fields":          withArgs((*Server).fields),
+	"flushall":        withName("flushall", (*Server).flush),
+	"flushdb":         withName("flushdb", (*Server).flush),
+	"function":        (*Server).function,
+	"get":             withArgs((*Server).get),
+	"getbit":          withArgs((*Server).getBit),
+	"getdel":          withArgs((*Server).getDel),
+	"getex":           withArgs((*Server).getEx),
+	"getrange":        withArgs((*Server).getRange),
+	"getset":          withArgs((*Server).getSet),
+	"hdel":            withArgs((*Server).hdel),
+	"hello":           (*Server).hello,
+	"hget":            withArgs((*Server).hget),
+	"hgetall":         (*Server).hgetall,
+	"hincrby":         withArgs((*Server).hincrby),
+	"hmget":           withArgs((*Server).hmget),
+	"hscan":           withArgs((*Server).hscan),
+	"hset":            withArgs((*Server).hset),
+	"incr":            withName("incr", (*Server).incr),
+	"incrby":          withName("incrby", (*Server).incr),
+	"incrbyfloat":     withArgs((*Server).incrByFloat),
+	"info":            withArgs((*Server).info),
+	"keys":            (*Server).keys,
+	"lastsave":        withArgs(func(s *Server, _ []string) string { return s.lastSave() }),
+	"lindex":          withArgs((*Server).lindex),
+	"llen":            withArgs((*Server).llen),
+	"lpop":            withName("lpop", (*Server).pop),
+	"lpush":           withName("lpush", (*Server).push),
+	"lrange":          withArgs((*Server).lrange),
+	"ltrim":           withArgs((*Server).ltrim),
+	"mget":            withArgs((*Server).mget),
+	"migrate":         withArgs((*Server).migrate),
+	"mset":            withArgs((*Server).mset),
+	"persist":         withArgs((*Server).persist),
+	"pexpire":         withName("pexpire", (*Server).expire),
+	"pexpireat":       withName("pexpireat", (*Server).expire),
+	"ping":            (*Server).ping,
+	"psubscribe":      func(s *Server, c *connection, args []string) string { return s.subscribe(c, args, true) },
+	"psync":           (*Server).psync,
+	"pttl":            withName("pttl", (*Server).ttl),
+	"publish":         withArgs((*Server).publish),
+	"pubsub":          (*Server).pubsubCommand,
+	"punsubscribe":    func(s *Server, c *connection, args []string) string { return s.unsubscribe(c, args, true) },
+	"readconsistency": (*Server).readConsistency,
+	"queue":           withArgs((*Server).queueCommand),
+	"randomkey":       withArgs(func(s *Server, _ []string) string { return s.randomKey() }),
+	"rename":          withName("rename", (*Server).rename),
+	"renamenx":        withName("renamenx", (*Server).rename),
+	"replconf":        (*Server).replconf,
+	"replication":     withArgs((*Server).replication),
+	"replicaof":       withArgs((*Server).replicaOf),
+	"restore":         withArgs((*Server).restore),
+	"role":            withArgs(func(s *Server, _ []string) string { return s.role() }),
+	"rpop":            withName("rpop", (*Server).pop),
+	"rpush":           withName("rpush", (*Server).push),
+	"sadd":            withArgs((*Server).sadd),
+	"scan":            (*Server).scan,
+	"scard":           withArgs((*Server).scard),
+	"script":          withArgs((*Server).script),
+	"sdiff":           setOp("sdiff"),
+	"session":         (*Server).session,
+	"set":             withArgs((*Server).set),
+	"setbit":          withArgs((*Server).setBit),
+	"setrange":        withArgs((*Server).setRange),
+	"shadow":          withArgs((*Server).shadowCommand),
+	"shutdown":        func(s *Server, c *connection, _ []string) string { return s.shutdown(c) },
+	"sinter":          setOp("sinter"),
+	"sismember":       withArgs((*Server).sismember),
+	"slaveof":         withArgs((*Server).replicaOf),
+	"slowlog":         withArgs((*Server).slowlogCommand),
+	"smembers":        (*Server).smembers,
+	"srem":            withArgs((*Server).srem),
+	"strlen":          withArgs((*Server).strlen),
+	"subscribe":       func(s *Server, c *connection, args []string) string { return s.subscribe(c, args, false) },
+	"sunion":          setOp("sunion"),
+	"tenant":          withArgs((*Server).tenant),
+	"time":            withArgs(func(s *Server, _ []string) string { return s.timeCommand() }),
+	"touch":           withArgs((*Server).touch),
+	"ttl":             withName("ttl", (*Server).ttl),
+	"type":            withArgs((*Server).typeCommand),
+	"unsubscribe":     func(s *Server, c *connection, args []string) string { return s.unsubscribe(c, args, false) },
+	"vadd":            withArgs((*Server).vadd),
+	"vaddtext":        (*Server).vaddText,
+	"vanalyze":        withArgs((*Server).vanalyze),
+	"vavg":            vectorOp("vavg", collection.OpAverage),
+	"vcount":          withArgs((*Server).vcount),
+	"vcreate":         withArgs((*Server).vcreate),
+	"vdel":            withArgs((*Server).vdel),
+	"vdrop":           withArgs((*Server).vdrop),
+	"vexplain":        withArgs((*Server).vexplain),
+	"vexport":         withArgs((*Server).vexport),
+	"vget":            withArgs((*Server).vget),
+	"vlist":           func(s *Server, _ *connection, _ []string) string { return s.vlist() },
+	"vmigrate":        withArgs((*Server).vmigrate),
+	"voutliers":       withArgs((*Server).voutliers),
+	"vquery":          withArgs((*Server).vquery),
+	"vschema":         withArgs((*Server).vschema),
+	"vscroll":         (*Server).vscroll,
+	"vsearch":         (*Server).vsearch,
+	"vstats":          withArgs((*Server).vstats),
+	"vsub":            vectorOp("vsub", collection.OpDifference),
+	"vsum":            vectorOp("vsum", collection.OpSum),
+	"xack":            withArgs((*Server).xack),
+	"xadd":            withArgs((*Server).xadd),
+	"xgroup":          withArgs((*Server).xgroup),
+	"xlen":            withArgs((*Server).xlen),
+	"xpending":        withArgs((*Server).xpending),
+	"xrange":          withArgs((*Server).xrange),
+	"xread":           (*Server).xread,
+	"xreadgroup":      (*Server).xreadgroup,
+	"zadd":            withArgs((*Server).zadd),
+	"zcard":           withArgs((*Server).zcard),
+	"zrange":          withArgs((*Server).zrange),
+	"zrangebyscore":   withArgs((*Server).zrangeByScore),
+	"zrank":           withArgs((*Server).zrank),
+	"zrem":            withArgs((*Server).zrem),
+	"zscore":          withArgs((*Server).zscore),
+}
+
+func init() {
+	for name, handler := range commandHandlers {
+		spec, ok := commandTable[name]
+		if !ok {
+			panic(fmt.Sprintf("command %q has a handler but is missing from commands.json", name))
+		}
+		spec.handler = handler
+	}
+	for name, spec := range commandTable {
+		if spec.handler == nil {
+			panic(fmt.Sprintf("command %q in commands.json has no handler", name))
+		}
+	}
+}
+
+func (s *Server) set(args []string) string {
+	defer s.keyLocks.lock(args[0])()
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	batch.Set([]byte(args[0]), []byte(args[1]), nil)
+	storage.SetMeta(batch, []byte(args[0]), storage.NewMeta(storage.ObjecTypeString))
+	if err := s.committer.Commit(batch, s.writeMode("set")); err != nil {
+		return resp.Error("ERR Failed to set key: " + err.Error())
+	}
+	return resp.OK
+}
+
+func (s *Server) del(args []string) string {
+	defer s.keyLocks.lockAll(args)()
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	// A key named twice is deleted, and counted, once, as in Redis.
+	seen := make(map[string]bool, len(args))
+	deleted := 0
+	for _, key := range args {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		meta, exists, err := s.lookup([]byte(key))
+		if err != nil {
+			return resp.Error("ERR Failed to get key: " + err.Error())
+		}
+		if !exists {
+			continue
+		}
+		storage.DeleteValue(batch, []byte(key), meta)
+		deleted++
+	}
+	if deleted > 0 {
+		if err := s.committer.Commit(batch, s.writeMode("del")); err != nil {
+			return resp.Error("ERR Failed to delete key: " + err.Error())
+		}
+	}
+	return resp.Integer(int64(deleted))
+}
+
+func (s *Server) get(args []string) string {
+	meta, exists, err := s.lookup([]byte(args[0]))
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if !exists {
+		return resp.Nil
+	}
+	if storage.HasElements(meta.Type) {
+		return resp.Error(errWrongType.Error())
+	}
+	res, err := s.io.get(s.db, []byte(args[0]))
+	if err != nil {
+		if err == pebble.ErrNotFound {
+			return resp.Nil
+		}
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	return resp.BulkString(string(res))
+}
+
+// mset implements MSET key value [key value ...]. Every pair is written in
+// one batch, so the keys are set together and with a single commit.
+func (s *Server) mset(args []string) string {
+	if len(args)%2 != 0 {
+		return resp.Error("ERR wrong number of arguments for 'mset' command")
+	}
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	defer s.keyLocks.lockAll(keys)()
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	for i := 0; i < len(args); i += 2 {
+		batch.Set([]byte(args[i]), []byte(args[i+1]), nil)
+		storage.SetMeta(batch, []byte(args[i]), storage.NewMeta(storage.ObjecTypeString))
+	}
+	if err := s.committer.Commit(batch, s.writeMode("mset")); err != nil {
+		return resp.Error("ERR Failed to set keys: " + err.Error())
+	}
+	return resp.OK
+}
+
+// mget implements MGET key [key ...], replying with the value of each key,
+// or nil for keys that do not exist or do not hold a string.
+func (s *Server) mget(args []string) string {
+	var w resp.Writer
+	w.Array(len(args))
+	for _, key := range args {
+		meta, exists, err := s.lookup([]byte(key))
+		if err != nil {
+			return resp.Error("ERR Failed to get key: " + err.Error())
+		}
+		if !exists || meta.Type != storage.ObjecTypeString {
+			w.Nil()
+			continue
+		}
+		value, err := s.io.get(s.db, []byte(key))
+		switch {
+		case err == pebble.ErrNotFound:
+			w.Nil()
+		case err != nil:
+			return resp.Error("ERR Failed to get key: " + err.Error())
+		default:
+			w.BulkString(string(value))
+		}
+	}
+	return w.String()
+}
+
+// hello switches the connection protocol (HELLO [2|3]) and describes the
+// server, as a map for RESP3 or a flat array for RESP2.
+func (s *Server) hello(c *connection, args []string) string {
+	if len(args) > 0 {
+		protocol, err := strconv.Atoi(args[0])
+		if err != nil || (protocol != 2 && protocol != 3) {
+			return resp.Error("NOPROTO unsupported protocol version")
+		}
+		c.mutex.Lock()
+		c.protocol = protocol
+		c.mutex.Unlock()
+	}
+
+	mode := "standalone"
+	if s.config.ClusterEnabled {
+		mode = "cluster"
+	}
+	role := "master"
+	if s.isReplica() {
+		role = "replica"
+	}
+	var w resp.Writer
+	if c.protocol >= 3 {
+		w.Map(4)
+	} else {
+		w.Array(8)
+	}
+	w.BulkString("server")
+	w.BulkString("vecble")
+	w.BulkString("proto")
+	w.Integer(int64(c.protocol))
+	w.BulkString("mode")
+	w.BulkString(mode)
+	w.BulkString("role")
+	w.BulkString(role)
+	return w.String()
+}
@@ -0,0 +1,528 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/durability"
+	"readpebble/internal/embed"
+	"readpebble/internal/glob"
+	"readpebble/internal/manifest"
+	"readpebble/internal/resp"
+)
+
+// configSetting is a setting CONFIG GET reports and CONFIG VALIDATE checks,
+// named after its command-line flag.
+type configSetting struct {
+	name string
+	// get returns the running value, formatted as parse formats values so
+	// that the two compare equal when they mean the same.
+	get func(c *Config) string
+	// parse checks a proposed value.
+	parse func(value string) (string, error)
+	// secret settings are reported as changed without their values.
+	secret bool
+	// set, for settings CONFIG SET can change, stores a value parse
+	// returned in c.
+	set func(c *Config, value string)
+}
+
+// settable makes setting changeable by CONFIG SET, which stores values
+// with set.
+func (setting configSetting) settable(set func(c *Config, value string)) configSetting {
+	setting.set = set
+	return setting
+}
+
+var configSettings = []configSetting{
+	stringSetting("addr", func(c *Config) string { return c.Addr }),
+	stringSetting("admin-addr", func(c *Config) string { return c.AdminAddr }),
+	boolSetting("admin-local-only", func(c *Config) bool { return c.AdminLocalOnly }),
+	secretSetting("admin-password", func(c *Config) string { return c.AdminPassword }),
+	stringSetting("audit-log", func(c *Config) string { return c.AuditLog }),
+	stringSetting("http-addr", func(c *Config) string { return c.HTTPAddr }),
+	intSetting("metrics-label-limit", func(c *Config) int64 { return int64(c.MetricsLabelLimit) }),
+	boolSetting("dashboard", func(c *Config) bool { return c.Dashboard }),
+	boolSetting("pprof", func(c *Config) bool { return c.Pprof }),
+	secretSetting("pprof-token", func(c *Config) string { return c.PprofToken }),
+	boolSetting("rebind-on-failure", func(c *Config) bool { return c.RebindOnFailure }),
+	boolSetting("cluster-enabled", func(c *Config) bool { return c.ClusterEnabled }),
+	{
+		name: "cluster-shards",
+		get:  func(c *Config) string { return strings.Join(c.ClusterShards, ",") },
+		parse: func(value string) (string, error) {
+			if value == "" {
+				return "", nil
+			}
+			return strings.Join(strings.Split(value, ","), ","), nil
+		},
+	},
+	durationSetting("shard-timeout", func(c *Config) time.Duration { return c.ShardTimeout }),
+	stringSetting("replicaof", func(c *Config) string { return c.ReplicaOf }),
+	intSetting("replication-backlog", func(c *Config) int64 { return c.ReplicationBacklog }),
+	intSetting("replication-window", func(c *Config) int64 { return c.ReplicationWindow }),
+	{
+		name: "durability",
+		get:  func(c *Config) string { return c.Durability.String() },
+		parse: func(value string) (string, error) {
+			mode, err := durability.ParseMode(value)
+			return mode.String(), err
+		},
+		set: func(c *Config, value string) { c.Durability, _ = durability.ParseMode(value) },
+	},
+	{
+		name: "durability-override",
+		get:  func(c *Config) string { return formatOverrides(c.DurabilityOverrides) },
+		parse: func(value string) (string, error) {
+			overrides, err := durability.ParseOverrides(value)
+			return formatOverrides(overrides), err
+		},
+		set: func(c *Config, value string) { c.DurabilityOverrides, _ = durability.ParseOverrides(value) },
+	},
+	durationSetting("sync-window", func(c *Config) time.Duration { return c.SyncWindow }),
+	intSetting("background-read-rate", func(c *Config) int64 { return c.BackgroundReadRate }),
+	intSetting("background-cpu-jobs", func(c *Config) int64 { return int64(c.BackgroundCPUJobs) }),
+	intSetting("background-io-jobs", func(c *Config) int64 { return int64(c.BackgroundIOJobs) }),
+	intSetting("min-free-disk", func(c *Config) int64 { return c.MinFreeDisk }),
+	durationSetting("hedge-after", func(c *Config) time.Duration { return c.HedgeAfter }),
+	stringSetting("shadow-addr", func(c *Config) string { return c.ShadowAddr }),
+	intSetting("shadow-queue", func(c *Config) int64 { return int64(c.ShadowQueue) }),
+	durationSetting("shadow-compare-interval", func(c *Config) time.Duration { return c.ShadowCompareInterval }),
+	intSetting("shadow-samples", func(c *Config) int64 { return int64(c.ShadowSamples) }).settable(func(c *Config, value string) {
+		c.ShadowSamples, _ = strconv.Atoi(value)
+	}),
+	stringSetting("embedder-url", func(c *Config) string { return c.EmbedderURL }),
+	stringSetting("embedder-model", func(c *Config) string { return c.EmbedderModel }),
+	secretSetting("embedder-api-key", func(c *Config) string { return c.EmbedderAPIKey }),
+	durationSetting("embedder-timeout", func(c *Config) time.Duration { return c.EmbedderTimeout }),
+	intSetting("embedder-failure-threshold", func(c *Config) int64 { return int64(c.EmbedderFailureThreshold) }),
+	durationSetting("embedder-cooldown", func(c *Config) time.Duration { return c.EmbedderCooldown }),
+	{
+		name: "embedder-fallback",
+		get:  func(c *Config) string { return c.EmbedderFallback.String() },
+		parse: func(value string) (string, error) {
+			fallback, err := embed.ParseFallback(value)
+			return fallback.String(), err
+		},
+	},
+	intSetting("embedder-queue", func(c *Config) int64 { return int64(c.EmbedderQueue) }),
+	intSetting("embed-workers", func(c *Config) int64 { return int64(c.EmbedWorkers) }),
+	intSetting("embed-max-attempts", func(c *Config) int64 { return int64(c.EmbedMaxAttempts) }),
+	boolSetting("embed-cache", func(c *Config) bool { return c.EmbedCache }),
+	boolSetting("embeddings-endpoint", func(c *Config) bool { return c.EmbeddingsEndpoint }),
+	intSetting("blob-chunk-size", func(c *Config) int64 { return int64(c.BlobChunkSize) }),
+	intSetting("blob-offload-threshold", func(c *Config) int64 { return c.BlobOffloadThreshold }),
+	intSetting("index-memory-budget", func(c *Config) int64 { return c.IndexMemoryBudget }).settable(func(c *Config, value string) {
+		c.IndexMemoryBudget, _ = strconv.ParseInt(value, 10, 64)
+	}),
+	stringSetting("collections-manifest", func(c *Config) string { return c.CollectionsManifest }),
+	durationSetting("script-timeout", func(c *Config) time.Duration { return c.ScriptTimeout }).settable(func(c *Config, value string) {
+		c.ScriptTimeout, _ = time.ParseDuration(value)
+	}),
+	stringSetting("capture-file", func(c *Config) string { return c.CaptureFile }),
+	stringSetting("migrate-legacy-vectors", func(c *Config) string { return c.MigrateLegacyVectors }),
+	boolSetting("migrate-legacy-untyped", func(c *Config) bool { return c.MigrateLegacyUntyped }),
+	boolSetting("auto-create-collections", func(c *Config) bool { return c.AutoCreateCollections }).settable(func(c *Config, value string) {
+		c.AutoCreateCollections, _ = strconv.ParseBool(value)
+	}),
+	{
+		name: "auto-create-metric",
+		get:  func(c *Config) string { return string(c.AutoCreateMetric) },
+		parse: func(value string) (string, error) {
+			metric, err := collection.ParseMetric(value)
+			return string(metric), err
+		},
+		set: func(c *Config, value string) { c.AutoCreateMetric = collection.Metric(value) },
+	},
+	{
+		name: "auto-create-index",
+		get:  func(c *Config) string { return string(c.AutoCreateIndex) },
+		parse: func(value string) (string, error) {
+			index, err := collection.ParseIndexType(value)
+			return string(index), err
+		},
+		set: func(c *Config, value string) { c.AutoCreateIndex = collection.IndexType(value) },
+	},
+	intSetting("soft-value-size", func(c *Config) int64 { return c.SoftValueSize }).settable(func(c *Config, value string) {
+		c.SoftValueSize, _ = strconv.ParseInt(value, 10, 64)
+	}),
+	intSetting("soft-collection-points", func(c *Config) int64 { return c.SoftCollectionPoints }).settable(func(c *Config, value string) {
+		c.SoftCollectionPoints, _ = strconv.ParseInt(value, 10, 64)
+	}),
+	intSetting("soft-filter-conditions", func(c *Config) int64 { return int64(c.SoftFilterConditions) }).settable(func(c *Config, value string) {
+		c.SoftFilterConditions, _ = strconv.Atoi(value)
+	}),
+	intSetting("max-command-size", func(c *Config) int64 { return c.MaxCommandSize }).settable(func(c *Config, value string) {
+		c.MaxCommandSize, _ = strconv.ParseInt(value, 10, 64)
+	}),
+	intSetting("slowlog-log-slower-than", func(c *Config) int64 { return c.SlowlogLogSlowerThan }).settable(func(c *Config, value string) {
+		c.SlowlogLogSlowerThan, _ = strconv.ParseInt(value, 10, 64)
+	}),
+	intSetting("slowlog-max-len", func(c *Config) int64 { return int64(c.SlowlogMaxLen) }).settable(func(c *Config, value string) {
+		c.SlowlogMaxLen, _ = strconv.Atoi(value)
+	}),
+	intSetting("maxmemory", func(c *Config) int64 { return c.MaxMemory }).settable(func(c *Config, value string) {
+		c.MaxMemory, _ = strconv.ParseInt(value, 10, 64)
+	}),
+	{
+		name:  "notify-keyspace-events",
+		get:   func(c *Config) string { return c.NotifyKeyspaceEvents },
+		parse: ParseKeyspaceEvents,
+		set:   func(c *Config, value string) { c.NotifyKeyspaceEvents = value },
+	},
+	intSetting("max-scan-jobs", func(c *Config) int64 { return int64(c.MaxScanJobs) }).settable(func(c *Config, value string) {
+		c.MaxScanJobs, _ = strconv.Atoi(value)
+	}),
+	stringSetting("config", func(c *Config) string { return c.ConfigFile }),
+}
+
+func stringSetting(name string, get func(c *Config) string) configSetting {
+	return configSetting{name: name, get: get, parse: func(value string) (string, error) { return value, nil }}
+}
+
+func secretSetting(name string, get func(c *Config) string) configSetting {
+	setting := stringSetting(name, get)
+	setting.secret = true
+	return setting
+}
+
+func boolSetting(name string, get func(c *Config) bool) configSetting {
+	return configSetting{
+		name: name,
+		get:  func(c *Config) string { return strconv.FormatBool(get(c)) },
+		parse: func(value string) (string, error) {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return "", fmt.Errorf("%q is not a boolean", value)
+			}
+			return strconv.FormatBool(b), nil
+		},
+	}
+}
+
+func intSetting(name string, get func(c *Config) int64) configSetting {
+	return configSetting{
+		name: name,
+		get:  func(c *Config) string { return strconv.FormatInt(get(c), 10) },
+		parse: func(value string) (string, error) {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("%q is not an integer", value)
+			}
+			return strconv.FormatInt(n, 10), nil
+		},
+	}
+}
+
+func durationSetting(name string, get func(c *Config) time.Duration) configSetting {
+	return configSetting{
+		name: name,
+		get:  func(c *Config) string { return get(c).String() },
+		parse: func(value string) (string, error) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return "", fmt.Errorf("%q is not a duration", value)
+			}
+			return d.String(), nil
+		},
+	}
+}
+
+// formatOverrides formats durability overrides as ParseOverrides parses
+// them, sorted by command.
+func formatOverrides(overrides map[string]durability.Mode) string {
+	pairs := make([]string, 0, len(overrides))
+	for cmd, mode := range overrides {
+		pairs = append(pairs, cmd+"="+mode.String())
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// configCommand implements CONFIG GET, SET, REWRITE and VALIDATE. Settings
+// are named after the command-line flags.
+func (s *Server) configCommand(args []string) string {
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "get" && len(args) >= 2:
+		return s.configGet(args[1:])
+	case sub == "set" && len(args) >= 3 && len(args)%2 == 1:
+		return s.configSet(args[1:])
+	case sub == "rewrite" && len(args) == 1:
+		return s.configRewrite()
+	case sub == "validate" && len(args) >= 3 && len(args)%2 == 1:
+		return s.configValidate(args[1:])
+	case sub == "get" || sub == "set" || sub == "rewrite" || sub == "validate":
+		return resp.Error("ERR wrong number of arguments for 'config|" + sub + "' command")
+	}
+	return resp.Error("ERR unknown CONFIG subcommand '" + args[0] + "'")
+}
+
+// lookupSetting returns the setting named name, case-insensitively.
+func lookupSetting(name string) (configSetting, bool) {
+	name = strings.ToLower(name)
+	i := slices.IndexFunc(configSettings, func(setting configSetting) bool { return setting.name == name })
+	if i < 0 {
+		return configSetting{}, false
+	}
+	return configSettings[i], true
+}
+
+// configGet implements CONFIG GET pattern [pattern ...], replying with the
+// names and running values of the settings matching any of the glob
+// patterns. Secret settings are left out.
+func (s *Server) configGet(patterns []string) string {
+	config := s.live.Load()
+	var pairs []string
+	for _, setting := range configSettings {
+		if setting.secret {
+			continue
+		}
+		for _, pattern := range patterns {
+			if glob.Match(strings.ToLower(pattern), setting.name) {
+				pairs = append(pairs, setting.name, setting.get(config))
+				break
+			}
+		}
+	}
+	return resp.StringArray(pairs)
+}
+
+// configSet implements CONFIG SET setting value [setting value ...],
+// changing settings while the server runs. Only the settings with a set
+// function can be changed; the others take a restart. The settings are
+// changed together, or not at all if one is invalid, and commands see
+// either the old values or the new ones.
+func (s *Server) configSet(args []string) string {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	next := *s.live.Load()
+	names := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		setting, ok := lookupSetting(args[i])
+		if !ok {
+			return resp.Error("ERR Unknown option or number of arguments for CONFIG SET - '" + args[i] + "'")
+		}
+		if slices.Contains(names, setting.name) {
+			return resp.Error("ERR CONFIG SET failed - duplicate parameter '" + setting.name + "'")
+		}
+		if setting.set == nil {
+			return resp.Error("ERR CONFIG SET failed - '" + setting.name + "' can only be set at startup")
+		}
+		value, err := setting.parse(args[i+1])
+		if err != nil {
+			return resp.Error("ERR CONFIG SET failed - invalid " + setting.name + ": " + err.Error())
+		}
+		setting.set(&next, value)
+		names = append(names, setting.name)
+	}
+	next.setDefaults()
+	s.live.Store(&next)
+	s.collections.SetIndexBudget(next.IndexMemoryBudget)
+	if s.configChanged == nil {
+		s.configChanged = make(map[string]bool)
+	}
+	for _, name := range names {
+		s.configChanged[name] = true
+	}
+	log.Printf("CONFIG SET changed %s", strings.Join(names, ", "))
+	return resp.OK
+}
+
+// configRewrite implements CONFIG REWRITE, writing the settings changed by
+// CONFIG SET to the ConfigFile the server was started with, so that they
+// outlast a restart. Lines setting them are updated in place and the
+// others appended; the rest of the file, comments included, is kept.
+func (s *Server) configRewrite() string {
+	if s.config.ConfigFile == "" {
+		return resp.Error("ERR The server is running without a config file")
+	}
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	config := s.live.Load()
+	values := make(map[string]string, len(s.configChanged))
+	for name := range s.configChanged {
+		setting, _ := lookupSetting(name)
+		values[name] = setting.get(config)
+	}
+	if err := rewriteConfigFile(s.config.ConfigFile, values); err != nil {
+		return resp.Error("ERR Rewriting config file: " + err.Error())
+	}
+	return resp.OK
+}
+
+// configValidate implements CONFIG VALIDATE setting value [setting value
+// ...], checking proposed settings before the server is restarted with
+// them. It replies with the changes from the running settings, such as
+// `durability: "always" -> "batched"`, and for a collections-manifest,
+// those applying it would make to the collections (see APPLY), so that a
+// deployment can be reviewed before it is rolled out. The first invalid
+// setting fails the command.
+func (s *Server) configValidate(args []string) string {
+	config := s.live.Load()
+	proposed := make(map[string]bool)
+	changes := []string{}
+	for i := 0; i < len(args); i += 2 {
+		setting, ok := lookupSetting(args[i])
+		if !ok {
+			return resp.Error("ERR unknown setting '" + args[i] + "'")
+		}
+		name := setting.name
+		if proposed[name] {
+			return resp.Error("ERR setting '" + name + "' given more than once")
+		}
+		proposed[name] = true
+		value, err := setting.parse(args[i+1])
+		if err != nil {
+			return resp.Error("ERR invalid " + name + ": " + err.Error())
+		}
+		switch current := setting.get(config); {
+		case value == current:
+		case setting.secret:
+			changes = append(changes, name+": changed")
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", name, current, value))
+		}
+		if name == "collections-manifest" && value != "" {
+			infos, err := manifest.LoadFile(value)
+			if err != nil {
+				return resp.Error("ERR invalid collections-manifest: " + err.Error())
+			}
+			planned, err := s.collections.Apply(infos, true)
+			if err != nil {
+				return resp.Error("ERR invalid collections-manifest: " + err.Error())
+			}
+			for _, change := range planned {
+				changes = append(changes, "collections: "+change.String())
+			}
+		}
+	}
+	return resp.StringArray(changes)
+}
+
+// FileSetting is a setting of a config file.
+type FileSetting struct {
+	Name, Value string
+}
+
+// ReadConfigFile reads the settings of a config file, one per line: the
+// name of the setting, as its command-line flag, then its value, quoted
+// as a Go string if it is empty or has spaces. Blank lines and lines
+// starting with # are ignored.
+func ReadConfigFile(path string) ([]FileSetting, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var settings []FileSetting
+	for i, line := range strings.Split(string(data), "\n") {
+		setting, ok, err := parseConfigLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		if ok {
+			settings = append(settings, setting)
+		}
+	}
+	return settings, nil
+}
+
+// parseConfigLine parses a line of a config file, reporting whether it
+// sets anything.
+func parseConfigLine(line string) (FileSetting, bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return FileSetting{}, false, nil
+	}
+	name, value, _ := strings.Cut(line, " ")
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return FileSetting{}, false, fmt.Errorf("invalid quoted value %s", value)
+		}
+		value = unquoted
+	}
+	return FileSetting{Name: strings.ToLower(name), Value: value}, true, nil
+}
+
+// formatConfigLine formats a line of a config file setting name to value.
+func formatConfigLine(name, value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\"#") {
+		value = strconv.Quote(value)
+	}
+	return name + " " + value
+}
+
+// rewriteConfigFile sets the settings of values in the config file at
+// path, replacing the first line setting each and dropping later ones,
+// and appending those it did not set yet in the order of configSettings.
+// The file is replaced atomically.
+func rewriteConfigFile(path string, values map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	written := make(map[string]bool, len(values))
+	kept := lines[:0]
+	for _, line := range lines {
+		setting, ok, _ := parseConfigLine(line)
+		value, changed := values[setting.Name]
+		switch {
+		case !ok || !changed:
+			kept = append(kept, line)
+		case !written[setting.Name]:
+			kept = append(kept, formatConfigLine(setting.Name, value))
+			written[setting.Name] = true
+		}
+	}
+	for _, setting := range configSettings {
+		if value, changed := values[setting.name]; changed && !written[setting.name] {
+			kept = append(kept, formatConfigLine(setting.name, value))
+		}
+	}
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(kept, "\n")+"\n"), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,355 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"readpebble/internal/resp"
+)
+
+// connection holds the per-client state of an accepted connection.
+type connection struct {
+	id        int64
+	conn      net.Conn
+	createdAt time.Time
+	// protocol is the RESP version negotiated with HELLO, 2 by default.
+	protocol int
+	// admin is set for connections accepted on the admin listener.
+	admin         bool
+	authenticated bool
+	// user is the name the connection authenticated as.
+	user string
+	// replication is set for the pseudo-connection applying a master's
+	// command stream, which may write while the server is a replica.
+	replication bool
+	// consistency and maxLag are set by READCONSISTENCY and guarded by
+	// mutex.
+	consistency consistencyLevel
+	maxLag      time.Duration
+	// listeningPort is the port a replica listens on, set by REPLCONF,
+	// and follower is set once PSYNC attached it as a replica. Both are
+	// guarded by mutex.
+	listeningPort string
+	follower      *follower
+	// streamable is set for client connections, whose replies may be
+	// streamed, and stream holds the reply stream of the command being
+	// handled until it is sent (see streamReply).
+	streamable bool
+	stream     replyStream
+	// turn is the worker slot of a client connection, given up while a
+	// command blocks (see blocked).
+	turn *turn
+	// order is the hold on the write order of the write being handled,
+	// also given up while it blocks.
+	order *orderHold
+	// textWrites are the latest writes of the connection whose text
+	// awaited embedding, by collection (see awaitOwnWrites).
+	textWrites map[string]textWrite
+	// writer is the reply writer of a client connection, which Pub/Sub
+	// messages are also written to under writeMutex, and subscriber its
+	// Pub/Sub state once it subscribed (see pubsub).
+	writer     *replyWriter
+	writeMutex sync.Mutex
+	subscriber *subscriber
+	// scripting is set for the connection the commands of a script run
+	// on (see scriptConnection).
+	scripting bool
+
+	// killed is set by CLIENT KILL killing the connection it ran on,
+	// which closes once the reply is sent.
+	killed bool
+	// warnings are the soft limits the command being handled exceeded,
+	// sent in the attribute of its reply (see checkSoftLimits).
+	warnings []string
+
+	mutex       sync.Mutex
+	lastCommand string
+	lastActive  time.Time
+	closed      time.Time
+	// name is set by CLIENT SETNAME and noEvict by CLIENT NO-EVICT.
+	name    string
+	noEvict bool
+	// session holds the defaults set with SESSION SET, by variable.
+	session map[string]string
+	// queryBuffer and outputBuffer are the bytes of commands received but
+	// not run yet, and of replies not sent yet, after the last command.
+	queryBuffer  int
+	outputBuffer int
+	// closedCh is closed with the connection once done was called.
+	closedCh chan struct{}
+	// ctx is the context of the commands of the connection, canceled with
+	// cancel once it is closed (see context).
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// close closes the underlying connection and records when it happened, so
+// the watchdog can tell if goroutines serving it are still running.
+func (c *connection) close() error {
+	c.mutex.Lock()
+	if c.closed.IsZero() {
+		c.closed = time.Now()
+		if c.closedCh != nil {
+			close(c.closedCh)
+		}
+		if c.cancel != nil {
+			c.cancel()
+		}
+	}
+	c.mutex.Unlock()
+	return c.conn.Close()
+}
+
+// done returns a channel closed once the connection is, which ends the
+// wait of a command blocked on its behalf.
+func (c *connection) done() <-chan struct{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closedCh == nil {
+		c.closedCh = make(chan struct{})
+		if !c.closed.IsZero() {
+			close(c.closedCh)
+		}
+	}
+	return c.closedCh
+}
+
+// context returns the context of the commands of the connection, which is
+// canceled once it is closed.
+func (c *connection) context() context.Context {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ctx == nil {
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		if !c.closed.IsZero() {
+			c.cancel()
+		}
+	}
+	return c.ctx
+}
+
+func (c *connection) closedAt() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+func (c *connection) touch(cmd string) {
+	c.mutex.Lock()
+	c.lastCommand = cmd
+	c.lastActive = time.Now()
+	c.mutex.Unlock()
+}
+
+// buffered records the buffer sizes of c after a command.
+func (c *connection) buffered(query, output int) {
+	c.mutex.Lock()
+	c.queryBuffer, c.outputBuffer = query, output
+	c.mutex.Unlock()
+}
+
+func (c *connection) info() clientInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return clientInfo{
+		ID:           c.id,
+		Addr:         c.conn.RemoteAddr().String(),
+		LocalAddr:    c.conn.LocalAddr().String(),
+		Name:         c.name,
+		User:         c.user,
+		Protocol:     c.protocol,
+		CreatedAt:    c.createdAt,
+		LastCommand:  c.lastCommand,
+		LastActive:   c.lastActive,
+		Replica:      c.follower != nil,
+		NoEvict:      c.noEvict,
+		QueryBuffer:  c.queryBuffer,
+		OutputBuffer: c.outputBuffer,
+	}
+}
+
+// replyBufferSize is the size of the buffer replies are batched in. Larger
+// replies are sent from their own memory (see replyWriter).
+const replyBufferSize = 16 << 10
+
+func (s *Server) handleConnection(conn net.Conn, l *serverListener) {
+	c := &connection{conn: conn, protocol: 2, admin: l.admin, consistency: consistencyLocal, streamable: true, createdAt: time.Now(), lastActive: time.Now()}
+	s.clients.add(c)
+	if s.draining.Load() {
+		// Accepted just before a handoff drained the registry.
+		conn.SetReadDeadline(time.Now())
+	}
+	s.stats.connections.Inc()
+	done := s.watchdog.trackConn(subsystemConnection, c)
+	defer func() {
+		log.Printf("Client disconnected: %s", conn.RemoteAddr().String())
+		s.clients.remove(c)
+		c.close()
+		s.pubsub.drop(c)
+		done()
+		s.wg.Done()
+	}()
+
+	reader := resp.NewReader(conn)
+	writer := newReplyWriter(conn, s.stats.vectored.Inc)
+	c.writer = writer
+	t := &turn{s: s.sched}
+	defer t.release()
+	c.turn = t
+
+	for {
+		reader.MaxCommandSize = s.live.Load().MaxCommandSize
+		cmd, args, err := readCommand(reader)
+		if errors.Is(err, resp.ErrCommandTooLarge) {
+			s.stats.tooLarge.Inc()
+			log.Printf("Rejected a command from %s larger than %d bytes", conn.RemoteAddr(), reader.MaxCommandSize)
+			if err := s.writeReply(c, resp.Error("ERR request too large"), reader.Buffered() == 0); err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if !s.draining.Load() {
+				c.writeMutex.Lock()
+				writer.WriteString(resp.Error("ERR Parse error"))
+				writer.Flush()
+				c.writeMutex.Unlock()
+			}
+			return
+		}
+		c.touch(cmd)
+		s.captureReceived(c, cmd, args)
+		args = s.sessionArgs(c, cmd, args)
+		t.begin()
+		s.load.begin()
+		start := time.Now()
+		response := s.handleCommand(c, cmd, args)
+		elapsed := time.Since(start)
+		s.load.end()
+		pending := reader.Buffered() > 0
+		yielded := t.end(pending)
+		if yielded {
+			s.stats.yields.Inc()
+		}
+		s.stats.command(cmd, elapsed, response)
+		s.logSlow(c, cmd, args, elapsed)
+		s.collectionCommand(cmd, args, response, elapsed)
+		// SUBSCRIBE and the like write their replies themselves and
+		// return none, which takes no attribute either.
+		if c.protocol >= 3 && (response != "" || c.stream != nil) {
+			response = s.replyAttribute(c.warnings) + response
+		}
+		c.warnings = nil
+		if err := s.writeReply(c, response, !pending || yielded || c.killed); err != nil {
+			return
+		}
+		if c.killed {
+			return
+		}
+		c.writeMutex.Lock()
+		c.buffered(reader.Buffered(), writer.Buffered())
+		c.writeMutex.Unlock()
+		// Once attached by PSYNC, the connection carries the replication
+		// stream rather than replies.
+		if c.follower != nil {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+			t.release()
+			s.serveFollower(c.follower, reader, bufio.NewWriterSize(conn, replyBufferSize))
+			return
+		}
+	}
+}
+
+// writeReply writes the reply to the command c just ran, followed by its
+// reply stream if it has one, and flushes them if flush is set. It holds
+// c's writeMutex so that Pub/Sub messages are not written in between.
+func (s *Server) writeReply(c *connection, response string, flush bool) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if _, err := c.writer.WriteString(response); err != nil {
+		return err
+	}
+	if c.stream != nil {
+		if err := s.sendStream(c, c.writer); err != nil {
+			return err
+		}
+	}
+	// Replies to pipelined commands are batched into as few writes as
+	// possible. They are flushed once the client has no more commands
+	// in flight, and before the connection waits for a worker again.
+	if flush {
+		if err := c.writer.Flush(); err != nil {
+			return err
+		}
+		s.stats.flushes.Inc()
+	}
+	return nil
+}
+
+// blocked runs wait, which blocks until something happens elsewhere, such
+// as entries being added to the streams XREAD waits for, without c holding
+// a worker slot or counting as load meanwhile.
+//
+// Commands called by a script do not wait, as the script would hold up
+// every other command meanwhile.
+func (s *Server) blocked(c *connection, wait func()) {
+	if c.scripting {
+		return
+	}
+	if c.order != nil {
+		c.order.release()
+		defer c.order.acquire()
+	}
+	s.scriptLock.RUnlock()
+	defer s.scriptLock.RLock()
+	if c.turn != nil {
+		c.turn.release()
+		s.load.end()
+		defer func() {
+			c.turn.begin()
+			s.load.begin()
+		}()
+	}
+	wait()
+}
+
+// readCommand reads the next command from reader, returning its lowercased
+// name and its arguments.
+func readCommand(reader *resp.Reader) (string, []string, error) {
+	args, err := reader.ReadCommand()
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.ToLower(args[0]), args[1:], nil
+}
@@ -0,0 +1,113 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"readpebble/internal/resp"
+)
+
+// consistencyLevel is how fresh the data a connection reads must be when
+// the server is a replica. A master always satisfies every level.
+type consistencyLevel string
+
+const (
+	// consistencyLocal reads whatever the replica has applied.
+	consistencyLocal consistencyLevel = "local"
+	// consistencyBounded reads from the replica only while it heard from
+	// its master within the connection's maxLag.
+	consistencyBounded consistencyLevel = "bounded"
+	// consistencyLeader refuses reads on replicas, so that clients go to
+	// the master.
+	consistencyLeader consistencyLevel = "leader"
+)
+
+// readConsistency implements READCONSISTENCY [LOCAL | LEADER | BOUNDED
+// max-lag-ms], setting the consistency of the connection's reads, or
+// replying with the current one without arguments.
+func (s *Server) readConsistency(c *connection, args []string) string {
+	if len(args) == 0 {
+		c.mutex.Lock()
+		level, maxLag := c.consistency, c.maxLag
+		c.mutex.Unlock()
+		if level == consistencyBounded {
+			return resp.StringArray([]string{string(level), strconv.FormatInt(maxLag.Milliseconds(), 10)})
+		}
+		return resp.StringArray([]string{string(level)})
+	}
+	level := consistencyLevel(strings.ToLower(args[0]))
+	var maxLag time.Duration
+	switch {
+	case level == consistencyBounded && len(args) == 2:
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || ms <= 0 {
+			return resp.Error("ERR max lag must be a positive number of milliseconds")
+		}
+		maxLag = time.Duration(ms) * time.Millisecond
+	case (level == consistencyLocal || level == consistencyLeader) && len(args) == 1:
+	default:
+		return resp.Error("ERR syntax error")
+	}
+	c.mutex.Lock()
+	c.consistency, c.maxLag = level, maxLag
+	c.mutex.Unlock()
+	return resp.OK
+}
+
+// checkConsistency refuses a read on a replica that cannot serve it at the
+// connection's consistency level. The master's command stream applied by
+// the replica is exempt.
+func (s *Server) checkConsistency(c *connection, spec *commandSpec) string {
+	if c.replication || !spec.hasFlag("readonly") {
+		return ""
+	}
+	c.mutex.Lock()
+	level, maxLag := c.consistency, c.maxLag
+	c.mutex.Unlock()
+	if level == "" || level == consistencyLocal {
+		return ""
+	}
+	s.replicaMutex.Lock()
+	r := s.replica
+	s.replicaMutex.Unlock()
+	if r == nil {
+		return ""
+	}
+	if level == consistencyLeader {
+		return resp.Error("NOTLEADER reads with LEADER consistency must go to the master at " + r.addr)
+	}
+	lag, connected := r.lag()
+	if !connected {
+		return resp.Error("STALE the link with the master at " + r.addr + " is down")
+	}
+	if lag > maxLag {
+		return resp.Error(fmt.Sprintf("STALE last heard from the master %dms ago, more than the %dms allowed",
+			lag.Milliseconds(), maxLag.Milliseconds()))
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"math"
+	"strconv"
+
+	"readpebble/internal/resp"
+)
+
+// incr implements INCR key, DECR key, INCRBY key increment and DECRBY key
+// decrement, replying with the new value. A key that does not exist counts
+// from 0; the expiry of one that does is kept.
+func (s *Server) incr(cmd string, args []string) string {
+	delta := int64(1)
+	switch cmd {
+	case "decr":
+		delta = -1
+	case "incrby", "decrby":
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return resp.Error("ERR value is not an integer or out of range")
+		}
+		delta = n
+		if cmd == "decrby" {
+			if n == math.MinInt64 {
+				return resp.Error("ERR decrement would overflow")
+			}
+			delta = -n
+		}
+	}
+
+	defer s.keyLocks.lock(args[0])()
+	meta, value, exists, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	var n int64
+	if exists {
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return resp.Error("ERR value is not an integer or out of range")
+		}
+	}
+	if delta > 0 && n > math.MaxInt64-delta || delta < 0 && n < math.MinInt64-delta {
+		return resp.Error("ERR increment or decrement would overflow")
+	}
+	n += delta
+	if err := s.storeString(cmd, []byte(args[0]), strconv.FormatInt(n, 10), meta); err != nil {
+		return stringError(err)
+	}
+	return resp.Integer(n)
+}
+
+// incrByFloat implements INCRBYFLOAT key increment, replying with the new
+// value.
+func (s *Server) incrByFloat(args []string) string {
+	delta, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return resp.Error("ERR value is not a valid float")
+	}
+
+	defer s.keyLocks.lock(args[0])()
+	meta, value, exists, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	var f float64
+	if exists {
+		if f, err = strconv.ParseFloat(value, 64); err != nil {
+			return resp.Error("ERR value is not a valid float")
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return resp.Error("ERR increment would produce NaN or Infinity")
+	}
+	formatted := strconv.FormatFloat(f, 'f', -1, 64)
+	if err := s.storeString("incrbyfloat", []byte(args[0]), formatted, meta); err != nil {
+		return stringError(err)
+	}
+	return resp.BulkString(formatted)
+}
@@ -0,0 +1,100 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+)
+
+// registerPprof mounts the net/http/pprof handlers, requiring
+// "Authorization: Bearer <PprofToken>" when a token is configured.
+func (s *Server) registerPprof(mux *http.ServeMux) {
+	guard := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if s.config.PprofToken != "" {
+				token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.PprofToken)) != 1 {
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+			handler(w, r)
+		}
+	}
+	mux.HandleFunc("/debug/pprof/", guard(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", guard(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", guard(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", guard(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", guard(pprof.Trace))
+}
+
+// debug implements DEBUG GOROUTINES, DEBUG HEAP and DEBUG DECODEKEY hex,
+// returning the goroutine dump, a summary of the Go heap or a readable form of
+// a collection key as a bulk string.
+func (s *Server) debug(args []string) string {
+	var buf bytes.Buffer
+	switch strings.ToLower(args[0]) {
+	case "decodekey":
+		if len(args) != 2 {
+			return resp.Error("ERR wrong number of arguments for 'debug|decodekey' command")
+		}
+		key, err := hex.DecodeString(args[1])
+		if err != nil {
+			return resp.Error("ERR key must be hex encoded")
+		}
+		decoded, err := collection.DecodeKey(key)
+		if err != nil {
+			return resp.Error("ERR " + err.Error())
+		}
+		buf.WriteString(decoded)
+	case "goroutines":
+		runtimepprof.Lookup("goroutine").WriteTo(&buf, 1)
+	case "heap":
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		fmt.Fprintf(&buf, "heap_alloc:%d\r\n", m.HeapAlloc)
+		fmt.Fprintf(&buf, "heap_inuse:%d\r\n", m.HeapInuse)
+		fmt.Fprintf(&buf, "heap_idle:%d\r\n", m.HeapIdle)
+		fmt.Fprintf(&buf, "heap_released:%d\r\n", m.HeapReleased)
+		fmt.Fprintf(&buf, "heap_objects:%d\r\n", m.HeapObjects)
+		fmt.Fprintf(&buf, "sys:%d\r\n", m.Sys)
+		fmt.Fprintf(&buf, "num_gc:%d\r\n", m.NumGC)
+		fmt.Fprintf(&buf, "gc_pause_total_ns:%d\r\n", m.PauseTotalNs)
+		fmt.Fprintf(&buf, "goroutines:%d\r\n", runtime.NumGoroutine())
+	default:
+		return resp.Error("ERR unknown DEBUG subcommand '" + args[0] + "'")
+	}
+	return resp.BulkString(buf.String())
+}
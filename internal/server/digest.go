@@ -0,0 +1,148 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// digest implements DIGEST [prefix ...], replying with a hash of the keys
+// starting with each prefix, or of the whole keyspace without one, so that
+// a master and its replicas can be compared without transferring their
+// contents. A digest that differs can be narrowed down by asking for the
+// digests of longer prefixes.
+//
+// Each key is hashed with its type, value, expiry and, for a hash or a
+// list, its elements, and the hashes are combined with XOR: the digest does not depend
+// on the order keys are visited in, and the digest of a prefix is the XOR
+// of the digests of the prefixes splitting it. Keys that expired but were not deleted yet are
+// left out, since a replica deletes them later than its master.
+func (s *Server) digest(args []string) string {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	digests := make([]string, len(args))
+	for i, prefix := range args {
+		sum, err := s.keyspaceDigest([]byte(prefix))
+		if err != nil {
+			return resp.Error("ERR Failed to compute digest: " + err.Error())
+		}
+		digests[i] = hex.EncodeToString(sum)
+	}
+	if len(digests) == 1 {
+		return resp.BulkString(digests[0])
+	}
+	return resp.StringArray(digests)
+}
+
+// keyspaceDigest returns the digest of the keys starting with prefix.
+// Prefixes in the reserved keyspace match nothing.
+func (s *Server) keyspaceDigest(prefix []byte) ([]byte, error) {
+	sum := make([]byte, sha1.Size)
+	if len(prefix) > 0 && prefix[0] == 0 {
+		return sum, nil
+	}
+	options := &pebble.IterOptions{LowerBound: []byte{1}}
+	if len(prefix) > 0 {
+		options = &pebble.IterOptions{LowerBound: prefix, UpperBound: keyPrefixEnd(prefix)}
+	}
+	iter, err := s.db.NewIter(options)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	now := time.Now()
+	var buf []byte
+	for iter.First(); iter.Valid(); iter.Next() {
+		s.io.backgroundRead(len(iter.Key()) + len(iter.Value()))
+		meta, exists, err := storage.LoadMeta(s.db, iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if !exists || meta.Expired(now) {
+			continue
+		}
+		var expireAt int64
+		if !meta.ExpireAt.IsZero() {
+			expireAt = meta.ExpireAt.UnixMilli()
+		}
+		buf = append(buf[:0], byte(meta.Type))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(expireAt))
+		buf = binary.AppendUvarint(buf, uint64(len(iter.Key())))
+		buf = append(buf, iter.Key()...)
+		buf = append(buf, iter.Value()...)
+		if buf, err = s.appendElements(buf, iter.Key(), meta.Type); err != nil {
+			return nil, err
+		}
+		keySum := sha1.Sum(buf)
+		for i := range sum {
+			sum[i] ^= keySum[i]
+		}
+	}
+	return sum, iter.Error()
+}
+
+// appendElements appends the elements of the value of type t at key, if
+// the type has any, to buf, each as the rest of its key and its value.
+func (s *Server) appendElements(buf, key []byte, t storage.ObjectType) ([]byte, error) {
+	start, end, ok := storage.ElementsSpan(key, t)
+	if !ok {
+		return buf, nil
+	}
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: start, UpperBound: end})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		s.io.backgroundRead(len(iter.Key()) + len(iter.Value()))
+		element := iter.Key()[len(start):]
+		buf = binary.AppendUvarint(buf, uint64(len(element)))
+		buf = append(buf, element...)
+		buf = binary.AppendUvarint(buf, uint64(len(iter.Value())))
+		buf = append(buf, iter.Value()...)
+	}
+	return buf, iter.Error()
+}
+
+// keyPrefixEnd returns the smallest key greater than every key starting
+// with prefix, or nil if there is none.
+func keyPrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"readpebble/internal/metrics"
+	"readpebble/internal/resp"
+)
+
+// diskCheckInterval is how often the free space of the data directory is
+// checked.
+const diskCheckInterval = time.Second
+
+// diskResumeMargin is how much more free space than MinFreeDisk, as a
+// fraction of it, the data directory must have again before writes are
+// accepted, so that writes freeing and taking space around the threshold
+// do not flip the server in and out of degraded mode.
+const diskResumeMargin = 0.1
+
+// spaceFreeingCommands are the writes accepted in degraded mode, since
+// they are how an operator frees space.
+var spaceFreeingCommands = map[string]bool{
+	"del": true, "flushall": true, "flushdb": true, "getdel": true,
+	"hdel": true, "lpop": true, "rpop": true, "ltrim": true, "srem": true,
+	"zrem": true, "vdel": true, "vdrop": true,
+}
+
+// diskMonitor watches the free space of the data directory and switches the
+// server to a degraded mode rejecting writes with -NOSPACE when it falls
+// below MinFreeDisk, before Pebble runs out of space mid-write, which it
+// cannot recover from. Reads are still served. The server leaves degraded
+// mode once space has been freed beyond the threshold plus a margin.
+type diskMonitor struct {
+	dir     string
+	minFree int64
+	// free returns the bytes available to the server in dir.
+	free func(dir string) (int64, error)
+
+	freeBytes atomic.Int64
+	degraded  atomic.Bool
+	entered   *metrics.Counter
+	rejected  *metrics.Counter
+}
+
+func newDiskMonitor(config Config, registry *metrics.Registry) *diskMonitor {
+	d := &diskMonitor{
+		dir:      config.DataDir,
+		minFree:  config.MinFreeDisk,
+		free:     freeDiskSpace,
+		entered:  registry.Counter("vecble_disk_degraded_total", "Times free disk space fell below the minimum and writes were rejected."),
+		rejected: registry.Counter("vecble_disk_rejected_writes_total", "Writes rejected with -NOSPACE while free disk space was low."),
+	}
+	registry.GaugeFunc("vecble_disk_free_bytes", "Bytes available on the filesystem of the data directory.", func() float64 {
+		return float64(d.freeBytes.Load())
+	})
+	registry.GaugeFunc("vecble_disk_degraded", "1 while writes are rejected for lack of disk space.", func() float64 {
+		if d.degraded.Load() {
+			return 1
+		}
+		return 0
+	})
+	return d
+}
+
+// enabled reports whether free space is monitored at all.
+func (d *diskMonitor) enabled() bool {
+	return d.dir != "" && d.minFree > 0
+}
+
+func (d *diskMonitor) run(quitCh chan struct{}) {
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.check()
+		case <-quitCh:
+			return
+		}
+	}
+}
+
+// check samples the free space and enters or leaves degraded mode.
+func (d *diskMonitor) check() {
+	free, err := d.free(d.dir)
+	if err != nil {
+		log.Printf("Checking free space of %s failed: %v", d.dir, err)
+		return
+	}
+	d.freeBytes.Store(free)
+	resume := d.minFree + int64(float64(d.minFree)*diskResumeMargin)
+	switch {
+	case !d.degraded.Load() && free < d.minFree:
+		d.degraded.Store(true)
+		d.entered.Inc()
+		log.Printf("Only %d bytes free in %s, below the minimum of %d: rejecting writes until %d bytes are free",
+			free, d.dir, d.minFree, resume)
+	case d.degraded.Load() && free >= resume:
+		d.degraded.Store(false)
+		log.Printf("%d bytes free in %s again: accepting writes", free, d.dir)
+	}
+}
+
+// checkDiskSpace returns a -NOSPACE error reply if cmd writes and the
+// server is in degraded mode, unless the write frees space.
+func (s *Server) checkDiskSpace(c *connection, spec *commandSpec, args []string) string {
+	if !s.disk.degraded.Load() || c.replication || !spec.writes(args) || spaceFreeingCommands[spec.Name] {
+		return ""
+	}
+	s.disk.rejected.Inc()
+	return resp.Error("NOSPACE Free disk space is below the minimum, writes are rejected until space is freed")
+}
+
+// infoDisk reports the free space of the data directory and whether
+// writes are rejected for lack of it.
+func (s *Server) infoDisk(b *strings.Builder) {
+	degraded := 0
+	if s.disk.degraded.Load() {
+		degraded = 1
+	}
+	fmt.Fprintf(b, "disk_free_bytes:%d\r\n", s.disk.freeBytes.Load())
+	fmt.Fprintf(b, "disk_min_free_bytes:%d\r\n", s.disk.minFree)
+	fmt.Fprintf(b, "disk_degraded:%d\r\n", degraded)
+}
+
+// freeDiskSpace returns the bytes available to unprivileged users on the
+// filesystem holding dir.
+func freeDiskSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,513 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/embed"
+	"readpebble/internal/resp"
+)
+
+const (
+	// embedRetryInterval is how often the embedding queue is checked for
+	// points to embed, and embedBatch how many texts each call to the
+	// provider sends at most.
+	embedRetryInterval = time.Second
+	embedBatch         = 64
+	// embedBackoff is how long a deferred point whose text failed to
+	// embed waits before it is tried again, doubled with each further
+	// failure up to embedMaxBackoff.
+	embedBackoff    = time.Second
+	embedMaxBackoff = 5 * time.Minute
+)
+
+// embedder embeds the text of VADDTEXT and EMBED, and works through the
+// writes whose text is waiting to be embedded: the in-memory queue of the
+// Queue fallback, and the deferred points stored by the collections.
+type embedder struct {
+	*embed.Embedder
+	fallback embed.Fallback
+	// queue holds the writes of the Queue fallback.
+	queue chan collection.DeferredPoint
+	// work hands batches of deferred points to the workers, and wake
+	// makes the dispatcher look for new ones before its next tick.
+	work    chan []collection.DeferredPoint
+	wake    chan struct{}
+	workers int
+	// maxAttempts is how many times a deferred point is tried before it
+	// is dropped.
+	maxAttempts int
+
+	mutex sync.Mutex
+	// inFlight are the deferred points handed to a worker.
+	inFlight map[deferredID]struct{}
+
+	// queueMutex orders the writes of the Queue fallback, numbered by
+	// queued, and applied counts the ones embedded or dropped since, so
+	// that a write is done once applied reaches its number. queuedAt holds
+	// when each of the writes not applied yet was queued, oldest first.
+	queueMutex sync.Mutex
+	queued     int64
+	applied    atomic.Int64
+	queuedAt   []time.Time
+
+	embedded atomic.Int64
+	dropped  atomic.Int64
+
+	// cache, if set, holds the vectors texts were embedded into.
+	cache *embed.Cache
+}
+
+type deferredID struct {
+	collection, key string
+}
+
+func newEmbedder(db *pebble.DB, config Config) *embedder {
+	provider := &embed.HTTP{
+		URL:    config.EmbedderURL,
+		Model:  config.EmbedderModel,
+		APIKey: config.EmbedderAPIKey,
+		Client: &http.Client{},
+	}
+	e := &embedder{
+		Embedder: embed.New(provider, embed.Config{
+			Timeout:          config.EmbedderTimeout,
+			FailureThreshold: config.EmbedderFailureThreshold,
+			Cooldown:         config.EmbedderCooldown,
+		}),
+		fallback:    config.EmbedderFallback,
+		queue:       make(chan collection.DeferredPoint, config.EmbedderQueue),
+		work:        make(chan []collection.DeferredPoint),
+		wake:        make(chan struct{}, 1),
+		workers:     config.EmbedWorkers,
+		maxAttempts: config.EmbedMaxAttempts,
+		inFlight:    make(map[deferredID]struct{}),
+	}
+	if config.EmbedCache {
+		e.cache = embed.NewCache(db, config.EmbedderModel)
+	}
+	return e
+}
+
+// embed returns the vectors of texts, from the cache if it holds them and
+// from the provider otherwise.
+func (e *embedder) embed(ctx context.Context, texts []string) ([][]float64, error) {
+	if e.cache == nil {
+		return e.Embed(ctx, texts)
+	}
+	vectors, err := e.cache.Lookup(texts)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for i, vector := range vectors {
+		if vector == nil {
+			missing = append(missing, texts[i])
+		}
+	}
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+	embedded, err := e.Embed(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.cache.Store(missing, embedded); err != nil {
+		log.Printf("Caching embeddings failed: %v", err)
+	}
+	for i := range vectors {
+		if vectors[i] == nil {
+			vectors[i], embedded = embedded[0], embedded[1:]
+		}
+	}
+	return vectors, nil
+}
+
+// embedError renders a failed embedding. Clients may retry once the
+// provider recovers, hence TRYAGAIN.
+func embedError(err error) string {
+	return resp.Error("TRYAGAIN " + err.Error())
+}
+
+// embedCommand implements EMBED text [text ...], replying with the vector
+// of each text.
+func (s *Server) embedCommand(args []string) string {
+	if s.embedder == nil {
+		return resp.Error("ERR no embedding provider configured")
+	}
+	vectors, err := s.embedder.embed(context.Background(), args)
+	if err != nil {
+		return embedError(err)
+	}
+	var w resp.Writer
+	w.Array(len(vectors))
+	for _, vector := range vectors {
+		w.Array(len(vector))
+		for _, x := range vector {
+			w.BulkString(strconv.FormatFloat(x, 'g', -1, 64))
+		}
+	}
+	return w.String()
+}
+
+// vaddText implements VADDTEXT collection key text [PAYLOAD payload]
+// [ASYNC], adding the point with the vector the provider embeds text into.
+// ASYNC stores the point for the embedding workers instead of waiting for
+// the provider (DEFERRED). When the provider is unavailable, the
+// configured fallback decides: the write fails, is queued in memory
+// (QUEUED), or is stored for the workers (DEFERRED).
+func (s *Server) vaddText(conn *connection, args []string) string {
+	if s.embedder == nil {
+		return resp.Error("ERR no embedding provider configured")
+	}
+	c, err := s.collections.Get(args[0])
+	if err != nil {
+		return collectionError(err)
+	}
+	p := collection.DeferredPoint{Collection: c.Name, Key: args[1], Text: args[2]}
+	async := false
+	for opts := args[3:]; len(opts) > 0; {
+		switch strings.ToLower(opts[0]) {
+		case "payload":
+			if len(opts) < 2 {
+				return resp.Error("ERR syntax error")
+			}
+			p.Payload = json.RawMessage(opts[1])
+			if !json.Valid(p.Payload) {
+				return resp.Error("ERR payload is not valid JSON")
+			}
+			opts = opts[2:]
+		case "async":
+			async = true
+			opts = opts[1:]
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+	if async {
+		return s.deferText(conn, p)
+	}
+
+	vectors, err := s.embedder.embed(context.Background(), []string{p.Text})
+	if err == nil {
+		if err := s.collections.Upsert(c.Name, p.Key, vectors[0], p.Payload, s.writeMode("vaddtext")); err != nil {
+			return collectionError(err)
+		}
+		return resp.OK
+	}
+	switch s.embedder.fallback {
+	case embed.Queue:
+		seq, ok := s.embedder.enqueue(p)
+		if !ok {
+			return embedError(errors.New("embedding queue is full"))
+		}
+		conn.textWritten(p.Collection, seq)
+		return resp.SimpleString("QUEUED")
+	case embed.Defer:
+		return s.deferText(conn, p)
+	}
+	return embedError(err)
+}
+
+// enqueue queues p for the Queue fallback, returning its number, and false
+// if the queue is full.
+func (e *embedder) enqueue(p collection.DeferredPoint) (int64, bool) {
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	p.QueuedAt = time.Now()
+	select {
+	case e.queue <- p:
+		e.queued++
+		e.queuedAt = append(e.queuedAt, p.QueuedAt)
+		return e.queued, true
+	default:
+		return 0, false
+	}
+}
+
+// dequeue records that the n oldest writes of the Queue fallback were
+// embedded or dropped.
+func (e *embedder) dequeue(n int) {
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	e.applied.Add(int64(n))
+	e.queuedAt = e.queuedAt[n:]
+}
+
+// queueBacklog returns how many writes of the Queue fallback are not
+// applied yet and when the oldest of them was queued.
+func (e *embedder) queueBacklog() (int64, time.Time) {
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	if len(e.queuedAt) == 0 {
+		return 0, time.Time{}
+	}
+	return int64(len(e.queuedAt)), e.queuedAt[0]
+}
+
+// deferText stores p, written by c, for the embedding workers.
+func (s *Server) deferText(c *connection, p collection.DeferredPoint) string {
+	if err := s.collections.Defer(p.Collection, p.Key, p.Text, p.Payload, s.writeMode("vaddtext")); err != nil {
+		return collectionError(err)
+	}
+	c.textWritten(p.Collection, 0)
+	select {
+	case s.embedder.wake <- struct{}{}:
+	default:
+	}
+	return resp.SimpleString("DEFERRED")
+}
+
+// embedLoop dispatches the writes waiting to be embedded, queued ones
+// first, whenever the breaker lets calls through.
+func (s *Server) embedLoop() {
+	ticker := time.NewTicker(embedRetryInterval)
+	defer ticker.Stop()
+	// retry is the last batch taken off the queue that failed, kept
+	// rather than queued again so that writes of a key stay in order.
+	var retry []collection.DeferredPoint
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.embedder.wake:
+		case <-s.quitCh:
+			if n := len(retry) + len(s.embedder.queue); n > 0 {
+				log.Printf("Dropping %d writes queued for the embedding provider", n)
+			}
+			return
+		}
+		// Replicas get their points from their master.
+		if s.isReplica() {
+			continue
+		}
+		retry = s.retryQueued(retry)
+		s.dispatchDeferred()
+	}
+}
+
+// retryQueued embeds the queued writes, starting with retry, until there
+// are none left or the provider fails. It returns the writes to retry next
+// time.
+func (s *Server) retryQueued(retry []collection.DeferredPoint) []collection.DeferredPoint {
+	for s.embedder.State() != embed.Open {
+		for len(retry) < embedBatch && len(s.embedder.queue) > 0 {
+			retry = append(retry, <-s.embedder.queue)
+		}
+		if len(retry) == 0 {
+			break
+		}
+		texts := make([]string, len(retry))
+		for i, p := range retry {
+			texts[i] = p.Text
+		}
+		vectors, err := s.embedder.embed(context.Background(), texts)
+		if err != nil {
+			break
+		}
+		for i, p := range retry {
+			if err := s.collections.Upsert(p.Collection, p.Key, vectors[i], p.Payload, s.writeMode("vaddtext")); err != nil {
+				log.Printf("Dropping embedded point %q of %q: %v", p.Key, p.Collection, err)
+				s.embedder.dropped.Add(1)
+				continue
+			}
+			s.embedder.embedded.Add(1)
+			s.stats.indexLag.Observe(time.Since(p.QueuedAt).Seconds())
+		}
+		s.embedder.dequeue(len(retry))
+		retry = retry[:0]
+	}
+	return retry
+}
+
+// dispatchDeferred hands the deferred points that are due to the workers,
+// until there are none left, the workers are all busy, or the breaker
+// opens.
+func (s *Server) dispatchDeferred() {
+	e := s.embedder
+	for e.State() != embed.Open {
+		now := time.Now()
+		e.mutex.Lock()
+		points, _, err := s.collections.Deferred(embedBatch, func(p collection.DeferredPoint) bool {
+			_, busy := e.inFlight[deferredID{p.Collection, p.Key}]
+			return !busy && !p.RetryAt.After(now)
+		})
+		for _, p := range points {
+			e.inFlight[deferredID{p.Collection, p.Key}] = struct{}{}
+		}
+		e.mutex.Unlock()
+		if err != nil {
+			log.Printf("Reading deferred points failed: %v", err)
+			return
+		}
+		if len(points) == 0 {
+			return
+		}
+		select {
+		case e.work <- points:
+		default:
+			e.release(points)
+			return
+		}
+	}
+}
+
+func (e *embedder) release(points []collection.DeferredPoint) {
+	e.mutex.Lock()
+	for _, p := range points {
+		delete(e.inFlight, deferredID{p.Collection, p.Key})
+	}
+	e.mutex.Unlock()
+}
+
+// embedWorker embeds the batches of deferred points it is handed until the
+// server stops.
+func (s *Server) embedWorker() {
+	for {
+		select {
+		case points := <-s.embedder.work:
+			s.embedDeferred(points)
+			s.embedder.release(points)
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// embedDeferred embeds the text of deferred points and adds them to their
+// collections. Points whose text failed to embed are retried after a
+// backoff, up to maxAttempts times; points that cannot be added, say
+// because the vectors do not fit the collection, are dropped.
+func (s *Server) embedDeferred(points []collection.DeferredPoint) {
+	e := s.embedder
+	texts := make([]string, len(points))
+	for i, p := range points {
+		texts[i] = p.Text
+	}
+	vectors, err := e.embed(context.Background(), texts)
+	if errors.Is(err, embed.ErrUnavailable) {
+		// Not an attempt: the points wait for the breaker to close.
+		return
+	}
+	for i, p := range points {
+		if err == nil {
+			err := s.collections.UpsertDeferred(p, vectors[i], s.writeMode("vaddtext"))
+			switch {
+			case err == nil:
+				e.embedded.Add(1)
+				s.stats.indexLag.Observe(time.Since(p.QueuedAt).Seconds())
+			case !errors.Is(err, collection.ErrDeferredReplaced):
+				s.dropDeferred(p, err)
+			}
+			continue
+		}
+		if p.Attempts+1 >= e.maxAttempts {
+			s.dropDeferred(p, err)
+			continue
+		}
+		if err := s.collections.RetryDeferred(p, time.Now().Add(embedRetryDelay(p.Attempts+1)), err.Error()); err != nil {
+			log.Printf("Rescheduling deferred point %q of %q failed: %v", p.Key, p.Collection, err)
+		}
+	}
+}
+
+func (s *Server) dropDeferred(p collection.DeferredPoint, reason error) {
+	log.Printf("Dropping deferred point %q of %q: %v", p.Key, p.Collection, reason)
+	s.embedder.dropped.Add(1)
+	if err := s.collections.DropDeferred(p); err != nil && !errors.Is(err, collection.ErrNotFound) {
+		log.Printf("Dropping deferred point %q of %q failed: %v", p.Key, p.Collection, err)
+	}
+}
+
+// embedRetryDelay is the backoff after a deferred point failed to embed
+// attempts times, jittered so that points that failed together are not
+// all retried together.
+func embedRetryDelay(attempts int) time.Duration {
+	d := embedMaxBackoff
+	if attempts < 20 {
+		d = min(embedBackoff<<(attempts-1), embedMaxBackoff)
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// queueCommand implements QUEUE STATUS, reporting on the writes waiting to
+// be embedded.
+func (s *Server) queueCommand(args []string) string {
+	if strings.ToLower(args[0]) != "status" || len(args) != 1 {
+		return resp.Error("ERR unknown QUEUE subcommand '" + args[0] + "'")
+	}
+	if s.embedder == nil {
+		return resp.Error("ERR no embedding provider configured")
+	}
+	e := s.embedder
+	now := time.Now()
+	stats, err := s.collections.DeferredStats(now)
+	if err != nil {
+		return resp.Error("ERR Failed to read the embedding queue: " + err.Error())
+	}
+	var oldest int64
+	if !stats.Oldest.IsZero() {
+		oldest = int64(now.Sub(stats.Oldest).Seconds())
+	}
+	e.mutex.Lock()
+	inFlight := len(e.inFlight)
+	e.mutex.Unlock()
+
+	var w resp.Writer
+	w.Array(20)
+	w.BulkString("pending")
+	w.Integer(int64(stats.Pending))
+	w.BulkString("in_flight")
+	w.Integer(int64(inFlight))
+	w.BulkString("retrying")
+	w.Integer(int64(stats.Retrying))
+	w.BulkString("waiting")
+	w.Integer(int64(stats.Waiting))
+	w.BulkString("oldest_age_seconds")
+	w.Integer(oldest)
+	w.BulkString("memory_queued")
+	w.Integer(int64(len(e.queue)))
+	w.BulkString("embedded")
+	w.Integer(e.embedded.Load())
+	w.BulkString("dropped")
+	w.Integer(e.dropped.Load())
+	w.BulkString("workers")
+	w.Integer(int64(e.workers))
+	w.BulkString("circuit")
+	w.BulkString(e.State().String())
+	return w.String()
+}
@@ -0,0 +1,141 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"strings"
+)
+
+// Error replies start with a code saying what went wrong, which tells
+// clients whether sending the same command again may succeed. A retryable
+// error is a transient condition of the server or of what it depends on,
+// and the command may succeed unchanged once it clears, ideally after a
+// backoff. A terminal error will be repeated for the same command against
+// the same server: the command, its arguments, the data or the
+// permissions have to change, or the command has to go elsewhere.
+// COMMAND DOCS lists the errors each command may reply and their class.
+
+// errorClass describes an error code.
+type errorClass struct {
+	code      string
+	retryable bool
+	// description says when the error is replied.
+	description string
+}
+
+// errorClasses are the codes of the error replies of the server. Every
+// error reply starts with one of them.
+var errorClasses = []errorClass{
+	{"ERR", false, "invalid command, arguments or data"},
+	{"WRONGTYPE", false, "the key holds another type than the command operates on"},
+	{"NOAUTH", false, "the connection must authenticate first"},
+	{"WRONGPASS", false, "invalid username or password"},
+	{"NOPERM", false, "an ACL denies the command or its keys to the user"},
+	{"NOPROTO", false, "unsupported protocol version"},
+	{"READONLY", false, "writes must go to the master"},
+	{"NOTLEADER", false, "reads with LEADER consistency must go to the master"},
+	{"CROSSSLOT", false, "the keys of the command hash to different slots"},
+	{"BUSYKEY", false, "the target key already exists"},
+	{"NOGROUP", false, "no such stream or consumer group"},
+	{"BUSYGROUP", false, "the consumer group already exists"},
+	{"NOSCRIPT", false, "no script of this SHA1 digest is cached, so it must be sent with EVAL"},
+	{"QUOTA", false, "the write would exceed the quota of the tenant"},
+	{"NOSPACE", false, "free disk space is below the minimum, so writes are refused until an operator frees space"},
+	{"TRYAGAIN", true, "a dependency such as the embedding provider or the authentication backend is unavailable, or writes were not indexed in time"},
+	{"LOADING", true, "the replica is loading the dataset from its master"},
+	{"STALE", true, "the replica lags its master more than the connection's consistency allows"},
+	{"SHARDUNAVAILABLE", true, "shards of the cluster did not answer in time"},
+	{"IOERR", true, "the target instance of a migration failed or timed out"},
+}
+
+var errorClassByCode = func() map[string]*errorClass {
+	classes := make(map[string]*errorClass, len(errorClasses))
+	for i := range errorClasses {
+		classes[errorClasses[i].code] = &errorClasses[i]
+	}
+	return classes
+}()
+
+// errorCode returns the code an error reply, without its leading '-',
+// starts with.
+func errorCode(msg string) string {
+	code, _, _ := strings.Cut(msg, " ")
+	return code
+}
+
+// retryable reports whether the error reply msg, without its leading '-',
+// is a transient condition that the same command may succeed after.
+func retryable(msg string) bool {
+	class := errorClassByCode[errorCode(msg)]
+	return class != nil && class.retryable
+}
+
+// errorCodes returns the codes of the errors c may reply: those of the
+// checks handleCommand makes depending on its flags and keys, and those
+// its handler replies, listed in commands.json. Scripts may reply the
+// errors of any command they call.
+func (c *commandSpec) errorCodes() []string {
+	codes := map[string]bool{"ERR": true, "NOAUTH": true, "NOPERM": true}
+	if scriptCommands[c.Name] {
+		for _, class := range errorClasses {
+			codes[class.code] = true
+		}
+	}
+	if !c.hasFlag("loading") {
+		codes["LOADING"] = true
+	}
+	if c.hasFlag("write") {
+		codes["READONLY"] = true
+		codes["NOSPACE"] = true
+	}
+	if c.hasFlag("readonly") {
+		codes["STALE"] = true
+		codes["NOTLEADER"] = true
+	}
+	if c.FirstKey > 0 && c.LastKey != c.FirstKey || c.hasFlag("movablekeys") {
+		codes["CROSSSLOT"] = true
+	}
+	for _, category := range c.Categories {
+		if typeCategories[category] {
+			codes["WRONGTYPE"] = true
+		}
+	}
+	for _, code := range c.Errors {
+		codes[code] = true
+	}
+	var list []string
+	for _, class := range errorClasses {
+		if codes[class.code] {
+			list = append(list, class.code)
+		}
+	}
+	return list
+}
+
+// typeCategories are the ACL categories of commands that operate on keys
+// of a given type, which reply WRONGTYPE for keys of another.
+var typeCategories = map[string]bool{
+	"string": true, "hash": true, "list": true, "set": true, "sortedset": true,
+	"bitmap": true, "stream": true,
+}
@@ -0,0 +1,270 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+const (
+	// expireInterval is how often the sweeper looks for expired keys, and
+	// expireBatch how many it deletes at most each time, so that a burst
+	// of expiries does not hold up writes.
+	expireInterval = 100 * time.Millisecond
+	expireBatch    = 1000
+)
+
+// lookup returns the metadata of key and whether it exists. An expired key
+// does not exist: it is deleted on the spot, except on replicas, which
+// leave deletion to their master.
+func (s *Server) lookup(key []byte) (storage.Meta, bool, error) {
+	meta, exists, err := storage.LoadMeta(s.db, key)
+	if err != nil || !exists || !meta.Expired(time.Now()) {
+		return meta, exists, err
+	}
+	if !s.isReplica() {
+		if err := s.deleteExpired(key); err != nil {
+			log.Printf("Deleting expired key failed: %v", err)
+		}
+	}
+	return storage.Meta{}, false, nil
+}
+
+// deleteExpired deletes key if it is still expired once it holds the write
+// order for it. It leaves the key to the sweeper if a write holds it
+// instead, which may be the one calling lookup: that write does not see
+// the key either, and waiting would deadlock.
+func (s *Server) deleteExpired(key []byte) error {
+	hold := s.order.tryHold(string(key))
+	if hold == nil {
+		return nil
+	}
+	defer hold.release()
+	meta, exists, err := storage.LoadMeta(s.db, key)
+	if err != nil || !exists || !meta.Expired(time.Now()) {
+		return err
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	storage.DeleteValue(batch, key, meta)
+	batch.Delete(storage.ExpiryKey(meta.ExpireAt, key), nil)
+	if err := batch.Commit(pebble.NoSync); err != nil {
+		return err
+	}
+	s.stats.expired.Inc()
+	s.backlog.propagate([]string{"DEL", string(key)})
+	s.notifyKeyspaceEvent('x', "expired", string(key))
+	return nil
+}
+
+// expiryIn returns the time n units from now, and false if n units
+// overflow a time.Duration.
+func expiryIn(n int64, unit time.Duration) (time.Time, bool) {
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return time.Time{}, false
+	}
+	return time.Now().Add(time.Duration(n) * unit), true
+}
+
+// expire implements EXPIRE key seconds, PEXPIRE key milliseconds, EXPIREAT
+// key unix-time-seconds and PEXPIREAT key unix-time-milliseconds, the form
+// Redis masters replicate expiries in, replying 1 if the key exists. An
+// expiry in the past deletes the key.
+func (s *Server) expire(cmd string, args []string) string {
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return resp.Error("ERR value is not an integer or out of range")
+	}
+	var at time.Time
+	var fits bool
+	switch cmd {
+	case "expire":
+		at, fits = expiryIn(n, time.Second)
+	case "pexpire":
+		at, fits = expiryIn(n, time.Millisecond)
+	case "expireat":
+		// Expiries are kept in milliseconds.
+		at, fits = time.Unix(n, 0), n <= math.MaxInt64/1000 && n >= math.MinInt64/1000
+	case "pexpireat":
+		at, fits = time.UnixMilli(n), true
+	}
+	if !fits {
+		return resp.Error("ERR invalid expire time in '" + cmd + "' command")
+	}
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, exists, err := s.lookup(key)
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if !exists {
+		return resp.Integer(0)
+	}
+	if err := s.setExpiry(cmd, key, meta, at); err != nil {
+		return resp.Error("ERR Failed to set expiry: " + err.Error())
+	}
+	return resp.Integer(1)
+}
+
+// setExpiry makes key, which exists with meta, expire at at, deleting it
+// right away if at has passed. A zero at removes the expiry.
+func (s *Server) setExpiry(cmd string, key []byte, meta storage.Meta, at time.Time) error {
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if at.IsZero() || at.After(time.Now()) {
+		meta.ExpireAt = at
+		storage.SetMeta(batch, key, meta)
+	} else {
+		storage.DeleteValue(batch, key, meta)
+	}
+	return s.committer.Commit(batch, s.writeMode(cmd))
+}
+
+// ttl implements TTL and PTTL key, replying with the time left before the
+// key expires, -1 if it has no expiry and -2 if it does not exist.
+func (s *Server) ttl(cmd string, args []string) string {
+	meta, exists, err := s.lookup([]byte(args[0]))
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	switch {
+	case !exists:
+		return resp.Integer(-2)
+	case meta.ExpireAt.IsZero():
+		return resp.Integer(-1)
+	}
+	left := time.Until(meta.ExpireAt).Milliseconds()
+	if cmd == "ttl" {
+		left = (left + 500) / 1000
+	}
+	return resp.Integer(left)
+}
+
+// persist implements PERSIST key, removing the expiry of key and replying
+// 1 if it had one.
+func (s *Server) persist(args []string) string {
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, exists, err := s.lookup(key)
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if !exists || meta.ExpireAt.IsZero() {
+		return resp.Integer(0)
+	}
+	if err := s.setExpiry("persist", key, meta, time.Time{}); err != nil {
+		return resp.Error("ERR Failed to remove expiry: " + err.Error())
+	}
+	return resp.Integer(1)
+}
+
+// expireLoop deletes expired keys in the background, so that keys nobody
+// reads again do not stay on disk.
+func (s *Server) expireLoop() {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.isReplica() {
+				continue
+			}
+			err := s.runJob(jobExpire, func() error { return s.sweepExpired(time.Now()) })
+			if err != nil && err != errStopping {
+				log.Printf("Deleting expired keys failed: %v", err)
+			}
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// sweepExpired deletes up to expireBatch keys due by now, dropping the
+// index entries whose key has since been deleted or given another expiry.
+func (s *Server) sweepExpired(now time.Time) error {
+	type entry struct {
+		indexKey []byte
+		at       time.Time
+		key      string
+	}
+	lower, upper := storage.ExpiryBounds(now)
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return err
+	}
+	var due []entry
+	var keys []string
+	for iter.First(); iter.Valid() && len(due) < expireBatch; iter.Next() {
+		at, key, ok := storage.DecodeExpiryKey(iter.Key())
+		if !ok {
+			continue
+		}
+		due = append(due, entry{append([]byte{}, iter.Key()...), at, string(key)})
+		keys = append(keys, string(key))
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	hold := s.order.hold(keys)
+	defer hold.release()
+	defer s.keyLocks.lockAll(keys)()
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	var expired []string
+	for _, e := range due {
+		batch.Delete(e.indexKey, nil)
+		meta, exists, err := storage.LoadMeta(s.db, []byte(e.key))
+		if err != nil {
+			return err
+		}
+		if exists && meta.ExpireAt.UnixMilli() == e.at.UnixMilli() {
+			storage.DeleteValue(batch, []byte(e.key), meta)
+			expired = append(expired, e.key)
+		}
+	}
+	if err := batch.Commit(pebble.NoSync); err != nil {
+		return err
+	}
+	s.stats.expired.Add(float64(len(expired)))
+	// Replicas do not expire keys themselves.
+	if len(expired) > 0 {
+		s.backlog.propagate(append([]string{"DEL"}, expired...))
+	}
+	for _, key := range expired {
+		s.notifyKeyspaceEvent('x', "expired", key)
+	}
+	return nil
+}
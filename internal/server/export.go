@@ -0,0 +1,167 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+	"readpebble/internal/sqlite"
+)
+
+// vexport implements VEXPORT collection path, writing the key and payload
+// fields of every point of a collection into a SQLite database at path on
+// the server, for ad hoc SQL: with sqlite3, or with DuckDB through ATTACH
+// 'path' (TYPE sqlite). It replies with the number of points exported.
+func (s *Server) vexport(args []string) string {
+	n, err := s.exportCollection(args[0], args[1])
+	if errors.Is(err, collection.ErrNotFound) {
+		return collectionError(err)
+	}
+	if err != nil {
+		return resp.Error("ERR Failed to export collection: " + err.Error())
+	}
+	return resp.Integer(n)
+}
+
+// exportCollection writes the points of a collection into a table called
+// points with the point key as its id column and a column for each payload
+// field, in the order fields are first seen. Strings, numbers and booleans
+// are stored as such and nested objects and arrays as JSON text; a payload
+// that is not an object goes in a payload column whole. The points are read
+// from a snapshot, so the file is consistent however long the export takes,
+// and it only replaces path once complete.
+func (s *Server) exportCollection(name, path string) (int64, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	w := sqlite.NewWriter(file, "points", "id")
+	columns := make(map[string]int)
+	whole := -1
+	var n int64
+	var row []any
+	set := func(column int, value any) {
+		for len(row) <= column {
+			row = append(row, nil)
+		}
+		row[column] = value
+	}
+	err = s.collections.Export(name, func(key string, payload []byte) error {
+		s.io.backgroundRead(len(key) + len(payload))
+		row = append(row[:0], key)
+		payload = bytes.TrimSpace(payload)
+		switch {
+		case len(payload) == 0:
+		case payload[0] != '{':
+			if whole < 0 {
+				whole = w.AddColumn("payload")
+			}
+			set(whole, string(payload))
+		default:
+			err := payloadFields(payload, func(field string, value any) {
+				column, ok := columns[field]
+				if !ok {
+					column = w.AddColumn(field)
+					columns[field] = column
+				}
+				set(column, value)
+			})
+			if err != nil {
+				return fmt.Errorf("point %q: %w", key, err)
+			}
+		}
+		n++
+		return w.Insert(row)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(file.Name(), path)
+}
+
+// payloadFields calls fn with each field of a JSON object in order, with its
+// value as stored in SQLite.
+func payloadFields(payload []byte, fn func(field string, value any)) error {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		value, err := sqlValue(raw)
+		if err != nil {
+			return err
+		}
+		fn(token.(string), value)
+	}
+	return nil
+}
+
+// sqlValue converts a JSON value to the value stored in SQLite: integers
+// that fit in 64 bits as integers, other numbers as reals.
+func sqlValue(raw json.RawMessage) (any, error) {
+	switch raw[0] {
+	case '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case 't', 'f':
+		return raw[0] == 't', nil
+	case 'n':
+		return nil, nil
+	case '{', '[':
+		return string(raw), nil
+	}
+	number := json.Number(raw)
+	if i, err := number.Int64(); err == nil {
+		return i, nil
+	}
+	return number.Float64()
+}
@@ -0,0 +1,110 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"log"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+var (
+	// keyspaceStart and keyspaceEnd bound the span the keyspace is range
+	// deleted in. Keys from keyspaceEnd on are deleted one by one, which
+	// is cheap since few if any keys start with 0xff.
+	keyspaceStart = []byte{1}
+	keyspaceEnd   = []byte{0xff}
+)
+
+// flush implements FLUSHDB and FLUSHALL [ASYNC | SYNC]. There is only
+// database 0, so both delete every key: the values, the elements of hashes
+// and lists, their metadata and the expiry index, with range deletions
+// committed before the reply, so that writes made after it are kept.
+// Collections and blobs are not keys and are left alone. The space the keys
+// took is reclaimed by compacting the deleted spans, which SYNC, the
+// default, waits for and ASYNC leaves to the background.
+func (s *Server) flush(cmd string, args []string) string {
+	async := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && strings.EqualFold(args[0], "async"):
+		async = true
+	case len(args) == 1 && strings.EqualFold(args[0], "sync"):
+	default:
+		return resp.Error("ERR syntax error")
+	}
+	spans, err := s.flushKeyspace(cmd)
+	if err != nil {
+		return resp.Error("ERR Failed to flush: " + err.Error())
+	}
+	compact := func() {
+		err := s.runJob(jobVacuum, func() error {
+			for _, span := range spans {
+				if err := s.db.Compact(span[0], span[1], true); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil && err != errStopping {
+			log.Printf("Compacting flushed keys failed: %v", err)
+		}
+	}
+	if async {
+		s.goTracked(subsystemCompaction, compact)
+	} else {
+		compact()
+	}
+	return resp.OK
+}
+
+// flushKeyspace deletes every key in one batch, returning the spans it
+// deleted.
+func (s *Server) flushKeyspace(cmd string) ([][2][]byte, error) {
+	spans := append(storage.MetaSpans(), storage.ElementSpans()...)
+	spans = append(spans, [2][]byte{keyspaceStart, keyspaceEnd})
+	defer s.keyLocks.lockEvery()()
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	for _, span := range spans {
+		if err := batch.DeleteRange(span[0], span[1], nil); err != nil {
+			return nil, err
+		}
+	}
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: keyspaceEnd})
+	if err != nil {
+		return nil, err
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...), nil)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return spans, s.committer.Commit(batch, s.writeMode(cmd))
+}
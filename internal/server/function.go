@@ -0,0 +1,517 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"readpebble/internal/glob"
+	"readpebble/internal/resp"
+)
+
+// Functions are Lua libraries loaded with FUNCTION LOAD, which register
+// functions with redis.register_function for FCALL and FCALL_RO to run,
+// as Redis 7 functions do. A library's code starts with a "#!lua
+// name=<library>" line. The code of every library is stored in Pebble
+// under functionPrefix and compiled again at startup, so functions
+// survive restarts, and FUNCTION LOAD, DELETE and FLUSH are replicated.
+// Like collections, libraries are not part of the snapshot of a full
+// resynchronization: replicas only get those loaded after they attached.
+// FCALL runs a function in the sandbox of EVAL (see script.go): each call
+// gets a fresh state the library's code is run in before the function is
+// called with the keys and arguments. The top level of a library may
+// only register functions: redis.call and redis.pcall are unavailable
+// while it runs.
+
+const (
+	functionPrefix = "\x00f:"
+	functionEnd    = "\x00f;"
+)
+
+// functionFlags are the flags register_function accepts. Only no-writes
+// has an effect: such functions may be run by FCALL_RO, and may not call
+// commands that write.
+var functionFlags = []string{"no-writes", "allow-oom", "allow-stale", "no-cluster", "allow-cross-slot-keys"}
+
+type functionLibrary struct {
+	name      string
+	code      string
+	proto     *lua.FunctionProto
+	functions map[string]*libraryFunction
+}
+
+type libraryFunction struct {
+	name        string
+	description string
+	flags       []string
+	// callback is only set in the state the library was run in.
+	callback *lua.LFunction
+}
+
+func (f *libraryFunction) readOnly() bool {
+	for _, flag := range f.flags {
+		if flag == "no-writes" {
+			return true
+		}
+	}
+	return false
+}
+
+// functionRegistry holds the loaded libraries, and the library of each
+// function.
+type functionRegistry struct {
+	mutex     sync.RWMutex
+	libraries map[string]*functionLibrary
+	functions map[string]*functionLibrary
+}
+
+func newFunctionRegistry() *functionRegistry {
+	r := &functionRegistry{}
+	r.clear()
+	return r
+}
+
+// load compiles the libraries stored in db.
+func (r *functionRegistry) load(db *pebble.DB) error {
+	iter, err := db.NewIter(&pebble.IterOptions{LowerBound: []byte(functionPrefix), UpperBound: []byte(functionEnd)})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for iter.First(); iter.Valid(); iter.Next() {
+		lib, err := compileLibrary(string(iter.Value()), 0)
+		if err != nil {
+			return fmt.Errorf("library %q: %w", strings.TrimPrefix(string(iter.Key()), functionPrefix), err)
+		}
+		r.add(lib)
+	}
+	return iter.Error()
+}
+
+// add adds lib, replacing the library of the same name. The caller holds
+// the mutex.
+func (r *functionRegistry) add(lib *functionLibrary) {
+	r.remove(lib.name)
+	r.libraries[lib.name] = lib
+	for name := range lib.functions {
+		r.functions[name] = lib
+	}
+}
+
+// remove removes the library name, if loaded. The caller holds the mutex.
+func (r *functionRegistry) remove(name string) {
+	lib, ok := r.libraries[name]
+	if !ok {
+		return
+	}
+	delete(r.libraries, name)
+	for fn := range lib.functions {
+		delete(r.functions, fn)
+	}
+}
+
+// clear removes every library. The caller holds the mutex.
+func (r *functionRegistry) clear() {
+	r.libraries = make(map[string]*functionLibrary)
+	r.functions = make(map[string]*functionLibrary)
+}
+
+func (r *functionRegistry) lookup(name string) (*functionLibrary, *libraryFunction) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	lib, ok := r.functions[name]
+	if !ok {
+		return nil, nil
+	}
+	return lib, lib.functions[name]
+}
+
+// compileLibrary compiles the code of a library and runs it to learn the
+// functions it registers, for at most timeout if it is not 0.
+func compileLibrary(code string, timeout time.Duration) (*functionLibrary, error) {
+	header, body, _ := strings.Cut(code, "\n")
+	name, err := libraryName(header)
+	if err != nil {
+		return nil, err
+	}
+	// The header is blanked rather than cut so that line numbers in
+	// errors match the code.
+	chunk, err := parse.Parse(strings.NewReader("\n"+body), "@user_function")
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling function: %v", err)
+	}
+	proto, err := lua.Compile(chunk, "@user_function")
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling function: %v", err)
+	}
+	L := newScriptState(nil, nil, true)
+	defer L.Close()
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		L.SetContext(ctx)
+	}
+	functions, err := registerFunctions(L, proto)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range functions {
+		fn.callback = nil
+	}
+	return &functionLibrary{name: name, code: code, proto: proto, functions: functions}, nil
+}
+
+// libraryName parses the "#!lua name=<library>" header of a library.
+func libraryName(header string) (string, error) {
+	fields := strings.Fields(header)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "#!") {
+		return "", errors.New("Missing library metadata")
+	}
+	if engine := strings.TrimPrefix(fields[0], "#!"); !strings.EqualFold(engine, "lua") {
+		return "", fmt.Errorf("Engine '%s' not found", engine)
+	}
+	var name string
+	for _, field := range fields[1:] {
+		value, ok := strings.CutPrefix(field, "name=")
+		if !ok {
+			return "", fmt.Errorf("Invalid metadata value given: %s", field)
+		}
+		name = value
+	}
+	if !validFunctionName(name) {
+		return "", errors.New("Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// registerFunctions runs the code of a library in L, with redis.call and
+// redis.pcall hidden and redis.register_function available, and returns
+// the functions it registered.
+func registerFunctions(L *lua.LState, proto *lua.FunctionProto) (map[string]*libraryFunction, error) {
+	redis := L.GetGlobal("redis").(*lua.LTable)
+	call, pcall := redis.RawGetString("call"), redis.RawGetString("pcall")
+	functions := make(map[string]*libraryFunction)
+	redis.RawSetString("call", lua.LNil)
+	redis.RawSetString("pcall", lua.LNil)
+	redis.RawSetString("register_function", L.NewFunction(func(L *lua.LState) int {
+		fn, err := registeredFunction(L)
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		if _, ok := functions[fn.name]; ok {
+			L.RaiseError("Function already exists in the library")
+		}
+		functions[fn.name] = fn
+		return 0
+	}))
+	L.Push(L.NewFunctionFromProto(proto))
+	err := L.PCall(0, 0, nil)
+	redis.RawSetString("register_function", lua.LNil)
+	redis.RawSetString("call", call)
+	redis.RawSetString("pcall", pcall)
+	switch {
+	case err != nil:
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			return nil, fmt.Errorf("Error registering functions: %s", apiErr.Object.String())
+		}
+		return nil, fmt.Errorf("Error registering functions: %v", err)
+	case len(functions) == 0:
+		return nil, errors.New("No functions registered")
+	}
+	return functions, nil
+}
+
+// registeredFunction parses the arguments of redis.register_function,
+// either a name and a callback or a table with function_name, callback,
+// and optionally flags and description.
+func registeredFunction(L *lua.LState) (*libraryFunction, error) {
+	fn := &libraryFunction{}
+	var name lua.LValue
+	if t, ok := L.Get(1).(*lua.LTable); ok && L.GetTop() == 1 {
+		var err error
+		t.ForEach(func(key, value lua.LValue) {
+			switch key.String() {
+			case "function_name":
+				name = value
+			case "callback":
+				fn.callback, _ = value.(*lua.LFunction)
+			case "description":
+				fn.description = value.String()
+			case "flags":
+				flags, ok := value.(*lua.LTable)
+				if !ok {
+					err = errors.New("flags argument to redis.register_function must be a table representing function flags")
+					return
+				}
+				flags.ForEach(func(_, flag lua.LValue) {
+					fn.flags = append(fn.flags, flag.String())
+				})
+			default:
+				err = errors.New("unknown argument given to redis.register_function")
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if L.GetTop() == 2 {
+		name = L.Get(1)
+		fn.callback, _ = L.Get(2).(*lua.LFunction)
+	} else {
+		return nil, errors.New("wrong number of arguments to redis.register_function")
+	}
+	if s, ok := name.(lua.LString); ok {
+		fn.name = string(s)
+	}
+	if !validFunctionName(fn.name) {
+		return nil, errors.New("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	if fn.callback == nil {
+		return nil, errors.New("callback must be a function")
+	}
+	for _, flag := range fn.flags {
+		if !slices.Contains(functionFlags, flag) {
+			return nil, errors.New("unknown flag given")
+		}
+	}
+	return fn, nil
+}
+
+// fcall implements FCALL function numkeys [key ...] [arg ...], and with
+// readOnly set FCALL_RO, which only runs functions flagged no-writes.
+func (s *Server) fcall(c *connection, args []string, readOnly bool) string {
+	lib, fn := s.functions.lookup(args[0])
+	if fn == nil {
+		return resp.Error("ERR Function not found")
+	}
+	if readOnly && !fn.readOnly() {
+		return resp.Error("ERR Can not execute a script with write flag using *_ro command.")
+	}
+	keys, argv, reply := scriptArgs(args[1:])
+	if reply != "" {
+		return reply
+	}
+	return s.runScript(c, fn.readOnly(), func(L *lua.LState) error {
+		functions, err := registerFunctions(L, lib.proto)
+		if err != nil {
+			return err
+		}
+		L.Push(functions[fn.name].callback)
+		L.Push(scriptStrings(L, keys))
+		L.Push(scriptStrings(L, argv))
+		return L.PCall(2, 1, nil)
+	})
+}
+
+// function implements FUNCTION LOAD [REPLACE] code, FUNCTION DELETE
+// library, FUNCTION FLUSH [ASYNC | SYNC] and FUNCTION LIST [LIBRARYNAME
+// pattern] [WITHCODE].
+func (s *Server) function(c *connection, args []string) string {
+	switch sub := strings.ToLower(args[0]); sub {
+	case "load":
+		replace := len(args) == 3 && strings.EqualFold(args[1], "replace")
+		if len(args) != 2 && !replace {
+			return resp.Error("ERR wrong number of arguments for 'function|load' command")
+		}
+		return s.functionLoad(args[len(args)-1], replace)
+	case "delete":
+		if len(args) != 2 {
+			return resp.Error("ERR wrong number of arguments for 'function|delete' command")
+		}
+		return s.functionDelete(args[1])
+	case "flush":
+		if len(args) > 2 {
+			return resp.Error("ERR wrong number of arguments for 'function|flush' command")
+		}
+		if len(args) == 2 && !strings.EqualFold(args[1], "async") && !strings.EqualFold(args[1], "sync") {
+			return resp.Error("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+		}
+		return s.functionFlush()
+	case "list":
+		return s.functionList(c, args[1:])
+	}
+	return resp.Error("ERR unknown FUNCTION subcommand '" + args[0] + "'")
+}
+
+func (s *Server) functionLoad(code string, replace bool) string {
+	lib, err := compileLibrary(code, s.live.Load().ScriptTimeout)
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	r := s.functions
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.libraries[lib.name]; ok && !replace {
+		return resp.Errorf("ERR Library '%s' already exists", lib.name)
+	}
+	for name := range lib.functions {
+		if other, ok := r.functions[name]; ok && other.name != lib.name {
+			return resp.Errorf("ERR Function %s already exists", name)
+		}
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	batch.Set([]byte(functionPrefix+lib.name), []byte(code), nil)
+	if err := s.committer.Commit(batch, s.writeMode("function")); err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	r.add(lib)
+	return resp.BulkString(lib.name)
+}
+
+func (s *Server) functionDelete(name string) string {
+	r := s.functions
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.libraries[name]; !ok {
+		return resp.Error("ERR Library not found")
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	batch.Delete([]byte(functionPrefix+name), nil)
+	if err := s.committer.Commit(batch, s.writeMode("function")); err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	r.remove(name)
+	return resp.OK
+}
+
+func (s *Server) functionFlush() string {
+	r := s.functions
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if err := batch.DeleteRange([]byte(functionPrefix), []byte(functionEnd), nil); err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	if err := s.committer.Commit(batch, s.writeMode("function")); err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	r.clear()
+	return resp.OK
+}
+
+// functionList replies with the libraries whose names match the pattern
+// of LIBRARYNAME, sorted by name, each with its functions and, with
+// WITHCODE, its code.
+func (s *Server) functionList(c *connection, args []string) string {
+	pattern, withCode := "*", false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "withcode"):
+			withCode = true
+		case strings.EqualFold(args[i], "libraryname") && i+1 < len(args):
+			i++
+			pattern = args[i]
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+	r := s.functions
+	r.mutex.RLock()
+	var libs []*functionLibrary
+	for name, lib := range r.libraries {
+		if glob.Match(pattern, name) {
+			libs = append(libs, lib)
+		}
+	}
+	r.mutex.RUnlock()
+	sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+
+	var w resp.Writer
+	writeMap := func(n int) {
+		if c.protocol >= 3 {
+			w.Map(n)
+		} else {
+			w.Array(2 * n)
+		}
+	}
+	w.Array(len(libs))
+	for _, lib := range libs {
+		fields := 3
+		if withCode {
+			fields++
+		}
+		writeMap(fields)
+		w.BulkString("library_name")
+		w.BulkString(lib.name)
+		w.BulkString("engine")
+		w.BulkString("LUA")
+		w.BulkString("functions")
+		names := make([]string, 0, len(lib.functions))
+		for name := range lib.functions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.Array(len(names))
+		for _, name := range names {
+			fn := lib.functions[name]
+			writeMap(3)
+			w.BulkString("name")
+			w.BulkString(fn.name)
+			w.BulkString("description")
+			if fn.description == "" {
+				w.Nil()
+			} else {
+				w.BulkString(fn.description)
+			}
+			w.BulkString("flags")
+			w.Array(len(fn.flags))
+			for _, flag := range fn.flags {
+				w.BulkString(flag)
+			}
+		}
+		if withCode {
+			w.BulkString("library_code")
+			w.BulkString(lib.code)
+		}
+	}
+	return w.String()
+}
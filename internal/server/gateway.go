@@ -0,0 +1,984 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"readpebble/internal/auth"
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+)
+
+// The gateway serves the collections over REST under /v1, in the shape the
+// vector store integrations of LangChain and LlamaIndex expect: points are
+// documents with an ID, a vector, a text and metadata, written in batches
+// and searched by vector or by text with metadata filters. Each request
+// runs as the commands it translates to, so authentication, ACLs, replicas,
+// the shadow and durability treat it like the same RESP commands. The API
+// is described by /openapi.json.
+
+//go:embed openapi.json
+var openAPIJSON []byte
+
+const (
+	// gatewayTextField is the payload field the text of a document is
+	// stored in, next to its metadata.
+	gatewayTextField = "text"
+	// gatewayDefaultK is how many results a search returns without k,
+	// as in LangChain.
+	gatewayDefaultK = 4
+	// gatewayPage is how many points a delete by filter scrolls and
+	// deletes at a time.
+	gatewayPage    = 1000
+	maxGatewayBody = 64 << 20
+)
+
+func (s *Server) registerGateway(mux *http.ServeMux) {
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI)
+	mux.HandleFunc("GET /v1/collections", s.gateway(s.listCollections))
+	mux.HandleFunc("PUT /v1/collections/{collection}", s.gateway(s.createCollection))
+	mux.HandleFunc("GET /v1/collections/{collection}", s.gateway(s.getCollection))
+	mux.HandleFunc("DELETE /v1/collections/{collection}", s.gateway(s.dropCollection))
+	mux.HandleFunc("POST /v1/collections/{collection}/points", s.gateway(s.upsertPoints))
+	mux.HandleFunc("POST /v1/collections/{collection}/points/get", s.gateway(s.getPoints))
+	mux.HandleFunc("POST /v1/collections/{collection}/points/delete", s.gateway(s.deletePoints))
+	mux.HandleFunc("GET /v1/collections/{collection}/points/{id}", s.gateway(s.getPoint))
+	mux.HandleFunc("DELETE /v1/collections/{collection}/points/{id}", s.gateway(s.deletePoint))
+	mux.HandleFunc("POST /v1/collections/{collection}/search", s.gateway(s.searchPoints))
+	mux.HandleFunc("POST /v1/collections/{collection}/query", s.gateway(s.queryPoints))
+	mux.HandleFunc("POST /v1/sql", s.gateway(s.sqlPoints))
+	if s.config.EmbeddingsEndpoint {
+		mux.HandleFunc("POST /v1/embeddings", s.gateway(s.embeddings))
+	}
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
+
+// gatewayError is a failed request, answered with status and a JSON body
+// holding the message.
+type gatewayError struct {
+	status  int
+	message string
+}
+
+func (e *gatewayError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &gatewayError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// replyError maps the error reply of a command to a status, 503 for the
+// errors worth retrying (see errorClasses).
+func replyError(msg string) error {
+	status := http.StatusBadRequest
+	if retryable(msg) {
+		status = http.StatusServiceUnavailable
+	}
+	code, text, _ := strings.Cut(msg, " ")
+	switch code {
+	case "NOAUTH", "WRONGPASS":
+		status = http.StatusUnauthorized
+	case "NOPERM":
+		status = http.StatusForbidden
+	case "READONLY", "NOTLEADER":
+		status = http.StatusMisdirectedRequest
+	case "QUOTA":
+		status = http.StatusInsufficientStorage
+	case "ERR":
+		msg = text
+		switch {
+		case strings.Contains(text, "no such"):
+			status = http.StatusNotFound
+		case strings.Contains(text, "already exists"):
+			status = http.StatusConflict
+		}
+	}
+	return &gatewayError{status, msg}
+}
+
+// gateway adapts a gateway handler, which runs on behalf of the connection
+// the request authenticates as and returns the value to reply with.
+func (s *Server) gateway(h func(*connection, *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := s.gatewayConnection(r)
+		if err == nil {
+			var value interface{}
+			if value, err = h(c, r); err == nil {
+				writeJSON(w, value)
+				return
+			}
+		}
+		status := http.StatusInternalServerError
+		var gerr *gatewayError
+		if errors.As(err, &gerr) {
+			status = gerr.status
+		}
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="vecble"`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	}
+}
+
+// gatewayAddr and gatewayConn stand in for the network connection of a
+// gateway request, of which commands only ever ask the client's address.
+type gatewayAddr string
+
+func (a gatewayAddr) Network() string { return "tcp" }
+func (a gatewayAddr) String() string  { return string(a) }
+
+type gatewayConn struct {
+	net.Conn
+	addr gatewayAddr
+}
+
+func (g gatewayConn) RemoteAddr() net.Addr { return g.addr }
+
+// gatewayConnection returns the connection the commands of r run on. It
+// is authenticated with the credentials of r, given with HTTP basic
+// authentication or as a bearer token holding the password of the default
+// user. Without credentials, commands reply NOAUTH if authentication is
+// required.
+func (s *Server) gatewayConnection(r *http.Request) (*connection, error) {
+	c := &connection{
+		conn:        gatewayConn{addr: gatewayAddr(r.RemoteAddr)},
+		protocol:    2,
+		consistency: consistencyLocal,
+		createdAt:   time.Now(),
+		lastActive:  time.Now(),
+		ctx:         r.Context(),
+	}
+	authenticator := s.config.Authenticator
+	if authenticator == nil {
+		c.authenticated = true
+		return c, nil
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			return c, nil
+		}
+		username, password = auth.DefaultUser, token
+	}
+	user, err := authenticator.Authenticate(username, password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		log.Printf("Failed gateway authentication for user %q from %s", username, r.RemoteAddr)
+		return nil, &gatewayError{http.StatusUnauthorized, "WRONGPASS " + err.Error()}
+	}
+	if err != nil {
+		log.Printf("Authentication backend failed for %s: %v", r.RemoteAddr, err)
+		return nil, &gatewayError{http.StatusServiceUnavailable, "authentication backend unavailable"}
+	}
+	c.authenticated = true
+	c.user = user
+	return c, nil
+}
+
+// gatewayCommand runs cmd on c and returns its decoded reply.
+func (s *Server) gatewayCommand(c *connection, cmd string, args ...string) (interface{}, error) {
+	c.touch(cmd)
+	start := time.Now()
+	reply := s.handleCommand(c, cmd, args)
+	elapsed := time.Since(start)
+	s.stats.command(cmd, elapsed, reply)
+	s.logSlow(c, cmd, args, elapsed)
+	s.collectionCommand(cmd, args, reply, elapsed)
+	value, err := resp.NewReader(strings.NewReader(reply)).ReadReply()
+	var errReply resp.ErrorReply
+	if errors.As(err, &errReply) {
+		return nil, replyError(string(errReply))
+	}
+	return value, err
+}
+
+func decodeGatewayBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxGatewayBody))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+type gatewayCollection struct {
+	Name      string                 `json:"name"`
+	Dimension int                    `json:"dimension"`
+	Metric    collection.Metric      `json:"metric"`
+	Tenant    string                 `json:"tenant,omitempty"`
+	Index     collection.IndexParams `json:"index"`
+	Fields    []string               `json:"fields,omitempty"`
+	Count     *int64                 `json:"count,omitempty"`
+}
+
+func collectionView(info collection.Info) gatewayCollection {
+	return gatewayCollection{
+		Name:      info.Name,
+		Dimension: info.Dimension,
+		Metric:    info.Metric,
+		Tenant:    info.Tenant,
+		Index:     info.Index,
+		Fields:    info.Fields,
+	}
+}
+
+func (s *Server) listCollections(c *connection, r *http.Request) (interface{}, error) {
+	reply, err := s.gatewayCommand(c, "vlist")
+	if err != nil {
+		return nil, err
+	}
+	views := []gatewayCollection{}
+	for _, name := range replyStrings(reply) {
+		coll, err := s.collections.Get(name)
+		if err != nil {
+			continue
+		}
+		views = append(views, collectionView(coll.Info))
+	}
+	return map[string]interface{}{"collections": views}, nil
+}
+
+type createRequest struct {
+	Dimension int      `json:"dimension"`
+	Metric    string   `json:"metric"`
+	Tenant    string   `json:"tenant"`
+	Index     string   `json:"index"`
+	Fields    []string `json:"fields"`
+}
+
+// createCollection creates the collection with VCREATE.
+func (s *Server) createCollection(c *connection, r *http.Request) (interface{}, error) {
+	var req createRequest
+	if err := decodeGatewayBody(r, &req); err != nil {
+		return nil, err
+	}
+	name := r.PathValue("collection")
+	args := []string{name, "DIM", strconv.Itoa(req.Dimension)}
+	for _, opt := range [][2]string{{"METRIC", req.Metric}, {"TENANT", req.Tenant}, {"INDEX", req.Index}} {
+		if opt[1] != "" {
+			args = append(args, opt[0], opt[1])
+		}
+	}
+	for _, field := range req.Fields {
+		args = append(args, "FIELD", field)
+	}
+	if _, err := s.gatewayCommand(c, "vcreate", args...); err != nil {
+		return nil, err
+	}
+	return s.getCollection(c, r)
+}
+
+// getCollection describes the collection, with the number of points VCOUNT
+// finds in it.
+func (s *Server) getCollection(c *connection, r *http.Request) (interface{}, error) {
+	name := r.PathValue("collection")
+	reply, err := s.gatewayCommand(c, "vcount", name)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := s.collections.Get(name)
+	if err != nil {
+		return nil, replyError("ERR " + err.Error())
+	}
+	view := collectionView(coll.Info)
+	count, _ := reply.(int64)
+	view.Count = &count
+	return view, nil
+}
+
+func (s *Server) dropCollection(c *connection, r *http.Request) (interface{}, error) {
+	if _, err := s.gatewayCommand(c, "vdrop", r.PathValue("collection")); err != nil {
+		return nil, err
+	}
+	return map[string]bool{"deleted": true}, nil
+}
+
+// gatewayPoint is a point as a document: its payload holds the document's
+// text in gatewayTextField and its metadata in the other fields.
+type gatewayPoint struct {
+	ID       string                 `json:"id"`
+	Vector   []float64              `json:"vector,omitempty"`
+	Text     string                 `json:"text,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// payload merges the text of p into its metadata.
+func (p gatewayPoint) payload() (string, error) {
+	fields := make(map[string]interface{}, len(p.Metadata)+1)
+	for k, v := range p.Metadata {
+		fields[k] = v
+	}
+	if p.Text != "" {
+		fields[gatewayTextField] = p.Text
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(fields)
+	return string(data), err
+}
+
+// newPointID returns a random UUID for points written without an ID.
+func newPointID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// upsertPoints writes a batch of points with VADD, or with VADDTEXT for
+// those given a text but no vector, replying with their IDs. Points are
+// written in order and a failure stops the batch: since writes are
+// upserts, the whole batch may be sent again.
+func (s *Server) upsertPoints(c *connection, r *http.Request) (interface{}, error) {
+	var req struct {
+		Points []gatewayPoint `json:"points"`
+	}
+	if err := decodeGatewayBody(r, &req); err != nil {
+		return nil, err
+	}
+	name := r.PathValue("collection")
+	ids := make([]string, len(req.Points))
+	for i, p := range req.Points {
+		if p.ID == "" {
+			p.ID = newPointID()
+		}
+		if p.Vector == nil && p.Text == "" {
+			return nil, badRequest("point %d has neither a vector nor a text", i)
+		}
+		payload, err := p.payload()
+		if err != nil {
+			return nil, badRequest("point %d: %v", i, err)
+		}
+		var cmd string
+		var args []string
+		if p.Vector != nil {
+			cmd = "vadd"
+			args = append([]string{name, p.ID}, formatVector(p.Vector)...)
+		} else {
+			cmd = "vaddtext"
+			args = []string{name, p.ID, p.Text}
+		}
+		if payload != "" {
+			args = append(args, "PAYLOAD", payload)
+		}
+		if _, err := s.gatewayCommand(c, cmd, args...); err != nil {
+			return nil, fmt.Errorf("point %d: %w", i, err)
+		}
+		ids[i] = p.ID
+	}
+	return map[string]interface{}{"ids": ids}, nil
+}
+
+// loadPoint reads the point id with VGET, reporting whether it exists.
+func (s *Server) loadPoint(c *connection, name, id string, withVector bool) (gatewayPoint, bool, error) {
+	reply, err := s.gatewayCommand(c, "vget", name, id)
+	if err != nil || reply == nil {
+		return gatewayPoint{}, false, err
+	}
+	parts, _ := reply.([]interface{})
+	if len(parts) != 2 {
+		return gatewayPoint{}, false, fmt.Errorf("unexpected VGET reply %v", reply)
+	}
+	p := gatewayPoint{ID: id}
+	if withVector {
+		for _, x := range replyStrings(parts[0]) {
+			f, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return gatewayPoint{}, false, err
+			}
+			p.Vector = append(p.Vector, f)
+		}
+	}
+	if payload, ok := parts[1].(string); ok {
+		decoder := json.NewDecoder(strings.NewReader(payload))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return gatewayPoint{}, false, err
+		}
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			// Written by VADD with a payload that is not an object.
+			fields = map[string]interface{}{"payload": value}
+		}
+		if text, ok := fields[gatewayTextField].(string); ok {
+			p.Text = text
+			delete(fields, gatewayTextField)
+		}
+		if len(fields) > 0 {
+			p.Metadata = fields
+		}
+	}
+	return p, true, nil
+}
+
+type pointsRequest struct {
+	IDs            []string        `json:"ids"`
+	Filter         json.RawMessage `json:"filter"`
+	IncludeVectors bool            `json:"include_vectors"`
+}
+
+// getPoints replies with the points of the given IDs that exist, in order.
+func (s *Server) getPoints(c *connection, r *http.Request) (interface{}, error) {
+	var req pointsRequest
+	if err := decodeGatewayBody(r, &req); err != nil {
+		return nil, err
+	}
+	points := []gatewayPoint{}
+	for _, id := range req.IDs {
+		p, exists, err := s.loadPoint(c, r.PathValue("collection"), id, req.IncludeVectors)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			points = append(points, p)
+		}
+	}
+	return map[string]interface{}{"points": points}, nil
+}
+
+func (s *Server) getPoint(c *connection, r *http.Request) (interface{}, error) {
+	p, exists, err := s.loadPoint(c, r.PathValue("collection"), r.PathValue("id"), true)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, &gatewayError{http.StatusNotFound, "no such point"}
+	}
+	return p, nil
+}
+
+// deletePoints deletes the points of the given IDs, or those matching a
+// filter, replying with how many were deleted.
+func (s *Server) deletePoints(c *connection, r *http.Request) (interface{}, error) {
+	var req pointsRequest
+	if err := decodeGatewayBody(r, &req); err != nil {
+		return nil, err
+	}
+	name := r.PathValue("collection")
+	if (req.IDs == nil) == (req.Filter == nil) {
+		return nil, badRequest("give either ids or a filter")
+	}
+	if req.IDs != nil {
+		deleted, err := s.deleteIDs(c, name, req.IDs)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]int64{"deleted": deleted}, nil
+	}
+	filter, err := gatewayFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	var deleted int64
+	for {
+		// Deleted points no longer match, so every page starts over.
+		reply, err := s.gatewayCommand(c, "vscroll", name, "0", "COUNT", strconv.Itoa(gatewayPage), "FILTER", filter)
+		if err != nil {
+			return nil, err
+		}
+		parts, _ := reply.([]interface{})
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected VSCROLL reply %v", reply)
+		}
+		ids := replyStrings(parts[1])
+		n, err := s.deleteIDs(c, name, ids)
+		if err != nil {
+			return nil, err
+		}
+		deleted += n
+		if parts[0] == "0" || n == 0 {
+			break
+		}
+	}
+	return map[string]int64{"deleted": deleted}, nil
+}
+
+func (s *Server) deleteIDs(c *connection, name string, ids []string) (int64, error) {
+	var deleted int64
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), gatewayPage)]
+		ids = ids[len(batch):]
+		reply, err := s.gatewayCommand(c, "vdel", append([]string{name}, batch...)...)
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := reply.(int64)
+		deleted += n
+	}
+	return deleted, nil
+}
+
+func (s *Server) deletePoint(c *connection, r *http.Request) (interface{}, error) {
+	deleted, err := s.deleteIDs(c, r.PathValue("collection"), []string{r.PathValue("id")})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int64{"deleted": deleted}, nil
+}
+
+type searchRequest struct {
+	Vector         []float64       `json:"vector"`
+	Text           string          `json:"text"`
+	K              *int            `json:"k"`
+	Filter         json.RawMessage `json:"filter"`
+	EF             int             `json:"ef"`
+	IncludeVectors bool            `json:"include_vectors"`
+}
+
+type searchResult struct {
+	gatewayPoint
+	// Score is a similarity, higher for closer points: 1 - distance for
+	// cosine, the inner product for ip and 1 / (1 + distance) for l2.
+	Score    float64 `json:"score"`
+	Distance float64 `json:"distance"`
+}
+
+// searchPoints searches the collection with VSEARCH, for the vector or for
+// the text embedded with EMBED, replying with the closest points first.
+func (s *Server) searchPoints(c *connection, r *http.Request) (interface{}, error) {
+	var req searchRequest
+	if err := decodeGatewayBody(r, &req); err != nil {
+		return nil, err
+	}
+	name := r.PathValue("collection")
+	if (req.Vector == nil) == (req.Text == "") {
+		return nil, badRequest("give either a vector or a text")
+	}
+	k := gatewayDefaultK
+	if req.K != nil {
+		k = *req.K
+	}
+	vector := formatVector(req.Vector)
+	if req.Text != "" {
+		reply, err := s.gatewayCommand(c, "embed", req.Text)
+		if err != nil {
+			return nil, err
+		}
+		vectors, _ := reply.([]interface{})
+		if len(vectors) != 1 {
+			return nil, fmt.Errorf("unexpected EMBED reply %v", reply)
+		}
+		vector = replyStrings(vectors[0])
+	}
+	args := append([]string{name, strconv.Itoa(k)}, vector...)
+	if req.EF > 0 {
+		args = append(args, "EF", strconv.Itoa(req.EF))
+	}
+	if req.Filter != nil {
+		filter, err := gatewayFilter(req.Filter)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "FILTER", filter)
+	}
+	reply, err := s.gatewayCommand(c, "vsearch", args...)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := s.collections.Get(name)
+	if err != nil {
+		return nil, replyError("ERR " + err.Error())
+	}
+	hits := replyStrings(reply)
+	results := []searchResult{}
+	for i := 0; i+1 < len(hits); i += 2 {
+		distance, err := strconv.ParseFloat(hits[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		p, exists, err := s.loadPoint(c, name, hits[i], req.IncludeVectors)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			// Deleted since the search.
+			continue
+		}
+		results = append(results, searchResult{p, similarity(coll.Metric, distance), distance})
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+// queryRequest is a query of several stages as VQUERY takes it (see
+// queryStage), with filters in any of the forms a search takes.
+type queryRequest struct {
+	Prefetch       []*queryRequest `json:"prefetch"`
+	Vector         []float64       `json:"vector"`
+	Text           string          `json:"text"`
+	Filter         json.RawMessage `json:"filter"`
+	Fusion         string          `json:"fusion"`
+	Limit          *int            `json:"limit"`
+	EF             int             `json:"ef"`
+	IncludeVectors bool            `json:"include_vectors"`
+}
+
+// stage translates the request into a VQUERY query stage.
+func (q *queryRequest) stage() (*queryStage, error) {
+	st := &queryStage{Vector: q.Vector, Text: q.Text, Fusion: q.Fusion, Limit: q.Limit, EF: q.EF}
+	if q.Filter != nil {
+		filter, err := gatewayFilter(q.Filter)
+		if err != nil {
+			return nil, err
+		}
+		st.Filter = filter
+	}
+	for _, p := range q.Prefetch {
+		prefetch, err := p.stage()
+		if err != nil {
+			return nil, err
+		}
+		st.Prefetch = append(st.Prefetch, prefetch)
+	}
+	return st, nil
+}
+
+type queryResult struct {
+	gatewayPoint
+	// Score is the similarity of the point, as in a search, for a query
+	// ranking by vector or text, and its fused score for a fusion.
+	Score float64 `json:"score"`
+}
+
+// queryPoints runs a query of several stages with VQUERY, replying with
+// the points it kept in rank order.
+func (s *Server) queryPoints(c *connection, r *http.Request) (interface{}, error) {
+	var req queryRequest
+	if err := decodeGatewayBody(r, &req); err != nil {
+		return nil, err
+	}
+	name := r.PathValue("collection")
+	st, err := req.stage()
+	if err != nil {
+		return nil, err
+	}
+	query, err := json.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := s.gatewayCommand(c, "vquery", name, string(query))
+	if err != nil {
+		return nil, err
+	}
+	coll, err := s.collections.Get(name)
+	if err != nil {
+		return nil, replyError("ERR " + err.Error())
+	}
+	hits := replyStrings(reply)
+	results := []queryResult{}
+	for i := 0; i+1 < len(hits); i += 2 {
+		score, err := strconv.ParseFloat(hits[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		if st.Vector != nil || st.Text != "" {
+			score = similarity(coll.Metric, score)
+		}
+		p, exists, err := s.loadPoint(c, name, hits[i], req.IncludeVectors)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			// Deleted since the query.
+			continue
+		}
+		results = append(results, queryResult{p, score})
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+type embeddingsRequest struct {
+	Input          json.RawMessage `json:"input"`
+	Model          string          `json:"model"`
+	EncodingFormat string          `json:"encoding_format"`
+}
+
+type embedding struct {
+	Object    string      `json:"object"`
+	Index     int         `json:"index"`
+	Embedding interface{} `json:"embedding"`
+}
+
+// embeddings implements POST /v1/embeddings as the OpenAI embeddings API
+// does, with EMBED. Inputs must be texts rather than token arrays, and
+// only the configured model is served. Token usage is not known and
+// reported as 0.
+func (s *Server) embeddings(c *connection, r *http.Request) (interface{}, error) {
+	var req embeddingsRequest
+	if err := decodeGatewayBody(r, &req); err != nil {
+		return nil, err
+	}
+	var texts []string
+	if err := json.Unmarshal(req.Input, &texts); err != nil {
+		var text string
+		if err := json.Unmarshal(req.Input, &text); err != nil {
+			return nil, badRequest("input must be a string or an array of strings")
+		}
+		texts = []string{text}
+	}
+	if len(texts) == 0 {
+		return nil, badRequest("input must not be empty")
+	}
+	model := s.config.EmbedderModel
+	switch {
+	case req.Model != "" && model != "" && req.Model != model:
+		return nil, badRequest("model %q is not served, only %q is", req.Model, model)
+	case model == "":
+		model = req.Model
+	}
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		return nil, badRequest("encoding_format must be float or base64")
+	}
+	reply, err := s.gatewayCommand(c, "embed", texts...)
+	if err != nil {
+		return nil, err
+	}
+	vectors, _ := reply.([]interface{})
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("unexpected EMBED reply %v", reply)
+	}
+	data := make([]embedding, len(vectors))
+	for i, v := range vectors {
+		components := replyStrings(v)
+		vector := make([]float64, len(components))
+		for j, x := range components {
+			if vector[j], err = strconv.ParseFloat(x, 64); err != nil {
+				return nil, err
+			}
+		}
+		data[i] = embedding{Object: "embedding", Index: i, Embedding: vector}
+		if req.EncodingFormat == "base64" {
+			data[i].Embedding = encodeFloat32s(vector)
+		}
+	}
+	return map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  model,
+		"usage":  map[string]int{"prompt_tokens": 0, "total_tokens": 0},
+	}, nil
+}
+
+// encodeFloat32s encodes vector as OpenAI does for the base64 encoding
+// format: little-endian float32 components, in base64.
+func encodeFloat32s(vector []float64) string {
+	buf := make([]byte, 4*len(vector))
+	for i, x := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(x)))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// similarity turns a distance into a score that is higher for closer
+// points, which is what vector store integrations rank by.
+func similarity(metric collection.Metric, distance float64) float64 {
+	switch metric {
+	case collection.MetricCosine:
+		return 1 - distance
+	case collection.MetricIP:
+		return -distance
+	}
+	return 1 / (1 + distance)
+}
+
+// gatewayFilter translates the filter of a request into a filter
+// expression. It is either an expression already, an object of metadata
+// fields and the values they must equal (or be one of, for arrays), as
+// LangChain passes them, or metadata filters as LlamaIndex passes them:
+// {"filters": [{"key": k, "value": v, "operator": op}, ...], "condition":
+// "and" | "or"}, where a filter may itself be such an object.
+func gatewayFilter(raw json.RawMessage) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", badRequest("invalid filter: %v", err)
+	}
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case map[string]interface{}:
+		if _, ok := value["filters"]; ok {
+			return metadataFilters(value)
+		}
+		fields := make([]string, 0, len(value))
+		for field := range value {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		conditions := make([]string, len(fields))
+		for i, field := range fields {
+			op := "=="
+			if _, ok := value[field].([]interface{}); ok {
+				op = "in"
+			}
+			condition, err := filterCondition(field, op, value[field])
+			if err != nil {
+				return "", err
+			}
+			conditions[i] = condition
+		}
+		return strings.Join(conditions, " AND "), nil
+	}
+	return "", badRequest("a filter is an expression or an object")
+}
+
+func metadataFilters(value map[string]interface{}) (string, error) {
+	join := " AND "
+	switch condition, _ := value["condition"].(string); strings.ToLower(condition) {
+	case "", "and":
+	case "or":
+		join = " OR "
+	default:
+		return "", badRequest("unknown filter condition %q", condition)
+	}
+	filters, ok := value["filters"].([]interface{})
+	if !ok || len(filters) == 0 {
+		return "", badRequest("filters must be a non-empty array")
+	}
+	conditions := make([]string, len(filters))
+	for i, f := range filters {
+		filter, ok := f.(map[string]interface{})
+		if !ok {
+			return "", badRequest("filters must be objects")
+		}
+		var condition string
+		var err error
+		if _, nested := filter["filters"]; nested {
+			condition, err = metadataFilters(filter)
+		} else {
+			key, _ := filter["key"].(string)
+			op, _ := filter["operator"].(string)
+			if op == "" {
+				op = "=="
+			}
+			condition, err = filterCondition(key, op, filter["value"])
+		}
+		if err != nil {
+			return "", err
+		}
+		conditions[i] = "(" + condition + ")"
+	}
+	return strings.Join(conditions, join), nil
+}
+
+// filterCondition renders a condition on field with one of the operators
+// of LlamaIndex's metadata filters.
+func filterCondition(field, op string, value interface{}) (string, error) {
+	if field == "" {
+		return "", badRequest("filter without a key")
+	}
+	switch op {
+	case "==", "!=", ">", ">=", "<", "<=":
+		literal, err := filterLiteral(value)
+		if err != nil {
+			return "", err
+		}
+		if op == "==" {
+			op = "="
+		}
+		return field + " " + op + " " + literal, nil
+	case "in", "nin":
+		values, ok := value.([]interface{})
+		if !ok || len(values) == 0 {
+			return "", badRequest("%s expects a non-empty array", op)
+		}
+		literals := make([]string, len(values))
+		for i, v := range values {
+			literal, err := filterLiteral(v)
+			if err != nil {
+				return "", err
+			}
+			literals[i] = literal
+		}
+		condition := field + " IN (" + strings.Join(literals, ", ") + ")"
+		if op == "nin" {
+			condition = "NOT " + condition
+		}
+		return condition, nil
+	case "text_match":
+		literal, err := filterLiteral(value)
+		if err != nil {
+			return "", err
+		}
+		return "MATCH(" + field + ", " + literal + ")", nil
+	case "is_empty":
+		return "NOT EXISTS(" + field + ")", nil
+	}
+	return "", badRequest("unsupported filter operator %q", op)
+}
+
+func filterLiteral(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case json.Number:
+		return value.String(), nil
+	case string, bool:
+		return collection.FormatFilterValue(value), nil
+	}
+	return "", badRequest("filter values must be numbers, strings or booleans")
+}
+
+func formatVector(vector []float64) []string {
+	components := make([]string, len(vector))
+	for i, x := range vector {
+		components[i] = strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return components
+}
+
+// replyStrings returns the strings of an array reply.
+func replyStrings(reply interface{}) []string {
+	items, _ := reply.([]interface{})
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
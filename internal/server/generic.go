@@ -0,0 +1,271 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// exists implements EXISTS key [key ...], counting the keys that exist. A
+// key given several times is counted as many times.
+func (s *Server) exists(args []string) string {
+	n := 0
+	for _, key := range args {
+		_, exists, err := s.lookup([]byte(key))
+		if err != nil {
+			return resp.Error("ERR Failed to get key: " + err.Error())
+		}
+		if exists {
+			n++
+		}
+	}
+	return resp.Integer(int64(n))
+}
+
+// typeCommand implements TYPE key, replying with the name of the type of
+// the value stored at key, or none.
+func (s *Server) typeCommand(args []string) string {
+	meta, exists, err := s.lookup([]byte(args[0]))
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if !exists {
+		return resp.SimpleString("none")
+	}
+	return resp.SimpleString(meta.Type.String())
+}
+
+// touch implements TOUCH key [key ...], updating the last access time of
+// the keys that exist and counting them. Replicas only count them, since
+// they write nothing of their own.
+func (s *Server) touch(args []string) string {
+	defer s.keyLocks.lockAll(args)()
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	replica := s.isReplica()
+	touched := 0
+	for _, key := range args {
+		meta, exists, err := s.lookup([]byte(key))
+		if err != nil {
+			return resp.Error("ERR Failed to get key: " + err.Error())
+		}
+		if !exists {
+			continue
+		}
+		if !replica {
+			meta.LastAccess = time.Now()
+			storage.SetMeta(batch, []byte(key), meta)
+		}
+		touched++
+	}
+	if touched > 0 && !replica {
+		if err := batch.Commit(pebble.NoSync); err != nil {
+			return resp.Error("ERR Failed to touch key: " + err.Error())
+		}
+	}
+	return resp.Integer(int64(touched))
+}
+
+// rename implements RENAME key newkey and RENAMENX key newkey. The value,
+// and the elements of a hash or list, move to newkey with its type and expiry in one
+// batch, replacing what newkey held, unless RENAMENX finds it exists and
+// replies 0.
+func (s *Server) rename(cmd string, args []string) string {
+	src, dst := []byte(args[0]), []byte(args[1])
+	defer s.keyLocks.lockPair(args[0], args[1])()
+	meta, value, exists, err := s.loadValue(src)
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if !exists {
+		return resp.Error("ERR no such key")
+	}
+	renamed := resp.OK
+	if cmd == "renamenx" {
+		renamed = resp.Integer(1)
+	}
+	if args[0] == args[1] {
+		// The key exists already under its new name.
+		if cmd == "renamenx" {
+			return resp.Integer(0)
+		}
+		return resp.OK
+	}
+	dstMeta, dstExists, err := s.lookup(dst)
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if dstExists && cmd == "renamenx" {
+		return resp.Integer(0)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	storage.DeleteValue(batch, src, meta)
+	if !meta.ExpireAt.IsZero() {
+		batch.Delete(storage.ExpiryKey(meta.ExpireAt, src), nil)
+	}
+	writeValue(batch, dst, value, meta, dstMeta, dstExists)
+	if err := s.copyElements(batch, src, dst, meta.Type); err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if err := s.committer.Commit(batch, s.writeMode(cmd)); err != nil {
+		return resp.Error("ERR Failed to rename key: " + err.Error())
+	}
+	return renamed
+}
+
+// copyCommand implements COPY source destination [DB 0] [REPLACE], copying
+// the value, and the elements of a hash or list, with its type and expiry and
+// replying 1, or 0 if source does not exist or destination does and
+// REPLACE is not given. There is only database 0.
+func (s *Server) copyCommand(args []string) string {
+	replace := false
+	for opts := args[2:]; len(opts) > 0; opts = opts[1:] {
+		switch strings.ToLower(opts[0]) {
+		case "replace":
+			replace = true
+		case "db":
+			if len(opts) < 2 {
+				return resp.Error("ERR syntax error")
+			}
+			if opts[1] != "0" {
+				return resp.Error("ERR DB index is out of range")
+			}
+			opts = opts[1:]
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+	if args[0] == args[1] {
+		return resp.Error("ERR source and destination objects are the same")
+	}
+	src, dst := []byte(args[0]), []byte(args[1])
+	defer s.keyLocks.lockPair(args[0], args[1])()
+	meta, value, exists, err := s.loadValue(src)
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if !exists {
+		return resp.Integer(0)
+	}
+	dstMeta, dstExists, err := s.lookup(dst)
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if dstExists && !replace {
+		return resp.Integer(0)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	meta.LastAccess = time.Now()
+	writeValue(batch, dst, value, meta, dstMeta, dstExists)
+	if err := s.copyElements(batch, src, dst, meta.Type); err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	if err := s.committer.Commit(batch, s.writeMode("copy")); err != nil {
+		return resp.Error("ERR Failed to copy key: " + err.Error())
+	}
+	return resp.Integer(1)
+}
+
+// loadValue returns the metadata and the value of key, of any type, and
+// whether it exists.
+func (s *Server) loadValue(key []byte) (storage.Meta, []byte, bool, error) {
+	meta, exists, err := s.lookup(key)
+	if err != nil || !exists {
+		return meta, nil, false, err
+	}
+	value, closer, err := s.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return meta, nil, false, nil
+	}
+	if err != nil {
+		return meta, nil, false, err
+	}
+	defer closer.Close()
+	return meta, append([]byte{}, value...), true, nil
+}
+
+// writeValue adds to batch the writes storing value with meta at key,
+// which held a value with old metadata if it existed. The elements of the
+// old value are deleted, so those of a value moved or copied there must be
+// written after.
+func writeValue(batch *pebble.Batch, key, value []byte, meta, old storage.Meta, existed bool) {
+	if existed && !old.ExpireAt.IsZero() && !old.ExpireAt.Equal(meta.ExpireAt) {
+		batch.Delete(storage.ExpiryKey(old.ExpireAt, key), nil)
+	}
+	if existed {
+		storage.DeleteElements(batch, key, old)
+	}
+	batch.Set(key, value, nil)
+	storage.SetMeta(batch, key, meta)
+}
+
+// copyElements adds to batch the writes copying the elements of the value
+// of type t at src, if the type has any, to dst.
+func (s *Server) copyElements(batch *pebble.Batch, src, dst []byte, t storage.ObjectType) error {
+	start, end, ok := storage.ElementsSpan(src, t)
+	if !ok {
+		return nil
+	}
+	prefix := storage.ElementsPrefix(dst, t)
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: start, UpperBound: end})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		s.io.backgroundRead(len(iter.Key()) + len(iter.Value()))
+		batch.Set(append(prefix[:len(prefix):len(prefix)], iter.Key()[len(start):]...), iter.Value(), nil)
+	}
+	return iter.Error()
+}
+
+// lookupType returns the metadata of the value of type t at key and
+// whether it exists. Keys holding another type fail with errWrongType.
+func (s *Server) lookupType(key []byte, t storage.ObjectType) (storage.Meta, bool, error) {
+	meta, exists, err := s.lookup(key)
+	if err != nil || !exists {
+		return meta, false, err
+	}
+	if meta.Type != t {
+		return meta, false, errWrongType
+	}
+	return meta, true, nil
+}
+
+// typeError returns the error reply for err, returned by a command working
+// on a single type.
+func typeError(err error) string {
+	if err == errWrongType {
+		return resp.Error(err.Error())
+	}
+	return resp.Error("ERR Failed to access key: " + err.Error())
+}
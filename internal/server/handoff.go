@@ -0,0 +1,129 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Handoff lets a new server process take over from a running one, for
+// binary upgrades without refusing connections:
+//
+//  1. The old process starts the new one, passing its listening sockets as
+//     inherited file descriptors named in HandoffEnv.
+//  2. The old process stops accepting; clients that connect meanwhile wait
+//     in the kernel's accept queue of the shared sockets. It lets in-flight
+//     commands finish, closes client connections between commands so that
+//     clients reconnect, and releases the Pebble directory on exit.
+//  3. The new process retries opening the Pebble directory until the old
+//     one has released it, then serves the inherited sockets.
+const HandoffEnv = "VECBLE_LISTEN_FDS"
+
+// handoffFirstFD is the descriptor of the first inherited listener: 0-2
+// are stdin, stdout and stderr.
+const handoffFirstFD = 3
+
+// InheritedListeners returns the listeners passed by a process handing off
+// to this one, by listener name, or nil when the process was started
+// normally.
+func InheritedListeners() (map[string]net.Listener, error) {
+	names := os.Getenv(HandoffEnv)
+	if names == "" {
+		return nil, nil
+	}
+	os.Unsetenv(HandoffEnv)
+	listeners := make(map[string]net.Listener)
+	for i, name := range strings.Split(names, ",") {
+		file := os.NewFile(uintptr(handoffFirstFD+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %s: %w", name, err)
+		}
+		listeners[name] = listener
+	}
+	return listeners, nil
+}
+
+// Handoff starts the current executable with the same arguments, passing it
+// the listening sockets, then drains this server. ListenAndServe returns
+// once every connection has closed, after which the caller must close the
+// Pebble DB so that the new process can open it.
+func (s *Server) Handoff() (int, error) {
+	if s.shuttingDown() {
+		return 0, errors.New("server is shutting down")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	defer func() {
+		for _, file := range files[handoffFirstFD:] {
+			file.Close()
+		}
+	}()
+	for _, l := range s.listeners {
+		file, err := l.file()
+		if err != nil {
+			return 0, fmt.Errorf("listener %s: %w", l.name, err)
+		}
+		names = append(names, l.name)
+		files = append(files, file)
+	}
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Env:   append(os.Environ(), HandoffEnv+"="+strings.Join(names, ",")),
+		Files: files,
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Handing off to process %d", process.Pid)
+	process.Release()
+
+	for _, l := range s.listeners {
+		l.keepOnClose()
+	}
+	s.stop()
+	s.draining.Store(true)
+	s.clients.drain()
+	return process.Pid, nil
+}
+
+// drain makes every connection stop after the command it is executing, by
+// expiring reads from the client.
+func (r *clientRegistry) drain() {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, c := range r.clients {
+		c.conn.SetReadDeadline(time.Now())
+	}
+}
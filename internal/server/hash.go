@@ -0,0 +1,409 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/glob"
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// hset implements HSET key field value [field value ...], creating the
+// hash if needed and replying with the number of fields added rather than
+// updated. Only the fields given are written, however large the hash.
+func (s *Server) hset(args []string) string {
+	if len(args)%2 != 1 {
+		return resp.Error("ERR wrong number of arguments for 'hset' command")
+	}
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, exists, err := s.lookupHash(key)
+	if err != nil {
+		return typeError(err)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	touchHash(batch, key, meta, exists)
+	added := make(map[string]bool)
+	for i := 1; i < len(args); i += 2 {
+		field := args[i]
+		if exists && !added[field] {
+			_, found, err := s.hashField(key, field)
+			if err != nil {
+				return typeError(err)
+			}
+			if found {
+				continue
+			}
+		}
+		added[field] = true
+	}
+	for i := 1; i < len(args); i += 2 {
+		batch.Set(storage.HashFieldKey(key, []byte(args[i])), []byte(args[i+1]), nil)
+	}
+	if err := s.committer.Commit(batch, s.writeMode("hset")); err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(int64(len(added)))
+}
+
+// hget implements HGET key field, replying with the value of the field or
+// nil.
+func (s *Server) hget(args []string) string {
+	key := []byte(args[0])
+	_, exists, err := s.lookupHash(key)
+	if err != nil || !exists {
+		if err != nil {
+			return typeError(err)
+		}
+		return resp.Nil
+	}
+	value, found, err := s.hashField(key, args[1])
+	if err != nil {
+		return typeError(err)
+	}
+	if !found {
+		return resp.Nil
+	}
+	return resp.BulkString(string(value))
+}
+
+// hmget implements HMGET key field [field ...], replying with the value of
+// each field, nil for the ones the hash does not have.
+func (s *Server) hmget(args []string) string {
+	key := []byte(args[0])
+	_, exists, err := s.lookupHash(key)
+	if err != nil {
+		return typeError(err)
+	}
+	var w resp.Writer
+	w.Array(len(args) - 1)
+	for _, field := range args[1:] {
+		if !exists {
+			w.Nil()
+			continue
+		}
+		value, found, err := s.hashField(key, field)
+		switch {
+		case err != nil:
+			return typeError(err)
+		case !found:
+			w.Nil()
+		default:
+			w.BulkString(string(value))
+		}
+	}
+	return w.String()
+}
+
+// hgetall implements HGETALL key, replying with every field and its value
+// in field order, as a map with RESP3. The reply is streamed from a
+// snapshot, which is read twice, since the number of fields is not kept:
+// once to count them and once to send them.
+func (s *Server) hgetall(c *connection, args []string) string {
+	key := []byte(args[0])
+	_, exists, err := s.lookupHash(key)
+	if err != nil {
+		return typeError(err)
+	}
+	if !exists {
+		var w resp.Writer
+		if c.protocol >= 3 {
+			w.Map(0)
+		} else {
+			w.Array(0)
+		}
+		return w.String()
+	}
+	snapshot := s.db.NewSnapshot()
+	return c.streamReply(func(w *resp.Writer, flush func() error) error {
+		defer snapshot.Close()
+		defer s.io.foregroundRead()()
+		n := 0
+		if _, err := s.scanHash(snapshot, key, nil, "", -1, func(_, _ []byte) { n++ }); err != nil {
+			return err
+		}
+		if c.protocol >= 3 {
+			w.Map(n)
+		} else {
+			w.Array(2 * n)
+		}
+		var err error
+		_, scanErr := s.scanHash(snapshot, key, nil, "", -1, func(field, value []byte) {
+			if err == nil {
+				w.BulkString(string(field))
+				w.BulkString(string(value))
+				err = flush()
+			}
+		})
+		return cmp.Or(err, scanErr)
+	})
+}
+
+// hdel implements HDEL key field [field ...], replying with the number of
+// fields removed. The key is deleted with its last field.
+func (s *Server) hdel(args []string) string {
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, exists, err := s.lookupHash(key)
+	if err != nil || !exists {
+		if err != nil {
+			return typeError(err)
+		}
+		return resp.Integer(0)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	removed := make(map[string]bool)
+	for _, field := range args[1:] {
+		if removed[field] {
+			continue
+		}
+		_, found, err := s.hashField(key, field)
+		if err != nil {
+			return typeError(err)
+		}
+		if found {
+			removed[field] = true
+			batch.Delete(storage.HashFieldKey(key, []byte(field)), nil)
+		}
+	}
+	if len(removed) == 0 {
+		return resp.Integer(0)
+	}
+	empty, err := s.hashEmptyWithout(key, removed)
+	if err != nil {
+		return typeError(err)
+	}
+	if empty {
+		storage.DeleteValue(batch, key, meta)
+	} else {
+		touchHash(batch, key, meta, true)
+	}
+	if err := s.committer.Commit(batch, s.writeMode("hdel")); err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(int64(len(removed)))
+}
+
+// hincrby implements HINCRBY key field increment, replying with the new
+// value of the field. A field, or hash, that does not exist counts from 0.
+func (s *Server) hincrby(args []string) string {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return resp.Error("ERR value is not an integer or out of range")
+	}
+	key, field := []byte(args[0]), args[1]
+	defer s.keyLocks.lock(args[0])()
+	meta, exists, err := s.lookupHash(key)
+	if err != nil {
+		return typeError(err)
+	}
+	var n int64
+	if exists {
+		value, found, err := s.hashField(key, field)
+		if err != nil {
+			return typeError(err)
+		}
+		if found {
+			if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return resp.Error("ERR hash value is not an integer")
+			}
+		}
+	}
+	if delta > 0 && n > math.MaxInt64-delta || delta < 0 && n < math.MinInt64-delta {
+		return resp.Error("ERR increment or decrement would overflow")
+	}
+	n += delta
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	touchHash(batch, key, meta, exists)
+	batch.Set(storage.HashFieldKey(key, []byte(field)), strconv.AppendInt(nil, n, 10), nil)
+	if err := s.committer.Commit(batch, s.writeMode("hincrby")); err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(n)
+}
+
+// hscan implements HSCAN key cursor [MATCH pattern] [COUNT count]
+// [NOVALUES], visiting up to count fields from where cursor left off and
+// replying like SCAN, with each matching field followed by its value
+// unless NOVALUES is given. Cursors are handed out by the same table as
+// SCAN's.
+func (s *Server) hscan(args []string) string {
+	key := []byte(args[0])
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return resp.Error("ERR invalid cursor")
+	}
+	pattern := ""
+	count := scanDefaultCount
+	values := true
+	for opts := args[2:]; len(opts) > 0; {
+		option := strings.ToLower(opts[0])
+		if option == "novalues" {
+			values = false
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 {
+			return resp.Error("ERR syntax error")
+		}
+		switch option {
+		case "match":
+			pattern = opts[1]
+		case "count":
+			count, err = strconv.Atoi(opts[1])
+			if err != nil {
+				return resp.Error("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return resp.Error("ERR syntax error")
+			}
+		default:
+			return resp.Error("ERR syntax error")
+		}
+		opts = opts[2:]
+	}
+	var start []byte
+	if cursor != 0 {
+		var ok bool
+		start, ok = s.scanCursors.resume(cursor)
+		if !ok || !bytes.HasPrefix(start, storage.HashFieldsPrefix(key)) {
+			return resp.Error("ERR invalid cursor")
+		}
+	}
+	_, exists, err := s.lookupHash(key)
+	if err != nil {
+		return typeError(err)
+	}
+	items := []string{}
+	nextCursor := uint64(0)
+	if exists {
+		defer s.io.foregroundRead()()
+		next, err := s.scanHash(s.db, key, start, pattern, count, func(field, value []byte) {
+			items = append(items, string(field))
+			if values {
+				items = append(items, string(value))
+			}
+		})
+		if err != nil {
+			return typeError(err)
+		}
+		if next != nil {
+			nextCursor = s.scanCursors.add(next)
+		}
+	}
+	return resp.Array(resp.BulkString(strconv.FormatUint(nextCursor, 10)), resp.StringArray(items))
+}
+
+// scanHash calls fn with the fields of the hash at key in r matching pattern
+// and their values, in field order from the field key start on, or from
+// the first field if start is nil. It visits up to count fields, or all of
+// them if count is negative, and returns the field key to resume from, nil
+// once there are no more.
+func (s *Server) scanHash(r pebble.Reader, key, start []byte, pattern string, count int, fn func(field, value []byte)) ([]byte, error) {
+	lower, upper := storage.HashFieldsSpan(key)
+	prefixLen := len(lower)
+	if start != nil {
+		lower = start
+	}
+	iter, err := r.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	visited := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		if visited == count {
+			return append([]byte{}, iter.Key()...), nil
+		}
+		visited++
+		field := iter.Key()[prefixLen:]
+		if pattern != "" && !glob.Match(pattern, string(field)) {
+			continue
+		}
+		fn(field, iter.Value())
+	}
+	return nil, iter.Error()
+}
+
+// hashEmptyWithout reports whether the hash at key has no fields besides
+// the ones in fields.
+func (s *Server) hashEmptyWithout(key []byte, fields map[string]bool) (bool, error) {
+	start, end := storage.HashFieldsSpan(key)
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: start, UpperBound: end})
+	if err != nil {
+		return false, err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		if !fields[string(iter.Key()[len(start):])] {
+			return false, nil
+		}
+	}
+	return true, iter.Error()
+}
+
+// hashField returns the value of a field of the hash at key and whether
+// the hash has it.
+func (s *Server) hashField(key []byte, field string) ([]byte, bool, error) {
+	value, err := s.io.get(s.db, storage.HashFieldKey(key, []byte(field)))
+	if err == pebble.ErrNotFound {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+// lookupHash returns the metadata of the hash at key and whether it
+// exists. Keys holding another type fail with errWrongType.
+func (s *Server) lookupHash(key []byte) (storage.Meta, bool, error) {
+	return s.lookupType(key, storage.ObjectTypeHash)
+}
+
+// touchHash adds to batch the writes making key, whose metadata is meta if
+// it exists, a hash last written now. A new hash starts by deleting any
+// fields left over from a hash the key held before and lost to a command
+// overwriting whatever the key holds, such as SET, which does not look for
+// fields to delete.
+func touchHash(batch *pebble.Batch, key []byte, meta storage.Meta, exists bool) {
+	if exists {
+		meta.LastAccess = time.Now()
+	} else {
+		start, end := storage.HashFieldsSpan(key)
+		batch.DeleteRange(start, end, nil)
+		batch.Set(key, nil, nil)
+		meta = storage.NewMeta(storage.ObjectTypeHash)
+	}
+	storage.SetMeta(batch, key, meta)
+}
@@ -0,0 +1,96 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"readpebble/internal/resp"
+)
+
+// timeCommand implements TIME, replying with the server's clock as unix
+// seconds and the microseconds within the second.
+func (s *Server) timeCommand() string {
+	now := time.Now()
+	return resp.StringArray([]string{
+		strconv.FormatInt(now.Unix(), 10),
+		strconv.Itoa(now.Nanosecond() / 1000),
+	})
+}
+
+// lastSave implements LASTSAVE, replying with the unix time the data was
+// last saved: by a collection checkpoint or a BACKUP, or at startup.
+// Writes are durable in Pebble as they reply, so this is mostly of use to
+// tell when a BACKUP completed.
+func (s *Server) lastSave() string {
+	return resp.Integer(s.lastSaveTime.Load())
+}
+
+// saved records that the data was just saved, for LASTSAVE.
+func (s *Server) saved() {
+	s.lastSaveTime.Store(time.Now().Unix())
+}
+
+// role implements ROLE as Redis replies to it. On a master that is
+// "master", the replication offset, and the address and acknowledged
+// offset of each replica; on a replica it is "slave", the master's host and
+// port, the state of the link (connect, sync or connected) and the offset
+// applied.
+func (s *Server) role() string {
+	var w resp.Writer
+	if st, ok := s.replicationStatus(); ok {
+		host, port, err := net.SplitHostPort(st.master)
+		if err != nil {
+			host, port = st.master, ""
+		}
+		port64, _ := strconv.ParseInt(port, 10, 64)
+		w.Array(5)
+		w.BulkString("slave")
+		w.BulkString(host)
+		w.Integer(port64)
+		w.BulkString(st.state)
+		w.Integer(st.applied)
+		return w.String()
+	}
+	bl := s.backlog
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+	w.Array(3)
+	w.BulkString("master")
+	w.Integer(bl.offset)
+	w.Array(len(bl.followers))
+	for f := range bl.followers {
+		host, port, err := net.SplitHostPort(f.addr)
+		if err != nil {
+			host, port = f.addr, ""
+		}
+		w.Array(3)
+		w.BulkString(host)
+		w.BulkString(port)
+		w.BulkString(strconv.FormatInt(f.ack, 10))
+	}
+	return w.String()
+}
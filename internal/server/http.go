@@ -0,0 +1,295 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/acl"
+	"readpebble/internal/collection"
+	"readpebble/internal/storage"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+const maxBrowseKeys = 500
+
+func (s *Server) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/api/stats", s.dashboard(s.handleStats, "info"))
+	mux.HandleFunc("/api/clients", s.dashboard(s.handleClients, "client", "list"))
+	mux.HandleFunc("/api/slowlog", s.dashboard(s.handleSlowlog, "slowlog", "get"))
+	mux.HandleFunc("/api/collections", s.dashboard(s.handleCollections, "vlist"))
+	mux.HandleFunc("/api/keys", s.dashboard(s.handleKeys, "scan"))
+	mux.HandleFunc("/api/key", s.dashboard(s.handleKey, "get"))
+	s.registerGateway(mux)
+	if s.config.Pprof {
+		s.registerPprof(mux)
+	}
+	if s.config.Dashboard {
+		mux.HandleFunc("/", s.handleDashboard)
+	}
+	return mux
+}
+
+func (s *Server) serveHTTP() {
+	httpServer := &http.Server{
+		Addr:              s.config.HTTPAddr,
+		Handler:           s.httpHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-s.quitCh
+		httpServer.Close()
+	}()
+	log.Printf("HTTP server running on %s", s.config.HTTPAddr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("HTTP server failed: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Failed to encode HTTP response: %v", err)
+	}
+}
+
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.stats.registry.WritePrometheus(w)
+}
+
+// dashboard adapts a handler of the dashboard API, which answers only
+// requests authenticated as for the gateway, from users that may run cmd
+// with args, the command showing the same data.
+func (s *Server) dashboard(h func(*connection, *http.Request) (interface{}, error), cmd string, args ...string) http.HandlerFunc {
+	return s.gateway(func(c *connection, r *http.Request) (interface{}, error) {
+		if err := s.checkDashboard(c, cmd, args...); err != nil {
+			return nil, err
+		}
+		return h(c, r)
+	})
+}
+
+// checkDashboard returns the error a request on c is refused with if c may
+// not run cmd with args.
+func (s *Server) checkDashboard(c *connection, cmd string, args ...string) error {
+	spec := commandTable[cmd]
+	reply := s.checkAuth(c, spec)
+	if reply == "" {
+		reply = s.checkACL(c, spec, args)
+	}
+	if reply != "" {
+		return replyError(strings.TrimSuffix(reply[1:], "\r\n"))
+	}
+	return nil
+}
+
+func (s *Server) handleStats(c *connection, r *http.Request) (interface{}, error) {
+	return s.stats.registry.Snapshot(), nil
+}
+
+func (s *Server) handleClients(c *connection, r *http.Request) (interface{}, error) {
+	return s.clientList(), nil
+}
+
+type slowlogView struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Duration int64     `json:"duration_us"`
+	Command  []string  `json:"command"`
+	Addr     string    `json:"addr"`
+	Name     string    `json:"name,omitempty"`
+}
+
+// handleSlowlog lists the slow log, newest first, as SLOWLOG GET -1 does.
+func (s *Server) handleSlowlog(c *connection, r *http.Request) (interface{}, error) {
+	maxLen := s.live.Load().SlowlogMaxLen
+	s.slowlog.mutex.Lock()
+	s.slowlog.resize(maxLen)
+	entries := s.slowlog.newest(-1)
+	s.slowlog.mutex.Unlock()
+	views := make([]slowlogView, len(entries))
+	for i, e := range entries {
+		views[i] = slowlogView{e.id, e.time, e.duration.Microseconds(), e.args, e.addr, e.name}
+	}
+	return views, nil
+}
+
+type collectionStatus struct {
+	gatewayCollection
+	Points int   `json:"points"`
+	Bytes  int64 `json:"bytes"`
+	// IndexBytes is the memory of the HNSW graph, 0 for flat indexes and
+	// while the graph is demoted.
+	IndexBytes int64 `json:"index_bytes"`
+	Demoted    bool  `json:"demoted,omitempty"`
+}
+
+// handleCollections lists the collections with their size and the state
+// of their index.
+func (s *Server) handleCollections(c *connection, r *http.Request) (interface{}, error) {
+	usages := make(map[string]collection.IndexUsage)
+	for _, u := range s.collections.IndexUsage() {
+		usages[u.Name] = u
+	}
+	views := []collectionStatus{}
+	for _, info := range s.collections.List() {
+		coll, err := s.collections.Get(info.Name)
+		if err != nil {
+			continue
+		}
+		u := usages[info.Name]
+		views = append(views, collectionStatus{
+			gatewayCollection: collectionView(info),
+			Points:            coll.Len(),
+			Bytes:             coll.LogicalBytes(),
+			IndexBytes:        u.Bytes,
+			Demoted:           u.Demoted,
+		})
+	}
+	return views, nil
+}
+
+// handleKeys lists the live keys the user may read, in order, starting at
+// the optional prefix. The reserved keyspace is not listed.
+func (s *Server) handleKeys(c *connection, r *http.Request) (interface{}, error) {
+	prefix := []byte(r.URL.Query().Get("prefix"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxBrowseKeys {
+		limit = 100
+	}
+	keys := []string{}
+	if bytes.HasPrefix(prefix, []byte(reservedPrefix)) {
+		return keys, nil
+	}
+	var user *acl.User
+	if s.config.ACL != nil {
+		user = s.config.ACL.User(c.username())
+	}
+
+	iterOptions := &pebble.IterOptions{LowerBound: []byte{1}}
+	if len(prefix) > 0 {
+		iterOptions.LowerBound = prefix
+		iterOptions.UpperBound = prefixUpperBound(prefix)
+	}
+	iter, err := s.db.NewIter(iterOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	now := time.Now()
+	for iter.First(); iter.Valid() && len(keys) < limit; iter.Next() {
+		s.io.backgroundRead(len(iter.Key()))
+		if user != nil && !user.CanAccess(string(iter.Key())) {
+			continue
+		}
+		meta, exists, err := storage.LoadMeta(s.db, iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if exists && !meta.Expired(now) {
+			keys = append(keys, string(iter.Key()))
+		}
+	}
+	return keys, iter.Error()
+}
+
+type keyView struct {
+	Key    string    `json:"key"`
+	Size   int       `json:"size"`
+	Value  string    `json:"value,omitempty"`
+	Vector []float64 `json:"vector,omitempty"`
+}
+
+// handleKey shows a single live key the user may read, decoded as a vector
+// when it looks like one written by storage.Insert.
+func (s *Server) handleKey(c *connection, r *http.Request) (interface{}, error) {
+	key := []byte(r.URL.Query().Get("key"))
+	if err := s.checkDashboard(c, "get", string(key)); err != nil {
+		return nil, err
+	}
+	notFound := &gatewayError{http.StatusNotFound, "no such key"}
+	if bytes.HasPrefix(key, []byte(reservedPrefix)) {
+		return nil, notFound
+	}
+	meta, exists, err := storage.LoadMeta(s.db, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists || meta.Expired(time.Now()) {
+		return nil, notFound
+	}
+	value, closer, err := s.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	view := keyView{Key: string(key), Size: len(value)}
+	if utf8.Valid(value) {
+		view.Value = string(value)
+	}
+	closer.Close()
+	if view.Value == "" && len(value)%8 == 0 {
+		view.Vector, _ = s.storage.Get(key)
+	}
+	return view, nil
+}
+
+// prefixUpperBound returns the smallest key greater than every key starting
+// with prefix, or nil if there is none.
+func prefixUpperBound(prefix []byte) []byte {
+	upper := append([]byte{}, prefix...)
+	for i := len(upper) - 1; i >= 0; i-- {
+		upper[i]++
+		if upper[i] != 0 {
+			return upper[:i+1]
+		}
+	}
+	return nil
+}
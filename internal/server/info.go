@@ -0,0 +1,318 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// infoSections are the INFO sections in the order they are printed.
+var infoSections = []struct {
+	name   string
+	render func(s *Server, b *strings.Builder)
+	// named sections are only printed when asked for by name or with ALL
+	// or EVERYTHING: keyspace scans every key, as DBSIZE does, and
+	// commandstats is long.
+	named bool
+}{
+	{"server", (*Server).infoServer, false},
+	{"clients", (*Server).infoClients, false},
+	{"memory", (*Server).infoMemory, false},
+	{"persistence", (*Server).infoPersistence, false},
+	{"stats", (*Server).infoStats, false},
+	{"replication", (*Server).infoReplication, false},
+	{"commandstats", (*Server).infoCommandStats, true},
+	{"keyspace", (*Server).infoKeyspace, true},
+	{"storage", (*Server).infoStorage, false},
+	{"disk", (*Server).infoDisk, false},
+	{"shadow", (*Server).infoShadow, false},
+	{"capture", (*Server).infoCapture, false},
+	{"migration", (*Server).infoMigration, false},
+	{"indexes", (*Server).infoIndexes, false},
+	{"indexing", (*Server).infoIndexing, false},
+	{"jobs", (*Server).infoJobs, false},
+}
+
+// info implements INFO [section ...]. With no section, or DEFAULT, it
+// returns every section not marked named.
+func (s *Server) info(args []string) string {
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		wanted[strings.ToLower(arg)] = true
+	}
+	all := wanted["all"] || wanted["everything"]
+	defaults := len(wanted) == 0 || wanted["default"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] && (section.named || !defaults) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(section.name[:1])+section.name[1:])
+		section.render(s, &b)
+	}
+	return resp.BulkString(b.String())
+}
+
+// infoServer describes the process.
+func (s *Server) infoServer(b *strings.Builder) {
+	mode := "standalone"
+	if s.config.ClusterEnabled {
+		mode = "cluster"
+	}
+	_, port, _ := net.SplitHostPort(s.config.Addr)
+	uptime := time.Since(s.stats.startTime)
+	fmt.Fprintf(b, "server_name:vecble\r\n")
+	fmt.Fprintf(b, "server_mode:%s\r\n", mode)
+	fmt.Fprintf(b, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(b, "os:%s\r\n", runtime.GOOS)
+	fmt.Fprintf(b, "arch:%s\r\n", runtime.GOARCH)
+	fmt.Fprintf(b, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(b, "tcp_port:%s\r\n", port)
+	fmt.Fprintf(b, "server_time_usec:%d\r\n", time.Now().UnixMicro())
+	fmt.Fprintf(b, "uptime_in_seconds:%d\r\n", int64(uptime.Seconds()))
+	fmt.Fprintf(b, "uptime_in_days:%d\r\n", int64(uptime.Hours()/24))
+	fmt.Fprintf(b, "command_workers:%d\r\n", s.config.CommandWorkers)
+	fmt.Fprintf(b, "gomaxprocs:%d\r\n", runtime.GOMAXPROCS(0))
+}
+
+// infoClients reports the open connections, from the connection registry.
+func (s *Server) infoClients(b *strings.Builder) {
+	var clients, pubsub, named, maxQuery, maxOutput int
+	for _, info := range s.clientList() {
+		if info.Replica {
+			continue
+		}
+		clients++
+		if info.Channels+info.Patterns > 0 {
+			pubsub++
+		}
+		if info.Name != "" {
+			named++
+		}
+		maxQuery = max(maxQuery, info.QueryBuffer)
+		maxOutput = max(maxOutput, info.OutputBuffer)
+	}
+	fmt.Fprintf(b, "connected_clients:%d\r\n", clients)
+	fmt.Fprintf(b, "pubsub_clients:%d\r\n", pubsub)
+	fmt.Fprintf(b, "named_clients:%d\r\n", named)
+	fmt.Fprintf(b, "client_max_query_buffer:%d\r\n", maxQuery)
+	fmt.Fprintf(b, "client_max_output_buffer:%d\r\n", maxOutput)
+	fmt.Fprintf(b, "commands_in_flight:%d\r\n", s.load.queueDepth.Load())
+	fmt.Fprintf(b, "command_workers_busy:%d\r\n", len(s.sched.slots))
+}
+
+// infoMemory reports the memory of the Go heap and of Pebble's memtables
+// and block cache; the HNSW graphs are under indexes.
+func (s *Server) infoMemory(b *strings.Builder) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	m := s.db.Metrics()
+	fmt.Fprintf(b, "used_memory:%d\r\n", ms.HeapAlloc)
+	fmt.Fprintf(b, "used_memory_human:%s\r\n", humanBytes(ms.HeapAlloc))
+	fmt.Fprintf(b, "used_memory_sys:%d\r\n", ms.Sys)
+	fmt.Fprintf(b, "used_memory_sys_human:%s\r\n", humanBytes(ms.Sys))
+	fmt.Fprintf(b, "maxmemory:%d\r\n", s.live.Load().MaxMemory)
+	fmt.Fprintf(b, "heap_objects:%d\r\n", ms.HeapObjects)
+	fmt.Fprintf(b, "gc_cycles:%d\r\n", ms.NumGC)
+	fmt.Fprintf(b, "gc_pause_total_ns:%d\r\n", ms.PauseTotalNs)
+	fmt.Fprintf(b, "memtable_bytes:%d\r\n", m.MemTable.Size)
+	fmt.Fprintf(b, "memtables:%d\r\n", m.MemTable.Count)
+	fmt.Fprintf(b, "block_cache_bytes:%d\r\n", m.BlockCache.Size)
+	fmt.Fprintf(b, "block_cache_hits:%d\r\n", m.BlockCache.Hits)
+	fmt.Fprintf(b, "block_cache_misses:%d\r\n", m.BlockCache.Misses)
+}
+
+// humanBytes formats n bytes as Redis does in INFO, e.g. 1.50M.
+func humanBytes(n uint64) string {
+	units := "BKMGTP"
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%c", f, units[i])
+}
+
+// infoPersistence reports how writes reach the disk: the durability they
+// are committed with, Pebble's flushes and compactions, and the index op
+// log replayed at startup.
+func (s *Server) infoPersistence(b *strings.Builder) {
+	m := s.db.Metrics()
+	loading := 0
+	if st, ok := s.replicationStatus(); ok && st.loading {
+		loading = 1
+	}
+	fmt.Fprintf(b, "loading:%d\r\n", loading)
+	fmt.Fprintf(b, "durability:%s\r\n", s.live.Load().Durability)
+	fmt.Fprintf(b, "wal_group_syncs:%d\r\n", s.committer.Syncs())
+	fmt.Fprintf(b, "wal_files:%d\r\n", m.WAL.Files)
+	fmt.Fprintf(b, "wal_bytes_written:%d\r\n", m.WAL.BytesWritten)
+	fmt.Fprintf(b, "flushes:%d\r\n", m.Flush.Count)
+	fmt.Fprintf(b, "compactions:%d\r\n", m.Compact.Count)
+	fmt.Fprintf(b, "compactions_in_progress:%d\r\n", m.Compact.NumInProgress)
+	fmt.Fprintf(b, "index_oplog_entries:%d\r\n", s.collections.PendingOps())
+	fmt.Fprintf(b, "index_checkpoint_interval_seconds:%d\r\n", int64(s.config.CheckpointInterval.Seconds()))
+}
+
+// infoStats reports counters since startup, from the server's metrics and
+// the per-command counters.
+func (s *Server) infoStats(b *strings.Builder) {
+	st := s.stats
+	var calls, failed int64
+	for _, cs := range st.commandTotals() {
+		calls += cs.calls
+		failed += cs.failed
+	}
+	ps := s.pubsub
+	ps.mutex.Lock()
+	channels, patterns := len(ps.channels), len(ps.patterns)
+	ps.mutex.Unlock()
+	fmt.Fprintf(b, "total_connections_received:%d\r\n", int64(st.connections.Value()))
+	fmt.Fprintf(b, "total_commands_processed:%d\r\n", calls)
+	fmt.Fprintf(b, "total_error_replies:%d\r\n", failed)
+	fmt.Fprintf(b, "expired_keys:%d\r\n", int64(st.expired.Value()))
+	fmt.Fprintf(b, "evicted_points:%d\r\n", s.collections.Evicted())
+	fmt.Fprintf(b, "pubsub_channels:%d\r\n", channels)
+	fmt.Fprintf(b, "pubsub_patterns:%d\r\n", patterns)
+	fmt.Fprintf(b, "sync_full:%d\r\n", int64(st.fullSyncs.Value()))
+	fmt.Fprintf(b, "sync_partial_ok:%d\r\n", int64(st.partialSyncs.Value()))
+	fmt.Fprintf(b, "pipeline_yields:%d\r\n", int64(st.yields.Value()))
+	fmt.Fprintf(b, "reply_flushes:%d\r\n", int64(st.flushes.Value()))
+	fmt.Fprintf(b, "hedged_reads:%d\r\n", s.io.hedged.Load())
+	fmt.Fprintf(b, "searches_degraded:%d\r\n", s.collections.Degraded())
+}
+
+// infoCommandStats reports the calls of each command called since
+// startup, in the cmdstat_<name> lines of Redis.
+func (s *Server) infoCommandStats(b *strings.Builder) {
+	totals := s.stats.commandTotals()
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cs := totals[name]
+		usec := cs.time.Microseconds()
+		fmt.Fprintf(b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d\r\n",
+			name, cs.calls, usec, float64(usec)/float64(cs.calls), cs.failed)
+	}
+}
+
+// infoKeyspace counts the live keys of the keyspace, those with an expiry
+// and their average time to live, as DBSIZE does by scanning them.
+func (s *Server) infoKeyspace(b *strings.Builder) {
+	defer s.io.foregroundRead()()
+	now := time.Now()
+	var keys, expires int64
+	var ttl time.Duration
+	_, err := s.scanKeys(s.db, now, nil, "", -1, func(_ []byte, meta storage.Meta) {
+		keys++
+		if !meta.ExpireAt.IsZero() {
+			expires++
+			ttl += meta.ExpireAt.Sub(now)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(b, "keyspace_error:%s\r\n", err)
+		return
+	}
+	if keys == 0 {
+		return
+	}
+	var avgTTL int64
+	if expires > 0 {
+		avgTTL = (ttl / time.Duration(expires)).Milliseconds()
+	}
+	fmt.Fprintf(b, "db0:keys=%d,expires=%d,avg_ttl=%d\r\n", keys, expires, avgTTL)
+}
+
+// infoStorage reports how much garbage Pebble holds, so operators can tell
+// when a compaction or a shorter TTL would reclaim space.
+func (s *Server) infoStorage(b *strings.Builder) {
+	m := s.db.Metrics()
+	fmt.Fprintf(b, "disk_usage_bytes:%d\r\n", m.DiskSpaceUsage())
+	fmt.Fprintf(b, "wal_bytes:%d\r\n", m.WAL.Size)
+	fmt.Fprintf(b, "wal_physical_bytes:%d\r\n", m.WAL.PhysicalSize)
+	fmt.Fprintf(b, "tombstones:%d\r\n", m.Keys.TombstoneCount)
+	fmt.Fprintf(b, "zombie_table_bytes:%d\r\n", m.Table.ZombieSize)
+	fmt.Fprintf(b, "compaction_debt_bytes:%d\r\n", m.Compact.EstimatedDebt)
+
+	usages, err := s.collections.SpaceUsage()
+	if err != nil {
+		fmt.Fprintf(b, "collections_error:%s\r\n", err)
+		return
+	}
+	for _, u := range usages {
+		fmt.Fprintf(b, "collection_%s:points=%d,logical_bytes=%d,disk_bytes=%d,space_amplification=%.2f\r\n",
+			u.Name, u.Points, u.LogicalBytes, u.DiskBytes, u.Amplification)
+	}
+}
+
+// infoIndexes reports the memory the HNSW graphs of the collections take
+// against IndexMemoryBudget, and which were demoted to keep within it.
+func (s *Server) infoIndexes(b *strings.Builder) {
+	usages := s.collections.IndexUsage()
+	var total int64
+	demoted := 0
+	for _, u := range usages {
+		total += u.Bytes
+		if u.Demoted {
+			demoted++
+		}
+	}
+	fmt.Fprintf(b, "index_memory_bytes:%d\r\n", total)
+	fmt.Fprintf(b, "index_memory_budget:%d\r\n", s.collections.IndexBudget())
+	fmt.Fprintf(b, "index_demoted_collections:%d\r\n", demoted)
+	fmt.Fprintf(b, "index_demotions:%d\r\n", s.collections.IndexDemotions())
+	fmt.Fprintf(b, "index_reloads:%d\r\n", s.collections.IndexReloads())
+	for _, u := range usages {
+		var searched int64
+		if !u.LastSearched.IsZero() {
+			searched = u.LastSearched.Unix()
+		}
+		demoted := 0
+		if u.Demoted {
+			demoted = 1
+		}
+		fmt.Fprintf(b, "index_%s:bytes=%d,demoted=%d,last_searched=%d\r\n", u.Name, u.Bytes, demoted, searched)
+	}
+}
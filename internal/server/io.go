@@ -0,0 +1,149 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+const (
+	// backgroundYield is how long a background read waits for client reads
+	// to drain before checking again, and backgroundMaxYield how long it
+	// waits in total so that maintenance cannot be starved forever.
+	backgroundYield    = 200 * time.Microsecond
+	backgroundMaxYield = 10 * time.Millisecond
+)
+
+// ioScheduler gives client reads (GET, VGET, VSEARCH) priority over
+// maintenance reads (MIGRATE and the admin key browser).
+// Maintenance reads back off while client reads are running and draw from
+// a separate bytes-per-second budget, so they cannot fill the disk queue
+// in front of queries.
+type ioScheduler struct {
+	rate       float64
+	hedgeAfter time.Duration
+
+	foreground atomic.Int64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+
+	throttled atomic.Int64
+	hedged    atomic.Int64
+}
+
+func newIOScheduler(config Config) *ioScheduler {
+	return &ioScheduler{
+		rate:       float64(config.BackgroundReadRate),
+		hedgeAfter: config.HedgeAfter,
+		tokens:     float64(config.BackgroundReadRate),
+		last:       time.Now(),
+	}
+}
+
+// foregroundRead marks a client read as running until the returned func is
+// called.
+func (ios *ioScheduler) foregroundRead() func() {
+	ios.foreground.Add(1)
+	return func() { ios.foreground.Add(-1) }
+}
+
+// backgroundRead is called by maintenance work before it reads n bytes. It
+// blocks while client reads are running and while the background read
+// budget is spent.
+func (ios *ioScheduler) backgroundRead(n int) {
+	waited := false
+	for yielded := time.Duration(0); ios.foreground.Load() > 0 && yielded < backgroundMaxYield; yielded += backgroundYield {
+		time.Sleep(backgroundYield)
+		waited = true
+	}
+	if ios.rate > 0 {
+		ios.mutex.Lock()
+		now := time.Now()
+		ios.tokens = min(ios.rate, ios.tokens+now.Sub(ios.last).Seconds()*ios.rate)
+		ios.last = now
+		ios.tokens -= float64(n)
+		var wait time.Duration
+		if ios.tokens < 0 {
+			wait = time.Duration(-ios.tokens / ios.rate * float64(time.Second))
+		}
+		ios.mutex.Unlock()
+		if wait > 0 {
+			time.Sleep(wait)
+			waited = true
+		}
+	}
+	if waited {
+		ios.throttled.Add(1)
+	}
+}
+
+type getResult struct {
+	value []byte
+	err   error
+}
+
+// get reads key for a client. When HedgeAfter is set and the read has not
+// finished by then, for instance because it is stuck behind a slow block
+// read, a second read is issued and whichever finishes first wins. The
+// returned value is a copy and err is pebble.ErrNotFound for missing keys.
+func (ios *ioScheduler) get(db *pebble.DB, key []byte) ([]byte, error) {
+	defer ios.foregroundRead()()
+	read := func(results chan<- getResult) {
+		value, closer, err := db.Get(key)
+		if err != nil {
+			results <- getResult{err: err}
+			return
+		}
+		value = append([]byte{}, value...)
+		closer.Close()
+		results <- getResult{value: value}
+	}
+	if ios.hedgeAfter <= 0 {
+		results := make(chan getResult, 1)
+		read(results)
+		r := <-results
+		return r.value, r.err
+	}
+
+	// Buffered for both reads so the loser never blocks.
+	results := make(chan getResult, 2)
+	go read(results)
+	timer := time.NewTimer(ios.hedgeAfter)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.value, r.err
+	case <-timer.C:
+	}
+	ios.hedged.Add(1)
+	go read(results)
+	r := <-results
+	return r.value, r.err
+}
@@ -0,0 +1,290 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"readpebble/internal/metrics"
+)
+
+const (
+	// jobRecheckInterval is how often a waiting background job checks
+	// whether the load pressure dropped enough for it to start.
+	jobRecheckInterval = 100 * time.Millisecond
+	// jobLowPriorityPressure is the load pressure, in percent, from which
+	// low priority jobs wait for the server to calm down.
+	jobLowPriorityPressure = 50
+)
+
+// errStopping is returned for background jobs the server stopped before
+// they could start.
+var errStopping = errors.New("server is shutting down")
+
+// jobResource is the resource a background job mostly uses, whose limit it
+// counts against.
+type jobResource int
+
+const (
+	jobCPU jobResource = iota
+	jobIO
+)
+
+func (r jobResource) String() string {
+	if r == jobCPU {
+		return "cpu"
+	}
+	return "io"
+}
+
+// jobPriority orders the background jobs waiting for the same resource.
+type jobPriority int
+
+const (
+	// priorityLow jobs also wait while the server is under load pressure.
+	priorityLow jobPriority = iota
+	priorityNormal
+	// priorityHigh jobs bound recovery time or memory use.
+	priorityHigh
+)
+
+// job is a kind of background work.
+type job struct {
+	name     string
+	resource jobResource
+	priority jobPriority
+}
+
+var (
+	jobCheckpoint    = job{"checkpoint", jobIO, priorityHigh}
+	jobIndexBudget   = job{"index-budget", jobCPU, priorityHigh}
+	jobIndexBuild    = job{"index-build", jobCPU, priorityNormal}
+	jobExpire        = job{"expire", jobIO, priorityNormal}
+	jobBackup        = job{"backup", jobIO, priorityNormal}
+	jobVacuum        = job{"vacuum", jobIO, priorityLow}
+	jobShadowCompare = job{"shadow-compare", jobIO, priorityLow}
+	jobMigration     = job{"migration", jobIO, priorityLow}
+	jobAnalyticScan  = job{"analytic-scan", jobCPU, priorityLow}
+)
+
+// jobs are the kinds of background work, in the order INFO reports them.
+var jobs = []job{
+	jobCheckpoint, jobIndexBudget, jobIndexBuild, jobExpire, jobBackup, jobVacuum, jobShadowCompare,
+	jobMigration, jobAnalyticScan,
+}
+
+// jobScheduler runs the maintenance work of the server, such as index
+// checkpoints and builds, expiry sweeps, backups and compactions of
+// deleted data, so that together it never takes more than a configured
+// share of the CPUs and the disk. Each resource has a limit on the jobs
+// using it at once, scaled down as the load pressure rises so that client
+// commands come first; waiting jobs start in order of priority, then of
+// arrival.
+type jobScheduler struct {
+	load   *loadMonitor
+	limits [2]int
+
+	mutex   sync.Mutex
+	running [2]int
+	// waiting are the jobs waiting to start, by priority then arrival.
+	waiting []*jobWaiter
+	// changed is closed and replaced whenever a job finishes or stops
+	// waiting, for the waiting ones to check whether they may start.
+	changed chan struct{}
+	stats   map[string]*jobStats
+}
+
+type jobWaiter struct {
+	job job
+}
+
+// jobStats are the totals of one kind of job.
+type jobStats struct {
+	runs     int64
+	failures int64
+	waited   time.Duration
+	ran      time.Duration
+}
+
+func newJobScheduler(config Config, load *loadMonitor, registry *metrics.Registry) *jobScheduler {
+	js := &jobScheduler{
+		load:    load,
+		limits:  [2]int{config.BackgroundCPUJobs, config.BackgroundIOJobs},
+		changed: make(chan struct{}),
+		stats:   make(map[string]*jobStats),
+	}
+	for _, r := range []jobResource{jobCPU, jobIO} {
+		registry.GaugeFunc("vecble_background_jobs_running", "Background jobs running, by the resource they use.", func() float64 {
+			js.mutex.Lock()
+			defer js.mutex.Unlock()
+			return float64(js.running[r])
+		}, "resource", r.String())
+	}
+	registry.GaugeFunc("vecble_background_jobs_waiting", "Background jobs waiting for their resource.", func() float64 {
+		js.mutex.Lock()
+		defer js.mutex.Unlock()
+		return float64(len(js.waiting))
+	})
+	for _, j := range jobs {
+		stats := &jobStats{}
+		js.stats[j.name] = stats
+		read := func(get func() float64) func() float64 {
+			return func() float64 {
+				js.mutex.Lock()
+				defer js.mutex.Unlock()
+				return get()
+			}
+		}
+		registry.CounterFunc("vecble_background_job_runs_total", "Background jobs run, by kind.",
+			read(func() float64 { return float64(stats.runs) }), "job", j.name)
+		registry.CounterFunc("vecble_background_job_failures_total", "Background jobs that failed, by kind.",
+			read(func() float64 { return float64(stats.failures) }), "job", j.name)
+		registry.CounterFunc("vecble_background_job_wait_seconds_total", "Seconds background jobs waited for their resource, by kind.",
+			read(func() float64 { return stats.waited.Seconds() }), "job", j.name)
+	}
+	return js
+}
+
+// run waits until j may start, then runs fn and returns its error. It
+// returns errStopping without running fn if quitCh is closed first.
+func (js *jobScheduler) run(j job, quitCh <-chan struct{}, fn func() error) error {
+	start := time.Now()
+	if !js.acquire(j, quitCh) {
+		return errStopping
+	}
+	started := time.Now()
+	err := fn()
+
+	js.mutex.Lock()
+	js.running[j.resource]--
+	stats := js.stats[j.name]
+	stats.runs++
+	if err != nil {
+		stats.failures++
+	}
+	stats.waited += started.Sub(start)
+	stats.ran += time.Since(started)
+	js.notify()
+	js.mutex.Unlock()
+	return err
+}
+
+// acquire waits until j may start and counts it as running, or reports
+// false if quitCh is closed first.
+func (js *jobScheduler) acquire(j job, quitCh <-chan struct{}) bool {
+	w := &jobWaiter{job: j}
+	js.mutex.Lock()
+	// Behind the jobs of the same or a higher priority.
+	i := slices.IndexFunc(js.waiting, func(other *jobWaiter) bool { return other.job.priority < j.priority })
+	if i < 0 {
+		i = len(js.waiting)
+	}
+	js.waiting = slices.Insert(js.waiting, i, w)
+
+	// The load pressure changes without any job finishing.
+	ticker := time.NewTicker(jobRecheckInterval)
+	defer ticker.Stop()
+	for !js.mayStart(w) {
+		changed := js.changed
+		js.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-quitCh:
+			js.mutex.Lock()
+			js.remove(w)
+			js.notify()
+			js.mutex.Unlock()
+			return false
+		}
+		js.mutex.Lock()
+	}
+	js.remove(w)
+	js.running[j.resource]++
+	js.mutex.Unlock()
+	return true
+}
+
+// mayStart reports whether w is the first job waiting for its resource and
+// the resource has room for it. js.mutex must be held.
+func (js *jobScheduler) mayStart(w *jobWaiter) bool {
+	for _, other := range js.waiting {
+		if other == w {
+			break
+		}
+		if other.job.resource == w.job.resource {
+			return false
+		}
+	}
+	return js.running[w.job.resource] < js.limit(w.job)
+}
+
+// limit returns how many jobs using the resource of j may run for j to
+// start, given the current load pressure.
+func (js *jobScheduler) limit(j job) int {
+	pressure := int(js.load.pressure())
+	if j.priority == priorityLow && pressure >= jobLowPriorityPressure {
+		return 0
+	}
+	return max(1, js.limits[j.resource]*(100-pressure)/100)
+}
+
+func (js *jobScheduler) remove(w *jobWaiter) {
+	js.waiting = slices.DeleteFunc(js.waiting, func(other *jobWaiter) bool { return other == w })
+}
+
+// notify wakes the waiting jobs. js.mutex must be held.
+func (js *jobScheduler) notify() {
+	close(js.changed)
+	js.changed = make(chan struct{})
+}
+
+// runJob runs fn as background job j once the scheduler lets it, or
+// returns errStopping if the server stops first.
+func (s *Server) runJob(j job, fn func() error) error {
+	return s.jobs.run(j, s.quitCh, fn)
+}
+
+// infoJobs reports the limits of the background jobs, how many are running
+// and waiting, and the totals of each kind.
+func (s *Server) infoJobs(b *strings.Builder) {
+	js := s.jobs
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	fmt.Fprintf(b, "jobs_cpu_limit:%d\r\n", js.limits[jobCPU])
+	fmt.Fprintf(b, "jobs_io_limit:%d\r\n", js.limits[jobIO])
+	fmt.Fprintf(b, "jobs_cpu_running:%d\r\n", js.running[jobCPU])
+	fmt.Fprintf(b, "jobs_io_running:%d\r\n", js.running[jobIO])
+	fmt.Fprintf(b, "jobs_waiting:%d\r\n", len(js.waiting))
+	for _, j := range jobs {
+		stats := js.stats[j.name]
+		fmt.Fprintf(b, "job_%s:runs=%d,failures=%d,wait_ms=%d,run_ms=%d\r\n",
+			strings.ReplaceAll(j.name, "-", "_"), stats.runs, stats.failures, stats.waited.Milliseconds(), stats.ran.Milliseconds())
+	}
+}
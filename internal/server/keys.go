@@ -0,0 +1,135 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"readpebble/internal/resp"
+)
+
+var (
+	errUnknownCommand = errors.New("invalid command specified")
+	errWrongArity     = errors.New("invalid number of arguments specified for command")
+)
+
+// reservedPrefix starts the keys the server keeps for itself: key
+// metadata, the elements of hashes, lists, sets and zsets, and collections.
+// Commands may not name keys starting with it, which would read or
+// overwrite those.
+const reservedPrefix = "\x00"
+
+// checkReservedKeys rejects a command naming a key in the reserved part of
+// the keyspace.
+func checkReservedKeys(spec *commandSpec, args []string) string {
+	keys, _ := getKeys(spec.Name, args)
+	for _, key := range keys {
+		if strings.HasPrefix(key, reservedPrefix) {
+			return resp.Error("ERR keys starting with a zero byte are reserved")
+		}
+	}
+	return ""
+}
+
+// keyExtractors find the keys of commands flagged "movablekeys", whose key
+// positions depend on their options. args exclude the command name.
+var keyExtractors = map[string]func(args []string) []string{
+	"eval":       evalKeys,
+	"evalsha":    evalKeys,
+	"fcall":      evalKeys,
+	"fcall_ro":   evalKeys,
+	"migrate":    migrateKeys,
+	"xread":      xreadKeys,
+	"xreadgroup": xreadGroupKeys,
+}
+
+// getKeys returns the keys cmd touches when called with args, which exclude
+// the command name, in argument order. It is what per-key locking and
+// cluster routing use to decide which keys a command needs.
+func getKeys(cmd string, args []string) ([]string, error) {
+	spec, ok := commandTable[strings.ToLower(cmd)]
+	if !ok {
+		return nil, errUnknownCommand
+	}
+	if !spec.validArity(args) {
+		return nil, errWrongArity
+	}
+	if extract, ok := keyExtractors[spec.Name]; ok {
+		return extract(args), nil
+	}
+	return spec.keys(args), nil
+}
+
+// keys applies the key positions of c to args. Positions count the command
+// name as 0, so they are shifted by one to index args.
+func (c *commandSpec) keys(args []string) []string {
+	if c.FirstKey <= 0 || c.Step <= 0 {
+		return nil
+	}
+	last := c.LastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+	last = min(last, len(args))
+	keys := make([]string, 0, (last-c.FirstKey)/c.Step+1)
+	for i := c.FirstKey; i <= last; i += c.Step {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+// migrateKeys returns the single key of MIGRATE or, when the key argument is
+// empty, everything after the KEYS option.
+func migrateKeys(args []string) []string {
+	if args[2] != "" {
+		return args[2:3]
+	}
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "auth":
+			i++
+		case "auth2":
+			i += 2
+		case "keys":
+			return args[i+1:]
+		}
+	}
+	return nil
+}
+
+// commandGetKeys implements COMMAND GETKEYS command [arg ...].
+func commandGetKeys(args []string) string {
+	if len(args) == 0 {
+		return resp.Error("ERR wrong number of arguments for 'command|getkeys' command")
+	}
+	keys, err := getKeys(args[0], args[1:])
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	if len(keys) == 0 {
+		return resp.Error("ERR The command has no key arguments")
+	}
+	return resp.StringArray(keys)
+}
@@ -0,0 +1,375 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// migrationBatch is how many keys a migration job looks at before it
+// yields to other jobs.
+const migrationBatch = 256
+
+// migrationLogEvery is how often, in keys migrated, a migration logs its
+// progress.
+const migrationLogEvery = 10000
+
+// legacyMigration moves the vectors written by storage.Insert, as plain
+// keys holding their components as raw little-endian float64s, into a
+// collection, as set up by VMIGRATE START or MigrateLegacyVectors. Each key
+// becomes the point of the same id, and is deleted once the point is
+// written; a migration cut short can be started again. Keys typed array
+// are migrated, and with untyped, also keys written before keys had a
+// type whose value decodes to finite float64s, which a string may happen
+// to do. Expiries are not carried over, as points do not expire.
+type legacyMigration struct {
+	collection string
+	pattern    string
+	untyped    bool
+	metric     collection.Metric
+	index      collection.IndexType
+	started    time.Time
+	stopCh     chan struct{}
+	doneCh     chan struct{}
+
+	scanned  atomic.Int64
+	migrated atomic.Int64
+	// skipped counts the legacy keys that could not be migrated: their
+	// dimension differs from the collection's.
+	skipped atomic.Int64
+
+	mutex    sync.Mutex
+	state    string
+	finished time.Time
+	err      error
+}
+
+// startMigration starts migrating the legacy keys matching m.pattern into
+// m.collection in the background.
+func (s *Server) startMigration(m *legacyMigration) error {
+	s.migrationMutex.Lock()
+	defer s.migrationMutex.Unlock()
+	if prev := s.migration; prev != nil && prev.status() == "running" {
+		return fmt.Errorf("already migrating into %s", prev.collection)
+	}
+	m.started = time.Now()
+	m.state = "running"
+	m.stopCh = make(chan struct{})
+	m.doneCh = make(chan struct{})
+	s.migration = m
+	s.goTracked(subsystemMigration, func() { s.runMigration(m) })
+	log.Printf("Migrating legacy vector keys into collection %s", m.collection)
+	return nil
+}
+
+func (m *legacyMigration) status() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.state
+}
+
+func (m *legacyMigration) finish(state string, err error) {
+	m.mutex.Lock()
+	m.state, m.err, m.finished = state, err, time.Now()
+	m.mutex.Unlock()
+}
+
+// runMigration migrates a batch of keys at a time, each as a job, until
+// every key was looked at or the migration is stopped.
+func (s *Server) runMigration(m *legacyMigration) {
+	defer close(m.doneCh)
+	var cursor []byte
+	for {
+		select {
+		case <-m.stopCh:
+			m.finish("stopped", nil)
+			log.Printf("Migration into %s stopped after %d keys", m.collection, m.migrated.Load())
+			return
+		case <-s.quitCh:
+			m.finish("stopped", nil)
+			return
+		default:
+		}
+		var next []byte
+		err := s.runJob(jobMigration, func() (err error) {
+			next, err = s.migrateBatch(m, cursor)
+			return err
+		})
+		if err == errStopping {
+			m.finish("stopped", nil)
+			return
+		}
+		if err != nil {
+			m.finish("failed", err)
+			log.Printf("Migration into %s failed: %v", m.collection, err)
+			return
+		}
+		if next == nil {
+			m.finish("done", nil)
+			log.Printf("Migrated %d legacy vector keys into %s, skipped %d", m.migrated.Load(), m.collection, m.skipped.Load())
+			return
+		}
+		cursor = next
+	}
+}
+
+// migrateBatch migrates the legacy keys among up to migrationBatch keys
+// from cursor on, returning the cursor to go on from, nil after the last
+// key.
+func (s *Server) migrateBatch(m *legacyMigration, cursor []byte) ([]byte, error) {
+	var keys [][]byte
+	next, err := s.scanKeys(s.db, time.Now(), cursor, m.pattern, migrationBatch, func(key []byte, meta storage.Meta) {
+		m.scanned.Add(1)
+		if meta.Type == storage.ObjectTypeArray || (m.untyped && meta.Type == storage.ObjecTypeString) {
+			keys = append(keys, append([]byte{}, key...))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := s.migrateKey(m, key); err != nil {
+			return nil, fmt.Errorf("migrating %q: %w", key, err)
+		}
+	}
+	return next, nil
+}
+
+// migrateKey moves key into the collection of m if it still is a legacy
+// vector, writing the point and deleting the key. Both are propagated to
+// replicas, as VADD and DEL.
+func (s *Server) migrateKey(m *legacyMigration, key []byte) error {
+	hold := s.order.hold([]string{string(key)})
+	defer hold.release()
+	defer s.keyLocks.lockAll([]string{string(key)})()
+	meta, exists, err := s.lookup(key)
+	if err != nil || !exists {
+		return err
+	}
+	var vector []float64
+	switch {
+	case meta.Type == storage.ObjectTypeArray:
+	case meta.Type == storage.ObjecTypeString && m.untyped:
+		// Only keys without a metadata record are untyped.
+		_, closer, err := s.db.Get(storage.MetaKey(key))
+		if err == nil {
+			closer.Close()
+			return nil
+		}
+		if err != pebble.ErrNotFound {
+			return err
+		}
+	default:
+		return nil
+	}
+	data, closer, err := s.db.Get(key)
+	if err != nil {
+		return err
+	}
+	vector, err = storage.DecodeVector(data)
+	closer.Close()
+	if err != nil || len(vector) == 0 || !finite(vector) {
+		if meta.Type == storage.ObjectTypeArray {
+			m.skipped.Add(1)
+		}
+		return nil
+	}
+	c, err := s.migrationCollection(m, len(vector))
+	if err != nil {
+		return err
+	}
+	if c.Dimension != len(vector) {
+		m.skipped.Add(1)
+		return nil
+	}
+	if err := s.collections.Upsert(c.Name, string(key), vector, nil, s.writeMode("vadd")); err != nil {
+		return err
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	storage.DeleteValue(batch, key, meta)
+	if err := s.committer.Commit(batch, s.writeMode("del")); err != nil {
+		return err
+	}
+	s.backlog.propagate(append([]string{"VADD", c.Name, string(key)}, formatVector(vector)...))
+	s.backlog.propagate([]string{"DEL", string(key)})
+	if n := m.migrated.Add(1); n%migrationLogEvery == 0 {
+		log.Printf("Migrated %d legacy vector keys into %s", n, c.Name)
+	}
+	return nil
+}
+
+// migrationCollection returns the collection of m, creating it with
+// dimension dim if it does not exist yet.
+func (s *Server) migrationCollection(m *legacyMigration, dim int) (*collection.Collection, error) {
+	c, err := s.collections.Get(m.collection)
+	if !errors.Is(err, collection.ErrNotFound) {
+		return c, err
+	}
+	info := collection.Info{
+		Name:      m.collection,
+		Dimension: dim,
+		Metric:    m.metric,
+		Index:     collection.IndexParams{Type: m.index},
+	}
+	switch err := s.collections.Create(info); {
+	case errors.Is(err, collection.ErrExists):
+	case err != nil:
+		return nil, err
+	default:
+		s.backlog.propagate([]string{"VCREATE", info.Name, "DIM", strconv.Itoa(dim), "METRIC", string(info.Metric), "INDEX", string(info.Index.Type)})
+	}
+	return s.collections.Get(m.collection)
+}
+
+// finite reports whether every component of vector is a finite number.
+func finite(vector []float64) bool {
+	for _, x := range vector {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// vmigrate implements VMIGRATE START collection [MATCH pattern] [UNTYPED]
+// [METRIC l2|cosine|ip] [INDEX flat|hnsw], migrating the legacy vector
+// keys matching the pattern into collection in the background (see
+// legacyMigration), METRIC and INDEX being those the collection is created
+// with if it does not exist; VMIGRATE STATUS, replying with the progress
+// of the last migration as field and value pairs; and VMIGRATE STOP,
+// stopping it once the batch it is on is done.
+func (s *Server) vmigrate(args []string) string {
+	switch strings.ToLower(args[0]) {
+	case "start":
+		if len(args) < 2 {
+			return resp.Error("ERR wrong number of arguments for 'vmigrate|start' command")
+		}
+		if s.isReplica() {
+			return resp.Error("READONLY You can't write against a read only replica.")
+		}
+		m := &legacyMigration{collection: args[1], metric: collection.MetricL2, index: collection.IndexFlat}
+		for opts := args[2:]; len(opts) > 0; {
+			switch strings.ToLower(opts[0]) {
+			case "untyped":
+				m.untyped = true
+				opts = opts[1:]
+				continue
+			case "match", "metric", "index":
+				if len(opts) < 2 {
+					return resp.Error("ERR syntax error")
+				}
+			default:
+				return resp.Error("ERR syntax error")
+			}
+			var err error
+			switch strings.ToLower(opts[0]) {
+			case "match":
+				m.pattern = opts[1]
+			case "metric":
+				m.metric, err = collection.ParseMetric(opts[1])
+			case "index":
+				m.index, err = collection.ParseIndexType(opts[1])
+			}
+			if err != nil {
+				return resp.Error("ERR " + err.Error())
+			}
+			opts = opts[2:]
+		}
+		if err := s.startMigration(m); err != nil {
+			return resp.Error("ERR " + err.Error())
+		}
+		return resp.OK
+	case "status":
+		s.migrationMutex.Lock()
+		m := s.migration
+		s.migrationMutex.Unlock()
+		if m == nil {
+			return resp.StringArray([]string{"state", "idle"})
+		}
+		m.mutex.Lock()
+		fields := []string{
+			"state", m.state,
+			"collection", m.collection,
+			"started", strconv.FormatInt(m.started.Unix(), 10),
+		}
+		if !m.finished.IsZero() {
+			fields = append(fields, "finished", strconv.FormatInt(m.finished.Unix(), 10))
+		}
+		if m.err != nil {
+			fields = append(fields, "error", m.err.Error())
+		}
+		m.mutex.Unlock()
+		fields = append(fields,
+			"scanned", strconv.FormatInt(m.scanned.Load(), 10),
+			"migrated", strconv.FormatInt(m.migrated.Load(), 10),
+			"skipped", strconv.FormatInt(m.skipped.Load(), 10))
+		return resp.StringArray(fields)
+	case "stop":
+		s.migrationMutex.Lock()
+		m := s.migration
+		s.migrationMutex.Unlock()
+		if m == nil || m.status() != "running" {
+			return resp.Error("ERR no migration is running")
+		}
+		select {
+		case <-m.stopCh:
+		default:
+			close(m.stopCh)
+		}
+		<-m.doneCh
+		return resp.OK
+	}
+	return resp.Error("ERR unknown VMIGRATE subcommand '" + args[0] + "'")
+}
+
+// infoMigration reports the progress of the last legacy key migration.
+func (s *Server) infoMigration(b *strings.Builder) {
+	s.migrationMutex.Lock()
+	m := s.migration
+	s.migrationMutex.Unlock()
+	if m == nil {
+		b.WriteString("migration_state:idle\r\n")
+		return
+	}
+	fmt.Fprintf(b, "migration_state:%s\r\n", m.status())
+	fmt.Fprintf(b, "migration_collection:%s\r\n", m.collection)
+	fmt.Fprintf(b, "migration_scanned:%d\r\n", m.scanned.Load())
+	fmt.Fprintf(b, "migration_migrated:%d\r\n", m.migrated.Load())
+	fmt.Fprintf(b, "migration_skipped:%d\r\n", m.skipped.Load())
+}
@@ -0,0 +1,303 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// push implements LPUSH and RPUSH key element [element ...], creating the
+// list if needed and replying with its new length. LPUSH pushes the
+// elements to the head one after the other, so they end up in reverse
+// order. Each element is written on its own along with the list's bounds,
+// however long the list.
+func (s *Server) push(cmd string, args []string) string {
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, bounds, exists, err := s.loadList(key)
+	if err != nil {
+		return typeError(err)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if !exists {
+		// Items left over from a list the key held before and lost to a
+		// command overwriting whatever the key holds, such as SET, which
+		// does not look for them, would come back otherwise.
+		storage.DeleteElements(batch, key, storage.Meta{Type: storage.ObjectTypeList})
+		meta, bounds = storage.NewMeta(storage.ObjectTypeList), storage.NewListBounds()
+	}
+	for _, element := range args[1:] {
+		if cmd == "lpush" {
+			bounds.Head--
+			batch.Set(storage.ListItemKey(key, bounds.Head), []byte(element), nil)
+		} else {
+			batch.Set(storage.ListItemKey(key, bounds.Tail), []byte(element), nil)
+			bounds.Tail++
+		}
+	}
+	writeList(batch, key, meta, bounds)
+	if err := s.committer.Commit(batch, s.writeMode(cmd)); err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(bounds.Len())
+}
+
+// pop implements LPOP and RPOP key [count], removing the first or last
+// item of the list and replying with it, or nil if the list does not
+// exist. With a count, up to count items are removed and replied with as
+// an array, a nil one if the list does not exist. The key is deleted with
+// the last item.
+func (s *Server) pop(cmd string, args []string) string {
+	count := int64(1)
+	switch len(args) {
+	case 1:
+	case 2:
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n < 0 {
+			return resp.Error("ERR value is out of range, must be positive")
+		}
+		count = n
+	default:
+		return resp.Error("ERR syntax error")
+	}
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, bounds, exists, err := s.loadList(key)
+	if err != nil {
+		return typeError(err)
+	}
+	if !exists {
+		if len(args) == 2 {
+			return resp.NilArray
+		}
+		return resp.Nil
+	}
+	n := uint64(min(count, bounds.Len()))
+	lo, hi := bounds.Head, bounds.Head+n
+	if cmd == "rpop" {
+		lo, hi = bounds.Tail-n, bounds.Tail
+	}
+	items, err := s.listItems(key, lo, hi)
+	if err != nil {
+		return typeError(err)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	for seq := lo; seq < hi; seq++ {
+		batch.Delete(storage.ListItemKey(key, seq), nil)
+	}
+	if cmd == "rpop" {
+		slices.Reverse(items)
+		bounds.Tail = lo
+	} else {
+		bounds.Head = hi
+	}
+	writeList(batch, key, meta, bounds)
+	if err := s.committer.Commit(batch, s.writeMode(cmd)); err != nil {
+		return typeError(err)
+	}
+	if len(args) == 2 {
+		return resp.StringArray(items)
+	}
+	return resp.BulkString(items[0])
+}
+
+// lrange implements LRANGE key start stop, replying with the items from
+// start to stop inclusive, which count from the end when negative. The
+// items are read in one pass over the keys holding them.
+func (s *Server) lrange(args []string) string {
+	start, stop, reply := parseListRange(args[1], args[2])
+	if reply != "" {
+		return reply
+	}
+	_, bounds, exists, err := s.loadList([]byte(args[0]))
+	if err != nil {
+		return typeError(err)
+	}
+	items := []string{}
+	if start, stop, ok := clampListRange(start, stop, bounds.Len()); exists && ok {
+		defer s.io.foregroundRead()()
+		lo := bounds.Head + uint64(start)
+		if items, err = s.listItems([]byte(args[0]), lo, lo+uint64(stop-start+1)); err != nil {
+			return typeError(err)
+		}
+	}
+	return resp.StringArray(items)
+}
+
+// llen implements LLEN key, replying 0 for a list that does not exist.
+func (s *Server) llen(args []string) string {
+	_, bounds, _, err := s.loadList([]byte(args[0]))
+	if err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(bounds.Len())
+}
+
+// lindex implements LINDEX key index, replying with the item at index,
+// which counts from the end when negative, or nil if there is none.
+func (s *Server) lindex(args []string) string {
+	index, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return resp.Error("ERR value is not an integer or out of range")
+	}
+	key := []byte(args[0])
+	_, bounds, exists, err := s.loadList(key)
+	if err != nil {
+		return typeError(err)
+	}
+	if index < 0 {
+		index += bounds.Len()
+	}
+	if !exists || index < 0 || index >= bounds.Len() {
+		return resp.Nil
+	}
+	value, err := s.io.get(s.db, storage.ListItemKey(key, bounds.Head+uint64(index)))
+	if err == pebble.ErrNotFound {
+		return resp.Nil
+	}
+	if err != nil {
+		return typeError(err)
+	}
+	return resp.BulkString(string(value))
+}
+
+// ltrim implements LTRIM key start stop, keeping only the items from start
+// to stop inclusive, counted as by LRANGE. The items dropped from each end
+// are deleted with a range deletion, however many there are.
+func (s *Server) ltrim(args []string) string {
+	start, stop, reply := parseListRange(args[1], args[2])
+	if reply != "" {
+		return reply
+	}
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, bounds, exists, err := s.loadList(key)
+	if err != nil || !exists {
+		if err != nil {
+			return typeError(err)
+		}
+		return resp.OK
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if start, stop, ok := clampListRange(start, stop, bounds.Len()); ok {
+		head, tail := bounds.Head+uint64(start), bounds.Head+uint64(stop)+1
+		if head > bounds.Head {
+			batch.DeleteRange(storage.ListItemKey(key, bounds.Head), storage.ListItemKey(key, head), nil)
+		}
+		if tail < bounds.Tail {
+			batch.DeleteRange(storage.ListItemKey(key, tail), storage.ListItemKey(key, bounds.Tail), nil)
+		}
+		bounds = storage.ListBounds{Head: head, Tail: tail}
+	} else {
+		bounds = storage.ListBounds{}
+	}
+	writeList(batch, key, meta, bounds)
+	if err := s.committer.Commit(batch, s.writeMode("ltrim")); err != nil {
+		return typeError(err)
+	}
+	return resp.OK
+}
+
+// parseListRange parses the start and stop of LRANGE and LTRIM, returning
+// an error reply if they are not integers.
+func parseListRange(startArg, stopArg string) (int64, int64, string) {
+	start, err1 := strconv.ParseInt(startArg, 10, 64)
+	stop, err2 := strconv.ParseInt(stopArg, 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, resp.Error("ERR value is not an integer or out of range")
+	}
+	return start, stop, ""
+}
+
+// clampListRange turns start and stop, inclusive and counting from the end
+// when negative, into offsets into a list of n items, and reports whether
+// the range holds any.
+func clampListRange(start, stop, n int64) (int64, int64, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start, stop = max(start, 0), min(stop, n-1)
+	return start, stop, start <= stop
+}
+
+// listItems returns the items of the list at key numbered from lo to hi-1.
+func (s *Server) listItems(key []byte, lo, hi uint64) ([]string, error) {
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: storage.ListItemKey(key, lo),
+		UpperBound: storage.ListItemKey(key, hi),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	items := make([]string, 0, hi-lo)
+	for iter.First(); iter.Valid(); iter.Next() {
+		items = append(items, string(iter.Value()))
+	}
+	return items, iter.Error()
+}
+
+// loadList returns the metadata and bounds of the list at key and whether
+// it exists. Keys holding another type fail with errWrongType.
+func (s *Server) loadList(key []byte) (storage.Meta, storage.ListBounds, bool, error) {
+	meta, exists, err := s.lookupType(key, storage.ObjectTypeList)
+	if err != nil || !exists {
+		return meta, storage.ListBounds{}, false, err
+	}
+	value, closer, err := s.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return meta, storage.ListBounds{}, false, nil
+	}
+	if err != nil {
+		return meta, storage.ListBounds{}, false, err
+	}
+	defer closer.Close()
+	bounds, err := storage.DecodeListBounds(value)
+	return meta, bounds, err == nil, err
+}
+
+// writeList adds to batch the writes storing the new bounds of the list at
+// key, whose metadata is meta, or deleting the key if the list is empty.
+func writeList(batch *pebble.Batch, key []byte, meta storage.Meta, bounds storage.ListBounds) {
+	if bounds.Len() == 0 {
+		storage.DeleteValue(batch, key, meta)
+		return
+	}
+	meta.LastAccess = time.Now()
+	batch.Set(key, bounds.Encode(), nil)
+	storage.SetMeta(batch, key, meta)
+}
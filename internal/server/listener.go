@@ -0,0 +1,226 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// serverListener is one of the addresses the server accepts RESP
+// connections on.
+type serverListener struct {
+	name    string
+	network string
+	addr    string
+	// admin listeners serve operational commands.
+	admin bool
+
+	mutex    sync.Mutex
+	listener net.Listener
+	// inherited is a listener passed by a process handing off to this one,
+	// used instead of listening on addr.
+	inherited net.Listener
+}
+
+func newServerListener(name, addr string, admin bool) *serverListener {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	return &serverListener{name: name, network: network, addr: addr, admin: admin}
+}
+
+func (l *serverListener) listen() error {
+	if l.inherited != nil {
+		l.mutex.Lock()
+		l.listener, l.inherited = l.inherited, nil
+		l.mutex.Unlock()
+		return nil
+	}
+	if l.network == "unix" {
+		// A socket file left behind by a crashed process would make
+		// Listen fail with "address already in use".
+		os.Remove(l.addr)
+	}
+	listener, err := net.Listen(l.network, l.addr)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	l.listener = listener
+	l.mutex.Unlock()
+	return nil
+}
+
+func (l *serverListener) current() net.Listener {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.listener
+}
+
+func (l *serverListener) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.listener != nil {
+		l.listener.Close()
+	}
+}
+
+// file returns a duplicate of the listening socket's descriptor.
+func (l *serverListener) file() (*os.File, error) {
+	switch listener := l.current().(type) {
+	case *net.TCPListener:
+		return listener.File()
+	case *net.UnixListener:
+		return listener.File()
+	}
+	return nil, errors.New("listener cannot be handed off")
+}
+
+// keepOnClose stops close from removing the socket file of a unix listener
+// that another process now serves.
+func (l *serverListener) keepOnClose() {
+	if listener, ok := l.current().(*net.UnixListener); ok {
+		listener.SetUnlinkOnClose(false)
+	}
+}
+
+// allows reports whether a connection from addr may use this listener.
+func (l *serverListener) allows(addr net.Addr, localOnly bool) bool {
+	if !localOnly || l.network == "unix" {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && tcpAddr.IP.IsLoopback()
+}
+
+// serve runs the accept loop of l. Temporary errors (for example running out
+// of file descriptors) are retried with exponential backoff instead of
+// spinning, the loop exits cleanly once Shutdown closes the listener, and any
+// other error either stops the server or, with RebindOnFailure, re-creates
+// the listener.
+func (s *Server) serve(l *serverListener) error {
+	var delay time.Duration
+	for {
+		conn, err := l.current().Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return nil
+			}
+			if isTemporary(err) {
+				delay = nextAcceptDelay(delay)
+				log.Printf("Failed to accept connection on %s: %v; retrying in %v", l.addr, err, delay)
+				if !s.sleep(delay) {
+					return nil
+				}
+				continue
+			}
+			if !s.config.RebindOnFailure {
+				return err
+			}
+			log.Printf("Listener on %s failed: %v; rebinding", l.addr, err)
+			l.close()
+			if err := s.rebind(l); err != nil {
+				return err
+			}
+			if s.shuttingDown() {
+				return nil
+			}
+			continue
+		}
+		delay = 0
+		if l.admin && !l.allows(conn.RemoteAddr(), s.config.AdminLocalOnly) {
+			log.Printf("Refused admin connection from %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go s.handleConnection(conn, l)
+	}
+}
+
+// rebind listens on the address of l again, backing off between attempts.
+func (s *Server) rebind(l *serverListener) error {
+	var delay time.Duration
+	var lastErr error
+	for attempt := 1; attempt <= s.config.RebindAttempts; attempt++ {
+		err := l.listen()
+		if err == nil {
+			if s.shuttingDown() {
+				l.close()
+				return nil
+			}
+			log.Printf("Listener rebound on %s", l.addr)
+			return nil
+		}
+		lastErr = err
+		delay = nextAcceptDelay(delay)
+		log.Printf("Rebind attempt %d on %s failed: %v", attempt, l.addr, err)
+		if !s.sleep(delay) {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to rebind %s after %d attempts: %w", l.addr, s.config.RebindAttempts, lastErr)
+}
+
+// sleep waits for d and reports false if the server started shutting down
+// in the meantime.
+func (s *Server) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.quitCh:
+		return false
+	}
+}
+
+func nextAcceptDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minAcceptDelay
+	}
+	return min(delay*2, maxAcceptDelay)
+}
+
+func isTemporary(err error) bool {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.ENOMEM) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
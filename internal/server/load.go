@@ -0,0 +1,97 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+)
+
+const loadSampleInterval = time.Second
+
+// loadMonitor tracks the signals RESP3 clients use to shed load: the number
+// of commands currently executing (queue depth) and Pebble's estimated
+// compaction debt, which is sampled periodically since Metrics is not free.
+type loadMonitor struct {
+	db             *pebble.DB
+	queueDepthHigh int64
+	debtHigh       uint64
+	queueDepth     atomic.Int64
+	compactionDebt atomic.Uint64
+}
+
+func newLoadMonitor(db *pebble.DB, config Config) *loadMonitor {
+	return &loadMonitor{
+		db:             db,
+		queueDepthHigh: config.LoadQueueDepthHigh,
+		debtHigh:       config.LoadCompactionDebtHigh,
+	}
+}
+
+func (l *loadMonitor) run(quitCh chan struct{}) {
+	ticker := time.NewTicker(loadSampleInterval)
+	defer ticker.Stop()
+	for {
+		l.compactionDebt.Store(l.db.Metrics().Compact.EstimatedDebt)
+		select {
+		case <-ticker.C:
+		case <-quitCh:
+			return
+		}
+	}
+}
+
+func (l *loadMonitor) begin() {
+	l.queueDepth.Add(1)
+}
+
+func (l *loadMonitor) end() {
+	l.queueDepth.Add(-1)
+}
+
+// pressure returns the load as a percentage of the configured high-water
+// marks, taking whichever signal is worse and capping at 100.
+func (l *loadMonitor) pressure() int64 {
+	queue := l.queueDepth.Load() * 100 / l.queueDepthHigh
+	debt := int64(l.compactionDebt.Load() * 100 / l.debtHigh)
+	return min(max(queue, debt), 100)
+}
+
+// writeAttribute writes the entry of the RESP3 reply attribute announcing
+// the load, given its pressure. Clients use it to back off before the
+// server has to reject work.
+func (l *loadMonitor) writeAttribute(w *resp.Writer, pressure int64) {
+	w.SimpleString("load")
+	w.Map(3)
+	w.SimpleString("pressure")
+	w.Integer(pressure)
+	w.SimpleString("queue-depth")
+	w.Integer(l.queueDepth.Load())
+	w.SimpleString("compaction-debt")
+	w.Integer(int64(l.compactionDebt.Load()))
+}
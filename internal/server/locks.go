@@ -0,0 +1,162 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"hash/fnv"
+	"slices"
+	"sync"
+)
+
+// keyLockStripes is how many mutexes keys are spread over.
+const keyLockStripes = 256
+
+// keyLocks serializes the commands that read a key and write it back, such
+// as INCR, so that concurrent ones do not overwrite each other, and the
+// ones deleting keys with those, so that a list is not written back with
+// the bounds it had before it was deleted. Keys are
+// hashed onto a fixed set of mutexes: unrelated keys rarely contend and no
+// lock is allocated per key.
+type keyLocks struct {
+	stripes [keyLockStripes]sync.Mutex
+}
+
+func stripe(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % keyLockStripes
+}
+
+// lock locks key and returns the function unlocking it.
+func (l *keyLocks) lock(key string) func() {
+	m := &l.stripes[stripe(key)]
+	m.Lock()
+	return m.Unlock
+}
+
+// lockPair locks two keys and returns the function unlocking them. The
+// stripes are locked in order, so that commands locking the same keys the
+// other way round cannot deadlock.
+func (l *keyLocks) lockPair(a, b string) func() {
+	i, j := stripe(a), stripe(b)
+	if i == j {
+		return l.lock(a)
+	}
+	if i > j {
+		i, j = j, i
+	}
+	l.stripes[i].Lock()
+	l.stripes[j].Lock()
+	return func() {
+		l.stripes[j].Unlock()
+		l.stripes[i].Unlock()
+	}
+}
+
+// lockAll locks keys and returns the function unlocking them. Like
+// lockPair, it locks stripes in order.
+func (l *keyLocks) lockAll(keys []string) func() {
+	stripes := make([]uint32, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, stripe(key))
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+	for _, i := range stripes {
+		l.stripes[i].Lock()
+	}
+	return func() {
+		for i := len(stripes) - 1; i >= 0; i-- {
+			l.stripes[stripes[i]].Unlock()
+		}
+	}
+}
+
+// lockEvery locks every key, for commands writing the whole keyspace, and
+// returns the function unlocking them.
+func (l *keyLocks) lockEvery() func() {
+	for i := range l.stripes {
+		l.stripes[i].Lock()
+	}
+	return func() {
+		for i := len(l.stripes) - 1; i >= 0; i-- {
+			l.stripes[i].Unlock()
+		}
+	}
+}
+
+// writeOrder keeps the replication stream in the order writes were
+// committed in. A write holds it from before it runs until it was
+// propagated: shared for the keyspace, and exclusively for its keys or,
+// if it names none, for every key. Writes to the same key therefore reach
+// the stream in commit order, and a full resynchronization, which holds
+// the keyspace exclusively while it takes its snapshot and offset, finds
+// every write either in the snapshot or in the stream after the offset.
+type writeOrder struct {
+	keyspace sync.RWMutex
+	keys     keyLocks
+}
+
+// orderHold is a write's hold on the write order, given up while the
+// write blocks (see blocked).
+type orderHold struct {
+	order  *writeOrder
+	keys   []string
+	unlock func()
+}
+
+// hold waits for the write order for a write to keys and returns the hold.
+func (o *writeOrder) hold(keys []string) *orderHold {
+	h := &orderHold{order: o, keys: keys}
+	h.acquire()
+	return h
+}
+
+// tryHold returns the hold for a write to key, or nil if another write,
+// possibly one the caller runs, holds it.
+func (o *writeOrder) tryHold(key string) *orderHold {
+	if !o.keyspace.TryRLock() {
+		return nil
+	}
+	m := &o.keys.stripes[stripe(key)]
+	if !m.TryLock() {
+		o.keyspace.RUnlock()
+		return nil
+	}
+	return &orderHold{order: o, keys: []string{key}, unlock: m.Unlock}
+}
+
+func (h *orderHold) acquire() {
+	h.order.keyspace.RLock()
+	if len(h.keys) == 0 {
+		h.unlock = h.order.keys.lockEvery()
+	} else {
+		h.unlock = h.order.keys.lockAll(h.keys)
+	}
+}
+
+func (h *orderHold) release() {
+	h.unlock()
+	h.order.keyspace.RUnlock()
+}
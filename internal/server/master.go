@@ -0,0 +1,586 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"readpebble/internal/rdb"
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+
+	"github.com/cockroachdb/pebble"
+)
+
+const (
+	// replicaPingInterval is how often the stream carries a PING while
+	// replicas are attached, so that they can tell an idle master from a
+	// lost one.
+	replicaPingInterval = 10 * time.Second
+	// followerChunk bounds how much of the stream is written to a replica
+	// at once.
+	followerChunk = 64 << 10
+)
+
+var (
+	errFollowerClosed = errors.New("replica disconnected")
+	errBacklogOverrun = errors.New("replica fell behind the replication backlog")
+)
+
+// backlog is the replication stream this server sends its replicas: the
+// write commands it executed, in the RESP form replicas apply them in. It
+// keeps the last ReplicationBacklog bytes of the stream, so that a replica
+// that reconnects after a short outage resumes from its offset (PSYNC
+// replid offset) instead of transferring the whole keyspace again, and
+// each attached replica is sent the stream from the backlog as well: one
+// that falls further behind than the backlog holds is disconnected and
+// resynchronizes fully when it reconnects.
+//
+// Nothing is recorded until the first replica attaches, as with Redis.
+type backlog struct {
+	mutex   sync.Mutex
+	changed *sync.Cond
+	replID  string
+	// buf holds the last histLen bytes of the stream, as a ring buffer
+	// ending at offset, the number of bytes ever written to the stream.
+	buf       []byte
+	size      int64
+	offset    int64
+	histLen   int64
+	followers map[*follower]struct{}
+}
+
+// follower is a replica attached to this server.
+type follower struct {
+	conn *connection
+	// addr is where the replica listens, as told by REPLCONF
+	// listening-port.
+	addr string
+	// snapshot is the keyspace to send first on a full resynchronization.
+	snapshot *pebble.Snapshot
+	// offset is how far in the stream the replica was sent, and ack how
+	// far it acknowledged processing, at ackTime.
+	offset  int64
+	ack     int64
+	ackTime time.Time
+	online  bool
+	closed  bool
+}
+
+func newBacklog(size int64) *backlog {
+	b := &backlog{size: size, replID: newReplID(), followers: make(map[*follower]struct{})}
+	b.changed = sync.NewCond(&b.mutex)
+	return b
+}
+
+// newReplID returns a random replication ID, 40 hex characters like the
+// ones Redis uses.
+func newReplID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// propagate appends a command to the stream.
+func (b *backlog) propagate(args []string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.buf == nil {
+		return
+	}
+	p := []byte(resp.StringArray(args))
+	if int64(len(p)) > b.size {
+		b.offset += int64(len(p)) - b.size
+		p = p[int64(len(p))-b.size:]
+	}
+	for len(p) > 0 {
+		n := copy(b.buf[b.offset%b.size:], p)
+		p = p[n:]
+		b.offset += int64(n)
+		b.histLen = min(b.histLen+int64(n), b.size)
+	}
+	b.changed.Broadcast()
+}
+
+// attach registers a replica that asked to resume from offset, returning
+// the replication ID and whether it can: the ID must be this server's and
+// the backlog must still hold the stream from there. Otherwise the replica
+// is given a snapshot of the keyspace taken at the current offset, which
+// needs the caller to hold the write order of the keyspace exclusively.
+func (b *backlog) attach(f *follower, replID string, offset int64, db *pebble.DB) (string, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.buf == nil {
+		b.buf = make([]byte, b.size)
+	}
+	b.followers[f] = struct{}{}
+	if replID == b.replID && offset >= b.offset-b.histLen && offset <= b.offset {
+		f.offset = offset
+		return b.replID, true
+	}
+	// The caller holds the write order exclusively, so every write
+	// committed before the snapshot was propagated before the offset and
+	// every later one is in the stream after it: none is replayed twice.
+	f.offset = b.offset
+	f.snapshot = db.NewSnapshot()
+	return b.replID, false
+}
+
+func (b *backlog) detach(f *follower) {
+	b.mutex.Lock()
+	delete(b.followers, f)
+	b.mutex.Unlock()
+}
+
+// next waits for the stream past f's offset and returns the next chunk of
+// it.
+func (b *backlog) next(f *follower) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for f.offset == b.offset && !f.closed {
+		b.changed.Wait()
+	}
+	switch {
+	case f.closed:
+		return nil, errFollowerClosed
+	case f.offset < b.offset-b.histLen:
+		return nil, errBacklogOverrun
+	}
+	n := min(b.offset-f.offset, followerChunk)
+	chunk := make([]byte, n)
+	start := f.offset % b.size
+	copied := copy(chunk, b.buf[start:])
+	copy(chunk[copied:], b.buf)
+	f.offset += n
+	return chunk, nil
+}
+
+// close marks f as disconnected, waking up the goroutine sending it the
+// stream.
+func (b *backlog) close(f *follower) {
+	b.mutex.Lock()
+	f.closed = true
+	b.changed.Broadcast()
+	b.mutex.Unlock()
+}
+
+// reset disconnects every replica and starts a new history under a new
+// replication ID, for when the server starts following a master and its
+// keyspace stops matching the stream it sent so far.
+func (b *backlog) reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for f := range b.followers {
+		f.closed = true
+		f.conn.close()
+	}
+	b.replID = newReplID()
+	b.buf, b.histLen = nil, 0
+	b.changed.Broadcast()
+}
+
+func (b *backlog) active() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.followers) > 0
+}
+
+// replconf implements REPLCONF, which replicas send before PSYNC. Only the
+// listening port is kept; acknowledgements are read once the connection
+// carries the stream.
+func (s *Server) replconf(c *connection, args []string) string {
+	for i := 0; i+1 < len(args); i += 2 {
+		if strings.ToLower(args[i]) == "listening-port" {
+			c.mutex.Lock()
+			c.listeningPort = args[i+1]
+			c.mutex.Unlock()
+		}
+	}
+	return resp.OK
+}
+
+// psync implements PSYNC replid offset, attaching the connection as a
+// replica once the reply is written. A replica serving its own replicas
+// would have to relay its master's stream, which vecble does not do.
+func (s *Server) psync(c *connection, args []string) string {
+	if s.isReplica() {
+		return resp.Error("ERR this server is a replica and cannot serve replicas")
+	}
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return resp.Error("ERR value is not an integer or out of range")
+	}
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	c.mutex.Lock()
+	f := &follower{conn: c, addr: net.JoinHostPort(host, c.listeningPort), ackTime: time.Now()}
+	c.follower = f
+	c.mutex.Unlock()
+
+	// The offset of a PSYNC is the first byte the replica is missing,
+	// counting from 1.
+	s.order.keyspace.Lock()
+	replID, partial := s.backlog.attach(f, args[0], offset-1, s.db)
+	s.order.keyspace.Unlock()
+	if partial {
+		s.stats.partialSyncs.Inc()
+		log.Printf("Partial resynchronization of replica %s accepted from offset %d", f.addr, offset)
+		return resp.SimpleString("CONTINUE " + replID)
+	}
+	s.stats.fullSyncs.Inc()
+	log.Printf("Full resynchronization of replica %s from offset %d", f.addr, f.offset)
+	return resp.SimpleString(fmt.Sprintf("FULLRESYNC %s %d", replID, f.offset))
+}
+
+// serveFollower sends the stream to a connection attached by PSYNC, after
+// the keyspace snapshot on a full resynchronization, until either side
+// disconnects. The replica's acknowledgements are read meanwhile.
+func (s *Server) serveFollower(f *follower, reader *resp.Reader, writer *bufio.Writer) {
+	defer s.backlog.detach(f)
+	go func() {
+		defer s.backlog.close(f)
+		for {
+			cmd, args, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			if cmd != "replconf" || len(args) != 2 || strings.ToLower(args[0]) != "ack" {
+				continue
+			}
+			if ack, err := strconv.ParseInt(args[1], 10, 64); err == nil {
+				s.backlog.mutex.Lock()
+				f.ack, f.ackTime = ack, time.Now()
+				s.backlog.mutex.Unlock()
+			}
+		}
+	}()
+
+	if f.snapshot != nil {
+		err := s.sendSnapshot(f.snapshot, writer)
+		f.snapshot.Close()
+		if err != nil {
+			log.Printf("Sending snapshot to replica %s failed: %v", f.addr, err)
+			return
+		}
+	}
+	s.backlog.mutex.Lock()
+	f.online = true
+	s.backlog.mutex.Unlock()
+	for {
+		chunk, err := s.backlog.next(f)
+		if err != nil {
+			if err == errBacklogOverrun {
+				log.Printf("Disconnecting replica %s: %v", f.addr, err)
+			}
+			return
+		}
+		if _, err := writer.Write(chunk); err != nil {
+			return
+		}
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// snapshotAuxPrefix starts the names of the auxiliary fields carrying what
+// a snapshot sends as it is stored: the rest of the name is a key of the
+// keyspace and the value its value, which replicas write as they are.
+const snapshotAuxPrefix = "vecble:"
+
+// snapshotTypes are the types of keys, besides strings, a snapshot sends as
+// they are stored: their value, metadata and elements.
+var snapshotTypes = map[storage.ObjectType]bool{
+	storage.ObjectTypeArray:  true,
+	storage.ObjectTypeHash:   true,
+	storage.ObjectTypeList:   true,
+	storage.ObjectTypeSet:    true,
+	storage.ObjectTypeZSet:   true,
+	storage.ObjectTypeStream: true,
+}
+
+// sendSnapshot writes the keys of snap as an RDB payload, the form replicas
+// load on a full resynchronization. Strings are RDB strings, and the other
+// types and the rest of the reserved keyspace, which holds collections,
+// function libraries and blobs, go as they are stored (see
+// snapshotAuxPrefix). A key of a type it cannot send fails the
+// resynchronization rather than leaving the replica without it. The
+// payload is staged in a temporary file since its length goes first.
+func (s *Server) sendSnapshot(snap *pebble.Snapshot, w *bufio.Writer) error {
+	file, err := os.CreateTemp("", "vecble-sync-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	rw, err := rdb.NewWriter(file)
+	if err != nil {
+		return err
+	}
+	if err := s.sendReserved(snap, rw); err != nil {
+		return err
+	}
+	iter, err := snap.NewIter(&pebble.IterOptions{LowerBound: []byte{1}})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	now := time.Now()
+	for iter.First(); iter.Valid(); iter.Next() {
+		s.io.backgroundRead(len(iter.Key()) + len(iter.Value()))
+		meta, exists, err := storage.LoadMeta(snap, iter.Key())
+		if err != nil {
+			return err
+		}
+		if !exists || meta.Expired(now) {
+			continue
+		}
+		switch {
+		case meta.Type == storage.ObjecTypeString:
+			err = rw.WriteString(iter.Key(), iter.Value(), meta.ExpireAt)
+		case snapshotTypes[meta.Type]:
+			err = s.sendStored(snap, rw, iter.Key(), iter.Value(), meta)
+		default:
+			return fmt.Errorf("cannot send %s key %q to replicas", meta.Type, iter.Key())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if err := rw.Close(); err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "$%d\r\n", size)
+	if _, err := io.Copy(w, file); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// sendStored writes key, whose value is value and metadata meta, as it is
+// stored: its value, its metadata, its expiry index entry and its
+// elements.
+func (s *Server) sendStored(snap *pebble.Snapshot, rw *rdb.Writer, key, value []byte, meta storage.Meta) error {
+	if err := writeStoredPair(rw, key, value); err != nil {
+		return err
+	}
+	record, closer, err := snap.Get(storage.MetaKey(key))
+	if err != nil {
+		return err
+	}
+	err = writeStoredPair(rw, storage.MetaKey(key), record)
+	closer.Close()
+	if err != nil {
+		return err
+	}
+	if !meta.ExpireAt.IsZero() {
+		if err := writeStoredPair(rw, storage.ExpiryKey(meta.ExpireAt, key), nil); err != nil {
+			return err
+		}
+	}
+	start, end, ok := storage.ElementsSpan(key, meta.Type)
+	if !ok {
+		return nil
+	}
+	iter, err := snap.NewIter(&pebble.IterOptions{LowerBound: start, UpperBound: end})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		s.io.backgroundRead(len(iter.Key()) + len(iter.Value()))
+		if err := writeStoredPair(rw, iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// sendReserved writes the reserved keyspace of snap as it is stored, but
+// for the metadata, the expiry index and the elements, which go with their
+// keys (see sendStored).
+func (s *Server) sendReserved(snap *pebble.Snapshot, rw *rdb.Writer) error {
+	skipped := append(storage.MetaSpans(), storage.ElementSpans()...)
+	iter, err := snap.NewIter(&pebble.IterOptions{LowerBound: []byte{0}, UpperBound: []byte{1}})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); {
+		if end := spanEnd(skipped, iter.Key()); end != nil {
+			iter.SeekGE(end)
+			continue
+		}
+		s.io.backgroundRead(len(iter.Key()) + len(iter.Value()))
+		if err := writeStoredPair(rw, iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+		iter.Next()
+	}
+	return iter.Error()
+}
+
+// spanEnd returns the end of the span of spans holding key, nil if none
+// does.
+func spanEnd(spans [][2][]byte, key []byte) []byte {
+	for _, span := range spans {
+		if bytes.Compare(key, span[0]) >= 0 && bytes.Compare(key, span[1]) < 0 {
+			return span[1]
+		}
+	}
+	return nil
+}
+
+func writeStoredPair(rw *rdb.Writer, key, value []byte) error {
+	return rw.WriteAux(append([]byte(snapshotAuxPrefix), key...), value)
+}
+
+// shouldPropagate reports whether a successful cmd is sent to replicas.
+// Commands applied from a master's stream are not, since a replica does
+// not serve replicas.
+func (s *Server) shouldPropagate(c *connection, spec *commandSpec, args []string, reply string) bool {
+	return spec.writes(args) && !c.replication && !strings.HasPrefix(reply, "-")
+}
+
+// propagate sends cmd, which replied reply, to replicas in a form that has
+// the same effect when they apply it later: relative expiries become
+// absolute, GETEX becomes the change of expiry it made, MIGRATE, which
+// replicas cannot repeat, becomes the deletion of the moved keys, XADD
+// gives the entry the ID it got here, and XREADGROUP loses its BLOCK
+// option.
+func (s *Server) propagate(cmd string, args []string, reply string) {
+	switch cmd {
+	case "expire", "pexpire", "expireat":
+		n, _ := strconv.ParseInt(args[1], 10, 64)
+		var at time.Time
+		switch cmd {
+		case "expire":
+			at, _ = expiryIn(n, time.Second)
+		case "pexpire":
+			at, _ = expiryIn(n, time.Millisecond)
+		case "expireat":
+			at = time.Unix(n, 0)
+		}
+		cmd, args = "pexpireat", []string{args[0], strconv.FormatInt(at.UnixMilli(), 10)}
+	case "getex":
+		at, persist, _ := getExOptions(args[1:])
+		switch {
+		case persist:
+			cmd, args = "persist", args[:1]
+		case !at.IsZero():
+			cmd, args = "pexpireat", []string{args[0], strconv.FormatInt(at.UnixMilli(), 10)}
+		default:
+			return
+		}
+	case "migrate":
+		for _, arg := range args[5:] {
+			if strings.ToLower(arg) == "copy" {
+				return
+			}
+		}
+		cmd, args = "del", migrateKeys(args)
+	case "xadd":
+		id, err := resp.NewReader(strings.NewReader(reply)).ReadReply()
+		if err != nil || id == nil {
+			// NOMKSTREAM found no stream.
+			return
+		}
+		opts, _ := parseXAdd(args)
+		args = append([]string{}, args...)
+		args[opts.idIndex] = id.(string)
+	case "xreadgroup":
+		args = xreadGroupUnblocked(args)
+	}
+	s.backlog.propagate(append([]string{strings.ToUpper(cmd)}, args...))
+}
+
+// pingReplicasLoop sends a PING down the stream every replicaPingInterval
+// while replicas are attached, until the server stops.
+func (s *Server) pingReplicasLoop() {
+	ticker := time.NewTicker(replicaPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.backlog.active() {
+				s.backlog.propagate([]string{"PING"})
+			}
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// infoFollowers writes the master side of REPLICATION INFO: the stream
+// position, what the backlog holds, and how far behind each replica is.
+func (s *Server) infoFollowers(b *strings.Builder) {
+	bl := s.backlog
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+	fmt.Fprintf(b, "role:master\r\n")
+	fmt.Fprintf(b, "connected_replicas:%d\r\n", len(bl.followers))
+	i := 0
+	for f := range bl.followers {
+		state := "sync"
+		if f.online {
+			state = "online"
+		}
+		fmt.Fprintf(b, "replica%d:addr=%s,state=%s,offset=%d,lag=%d,lag_bytes=%d\r\n",
+			i, f.addr, state, f.ack, int(time.Since(f.ackTime).Seconds()), bl.offset-f.ack)
+		i++
+	}
+	active := 0
+	if bl.buf != nil {
+		active = 1
+	}
+	fmt.Fprintf(b, "master_replid:%s\r\n", bl.replID)
+	fmt.Fprintf(b, "master_repl_offset:%d\r\n", bl.offset)
+	fmt.Fprintf(b, "repl_backlog_active:%d\r\n", active)
+	fmt.Fprintf(b, "repl_backlog_size:%d\r\n", bl.size)
+	fmt.Fprintf(b, "repl_backlog_first_byte_offset:%d\r\n", bl.offset-bl.histLen+1)
+	fmt.Fprintf(b, "repl_backlog_histlen:%d\r\n", bl.histLen)
+}
@@ -0,0 +1,228 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"readpebble/internal/rdb"
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+	"readpebble/pkg/client"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// dump implements DUMP key. Values are serialized in the RDB string encoding
+// so that the payload can be restored by vecble and by Redis alike.
+func (s *Server) dump(args []string) string {
+	payload, _, found, err := s.dumpKey(args[0])
+	if err != nil {
+		return stringError(err)
+	}
+	if !found {
+		return resp.Nil
+	}
+	return resp.BulkString(string(payload))
+}
+
+// dumpKey returns the DUMP payload of key and its metadata. Payloads only
+// hold strings, so keys of other types fail with errWrongType.
+func (s *Server) dumpKey(key string) ([]byte, storage.Meta, bool, error) {
+	meta, exists, err := s.lookup([]byte(key))
+	if err != nil || !exists {
+		return nil, meta, false, err
+	}
+	if storage.HasElements(meta.Type) {
+		return nil, meta, false, errWrongType
+	}
+	value, closer, err := s.db.Get([]byte(key))
+	if err == pebble.ErrNotFound {
+		return nil, meta, false, nil
+	}
+	if err != nil {
+		return nil, meta, false, err
+	}
+	defer closer.Close()
+	return rdb.EncodeDump(value), meta, true, nil
+}
+
+// restore implements RESTORE key ttl payload [REPLACE].
+func (s *Server) restore(args []string) string {
+	key, payload := args[0], args[2]
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || ttl < 0 {
+		return resp.Error("ERR Invalid TTL value, must be >= 0")
+	}
+	replace := false
+	for _, opt := range args[3:] {
+		if strings.ToLower(opt) != "replace" {
+			return resp.Error("ERR syntax error")
+		}
+		replace = true
+	}
+
+	value, err := rdb.DecodeDump([]byte(payload))
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	if !replace {
+		_, exists, err := s.lookup([]byte(key))
+		if err != nil {
+			return resp.Error("ERR Failed to get key: " + err.Error())
+		}
+		if exists {
+			return resp.Error("BUSYKEY Target key name already exists.")
+		}
+	}
+	meta := storage.NewMeta(storage.ObjecTypeString)
+	if ttl > 0 {
+		meta.ExpireAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	batch.Set([]byte(key), value, nil)
+	storage.SetMeta(batch, []byte(key), meta)
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return resp.Error("ERR Failed to set key: " + err.Error())
+	}
+	return resp.OK
+}
+
+// migrate implements
+//
+//	MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE]
+//	        [AUTH password | AUTH2 username password] [KEYS key ...]
+//
+// Each key is dumped, restored on the target over RESP and, unless COPY is
+// given, deleted locally once the target acknowledged it. Servers have a
+// single database, so destination-db must be 0.
+func (s *Server) migrate(args []string) string {
+	host, port, key := args[0], args[1], args[2]
+	if destDB, err := strconv.Atoi(args[3]); err != nil || destDB != 0 {
+		return resp.Error("ERR invalid destination db")
+	}
+	timeoutMs, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil || timeoutMs < 0 {
+		return resp.Error("ERR invalid timeout")
+	}
+	if timeoutMs == 0 {
+		timeoutMs = 1000
+	}
+
+	var copyKeys, replace bool
+	var username, password string
+	keys := []string{key}
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "auth":
+			if i+1 >= len(args) {
+				return resp.Error("ERR syntax error")
+			}
+			password = args[i+1]
+			i++
+		case "auth2":
+			if i+2 >= len(args) {
+				return resp.Error("ERR syntax error")
+			}
+			username, password = args[i+1], args[i+2]
+			i += 2
+		case "keys":
+			if key != "" {
+				return resp.Error("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys = args[i+1:]
+			i = len(args)
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	target := client.NewRemoteClient(client.Options{
+		Addr:        net.JoinHostPort(host, port),
+		Username:    username,
+		Password:    password,
+		PoolSize:    1,
+		DialTimeout: timeout,
+		ReadTimeout: timeout,
+		MaxRetries:  -1,
+	})
+	defer target.Close()
+
+	migrated := 0
+	for _, key := range keys {
+		payload, meta, found, err := s.dumpKey(key)
+		if err != nil {
+			return stringError(err)
+		}
+		if !found {
+			continue
+		}
+		s.io.backgroundRead(len(payload))
+		ttl := int64(0)
+		if !meta.ExpireAt.IsZero() {
+			ttl = max(1, time.Until(meta.ExpireAt).Milliseconds())
+		}
+		restoreArgs := []string{"RESTORE", key, strconv.FormatInt(ttl, 10), string(payload)}
+		if replace {
+			restoreArgs = append(restoreArgs, "REPLACE")
+		}
+		do := target.Do
+		if replace {
+			// Restoring with REPLACE twice leaves the same key.
+			do = target.DoIdempotent
+		}
+		if _, err := do(restoreArgs...); err != nil {
+			if _, ok := err.(client.Error); ok {
+				return resp.Error("ERR Target instance replied with error: " + err.Error())
+			}
+			return resp.Error("IOERR error or timeout migrating to target instance: " + err.Error())
+		}
+		if !copyKeys {
+			batch := s.db.NewBatch()
+			batch.Delete([]byte(key), nil)
+			storage.DeleteMeta(batch, []byte(key))
+			err := batch.Commit(pebble.Sync)
+			batch.Close()
+			if err != nil {
+				return resp.Error("ERR Failed to delete migrated key: " + err.Error())
+			}
+		}
+		migrated++
+	}
+	if migrated == 0 {
+		return resp.SimpleString("NOKEY")
+	}
+	log.Printf("Migrated %d key(s) to %s:%s", migrated, host, port)
+	return resp.OK
+}
@@ -0,0 +1,139 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"runtime/metrics"
+	"strings"
+
+	"readpebble/internal/resp"
+)
+
+// keyspaceEventClasses are the flags of notify-keyspace-events selecting
+// classes of keyspace events, in the order CONFIG GET lists them: generic
+// commands, strings, lists, sets, hashes, sorted sets, expiries, evictions,
+// which vecble never makes, and streams. A stands for all of them, and K
+// and E select the channels events are published to.
+const keyspaceEventClasses = "g$lshzxet"
+
+// ParseKeyspaceEvents checks the flags of notify-keyspace-events and
+// returns them in the order CONFIG GET lists them.
+func ParseKeyspaceEvents(value string) (string, error) {
+	var set [256]bool
+	for i := 0; i < len(value); i++ {
+		switch flag := value[i]; {
+		case flag == 'A':
+			for j := 0; j < len(keyspaceEventClasses); j++ {
+				set[keyspaceEventClasses[j]] = true
+			}
+		case flag == 'K' || flag == 'E' || strings.IndexByte(keyspaceEventClasses, flag) >= 0:
+			set[flag] = true
+		default:
+			return "", fmt.Errorf("%q is not a keyspace event flag", flag)
+		}
+	}
+	var b strings.Builder
+	for _, flag := range []byte(keyspaceEventClasses + "KE") {
+		if set[flag] {
+			b.WriteByte(flag)
+		}
+	}
+	return b.String(), nil
+}
+
+// keyspaceEventClass returns the flag of the class of the events spec
+// raises, 0 for commands that raise none.
+func keyspaceEventClass(spec *commandSpec) byte {
+	switch {
+	case spec.hasCategory("string") || spec.hasCategory("bitmap"):
+		return '$'
+	case spec.hasCategory("list"):
+		return 'l'
+	case spec.hasCategory("set"):
+		return 's'
+	case spec.hasCategory("hash"):
+		return 'h'
+	case spec.hasCategory("sortedset"):
+		return 'z'
+	case spec.hasCategory("stream"):
+		return 't'
+	case spec.hasCategory("keyspace"):
+		return 'g'
+	}
+	return 0
+}
+
+// notifyCommand publishes the keyspace events of spec, a write that
+// succeeded, which are named after the command, for each key it names.
+func (s *Server) notifyCommand(spec *commandSpec, args []string) {
+	if s.live.Load().NotifyKeyspaceEvents == "" {
+		return
+	}
+	class := keyspaceEventClass(spec)
+	if class == 0 {
+		return
+	}
+	keys, _ := getKeys(spec.Name, args)
+	for _, key := range keys {
+		s.notifyKeyspaceEvent(class, spec.Name, key)
+	}
+}
+
+// notifyKeyspaceEvent publishes event on key, of class, to the channels
+// notify-keyspace-events selects, if it selects the class.
+func (s *Server) notifyKeyspaceEvent(class byte, event, key string) {
+	flags := s.live.Load().NotifyKeyspaceEvents
+	if strings.IndexByte(flags, class) < 0 {
+		return
+	}
+	if strings.IndexByte(flags, 'K') >= 0 {
+		s.pubsub.publish("__keyspace@0__:"+key, event)
+	}
+	if strings.IndexByte(flags, 'E') >= 0 {
+		s.pubsub.publish("__keyevent@0__:"+event, key)
+	}
+}
+
+// heapBytes returns the bytes of the heap objects, live or not swept yet,
+// read without stopping the world as runtime.ReadMemStats does.
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// checkMemory returns an -OOM error reply if cmd writes while the heap is
+// over MaxMemory, as Redis replies under its noeviction policy, unless the
+// write frees memory.
+func (s *Server) checkMemory(c *connection, spec *commandSpec, args []string) string {
+	limit := s.live.Load().MaxMemory
+	if limit <= 0 || c.replication || !spec.writes(args) || spaceFreeingCommands[spec.Name] {
+		return ""
+	}
+	if heapBytes() <= uint64(limit) {
+		return ""
+	}
+	return resp.Error("OOM command not allowed when used memory > 'maxmemory'.")
+}
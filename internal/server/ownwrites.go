@@ -0,0 +1,136 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"time"
+
+	"readpebble/internal/collection"
+)
+
+// ownWritesPoll is how often a search waiting for its caller's writes
+// checks whether they were embedded.
+const ownWritesPoll = 10 * time.Millisecond
+
+var errOwnWritesPending = errors.New("writes of this connection are still waiting to be embedded")
+
+// Writes by text are not searchable until their text is embedded, which
+// VADDTEXT ASYNC and the Queue and Defer fallbacks leave to the embedding
+// workers. A connection remembers its latest such write to each
+// collection, so that a search with AFTER_WRITES can wait for it, and for
+// every write queued before it, to be searchable.
+
+// textWrite is the latest write of a connection to a collection whose text
+// awaited embedding: the time it was deferred at, and the number of the
+// latest write queued in memory, 0 if none was.
+type textWrite struct {
+	deferred time.Time
+	queued   int64
+}
+
+// textWritten records a write by c to collection whose text awaits
+// embedding, queued in memory as number seq, or deferred if seq is 0.
+func (c *connection) textWritten(collection string, seq int64) {
+	if c.textWrites == nil {
+		c.textWrites = make(map[string]textWrite)
+	}
+	w := c.textWrites[collection]
+	if seq > 0 {
+		w.queued = seq
+	} else {
+		w.deferred = time.Now()
+	}
+	c.textWrites[collection] = w
+}
+
+// awaitOwnWrites waits up to timeout for the writes c made to collection
+// whose text awaited embedding to be searchable, along with the writes
+// queued before them. The deferred points among those are embedded right
+// away, as the workers would, rather than when the workers get to them.
+// It fails if some are still waiting when the timeout passes.
+func (s *Server) awaitOwnWrites(c *connection, collection string, timeout time.Duration) error {
+	w, ok := c.textWrites[collection]
+	if !ok || s.embedder == nil {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for flush := true; ; flush = false {
+		pending, err := s.ownWritesPending(collection, w, flush)
+		if err != nil {
+			return err
+		}
+		if !pending {
+			delete(c.textWrites, collection)
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return errOwnWritesPending
+		}
+		select {
+		case <-time.After(min(ownWritesPoll, time.Until(deadline))):
+		case <-s.quitCh:
+			return errStopping
+		}
+	}
+}
+
+// ownWritesPending reports whether the writes w describes are still
+// waiting to be embedded. With flush, it first embeds the deferred points
+// among them that no worker is embedding, once each: the ones that fail
+// are left to the workers to retry.
+func (s *Server) ownWritesPending(name string, w textWrite, flush bool) (bool, error) {
+	e := s.embedder
+	queued := w.queued > e.applied.Load()
+	if w.deferred.IsZero() {
+		return queued, nil
+	}
+	tried := make(map[string]bool)
+	for {
+		pending := false
+		var claimed []collection.DeferredPoint
+		e.mutex.Lock()
+		_, _, err := s.collections.Deferred(embedBatch, func(p collection.DeferredPoint) bool {
+			if p.Collection != name || p.QueuedAt.After(w.deferred) {
+				return false
+			}
+			pending = true
+			if _, busy := e.inFlight[deferredID{p.Collection, p.Key}]; busy || !flush || tried[p.Key] {
+				return false
+			}
+			tried[p.Key] = true
+			claimed = append(claimed, p)
+			return true
+		})
+		for _, p := range claimed {
+			e.inFlight[deferredID{p.Collection, p.Key}] = struct{}{}
+		}
+		e.mutex.Unlock()
+		if err != nil || len(claimed) == 0 {
+			return queued || pending, err
+		}
+		s.embedDeferred(claimed)
+		e.release(claimed)
+	}
+}
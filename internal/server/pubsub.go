@@ -0,0 +1,410 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"readpebble/internal/glob"
+	"readpebble/internal/resp"
+)
+
+// Pub/Sub lives in memory only: messages go to the connections subscribed
+// when they are published and are neither stored nor sent to replicas. A
+// subscribed connection gets the messages published to its channels, or
+// to channels matching its patterns, as pushes with RESP3 and as arrays
+// with RESP2, which limits it to the Pub/Sub commands while it has
+// subscriptions.
+
+// subscriberQueue bounds the messages waiting to be sent to a subscriber.
+// One that falls further behind is disconnected, so that PUBLISH never
+// waits for a slow client nor lets its messages pile up in memory.
+const subscriberQueue = 4096
+
+// subscribedCommands are the commands a RESP2 connection may run while it
+// has subscriptions.
+var subscribedCommands = map[string]bool{
+	"subscribe": true, "psubscribe": true, "unsubscribe": true, "punsubscribe": true, "ping": true,
+}
+
+// pubsub is the registry of the subscriptions of every connection.
+type pubsub struct {
+	mutex sync.Mutex
+	// channels and patterns hold the connections subscribed to each
+	// channel and pattern.
+	channels map[string]map[*connection]bool
+	patterns map[string]map[*connection]bool
+}
+
+func newPubSub() *pubsub {
+	return &pubsub{
+		channels: make(map[string]map[*connection]bool),
+		patterns: make(map[string]map[*connection]bool),
+	}
+}
+
+// subscriber is the Pub/Sub state of a connection. Its subscriptions only
+// change on the connection's own goroutine, under the registry's mutex.
+type subscriber struct {
+	channels map[string]bool
+	patterns map[string]bool
+	// messages queues the messages published to the connection until
+	// deliverMessages sends them.
+	messages chan string
+	// overflowed is set once the queue was full and the connection was
+	// closed.
+	overflowed bool
+}
+
+// count returns the number of subscriptions.
+func (sub *subscriber) count() int {
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// subscribedMode reports whether c is limited to subscribedCommands. It is
+// only called on the connection's own goroutine.
+func (c *connection) subscribedMode() bool {
+	return c.protocol < 3 && c.subscriber != nil && c.subscriber.count() > 0
+}
+
+// subscribe subscribes c to a channel, or with pattern set to a pattern,
+// and returns the number of subscriptions of c and whether it was given
+// its subscriber state, whose messages are then to be delivered.
+func (ps *pubsub) subscribe(c *connection, name string, pattern bool) (int, bool) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	created := c.subscriber == nil
+	if created {
+		c.subscriber = &subscriber{
+			channels: make(map[string]bool),
+			patterns: make(map[string]bool),
+			messages: make(chan string, subscriberQueue),
+		}
+	}
+	registry, own := ps.channels, c.subscriber.channels
+	if pattern {
+		registry, own = ps.patterns, c.subscriber.patterns
+	}
+	if registry[name] == nil {
+		registry[name] = make(map[*connection]bool)
+	}
+	registry[name][c] = true
+	own[name] = true
+	return c.subscriber.count(), created
+}
+
+// unsubscribe unsubscribes c from a channel, or with pattern set from a
+// pattern, and returns the number of subscriptions c has left.
+func (ps *pubsub) unsubscribe(c *connection, name string, pattern bool) int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if c.subscriber == nil {
+		return 0
+	}
+	registry, own := ps.channels, c.subscriber.channels
+	if pattern {
+		registry, own = ps.patterns, c.subscriber.patterns
+	}
+	delete(own, name)
+	delete(registry[name], c)
+	if len(registry[name]) == 0 {
+		delete(registry, name)
+	}
+	return c.subscriber.count()
+}
+
+// subscriptions returns the channels, or with pattern set the patterns, c
+// is subscribed to, in order.
+func (ps *pubsub) subscriptions(c *connection, pattern bool) []string {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if c.subscriber == nil {
+		return nil
+	}
+	own := c.subscriber.channels
+	if pattern {
+		own = c.subscriber.patterns
+	}
+	names := make([]string, 0, len(own))
+	for name := range own {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// counts returns the number of channels and patterns c is subscribed to
+// and of messages waiting to be sent to it.
+func (ps *pubsub) counts(c *connection) (channels, patterns, pending int) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if c.subscriber == nil {
+		return 0, 0, 0
+	}
+	return len(c.subscriber.channels), len(c.subscriber.patterns), len(c.subscriber.messages)
+}
+
+// drop removes the subscriptions of c, which closed.
+func (ps *pubsub) drop(c *connection) {
+	for _, pattern := range []bool{false, true} {
+		for _, name := range ps.subscriptions(c, pattern) {
+			ps.unsubscribe(c, name, pattern)
+		}
+	}
+}
+
+// publish queues message for the connections subscribed to channel, once
+// for each of their subscriptions that match, and returns how many times
+// it was queued.
+func (ps *pubsub) publish(channel, message string) int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	receivers := 0
+	// The message is encoded once for each protocol version.
+	var encoded [2]string
+	send := func(c *connection, fields ...string) {
+		v := 0
+		if c.version() >= 3 {
+			v = 1
+		}
+		if encoded[v] == "" {
+			var w resp.Writer
+			writePushHeader(&w, v == 1, len(fields))
+			for _, f := range fields {
+				w.BulkString(f)
+			}
+			encoded[v] = w.String()
+		}
+		if c.subscriber.send(c, encoded[v]) {
+			receivers++
+		}
+	}
+	for c := range ps.channels[channel] {
+		send(c, "message", channel, message)
+	}
+	for pattern, conns := range ps.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		encoded = [2]string{}
+		for c := range conns {
+			send(c, "pmessage", pattern, channel, message)
+		}
+	}
+	return receivers
+}
+
+// send queues msg for c, closing c if its queue is full, and reports
+// whether msg was queued. The registry's mutex must be held.
+func (sub *subscriber) send(c *connection, msg string) bool {
+	select {
+	case sub.messages <- msg:
+		return true
+	default:
+		if !sub.overflowed {
+			sub.overflowed = true
+			log.Printf("Disconnecting subscriber %s: more than %d messages pending", c.conn.RemoteAddr(), subscriberQueue)
+			c.close()
+		}
+		return false
+	}
+}
+
+// version returns the RESP version of c, safe to call from other
+// goroutines than the connection's.
+func (c *connection) version() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.protocol
+}
+
+func writePushHeader(w *resp.Writer, resp3 bool, n int) {
+	if resp3 {
+		w.Push(n)
+	} else {
+		w.Array(n)
+	}
+}
+
+// deliverMessages sends the messages published to c until it closes,
+// those that queued up meanwhile in a single write.
+func (s *Server) deliverMessages(c *connection, sub *subscriber) {
+	for {
+		select {
+		case msg := <-sub.messages:
+			c.writeMutex.Lock()
+			_, err := c.writer.WriteString(msg)
+			for n := len(sub.messages); err == nil && n > 0; n-- {
+				_, err = c.writer.WriteString(<-sub.messages)
+			}
+			if err == nil {
+				err = c.writer.Flush()
+			}
+			c.writeMutex.Unlock()
+			if err != nil {
+				c.close()
+				return
+			}
+		case <-c.done():
+			return
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// subscribe implements SUBSCRIBE channel [channel ...], or with pattern
+// set PSUBSCRIBE pattern [pattern ...], where patterns are globs as KEYS
+// takes them. It confirms each subscription with the number of
+// subscriptions the connection has then. The confirmations are written
+// right away rather than returned, so that no message published once the
+// subscription is made can reach the client before them.
+func (s *Server) subscribe(c *connection, args []string, pattern bool) string {
+	if c.writer == nil {
+		return resp.Error("ERR Pub/Sub is only available on client connections")
+	}
+	kind := "subscribe"
+	if pattern {
+		kind = "psubscribe"
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	var w resp.Writer
+	for _, name := range args {
+		n, created := s.pubsub.subscribe(c, name, pattern)
+		if created {
+			go s.deliverMessages(c, c.subscriber)
+		}
+		writePushHeader(&w, c.protocol >= 3, 3)
+		w.BulkString(kind)
+		w.BulkString(name)
+		w.Integer(int64(n))
+	}
+	// A failed write fails the connection's next one too.
+	c.writer.WriteString(w.String())
+	return ""
+}
+
+// unsubscribe implements UNSUBSCRIBE [channel ...], or with pattern set
+// PUNSUBSCRIBE [pattern ...], unsubscribing from every channel or pattern
+// without arguments. It confirms each as subscribe does, with a nil name
+// if there was none to unsubscribe from.
+func (s *Server) unsubscribe(c *connection, args []string, pattern bool) string {
+	if c.writer == nil {
+		return resp.Error("ERR Pub/Sub is only available on client connections")
+	}
+	kind := "unsubscribe"
+	if pattern {
+		kind = "punsubscribe"
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	names := args
+	if len(names) == 0 {
+		names = s.pubsub.subscriptions(c, pattern)
+	}
+	var w resp.Writer
+	if len(names) == 0 {
+		writePushHeader(&w, c.protocol >= 3, 3)
+		w.BulkString(kind)
+		w.Nil()
+		w.Integer(0)
+	}
+	for _, name := range names {
+		n := s.pubsub.unsubscribe(c, name, pattern)
+		writePushHeader(&w, c.protocol >= 3, 3)
+		w.BulkString(kind)
+		w.BulkString(name)
+		w.Integer(int64(n))
+	}
+	c.writer.WriteString(w.String())
+	return ""
+}
+
+// ping implements PING [message], replying with PONG, or with the message
+// when given. A RESP2 connection in subscribed mode gets a reply in the
+// shape of a message.
+func (s *Server) ping(c *connection, args []string) string {
+	if len(args) > 1 {
+		return resp.Error("ERR wrong number of arguments for 'ping' command")
+	}
+	if !c.subscribedMode() {
+		if len(args) == 1 {
+			return resp.BulkString(args[0])
+		}
+		return resp.Pong
+	}
+	message := ""
+	if len(args) > 0 {
+		message = args[0]
+	}
+	return resp.StringArray([]string{"pong", message})
+}
+
+// publish implements PUBLISH channel message, replying with the number of
+// subscriptions it was sent for.
+func (s *Server) publish(args []string) string {
+	return resp.Integer(int64(s.pubsub.publish(args[0], args[1])))
+}
+
+// pubsubCommand implements PUBSUB CHANNELS [pattern], listing the channels
+// with subscribers, NUMSUB [channel ...], replying with the number of
+// subscribers of each channel, and NUMPAT, replying with the number of
+// patterns subscribed to.
+func (s *Server) pubsubCommand(c *connection, args []string) string {
+	ps := s.pubsub
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "channels" && len(args) <= 2:
+		var channels []string
+		for channel := range ps.channels {
+			if len(args) == 1 || glob.Match(args[1], channel) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		return resp.StringArray(channels)
+	case sub == "numsub":
+		var w resp.Writer
+		if c.protocol >= 3 {
+			w.Map(len(args) - 1)
+		} else {
+			w.Array(2 * (len(args) - 1))
+		}
+		for _, channel := range args[1:] {
+			w.BulkString(channel)
+			w.Integer(int64(len(ps.channels[channel])))
+		}
+		return w.String()
+	case sub == "numpat" && len(args) == 1:
+		return resp.Integer(int64(len(ps.patterns)))
+	case sub == "channels" || sub == "numpat":
+		return resp.Error("ERR wrong number of arguments for 'pubsub|" + sub + "' command")
+	}
+	return resp.Error("ERR unknown PUBSUB subcommand '" + args[0] + "'")
+}
@@ -0,0 +1,283 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+)
+
+const (
+	// defaultQueryLimit is how many points a query stage keeps without a
+	// limit, and maxQueryStages how many stages a query may have.
+	defaultQueryLimit = 10
+	maxQueryStages    = 64
+	// rrfK damps the weight reciprocal rank fusion gives the first ranks,
+	// with the value its authors found to work best.
+	rrfK = 60
+)
+
+// queryStage is a stage of a VQUERY query. A stage without prefetch
+// searches the collection: for the points closest to its vector, or to
+// its text once embedded, or for the points matching its filter if it
+// has neither. A stage with prefetch runs its prefetch stages and ranks
+// the points they found instead: with fusion, by combining the ranks
+// each gave them, or by their distance to its vector or text, which
+// makes it a rerank. Either way only the points matching its filter are
+// kept, up to its limit.
+type queryStage struct {
+	Prefetch []*queryStage `json:"prefetch,omitempty"`
+	Vector   []float64     `json:"vector,omitempty"`
+	Text     string        `json:"text,omitempty"`
+	Filter   string        `json:"filter,omitempty"`
+	// Fusion is how the ranks of the prefetch stages combine: only "rrf",
+	// reciprocal rank fusion, is supported.
+	Fusion string `json:"fusion,omitempty"`
+	Limit  *int   `json:"limit,omitempty"`
+	EF     int    `json:"ef,omitempty"`
+
+	filter *collection.Filter
+}
+
+// parseQuery decodes and checks a query, returning its stages in the
+// order they are checked, the query first.
+func parseQuery(data string) (*queryStage, []*queryStage, error) {
+	var q queryStage
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&q); err != nil {
+		return nil, nil, fmt.Errorf("invalid query: %v", err)
+	}
+	stages := []*queryStage{&q}
+	for i := 0; i < len(stages); i++ {
+		if len(stages) > maxQueryStages {
+			return nil, nil, fmt.Errorf("a query has at most %d stages", maxQueryStages)
+		}
+		if err := stages[i].check(); err != nil {
+			return nil, nil, err
+		}
+		stages = append(stages, stages[i].Prefetch...)
+	}
+	return &q, stages, nil
+}
+
+// check validates the stage, parsing its filter.
+func (st *queryStage) check() error {
+	ranked := st.Vector != nil || st.Text != ""
+	switch {
+	case st.Vector != nil && st.Text != "":
+		return errors.New("a query stage has a vector or a text, not both")
+	case st.Fusion != "" && st.Fusion != "rrf":
+		return fmt.Errorf("unknown fusion '%s'", st.Fusion)
+	case st.Fusion != "" && ranked:
+		return errors.New("a query stage ranks by fusion or by a vector, not both")
+	case st.Fusion != "" && len(st.Prefetch) == 0:
+		return errors.New("fusion needs prefetch stages")
+	case len(st.Prefetch) > 0 && st.Fusion == "" && !ranked:
+		return errors.New("a query stage with prefetch needs a fusion, a vector or a text to rank by")
+	case len(st.Prefetch) == 0 && !ranked && st.Filter == "":
+		return errors.New("a query stage needs a vector, a text, a filter or prefetch stages")
+	case st.Limit != nil && *st.Limit < 0:
+		return errors.New("limit must be a non-negative integer")
+	case st.EF < 0:
+		return errors.New("ef must be a positive integer")
+	}
+	if st.Filter != "" {
+		filter, err := collection.ParseFilter(st.Filter)
+		if err != nil {
+			return err
+		}
+		st.filter = filter
+	}
+	return nil
+}
+
+func (st *queryStage) limit() int {
+	if st.Limit == nil {
+		return defaultQueryLimit
+	}
+	return *st.Limit
+}
+
+// vquery implements VQUERY collection query, running a query of several
+// stages, given as JSON (see queryStage), in one round trip: for
+// instance, a fusion of the points closest to a vector, those closest to
+// a text and those matching a filter, or a rerank of the points closest
+// to a coarse vector. It replies, as VSEARCH does, with the ids and
+// scores of the points the query stage kept, in rank order: the score is
+// the distance for a stage ranking by vector and the fused score, higher
+// first, for a fusion stage. Texts are embedded in a single call to the
+// embedding provider. The query runs on this node only, even in cluster
+// mode.
+func (s *Server) vquery(args []string) string {
+	defer s.io.foregroundRead()()
+	c, err := s.collections.Get(args[0])
+	if err != nil {
+		return collectionError(err)
+	}
+	q, stages, err := parseQuery(args[1])
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	if err := s.embedStages(stages); err != nil {
+		return embedError(err)
+	}
+	for _, st := range stages {
+		if st.Vector != nil && len(st.Vector) != c.Dimension {
+			return resp.Errorf("ERR expected %d vector components", c.Dimension)
+		}
+	}
+	results, err := s.runStage(c.Name, q)
+	if err != nil {
+		return collectionError(err)
+	}
+	var w resp.Writer
+	w.Array(2 * len(results))
+	for _, r := range results {
+		w.BulkString(r.ID)
+		w.BulkString(strconv.FormatFloat(r.Score, 'g', -1, 64))
+	}
+	return w.String()
+}
+
+// embedStages sets the vectors of the stages that have a text to the
+// vectors the texts embed into.
+func (s *Server) embedStages(stages []*queryStage) error {
+	var texts []string
+	var embedded []*queryStage
+	for _, st := range stages {
+		if st.Text != "" {
+			texts = append(texts, st.Text)
+			embedded = append(embedded, st)
+		}
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	if s.embedder == nil {
+		return errors.New("no embedding provider configured")
+	}
+	vectors, err := s.embedder.embed(context.Background(), texts)
+	if err != nil {
+		return err
+	}
+	for i, st := range embedded {
+		st.Vector = vectors[i]
+	}
+	return nil
+}
+
+// runStage runs a query stage and the stages it prefetches from.
+func (s *Server) runStage(name string, st *queryStage) ([]collection.Result, error) {
+	limit := st.limit()
+	if len(st.Prefetch) == 0 {
+		if st.Vector == nil {
+			keys, _, err := s.collections.Scroll(name, 0, limit, st.filter)
+			if err != nil {
+				return nil, err
+			}
+			results := make([]collection.Result, len(keys))
+			for i, key := range keys {
+				results[i] = collection.Result{ID: key}
+			}
+			return results, nil
+		}
+		results, _, err := s.collections.Search(name, st.Vector, collection.SearchOptions{
+			K:      limit,
+			EF:     st.EF,
+			Filter: st.filter,
+		})
+		return results, err
+	}
+
+	branches := make([][]collection.Result, len(st.Prefetch))
+	var keys []string
+	for i, prefetch := range st.Prefetch {
+		results, err := s.runStage(name, prefetch)
+		if err != nil {
+			return nil, err
+		}
+		branches[i] = results
+		for _, r := range results {
+			keys = append(keys, r.ID)
+		}
+	}
+	var results []collection.Result
+	if st.Fusion != "" {
+		fused := fuseRanks(branches)
+		keys = keys[:0]
+		for _, r := range fused {
+			keys = append(keys, r.ID)
+		}
+		kept, err := s.collections.Rank(name, nil, keys, st.filter)
+		if err != nil {
+			return nil, err
+		}
+		scores := make(map[string]float64, len(fused))
+		for _, r := range fused {
+			scores[r.ID] = r.Score
+		}
+		for i := range kept {
+			kept[i].Score = scores[kept[i].ID]
+		}
+		results = kept
+	} else {
+		var err error
+		if results, err = s.collections.Rank(name, st.Vector, keys, st.filter); err != nil {
+			return nil, err
+		}
+	}
+	return results[:min(limit, len(results))], nil
+}
+
+// fuseRanks combines rankings by reciprocal rank fusion: a point scores
+// 1 / (rrfK + rank) for each ranking it appears in, rank counting from 1.
+// Points are returned highest score first, ties in the order they were
+// first seen.
+func fuseRanks(rankings [][]collection.Result) []collection.Result {
+	var fused []collection.Result
+	index := make(map[string]int)
+	for _, ranking := range rankings {
+		for rank, r := range ranking {
+			i, ok := index[r.ID]
+			if !ok {
+				i = len(fused)
+				index[r.ID] = i
+				fused = append(fused, collection.Result{ID: r.ID})
+			}
+			fused[i].Score += 1 / float64(rrfK+rank+1)
+		}
+	}
+	slices.SortStableFunc(fused, func(a, b collection.Result) int { return cmp.Compare(b.Score, a.Score) })
+	return fused
+}
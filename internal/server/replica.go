@@ -0,0 +1,673 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"readpebble/internal/rdb"
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+
+	"github.com/cockroachdb/pebble"
+)
+
+const (
+	replicaAckInterval = time.Second
+	replicaLoadBatch   = 1000
+	// replicaQueuedOps bounds the received commands waiting to be applied,
+	// on top of the ReplicationWindow bound on their bytes.
+	replicaQueuedOps = 10000
+)
+
+// replica follows a Redis master: it performs the PSYNC handshake, loads
+// the RDB snapshot into Pebble and then applies the command stream,
+// acknowledging the processed offset so the master can track it.
+type replica struct {
+	server *Server
+	addr   string
+	stopCh chan struct{}
+
+	mutex  sync.Mutex
+	state  string
+	replID string
+	// loading is set while a snapshot from the master is loaded, and
+	// stays set if loading it failed, until a later one is loaded.
+	loading bool
+	// offset is the replication offset of the last byte applied.
+	offset int64
+	// lastContact is when the master last sent anything on the stream.
+	lastContact time.Time
+	// received is the replication offset of the last byte received, and
+	// queuedBytes and queuedOps the commands received but not applied.
+	// The receiving goroutine waits on window while queuedBytes exceeds
+	// the ReplicationWindow; paused is set while it does and pauses
+	// counts how many times it did.
+	received    int64
+	queuedBytes int64
+	queuedOps   int
+	window      *sync.Cond
+	paused      bool
+	pauses      int64
+	conn        net.Conn
+}
+
+// replicaOf implements REPLICAOF host port and REPLICAOF NO ONE. Commands
+// flagged "write" are refused from clients while a master is followed,
+// since its stream is the only source of writes.
+func (s *Server) replicaOf(args []string) string {
+	s.replicaMutex.Lock()
+	defer s.replicaMutex.Unlock()
+
+	if strings.ToLower(args[0]) == "no" && strings.ToLower(args[1]) == "one" {
+		if s.replica != nil {
+			s.replica.stop()
+			s.replica = nil
+			log.Println("Replication stopped, now serving writes")
+		}
+		return resp.OK
+	}
+	if _, err := strconv.Atoi(args[1]); err != nil {
+		return resp.Error("ERR Invalid master port")
+	}
+	if s.replica != nil {
+		s.replica.stop()
+	}
+	s.backlog.reset()
+	s.replica = &replica{
+		server: s,
+		addr:   net.JoinHostPort(args[0], args[1]),
+		stopCh: make(chan struct{}),
+		state:  "connect",
+		offset: -1,
+	}
+	r := s.replica
+	r.window = sync.NewCond(&r.mutex)
+	s.goTracked(subsystemReplication, r.run)
+	return resp.OK
+}
+
+func (s *Server) isReplica() bool {
+	s.replicaMutex.Lock()
+	defer s.replicaMutex.Unlock()
+	return s.replica != nil
+}
+
+func (r *replica) stop() {
+	close(r.stopCh)
+	r.mutex.Lock()
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.mutex.Unlock()
+}
+
+func (r *replica) stopped() bool {
+	select {
+	case <-r.stopCh:
+		return true
+	case <-r.server.quitCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *replica) setState(state string) {
+	r.mutex.Lock()
+	r.state = state
+	r.mutex.Unlock()
+}
+
+// run keeps a replication link to the master, reconnecting with backoff.
+func (r *replica) run() {
+	var delay time.Duration
+	for !r.stopped() {
+		err := r.sync()
+		if r.stopped() {
+			return
+		}
+		r.setState("connect")
+		delay = nextAcceptDelay(delay)
+		delay = max(delay, 100*time.Millisecond)
+		log.Printf("Replication link with %s lost: %v; reconnecting in %v", r.addr, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-r.stopCh:
+		case <-r.server.quitCh:
+		}
+	}
+}
+
+// countingReader counts the bytes read from the master connection.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (r *replica) sync() error {
+	conn, err := net.DialTimeout("tcp", r.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.conn = conn
+	r.mutex.Unlock()
+	defer conn.Close()
+	if r.stopped() {
+		return nil
+	}
+
+	counter := &countingReader{r: conn}
+	reader := bufio.NewReader(counter)
+	link := &replicaLink{conn: conn, reader: reader}
+
+	if _, err := link.call("PING"); err != nil {
+		return err
+	}
+	_, port, _ := net.SplitHostPort(r.server.config.Addr)
+	if _, err := link.call("REPLCONF", "listening-port", port); err != nil {
+		return err
+	}
+	if _, err := link.call("REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	replID, offset := r.replID, r.offset
+	r.mutex.Unlock()
+	psyncArgs := []string{"PSYNC", "?", "-1"}
+	if replID != "" {
+		psyncArgs = []string{"PSYNC", replID, strconv.FormatInt(offset+1, 10)}
+	}
+	reply, err := link.call(psyncArgs...)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad FULLRESYNC reply %q", reply)
+		}
+		r.mutex.Lock()
+		r.state, r.loading = "sync", true
+		r.mutex.Unlock()
+		if err := r.loadSnapshot(reader); err != nil {
+			return err
+		}
+		r.mutex.Lock()
+		r.replID, r.offset = fields[1], offset
+		r.loading = false
+		r.mutex.Unlock()
+		log.Printf("Full resynchronization with %s done, replication id %s offset %d", r.addr, fields[1], offset)
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		if len(fields) == 2 {
+			r.mutex.Lock()
+			r.replID = fields[1]
+			r.mutex.Unlock()
+		}
+		log.Printf("Partial resynchronization with %s accepted", r.addr)
+	default:
+		return fmt.Errorf("unexpected PSYNC reply %q", reply)
+	}
+
+	r.mutex.Lock()
+	r.state = "connected"
+	r.lastContact = time.Now()
+	r.received = r.offset
+	r.queuedBytes, r.queuedOps = 0, 0
+	r.mutex.Unlock()
+	ackDone := make(chan struct{})
+	defer close(ackDone)
+	go r.ackLoop(link, ackDone)
+	return r.stream(link, counter)
+}
+
+// loadSnapshot replaces the local keyspace with the RDB payload sent by the
+// master. Only database 0 and string values are applied, since vecble has
+// a single keyspace and no other Redis types yet; everything else is
+// counted and reported.
+func (r *replica) loadSnapshot(reader *bufio.Reader) error {
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(header, "$")), 10, 64)
+	if err != nil {
+		return fmt.Errorf("unsupported snapshot transfer header %q", header)
+	}
+
+	db := r.server.db
+	// The function libraries stored go with the rest.
+	functions := r.server.functions
+	functions.mutex.Lock()
+	err = deleteAllKeys(db)
+	functions.clear()
+	functions.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	body := io.LimitReader(reader, size)
+	batch := db.NewBatch()
+	// commit commits the batch once it is full.
+	commit := func() error {
+		if batch.Count() < replicaLoadBatch {
+			return nil
+		}
+		if err := batch.Commit(pebble.NoSync); err != nil {
+			return err
+		}
+		batch = db.NewBatch()
+		return nil
+	}
+	var loaded, stored, skipped, expired int
+	err = rdb.Parse(body, func(entry rdb.Entry) error {
+		switch {
+		case entry.Type == rdb.TypeAux:
+			// What a vecble master sends as it is stored (see
+			// sendSnapshot); the fields a Redis master sends are ignored.
+			key, ok := bytes.CutPrefix(entry.Key, []byte(snapshotAuxPrefix))
+			if !ok {
+				return nil
+			}
+			if err := batch.Set(key, entry.Value, nil); err != nil {
+				return err
+			}
+			stored++
+			return commit()
+		case entry.DB != 0 || entry.Type != rdb.TypeString:
+			skipped++
+			return nil
+		case !entry.ExpireAt.IsZero() && entry.ExpireAt.Before(time.Now()):
+			expired++
+			return nil
+		}
+		if err := batch.Set(entry.Key, entry.Value, nil); err != nil {
+			return err
+		}
+		meta := storage.NewMeta(storage.ObjecTypeString)
+		meta.ExpireAt = entry.ExpireAt
+		if err := storage.SetMeta(batch, entry.Key, meta); err != nil {
+			return err
+		}
+		loaded++
+		return commit()
+	})
+	if err != nil {
+		batch.Close()
+		return fmt.Errorf("failed to load snapshot: %w", err)
+	}
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+	io.Copy(io.Discard, body)
+	// Collections and function libraries came with the keyspace.
+	if err := r.server.collections.Reload(); err != nil {
+		return fmt.Errorf("failed to reload collections: %w", err)
+	}
+	if err := functions.load(db); err != nil {
+		return fmt.Errorf("failed to load function libraries: %w", err)
+	}
+	log.Printf("Loaded %d keys and %d stored entries from master snapshot (%d non-string or non-zero db keys skipped, %d already expired)",
+		loaded, stored, skipped, expired)
+	return nil
+}
+
+// replicaEntry is a command received from the master, waiting to be
+// applied.
+type replicaEntry struct {
+	cmd  string
+	args []string
+	// size is how many bytes the command took on the wire.
+	size int64
+}
+
+// stream applies the master's command stream, advancing the offset by the
+// exact number of bytes each command took on the wire.
+//
+// Commands are received and applied by separate goroutines, so a burst of
+// slow commands does not stop the replica from keeping up with the stream.
+// The received commands not applied yet are bounded by ReplicationWindow
+// bytes: past it, the replica stops reading from the connection until it
+// catches up, which pauses the master's stream through TCP flow control
+// while the master keeps taking writes and buffers them.
+func (r *replica) stream(link *replicaLink, counter *countingReader) error {
+	entries := make(chan replicaEntry, replicaQueuedOps)
+	received := make(chan error, 1)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		r.mutex.Lock()
+		r.queuedBytes, r.queuedOps = 0, 0
+		r.window.Broadcast()
+		r.mutex.Unlock()
+	}()
+	go func() {
+		received <- r.receive(link, counter, entries, done)
+	}()
+
+	c := &connection{conn: link.conn, protocol: 2, admin: true, authenticated: true, replication: true, createdAt: time.Now()}
+	db := 0
+	for {
+		var e replicaEntry
+		select {
+		case e = <-entries:
+		case err := <-received:
+			return err
+		}
+		switch e.cmd {
+		case "ping", "multi", "exec":
+		case "select":
+			if len(e.args) == 1 {
+				db, _ = strconv.Atoi(e.args[0])
+			}
+		case "replconf":
+			if len(e.args) > 0 && strings.ToLower(e.args[0]) == "getack" {
+				// The ACK reports the offset before this GETACK, as
+				// Redis replicas do.
+				link.ack(r.currentOffset())
+			}
+		default:
+			if db == 0 {
+				if reply := r.server.handleCommand(c, e.cmd, e.args); strings.HasPrefix(reply, "-") {
+					log.Printf("Replicated %s failed: %s", e.cmd, strings.TrimSpace(reply))
+				}
+			}
+		}
+
+		r.mutex.Lock()
+		r.offset += e.size
+		r.queuedBytes -= e.size
+		r.queuedOps--
+		r.window.Broadcast()
+		r.mutex.Unlock()
+	}
+}
+
+// receive reads commands from the master into entries until the connection
+// fails or done is closed, waiting while the window is full.
+func (r *replica) receive(link *replicaLink, counter *countingReader, entries chan<- replicaEntry, done <-chan struct{}) error {
+	window := r.server.config.ReplicationWindow
+	reader := resp.NewReader(link.reader)
+	for {
+		before := counter.n - int64(link.reader.Buffered())
+		cmd, args, err := readCommand(reader)
+		if err != nil {
+			return err
+		}
+		size := counter.n - int64(link.reader.Buffered()) - before
+
+		r.mutex.Lock()
+		r.received += size
+		r.lastContact = time.Now()
+		if r.queuedBytes >= window {
+			r.pauses++
+			r.paused = true
+			for r.queuedBytes >= window {
+				r.window.Wait()
+			}
+			r.paused = false
+		}
+		r.queuedBytes += size
+		r.queuedOps++
+		r.mutex.Unlock()
+
+		select {
+		case entries <- replicaEntry{cmd: cmd, args: args, size: size}:
+		case <-done:
+			return nil
+		}
+	}
+}
+
+func (r *replica) currentOffset() int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.offset
+}
+
+// lag reports how long ago the master was last heard from and whether the
+// replica is streaming from it. Masters ping their replicas periodically
+// (every 10 seconds by default), so an idle master can appear up to that
+// far behind.
+func (r *replica) lag() (time.Duration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return time.Since(r.lastContact), r.state == "connected"
+}
+
+// replicationStatus is a snapshot of the replication link.
+type replicationStatus struct {
+	master      string
+	state       string
+	lastContact time.Time
+	received    int64
+	applied     int64
+	queuedBytes int64
+	queuedOps   int
+	paused      bool
+	pauses      int64
+	loading     bool
+}
+
+func (r *replica) status() replicationStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return replicationStatus{
+		master:      r.addr,
+		state:       r.state,
+		lastContact: r.lastContact,
+		received:    r.received,
+		applied:     r.offset,
+		queuedBytes: r.queuedBytes,
+		queuedOps:   r.queuedOps,
+		paused:      r.paused,
+		pauses:      r.pauses,
+		loading:     r.loading,
+	}
+}
+
+// replicationStatus returns the status of the link with the master, or
+// false when the server is not a replica.
+func (s *Server) replicationStatus() (replicationStatus, bool) {
+	s.replicaMutex.Lock()
+	r := s.replica
+	s.replicaMutex.Unlock()
+	if r == nil {
+		return replicationStatus{}, false
+	}
+	return r.status(), true
+}
+
+// checkLoading refuses commands not flagged "loading" while the server, a
+// replica, loads a snapshot of its master, since the keyspace holds only
+// part of the dataset until it is done. The master's command stream is
+// only applied once it is.
+func (s *Server) checkLoading(c *connection, spec *commandSpec) string {
+	if c.replication || spec.hasFlag("loading") {
+		return ""
+	}
+	s.replicaMutex.Lock()
+	r := s.replica
+	s.replicaMutex.Unlock()
+	if r == nil {
+		return ""
+	}
+	r.mutex.Lock()
+	loading := r.loading
+	r.mutex.Unlock()
+	if loading {
+		return resp.Error("LOADING the dataset is being loaded from the master at " + r.addr)
+	}
+	return ""
+}
+
+// replication implements REPLICATION INFO. On a replica it describes the
+// link with the master: how far the replica got in the stream and how much
+// of it was received but not applied yet, in bytes and commands. On a
+// master it describes the replicas and the backlog.
+func (s *Server) replication(args []string) string {
+	if strings.ToLower(args[0]) != "info" || len(args) != 1 {
+		return resp.Error("ERR unknown REPLICATION subcommand '" + args[0] + "'")
+	}
+	var b strings.Builder
+	s.infoReplication(&b)
+	return resp.BulkString(b.String())
+}
+
+// infoReplication reports the state of the link to the master on a
+// replica, and the replicas attached on a master.
+func (s *Server) infoReplication(b *strings.Builder) {
+	st, ok := s.replicationStatus()
+	if !ok {
+		s.infoFollowers(b)
+		return
+	}
+	link := "down"
+	if st.state == "connected" {
+		link = "up"
+	}
+	paused, loading := 0, 0
+	if st.paused {
+		paused = 1
+	}
+	if st.loading {
+		loading = 1
+	}
+	fmt.Fprintf(b, "role:replica\r\n")
+	fmt.Fprintf(b, "master:%s\r\n", st.master)
+	fmt.Fprintf(b, "master_link_status:%s\r\n", link)
+	fmt.Fprintf(b, "master_sync_state:%s\r\n", st.state)
+	fmt.Fprintf(b, "loading:%d\r\n", loading)
+	if !st.lastContact.IsZero() {
+		fmt.Fprintf(b, "master_last_io_ms_ago:%d\r\n", time.Since(st.lastContact).Milliseconds())
+	}
+	fmt.Fprintf(b, "received_offset:%d\r\n", st.received)
+	fmt.Fprintf(b, "applied_offset:%d\r\n", st.applied)
+	fmt.Fprintf(b, "lag_bytes:%d\r\n", st.queuedBytes)
+	fmt.Fprintf(b, "lag_ops:%d\r\n", st.queuedOps)
+	fmt.Fprintf(b, "window_bytes:%d\r\n", s.config.ReplicationWindow)
+	fmt.Fprintf(b, "stream_paused:%d\r\n", paused)
+	fmt.Fprintf(b, "stream_pauses:%d\r\n", st.pauses)
+}
+
+func (r *replica) ackLoop(link *replicaLink, done chan struct{}) {
+	ticker := time.NewTicker(replicaAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := link.ack(r.currentOffset()); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// replicaLink is the connection to the master. Writes are serialized since
+// ACKs are sent both periodically and in response to GETACK.
+type replicaLink struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex
+}
+
+func (l *replicaLink) write(args ...string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err := l.conn.Write([]byte(resp.StringArray(args)))
+	return err
+}
+
+// call sends a handshake command and returns its single-line reply.
+func (l *replicaLink) call(args ...string) (string, error) {
+	l.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer l.conn.SetDeadline(time.Time{})
+	if err := l.write(args...); err != nil {
+		return "", err
+	}
+	for {
+		line, err := l.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimSpace(line)
+		// Masters send newlines as keepalives while preparing the
+		// snapshot.
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "-") {
+			return "", fmt.Errorf("master replied to %s: %s", args[0], line[1:])
+		}
+		return strings.TrimPrefix(line, "+"), nil
+	}
+}
+
+func (l *replicaLink) ack(offset int64) error {
+	return l.write("REPLCONF", "ACK", strconv.FormatInt(offset, 10))
+}
+
+// deleteAllKeys removes every key from db in batches.
+func deleteAllKeys(db *pebble.DB) error {
+	iter, err := db.NewIter(nil)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	batch := db.NewBatch()
+	for iter.First(); iter.Valid(); iter.Next() {
+		if err := batch.Delete(iter.Key(), nil); err != nil {
+			return err
+		}
+		if batch.Count() >= replicaLoadBatch {
+			if err := batch.Commit(pebble.NoSync); err != nil {
+				return err
+			}
+			batch = db.NewBatch()
+		}
+	}
+	return batch.Commit(pebble.Sync)
+}
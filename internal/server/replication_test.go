@@ -0,0 +1,136 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// fullResync sends a snapshot of master's keyspace to a new replica, as a
+// full resynchronization does, and returns the replica.
+func fullResync(t *testing.T, master *Server) *Server {
+	t.Helper()
+	snap := master.db.NewSnapshot()
+	defer snap.Close()
+	var payload bytes.Buffer
+	if err := master.sendSnapshot(snap, bufio.NewWriter(&payload)); err != nil {
+		t.Fatal(err)
+	}
+	s := newBenchServer(t)
+	r := &replica{server: s}
+	if err := r.loadSnapshot(bufio.NewReader(&payload)); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// replicationCase is commands run on the master and the reply expected
+// from the replica to a check after the full resynchronization.
+type replicationCase struct {
+	writes [][]string
+	check  []string
+	want   string
+}
+
+func testFullResync(t *testing.T, cases []replicationCase) {
+	master := newBenchServer(t)
+	c := &connection{protocol: 2}
+	for _, test := range cases {
+		for _, write := range test.writes {
+			if reply := master.handleCommand(c, write[0], write[1:]); strings.HasPrefix(reply, "-") {
+				t.Fatalf("%q: %q", write, reply)
+			}
+		}
+	}
+	replica := fullResync(t, master)
+	for _, test := range cases {
+		if got := replica.handleCommand(c, test.check[0], test.check[1:]); got != test.want {
+			t.Errorf("%q after %q = %q, want %q", test.check, test.writes, got, test.want)
+		}
+	}
+}
+
+func TestFullResync(t *testing.T) {
+	testFullResync(t, []replicationCase{
+		{[][]string{{"set", "greeting", "hello"}}, []string{"get", "greeting"}, "$5\r\nhello\r\n"},
+		{[][]string{{"set", "session", "token"}, {"expire", "session", "100"}}, []string{"ttl", "session"}, ":100\r\n"},
+		{[][]string{{"set", "gone", "soon"}, {"pexpire", "gone", "1"}}, []string{"exists", "gone"}, ":0\r\n"},
+		{[][]string{{"vcreate", "docs", "DIM", "2"}}, []string{"vcount", "docs"}, ":0\r\n"},
+		{[][]string{{"vcreate", "notes", "DIM", "2"}, {"vadd", "notes", "a", "1", "0"}}, []string{"vcount", "notes"}, ":1\r\n"},
+		{
+			[][]string{{"function", "load", "#!lua name=lib\nredis.register_function('peek', function(keys) return redis.call('GET', keys[1]) end)"}},
+			[]string{"fcall", "peek", "1", "greeting"},
+			"$5\r\nhello\r\n",
+		},
+	})
+}
+
+func TestFullResyncHash(t *testing.T) {
+	testFullResync(t, []replicationCase{
+		{[][]string{{"hset", "user", "name", "ada", "visits", "1"}, {"hincrby", "user", "visits", "2"}}, []string{"hget", "user", "name"}, "$3\r\nada\r\n"},
+		{nil, []string{"hget", "user", "visits"}, "$1\r\n3\r\n"},
+		{nil, []string{"hgetall", "user"}, "*4\r\n$4\r\nname\r\n$3\r\nada\r\n$6\r\nvisits\r\n$1\r\n3\r\n"},
+		{[][]string{{"hset", "temp", "f", "v"}, {"expire", "temp", "100"}}, []string{"ttl", "temp"}, ":100\r\n"},
+	})
+}
+
+func TestFullResyncList(t *testing.T) {
+	testFullResync(t, []replicationCase{
+		{[][]string{{"lpush", "queue", "a", "b", "c"}, {"ltrim", "queue", "0", "1"}}, []string{"lrange", "queue", "0", "-1"}, "*2\r\n$1\r\nc\r\n$1\r\nb\r\n"},
+		{nil, []string{"lpush", "queue", "d"}, ":3\r\n"},
+		{nil, []string{"lindex", "queue", "-1"}, "$1\r\nb\r\n"},
+	})
+}
+
+func TestFullResyncSet(t *testing.T) {
+	testFullResync(t, []replicationCase{
+		{[][]string{{"sadd", "tags", "red", "green", "blue"}, {"srem", "tags", "green"}}, []string{"scard", "tags"}, ":2\r\n"},
+		{nil, []string{"sismember", "tags", "red"}, ":1\r\n"},
+		{nil, []string{"sismember", "tags", "green"}, ":0\r\n"},
+		{nil, []string{"sadd", "tags", "red", "white"}, ":1\r\n"},
+	})
+}
+
+func TestFullResyncZSet(t *testing.T) {
+	testFullResync(t, []replicationCase{
+		{[][]string{{"zadd", "board", "3", "carol", "1", "alice", "2", "bob"}, {"zadd", "board", "5", "alice"}}, []string{"zrange", "board", "0", "-1"}, "*3\r\n$3\r\nbob\r\n$5\r\ncarol\r\n$5\r\nalice\r\n"},
+		{nil, []string{"zscore", "board", "alice"}, "$1\r\n5\r\n"},
+		{nil, []string{"zrank", "board", "carol"}, ":1\r\n"},
+		{nil, []string{"zcard", "board"}, ":3\r\n"},
+	})
+}
+
+func TestFullResyncStream(t *testing.T) {
+	testFullResync(t, []replicationCase{
+		{[][]string{{"xadd", "events", "1-1", "kind", "start"}, {"xadd", "events", "2-1", "kind", "stop"}}, []string{"xrange", "events", "-", "+"},
+			"*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$4\r\nkind\r\n$5\r\nstart\r\n*2\r\n$3\r\n2-1\r\n*2\r\n$4\r\nkind\r\n$4\r\nstop\r\n"},
+		{nil, []string{"xadd", "events", "2-1", "kind", "again"}, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{[][]string{{"xgroup", "create", "events", "workers", "0"}, {"xreadgroup", "GROUP", "workers", "w1", "COUNT", "1", "STREAMS", "events", ">"}}, []string{"xack", "events", "workers", "1-1"}, ":1\r\n"},
+		{nil, []string{"xreadgroup", "GROUP", "workers", "w1", "STREAMS", "events", ">"},
+			"*1\r\n*2\r\n$6\r\nevents\r\n*1\r\n*2\r\n$3\r\n2-1\r\n*2\r\n$4\r\nkind\r\n$4\r\nstop\r\n"},
+	})
+}
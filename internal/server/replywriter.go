@@ -0,0 +1,128 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"io"
+	"net"
+	"unsafe"
+)
+
+// replyDirectSize is the size from which a reply is not copied into the
+// reply buffer but written from its own memory, along with the buffer, by
+// a vectored write.
+const replyDirectSize = 4 << 10
+
+// replyWriter batches the replies of a connection until they are flushed,
+// like a bufio.Writer, but without copying large replies: a reply of
+// replyDirectSize bytes or more, such as a large GET, is queued as is
+// after the replies buffered before it, and the flush sends everything
+// queued with a single writev instead of one write per buffer's worth.
+type replyWriter struct {
+	conn io.Writer
+	// buffer backs buf, which holds the replies buffered since the last
+	// queued segment.
+	buffer []byte
+	buf    []byte
+	// queued are the segments to send before buf, in order.
+	queued net.Buffers
+	// vectored counts the flushes that sent more than one segment.
+	vectored func()
+}
+
+func newReplyWriter(conn io.Writer, vectored func()) *replyWriter {
+	buffer := make([]byte, 0, replyBufferSize)
+	return &replyWriter{conn: conn, buffer: buffer, buf: buffer, vectored: vectored}
+}
+
+// WriteString queues reply. It returns an error only if it had to flush
+// and the flush failed.
+func (w *replyWriter) WriteString(reply string) (int, error) {
+	if len(reply) >= replyDirectSize {
+		if len(w.buf) > 0 {
+			w.queued = append(w.queued, w.buf)
+			// The rest of the buffer stays free for the replies to come.
+			w.buf = w.buf[len(w.buf):]
+		}
+		// Strings are immutable and the write only reads the reply, so it
+		// is sent without a copy.
+		w.queued = append(w.queued, unsafe.Slice(unsafe.StringData(reply), len(reply)))
+		return len(reply), nil
+	}
+	if len(w.buf)+len(reply) > cap(w.buf) {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	w.buf = append(w.buf, reply...)
+	return len(reply), nil
+}
+
+// Send sends chunk right away, after everything queued, so that its memory
+// may be reused once Send returns.
+func (w *replyWriter) Send(chunk []byte) error {
+	if len(w.buf)+len(chunk) <= cap(w.buf) {
+		w.buf = append(w.buf, chunk...)
+	} else {
+		if len(w.buf) > 0 {
+			w.queued = append(w.queued, w.buf)
+		}
+		w.queued = append(w.queued, chunk)
+	}
+	return w.Flush()
+}
+
+// Buffered returns the number of bytes queued.
+func (w *replyWriter) Buffered() int {
+	n := len(w.buf)
+	for _, segment := range w.queued {
+		n += len(segment)
+	}
+	return n
+}
+
+// Flush sends everything queued.
+func (w *replyWriter) Flush() error {
+	var err error
+	switch {
+	case len(w.queued) == 0:
+		if len(w.buf) > 0 {
+			_, err = w.conn.Write(w.buf)
+		}
+	default:
+		if len(w.buf) > 0 {
+			w.queued = append(w.queued, w.buf)
+		}
+		if len(w.queued) > 1 {
+			w.vectored()
+		}
+		// WriteTo consumes the slice, so the array is kept for reuse.
+		segments := w.queued
+		_, err = segments.WriteTo(w.conn)
+		clear(w.queued)
+		w.queued = w.queued[:0]
+	}
+	w.buf = w.buffer[:0]
+	return err
+}
@@ -0,0 +1,301 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/glob"
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+const (
+	// randomKeyAttempts is how many random seeks RANDOMKEY makes before it
+	// settles for the first live key.
+	randomKeyAttempts = 16
+	// scanDefaultCount is how many keys SCAN visits without COUNT.
+	scanDefaultCount = 10
+	// maxScanCursors is how many SCAN cursors are remembered. Older ones
+	// are forgotten and fail as invalid.
+	maxScanCursors = 16384
+)
+
+// scanCursors remembers where each SCAN cursor handed out resumes. Keys are
+// visited in order, so a cursor only needs the next key to visit, but
+// clients expect cursors to be integers, which keys do not fit in. Cursors
+// are numbered in sequence, starting from the time the server started so
+// that those of a previous run are unlikely to be mistaken for new ones,
+// and the oldest are forgotten first.
+type scanCursors struct {
+	mutex sync.Mutex
+	// keys holds the cursors from first to next.
+	keys        map[uint64][]byte
+	first, next uint64
+}
+
+func newScanCursors() *scanCursors {
+	start := uint64(time.Now().UnixNano())
+	return &scanCursors{keys: make(map[uint64][]byte), first: start, next: start}
+}
+
+// add returns a new cursor resuming at key.
+func (sc *scanCursors) add(key []byte) uint64 {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	cursor := sc.next
+	sc.next++
+	sc.keys[cursor] = key
+	for len(sc.keys) > maxScanCursors {
+		delete(sc.keys, sc.first)
+		sc.first++
+	}
+	return cursor
+}
+
+// resume returns the key cursor resumes at.
+func (sc *scanCursors) resume(cursor uint64) ([]byte, bool) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	key, ok := sc.keys[cursor]
+	return key, ok
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count] [TYPE type],
+// visiting up to count keys from where cursor left off and replying with
+// the next cursor, 0 once every key was visited, and the visited keys that
+// match the pattern and type. A pattern with a literal prefix only visits
+// the keys with that prefix.
+func (s *Server) scan(c *connection, args []string) string {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return resp.Error("ERR invalid cursor")
+	}
+	pattern, typeName := "", ""
+	count := scanDefaultCount
+	for opts := args[1:]; len(opts) > 0; opts = opts[2:] {
+		if len(opts) < 2 {
+			return resp.Error("ERR syntax error")
+		}
+		switch strings.ToLower(opts[0]) {
+		case "match":
+			pattern = opts[1]
+		case "count":
+			count, err = strconv.Atoi(opts[1])
+			if err != nil {
+				return resp.Error("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return resp.Error("ERR syntax error")
+			}
+		case "type":
+			typeName = strings.ToLower(opts[1])
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+	var start []byte
+	if cursor != 0 {
+		var ok bool
+		if start, ok = s.scanCursors.resume(cursor); !ok {
+			return resp.Error("ERR invalid cursor")
+		}
+	}
+
+	// The keys are counted before they are sent, both from one snapshot.
+	snapshot := s.db.NewSnapshot()
+	return c.streamReply(func(w *resp.Writer, flush func() error) error {
+		defer snapshot.Close()
+		defer s.io.foregroundRead()()
+		now, n := time.Now(), 0
+		matches := func(meta storage.Meta) bool {
+			return typeName == "" || meta.Type.String() == typeName
+		}
+		next, err := s.scanKeys(snapshot, now, start, pattern, count, func(_ []byte, meta storage.Meta) {
+			if matches(meta) {
+				n++
+			}
+		})
+		if err != nil {
+			return err
+		}
+		nextCursor := uint64(0)
+		if next != nil {
+			nextCursor = s.scanCursors.add(next)
+		}
+		w.Array(2)
+		w.BulkString(strconv.FormatUint(nextCursor, 10))
+		return s.streamKeys(snapshot, now, w, flush, n, start, pattern, count, matches)
+	})
+}
+
+// keys implements KEYS pattern, replying with every key matching pattern.
+// It reads the whole keyspace, or the keys with the pattern's literal
+// prefix, twice from one snapshot, to count the keys and to stream them,
+// so it is meant for small datasets; SCAN visits large ones in steps.
+func (s *Server) keys(c *connection, args []string) string {
+	snapshot := s.db.NewSnapshot()
+	return c.streamReply(func(w *resp.Writer, flush func() error) error {
+		defer snapshot.Close()
+		defer s.io.foregroundRead()()
+		now, n := time.Now(), 0
+		_, err := s.scanKeys(snapshot, now, nil, args[0], -1, func([]byte, storage.Meta) {
+			n++
+		})
+		if err != nil {
+			return err
+		}
+		return s.streamKeys(snapshot, now, w, flush, n, nil, args[0], -1, nil)
+	})
+}
+
+// streamKeys writes the array of the n keys that scanKeys visits with the
+// given arguments and that match, or all of them if match is nil.
+func (s *Server) streamKeys(r pebble.Reader, now time.Time, w *resp.Writer, flush func() error, n int, start []byte, pattern string, count int, match func(storage.Meta) bool) error {
+	w.Array(n)
+	var err error
+	_, scanErr := s.scanKeys(r, now, start, pattern, count, func(key []byte, meta storage.Meta) {
+		if err == nil && (match == nil || match(meta)) {
+			w.BulkString(string(key))
+			err = flush()
+		}
+	})
+	return cmp.Or(err, scanErr)
+}
+
+// dbsize implements DBSIZE, replying with the number of live keys. Keys do
+// not have a count kept up to date on every write, so they are counted,
+// which takes time in proportion to the keyspace.
+func (s *Server) dbsize() string {
+	defer s.io.foregroundRead()()
+	n := 0
+	_, err := s.scanKeys(s.db, time.Now(), nil, "", -1, func([]byte, storage.Meta) {
+		n++
+	})
+	if err != nil {
+		return resp.Error("ERR Failed to count keys: " + err.Error())
+	}
+	return resp.Integer(int64(n))
+}
+
+// randomKey implements RANDOMKEY, replying with a random live key or nil if
+// there is none. It seeks to a random key between the first and the last
+// one, which favours keys following gaps in the keyspace but costs a seek
+// rather than a scan.
+func (s *Server) randomKey() string {
+	defer s.io.foregroundRead()()
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: []byte{1}})
+	if err != nil {
+		return resp.Error("ERR Failed to get key: " + err.Error())
+	}
+	defer iter.Close()
+	if !iter.First() {
+		return resp.Nil
+	}
+	first := append([]byte{}, iter.Key()...)
+	iter.Last()
+	last := append([]byte{}, iter.Key()...)
+	now := time.Now()
+	live := func() (bool, error) {
+		meta, exists, err := storage.LoadMeta(s.db, iter.Key())
+		return exists && !meta.Expired(now), err
+	}
+	for i := 0; i < randomKeyAttempts; i++ {
+		// As when sampling keys for the shadow, seeking alternately up and
+		// down lets both ends of the range be picked.
+		target := randomKeyBetween(first, last)
+		if i%2 == 0 && !iter.SeekGE(target) || i%2 == 1 && !iter.SeekLT(target) {
+			iter.First()
+		}
+		ok, err := live()
+		if err != nil {
+			return resp.Error("ERR Failed to get key: " + err.Error())
+		}
+		if ok {
+			return resp.BulkString(string(iter.Key()))
+		}
+	}
+	// Mostly expired keys: take the first live one.
+	for iter.First(); iter.Valid(); iter.Next() {
+		ok, err := live()
+		if err != nil {
+			return resp.Error("ERR Failed to get key: " + err.Error())
+		}
+		if ok {
+			return resp.BulkString(string(iter.Key()))
+		}
+	}
+	return resp.Nil
+}
+
+// scanKeys calls fn with the keys of r live at now matching pattern from
+// start on, in order, visiting up to count keys, or all of them if count is
+// negative. It returns the key to resume from, nil once there are no more.
+func (s *Server) scanKeys(r pebble.Reader, now time.Time, start []byte, pattern string, count int, fn func(key []byte, meta storage.Meta)) ([]byte, error) {
+	options := &pebble.IterOptions{LowerBound: []byte{1}}
+	if prefix := glob.Prefix(pattern); prefix != "" {
+		if prefix[0] == 0 {
+			// The reserved keyspace is not listed.
+			return nil, nil
+		}
+		options = &pebble.IterOptions{LowerBound: []byte(prefix), UpperBound: keyPrefixEnd([]byte(prefix))}
+	}
+	if bytes.Compare(start, options.LowerBound) > 0 {
+		options.LowerBound = start
+	}
+	if options.UpperBound != nil && bytes.Compare(options.LowerBound, options.UpperBound) >= 0 {
+		return nil, nil
+	}
+	iter, err := r.NewIter(options)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	visited := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		if visited == count {
+			return append([]byte{}, iter.Key()...), nil
+		}
+		visited++
+		key := iter.Key()
+		if pattern != "" && !glob.Match(pattern, string(key)) {
+			continue
+		}
+		meta, exists, err := storage.LoadMeta(r, key)
+		if err != nil {
+			return nil, err
+		}
+		if exists && !meta.Expired(now) {
+			fn(key, meta)
+		}
+	}
+	return nil, iter.Error()
+}
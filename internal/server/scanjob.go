@@ -0,0 +1,332 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+)
+
+const (
+	// scanJobBatch is how many points a scan job reads before it yields to
+	// other background jobs.
+	scanJobBatch = 1024
+	// scanJobsKept is how many finished scan jobs are kept for
+	// VANALYZE STATUS and RESULT.
+	scanJobsKept = 32
+	// scanJobMaxK bounds the results of a RESCORE scan.
+	scanJobMaxK = 10000
+)
+
+// scanJob is an analytic scan over a snapshot of a collection, started by
+// VANALYZE START. It reads the points a batch at a time, each as a
+// background job of the scheduler, and sees none of the writes made after
+// it started.
+type scanJob struct {
+	id         int64
+	kind       string
+	collection string
+	started    time.Time
+	snapshot   *collection.PointSnapshot
+	analysis   collection.Analysis
+	// result renders the analysis once the scan is done.
+	result   func() []string
+	scanned  atomic.Int64
+	cancelCh chan struct{}
+	cancel   sync.Once
+
+	mutex    sync.Mutex
+	state    string
+	finished time.Time
+	err      error
+}
+
+// scanJobs are the running scan jobs and the latest finished ones.
+type scanJobs struct {
+	mutex  sync.Mutex
+	nextID int64
+	// jobs are ordered by ID.
+	jobs []*scanJob
+}
+
+func (j *scanJob) status() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.state
+}
+
+func (j *scanJob) finish(state string, err error) {
+	j.mutex.Lock()
+	j.state, j.err, j.finished = state, err, time.Now()
+	j.mutex.Unlock()
+}
+
+// progress returns the share of the points scanned, in percent.
+func (j *scanJob) progress() float64 {
+	if j.snapshot.Len == 0 {
+		return 100
+	}
+	return min(100, float64(j.scanned.Load())*100/float64(j.snapshot.Len))
+}
+
+// startScanJob registers j and starts scanning in the background, unless
+// MaxScanJobs are running already. Finished jobs beyond scanJobsKept are
+// forgotten, oldest first.
+func (s *Server) startScanJob(j *scanJob) error {
+	sj := &s.scanJobs
+	sj.mutex.Lock()
+	defer sj.mutex.Unlock()
+	running := 0
+	for _, other := range sj.jobs {
+		if other.status() == "running" {
+			running++
+		}
+	}
+	if limit := s.live.Load().MaxScanJobs; running >= limit {
+		return fmt.Errorf("too many scan jobs running, the most is %d", limit)
+	}
+	sj.nextID++
+	j.id = sj.nextID
+	j.started = time.Now()
+	j.state = "running"
+	j.cancelCh = make(chan struct{})
+	finished := len(sj.jobs) - running
+	for i := 0; i < len(sj.jobs) && finished >= scanJobsKept; {
+		if sj.jobs[i].status() != "running" {
+			sj.jobs = append(sj.jobs[:i], sj.jobs[i+1:]...)
+			finished--
+			continue
+		}
+		i++
+	}
+	sj.jobs = append(sj.jobs, j)
+	s.goTracked(subsystemAnalytics, func() { s.runScanJob(j) })
+	return nil
+}
+
+// runScanJob feeds the points of the snapshot of j to its analysis a batch
+// at a time, until every point was or the job is canceled.
+func (s *Server) runScanJob(j *scanJob) {
+	defer j.snapshot.Close()
+	var cursor uint64
+	for {
+		select {
+		case <-j.cancelCh:
+			j.finish("canceled", nil)
+			return
+		case <-s.quitCh:
+			j.finish("canceled", errStopping)
+			return
+		default:
+		}
+		err := s.runJob(jobAnalyticScan, func() (err error) {
+			cursor, err = j.snapshot.Scan(cursor, scanJobBatch, func(key string, vector []float64, payload []byte) {
+				j.analysis.Add(key, vector, payload)
+				j.scanned.Add(1)
+			})
+			return err
+		})
+		switch {
+		case err == errStopping:
+			j.finish("canceled", err)
+			return
+		case err != nil:
+			log.Printf("Scan job %d of %s failed: %v", j.id, j.collection, err)
+			j.finish("failed", err)
+			return
+		case cursor == 0:
+			j.finish("done", nil)
+			return
+		}
+	}
+}
+
+// scanJob returns the job of the VANALYZE argument id.
+func (s *Server) scanJob(id string) (*scanJob, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid job ID")
+	}
+	s.scanJobs.mutex.Lock()
+	defer s.scanJobs.mutex.Unlock()
+	for _, j := range s.scanJobs.jobs {
+		if j.id == n {
+			return j, nil
+		}
+	}
+	return nil, errors.New("no such scan job")
+}
+
+// newScanJob returns the job of VANALYZE START collection kind [arg ...]:
+// NORMS, summarizing the L2 norms of the vectors, or RESCORE k component
+// [component ...], finding the k points nearest to the vector given by
+// comparing it to every point.
+func (s *Server) newScanJob(name, kind string, args []string) (*scanJob, error) {
+	j := &scanJob{kind: strings.ToLower(kind), collection: name}
+	var query []float64
+	var k int
+	switch j.kind {
+	case "norms":
+		if len(args) != 0 {
+			return nil, errors.New("syntax error")
+		}
+	case "rescore":
+		if len(args) < 2 {
+			return nil, errors.New("syntax error")
+		}
+		var err error
+		if k, err = strconv.Atoi(args[0]); err != nil || k <= 0 || k > scanJobMaxK {
+			return nil, fmt.Errorf("k must be between 1 and %d", scanJobMaxK)
+		}
+		if query, err = parseVector(args[1:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown scan kind '%s'", kind)
+	}
+	snapshot, err := s.collections.SnapshotPoints(name)
+	if err != nil {
+		return nil, err
+	}
+	j.snapshot = snapshot
+	switch j.kind {
+	case "norms":
+		stats := &collection.NormStats{}
+		j.analysis = stats
+		j.result = func() []string {
+			return []string{
+				"points", strconv.FormatInt(stats.Points, 10),
+				"zero", strconv.FormatInt(stats.Zero, 10),
+				"nonfinite", strconv.FormatInt(stats.NonFinite, 10),
+				"min", strconv.FormatFloat(stats.Min, 'g', -1, 64),
+				"max", strconv.FormatFloat(stats.Max, 'g', -1, 64),
+				"mean", strconv.FormatFloat(stats.Mean(), 'g', -1, 64),
+				"stddev", strconv.FormatFloat(stats.StdDev(), 'g', -1, 64),
+			}
+		}
+	case "rescore":
+		if len(query) != snapshot.Info.Dimension {
+			snapshot.Close()
+			return nil, fmt.Errorf("vector has %d dimensions, collection %q expects %d", len(query), name, snapshot.Info.Dimension)
+		}
+		neighbors := collection.NewExactNeighbors(snapshot.Info.Metric, query, k)
+		j.analysis = neighbors
+		j.result = func() []string {
+			var reply []string
+			for _, r := range neighbors.Results() {
+				reply = append(reply, r.ID, strconv.FormatFloat(r.Score, 'g', -1, 64))
+			}
+			return reply
+		}
+	}
+	return j, nil
+}
+
+// vanalyze implements VANALYZE START collection NORMS | RESCORE k
+// component [component ...], starting a scan job (see newScanJob) and
+// replying with its ID; VANALYZE STATUS id, replying with its state and
+// progress as field and value pairs; VANALYZE RESULT id, replying with what
+// it computed once done; VANALYZE CANCEL id, stopping it before its next
+// batch, or replying with an error if it already finished; and VANALYZE
+// LIST, replying with the ID, kind, collection and state of every job kept.
+func (s *Server) vanalyze(args []string) string {
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "start" && len(args) >= 3:
+		j, err := s.newScanJob(args[1], args[2], args[3:])
+		if errors.Is(err, collection.ErrNotFound) {
+			return resp.Error("ERR no such collection")
+		}
+		if err != nil {
+			return resp.Error("ERR " + err.Error())
+		}
+		if err := s.startScanJob(j); err != nil {
+			j.snapshot.Close()
+			return resp.Error("ERR " + err.Error())
+		}
+		return resp.Integer(j.id)
+	case (sub == "status" || sub == "result" || sub == "cancel") && len(args) == 2:
+		j, err := s.scanJob(args[1])
+		if err != nil {
+			return resp.Error("ERR " + err.Error())
+		}
+		switch sub {
+		case "status":
+			return resp.StringArray(j.fields())
+		case "result":
+			if state := j.status(); state != "done" {
+				return resp.Error("ERR scan job is " + state + ", not done")
+			}
+			return resp.StringArray(j.result())
+		}
+		if j.status() != "running" {
+			return resp.Error("ERR scan job already finished")
+		}
+		j.cancel.Do(func() { close(j.cancelCh) })
+		return resp.OK
+	case sub == "list" && len(args) == 1:
+		s.scanJobs.mutex.Lock()
+		jobs := append([]*scanJob{}, s.scanJobs.jobs...)
+		s.scanJobs.mutex.Unlock()
+		var reply []string
+		for _, j := range jobs {
+			reply = append(reply, strconv.FormatInt(j.id, 10), j.kind, j.collection, j.status())
+		}
+		return resp.StringArray(reply)
+	case sub == "start" || sub == "status" || sub == "result" || sub == "cancel" || sub == "list":
+		return resp.Error("ERR wrong number of arguments for 'vanalyze|" + sub + "' command")
+	}
+	return resp.Error("ERR unknown VANALYZE subcommand '" + args[0] + "'")
+}
+
+// fields returns the state and progress of j as field and value pairs.
+func (j *scanJob) fields() []string {
+	j.mutex.Lock()
+	fields := []string{
+		"id", strconv.FormatInt(j.id, 10),
+		"kind", j.kind,
+		"collection", j.collection,
+		"state", j.state,
+		"started", strconv.FormatInt(j.started.Unix(), 10),
+	}
+	if !j.finished.IsZero() {
+		fields = append(fields, "finished", strconv.FormatInt(j.finished.Unix(), 10))
+	}
+	if j.err != nil {
+		fields = append(fields, "error", j.err.Error())
+	}
+	j.mutex.Unlock()
+	return append(fields,
+		"scanned", strconv.FormatInt(j.scanned.Load(), 10),
+		"total", strconv.Itoa(j.snapshot.Len),
+		"progress", strconv.FormatFloat(j.progress(), 'f', 1, 64))
+}
@@ -0,0 +1,236 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"readpebble/internal/collection"
+	"readpebble/pkg/client"
+)
+
+var errShardTimeout = errors.New("shard did not answer in time")
+
+// shardTarget is the part of the RESP client a shard is queried with. The
+// queries only read, so they are retried even once sent.
+type shardTarget interface {
+	DoIdempotent(args ...string) (interface{}, error)
+	Close()
+}
+
+// shard is another node of the cluster. Points are spread over the nodes
+// by the clients writing them, so a search has to ask every node and
+// merge what they found; collections are created on each node.
+type shard struct {
+	addr   string
+	target shardTarget
+}
+
+func newShards(config Config) []*shard {
+	if !config.ClusterEnabled {
+		return nil
+	}
+	shards := make([]*shard, len(config.ClusterShards))
+	for i, addr := range config.ClusterShards {
+		shards[i] = &shard{
+			addr: addr,
+			target: client.NewRemoteClient(client.Options{
+				Addr:        addr,
+				PoolSize:    4,
+				ReadTimeout: config.ShardTimeout,
+				// A shard that does not answer in time is reported
+				// missing rather than asked again.
+				MaxRetries: -1,
+			}),
+		}
+	}
+	return shards
+}
+
+// shardReply is a shard's reply to a scattered command.
+type shardReply struct {
+	addr  string
+	reply interface{}
+	err   error
+}
+
+// scatter sends args to every shard at once and returns a function waiting
+// for their replies. Shards that have not answered within timeout are
+// reported with errShardTimeout; their replies are dropped when they come.
+func (s *Server) scatter(args []string, timeout time.Duration) func() []shardReply {
+	deadline := time.Now().Add(timeout)
+	replies := make(chan shardReply, len(s.shards))
+	for _, sh := range s.shards {
+		go func() {
+			reply, err := sh.target.DoIdempotent(args...)
+			replies <- shardReply{addr: sh.addr, reply: reply, err: err}
+		}()
+	}
+	return func() []shardReply {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		answered := make(map[string]bool, len(s.shards))
+		gathered := make([]shardReply, 0, len(s.shards))
+		for len(gathered) < len(s.shards) {
+			select {
+			case r := <-replies:
+				answered[r.addr] = true
+				gathered = append(gathered, r)
+			case <-timer.C:
+				for _, sh := range s.shards {
+					if !answered[sh.addr] {
+						gathered = append(gathered, shardReply{addr: sh.addr, err: errShardTimeout})
+					}
+				}
+				return gathered
+			}
+		}
+		return gathered
+	}
+}
+
+// result decodes the reply of a shard to a scattered VSEARCH.
+func (r shardReply) result(hasDeadline, hasFacets bool) (*shardSearch, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return parseShardSearch(r.reply, hasDeadline, hasFacets)
+}
+
+// shardSearch is a shard's part of a scattered VSEARCH.
+type shardSearch struct {
+	results  []collection.Result
+	degraded bool
+	facets   map[string][]collection.FacetCount
+}
+
+// parseShardSearch decodes a VSEARCH reply, shaped by whether the search
+// had a DEADLINE and FACET options.
+func parseShardSearch(reply interface{}, hasDeadline, hasFacets bool) (*shardSearch, error) {
+	errMalformed := errors.New("malformed VSEARCH reply")
+	parts := []interface{}{reply}
+	if hasDeadline || hasFacets {
+		var ok bool
+		if parts, ok = reply.([]interface{}); !ok || len(parts) < 2 {
+			return nil, errMalformed
+		}
+	}
+	hits, ok := parts[0].([]interface{})
+	if !ok || len(hits)%2 != 0 {
+		return nil, errMalformed
+	}
+	ss := &shardSearch{results: make([]collection.Result, 0, len(hits)/2)}
+	for i := 0; i < len(hits); i += 2 {
+		id, _ := hits[i].(string)
+		raw, _ := hits[i+1].(string)
+		score, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, errMalformed
+		}
+		ss.results = append(ss.results, collection.Result{ID: id, Score: score})
+	}
+	parts = parts[1:]
+	if hasDeadline {
+		flag, _ := parts[0].(int64)
+		ss.degraded = flag == 1
+		parts = parts[1:]
+	}
+	if hasFacets {
+		if len(parts) == 0 {
+			return nil, errMalformed
+		}
+		fields, ok := parts[0].([]interface{})
+		if !ok || len(fields)%2 != 0 {
+			return nil, errMalformed
+		}
+		ss.facets = make(map[string][]collection.FacetCount)
+		for i := 0; i < len(fields); i += 2 {
+			field, _ := fields[i].(string)
+			pairs, _ := fields[i+1].([]interface{})
+			for j := 0; j+1 < len(pairs); j += 2 {
+				value, _ := pairs[j].(string)
+				count, _ := pairs[j+1].(int64)
+				ss.facets[field] = append(ss.facets[field], collection.FacetCount{Value: value, Count: int(count)})
+			}
+		}
+	}
+	return ss, nil
+}
+
+// mergeResults returns the k closest of results, closest first, keeping
+// the closest score of an id found by several shards.
+func mergeResults(results []collection.Result, k int) []collection.Result {
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score < results[j].Score })
+	seen := make(map[string]bool, len(results))
+	merged := results[:0]
+	for _, r := range results {
+		if len(merged) == k {
+			break
+		}
+		if !seen[r.ID] {
+			seen[r.ID] = true
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// mergeFacets adds up the counts of each facet value over the shards and
+// keeps the limit most common values of each field. Shards only report
+// their own most common values, so a value just outside the top of some
+// shards may be undercounted.
+func mergeFacets(sets []map[string][]collection.FacetCount, limit int) map[string][]collection.FacetCount {
+	counts := make(map[string]map[string]int)
+	for _, facets := range sets {
+		for field, values := range facets {
+			if counts[field] == nil {
+				counts[field] = make(map[string]int)
+			}
+			for _, fc := range values {
+				counts[field][collection.FormatFacetValue(fc.Value)] += fc.Count
+			}
+		}
+	}
+	merged := make(map[string][]collection.FacetCount, len(counts))
+	for field, values := range counts {
+		list := make([]collection.FacetCount, 0, len(values))
+		for value, count := range values {
+			list = append(list, collection.FacetCount{Value: value, Count: count})
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Value.(string) < list[j].Value.(string)
+		})
+		if len(list) > limit {
+			list = list[:limit]
+		}
+		merged[field] = list
+	}
+	return merged
+}
@@ -0,0 +1,75 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+// scheduler bounds how many commands execute at once across all
+// connections. A connection keeps its slot while it has pipelined commands
+// buffered, but gives it up after burst commands so that a client pipelining
+// a huge batch queues behind everyone else instead of starving them.
+type scheduler struct {
+	slots chan struct{}
+	burst int
+}
+
+func newScheduler(config Config) *scheduler {
+	return &scheduler{
+		slots: make(chan struct{}, config.CommandWorkers),
+		burst: config.PipelineBurst,
+	}
+}
+
+// turn is the state of one connection's use of the scheduler.
+type turn struct {
+	s    *scheduler
+	held bool
+	ran  int
+}
+
+// begin waits for a slot unless the connection already holds one.
+func (t *turn) begin() {
+	if !t.held {
+		t.s.slots <- struct{}{}
+		t.held = true
+		t.ran = 0
+	}
+}
+
+// end records an executed command and releases the slot when no pipelined
+// command is waiting or the burst is used up. It reports whether the
+// connection yielded with commands still pending.
+func (t *turn) end(pending bool) bool {
+	t.ran++
+	if pending && t.ran < t.s.burst {
+		return false
+	}
+	t.release()
+	return pending
+}
+
+func (t *turn) release() {
+	if t.held {
+		<-t.s.slots
+		t.held = false
+	}
+}
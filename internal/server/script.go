@@ -0,0 +1,428 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"readpebble/internal/resp"
+)
+
+// Scripts are Lua programs run by EVAL and EVALSHA, which call commands
+// with redis.call and redis.pcall as Redis scripts do. A script runs
+// atomically: no other command runs while it does, which handleCommand
+// ensures by holding scriptLock shared around every other command while
+// a script holds it exclusively. The commands a script calls are checked,
+// replicated and forwarded to the shadow one by one like those of
+// clients, so replicas apply the effects of a script rather than run it.
+// Commands flagged "noscript" cannot be called, and blocking commands
+// time out right away. A script runs for at most ScriptTimeout, after
+// which it fails, keeping the writes it made.
+
+// scriptCommands run scripts, and take scriptLock exclusively rather than
+// shared.
+var scriptCommands = map[string]bool{"eval": true, "evalsha": true, "fcall": true, "fcall_ro": true}
+
+// scriptCache holds the compiled scripts by the SHA1 digest of their
+// source, for EVALSHA.
+type scriptCache struct {
+	mutex   sync.Mutex
+	scripts map[string]*lua.FunctionProto
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{scripts: make(map[string]*lua.FunctionProto)}
+}
+
+func scriptSHA(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// load compiles source, unless it is cached already, and returns its
+// digest.
+func (sc *scriptCache) load(source string) (string, *lua.FunctionProto, error) {
+	sha := scriptSHA(source)
+	sc.mutex.Lock()
+	proto := sc.scripts[sha]
+	sc.mutex.Unlock()
+	if proto != nil {
+		return sha, proto, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(source), "@user_script")
+	if err != nil {
+		return "", nil, err
+	}
+	proto, err = lua.Compile(chunk, "@user_script")
+	if err != nil {
+		return "", nil, err
+	}
+	sc.mutex.Lock()
+	sc.scripts[sha] = proto
+	sc.mutex.Unlock()
+	return sha, proto, nil
+}
+
+func (sc *scriptCache) get(sha string) *lua.FunctionProto {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.scripts[strings.ToLower(sha)]
+}
+
+// eval implements EVAL script numkeys [key ...] [arg ...], and with sha
+// set EVALSHA sha1 numkeys [key ...] [arg ...], running a script given
+// its source or the digest of a script loaded before.
+func (s *Server) eval(c *connection, args []string, sha bool) string {
+	var proto *lua.FunctionProto
+	if sha {
+		if proto = s.scripts.get(args[0]); proto == nil {
+			return resp.Error("NOSCRIPT No matching script. Please use EVAL.")
+		}
+	} else {
+		var err error
+		if _, proto, err = s.scripts.load(args[0]); err != nil {
+			return resp.Error("ERR Error compiling script: " + err.Error())
+		}
+	}
+	keys, argv, reply := scriptArgs(args[1:])
+	if reply != "" {
+		return reply
+	}
+	return s.runScript(c, false, func(L *lua.LState) error {
+		L.SetGlobal("KEYS", scriptStrings(L, keys))
+		L.SetGlobal("ARGV", scriptStrings(L, argv))
+		L.Push(L.NewFunctionFromProto(proto))
+		return L.PCall(0, 1, nil)
+	})
+}
+
+// scriptArgs splits numkeys [key ...] [arg ...] into the keys and the
+// arguments, replying with an error if numkeys is invalid.
+func scriptArgs(args []string) (keys, argv []string, reply string) {
+	numKeys, err := strconv.Atoi(args[0])
+	switch {
+	case err != nil:
+		return nil, nil, resp.Error("ERR value is not an integer or out of range")
+	case numKeys < 0:
+		return nil, nil, resp.Error("ERR Number of keys can't be negative")
+	case numKeys > len(args)-1:
+		return nil, nil, resp.Error("ERR Number of keys can't be greater than number of args")
+	}
+	return args[1 : 1+numKeys], args[1+numKeys:], ""
+}
+
+// runScript runs a script alone in a fresh Lua state calling commands on
+// behalf of c, read-only if readOnly is set. run pushes the script's
+// result on the stack, and it is converted to the reply.
+func (s *Server) runScript(c *connection, readOnly bool, run func(L *lua.LState) error) string {
+	s.scriptLock.Lock()
+	defer s.scriptLock.Unlock()
+	timeout := s.live.Load().ScriptTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	L := newScriptState(s, scriptConnection(c), readOnly)
+	defer L.Close()
+	L.SetContext(ctx)
+	if err := run(L); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return resp.Errorf("ERR Script timed out after %s", timeout)
+		}
+		return scriptError(err, "ERR Error running script: ")
+	}
+	return scriptReply(L.Get(-1))
+}
+
+// scriptError returns the error reply for a script that raised err: the
+// err field of a table raised, as redis.call and redis.error_reply make,
+// or else prefix followed by the error.
+func scriptError(err error, prefix string) string {
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		if t, ok := apiErr.Object.(*lua.LTable); ok {
+			if msg, ok := t.RawGetString("err").(lua.LString); ok {
+				return resp.Error(string(msg))
+			}
+		}
+		return resp.Error(prefix + apiErr.Object.String())
+	}
+	return resp.Error(prefix + err.Error())
+}
+
+// scriptConnection returns the connection the commands a script run on c
+// call run on: it acts as c, but replies in RESP2 rather than streaming.
+func scriptConnection(c *connection) *connection {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return &connection{
+		id:            c.id,
+		conn:          c.conn,
+		createdAt:     c.createdAt,
+		protocol:      2,
+		admin:         c.admin,
+		authenticated: c.authenticated,
+		user:          c.user,
+		replication:   c.replication,
+		consistency:   c.consistency,
+		maxLag:        c.maxLag,
+		ctx:           c.ctx,
+		scripting:     true,
+		lastActive:    time.Now(),
+	}
+}
+
+// newScriptState returns a Lua state with the base, table, string and
+// math libraries, without the functions reaching the file system, and
+// with the redis table calling commands on c, only those not writing if
+// readOnly is set.
+func newScriptState(s *Server, c *connection, readOnly bool) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "print"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return s.scriptCall(L, c, readOnly, true) },
+		"pcall": func(L *lua.LState) int { return s.scriptCall(L, c, readOnly, false) },
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(scriptSHA(L.CheckString(1))))
+			return 1
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+	})
+	L.SetGlobal("redis", redis)
+	return L
+}
+
+// scriptCall implements redis.call and redis.pcall, which run a command
+// and return its reply converted to Lua. An error reply is raised by
+// redis.call and returned as a table with an err field by redis.pcall.
+// Read-only scripts may not call commands that write.
+func (s *Server) scriptCall(L *lua.LState, c *connection, readOnly, raise bool) int {
+	n := L.GetTop()
+	if n == 0 {
+		L.RaiseError("Please specify at least one argument for this redis lib call")
+	}
+	args := make([]string, n)
+	for i := range args {
+		switch v := L.Get(i + 1).(type) {
+		case lua.LString:
+			args[i] = string(v)
+		case lua.LNumber:
+			args[i] = strconv.FormatFloat(float64(v), 'f', -1, 64)
+		default:
+			L.RaiseError("Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	cmd := strings.ToLower(args[0])
+	var reply string
+	spec, ok := commandTable[cmd]
+	switch {
+	case ok && spec.hasFlag("noscript"):
+		reply = resp.Error("ERR This Redis command is not allowed from script")
+	case ok && readOnly && spec.writes(args[1:]):
+		reply = resp.Error("ERR Write commands are not allowed from read-only scripts")
+	default:
+		reply = s.handleCommand(c, cmd, args[1:])
+	}
+	value := luaReply(L, reply)
+	if t, ok := value.(*lua.LTable); ok && raise && t.RawGetString("err") != lua.LNil {
+		L.Error(t, 1)
+	}
+	L.Push(value)
+	return 1
+}
+
+// luaReply converts a RESP2 reply to Lua as Redis does: integers become
+// numbers, bulk strings strings, arrays tables, nil replies false, and
+// status and error replies tables with an ok or err field.
+func luaReply(L *lua.LState, reply string) lua.LValue {
+	if strings.HasPrefix(reply, "+") {
+		return replyTable(L, "ok", strings.TrimSuffix(reply[1:], "\r\n"))
+	}
+	value, err := resp.NewReader(strings.NewReader(reply)).ReadReply()
+	var errReply resp.ErrorReply
+	switch {
+	case errors.As(err, &errReply):
+		return replyTable(L, "err", string(errReply))
+	case err != nil:
+		return replyTable(L, "err", "ERR "+err.Error())
+	}
+	return luaValue(L, value)
+}
+
+func luaValue(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LFalse
+	case string:
+		return lua.LString(v)
+	case int64:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LString(strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		if v {
+			return lua.LNumber(1)
+		}
+		return lua.LFalse
+	case resp.ErrorReply:
+		return replyTable(L, "err", string(v))
+	case []interface{}:
+		t := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(luaValue(L, item))
+		}
+		return t
+	}
+	return lua.LFalse
+}
+
+func replyTable(L *lua.LState, field, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(msg))
+	return t
+}
+
+func scriptStrings(L *lua.LState, strs []string) *lua.LTable {
+	t := L.CreateTable(len(strs), 0)
+	for _, s := range strs {
+		t.Append(lua.LString(s))
+	}
+	return t
+}
+
+// scriptReply converts the value a script returned to a reply as Redis
+// does: numbers become integers, truncated, true becomes 1, false and nil
+// nil replies, tables with an ok or err field status or error replies,
+// and other tables arrays of their values up to the first nil.
+func scriptReply(value lua.LValue) string {
+	switch v := value.(type) {
+	case lua.LString:
+		return resp.BulkString(string(v))
+	case lua.LNumber:
+		return resp.Integer(int64(v))
+	case lua.LBool:
+		if v {
+			return resp.Integer(1)
+		}
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return resp.Error(string(msg))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return resp.SimpleString(string(msg))
+		}
+		var w resp.Writer
+		var items []string
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			items = append(items, scriptReply(item))
+		}
+		w.Array(len(items))
+		for _, item := range items {
+			w.Raw(item)
+		}
+		return w.String()
+	}
+	return resp.Nil
+}
+
+// script implements SCRIPT LOAD script, caching a script for EVALSHA and
+// replying with its digest, SCRIPT EXISTS sha1 [sha1 ...], replying 1 for
+// each cached script and 0 for others, and SCRIPT FLUSH [ASYNC | SYNC],
+// emptying the cache.
+func (s *Server) script(args []string) string {
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "load" && len(args) == 2:
+		sha, _, err := s.scripts.load(args[1])
+		if err != nil {
+			return resp.Error("ERR Error compiling script: " + err.Error())
+		}
+		return resp.BulkString(sha)
+	case sub == "exists" && len(args) >= 2:
+		var w resp.Writer
+		w.Array(len(args) - 1)
+		for _, sha := range args[1:] {
+			exists := 0
+			if s.scripts.get(sha) != nil {
+				exists = 1
+			}
+			w.Integer(int64(exists))
+		}
+		return w.String()
+	case sub == "flush" && len(args) <= 2:
+		if len(args) == 2 && !strings.EqualFold(args[1], "async") && !strings.EqualFold(args[1], "sync") {
+			return resp.Error("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+		}
+		s.scripts.mutex.Lock()
+		s.scripts.scripts = make(map[string]*lua.FunctionProto)
+		s.scripts.mutex.Unlock()
+		return resp.OK
+	case sub == "load" || sub == "exists" || sub == "flush":
+		return resp.Error("ERR wrong number of arguments for 'script|" + sub + "' command")
+	}
+	return resp.Error("ERR unknown SCRIPT subcommand '" + args[0] + "'")
+}
+
+// evalKeys returns the keys EVAL, EVALSHA, FCALL and FCALL_RO declare.
+func evalKeys(args []string) []string {
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 || n > len(args)-2 {
+		return nil
+	}
+	return args[2 : 2+n]
+}
@@ -0,0 +1,730 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"readpebble/internal/acl"
+	"readpebble/internal/auth"
+	"readpebble/internal/blob"
+	"readpebble/internal/collection"
+	"readpebble/internal/durability"
+	"readpebble/internal/embed"
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+type Config struct {
+	Addr string
+	// AdminAddr is a separate listener for operational commands (DEBUG,
+	// SHUTDOWN, BACKUP, ...). A "unix:" prefix selects a Unix socket. When
+	// set, those commands are refused on Addr.
+	AdminAddr string
+	// AdminLocalOnly refuses admin connections from non-loopback addresses.
+	AdminLocalOnly bool
+	// AdminPassword, when set, must be given with AUTH on the admin listener
+	// before any other command.
+	AdminPassword string
+	// Authenticator, when set, checks AUTH on every listener except an
+	// admin listener with its own AdminPassword. Clients must authenticate
+	// before running other commands.
+	Authenticator auth.Authenticator
+	// ACL, when set, restricts each user to the commands, key patterns and
+	// Pub/Sub channels of its rules, which ACL SETUSER changes and ACL SAVE
+	// writes back to the file it was loaded from. Connections that did not
+	// authenticate act as the default user.
+	ACL *acl.Store
+	// AuditLog is the file security events such as ACL denials are
+	// appended to as JSON lines. Empty writes them to the server log.
+	AuditLog string
+	// HTTPAddr is where metrics and the admin API are served. Empty disables
+	// the HTTP listener.
+	HTTPAddr string
+	// MetricsLabelLimit is how many collections, and how many tenants, get
+	// their own label values in per-collection metrics. Later ones are
+	// reported together as "other", bounding the number of series.
+	MetricsLabelLimit int
+	// Dashboard serves the embedded web UI from the HTTP listener.
+	Dashboard bool
+	// Pprof exposes net/http/pprof under /debug/pprof/ on the HTTP listener.
+	Pprof bool
+	// PprofToken, when set, must be presented as a bearer token to reach the
+	// pprof handlers.
+	PprofToken string
+	// RebindOnFailure closes and re-listens on Addr when Accept fails with a
+	// non-temporary error, instead of stopping the server.
+	RebindOnFailure bool
+	// RebindAttempts bounds how many times a rebind is tried before giving
+	// up.
+	RebindAttempts int
+	// WatchdogInterval is how often the goroutine leak detector runs.
+	WatchdogInterval time.Duration
+	// WatchdogGrace is how long goroutines may keep running after their
+	// connection closed before they are reported as leaked.
+	WatchdogGrace time.Duration
+	// Durability is how durable writes are when their command replies.
+	Durability durability.Mode
+	// DurabilityOverrides sets the durability of individual commands, by
+	// lowercase command name, overriding Durability.
+	DurabilityOverrides map[string]durability.Mode
+	// SyncWindow is how long batched writes wait to share a WAL fsync.
+	SyncWindow time.Duration
+	// BackgroundReadRate caps the bytes per second maintenance work such as
+	// MIGRATE reads, leaving the disk to client queries. Zero is unlimited.
+	BackgroundReadRate int64
+	// BackgroundCPUJobs and BackgroundIOJobs are how many maintenance jobs
+	// mostly using the CPUs, such as index builds, and the disk, such as
+	// checkpoints, expiry sweeps and backups, may run at once, bounding
+	// the share of each that maintenance takes. Fewer run while the server
+	// is under load pressure.
+	BackgroundCPUJobs int
+	BackgroundIOJobs  int
+	// HedgeAfter, if set, makes a GET that has not finished after this long
+	// issue a second read and use whichever finishes first.
+	HedgeAfter time.Duration
+	// Listeners are listening sockets inherited from a process handing off
+	// to this one (see InheritedListeners), by listener name: "data" or
+	// "admin".
+	Listeners map[string]net.Listener
+	// DataDir is the directory Pebble stores its files in, whose free
+	// space is checked against MinFreeDisk.
+	DataDir string
+	// MinFreeDisk is the free space, in bytes, below which the server
+	// rejects writes with -NOSPACE until space is freed, 0 for no minimum.
+	MinFreeDisk int64
+	// CheckpointInterval is how often collection indexes are checkpointed,
+	// bounding how much of the op log is replayed at startup.
+	CheckpointInterval time.Duration
+	// IndexMemoryBudget caps the bytes the HNSW graphs of the collections
+	// take in memory, 0 for no cap. The graphs of the collections searched
+	// least recently are demoted to disk to keep within it, and reloaded by
+	// their next search.
+	IndexMemoryBudget int64
+	// LoadQueueDepthHigh is the number of in-flight commands at which the
+	// server reports full pressure to RESP3 clients.
+	LoadQueueDepthHigh int64
+	// LoadCompactionDebtHigh is the estimated compaction debt, in bytes, at
+	// which the server reports full pressure to RESP3 clients.
+	LoadCompactionDebtHigh uint64
+	// ClusterEnabled routes keys by Redis Cluster hash slot and rejects
+	// commands whose keys span several slots with -CROSSSLOT.
+	ClusterEnabled bool
+	// ClusterShards are the addresses of the other nodes of the cluster,
+	// which VSEARCH fans out to in cluster mode.
+	ClusterShards []string
+	// ShardTimeout is how long a VSEARCH fanned out to the shards waits
+	// for each of them.
+	ShardTimeout time.Duration
+	// CommandWorkers is how many commands may execute at once across all
+	// connections.
+	CommandWorkers int
+	// PipelineBurst is how many pipelined commands a connection may run
+	// back to back before it yields its worker to other clients.
+	PipelineBurst int
+	// ReplicaOf is the "host port" of a Redis master to replicate from at
+	// startup, as with REPLICAOF.
+	ReplicaOf string
+	// ReplicationWindow is how many bytes of the master's stream may be
+	// received but not applied yet before the replica stops reading it.
+	// Redis masters disconnect replicas whose stream they buffer for too
+	// long (client-output-buffer-limit replica), so it should stay well
+	// below that limit.
+	ReplicationWindow int64
+	// ReplicationBacklog is how many bytes of the stream sent to replicas
+	// are kept, so that a replica reconnecting within that much writes
+	// resumes where it left off instead of resynchronizing fully.
+	ReplicationBacklog int64
+	// ShadowAddr is an instance successful writes are also forwarded to,
+	// asynchronously, to try out a migration on live traffic. Empty
+	// disables shadowing.
+	ShadowAddr string
+	// ShadowQueue is how many writes may wait to be forwarded before new
+	// ones are dropped.
+	ShadowQueue int
+	// ShadowCompareInterval is how often a sample of keys is compared with
+	// the shadow. Zero compares only on SHADOW COMPARE.
+	ShadowCompareInterval time.Duration
+	// ShadowSamples is how many keys a comparison samples.
+	ShadowSamples int
+	// EmbedderURL is the endpoint of an embedding provider speaking the
+	// OpenAI embeddings API, which VADDTEXT and EMBED turn text into
+	// vectors with. Empty disables them.
+	EmbedderURL    string
+	EmbedderModel  string
+	EmbedderAPIKey string
+	// EmbedderTimeout bounds each call to the provider.
+	EmbedderTimeout time.Duration
+	// EmbedderFailureThreshold is how many calls to the provider in a row
+	// may fail before the circuit breaker opens and calls fail at once,
+	// and EmbedderCooldown how long it stays open before a trial call.
+	EmbedderFailureThreshold int
+	EmbedderCooldown         time.Duration
+	// EmbedderFallback is what VADDTEXT does when the provider is
+	// unavailable: reject the write, queue it in memory, or store the
+	// point without a vector to embed it later.
+	EmbedderFallback embed.Fallback
+	// EmbedderQueue is how many writes the queue fallback holds.
+	EmbedderQueue int
+	// EmbedWorkers is how many deferred points are embedded at once, and
+	// EmbedMaxAttempts how many times embedding one is tried before it is
+	// dropped.
+	EmbedWorkers     int
+	EmbedMaxAttempts int
+	// EmbedCache keeps the vectors texts are embedded into in Pebble, so
+	// that each text is sent to the provider once.
+	EmbedCache bool
+	// EmbeddingsEndpoint serves EMBED on the HTTP listener as an OpenAI
+	// compatible POST /v1/embeddings, for applications to share the
+	// provider and its cache through vecble.
+	EmbeddingsEndpoint bool
+	// BlobChunkSize is the size BLOB PUT splits documents into.
+	BlobChunkSize int
+	// BlobOffload, if set, is the object store the chunks of documents of
+	// BlobOffloadThreshold bytes or more are stored in instead of Pebble.
+	BlobOffload          blob.ObjectStore
+	BlobOffloadThreshold int64
+	// CollectionsManifest, if set, is a manifest of collections (see
+	// package manifest) the collections are brought in line with at
+	// startup, as by APPLY.
+	CollectionsManifest string
+	// ScriptTimeout is how long an EVAL script may run before it fails.
+	ScriptTimeout time.Duration
+	// CaptureFile, if set, is a file the commands of client connections
+	// are captured to from startup, as by CAPTURE START, for vecble-replay
+	// to replay.
+	CaptureFile string
+	// MigrateLegacyVectors, if set, is a collection the vectors stored as
+	// plain float64 keys by earlier versions are migrated into from
+	// startup, as by VMIGRATE START, created with AutoCreateMetric and
+	// AutoCreateIndex if it does not exist. MigrateLegacyUntyped also
+	// migrates the untyped keys written before keys had a type.
+	MigrateLegacyVectors string
+	MigrateLegacyUntyped bool
+	// AutoCreateCollections makes VADD to a collection that does not exist
+	// create it, with the dimension of the vector added and the metric and
+	// index of AutoCreateMetric and AutoCreateIndex, instead of failing.
+	// It is meant for prototyping; production setups create collections
+	// explicitly so that a mistyped name or dimension is an error.
+	AutoCreateCollections bool
+	AutoCreateMetric      collection.Metric
+	AutoCreateIndex       collection.IndexType
+	// SoftValueSize, SoftCollectionPoints and SoftFilterConditions are
+	// soft limits, which commands exceeding are warned about rather than
+	// rejected (see checkSoftLimits), or 0 for none.
+	SoftValueSize        int64
+	SoftCollectionPoints int64
+	SoftFilterConditions int
+	// MaxCommandSize is the most bytes a command may take, as sent, before
+	// it is rejected with "request too large" while being read.
+	MaxCommandSize int64
+	// SlowlogLogSlowerThan is how many microseconds a command must take to
+	// be recorded in the slow log SLOWLOG reads, 0 to record every command
+	// and a negative value none. SlowlogMaxLen is how many of the latest
+	// such commands are kept.
+	SlowlogLogSlowerThan int64
+	SlowlogMaxLen        int
+	// MaxMemory, if positive, is the most bytes of heap the server uses
+	// before it refuses writes with -OOM, as Redis does under its
+	// noeviction policy.
+	MaxMemory int64
+	// NotifyKeyspaceEvents selects the keyspace events published to
+	// Pub/Sub, with the flags of the Redis setting of the same name (see
+	// ParseKeyspaceEvents).
+	NotifyKeyspaceEvents string
+	// MaxScanJobs is how many analytic scans VANALYZE may run at once, each
+	// pinning a snapshot of the data it reads until it finishes.
+	MaxScanJobs int
+	// ConfigFile is the file the settings were read from, if any, which
+	// CONFIG REWRITE writes those changed by CONFIG SET back to.
+	ConfigFile string
+}
+
+func (c *Config) setDefaults() {
+	if c.Addr == "" {
+		c.Addr = ":6379"
+	}
+	if c.LoadQueueDepthHigh <= 0 {
+		c.LoadQueueDepthHigh = 256
+	}
+	if c.LoadCompactionDebtHigh == 0 {
+		c.LoadCompactionDebtHigh = 1 << 30
+	}
+	if c.RebindAttempts <= 0 {
+		c.RebindAttempts = 5
+	}
+	if c.CommandWorkers <= 0 {
+		c.CommandWorkers = 4 * runtime.GOMAXPROCS(0)
+	}
+	if c.BackgroundCPUJobs <= 0 {
+		c.BackgroundCPUJobs = max(1, runtime.GOMAXPROCS(0)/4)
+	}
+	if c.BackgroundIOJobs <= 0 {
+		c.BackgroundIOJobs = 2
+	}
+	if c.PipelineBurst <= 0 {
+		c.PipelineBurst = 32
+	}
+	if c.ReplicationWindow <= 0 {
+		c.ReplicationWindow = 4 << 20
+	}
+	if c.ReplicationBacklog <= 0 {
+		c.ReplicationBacklog = 1 << 20
+	}
+	if c.WatchdogInterval <= 0 {
+		c.WatchdogInterval = 10 * time.Second
+	}
+	if c.WatchdogGrace <= 0 {
+		c.WatchdogGrace = 30 * time.Second
+	}
+	if c.SyncWindow <= 0 {
+		c.SyncWindow = 2 * time.Millisecond
+	}
+	if c.CheckpointInterval <= 0 {
+		c.CheckpointInterval = time.Minute
+	}
+	if c.ShardTimeout <= 0 {
+		c.ShardTimeout = 500 * time.Millisecond
+	}
+	if c.ShadowQueue <= 0 {
+		c.ShadowQueue = 10000
+	}
+	if c.ShadowSamples <= 0 {
+		c.ShadowSamples = 100
+	}
+	if c.EmbedderTimeout <= 0 {
+		c.EmbedderTimeout = 2 * time.Second
+	}
+	if c.EmbedderFailureThreshold <= 0 {
+		c.EmbedderFailureThreshold = 5
+	}
+	if c.EmbedderCooldown <= 0 {
+		c.EmbedderCooldown = 30 * time.Second
+	}
+	if c.EmbedderQueue <= 0 {
+		c.EmbedderQueue = 1000
+	}
+	if c.EmbedWorkers <= 0 {
+		c.EmbedWorkers = 4
+	}
+	if c.EmbedMaxAttempts <= 0 {
+		c.EmbedMaxAttempts = 10
+	}
+	if c.MetricsLabelLimit <= 0 {
+		c.MetricsLabelLimit = 100
+	}
+	if c.BlobChunkSize <= 0 {
+		c.BlobChunkSize = blob.DefaultChunkSize
+	}
+	if c.MaxScanJobs <= 0 {
+		c.MaxScanJobs = 4
+	}
+	if c.SlowlogMaxLen <= 0 {
+		c.SlowlogMaxLen = 128
+	}
+	if c.MaxCommandSize <= 0 {
+		c.MaxCommandSize = 512 << 20
+	}
+	if c.BlobOffloadThreshold <= 0 {
+		c.BlobOffloadThreshold = 4 << 20
+	}
+	if c.ScriptTimeout <= 0 {
+		c.ScriptTimeout = 5 * time.Second
+	}
+	if c.AutoCreateMetric == "" {
+		c.AutoCreateMetric = collection.MetricL2
+	}
+	if c.AutoCreateIndex == "" {
+		c.AutoCreateIndex = collection.IndexFlat
+	}
+}
+
+type Server struct {
+	// config is the configuration the server started with, and live the
+	// one in effect, which CONFIG SET replaces under configMutex. Settings
+	// CONFIG SET can change must be read from live (see config.go).
+	config        Config
+	live          atomic.Pointer[Config]
+	configMutex   sync.Mutex
+	configChanged map[string]bool
+	adminAuth     auth.Authenticator
+	db            *pebble.DB
+	storage       storage.Storage
+	// committer commits writes with the configured durability.
+	committer *durability.Committer
+	// collections holds the vector collections and tenants.
+	collections *collection.Manager
+	// blobs holds the documents stored with BLOB PUT.
+	blobs    *blob.Store
+	load     *loadMonitor
+	io       *ioScheduler
+	sched    *scheduler
+	jobs     *jobScheduler
+	disk     *diskMonitor
+	clients  *clientRegistry
+	stats    *stats
+	audit    *auditLog
+	watchdog *watchdog
+	wg       sync.WaitGroup
+	quitCh   chan struct{}
+	quitOnce sync.Once
+	// draining is set once a handoff started closing client connections.
+	draining atomic.Bool
+
+	listeners []*serverListener
+
+	replicaMutex sync.Mutex
+	// replica is set while the server follows a master (REPLICAOF).
+	replica *replica
+	// backlog is the stream sent to the replicas of this server.
+	backlog *backlog
+	// shadow is set when writes are forwarded to a shadow instance.
+	shadow *shadow
+	// shards are the other nodes of the cluster.
+	shards []*shard
+	// keyLocks serializes read-modify-write commands per key.
+	keyLocks keyLocks
+	// order keeps the replication stream in commit order (see writeOrder).
+	order writeOrder
+	// embedder is set when an embedding provider is configured.
+	embedder *embedder
+	// scanCursors are the cursors handed out by SCAN.
+	scanCursors *scanCursors
+	// feeds wakes the XREADs blocked on streams.
+	feeds *streamFeeds
+	// staleness caches the measure of how far searches trail the writes.
+	staleness stalenessCache
+	// pubsub holds the Pub/Sub subscriptions of the connections.
+	pubsub *pubsub
+	// scripts caches the scripts EVAL and SCRIPT LOAD compiled, and
+	// scriptLock is held exclusively while one runs (see script.go).
+	scripts    *scriptCache
+	scriptLock sync.RWMutex
+	// capture is the running capture of commands, if any, started and
+	// stopped under captureMutex (see capture.go).
+	capture      atomic.Pointer[captureSession]
+	captureMutex sync.Mutex
+	// migration is the last migration of legacy vector keys, if any,
+	// guarded by migrationMutex (see legacy.go).
+	migration      *legacyMigration
+	migrationMutex sync.Mutex
+	// scanJobs are the analytic scans of VANALYZE (see scanjob.go).
+	scanJobs scanJobs
+	// slowlog holds the commands that took longer than
+	// SlowlogLogSlowerThan.
+	slowlog slowlog
+	// authorizers are the checks of the embedding application (see
+	// RegisterAuthorizer).
+	authorizers authorizers
+	// lastSaveTime is the unix time LASTSAVE replies with.
+	lastSaveTime atomic.Int64
+	// functions are the libraries FUNCTION LOAD loaded, for FCALL (see
+	// function.go).
+	functions *functionRegistry
+}
+
+func NewServer(db *pebble.DB, config Config) *Server {
+	config.setDefaults()
+	store := storage.NewStorage(db)
+	committer := durability.NewCommitter(db, config.SyncWindow)
+	s := &Server{
+		config:      config,
+		db:          db,
+		storage:     &store,
+		committer:   committer,
+		collections: collection.NewManager(db, committer),
+		blobs: blob.New(db, committer, blob.Config{
+			ChunkSize:        config.BlobChunkSize,
+			Remote:           config.BlobOffload,
+			OffloadThreshold: config.BlobOffloadThreshold,
+		}),
+		load:        newLoadMonitor(db, config),
+		io:          newIOScheduler(config),
+		sched:       newScheduler(config),
+		clients:     newClientRegistry(),
+		backlog:     newBacklog(config.ReplicationBacklog),
+		shards:      newShards(config),
+		scanCursors: newScanCursors(),
+		feeds:       newStreamFeeds(),
+		pubsub:      newPubSub(),
+		scripts:     newScriptCache(),
+		functions:   newFunctionRegistry(),
+		quitCh:      make(chan struct{}),
+	}
+	s.live.Store(&config)
+	s.saved()
+	s.collections.SetIndexBudget(config.IndexMemoryBudget)
+	if config.AdminPassword != "" {
+		s.adminAuth = auth.NewStatic(config.AdminPassword)
+	}
+	if config.ShadowAddr != "" {
+		s.shadow = newShadow(config)
+	}
+	if config.EmbedderURL != "" {
+		s.embedder = newEmbedder(db, config)
+	}
+	s.stats = s.newStats()
+	s.audit = newAuditLog(s.stats.registry)
+	s.watchdog = newWatchdog(config, s.stats.registry)
+	s.jobs = newJobScheduler(config, s.load, s.stats.registry)
+	s.disk = newDiskMonitor(config, s.stats.registry)
+	s.listeners = []*serverListener{newServerListener("data", config.Addr, false)}
+	if config.AdminAddr != "" {
+		s.listeners = append(s.listeners, newServerListener("admin", config.AdminAddr, true))
+	}
+	for _, l := range s.listeners {
+		l.inherited = config.Listeners[l.name]
+	}
+	return s
+}
+
+// ListenAndServe opens every configured listener and serves connections
+// until Shutdown is called, then waits for open connections to finish.
+func (s *Server) ListenAndServe() error {
+	if err := s.collections.Load(); err != nil {
+		return fmt.Errorf("failed to load collections: %w", err)
+	}
+	if err := s.functions.load(s.db); err != nil {
+		return fmt.Errorf("failed to load function libraries: %w", err)
+	}
+	if s.config.CollectionsManifest != "" {
+		if err := s.applyManifestFile(s.config.CollectionsManifest); err != nil {
+			return fmt.Errorf("failed to apply collections manifest: %w", err)
+		}
+	}
+	if err := s.collections.EnforceIndexBudget(); err != nil {
+		return fmt.Errorf("failed to demote collection indexes: %w", err)
+	}
+	if s.config.CaptureFile != "" {
+		if err := s.startCapture(s.config.CaptureFile); err != nil {
+			return fmt.Errorf("failed to start capture: %w", err)
+		}
+	}
+	if s.disk.enabled() {
+		// Writes are rejected from the start if the disk is already full.
+		s.disk.check()
+	}
+	if s.config.AuditLog != "" {
+		if err := s.audit.open(s.config.AuditLog); err != nil {
+			return err
+		}
+		defer s.audit.close()
+	}
+	for _, l := range s.listeners {
+		if err := l.listen(); err != nil {
+			s.closeListeners()
+			return err
+		}
+		log.Printf("Redis-compatible %s listener running on %s", l.name, l.addr)
+	}
+	s.goTracked(subsystemLoadMonitor, func() { s.load.run(s.quitCh) })
+	s.goTracked(subsystemWatchdog, func() { s.watchdog.run(s.quitCh) })
+	s.goTracked(subsystemCheckpoint, s.checkpointLoop)
+	s.goTracked(subsystemCheckpoint, s.indexBudgetLoop)
+	s.goTracked(subsystemExpire, s.expireLoop)
+	if s.disk.enabled() {
+		s.goTracked(subsystemDisk, func() { s.disk.run(s.quitCh) })
+	}
+	s.goTracked(subsystemReplication, s.pingReplicasLoop)
+	if s.config.HTTPAddr != "" {
+		s.goTracked(subsystemHTTP, s.serveHTTP)
+	}
+	if s.embedder != nil {
+		s.goTracked(subsystemEmbed, s.embedLoop)
+		for i := 0; i < s.embedder.workers; i++ {
+			s.goTracked(subsystemEmbed, s.embedWorker)
+		}
+	}
+	if s.shadow != nil {
+		s.goTracked(subsystemShadow, func() { s.shadow.run(s.quitCh) })
+		if s.config.ShadowCompareInterval > 0 {
+			s.goTracked(subsystemShadow, s.compareLoop)
+		}
+	}
+	if s.config.MigrateLegacyVectors != "" && s.config.ReplicaOf == "" {
+		config := s.live.Load()
+		m := &legacyMigration{
+			collection: s.config.MigrateLegacyVectors,
+			untyped:    s.config.MigrateLegacyUntyped,
+			metric:     config.AutoCreateMetric,
+			index:      config.AutoCreateIndex,
+		}
+		if err := s.startMigration(m); err != nil {
+			s.stop()
+			return fmt.Errorf("failed to start migration: %w", err)
+		}
+	}
+	if s.config.ReplicaOf != "" {
+		master := strings.Fields(s.config.ReplicaOf)
+		if len(master) != 2 {
+			s.stop()
+			return fmt.Errorf("invalid replicaof %q: expected \"host port\"", s.config.ReplicaOf)
+		}
+		if reply := s.replicaOf(master); reply != resp.OK {
+			s.stop()
+			return fmt.Errorf("invalid replicaof %q: %s", s.config.ReplicaOf, strings.TrimSpace(reply[1:]))
+		}
+	}
+
+	errCh := make(chan error, len(s.listeners))
+	for _, l := range s.listeners[1:] {
+		l := l
+		go func() { errCh <- s.serve(l) }()
+	}
+	errCh <- s.serve(s.listeners[0])
+
+	var err error
+	for range s.listeners {
+		if serveErr := <-errCh; serveErr != nil && err == nil {
+			err = serveErr
+			log.Printf("Listener failed: %v", serveErr)
+			// One listener failing takes the server down rather than
+			// leaving it half reachable.
+			s.stop()
+		}
+	}
+	s.wg.Wait()
+	for _, sh := range s.shards {
+		sh.target.Close()
+	}
+	if checkpointErr := s.collections.Checkpoint(); checkpointErr != nil {
+		log.Printf("Checkpointing collections failed: %v", checkpointErr)
+	}
+	return err
+}
+
+// checkpointLoop checkpoints the collection indexes every
+// CheckpointInterval until the server stops.
+func (s *Server) checkpointLoop() {
+	ticker := time.NewTicker(s.config.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			switch err := s.runJob(jobCheckpoint, s.collections.Checkpoint); {
+			case err == nil:
+				s.saved()
+			case err != errStopping:
+				log.Printf("Checkpointing collections failed: %v", err)
+			}
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// indexBudgetInterval is how often the graphs of the collections are
+// checked against IndexMemoryBudget.
+const indexBudgetInterval = time.Second
+
+// indexBudgetLoop keeps the collection indexes within IndexMemoryBudget
+// until the server stops.
+func (s *Server) indexBudgetLoop() {
+	ticker := time.NewTicker(indexBudgetInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// The budget may be set or removed by CONFIG SET.
+			if s.collections.IndexBudget() == 0 {
+				continue
+			}
+			if err := s.runJob(jobIndexBudget, s.collections.EnforceIndexBudget); err != nil && err != errStopping {
+				log.Printf("Demoting collection indexes failed: %v", err)
+			}
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// Shutdown stops accepting new connections and waits for the open ones to
+// finish. ListenAndServe returns nil once the accept loops have exited.
+func (s *Server) Shutdown() {
+	s.stop()
+	s.wg.Wait()
+}
+
+func (s *Server) stop() {
+	s.quitOnce.Do(func() {
+		close(s.quitCh)
+		s.closeListeners()
+		s.replicaMutex.Lock()
+		if s.replica != nil {
+			s.replica.stop()
+			s.replica = nil
+		}
+		s.replicaMutex.Unlock()
+		// Replicas never disconnect on their own, so Shutdown would
+		// wait for them forever.
+		s.backlog.reset()
+	})
+}
+
+func (s *Server) closeListeners() {
+	for _, l := range s.listeners {
+		l.close()
+	}
+}
+
+// writeMode returns how durable the writes of cmd must be when it replies.
+func (s *Server) writeMode(cmd string) durability.Mode {
+	config := s.live.Load()
+	if mode, ok := config.DurabilityOverrides[cmd]; ok {
+		return mode
+	}
+	return config.Durability
+}
+
+// adminSeparated reports whether operational commands are confined to the
+// admin listener.
+func (s *Server) adminSeparated() bool {
+	return s.config.AdminAddr != ""
+}
+
+func (s *Server) shuttingDown() bool {
+	select {
+	case <-s.quitCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// goTracked runs fn in a goroutine accounted to subsystem by the watchdog.
+func (s *Server) goTracked(subsystem string, fn func()) {
+	done := s.watchdog.track(subsystem)
+	go func() {
+		defer done()
+		fn()
+	}()
+}
@@ -0,0 +1,196 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+)
+
+// sessionVariable is a default a connection can set with SESSION SET.
+type sessionVariable struct {
+	name string
+	// check validates a value, returning it as the session stores it.
+	check func(value string) (string, error)
+}
+
+// sessionVariables are the defaults of a session:
+//
+//   - collection is the collection of vector commands whose collection
+//     argument is empty, e.g. VSEARCH "" 10 0.1 0.2.
+//   - k is the k of VSEARCH when its k argument is empty.
+//   - ef and timeout are the EF and DEADLINE of VSEARCH when it is given
+//     none, in milliseconds for timeout. As with DEADLINE, replies then
+//     say whether the search was degraded.
+//   - format is the reply of VSEARCH: scores, the default, for ids and
+//     scores, or ids for the ids alone (SCORES 0).
+var sessionVariables = []sessionVariable{
+	{"collection", func(value string) (string, error) {
+		if value == "" || strings.Contains(value, ":") {
+			return "", collection.ErrInvalidName
+		}
+		return value, nil
+	}},
+	{"k", func(value string) (string, error) {
+		return sessionInt(value, 0, "k must be a non-negative integer")
+	}},
+	{"ef", func(value string) (string, error) {
+		return sessionInt(value, 1, "ef must be a positive integer")
+	}},
+	{"timeout", func(value string) (string, error) {
+		return sessionInt(value, 1, "timeout must be a positive number of milliseconds")
+	}},
+	{"format", func(value string) (string, error) {
+		switch format := strings.ToLower(value); format {
+		case "scores", "ids":
+			return format, nil
+		}
+		return "", errors.New("format must be scores or ids")
+	}},
+}
+
+// sessionInt checks that value is an integer of at least least.
+func sessionInt(value string, least int, message string) (string, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < least {
+		return "", errors.New(message)
+	}
+	return strconv.Itoa(n), nil
+}
+
+// session implements SESSION SET name value [name value ...], SESSION GET
+// name, SESSION UNSET name [name ...], SESSION LIST and SESSION RESET,
+// which set, read and clear the defaults of the connection (see
+// sessionVariables). They last as long as the connection, and LIST
+// replies with the names and values of those set.
+func (s *Server) session(c *connection, args []string) string {
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "set" && len(args) >= 3 && len(args)%2 == 1:
+		values := make(map[string]string)
+		for i := 1; i < len(args); i += 2 {
+			name := strings.ToLower(args[i])
+			j := slices.IndexFunc(sessionVariables, func(v sessionVariable) bool { return v.name == name })
+			if j < 0 {
+				return resp.Error("ERR unknown session variable '" + args[i] + "'")
+			}
+			value, err := sessionVariables[j].check(args[i+1])
+			if err != nil {
+				return resp.Error("ERR " + err.Error())
+			}
+			values[name] = value
+		}
+		c.mutex.Lock()
+		if c.session == nil {
+			c.session = make(map[string]string)
+		}
+		for name, value := range values {
+			c.session[name] = value
+		}
+		c.mutex.Unlock()
+		return resp.OK
+	case sub == "get" && len(args) == 2:
+		value, ok := c.sessionValue(strings.ToLower(args[1]))
+		if !ok {
+			return resp.Nil
+		}
+		return resp.BulkString(value)
+	case sub == "unset" && len(args) >= 2:
+		c.mutex.Lock()
+		for _, name := range args[1:] {
+			delete(c.session, strings.ToLower(name))
+		}
+		c.mutex.Unlock()
+		return resp.OK
+	case sub == "list" && len(args) == 1:
+		var list []string
+		for _, v := range sessionVariables {
+			if value, ok := c.sessionValue(v.name); ok {
+				list = append(list, v.name, value)
+			}
+		}
+		return resp.StringArray(list)
+	case sub == "reset" && len(args) == 1:
+		c.mutex.Lock()
+		c.session = nil
+		c.mutex.Unlock()
+		return resp.OK
+	case sub == "set" || sub == "get" || sub == "unset" || sub == "list" || sub == "reset":
+		return resp.Error("ERR wrong number of arguments for 'session|" + sub + "' command")
+	}
+	return resp.Error("ERR unknown SESSION subcommand '" + args[0] + "'")
+}
+
+// sessionValue returns the value of a session variable of c, if set.
+func (c *connection) sessionValue(name string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, ok := c.session[name]
+	return value, ok
+}
+
+// sessionArgs fills in the empty collection argument of a vector command,
+// and the empty k of VSEARCH, from the session of c. It runs before the
+// command is checked and handled, so that ACLs, cluster slots, replicas
+// and metrics all see the collection the command acts on.
+func (s *Server) sessionArgs(c *connection, cmd string, args []string) []string {
+	spec, ok := commandTable[cmd]
+	if !ok || !spec.hasCategory("vector") || spec.FirstKey == 0 || len(args) < spec.FirstKey {
+		return args
+	}
+	fill := func(i int, name string) {
+		if args[i] != "" {
+			return
+		}
+		if value, ok := c.sessionValue(name); ok {
+			args[i] = value
+		}
+	}
+	fill(spec.FirstKey-1, "collection")
+	if cmd == "vsearch" && len(args) > 1 {
+		fill(1, "k")
+	}
+	return args
+}
+
+// sessionSearchOptions returns the VSEARCH options the session of c
+// defaults to, which options given with the command override.
+func (c *connection) sessionSearchOptions() []string {
+	var opts []string
+	if ef, ok := c.sessionValue("ef"); ok {
+		opts = append(opts, "EF", ef)
+	}
+	if timeout, ok := c.sessionValue("timeout"); ok {
+		opts = append(opts, "DEADLINE", timeout)
+	}
+	if format, ok := c.sessionValue("format"); ok && format == "ids" {
+		opts = append(opts, "SCORES", "0")
+	}
+	return opts
+}
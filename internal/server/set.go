@@ -0,0 +1,393 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// sadd implements SADD key member [member ...], creating the set if needed
+// and replying with the number of members added. Only the members given
+// are written, along with the set's cardinality, however large the set.
+func (s *Server) sadd(args []string) string {
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, card, exists, err := s.loadSet(key)
+	if err != nil {
+		return typeError(err)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if !exists {
+		// Members left over from a set the key held before and lost to a
+		// command overwriting whatever the key holds, such as SET, which
+		// does not look for them, would come back otherwise.
+		start, end := storage.SetMembersSpan(key)
+		batch.DeleteRange(start, end, nil)
+		meta = storage.NewMeta(storage.ObjectTypeSet)
+	}
+	added := make(map[string]bool)
+	for _, member := range args[1:] {
+		if added[member] {
+			continue
+		}
+		if exists {
+			found, err := s.setMember(key, member)
+			if err != nil {
+				return typeError(err)
+			}
+			if found {
+				continue
+			}
+		}
+		added[member] = true
+		batch.Set(storage.SetMemberKey(key, []byte(member)), nil, nil)
+	}
+	if len(added) == 0 {
+		return resp.Integer(0)
+	}
+	writeCardinality(batch, key, meta, card+int64(len(added)))
+	if err := s.committer.Commit(batch, s.writeMode("sadd")); err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(int64(len(added)))
+}
+
+// srem implements SREM key member [member ...], replying with the number
+// of members removed. The key is deleted with its last member.
+func (s *Server) srem(args []string) string {
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, card, exists, err := s.loadSet(key)
+	if err != nil || !exists {
+		if err != nil {
+			return typeError(err)
+		}
+		return resp.Integer(0)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	removed := make(map[string]bool)
+	for _, member := range args[1:] {
+		if removed[member] {
+			continue
+		}
+		found, err := s.setMember(key, member)
+		if err != nil {
+			return typeError(err)
+		}
+		if found {
+			removed[member] = true
+			batch.Delete(storage.SetMemberKey(key, []byte(member)), nil)
+		}
+	}
+	if len(removed) == 0 {
+		return resp.Integer(0)
+	}
+	writeCardinality(batch, key, meta, card-int64(len(removed)))
+	if err := s.committer.Commit(batch, s.writeMode("srem")); err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(int64(len(removed)))
+}
+
+// smembers implements SMEMBERS key, replying with every member in byte
+// order, streamed from one pass over the span they are stored in.
+func (s *Server) smembers(c *connection, args []string) string {
+	return s.combineSets(c, "smembers", args[:1])
+}
+
+// sismember implements SISMEMBER key member, replying 1 if the set has the
+// member and 0 otherwise.
+func (s *Server) sismember(args []string) string {
+	key := []byte(args[0])
+	_, _, exists, err := s.loadSet(key)
+	if err != nil || !exists {
+		if err != nil {
+			return typeError(err)
+		}
+		return resp.Integer(0)
+	}
+	found, err := s.setMember(key, args[1])
+	if err != nil {
+		return typeError(err)
+	}
+	if found {
+		return resp.Integer(1)
+	}
+	return resp.Integer(0)
+}
+
+// scard implements SCARD key, replying 0 for a set that does not exist.
+func (s *Server) scard(args []string) string {
+	_, card, _, err := s.loadSet([]byte(args[0]))
+	if err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(card)
+}
+
+// combineSets implements SINTER, SUNION and SDIFF key [key ...], replying
+// with the members of the intersection, union or difference of the sets
+// in byte order. Keys that do not exist count as empty sets. The sets are
+// read from one snapshot, each through an iterator over its members, and
+// merged as they are walked, the reply being streamed as members come
+// out: nothing is held in memory, however large the sets. The size of
+// the result is not known in advance but for SMEMBERS, so the sets are
+// walked twice, once to count the members and once to send them.
+func (s *Server) combineSets(c *connection, cmd string, args []string) string {
+	// The snapshot is taken before the sets can change, so that it has as
+	// many members as the cardinalities say.
+	unlock := s.keyLocks.lockAll(args)
+	cards := make([]int64, len(args))
+	for i, arg := range args {
+		_, card, _, err := s.loadSet([]byte(arg))
+		if err != nil {
+			unlock()
+			return typeError(err)
+		}
+		cards[i] = card
+	}
+	if cmd == "sinter" && slices.Contains(cards, 0) || cmd != "sunion" && cards[0] == 0 {
+		unlock()
+		return resp.StringArray(nil)
+	}
+	snapshot := s.db.NewSnapshot()
+	unlock()
+	iters := make([]*setIter, 0, len(args))
+	release := func() {
+		for _, iter := range iters {
+			iter.Close()
+		}
+		snapshot.Close()
+	}
+	for i, arg := range args {
+		if cards[i] == 0 {
+			continue
+		}
+		iter, err := newSetIter(snapshot, []byte(arg), cards[i])
+		if err != nil {
+			release()
+			return typeError(err)
+		}
+		iters = append(iters, iter)
+	}
+	walk := func(fn func(member []byte)) error {
+		switch cmd {
+		case "sinter":
+			// The smallest set drives the walk, the others being skipped
+			// ahead to its members rather than read in full.
+			slices.SortStableFunc(iters, func(a, b *setIter) int { return cmp.Compare(a.card, b.card) })
+			return intersectSets(iters, fn)
+		case "sdiff":
+			return diffSets(iters, fn)
+		default:
+			return unionSets(iters, fn)
+		}
+	}
+	return c.streamReply(func(w *resp.Writer, flush func() error) error {
+		defer release()
+		defer s.io.foregroundRead()()
+		n := cards[0]
+		if cmd != "smembers" {
+			n = 0
+			if err := walk(func([]byte) { n++ }); err != nil {
+				return err
+			}
+		}
+		w.Array(int(n))
+		var err error
+		walkErr := walk(func(member []byte) {
+			if err == nil {
+				w.BulkString(string(member))
+				err = flush()
+			}
+		})
+		return cmp.Or(err, walkErr)
+	})
+}
+
+// intersectSets calls fn with the members found in every set of iters,
+// starting with the first set's and seeking the others to each of them.
+func intersectSets(iters []*setIter, fn func(member []byte)) error {
+	lead := iters[0]
+	lead.First()
+walk:
+	for lead.Valid() {
+		member := lead.member()
+		for _, iter := range iters[1:] {
+			if !iter.SeekGE(member) {
+				break walk
+			}
+			if other := iter.member(); !bytes.Equal(other, member) {
+				// Nothing between the two can be in both sets.
+				lead.SeekGE(other)
+				continue walk
+			}
+		}
+		fn(member)
+		lead.Next()
+	}
+	return setIterError(iters)
+}
+
+// unionSets calls fn with the members found in any set of iters, each
+// once, by repeatedly taking the smallest member any of them is at.
+func unionSets(iters []*setIter, fn func(member []byte)) error {
+	for _, iter := range iters {
+		iter.First()
+	}
+	for {
+		var smallest []byte
+		for _, iter := range iters {
+			if iter.Valid() && (smallest == nil || bytes.Compare(iter.member(), smallest) < 0) {
+				smallest = iter.member()
+			}
+		}
+		if smallest == nil {
+			return setIterError(iters)
+		}
+		// The iterators may reuse the memory of the member once moved.
+		member := append([]byte{}, smallest...)
+		fn(member)
+		for _, iter := range iters {
+			if iter.Valid() && bytes.Equal(iter.member(), member) {
+				iter.Next()
+			}
+		}
+	}
+}
+
+// diffSets calls fn with the members of the first set of iters found in
+// none of the others, seeking the others to each of them.
+func diffSets(iters []*setIter, fn func(member []byte)) error {
+	lead := iters[0]
+	for lead.First(); lead.Valid(); lead.Next() {
+		member := lead.member()
+		found := false
+		for _, iter := range iters[1:] {
+			if iter.SeekGE(member) && bytes.Equal(iter.member(), member) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fn(member)
+		}
+	}
+	return setIterError(iters)
+}
+
+// setIter iterates over the members of a set.
+type setIter struct {
+	*pebble.Iterator
+	prefix []byte
+	// card is the number of members of the set.
+	card int64
+}
+
+func newSetIter(snapshot *pebble.Snapshot, key []byte, card int64) (*setIter, error) {
+	start, end := storage.SetMembersSpan(key)
+	iter, err := snapshot.NewIter(&pebble.IterOptions{LowerBound: start, UpperBound: end})
+	if err != nil {
+		return nil, err
+	}
+	return &setIter{Iterator: iter, prefix: start, card: card}, nil
+}
+
+// member returns the member the iterator is at, valid until it moves.
+func (it *setIter) member() []byte {
+	return it.Key()[len(it.prefix):]
+}
+
+// SeekGE moves the iterator to the first member not less than member.
+func (it *setIter) SeekGE(member []byte) bool {
+	return it.Iterator.SeekGE(append(it.prefix[:len(it.prefix):len(it.prefix)], member...))
+}
+
+// setIterError returns the first error any of iters ran into.
+func setIterError(iters []*setIter) error {
+	for _, iter := range iters {
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setMember reports whether the set at key has member.
+func (s *Server) setMember(key []byte, member string) (bool, error) {
+	_, err := s.io.get(s.db, storage.SetMemberKey(key, []byte(member)))
+	if err == pebble.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// loadSet returns the metadata and cardinality of the set at key and
+// whether it exists. Keys holding another type fail with errWrongType.
+func (s *Server) loadSet(key []byte) (storage.Meta, int64, bool, error) {
+	return s.loadCardinality(key, storage.ObjectTypeSet)
+}
+
+// loadCardinality returns the metadata and cardinality of the set or
+// sorted set, as t says, at key and whether it exists. Keys holding
+// another type fail with errWrongType.
+func (s *Server) loadCardinality(key []byte, t storage.ObjectType) (storage.Meta, int64, bool, error) {
+	meta, exists, err := s.lookupType(key, t)
+	if err != nil || !exists {
+		return meta, 0, false, err
+	}
+	value, closer, err := s.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return meta, 0, false, nil
+	}
+	if err != nil {
+		return meta, 0, false, err
+	}
+	defer closer.Close()
+	card, err := storage.DecodeSetCard(value)
+	return meta, card, err == nil, err
+}
+
+// writeCardinality adds to batch the writes storing the new cardinality of
+// the set or sorted set at key, whose metadata is meta, or deleting the
+// key if it is empty.
+func writeCardinality(batch *pebble.Batch, key []byte, meta storage.Meta, card int64) {
+	if card == 0 {
+		storage.DeleteValue(batch, key, meta)
+		return
+	}
+	meta.LastAccess = time.Now()
+	batch.Set(key, storage.EncodeSetCard(card), nil)
+	storage.SetMeta(batch, key, meta)
+}
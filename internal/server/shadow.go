@@ -0,0 +1,303 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"readpebble/internal/resp"
+	"readpebble/pkg/client"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// maxDivergentKeys bounds how many divergent keys a comparison remembers.
+const maxDivergentKeys = 16
+
+// shadowTarget is the part of the RESP client the shadow needs.
+type shadowTarget interface {
+	Do(args ...string) (interface{}, error)
+	Get(key string) (string, bool, error)
+	Close()
+}
+
+// shadow forwards successful writes to a second instance, such as one
+// running a new version or encoding, and compares samples of the two
+// keyspaces so a migration can be validated on live traffic before
+// clients are switched over. Forwarding is asynchronous and never slows
+// down or fails the client's write: when the target falls behind by more
+// than ShadowQueue writes they are dropped and counted, and the comparator
+// will report the keys they touched as divergent.
+type shadow struct {
+	addr   string
+	target shadowTarget
+	queue  chan []string
+
+	forwarded atomic.Int64
+	dropped   atomic.Int64
+	failed    atomic.Int64
+	compared  atomic.Int64
+	diverged  atomic.Int64
+
+	mutex sync.Mutex
+	// lastError is the last error forwarding a write.
+	lastError string
+	// last is the result of the latest comparison.
+	last *shadowReport
+}
+
+// shadowReport is the result of comparing a sample of keys.
+type shadowReport struct {
+	time      time.Time
+	compared  int
+	divergent []string
+}
+
+func newShadow(config Config) *shadow {
+	return &shadow{
+		addr: config.ShadowAddr,
+		target: client.NewRemoteClient(client.Options{
+			Addr:     config.ShadowAddr,
+			PoolSize: 2,
+		}),
+		queue: make(chan []string, config.ShadowQueue),
+	}
+}
+
+// forward queues cmd for the target, dropping it if the queue is full.
+func (sh *shadow) forward(cmd string, args []string) {
+	if cmd == "xreadgroup" {
+		args = xreadGroupUnblocked(args)
+	}
+	select {
+	case sh.queue <- append([]string{cmd}, args...):
+	default:
+		sh.dropped.Add(1)
+	}
+}
+
+// run sends queued writes to the target in order until quitCh is closed.
+func (sh *shadow) run(quitCh chan struct{}) {
+	defer sh.target.Close()
+	for {
+		select {
+		case args := <-sh.queue:
+			if _, err := sh.target.Do(args...); err != nil {
+				sh.failed.Add(1)
+				sh.mutex.Lock()
+				sh.lastError = fmt.Sprintf("%s: %v", args[0], err)
+				sh.mutex.Unlock()
+				continue
+			}
+			sh.forwarded.Add(1)
+		case <-quitCh:
+			return
+		}
+	}
+}
+
+// shouldShadow reports whether a successful cmd is forwarded to the shadow.
+// MIGRATE is not: the shadow would move its own copy of the keys away,
+// while the keys it deletes locally show up as divergent.
+func (s *Server) shouldShadow(spec *commandSpec, args []string, reply string) bool {
+	return s.shadow != nil && spec.writes(args) && spec.Name != "migrate" &&
+		!strings.HasPrefix(reply, "-")
+}
+
+// compareLoop compares the keyspaces every ShadowCompareInterval until the
+// server stops.
+func (s *Server) compareLoop() {
+	ticker := time.NewTicker(s.config.ShadowCompareInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var report *shadowReport
+			err := s.runJob(jobShadowCompare, func() (err error) {
+				report, err = s.compareShadow(s.live.Load().ShadowSamples)
+				return err
+			})
+			if err == errStopping {
+				return
+			}
+			if err != nil {
+				log.Printf("Comparing with shadow %s failed: %v", s.shadow.addr, err)
+				continue
+			}
+			if len(report.divergent) > 0 {
+				log.Printf("Shadow %s diverges on %d of %d sampled keys, e.g. %q",
+					s.shadow.addr, len(report.divergent), report.compared, report.divergent[0])
+			}
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// compareShadow reads up to samples random keys from both instances and
+// reports the ones whose values differ or that are missing on the shadow.
+// Only plain keys are sampled: collections live in the reserved keyspace,
+// whose encoding is what a shadow on a new version may legitimately change.
+func (s *Server) compareShadow(samples int) (*shadowReport, error) {
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: []byte{1}})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	if !iter.First() {
+		return s.recordComparison(&shadowReport{time: time.Now()}), iter.Error()
+	}
+	lo := append([]byte{}, iter.Key()...)
+	iter.Last()
+	hi := append([]byte{}, iter.Key()...)
+
+	report := &shadowReport{}
+	seen := make(map[string]bool)
+	for i := 0; i < samples; i++ {
+		// Seeking alternately up and down lets both ends of the range be
+		// picked.
+		target := randomKeyBetween(lo, hi)
+		if i%2 == 0 && !iter.SeekGE(target) || i%2 == 1 && !iter.SeekLT(target) {
+			if !iter.First() {
+				break
+			}
+		}
+		key := string(iter.Key())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		local := iter.Value()
+		s.io.backgroundRead(len(key) + len(local))
+		remote, found, err := s.shadow.target.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		report.compared++
+		if !found || !bytes.Equal(local, []byte(remote)) {
+			if len(report.divergent) < maxDivergentKeys {
+				report.divergent = append(report.divergent, key)
+			}
+			s.shadow.diverged.Add(1)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	report.time = time.Now()
+	s.shadow.compared.Add(int64(report.compared))
+	return s.recordComparison(report), nil
+}
+
+func (s *Server) recordComparison(report *shadowReport) *shadowReport {
+	s.shadow.mutex.Lock()
+	s.shadow.last = report
+	s.shadow.mutex.Unlock()
+	return report
+}
+
+// randomKeyBetween returns a key between lo and hi to seek to, roughly
+// uniformly distributed over the range by interpolating their first 8
+// bytes.
+func randomKeyBetween(lo, hi []byte) []byte {
+	prefix := func(key []byte) uint64 {
+		var b [8]byte
+		copy(b[:], key)
+		return binary.BigEndian.Uint64(b[:])
+	}
+	a, b := prefix(lo), prefix(hi)
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, a+rand.Uint64N(min(b-a, math.MaxUint64-1)+1))
+	return key
+}
+
+// shadowCommand implements SHADOW COMPARE [samples], comparing a sample of
+// keys with the shadow right away. It replies with the number of keys
+// compared, the number that diverged and some of the divergent keys.
+func (s *Server) shadowCommand(args []string) string {
+	if strings.ToLower(args[0]) != "compare" {
+		return resp.Error("ERR unknown SHADOW subcommand '" + args[0] + "'")
+	}
+	if s.shadow == nil {
+		return resp.Error("ERR no shadow configured")
+	}
+	samples := s.live.Load().ShadowSamples
+	switch len(args) {
+	case 1:
+	case 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return resp.Error("ERR samples must be a positive integer")
+		}
+		samples = n
+	default:
+		return resp.Error("ERR syntax error")
+	}
+	report, err := s.compareShadow(samples)
+	if err != nil {
+		return resp.Error("ERR Failed to compare with shadow: " + err.Error())
+	}
+	return resp.Array(
+		resp.Integer(int64(report.compared)),
+		resp.Integer(int64(len(report.divergent))),
+		resp.StringArray(report.divergent),
+	)
+}
+
+// infoShadow reports how far forwarding and the latest comparison got.
+func (s *Server) infoShadow(b *strings.Builder) {
+	if s.shadow == nil {
+		b.WriteString("shadow_enabled:0\r\n")
+		return
+	}
+	sh := s.shadow
+	b.WriteString("shadow_enabled:1\r\n")
+	fmt.Fprintf(b, "shadow_addr:%s\r\n", sh.addr)
+	fmt.Fprintf(b, "shadow_queued:%d\r\n", len(sh.queue))
+	fmt.Fprintf(b, "shadow_forwarded:%d\r\n", sh.forwarded.Load())
+	fmt.Fprintf(b, "shadow_dropped:%d\r\n", sh.dropped.Load())
+	fmt.Fprintf(b, "shadow_errors:%d\r\n", sh.failed.Load())
+	fmt.Fprintf(b, "shadow_keys_compared:%d\r\n", sh.compared.Load())
+	fmt.Fprintf(b, "shadow_keys_divergent:%d\r\n", sh.diverged.Load())
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	if sh.lastError != "" {
+		fmt.Fprintf(b, "shadow_last_error:%s\r\n", sh.lastError)
+	}
+	if sh.last != nil {
+		fmt.Fprintf(b, "shadow_last_compare:%d\r\n", sh.last.time.Unix())
+		fmt.Fprintf(b, "shadow_last_compared:%d\r\n", sh.last.compared)
+		fmt.Fprintf(b, "shadow_last_divergent:%d\r\n", len(sh.last.divergent))
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"readpebble/internal/resp"
+)
+
+const (
+	// slowlogMaxArgs and slowlogMaxArgLen bound the arguments kept of a
+	// command in the slow log, as in Redis.
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+	// slowlogDefaultCount is how many entries SLOWLOG GET replies with
+	// without a count.
+	slowlogDefaultCount = 10
+)
+
+// slowlogEntry is a command that took longer than SlowlogLogSlowerThan.
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowlog keeps the latest commands that took longer than
+// SlowlogLogSlowerThan, up to SlowlogMaxLen of them, for SLOWLOG.
+type slowlog struct {
+	mutex sync.Mutex
+	// ring holds the n entries from start on, oldest first, wrapping
+	// around.
+	ring     []slowlogEntry
+	start, n int
+	nextID   int64
+}
+
+// resize makes room for maxLen entries, dropping the oldest ones that do
+// not fit.
+func (l *slowlog) resize(maxLen int) {
+	if len(l.ring) == maxLen {
+		return
+	}
+	entries := l.newest(maxLen)
+	l.ring = make([]slowlogEntry, maxLen)
+	l.start, l.n = 0, len(entries)
+	for i, e := range entries {
+		l.ring[len(entries)-1-i] = e
+	}
+}
+
+// add records e, replacing the oldest entry if there are maxLen already.
+func (l *slowlog) add(e slowlogEntry, maxLen int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.resize(maxLen)
+	e.id = l.nextID
+	l.nextID++
+	if l.n < len(l.ring) {
+		l.ring[(l.start+l.n)%len(l.ring)] = e
+		l.n++
+		return
+	}
+	l.ring[l.start] = e
+	l.start = (l.start + 1) % len(l.ring)
+}
+
+// newest returns up to count entries, or all of them if count is negative,
+// newest first.
+func (l *slowlog) newest(count int) []slowlogEntry {
+	if count < 0 || count > l.n {
+		count = l.n
+	}
+	entries := make([]slowlogEntry, count)
+	for i := range entries {
+		entries[i] = l.ring[(l.start+l.n-1-i)%len(l.ring)]
+	}
+	return entries
+}
+
+// logSlow records cmd in the slow log if it took elapsed at least
+// SlowlogLogSlowerThan.
+func (s *Server) logSlow(c *connection, cmd string, args []string, elapsed time.Duration) {
+	config := s.live.Load()
+	if config.SlowlogLogSlowerThan < 0 || elapsed < time.Duration(config.SlowlogLogSlowerThan)*time.Microsecond {
+		return
+	}
+	c.mutex.Lock()
+	name := c.name
+	c.mutex.Unlock()
+	s.slowlog.add(slowlogEntry{
+		time:     time.Now().Add(-elapsed),
+		duration: elapsed,
+		args:     slowlogArgs(cmd, args),
+		addr:     c.conn.RemoteAddr().String(),
+		name:     name,
+	}, config.SlowlogMaxLen)
+}
+
+// slowlogArgs returns the command line of cmd as the slow log keeps it:
+// at most slowlogMaxArgs arguments of at most slowlogMaxArgLen bytes, with
+// credentials redacted.
+func slowlogArgs(cmd string, args []string) []string {
+	redactFrom := len(args)
+	switch {
+	case cmd == "auth" || cmd == "hello":
+		redactFrom = 0
+	case cmd == "acl" && strings.EqualFold(args[0], "setuser"):
+		redactFrom = 2
+	}
+	line := []string{cmd}
+	for i, arg := range args {
+		if len(line) == slowlogMaxArgs-1 && len(args)-i > 1 {
+			line = append(line, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+		switch {
+		case i >= redactFrom:
+			arg = "(redacted)"
+		case len(arg) > slowlogMaxArgLen:
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		line = append(line, arg)
+	}
+	return line
+}
+
+// slowlogCommand implements SLOWLOG GET [count], replying with the latest
+// count entries, 10 by default or all of them for -1, newest first; SLOWLOG
+// LEN; and SLOWLOG RESET, which empties the slow log.
+func (s *Server) slowlogCommand(args []string) string {
+	maxLen := s.live.Load().SlowlogMaxLen
+	s.slowlog.mutex.Lock()
+	defer s.slowlog.mutex.Unlock()
+	s.slowlog.resize(maxLen)
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "get" && len(args) <= 2:
+		count := slowlogDefaultCount
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < -1 {
+				return resp.Error("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		var w resp.Writer
+		entries := s.slowlog.newest(count)
+		w.Array(len(entries))
+		for _, e := range entries {
+			w.Array(6)
+			w.Integer(e.id)
+			w.Integer(e.time.Unix())
+			w.Integer(e.duration.Microseconds())
+			w.Array(len(e.args))
+			for _, arg := range e.args {
+				w.BulkString(arg)
+			}
+			w.BulkString(e.addr)
+			w.BulkString(e.name)
+		}
+		return w.String()
+	case sub == "len" && len(args) == 1:
+		return resp.Integer(int64(s.slowlog.n))
+	case sub == "reset" && len(args) == 1:
+		s.slowlog.start, s.slowlog.n = 0, 0
+		clear(s.slowlog.ring)
+		return resp.OK
+	case sub == "get" || sub == "len" || sub == "reset":
+		return resp.Error("ERR wrong number of arguments for 'slowlog|" + sub + "' command")
+	}
+	return resp.Error("ERR unknown SLOWLOG subcommand '" + args[0] + "'")
+}
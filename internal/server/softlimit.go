@@ -0,0 +1,153 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+)
+
+// softLimit is a limit that does not reject the commands exceeding it but
+// warns about them: in the RESP3 attribute of their reply, in the log and
+// in the vecble_soft_limit_warnings_total metric. Soft limits point at
+// usage that may become a problem, such as huge values, before hard limits
+// are put in place.
+type softLimit struct {
+	// name is the setting the limit is configured with.
+	name string
+	// lastLogged is the unix time the limit was last logged, as it is at
+	// most once a second.
+	lastLogged atomic.Int64
+}
+
+var (
+	softValueSize        = &softLimit{name: "soft-value-size"}
+	softCollectionPoints = &softLimit{name: "soft-collection-points"}
+	softFilterConditions = &softLimit{name: "soft-filter-conditions"}
+)
+
+// checkSoftLimits records the soft limits cmd, which succeeded on c with
+// args, exceeded:
+//
+//   - SoftValueSize, by an argument of a write, such as the value of SET or
+//     the payload of VADD, of more bytes.
+//   - SoftCollectionPoints, by a write to a collection holding more
+//     points.
+//   - SoftFilterConditions, by a FILTER of a vector read with more
+//     conditions (see collection.Filter.Conditions).
+//
+// A limit of 0 is not checked. The commands the replication stream applies
+// are not checked, as the master did.
+func (s *Server) checkSoftLimits(c *connection, spec *commandSpec, args []string) {
+	if c.replication {
+		return
+	}
+	config := s.live.Load()
+	writes := spec.writes(args)
+	if limit := config.SoftValueSize; limit > 0 && writes {
+		largest := 0
+		for _, arg := range args {
+			largest = max(largest, len(arg))
+		}
+		if int64(largest) > limit {
+			s.softLimitExceeded(c, softValueSize, spec.Name, fmt.Sprintf("value of %d bytes is over the soft limit of %d", largest, limit))
+		}
+	}
+	if !spec.hasCategory("vector") || spec.FirstKey == 0 || len(args) < spec.FirstKey {
+		return
+	}
+	if limit := config.SoftCollectionPoints; limit > 0 && writes {
+		if coll, err := s.collections.Get(args[spec.FirstKey-1]); err == nil {
+			if n := coll.Len(); int64(n) > limit {
+				s.softLimitExceeded(c, softCollectionPoints, spec.Name, fmt.Sprintf("collection %s holds %d points, over the soft limit of %d", coll.Name, n, limit))
+			}
+		}
+	}
+	if limit := config.SoftFilterConditions; limit > 0 && !writes {
+		for i := spec.FirstKey; i+1 < len(args); i++ {
+			if !strings.EqualFold(args[i], "filter") {
+				continue
+			}
+			filter, err := collection.ParseFilter(args[i+1])
+			if err != nil {
+				continue
+			}
+			if n := filter.Conditions(); n > limit {
+				s.softLimitExceeded(c, softFilterConditions, spec.Name, fmt.Sprintf("filter has %d conditions, over the soft limit of %d", n, limit))
+			}
+		}
+	}
+}
+
+// softLimitExceeded records that cmd, run by c, exceeded limit as warning
+// describes.
+func (s *Server) softLimitExceeded(c *connection, limit *softLimit, cmd, warning string) {
+	warning = fmt.Sprintf("%s: %s (%s)", cmd, warning, limit.name)
+	c.warnings = append(c.warnings, warning)
+	s.stats.registry.Counter("vecble_soft_limit_warnings_total", "Commands that exceeded a soft limit, by limit.", "limit", limit.name).Inc()
+	now := time.Now().Unix()
+	if last := limit.lastLogged.Load(); last != now && limit.lastLogged.CompareAndSwap(last, now) {
+		addr := ""
+		if c.conn != nil {
+			addr = c.conn.RemoteAddr().String()
+		}
+		log.Printf("Soft limit exceeded by client %d at %s: %s", c.id, addr, warning)
+	}
+}
+
+// replyAttribute returns the RESP3 attribute of a reply, or an empty
+// string if it has none: it announces the load while the server is under
+// pressure, and the soft limits the command exceeded.
+func (s *Server) replyAttribute(warnings []string) string {
+	pressure := s.load.pressure()
+	n := 0
+	if pressure > 0 {
+		n++
+	}
+	if len(warnings) > 0 {
+		n++
+	}
+	if n == 0 {
+		return ""
+	}
+	var w resp.Writer
+	w.Attribute(n)
+	if pressure > 0 {
+		s.load.writeAttribute(&w, pressure)
+	}
+	if len(warnings) > 0 {
+		w.SimpleString("warnings")
+		w.Array(len(warnings))
+		for _, warning := range warnings {
+			w.BulkString(warning)
+		}
+	}
+	return w.String()
+}
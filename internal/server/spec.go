@@ -0,0 +1,371 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"readpebble/internal/glob"
+	"readpebble/internal/resp"
+)
+
+// commands.json describes every command the server accepts. The dispatcher
+// validates arity from it, admin and replica checks read its flags, and
+// COMMAND reports it, so a new command only needs an entry there and a case
+// in handleCommand.
+//
+//go:embed commands.json
+var commandsJSON []byte
+
+var commandTable = loadCommandTable(commandsJSON)
+
+// commandSpec follows the conventions of Redis' COMMAND INFO: arity counts
+// the command name and is negative for a minimum, key positions are 1-based
+// argument indexes with a negative last key counting from the end.
+type commandSpec struct {
+	Name     string   `json:"name"`
+	Arity    int      `json:"arity"`
+	Flags    []string `json:"flags"`
+	FirstKey int      `json:"first_key"`
+	LastKey  int      `json:"last_key"`
+	Step     int      `json:"step"`
+	// Categories are the ACL categories, without the leading @.
+	Categories []string `json:"acl_categories"`
+	// ReadSubcommands are the subcommands of a write command that only
+	// read, which replicas serve and masters do not propagate.
+	ReadSubcommands []string `json:"read_subcommands,omitempty"`
+	// Errors are the codes of the errors the handler may reply besides
+	// those every command may (see errorCodes).
+	Errors []string `json:"errors,omitempty"`
+	// handler runs the command (see commandHandlers).
+	handler commandHandler
+}
+
+func loadCommandTable(data []byte) map[string]*commandSpec {
+	var specs []*commandSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		panic(fmt.Sprintf("invalid commands.json: %v", err))
+	}
+	table := make(map[string]*commandSpec, len(specs))
+	for _, spec := range specs {
+		table[spec.Name] = spec
+	}
+	return table
+}
+
+func (c *commandSpec) hasFlag(flag string) bool {
+	for _, f := range c.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// writes reports whether c, called with args, is a write.
+func (c *commandSpec) writes(args []string) bool {
+	if !c.hasFlag("write") {
+		return false
+	}
+	if len(args) > 0 {
+		for _, sub := range c.ReadSubcommands {
+			if strings.EqualFold(args[0], sub) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *commandSpec) hasCategory(category string) bool {
+	for _, cat := range c.Categories {
+		if cat == category {
+			return true
+		}
+	}
+	return false
+}
+
+// validArity reports whether args, which exclude the command name, satisfy
+// the arity of c.
+func (c *commandSpec) validArity(args []string) bool {
+	n := len(args) + 1
+	if c.Arity >= 0 {
+		return n == c.Arity
+	}
+	return n >= -c.Arity
+}
+
+// info renders c as a COMMAND INFO entry in the shape of Redis 7: name,
+// arity, flags, first key, last key, step, ACL categories, tips, key
+// specifications and subcommands. Tips and subcommands are always empty,
+// as commands.json does not describe them.
+func (c *commandSpec) info(resp3 bool) string {
+	var w resp.Writer
+	w.Array(10)
+	w.BulkString(c.Name)
+	w.Integer(int64(c.Arity))
+	w.Array(len(c.Flags))
+	for _, flag := range c.Flags {
+		w.SimpleString(flag)
+	}
+	w.Integer(int64(c.FirstKey))
+	w.Integer(int64(c.LastKey))
+	w.Integer(int64(c.Step))
+	w.Array(len(c.Categories))
+	for _, category := range c.Categories {
+		w.SimpleString("@" + category)
+	}
+	w.Array(0)
+	c.writeKeySpecs(&w, resp3)
+	w.Array(0)
+	return w.String()
+}
+
+// writeKeySpecs writes the key specifications of c, derived from its key
+// positions: a range of keys starting at FirstKey, or for commands whose
+// keys move with their arguments, a specification of unknown keys.
+func (c *commandSpec) writeKeySpecs(w *resp.Writer, resp3 bool) {
+	movable := c.hasFlag("movablekeys")
+	if c.FirstKey == 0 && !movable {
+		w.Array(0)
+		return
+	}
+	access := "RO"
+	if c.hasFlag("write") {
+		access = "RW"
+	}
+	w.Array(1)
+	writeMapHeader(w, resp3, 3)
+	w.BulkString("flags")
+	w.Array(1)
+	w.SimpleString(access)
+	w.BulkString("begin_search")
+	if c.FirstKey == 0 {
+		writeMapHeader(w, resp3, 2)
+		w.BulkString("type")
+		w.BulkString("unknown")
+		w.BulkString("spec")
+		writeMapHeader(w, resp3, 0)
+		w.BulkString("find_keys")
+		writeMapHeader(w, resp3, 2)
+		w.BulkString("type")
+		w.BulkString("unknown")
+		w.BulkString("spec")
+		writeMapHeader(w, resp3, 0)
+		return
+	}
+	writeMapHeader(w, resp3, 2)
+	w.BulkString("type")
+	w.BulkString("index")
+	w.BulkString("spec")
+	writeMapHeader(w, resp3, 1)
+	w.BulkString("index")
+	w.Integer(int64(c.FirstKey))
+	// The last key of find_keys counts from the first, or from the end
+	// when negative.
+	lastKey := c.LastKey
+	if lastKey > 0 {
+		lastKey -= c.FirstKey
+	}
+	w.BulkString("find_keys")
+	writeMapHeader(w, resp3, 2)
+	w.BulkString("type")
+	w.BulkString("range")
+	w.BulkString("spec")
+	writeMapHeader(w, resp3, 3)
+	w.BulkString("lastkey")
+	w.Integer(int64(lastKey))
+	w.BulkString("keystep")
+	w.Integer(int64(c.Step))
+	w.BulkString("limit")
+	w.Integer(0)
+}
+
+// writeMapHeader starts a map of n pairs: a RESP3 map, or a flat array for
+// RESP2.
+func writeMapHeader(w *resp.Writer, resp3 bool, n int) {
+	if resp3 {
+		w.Map(n)
+	} else {
+		w.Array(2 * n)
+	}
+}
+
+// commandGroups are the groups of COMMAND DOCS, by the ACL category that
+// places a command in them, in order of precedence.
+var commandGroups = []struct{ category, group string }{
+	{"vector", "vector"},
+	{"string", "string"},
+	{"bitmap", "bitmap"},
+	{"list", "list"},
+	{"hash", "hash"},
+	{"set", "set"},
+	{"sortedset", "sorted-set"},
+	{"stream", "stream"},
+	{"pubsub", "pubsub"},
+	{"scripting", "scripting"},
+	{"connection", "connection"},
+	{"keyspace", "generic"},
+}
+
+// group returns the COMMAND DOCS group of c, "server" for the commands of
+// no other group.
+func (c *commandSpec) group() string {
+	for _, g := range commandGroups {
+		if c.hasCategory(g.category) {
+			return g.group
+		}
+	}
+	return "server"
+}
+
+// command implements COMMAND, COMMAND COUNT, COMMAND INFO [name ...],
+// COMMAND DOCS [name ...], COMMAND LIST [FILTERBY ACLCAT category |
+// PATTERN pattern | MODULE name] and COMMAND GETKEYS. COMMAND and COMMAND
+// INFO without names describe every command.
+func (s *Server) command(c *connection, args []string) string {
+	if len(args) == 0 {
+		return commandInfos(c, commandNames())
+	}
+	switch strings.ToLower(args[0]) {
+	case "count":
+		return resp.Integer(int64(len(commandTable)))
+	case "info":
+		if len(args) == 1 {
+			return commandInfos(c, commandNames())
+		}
+		return commandInfos(c, args[1:])
+	case "docs":
+		return commandDocs(c, args[1:])
+	case "list":
+		return commandList(args[1:])
+	case "getkeys":
+		return commandGetKeys(args[1:])
+	default:
+		return resp.Error("ERR unknown COMMAND subcommand '" + args[0] + "'")
+	}
+}
+
+// commandNames returns the names of every command, sorted.
+func commandNames() []string {
+	names := make([]string, 0, len(commandTable))
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandList implements COMMAND LIST, replying with the names of the
+// commands, or of those the filter selects. There are no modules, so
+// filtering by one selects none.
+func commandList(args []string) string {
+	if len(args) == 0 {
+		return resp.StringArray(commandNames())
+	}
+	if len(args) != 3 || !strings.EqualFold(args[0], "filterby") {
+		return resp.Error("ERR syntax error")
+	}
+	value := strings.ToLower(args[2])
+	var match func(spec *commandSpec) bool
+	switch strings.ToLower(args[1]) {
+	case "aclcat":
+		match = func(spec *commandSpec) bool { return spec.hasCategory(strings.TrimPrefix(value, "@")) }
+	case "pattern":
+		match = func(spec *commandSpec) bool { return glob.Match(value, spec.Name) }
+	case "module":
+		match = func(*commandSpec) bool { return false }
+	default:
+		return resp.Error("ERR syntax error")
+	}
+	names := []string{}
+	for _, name := range commandNames() {
+		if match(commandTable[name]) {
+			names = append(names, name)
+		}
+	}
+	return resp.StringArray(names)
+}
+
+// commandDocs renders the docs of the commands of the given names, or of
+// every command without names, leaving out unknown ones. The docs of a
+// command give its group, as Redis groups commands, and list the errors it
+// may reply, with their class (see errorClasses), so that clients can tell
+// which are worth retrying.
+func commandDocs(c *connection, names []string) string {
+	if len(names) == 0 {
+		names = commandNames()
+	}
+	var specs []*commandSpec
+	for _, name := range names {
+		if spec, ok := commandTable[strings.ToLower(name)]; ok {
+			specs = append(specs, spec)
+		}
+	}
+	var w resp.Writer
+	writeMap := func(n int) { writeMapHeader(&w, c.protocol >= 3, n) }
+	writeMap(len(specs))
+	for _, spec := range specs {
+		w.BulkString(spec.Name)
+		writeMap(2)
+		w.BulkString("group")
+		w.BulkString(spec.group())
+		w.BulkString("errors")
+		codes := spec.errorCodes()
+		w.Array(len(codes))
+		for _, code := range codes {
+			class := errorClassByCode[code]
+			retryable := 0
+			if class.retryable {
+				retryable = 1
+			}
+			writeMap(3)
+			w.BulkString("code")
+			w.BulkString(code)
+			w.BulkString("retryable")
+			w.Integer(int64(retryable))
+			w.BulkString("description")
+			w.BulkString(class.description)
+		}
+	}
+	return w.String()
+}
+
+func commandInfos(c *connection, names []string) string {
+	var w resp.Writer
+	w.Array(len(names))
+	for _, name := range names {
+		if spec, ok := commandTable[strings.ToLower(name)]; ok {
+			w.Raw(spec.info(c.protocol >= 3))
+		} else {
+			w.Raw(resp.NilArray)
+		}
+	}
+	return w.String()
+}
@@ -0,0 +1,543 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"readpebble/internal/collection"
+)
+
+// POST /v1/sql runs a read-only query written in a small subset of SQL,
+// for analysts who would rather not assemble search requests:
+//
+//	SELECT * | column { "," column }
+//	FROM collection
+//	[ WHERE condition ]
+//	[ ORDER BY score [ DESC ] ]
+//	[ LIMIT n ]
+//
+// A column is id, text, metadata, vector, score, distance or a dotted path
+// into the metadata of the points, and * stands for id, text and metadata,
+// with score for a nearest-neighbour query. The condition is a filter
+// expression as VSEARCH takes it, which may be joined with AND to a
+// NEAR([x, y, ...]) or NEAR('text') predicate to find the points closest
+// to a vector or to a text embedded with EMBED. Such a query runs as
+// VSEARCH and returns the closest points first, while one without NEAR
+// runs as VSCROLL and returns points in key order.
+
+// sqlDefaultLimit is how many rows a query without LIMIT returns.
+const sqlDefaultLimit = 100
+
+// sqlQuery is a parsed query.
+type sqlQuery struct {
+	columns    []string
+	collection string
+	// filter is the condition without the NEAR predicate.
+	filter string
+	// near is set for a nearest-neighbour query, to the vector or to the
+	// text nearText holds.
+	near     bool
+	vector   []float64
+	nearText string
+	limit    int
+}
+
+type sqlTokenKind int
+
+const (
+	sqlWord sqlTokenKind = iota
+	sqlNumber
+	sqlString
+	sqlSymbol
+)
+
+// sqlToken is a token of a query, with the offsets of its source so that
+// the WHERE clause can be passed on as written.
+type sqlToken struct {
+	kind       sqlTokenKind
+	text       string
+	start, end int
+}
+
+func tokenizeSQL(s string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+			continue
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, badRequest("unterminated string")
+			}
+			i = j + 1
+			tokens = append(tokens, sqlToken{sqlString, b.String(), start, i})
+			continue
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && (s[j] == '.' || s[j] == 'e' || s[j] == 'E' || s[j] >= '0' && s[j] <= '9' ||
+				(s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				j++
+			}
+			i = j
+			tokens = append(tokens, sqlToken{sqlNumber, s[start:i], start, i})
+			continue
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			i = j
+			tokens = append(tokens, sqlToken{sqlWord, s[start:i], start, i})
+			continue
+		case strings.HasPrefix(s[i:], "!=") || strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			i += 2
+		case strings.IndexByte("=<>(),[]*;", c) >= 0:
+			i++
+		default:
+			return nil, badRequest("unexpected character %q", c)
+		}
+		tokens = append(tokens, sqlToken{sqlSymbol, s[start:i], start, i})
+	}
+	return tokens, nil
+}
+
+type sqlParser struct {
+	source string
+	tokens []sqlToken
+	pos    int
+}
+
+func (p *sqlParser) peek() sqlToken {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return sqlToken{kind: sqlSymbol, start: len(p.source), end: len(p.source)}
+}
+
+func (t sqlToken) is(word string) bool {
+	return t.kind == sqlWord && strings.EqualFold(t.text, word)
+}
+
+// keyword consumes the next token if it is the keyword word.
+func (p *sqlParser) keyword(word string) bool {
+	if p.peek().is(word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// symbol consumes the next token if it is the symbol sym.
+func (p *sqlParser) symbol(sym string) bool {
+	if t := p.peek(); t.kind == sqlSymbol && t.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) unexpected(want string) error {
+	if p.pos >= len(p.tokens) {
+		return badRequest("expected %s at end of query", want)
+	}
+	return badRequest("expected %s, got %q", want, p.tokens[p.pos].text)
+}
+
+// parseSQL parses a query.
+func parseSQL(source string) (*sqlQuery, error) {
+	tokens, err := tokenizeSQL(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &sqlParser{source: source, tokens: tokens}
+	q := &sqlQuery{limit: sqlDefaultLimit}
+	if !p.keyword("select") {
+		return nil, p.unexpected("SELECT")
+	}
+	if !p.symbol("*") {
+		for {
+			t := p.peek()
+			if t.kind != sqlWord || t.is("from") || !validColumn(t.text) {
+				return nil, p.unexpected("a column")
+			}
+			p.pos++
+			q.columns = append(q.columns, t.text)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	if !p.keyword("from") {
+		return nil, p.unexpected("FROM")
+	}
+	if t := p.peek(); t.kind == sqlWord || t.kind == sqlString {
+		q.collection = t.text
+		p.pos++
+	} else {
+		return nil, p.unexpected("a collection")
+	}
+	if p.keyword("where") {
+		if err := p.where(q); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("order") {
+		if !p.keyword("by") {
+			return nil, p.unexpected("BY")
+		}
+		if !p.keyword("score") {
+			return nil, p.unexpected("score, the only column to order by")
+		}
+		if p.keyword("asc") {
+			return nil, badRequest("only ORDER BY score DESC is supported")
+		}
+		p.keyword("desc")
+		if !q.near {
+			return nil, badRequest("ORDER BY score needs a NEAR predicate")
+		}
+	}
+	if p.keyword("limit") {
+		t := p.peek()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != sqlNumber || err != nil || n < 0 {
+			return nil, p.unexpected("a non-negative LIMIT")
+		}
+		p.pos++
+		q.limit = n
+	}
+	p.symbol(";")
+	if p.pos < len(p.tokens) {
+		return nil, badRequest("unexpected %q", p.tokens[p.pos].text)
+	}
+	if q.columns == nil {
+		q.columns = []string{"id", "text", "metadata"}
+		if q.near {
+			q.columns = []string{"id", "score", "text", "metadata"}
+		}
+	}
+	for _, column := range q.columns {
+		if (column == "score" || column == "distance") && !q.near {
+			return nil, badRequest("column %s needs a NEAR predicate", column)
+		}
+	}
+	return q, nil
+}
+
+// validColumn reports whether column is a dotted path without empty parts.
+func validColumn(column string) bool {
+	for _, name := range strings.Split(column, ".") {
+		if name == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// where parses the WHERE clause, which runs to ORDER BY, LIMIT or the end
+// of the query. A NEAR predicate in it must be joined to the rest with AND
+// outside of parentheses, and is taken out of the filter.
+func (p *sqlParser) where(q *sqlQuery) error {
+	start, depth, near := p.pos, 0, -1
+	end := p.pos
+	for ; end < len(p.tokens); end++ {
+		t := p.tokens[end]
+		if depth == 0 && (t.is("order") || t.is("limit") || t.kind == sqlSymbol && t.text == ";") {
+			break
+		}
+		switch {
+		case t.kind == sqlSymbol && (t.text == "(" || t.text == "["):
+			depth++
+		case t.kind == sqlSymbol && (t.text == ")" || t.text == "]"):
+			depth--
+		case t.is("near") && end+1 < len(p.tokens) && p.tokens[end+1].kind == sqlSymbol && p.tokens[end+1].text == "(":
+			if depth > 0 || near >= 0 {
+				return badRequest("NEAR must appear once, joined to the other conditions with AND")
+			}
+			near = end
+		}
+	}
+	if end == start {
+		return p.unexpected("a condition")
+	}
+	p.pos = end
+	if near < 0 {
+		q.filter = p.source[p.tokens[start].start:p.tokens[end-1].end]
+		return nil
+	}
+	for i := start; i < end; i++ {
+		if p.tokens[i].is("or") && p.depthAt(start, i) == 0 {
+			return badRequest("NEAR cannot be combined with OR outside of parentheses")
+		}
+	}
+	sub := &sqlParser{source: p.source, tokens: p.tokens[:end], pos: near + 1}
+	if err := sub.near(q); err != nil {
+		return err
+	}
+	// The predicate is cut out with the AND joining it to the filter.
+	cutStart, cutEnd := near, sub.pos
+	switch {
+	case near > start && p.tokens[near-1].is("and"):
+		cutStart = near - 1
+	case sub.pos < end && p.tokens[sub.pos].is("and"):
+		cutEnd = sub.pos + 1
+	case near > start || sub.pos < end:
+		return badRequest("NEAR must be joined to the other conditions with AND")
+	}
+	var filter strings.Builder
+	if cutStart > start {
+		filter.WriteString(p.source[p.tokens[start].start:p.tokens[cutStart-1].end])
+	}
+	if cutEnd < end {
+		if filter.Len() > 0 {
+			filter.WriteByte(' ')
+		}
+		filter.WriteString(p.source[p.tokens[cutEnd].start:p.tokens[end-1].end])
+	}
+	q.filter = filter.String()
+	return nil
+}
+
+// depthAt returns the nesting of parentheses and brackets at token i of
+// a clause starting at token start.
+func (p *sqlParser) depthAt(start, i int) int {
+	depth := 0
+	for _, t := range p.tokens[start:i] {
+		switch {
+		case t.kind == sqlSymbol && (t.text == "(" || t.text == "["):
+			depth++
+		case t.kind == sqlSymbol && (t.text == ")" || t.text == "]"):
+			depth--
+		}
+	}
+	return depth
+}
+
+// near parses the arguments of a NEAR predicate: a vector literal or a
+// text.
+func (p *sqlParser) near(q *sqlQuery) error {
+	q.near = true
+	if !p.symbol("(") {
+		return p.unexpected("'('")
+	}
+	switch t := p.peek(); {
+	case t.kind == sqlString:
+		p.pos++
+		q.nearText = t.text
+		if q.nearText == "" {
+			return badRequest("NEAR needs a non-empty text")
+		}
+	case p.symbol("["):
+		for {
+			t := p.peek()
+			x, err := strconv.ParseFloat(t.text, 64)
+			if t.kind != sqlNumber || err != nil {
+				return p.unexpected("a number")
+			}
+			p.pos++
+			q.vector = append(q.vector, x)
+			if !p.symbol(",") {
+				break
+			}
+		}
+		if !p.symbol("]") {
+			return p.unexpected("']'")
+		}
+	default:
+		return p.unexpected("a vector or a text")
+	}
+	if !p.symbol(")") {
+		return p.unexpected("')'")
+	}
+	return nil
+}
+
+type sqlRequest struct {
+	Query string `json:"query"`
+}
+
+// sqlRow is a point found by a query, with its distance for a
+// nearest-neighbour query.
+type sqlRow struct {
+	point    gatewayPoint
+	distance float64
+}
+
+// sqlPoints runs a query, replying with the columns it selected and a row
+// of their values for each point, null for metadata a point lacks.
+func (s *Server) sqlPoints(c *connection, r *http.Request) (interface{}, error) {
+	var req sqlRequest
+	if err := decodeGatewayBody(r, &req); err != nil {
+		return nil, err
+	}
+	q, err := parseSQL(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	withVector := false
+	for _, column := range q.columns {
+		withVector = withVector || column == "vector"
+	}
+	var found []sqlRow
+	if q.near {
+		found, err = s.sqlSearch(c, q, withVector)
+	} else {
+		found, err = s.sqlScan(c, q, withVector)
+	}
+	if err != nil {
+		return nil, err
+	}
+	coll, err := s.collections.Get(q.collection)
+	if err != nil {
+		return nil, replyError("ERR " + err.Error())
+	}
+	rows := make([][]interface{}, len(found))
+	for i, row := range found {
+		rows[i] = make([]interface{}, len(q.columns))
+		for j, column := range q.columns {
+			rows[i][j] = row.column(column, coll.Metric)
+		}
+	}
+	return map[string]interface{}{"columns": q.columns, "rows": rows}, nil
+}
+
+// column returns the value of the row in column.
+func (row sqlRow) column(column string, metric collection.Metric) interface{} {
+	p := row.point
+	switch column {
+	case "id":
+		return p.ID
+	case "score":
+		return similarity(metric, row.distance)
+	case "distance":
+		return row.distance
+	case "vector":
+		return p.Vector
+	case "text":
+		if p.Text == "" {
+			return nil
+		}
+		return p.Text
+	case "metadata":
+		return p.Metadata
+	}
+	var value interface{} = p.Metadata
+	for _, name := range strings.Split(column, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = fields[name]
+	}
+	return value
+}
+
+// sqlSearch runs a nearest-neighbour query with VSEARCH.
+func (s *Server) sqlSearch(c *connection, q *sqlQuery, withVector bool) ([]sqlRow, error) {
+	vector := formatVector(q.vector)
+	if q.nearText != "" {
+		reply, err := s.gatewayCommand(c, "embed", q.nearText)
+		if err != nil {
+			return nil, err
+		}
+		vectors, _ := reply.([]interface{})
+		if len(vectors) != 1 {
+			return nil, fmt.Errorf("unexpected EMBED reply %v", reply)
+		}
+		vector = replyStrings(vectors[0])
+	}
+	args := append([]string{q.collection, strconv.Itoa(q.limit)}, vector...)
+	if q.filter != "" {
+		args = append(args, "FILTER", q.filter)
+	}
+	reply, err := s.gatewayCommand(c, "vsearch", args...)
+	if err != nil {
+		return nil, err
+	}
+	hits := replyStrings(reply)
+	var rows []sqlRow
+	for i := 0; i+1 < len(hits); i += 2 {
+		distance, err := strconv.ParseFloat(hits[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		p, exists, err := s.loadPoint(c, q.collection, hits[i], withVector)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			rows = append(rows, sqlRow{p, distance})
+		}
+	}
+	return rows, nil
+}
+
+// sqlScan runs a query without NEAR with VSCROLL, a page at a time until
+// it found enough points.
+func (s *Server) sqlScan(c *connection, q *sqlQuery, withVector bool) ([]sqlRow, error) {
+	var rows []sqlRow
+	cursor := "0"
+	for len(rows) < q.limit {
+		args := []string{q.collection, cursor, "COUNT", strconv.Itoa(min(q.limit-len(rows), gatewayPage))}
+		if q.filter != "" {
+			args = append(args, "FILTER", q.filter)
+		}
+		reply, err := s.gatewayCommand(c, "vscroll", args...)
+		if err != nil {
+			return nil, err
+		}
+		parts, _ := reply.([]interface{})
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected VSCROLL reply %v", reply)
+		}
+		for _, id := range replyStrings(parts[1]) {
+			p, exists, err := s.loadPoint(c, q.collection, id, withVector)
+			if err != nil {
+				return nil, err
+			}
+			if exists && len(rows) < q.limit {
+				rows = append(rows, sqlRow{point: p})
+			}
+		}
+		if cursor, _ = parts[0].(string); cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	return rows, nil
+}
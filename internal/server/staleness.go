@@ -0,0 +1,122 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stalenessTTL is how long a measure of the indexing backlog is reused:
+// finding the oldest deferred point reads every one of them, which
+// scrapes and INFO should not repeat back to back while a backlog builds.
+const stalenessTTL = time.Second
+
+// indexLagBuckets are the upper bounds, in seconds, of the histogram of
+// how long writes took to become searchable.
+var indexLagBuckets = []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// staleness is how far what searches see trails the writes: the writes of
+// VADDTEXT whose text waits to be embedded, queued in memory by the Queue
+// fallback or deferred in the collections, are not searchable until their
+// vector is added.
+type staleness struct {
+	// queued and deferred count the writes in the memory queue and the
+	// deferred points, inFlight the deferred points being embedded.
+	queued   int64
+	deferred int64
+	inFlight int64
+	// oldest is when the oldest of them was written, zero if none.
+	oldest time.Time
+}
+
+func (st staleness) pending() int64 {
+	return st.queued + st.deferred
+}
+
+// age returns how long the oldest pending write has waited by now.
+func (st staleness) age(now time.Time) time.Duration {
+	if st.oldest.IsZero() {
+		return 0
+	}
+	return max(now.Sub(st.oldest), 0)
+}
+
+// stalenessCache keeps the last measure of the indexing backlog.
+type stalenessCache struct {
+	mutex sync.Mutex
+	last  staleness
+	at    time.Time
+}
+
+// indexStaleness measures the indexing backlog, reusing a measure taken
+// less than stalenessTTL ago.
+func (s *Server) indexStaleness() (staleness, error) {
+	cache := &s.staleness
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	now := time.Now()
+	if !cache.at.IsZero() && now.Sub(cache.at) < stalenessTTL {
+		return cache.last, nil
+	}
+	stats, err := s.collections.DeferredStats(now)
+	if err != nil {
+		return staleness{}, err
+	}
+	st := staleness{deferred: int64(stats.Pending), oldest: stats.Oldest}
+	if e := s.embedder; e != nil {
+		var queuedAt time.Time
+		st.queued, queuedAt = e.queueBacklog()
+		if !queuedAt.IsZero() && (st.oldest.IsZero() || queuedAt.Before(st.oldest)) {
+			st.oldest = queuedAt
+		}
+		e.mutex.Lock()
+		st.inFlight = int64(len(e.inFlight))
+		e.mutex.Unlock()
+	}
+	cache.last, cache.at = st, now
+	return st, nil
+}
+
+// infoIndexing reports how far searches trail the writes, so that users
+// relying on near-real-time search can alert on indexing falling behind.
+func (s *Server) infoIndexing(b *strings.Builder) {
+	st, err := s.indexStaleness()
+	if err != nil {
+		fmt.Fprintf(b, "indexing_error:%s\r\n", err)
+		return
+	}
+	fmt.Fprintf(b, "indexing_pending:%d\r\n", st.pending())
+	fmt.Fprintf(b, "indexing_queued:%d\r\n", st.queued)
+	fmt.Fprintf(b, "indexing_deferred:%d\r\n", st.deferred)
+	fmt.Fprintf(b, "indexing_in_flight:%d\r\n", st.inFlight)
+	var oldest int64
+	if !st.oldest.IsZero() {
+		oldest = st.oldest.Unix()
+	}
+	fmt.Fprintf(b, "indexing_oldest_pending:%d\r\n", oldest)
+	fmt.Fprintf(b, "indexing_lag_seconds:%.3f\r\n", st.age(time.Now()).Seconds())
+}
@@ -0,0 +1,363 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/embed"
+	"readpebble/internal/metrics"
+)
+
+// stats holds the server-wide metrics exported over HTTP.
+type stats struct {
+	registry    *metrics.Registry
+	startTime   time.Time
+	connections *metrics.Counter
+	yields      *metrics.Counter
+	flushes     *metrics.Counter
+	vectored    *metrics.Counter
+	streamed    *metrics.Counter
+	expired     *metrics.Counter
+	// tooLarge counts the commands rejected for exceeding MaxCommandSize.
+	tooLarge *metrics.Counter
+	// indexLag observes how long writes whose text waited to be embedded
+	// took to become searchable.
+	indexLag *metrics.Histogram
+	// fullSyncs and partialSyncs count the replicas that attached with a
+	// snapshot and from the backlog.
+	fullSyncs    *metrics.Counter
+	partialSyncs *metrics.Counter
+	// collectionLabels and tenantLabels bound the label values of the
+	// per-collection metrics.
+	collectionLabels *labelGuard
+	tenantLabels     *labelGuard
+	// commands are the calls of each command of commandTable, for INFO
+	// commandstats.
+	commandsMutex sync.Mutex
+	commands      map[string]*commandStats
+}
+
+// commandStats count the calls of a command, their time, and those that
+// replied with an error.
+type commandStats struct {
+	calls  int64
+	failed int64
+	time   time.Duration
+}
+
+// otherLabel is the label value collections and tenants beyond
+// MetricsLabelLimit are reported under.
+const otherLabel = "other"
+
+// labelGuard hands out label values first come, first served, up to a
+// limit, after which new values are reported as otherLabel. Values are
+// never given back, as the series created for them stay in the registry.
+type labelGuard struct {
+	mutex  sync.Mutex
+	limit  int
+	values map[string]struct{}
+}
+
+func newLabelGuard(limit int) *labelGuard {
+	return &labelGuard{limit: limit, values: make(map[string]struct{})}
+}
+
+func (g *labelGuard) value(v string) string {
+	if v == "" {
+		return v
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if _, ok := g.values[v]; ok {
+		return v
+	}
+	if len(g.values) >= g.limit {
+		return otherLabel
+	}
+	g.values[v] = struct{}{}
+	return v
+}
+
+// latencyBuckets are the upper bounds, in seconds, of the command latency
+// histograms.
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+func (s *Server) newStats() *stats {
+	registry := metrics.NewRegistry()
+	st := &stats{
+		registry:     registry,
+		startTime:    time.Now(),
+		connections:  registry.Counter("vecble_connections_total", "Connections accepted since startup."),
+		yields:       registry.Counter("vecble_pipeline_yields_total", "Times a pipelining connection gave up its worker to other clients."),
+		flushes:      registry.Counter("vecble_reply_flushes_total", "Writes of batched replies to client connections."),
+		vectored:     registry.Counter("vecble_reply_vectored_flushes_total", "Flushes of batched replies sending large replies along with a single vectored write."),
+		streamed:     registry.Counter("vecble_reply_streams_total", "Replies streamed to clients a chunk at a time as they were encoded."),
+		expired:      registry.Counter("vecble_expired_keys_total", "Keys deleted because their expiry passed."),
+		tooLarge:     registry.Counter("vecble_commands_too_large_total", "Commands rejected unread for exceeding the maximum command size."),
+		indexLag:     registry.Histogram("vecble_index_lag_seconds", "Time from a write whose text waited to be embedded to its point becoming searchable.", indexLagBuckets),
+		fullSyncs:    registry.Counter("vecble_replication_full_syncs_total", "Replicas sent a snapshot of the keyspace on attaching."),
+		partialSyncs: registry.Counter("vecble_replication_partial_syncs_total", "Replicas that resumed from the replication backlog on attaching."),
+
+		collectionLabels: newLabelGuard(s.config.MetricsLabelLimit),
+		tenantLabels:     newLabelGuard(s.config.MetricsLabelLimit),
+		commands:         make(map[string]*commandStats),
+	}
+	registry.GaugeFunc("vecble_uptime_seconds", "Seconds since the server started.", func() float64 {
+		return time.Since(st.startTime).Seconds()
+	})
+	registry.GaugeFunc("vecble_connected_clients", "Currently open client connections.", func() float64 {
+		return float64(s.clients.count())
+	})
+	registry.GaugeFunc("vecble_commands_in_flight", "Commands currently executing.", func() float64 {
+		return float64(s.load.queueDepth.Load())
+	})
+	registry.GaugeFunc("vecble_command_workers_busy", "Command workers currently executing a command.", func() float64 {
+		return float64(len(s.sched.slots))
+	})
+	registry.GaugeFunc("vecble_compaction_debt_bytes", "Estimated bytes Pebble still has to compact.", func() float64 {
+		return float64(s.load.compactionDebt.Load())
+	})
+	registry.GaugeFunc("vecble_replication_lag_bytes", "Bytes of the master's stream received but not applied yet.", func() float64 {
+		st, _ := s.replicationStatus()
+		return float64(st.queuedBytes)
+	})
+	registry.GaugeFunc("vecble_replication_lag_ops", "Commands of the master's stream received but not applied yet.", func() float64 {
+		st, _ := s.replicationStatus()
+		return float64(st.queuedOps)
+	})
+	registry.CounterFunc("vecble_replication_stream_pauses_total", "Times the replica stopped reading the master's stream to catch up.", func() float64 {
+		st, _ := s.replicationStatus()
+		return float64(st.pauses)
+	})
+	registry.GaugeFunc("vecble_collections", "Vector collections.", func() float64 {
+		return float64(len(s.collections.List()))
+	})
+	registry.CounterFunc("vecble_wal_group_syncs_total", "WAL fsyncs shared by batched writes.", func() float64 {
+		return float64(s.committer.Syncs())
+	})
+	registry.CounterFunc("vecble_background_reads_throttled_total", "Maintenance reads delayed to give client reads priority.", func() float64 {
+		return float64(s.io.throttled.Load())
+	})
+	registry.CounterFunc("vecble_hedged_reads_total", "GETs that issued a second read after HedgeAfter.", func() float64 {
+		return float64(s.io.hedged.Load())
+	})
+	registry.GaugeFunc("vecble_index_oplog_entries", "Index op log entries not yet covered by a checkpoint.", func() float64 {
+		return float64(s.collections.PendingOps())
+	})
+	registry.GaugeFunc("vecble_index_memory_bytes", "Estimated bytes the HNSW graphs of the collections take in memory.", func() float64 {
+		var total int64
+		for _, u := range s.collections.IndexUsage() {
+			total += u.Bytes
+		}
+		return float64(total)
+	})
+	registry.CounterFunc("vecble_index_demotions_total", "HNSW graphs dropped from memory to keep within the index memory budget.", func() float64 {
+		return float64(s.collections.IndexDemotions())
+	})
+	registry.CounterFunc("vecble_index_reloads_total", "Demoted HNSW graphs reloaded by a search.", func() float64 {
+		return float64(s.collections.IndexReloads())
+	})
+	registry.CounterFunc("vecble_searches_degraded_total", "Searches whose deadline lowered their effort or cut them short.", func() float64 {
+		return float64(s.collections.Degraded())
+	})
+	registry.CounterFunc("vecble_points_evicted_total", "Points evicted to keep tenants within their quota.", func() float64 {
+		return float64(s.collections.Evicted())
+	})
+	registry.CounterFunc("vecble_points_outliers_total", "Points flagged as outliers when written.", func() float64 {
+		return float64(s.collections.OutliersFlagged())
+	})
+	registry.GaugeFunc("vecble_disk_usage_bytes", "Bytes used on disk by the Pebble store.", func() float64 {
+		return float64(s.db.Metrics().DiskSpaceUsage())
+	})
+	registry.GaugeFunc("vecble_memtable_bytes", "Bytes allocated by Pebble memtables.", func() float64 {
+		return float64(s.db.Metrics().MemTable.Size)
+	})
+	registry.GaugeFunc("vecble_wal_bytes", "Live bytes in the write-ahead log.", func() float64 {
+		return float64(s.db.Metrics().WAL.Size)
+	})
+	registry.GaugeFunc("vecble_wal_physical_bytes", "Bytes the write-ahead log files take on disk.", func() float64 {
+		return float64(s.db.Metrics().WAL.PhysicalSize)
+	})
+	registry.GaugeFunc("vecble_tombstones", "Approximate number of deletion tombstones not yet compacted away.", func() float64 {
+		return float64(s.db.Metrics().Keys.TombstoneCount)
+	})
+	registry.GaugeFunc("vecble_zombie_table_bytes", "Bytes in obsolete tables still held open by readers.", func() float64 {
+		return float64(s.db.Metrics().Table.ZombieSize)
+	})
+	if sh := s.shadow; sh != nil {
+		registry.CounterFunc("vecble_shadow_forwarded_total", "Writes forwarded to the shadow instance.", func() float64 {
+			return float64(sh.forwarded.Load())
+		})
+		registry.CounterFunc("vecble_shadow_dropped_total", "Writes not forwarded because the shadow queue was full.", func() float64 {
+			return float64(sh.dropped.Load())
+		})
+		registry.CounterFunc("vecble_shadow_errors_total", "Forwarded writes the shadow failed or rejected.", func() float64 {
+			return float64(sh.failed.Load())
+		})
+		registry.CounterFunc("vecble_shadow_keys_compared_total", "Keys compared with the shadow.", func() float64 {
+			return float64(sh.compared.Load())
+		})
+		registry.CounterFunc("vecble_shadow_keys_divergent_total", "Compared keys whose value differed on the shadow or was missing there.", func() float64 {
+			return float64(sh.diverged.Load())
+		})
+	}
+	if e := s.embedder; e != nil {
+		registry.CounterFunc("vecble_embedder_calls_total", "Calls made to the embedding provider.", func() float64 {
+			return float64(e.Calls())
+		})
+		registry.CounterFunc("vecble_embedder_failures_total", "Calls to the embedding provider that failed or timed out.", func() float64 {
+			return float64(e.Failures())
+		})
+		registry.CounterFunc("vecble_embedder_rejected_total", "Embeddings refused without calling the provider because the circuit breaker was open.", func() float64 {
+			return float64(e.Rejected())
+		})
+		for _, state := range []embed.State{embed.Closed, embed.Open, embed.HalfOpen} {
+			registry.GaugeFunc("vecble_embedder_circuit_state", "1 for the current state of the embedding provider's circuit breaker.", func() float64 {
+				if e.State() == state {
+					return 1
+				}
+				return 0
+			}, "state", state.String())
+		}
+		registry.CounterFunc("vecble_embedder_points_embedded_total", "Queued and deferred points embedded and added to their collection.", func() float64 {
+			return float64(e.embedded.Load())
+		})
+		registry.CounterFunc("vecble_embedder_points_dropped_total", "Queued and deferred points dropped because they could not be embedded or added.", func() float64 {
+			return float64(e.dropped.Load())
+		})
+		registry.GaugeFunc("vecble_embedder_queued", "Writes queued in memory until the embedding provider is back.", func() float64 {
+			return float64(len(e.queue))
+		})
+		if c := e.cache; c != nil {
+			registry.CounterFunc("vecble_embedder_cache_hits_total", "Texts whose vector was found in the embedding cache.", func() float64 {
+				return float64(c.Hits())
+			})
+			registry.CounterFunc("vecble_embedder_cache_misses_total", "Texts not found in the embedding cache and sent to the provider.", func() float64 {
+				return float64(c.Misses())
+			})
+		}
+		registry.GaugeFunc("vecble_embedder_deferred_points", "Points stored without a vector until the embedding provider is back.", func() float64 {
+			_, n, _ := s.collections.Deferred(0, nil)
+			return float64(n)
+		})
+	}
+	registry.GaugeFunc("vecble_index_pending_writes", "Writes not searchable yet because their text waits to be embedded.", func() float64 {
+		st, _ := s.indexStaleness()
+		return float64(st.pending())
+	})
+	registry.GaugeFunc("vecble_index_oldest_pending_age_seconds", "Age of the oldest write not searchable yet, 0 when every write is.", func() float64 {
+		st, _ := s.indexStaleness()
+		return st.age(time.Now()).Seconds()
+	})
+	registry.RegisterCollector(func(emit func(name, help string, value float64, labels ...string)) {
+		usages, err := s.collections.SpaceUsage()
+		if err != nil {
+			return
+		}
+		// Collections beyond the label limit add up under "other".
+		type labels struct{ collection, tenant string }
+		var order []labels
+		totals := make(map[labels]*collection.SpaceUsage)
+		for _, u := range usages {
+			l := labels{st.collectionLabels.value(u.Name), st.tenantLabels.value(u.Tenant)}
+			total, ok := totals[l]
+			if !ok {
+				total = &collection.SpaceUsage{}
+				totals[l] = total
+				order = append(order, l)
+			}
+			total.Points += u.Points
+			total.LogicalBytes += u.LogicalBytes
+			total.DiskBytes += u.DiskBytes
+		}
+		for _, l := range order {
+			u := totals[l]
+			if u.LogicalBytes > 0 {
+				u.Amplification = float64(u.DiskBytes) / float64(u.LogicalBytes)
+			}
+			emit("vecble_collection_points", "Points per collection.", float64(u.Points), "collection", l.collection, "tenant", l.tenant)
+			emit("vecble_collection_logical_bytes", "Size of a collection's keys and values.", float64(u.LogicalBytes), "collection", l.collection, "tenant", l.tenant)
+			emit("vecble_collection_disk_bytes", "Estimated on-disk size of a collection.", float64(u.DiskBytes), "collection", l.collection, "tenant", l.tenant)
+			emit("vecble_collection_space_amplification", "On-disk bytes per logical byte of a collection.", u.Amplification, "collection", l.collection, "tenant", l.tenant)
+		}
+	})
+	return st
+}
+
+// command records a call of cmd, which took elapsed and replied reply.
+func (st *stats) command(cmd string, elapsed time.Duration, reply string) {
+	st.registry.Counter("vecble_commands_total", "Commands processed, by command name.", "cmd", cmd).Inc()
+	if _, ok := commandTable[cmd]; !ok {
+		return
+	}
+	st.commandsMutex.Lock()
+	defer st.commandsMutex.Unlock()
+	cs, ok := st.commands[cmd]
+	if !ok {
+		cs = &commandStats{}
+		st.commands[cmd] = cs
+	}
+	cs.calls++
+	cs.time += elapsed
+	if strings.HasPrefix(reply, "-") {
+		cs.failed++
+	}
+}
+
+// commandTotals returns a copy of the stats of the commands called so
+// far, by name.
+func (st *stats) commandTotals() map[string]commandStats {
+	st.commandsMutex.Lock()
+	defer st.commandsMutex.Unlock()
+	stats := make(map[string]commandStats, len(st.commands))
+	for name, cs := range st.commands {
+		stats[name] = *cs
+	}
+	return stats
+}
+
+// collectionCommand records the latency and failure of cmd, labelled by
+// collection and tenant, if it is a vector command naming a collection.
+// Collections that do not exist are reported as "other", so that mistyped
+// names do not use up label values.
+func (s *Server) collectionCommand(cmd string, args []string, reply string, elapsed time.Duration) {
+	spec, ok := commandTable[cmd]
+	if !ok || !spec.hasCategory("vector") || spec.FirstKey == 0 || len(args) < spec.FirstKey {
+		return
+	}
+	name, tenant := otherLabel, ""
+	if c, err := s.collections.Get(args[spec.FirstKey-1]); err == nil {
+		name, tenant = c.Name, c.Tenant
+	}
+	st := s.stats
+	labels := []string{"cmd", cmd, "collection", st.collectionLabels.value(name), "tenant", st.tenantLabels.value(tenant)}
+	st.registry.Histogram("vecble_collection_command_duration_seconds", "Latency of vector commands, by collection and tenant.", latencyBuckets, labels...).Observe(elapsed.Seconds())
+	if strings.HasPrefix(reply, "-") {
+		st.registry.Counter("vecble_collection_command_errors_total", "Vector commands that replied with an error, by collection and tenant.", labels...).Inc()
+	}
+}
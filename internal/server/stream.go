@@ -0,0 +1,84 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"log"
+
+	"readpebble/internal/resp"
+)
+
+// streamChunkSize is how much of a streamed reply is encoded before it is
+// sent.
+const streamChunkSize = 64 << 10
+
+// replyStream encodes a reply into w, calling flush after every element
+// so that the reply can be sent in chunks as it is encoded. An error is
+// returned as is: once part of the reply is sent, it can no longer be
+// replaced by an error reply.
+type replyStream func(w *resp.Writer, flush func() error) error
+
+// streamReply replies with the output of fn. Commands whose replies grow
+// with the data, such as SMEMBERS of a huge set, use it so that the reply
+// is never held in memory as a whole: on a client connection fn runs once
+// the command returned, reading from a snapshot or other state it owns
+// since the command's locks are released by then, and the reply is
+// written to the client a chunk at a time. Elsewhere, such as on the HTTP
+// gateway, the reply is encoded in full and returned.
+func (c *connection) streamReply(fn replyStream) string {
+	if c.streamable {
+		c.stream = fn
+		return ""
+	}
+	var w resp.Writer
+	if err := fn(&w, func() error { return nil }); err != nil {
+		return resp.Error("ERR Failed to read reply: " + err.Error())
+	}
+	return w.String()
+}
+
+// sendStream runs the reply stream left by the command just handled on c,
+// sending what it encodes to writer whenever a chunk is ready, along with
+// the replies queued before it.
+func (s *Server) sendStream(c *connection, writer *replyWriter) error {
+	stream := c.stream
+	c.stream = nil
+	var w resp.Writer
+	flush := func() error {
+		if w.Len() < streamChunkSize {
+			return nil
+		}
+		err := writer.Send(w.Bytes())
+		w.Reset()
+		return err
+	}
+	err := stream(&w, flush)
+	if err == nil {
+		err = writer.Send(w.Bytes())
+	} else {
+		log.Printf("Streaming a reply to %s failed: %v", c.conn.RemoteAddr(), err)
+	}
+	s.stats.streamed.Inc()
+	return err
+}
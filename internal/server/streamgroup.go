@@ -0,0 +1,575 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// A consumer group shares a stream among the consumers reading it with
+// XREADGROUP: each entry is delivered to one of them, and stays pending
+// in the group, against that consumer, until it acknowledges it with XACK.
+// A consumer that fails before acknowledging finds its pending entries
+// again by reading from an ID rather than >, and XPENDING lists them, so
+// no entry goes unprocessed. The group's state lives among the stream's
+// elements (see storage/stream.go), written in the same batch as the
+// reads that change it.
+
+// errNoGroup is returned for a consumer group that does not exist.
+var errNoGroup = errors.New("no such consumer group")
+
+// noGroupError is the reply to a command naming a consumer group that
+// does not exist.
+func noGroupError(key, group string) string {
+	return resp.Error("NOGROUP No such consumer group '" + group + "' for key name '" + key + "'")
+}
+
+// loadStreamGroup returns the last ID delivered to a consumer group of the
+// stream at key and whether the group exists.
+func (s *Server) loadStreamGroup(key []byte, group string) (storage.StreamID, bool, error) {
+	value, err := s.io.get(s.db, storage.StreamGroupKey(key, group))
+	if err == pebble.ErrNotFound {
+		return storage.StreamID{}, false, nil
+	}
+	if err != nil {
+		return storage.StreamID{}, false, err
+	}
+	last, err := storage.DecodeStreamGroup(value)
+	return last, err == nil, err
+}
+
+// streamConsumerExists reports whether a consumer group of the stream at
+// key has consumer.
+func (s *Server) streamConsumerExists(key []byte, group, consumer string) (bool, error) {
+	_, err := s.io.get(s.db, storage.StreamConsumerKey(key, group, consumer))
+	if err == pebble.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// xgroup implements XGROUP CREATE key group id|$ [MKSTREAM], SETID key
+// group id|$, DESTROY key group, CREATECONSUMER key group consumer and
+// DELCONSUMER key group consumer. A group created at, or set to, an ID is
+// next delivered the entries after it, $ standing for the stream's last
+// one. DESTROY replies with the number of groups deleted, CREATECONSUMER
+// with the number of consumers created, and DELCONSUMER with the number
+// of entries that were pending for the consumer, which are dropped.
+func (s *Server) xgroup(args []string) string {
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "create" && (len(args) == 4 || len(args) == 5 && strings.ToLower(args[4]) == "mkstream"):
+	case (sub == "setid" || sub == "createconsumer" || sub == "delconsumer") && len(args) == 4:
+	case sub == "destroy" && len(args) == 3:
+	case sub == "create" || sub == "setid" || sub == "createconsumer" || sub == "delconsumer" || sub == "destroy":
+		return resp.Error("ERR syntax error")
+	default:
+		return resp.Error("ERR unknown XGROUP subcommand '" + args[0] + "'")
+	}
+	key, group := []byte(args[1]), args[2]
+	defer s.keyLocks.lock(args[1])()
+	meta, _, last, exists, err := s.loadStream(key)
+	if err != nil {
+		return typeError(err)
+	}
+	if !exists && !(sub == "create" && len(args) == 5) {
+		return resp.Error("ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	_, found, err := s.loadStreamGroup(key, group)
+	if err != nil {
+		return typeError(err)
+	}
+	if sub == "create" && found {
+		return resp.Error("BUSYGROUP Consumer Group name already exists")
+	}
+	if sub != "create" && sub != "destroy" && !found {
+		return noGroupError(args[1], group)
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	var reply string
+	switch sub {
+	case "create", "setid":
+		at := last
+		if args[3] != "$" {
+			id, ok := parseStreamID(args[3], 0)
+			if !ok {
+				return resp.Error("ERR " + errStreamIDInvalid.Error())
+			}
+			at = id
+		}
+		if !exists {
+			// As in XADD, elements of a stream the key held before must not
+			// come back.
+			storage.DeleteElements(batch, key, storage.Meta{Type: storage.ObjectTypeStream})
+			meta = storage.NewMeta(storage.ObjectTypeStream)
+			meta.LastAccess = time.Now()
+			batch.Set(key, storage.EncodeStreamHeader(0, storage.StreamID{}), nil)
+			storage.SetMeta(batch, key, meta)
+		}
+		batch.Set(storage.StreamGroupKey(key, group), storage.EncodeStreamGroup(at), nil)
+		reply = resp.OK
+	case "destroy":
+		if !found {
+			return resp.Integer(0)
+		}
+		batch.Delete(storage.StreamGroupKey(key, group), nil)
+		for _, prefix := range [][]byte{storage.StreamConsumersPrefix(key, group), storage.StreamPendingPrefix(key, group)} {
+			if err := batch.DeleteRange(prefix, keyPrefixEnd(prefix), nil); err != nil {
+				return typeError(err)
+			}
+		}
+		reply = resp.Integer(1)
+	case "createconsumer":
+		consumer := args[3]
+		known, err := s.streamConsumerExists(key, group, consumer)
+		if err != nil {
+			return typeError(err)
+		}
+		if known {
+			return resp.Integer(0)
+		}
+		batch.Set(storage.StreamConsumerKey(key, group, consumer), nil, nil)
+		reply = resp.Integer(1)
+	case "delconsumer":
+		consumer := args[3]
+		var dropped int64
+		err := s.scanPending(key, group, storage.StreamID{}, func(id storage.StreamID, p storage.PendingEntry) bool {
+			if p.Consumer == consumer {
+				batch.Delete(storage.StreamPendingKey(key, group, id), nil)
+				dropped++
+			}
+			return true
+		})
+		if err != nil {
+			return typeError(err)
+		}
+		batch.Delete(storage.StreamConsumerKey(key, group, consumer), nil)
+		reply = resp.Integer(dropped)
+	}
+	if err := s.committer.Commit(batch, s.writeMode("xgroup")); err != nil {
+		return typeError(err)
+	}
+	return reply
+}
+
+// scanPending calls fn with the entries pending in a consumer group of the
+// stream at key, from the ID start on and in ID order, until fn returns
+// false.
+func (s *Server) scanPending(key []byte, group string, start storage.StreamID, fn func(id storage.StreamID, p storage.PendingEntry) bool) error {
+	prefix := storage.StreamPendingPrefix(key, group)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: storage.StreamPendingKey(key, group, start),
+		UpperBound: keyPrefixEnd(prefix),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		id, ok := storage.DecodeStreamEntryKey(prefix, iter.Key())
+		if !ok {
+			continue
+		}
+		p, err := storage.DecodePendingEntry(iter.Value())
+		if err != nil {
+			return err
+		}
+		if !fn(id, p) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// xreadGroupOptions returns the group, consumer and options of XREADGROUP
+// GROUP group consumer [COUNT count] [BLOCK ms] [NOACK] STREAMS key [key
+// ...] id [id ...].
+func xreadGroupOptions(args []string) (group, consumer string, opts xreadOptions, err error) {
+	if len(args) < 3 || strings.ToLower(args[0]) != "group" {
+		return "", "", opts, errors.New("syntax error")
+	}
+	opts, err = parseXRead(args[3:], true)
+	return args[1], args[2], opts, err
+}
+
+// xreadGroupKeys returns the keys of XREADGROUP.
+func xreadGroupKeys(args []string) []string {
+	_, _, opts, _ := xreadGroupOptions(args)
+	return opts.keys
+}
+
+// xreadGroupUnblocked returns the arguments of XREADGROUP without its
+// BLOCK option, for the copies of the command replicas and the shadow
+// repeat: the entries it read are there by then, and they must not wait
+// when there were none.
+func xreadGroupUnblocked(args []string) []string {
+	unblocked := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "block":
+			i++
+			continue
+		case "streams":
+			return append(unblocked, args[i:]...)
+		}
+		unblocked = append(unblocked, args[i])
+	}
+	return unblocked
+}
+
+// xreadgroup implements XREADGROUP GROUP group consumer [COUNT count]
+// [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...] (see XREAD for the
+// options). With the ID >, it delivers up to count entries the group was
+// not delivered yet to consumer, leaving them pending against it unless
+// NOACK is given. With any other ID, it delivers again up to count of the
+// entries after it pending against consumer, nil standing for the fields
+// of the ones deleted since, and never blocks. Consumers are created the
+// first time they read.
+func (s *Server) xreadgroup(c *connection, args []string) string {
+	group, consumer, opts, err := xreadGroupOptions(args)
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	after := make([]storage.StreamID, len(opts.keys))
+	for i, arg := range opts.ids {
+		if arg == ">" {
+			continue
+		}
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return resp.Error("ERR " + errStreamIDInvalid.Error())
+		}
+		after[i] = id
+		opts.blocking = false
+	}
+
+	var wake <-chan struct{}
+	var timeout <-chan time.Time
+	if opts.blocking {
+		ch, cancel := s.feeds.subscribe(opts.keys)
+		defer cancel()
+		wake = ch
+		if opts.block > 0 {
+			timer := time.NewTimer(opts.block)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+	}
+	for {
+		reads, missing, err := s.readGroup(group, consumer, opts, after)
+		if err == errNoGroup {
+			return resp.Error("NOGROUP No such key '" + missing + "' or consumer group '" + group +
+				"' in XREADGROUP with GROUP option")
+		}
+		if err != nil {
+			return typeError(err)
+		}
+		if len(reads) > 0 {
+			return xreadReply(c, reads)
+		}
+		if !opts.blocking {
+			return resp.NilArray
+		}
+		woken := false
+		s.blocked(c, func() {
+			select {
+			case <-wake:
+				woken = true
+			case <-timeout:
+			case <-c.done():
+			case <-s.quitCh:
+			}
+		})
+		if !woken {
+			return resp.NilArray
+		}
+	}
+}
+
+// readGroup makes one attempt of XREADGROUP at delivering entries of the
+// streams of opts to consumer, reading its pending entries after the
+// matching ID of after for the streams not read with >. It fails with
+// errNoGroup, and the key, when a stream or its group is missing.
+func (s *Server) readGroup(group, consumer string, opts xreadOptions, after []storage.StreamID) ([]streamRead, string, error) {
+	defer s.keyLocks.lockAll(opts.keys)()
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	now := time.Now()
+	var reads []streamRead
+	for i, name := range opts.keys {
+		key := []byte(name)
+		_, _, _, exists, err := s.loadStream(key)
+		if err != nil {
+			return nil, "", err
+		}
+		last, found, err := s.loadStreamGroup(key, group)
+		if err != nil {
+			return nil, "", err
+		}
+		if !exists || !found {
+			return nil, name, errNoGroup
+		}
+		known, err := s.streamConsumerExists(key, group, consumer)
+		if err != nil {
+			return nil, "", err
+		}
+		if !known {
+			batch.Set(storage.StreamConsumerKey(key, group, consumer), nil, nil)
+		}
+
+		if opts.ids[i] != ">" {
+			entries, err := s.redeliver(batch, key, group, consumer, after[i], opts.count, now)
+			if err != nil {
+				return nil, "", err
+			}
+			reads = append(reads, streamRead{key: name, entries: entries})
+			continue
+		}
+		start, ok := followingStreamID(last)
+		if !ok {
+			continue
+		}
+		entries, err := s.rangeStream(key, start, maxStreamID, opts.count)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		batch.Set(storage.StreamGroupKey(key, group), storage.EncodeStreamGroup(entries[len(entries)-1].id), nil)
+		if !opts.noAck {
+			// An entry still pending, which a SETID back makes possible, is
+			// handed over to consumer.
+			pending := storage.PendingEntry{Consumer: consumer, DeliveredAt: now, Deliveries: 1}.Encode()
+			for _, e := range entries {
+				batch.Set(storage.StreamPendingKey(key, group, e.id), pending, nil)
+			}
+		}
+		reads = append(reads, streamRead{key: name, entries: entries})
+	}
+	if !batch.Empty() {
+		if err := s.committer.Commit(batch, s.writeMode("xreadgroup")); err != nil {
+			return nil, "", err
+		}
+	}
+	return reads, "", nil
+}
+
+// redeliver returns up to count of the entries after the ID after pending
+// in a consumer group against consumer, adding to batch the updates of
+// their delivery time and count.
+func (s *Server) redeliver(batch *pebble.Batch, key []byte, group, consumer string, after storage.StreamID, count int, now time.Time) ([]streamEntry, error) {
+	entries := []streamEntry{}
+	start, ok := followingStreamID(after)
+	if !ok || count == 0 {
+		return entries, nil
+	}
+	var err error
+	scanErr := s.scanPending(key, group, start, func(id storage.StreamID, p storage.PendingEntry) bool {
+		if p.Consumer != consumer {
+			return true
+		}
+		var value []byte
+		value, err = s.io.get(s.db, storage.StreamEntryKey(key, id))
+		e := streamEntry{id: id}
+		switch err {
+		case nil:
+			if e.fields, err = storage.DecodeStreamEntry(value); err != nil {
+				return false
+			}
+		case pebble.ErrNotFound:
+			err = nil
+		default:
+			return false
+		}
+		p.DeliveredAt = now
+		p.Deliveries++
+		batch.Set(storage.StreamPendingKey(key, group, id), p.Encode(), nil)
+		entries = append(entries, e)
+		return len(entries) != count
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return entries, err
+}
+
+// xack implements XACK key group id [id ...], acknowledging entries
+// pending in a consumer group and replying with how many were.
+func (s *Server) xack(args []string) string {
+	ids := make([]storage.StreamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return resp.Error("ERR " + errStreamIDInvalid.Error())
+		}
+		ids = append(ids, id)
+	}
+	key, group := []byte(args[0]), args[1]
+	defer s.keyLocks.lock(args[0])()
+	if _, _, _, _, err := s.loadStream(key); err != nil {
+		return typeError(err)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	var acked int64
+	for _, id := range ids {
+		pendingKey := storage.StreamPendingKey(key, group, id)
+		if _, err := s.io.get(s.db, pendingKey); err == pebble.ErrNotFound {
+			continue
+		} else if err != nil {
+			return typeError(err)
+		}
+		batch.Delete(pendingKey, nil)
+		acked++
+	}
+	if acked > 0 {
+		if err := s.committer.Commit(batch, s.writeMode("xack")); err != nil {
+			return typeError(err)
+		}
+	}
+	return resp.Integer(acked)
+}
+
+// xpending implements XPENDING key group [[IDLE ms] start end count
+// [consumer]]. Without a range, it replies with the number of entries
+// pending in the group, the first and last of their IDs and how many each
+// consumer has. With one, it lists up to count of the pending entries
+// from start to end (see XRANGE), of consumer only if given and idle for
+// at least ms milliseconds with IDLE, each as its ID, its consumer, the
+// milliseconds since it was last delivered and how many times it was.
+func (s *Server) xpending(args []string) string {
+	key, group := []byte(args[0]), args[1]
+	var idle time.Duration
+	rest := args[2:]
+	if len(rest) >= 2 && strings.ToLower(rest[0]) == "idle" {
+		ms, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil {
+			return resp.Error("ERR value is not an integer or out of range")
+		}
+		idle = time.Duration(ms) * time.Millisecond
+		rest = rest[2:]
+	}
+	if len(rest) != 0 && len(rest) != 3 && len(rest) != 4 || len(rest) == 0 && len(args) > 2 {
+		return resp.Error("ERR syntax error")
+	}
+	_, exists, err := s.lookupType(key, storage.ObjectTypeStream)
+	if err != nil {
+		return typeError(err)
+	}
+	_, found, err := s.loadStreamGroup(key, group)
+	if err != nil {
+		return typeError(err)
+	}
+	if !exists || !found {
+		return noGroupError(args[0], group)
+	}
+	if len(rest) == 0 {
+		return s.pendingSummary(key, group)
+	}
+
+	start, startOK, err := parseRangeBound(rest[0], false)
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	end, endOK, err := parseRangeBound(rest[1], true)
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	count, err := strconv.Atoi(rest[2])
+	if err != nil {
+		return resp.Error("ERR value is not an integer or out of range")
+	}
+	var entries []string
+	if startOK && endOK && count > 0 {
+		now := time.Now()
+		err = s.scanPending(key, group, start, func(id storage.StreamID, p storage.PendingEntry) bool {
+			if end.Less(id) {
+				return false
+			}
+			if len(rest) == 4 && p.Consumer != rest[3] || now.Sub(p.DeliveredAt) < idle {
+				return true
+			}
+			entries = append(entries, resp.Array(
+				resp.BulkString(id.String()),
+				resp.BulkString(p.Consumer),
+				resp.Integer(max(now.Sub(p.DeliveredAt).Milliseconds(), 0)),
+				resp.Integer(p.Deliveries),
+			))
+			return len(entries) != count
+		})
+		if err != nil {
+			return typeError(err)
+		}
+	}
+	return resp.Array(entries...)
+}
+
+// pendingSummary returns the reply of XPENDING without a range for a
+// consumer group of the stream at key.
+func (s *Server) pendingSummary(key []byte, group string) string {
+	var total int64
+	var first, last storage.StreamID
+	var consumers []string
+	counts := make(map[string]int64)
+	err := s.scanPending(key, group, storage.StreamID{}, func(id storage.StreamID, p storage.PendingEntry) bool {
+		if total == 0 {
+			first = id
+		}
+		last = id
+		total++
+		if counts[p.Consumer] == 0 {
+			consumers = append(consumers, p.Consumer)
+		}
+		counts[p.Consumer]++
+		return true
+	})
+	if err != nil {
+		return typeError(err)
+	}
+	if total == 0 {
+		return resp.Array(resp.Integer(0), resp.Nil, resp.Nil, resp.NilArray)
+	}
+	perConsumer := make([]string, len(consumers))
+	for i, consumer := range consumers {
+		perConsumer[i] = resp.StringArray([]string{consumer, strconv.FormatInt(counts[consumer], 10)})
+	}
+	return resp.Array(
+		resp.Integer(total),
+		resp.BulkString(first.String()),
+		resp.BulkString(last.String()),
+		resp.Array(perConsumer...),
+	)
+}
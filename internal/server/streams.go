@@ -0,0 +1,651 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+var (
+	errStreamIDInvalid = errors.New("Invalid stream ID specified as stream command argument")
+	errStreamIDSmaller = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamIDZero    = errors.New("The ID specified in XADD must be greater than 0-0")
+)
+
+// maxStreamID is the last ID a stream entry can have.
+var maxStreamID = storage.StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+// streamEntry is an entry read from a stream, with its fields and values
+// alternating.
+type streamEntry struct {
+	id     storage.StreamID
+	fields []string
+}
+
+// xaddOptions are the options of XADD, and idIndex the position of the
+// ID among its arguments.
+type xaddOptions struct {
+	noMkStream bool
+	// maxLen is the MAXLEN count, -1 without the option.
+	maxLen  int64
+	idIndex int
+}
+
+// parseXAdd reads the options of XADD key [NOMKSTREAM] [MAXLEN [=|~]
+// count] id field value [field value ...].
+func parseXAdd(args []string) (xaddOptions, error) {
+	opts := xaddOptions{maxLen: -1}
+	i := 1
+options:
+	for i < len(args) {
+		switch strings.ToLower(args[i]) {
+		case "nomkstream":
+			opts.noMkStream = true
+		case "maxlen":
+			if i+1 < len(args) && (args[i+1] == "=" || args[i+1] == "~") {
+				i++
+			}
+			if i+1 >= len(args) {
+				return opts, errors.New("syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n < 0 {
+				return opts, errors.New("The MAXLEN argument must be >= 0.")
+			}
+			opts.maxLen = n
+			i++
+		default:
+			break options
+		}
+		i++
+	}
+	opts.idIndex = i
+	if n := len(args) - i - 1; n <= 0 || n%2 != 0 {
+		return opts, errors.New("wrong number of arguments for 'xadd' command")
+	}
+	return opts, nil
+}
+
+// xadd implements XADD key [NOMKSTREAM] [MAXLEN [=|~] count] id field value
+// [field value ...], appending an entry to the stream at key, created if
+// needed, and replying with the entry's ID. IDs must grow: with *, the ID
+// is the current time in milliseconds, or the last ID's if the clock is
+// behind it, and the next sequence number within it; with <ms>-*, only
+// the sequence number is chosen. NOMKSTREAM replies nil rather than
+// create the stream, and MAXLEN trims the oldest entries beyond count, ~
+// trimming exactly too. Readers blocked in XREAD are woken.
+func (s *Server) xadd(args []string) string {
+	opts, err := parseXAdd(args)
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, length, last, exists, err := s.loadStream(key)
+	if err != nil {
+		return typeError(err)
+	}
+	if !exists && opts.noMkStream {
+		return resp.Nil
+	}
+	id, err := nextStreamID(args[opts.idIndex], last, time.Now())
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if !exists {
+		// Entries left over from a stream the key held before and lost to
+		// a command overwriting whatever the key holds, such as SET, which
+		// does not look for them, would come back otherwise.
+		storage.DeleteElements(batch, key, storage.Meta{Type: storage.ObjectTypeStream})
+		meta = storage.NewMeta(storage.ObjectTypeStream)
+	}
+	add := true
+	if excess := length + 1 - opts.maxLen; opts.maxLen >= 0 && excess > 0 {
+		trimmed, err := s.trimStream(batch, key, min(excess, length))
+		if err != nil {
+			return typeError(err)
+		}
+		length -= trimmed
+		// The new entry is the newest, so it only goes itself, with
+		// MAXLEN 0, once every older one went.
+		add = trimmed == excess
+	}
+	if add {
+		batch.Set(storage.StreamEntryKey(key, id), storage.EncodeStreamEntry(args[opts.idIndex+1:]), nil)
+		length++
+	}
+	meta.LastAccess = time.Now()
+	batch.Set(key, storage.EncodeStreamHeader(length, id), nil)
+	storage.SetMeta(batch, key, meta)
+	if err := s.committer.Commit(batch, s.writeMode("xadd")); err != nil {
+		return typeError(err)
+	}
+	s.feeds.notify(args[0])
+	return resp.BulkString(id.String())
+}
+
+// nextStreamID returns the ID of an entry added as arg says, *, <ms>-* or
+// an ID, to a stream whose last ID is last.
+func nextStreamID(arg string, last storage.StreamID, now time.Time) (storage.StreamID, error) {
+	if arg == "*" {
+		if ms := uint64(max(now.UnixMilli(), 0)); ms > last.Ms {
+			return storage.StreamID{Ms: ms}, nil
+		}
+		if last == maxStreamID {
+			return storage.StreamID{}, errStreamIDSmaller
+		}
+		if last.Seq == math.MaxUint64 {
+			return storage.StreamID{Ms: last.Ms + 1}, nil
+		}
+		return storage.StreamID{Ms: last.Ms, Seq: last.Seq + 1}, nil
+	}
+	if ms, ok := strings.CutSuffix(arg, "-*"); ok {
+		n, err := strconv.ParseUint(ms, 10, 64)
+		switch {
+		case err != nil:
+			return storage.StreamID{}, errStreamIDInvalid
+		case n > last.Ms:
+			return storage.StreamID{Ms: n}, nil
+		case n < last.Ms || last.Seq == math.MaxUint64:
+			return storage.StreamID{}, errStreamIDSmaller
+		}
+		return storage.StreamID{Ms: n, Seq: last.Seq + 1}, nil
+	}
+	id, ok := parseStreamID(arg, 0)
+	switch {
+	case !ok:
+		return id, errStreamIDInvalid
+	case id == storage.StreamID{}:
+		return id, errStreamIDZero
+	case !last.Less(id):
+		return id, errStreamIDSmaller
+	}
+	return id, nil
+}
+
+// parseStreamID parses an ID, <ms>-<seq> or <ms>, which stands for the ID
+// with sequence number seq of that millisecond.
+func parseStreamID(arg string, seq uint64) (storage.StreamID, bool) {
+	ms, rest, found := strings.Cut(arg, "-")
+	id := storage.StreamID{Seq: seq}
+	var err error
+	if id.Ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return id, false
+	}
+	if found {
+		if id.Seq, err = strconv.ParseUint(rest, 10, 64); err != nil {
+			return id, false
+		}
+	}
+	return id, true
+}
+
+// trimStream adds to batch the deletion of the n oldest entries of the
+// stream at key, which has at least n, and returns how many it deleted.
+func (s *Server) trimStream(batch *pebble.Batch, key []byte, n int64) (int64, error) {
+	var trimmed int64
+	err := s.scanStream(s.db, key, storage.StreamID{}, func(id storage.StreamID, _ []byte) bool {
+		if trimmed == n {
+			return false
+		}
+		batch.Delete(storage.StreamEntryKey(key, id), nil)
+		trimmed++
+		return true
+	})
+	return trimmed, err
+}
+
+// xlen implements XLEN key, replying 0 for a stream that does not exist.
+func (s *Server) xlen(args []string) string {
+	_, length, _, _, err := s.loadStream([]byte(args[0]))
+	if err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(length)
+}
+
+// xrange implements XRANGE key start end [COUNT count], replying with the
+// entries from start to end, each as its ID and its fields and values, in
+// ID order. - and + are the first and last possible IDs, an ID without a
+// sequence number covers its whole millisecond, and a ( before an ID
+// excludes it.
+func (s *Server) xrange(args []string) string {
+	count := -1
+	switch {
+	case len(args) == 5 && strings.ToLower(args[3]) == "count":
+		n, err := strconv.Atoi(args[4])
+		if err != nil {
+			return resp.Error("ERR value is not an integer or out of range")
+		}
+		count = max(n, 0)
+	case len(args) != 3:
+		return resp.Error("ERR syntax error")
+	}
+	start, startOK, err := parseRangeBound(args[1], false)
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	end, endOK, err := parseRangeBound(args[2], true)
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	key := []byte(args[0])
+	_, exists, err := s.lookupType(key, storage.ObjectTypeStream)
+	if err != nil {
+		return typeError(err)
+	}
+	var entries []streamEntry
+	if exists && startOK && endOK && count != 0 {
+		defer s.io.foregroundRead()()
+		if entries, err = s.rangeStream(key, start, end, count); err != nil {
+			return typeError(err)
+		}
+	}
+	var w resp.Writer
+	writeStreamEntries(&w, entries)
+	return w.String()
+}
+
+// parseRangeBound parses the start, or with end set the end, of a range of
+// IDs (see xrange). ok is false for an exclusive bound with no ID beyond
+// it, which makes the range empty.
+func parseRangeBound(arg string, end bool) (id storage.StreamID, ok bool, err error) {
+	switch arg {
+	case "-":
+		return storage.StreamID{}, true, nil
+	case "+":
+		return maxStreamID, true, nil
+	}
+	exclusive := strings.HasPrefix(arg, "(")
+	var seq uint64
+	if end {
+		seq = math.MaxUint64
+	}
+	id, ok = parseStreamID(strings.TrimPrefix(arg, "("), seq)
+	if !ok {
+		return id, false, errStreamIDInvalid
+	}
+	if !exclusive {
+		return id, true, nil
+	}
+	if end {
+		id, ok = previousStreamID(id)
+	} else {
+		id, ok = followingStreamID(id)
+	}
+	return id, ok, nil
+}
+
+// followingStreamID returns the ID after id, and false if there is none.
+func followingStreamID(id storage.StreamID) (storage.StreamID, bool) {
+	switch {
+	case id == maxStreamID:
+		return id, false
+	case id.Seq == math.MaxUint64:
+		return storage.StreamID{Ms: id.Ms + 1}, true
+	}
+	return storage.StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+}
+
+// previousStreamID returns the ID before id, and false if there is none.
+func previousStreamID(id storage.StreamID) (storage.StreamID, bool) {
+	switch {
+	case id == storage.StreamID{}:
+		return id, false
+	case id.Seq == 0:
+		return storage.StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return storage.StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+}
+
+// rangeStream returns up to count entries, or all of them if count is
+// negative, of the stream at key from start to end.
+func (s *Server) rangeStream(key []byte, start, end storage.StreamID, count int) ([]streamEntry, error) {
+	var entries []streamEntry
+	var err error
+	scanErr := s.scanStream(s.db, key, start, func(id storage.StreamID, value []byte) bool {
+		if end.Less(id) || len(entries) == count {
+			return false
+		}
+		var fields []string
+		if fields, err = storage.DecodeStreamEntry(value); err != nil {
+			return false
+		}
+		entries = append(entries, streamEntry{id: id, fields: fields})
+		return true
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return entries, err
+}
+
+// scanStream calls fn with the entries of the stream at key in r, from the
+// ID start on and in ID order, until fn returns false.
+func (s *Server) scanStream(r pebble.Reader, key []byte, start storage.StreamID, fn func(id storage.StreamID, value []byte) bool) error {
+	prefix, end := storage.StreamEntriesSpan(key)
+	iter, err := r.NewIter(&pebble.IterOptions{
+		LowerBound: storage.StreamEntryKey(key, start),
+		UpperBound: end,
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		id, ok := storage.DecodeStreamEntryKey(prefix, iter.Key())
+		if !ok {
+			continue
+		}
+		if !fn(id, iter.Value()) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// writeStreamEntries writes entries as an array of pairs of an ID and the
+// array of its fields and values, nil for an entry deleted since it was
+// delivered to a consumer group.
+func writeStreamEntries(w *resp.Writer, entries []streamEntry) {
+	w.Array(len(entries))
+	for _, e := range entries {
+		w.Array(2)
+		w.BulkString(e.id.String())
+		if e.fields == nil {
+			w.Nil()
+			continue
+		}
+		w.Array(len(e.fields))
+		for _, f := range e.fields {
+			w.BulkString(f)
+		}
+	}
+}
+
+// xreadOptions are the options of XREAD.
+type xreadOptions struct {
+	// count is the COUNT option, -1 without it.
+	count int
+	// block is the BLOCK timeout, 0 blocking until entries arrive, when
+	// blocking is set.
+	block    time.Duration
+	blocking bool
+	// noAck is the NOACK option of XREADGROUP.
+	noAck bool
+	keys  []string
+	ids   []string
+}
+
+// parseXRead reads the arguments of XREAD [COUNT count] [BLOCK ms] STREAMS
+// key [key ...] id [id ...], or with group set the ones of XREADGROUP
+// after its GROUP option, which may also include NOACK.
+func parseXRead(args []string, group bool) (xreadOptions, error) {
+	opts := xreadOptions{count: -1}
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); {
+		case option == "noack" && group:
+			opts.noAck = true
+			continue
+		case option == "streams":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return opts, errors.New("Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			opts.keys, opts.ids = streams[:len(streams)/2], streams[len(streams)/2:]
+			return opts, nil
+		case i+1 == len(args):
+		case option == "count":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errors.New("value is not an integer or out of range")
+			}
+			if n > 0 {
+				opts.count = n
+			}
+			i++
+			continue
+		case option == "block":
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, errors.New("timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return opts, errors.New("timeout is negative")
+			}
+			opts.block, opts.blocking = time.Duration(ms)*time.Millisecond, true
+			i++
+			continue
+		}
+		return opts, errors.New("syntax error")
+	}
+	return opts, errors.New("syntax error")
+}
+
+// xreadKeys returns the keys of XREAD, the first half of what follows its
+// STREAMS option.
+func xreadKeys(args []string) []string {
+	opts, _ := parseXRead(args, false)
+	return opts.keys
+}
+
+// streamRead holds the entries XREAD read from a stream.
+type streamRead struct {
+	key     string
+	entries []streamEntry
+}
+
+// xread implements XREAD [COUNT count] [BLOCK ms] STREAMS key [key ...] id
+// [id ...], replying with up to count entries after the given ID of each
+// stream, for the streams that have any, or nil if none does. $ stands
+// for the last ID of the stream when the command arrived. With BLOCK, a
+// read that finds nothing waits up to ms milliseconds, or for good with
+// 0, for entries to be added, without holding up other clients meanwhile,
+// which makes streams a simple change feed.
+func (s *Server) xread(c *connection, args []string) string {
+	opts, err := parseXRead(args, false)
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	after := make([]storage.StreamID, len(opts.keys))
+	for i, key := range opts.keys {
+		if opts.ids[i] == "$" {
+			_, _, last, _, err := s.loadStream([]byte(key))
+			if err != nil {
+				return typeError(err)
+			}
+			after[i] = last
+			continue
+		}
+		id, ok := parseStreamID(opts.ids[i], 0)
+		if !ok {
+			return resp.Error("ERR " + errStreamIDInvalid.Error())
+		}
+		after[i] = id
+	}
+
+	var wake <-chan struct{}
+	var timeout <-chan time.Time
+	if opts.blocking {
+		// Waiting starts before the first read, so that no entry added
+		// after it goes unnoticed.
+		ch, cancel := s.feeds.subscribe(opts.keys)
+		defer cancel()
+		wake = ch
+		if opts.block > 0 {
+			timer := time.NewTimer(opts.block)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+	}
+	for {
+		reads, err := s.readStreams(opts.keys, after, opts.count)
+		if err != nil {
+			return typeError(err)
+		}
+		if len(reads) > 0 {
+			return xreadReply(c, reads)
+		}
+		if !opts.blocking {
+			return resp.NilArray
+		}
+		woken := false
+		s.blocked(c, func() {
+			select {
+			case <-wake:
+				woken = true
+			case <-timeout:
+			case <-c.done():
+			case <-s.quitCh:
+			}
+		})
+		if !woken {
+			return resp.NilArray
+		}
+	}
+}
+
+// readStreams reads up to count entries after the matching ID of after of
+// each stream of keys, leaving out the streams without any.
+func (s *Server) readStreams(keys []string, after []storage.StreamID, count int) ([]streamRead, error) {
+	defer s.io.foregroundRead()()
+	var reads []streamRead
+	for i, key := range keys {
+		_, exists, err := s.lookupType([]byte(key), storage.ObjectTypeStream)
+		if err != nil {
+			return nil, err
+		}
+		start, ok := followingStreamID(after[i])
+		if !exists || !ok {
+			continue
+		}
+		entries, err := s.rangeStream([]byte(key), start, maxStreamID, count)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			reads = append(reads, streamRead{key: key, entries: entries})
+		}
+	}
+	return reads, nil
+}
+
+// xreadReply returns the reply of XREAD: the entries read from each stream
+// by key, as a map with RESP3.
+func xreadReply(c *connection, reads []streamRead) string {
+	var w resp.Writer
+	if c.protocol >= 3 {
+		w.Map(len(reads))
+	} else {
+		w.Array(len(reads))
+	}
+	for _, read := range reads {
+		if c.protocol < 3 {
+			w.Array(2)
+		}
+		w.BulkString(read.key)
+		writeStreamEntries(&w, read.entries)
+	}
+	return w.String()
+}
+
+// loadStream returns the metadata, length and last ID of the stream at
+// key and whether it exists. Keys holding another type fail with
+// errWrongType.
+func (s *Server) loadStream(key []byte) (storage.Meta, int64, storage.StreamID, bool, error) {
+	meta, exists, err := s.lookupType(key, storage.ObjectTypeStream)
+	if err != nil || !exists {
+		return meta, 0, storage.StreamID{}, false, err
+	}
+	value, closer, err := s.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return meta, 0, storage.StreamID{}, false, nil
+	}
+	if err != nil {
+		return meta, 0, storage.StreamID{}, false, err
+	}
+	defer closer.Close()
+	length, last, err := storage.DecodeStreamHeader(value)
+	return meta, length, last, err == nil, err
+}
+
+// streamFeeds wakes the XREADs blocked on streams when entries are added.
+type streamFeeds struct {
+	mutex sync.Mutex
+	// waiting holds the channels of the readers blocked on each key.
+	waiting map[string]map[chan struct{}]bool
+}
+
+func newStreamFeeds() *streamFeeds {
+	return &streamFeeds{waiting: make(map[string]map[chan struct{}]bool)}
+}
+
+// subscribe returns a channel receiving a value whenever an entry is added
+// to any of keys, until cancel is called.
+func (f *streamFeeds) subscribe(keys []string) (ch <-chan struct{}, cancel func()) {
+	wake := make(chan struct{}, 1)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, key := range keys {
+		if f.waiting[key] == nil {
+			f.waiting[key] = make(map[chan struct{}]bool)
+		}
+		f.waiting[key][wake] = true
+	}
+	return wake, func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		for _, key := range keys {
+			delete(f.waiting[key], wake)
+			if len(f.waiting[key]) == 0 {
+				delete(f.waiting, key)
+			}
+		}
+	}
+}
+
+// notify wakes the readers blocked on key.
+func (f *streamFeeds) notify(key string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for wake := range f.waiting[key] {
+		select {
+		case wake <- struct{}{}:
+		default:
+			// Already woken.
+		}
+	}
+}
@@ -0,0 +1,277 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// maxStringSize is the largest string SETRANGE and APPEND may build, the
+// default proto-max-bulk-len of Redis.
+const maxStringSize = 512 << 20
+
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// appendCommand implements APPEND key value, creating the key if needed,
+// and replies with the new length.
+func (s *Server) appendCommand(args []string) string {
+	defer s.keyLocks.lock(args[0])()
+	meta, value, _, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	if len(value)+len(args[1]) > maxStringSize {
+		return resp.Error("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	value += args[1]
+	if err := s.storeString("append", []byte(args[0]), value, meta); err != nil {
+		return stringError(err)
+	}
+	return resp.Integer(int64(len(value)))
+}
+
+// strlen implements STRLEN key, replying 0 for a key that does not exist.
+func (s *Server) strlen(args []string) string {
+	_, value, _, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	return resp.Integer(int64(len(value)))
+}
+
+// getRange implements GETRANGE key start end. The offsets are inclusive,
+// count from the end when negative, and are clamped to the string.
+func (s *Server) getRange(args []string) string {
+	start, err1 := strconv.Atoi(args[1])
+	end, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return resp.Error("ERR value is not an integer or out of range")
+	}
+	_, value, _, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	n := len(value)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if start > end || n == 0 {
+		return resp.BulkString("")
+	}
+	return resp.BulkString(value[start : end+1])
+}
+
+// setRange implements SETRANGE key offset value, overwriting the string
+// from offset and replying with its new length. A string shorter than
+// offset is padded with zero bytes first; an empty value changes nothing.
+func (s *Server) setRange(args []string) string {
+	offset, err := strconv.Atoi(args[1])
+	if err != nil || offset < 0 {
+		return resp.Error("ERR offset is out of range")
+	}
+	if offset+len(args[2]) > maxStringSize {
+		return resp.Error("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	defer s.keyLocks.lock(args[0])()
+	meta, value, _, err := s.loadString([]byte(args[0]))
+	if err != nil {
+		return stringError(err)
+	}
+	if args[2] == "" {
+		return resp.Integer(int64(len(value)))
+	}
+	buf := []byte(value)
+	if need := offset + len(args[2]); need > len(buf) {
+		buf = append(buf, make([]byte, need-len(buf))...)
+	}
+	copy(buf[offset:], args[2])
+	if err := s.storeString("setrange", []byte(args[0]), string(buf), meta); err != nil {
+		return stringError(err)
+	}
+	return resp.Integer(int64(len(buf)))
+}
+
+// getSet implements GETSET key value, setting the string and replying with
+// its previous value, nil if there was none. Like SET, it drops the expiry.
+func (s *Server) getSet(args []string) string {
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	_, old, exists, err := s.loadString(key)
+	if err != nil {
+		return stringError(err)
+	}
+	if err := s.storeString("getset", key, args[1], storage.Meta{}); err != nil {
+		return stringError(err)
+	}
+	if !exists {
+		return resp.Nil
+	}
+	return resp.BulkString(old)
+}
+
+// getDel implements GETDEL key, deleting the string and replying with its
+// value, nil if it did not exist.
+func (s *Server) getDel(args []string) string {
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, value, exists, err := s.loadString(key)
+	if err != nil || !exists {
+		if err != nil {
+			return stringError(err)
+		}
+		return resp.Nil
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	batch.Delete(key, nil)
+	storage.DeleteMeta(batch, key)
+	if !meta.ExpireAt.IsZero() {
+		batch.Delete(storage.ExpiryKey(meta.ExpireAt, key), nil)
+	}
+	if err := s.committer.Commit(batch, s.writeMode("getdel")); err != nil {
+		return stringError(err)
+	}
+	return resp.BulkString(value)
+}
+
+// getEx implements GETEX key [EX seconds | PX milliseconds | EXAT
+// unix-time-seconds | PXAT unix-time-milliseconds | PERSIST], replying with
+// the value of the string and then changing its expiry as EXPIRE and
+// PERSIST would. Without an option it is a plain GET.
+func (s *Server) getEx(args []string) string {
+	at, persist, reply := getExOptions(args[1:])
+	if reply != "" {
+		return reply
+	}
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, value, exists, err := s.loadString(key)
+	if err != nil || !exists {
+		if err != nil {
+			return stringError(err)
+		}
+		return resp.Nil
+	}
+	if persist && meta.ExpireAt.IsZero() {
+		persist = false
+	}
+	if !at.IsZero() || persist {
+		if err := s.setExpiry("getex", key, meta, at); err != nil {
+			return resp.Error("ERR Failed to set expiry: " + err.Error())
+		}
+	}
+	return resp.BulkString(value)
+}
+
+// getExOptions parses the options of GETEX into the expiry they set, zero
+// for none, and whether PERSIST was given. It returns an error reply for
+// invalid options.
+func getExOptions(opts []string) (time.Time, bool, string) {
+	switch {
+	case len(opts) == 0:
+		return time.Time{}, false, ""
+	case len(opts) == 1 && strings.EqualFold(opts[0], "persist"):
+		return time.Time{}, true, ""
+	case len(opts) != 2:
+		return time.Time{}, false, resp.Error("ERR syntax error")
+	}
+	n, err := strconv.ParseInt(opts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false, resp.Error("ERR value is not an integer or out of range")
+	}
+	if n <= 0 {
+		return time.Time{}, false, resp.Error("ERR invalid expire time in 'getex' command")
+	}
+	var at time.Time
+	fits := true
+	switch strings.ToLower(opts[0]) {
+	case "ex":
+		at, fits = expiryIn(n, time.Second)
+	case "px":
+		at, fits = expiryIn(n, time.Millisecond)
+	case "exat":
+		at, fits = time.Unix(n, 0), n <= math.MaxInt64/1000
+	case "pxat":
+		at = time.UnixMilli(n)
+	default:
+		return time.Time{}, false, resp.Error("ERR syntax error")
+	}
+	if !fits {
+		return time.Time{}, false, resp.Error("ERR invalid expire time in 'getex' command")
+	}
+	return at, false, ""
+}
+
+// loadString returns the metadata and value of the string at key, and
+// whether it exists. Keys holding another type than string fail with
+// errWrongType.
+func (s *Server) loadString(key []byte) (storage.Meta, string, bool, error) {
+	meta, exists, err := s.lookup(key)
+	if err != nil || !exists {
+		return meta, "", false, err
+	}
+	if meta.Type != storage.ObjecTypeString {
+		return meta, "", false, errWrongType
+	}
+	value, closer, err := s.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return meta, "", false, nil
+	}
+	if err != nil {
+		return meta, "", false, err
+	}
+	defer closer.Close()
+	return meta, string(value), true, nil
+}
+
+// storeString writes the new value of a string, keeping its expiry.
+func (s *Server) storeString(cmd string, key []byte, value string, meta storage.Meta) error {
+	next := storage.NewMeta(storage.ObjecTypeString)
+	next.ExpireAt = meta.ExpireAt
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	batch.Set(key, []byte(value), nil)
+	storage.SetMeta(batch, key, next)
+	return s.committer.Commit(batch, s.writeMode(cmd))
+}
+
+func stringError(err error) string {
+	if err == errWrongType {
+		return resp.Error(err.Error())
+	}
+	return resp.Error("ERR Failed to access key: " + err.Error())
+}
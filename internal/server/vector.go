@@ -0,0 +1,856 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"readpebble/internal/collection"
+	"readpebble/internal/resp"
+)
+
+// vcreate implements VCREATE collection DIM n [METRIC l2|cosine|ip]
+// [TENANT name] [INDEX flat|hnsw] [M n] [EF_CONSTRUCTION n] [EF_SEARCH n]
+// [FIELD name ...] [TEXT name [ANALYZER spec] ...] [SCHEMA json]
+// [OUTLIER_THRESHOLD z], where each FIELD adds a secondary index on a payload
+// field, each TEXT a text index for MATCH filters, analyzed as ANALYZER
+// describes (see collection.Analyzer), SCHEMA is the JSON schema payloads
+// must match and OUTLIER_THRESHOLD flags points written more than z standard
+// deviations further from the centroid than usual (see VOUTLIERS).
+func (s *Server) vcreate(args []string) string {
+	info := collection.Info{Name: args[0]}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return resp.Error("ERR syntax error")
+		}
+		value := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "dim":
+			dim, err := strconv.Atoi(value)
+			if err != nil || dim <= 0 {
+				return resp.Error("ERR DIM must be a positive integer")
+			}
+			info.Dimension = dim
+		case "metric":
+			metric, err := collection.ParseMetric(value)
+			if err != nil {
+				return resp.Error("ERR " + err.Error())
+			}
+			info.Metric = metric
+		case "tenant":
+			info.Tenant = value
+		case "field":
+			info.Fields = append(info.Fields, value)
+		case "text":
+			info.Text = append(info.Text, collection.TextField{Field: value})
+		case "analyzer":
+			if len(info.Text) == 0 {
+				return resp.Error("ERR ANALYZER must follow a TEXT field")
+			}
+			info.Text[len(info.Text)-1].Analyzer = value
+		case "schema":
+			info.Schema = json.RawMessage(value)
+		case "outlier_threshold":
+			z, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(z > 0) {
+				return resp.Error("ERR OUTLIER_THRESHOLD must be a positive number")
+			}
+			info.OutlierThreshold = z
+		case "index":
+			index, err := collection.ParseIndexType(value)
+			if err != nil {
+				return resp.Error("ERR " + err.Error())
+			}
+			info.Index.Type = index
+		case "m", "ef_construction", "ef_search":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return resp.Error("ERR " + strings.ToUpper(args[i]) + " must be a positive integer")
+			}
+			switch strings.ToLower(args[i]) {
+			case "m":
+				info.Index.M = n
+			case "ef_construction":
+				info.Index.EFConstruction = n
+			default:
+				info.Index.EFSearch = n
+			}
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+	if info.Dimension == 0 {
+		return resp.Error("ERR DIM is required")
+	}
+	if err := s.collections.Create(info); err != nil {
+		return collectionError(err)
+	}
+	return resp.OK
+}
+
+// vdrop implements VDROP collection.
+func (s *Server) vdrop(args []string) string {
+	c, err := s.collections.Get(args[0])
+	if err != nil {
+		return collectionError(err)
+	}
+	if err := s.collections.Drop(args[0]); err != nil {
+		return collectionError(err)
+	}
+	start, end := c.Span()
+	s.goTracked(subsystemCompaction, func() {
+		err := s.runJob(jobVacuum, func() error { return s.db.Compact(start, end, true) })
+		if err != nil && err != errStopping {
+			log.Printf("Compacting dropped collection %s failed: %v", args[0], err)
+		}
+	})
+	return resp.OK
+}
+
+// vschema implements VSCHEMA collection [json|NONE]. Without a schema it
+// replies with the current one, or nil. Setting a schema replies with the
+// number of existing points that do not match it.
+func (s *Server) vschema(args []string) string {
+	if len(args) > 2 {
+		return resp.Error("ERR syntax error")
+	}
+	if len(args) == 1 {
+		c, err := s.collections.Get(args[0])
+		if err != nil {
+			return collectionError(err)
+		}
+		schema := c.SchemaSource()
+		if schema == nil {
+			return resp.Nil
+		}
+		return resp.BulkString(string(schema))
+	}
+	if strings.EqualFold(args[1], "none") {
+		if _, err := s.collections.SetSchema(args[0], nil); err != nil {
+			return collectionError(err)
+		}
+		return resp.OK
+	}
+	// Checking and indexing every point is a background job, waiting for
+	// the other index builds to leave room for it.
+	var invalid int
+	err := s.runJob(jobIndexBuild, func() (err error) {
+		invalid, err = s.collections.SetSchema(args[0], []byte(args[1]))
+		return err
+	})
+	if err != nil {
+		return collectionError(err)
+	}
+	return resp.Integer(int64(invalid))
+}
+
+// vlist implements VLIST, returning the collection names.
+func (s *Server) vlist() string {
+	infos := s.collections.List()
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name
+	}
+	return resp.StringArray(names)
+}
+
+// vadd implements VADD collection id x1 ... xn [PAYLOAD json], where n is
+// the dimension of the collection.
+func (s *Server) vadd(args []string) string {
+	c, err := s.collections.Get(args[0])
+	if errors.Is(err, collection.ErrNotFound) && s.live.Load().AutoCreateCollections {
+		c, err = s.autoCreate(args)
+	}
+	if err != nil {
+		return collectionError(err)
+	}
+	rest := args[2:]
+	if len(rest) < c.Dimension {
+		return resp.Errorf("ERR expected %d vector components", c.Dimension)
+	}
+	vector, err := parseVector(rest[:c.Dimension])
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	var payload []byte
+	switch opts := rest[c.Dimension:]; {
+	case len(opts) == 2 && strings.ToLower(opts[0]) == "payload":
+		payload = []byte(opts[1])
+	case len(opts) != 0:
+		return resp.Error("ERR syntax error")
+	}
+	if err := s.collections.Upsert(c.Name, args[1], vector, payload, s.writeMode("vadd")); err != nil {
+		return collectionError(err)
+	}
+	return resp.OK
+}
+
+// autoCreate creates the collection of VADD args, which does not exist,
+// taking its dimension from the vector added. The VCREATE it amounts to is
+// propagated ahead of the VADD so that replicas need not auto-create too.
+func (s *Server) autoCreate(args []string) (*collection.Collection, error) {
+	dim := len(args) - 2
+	if dim >= 2 && strings.ToLower(args[len(args)-2]) == "payload" {
+		dim -= 2
+	}
+	config := s.live.Load()
+	info := collection.Info{
+		Name:      args[0],
+		Dimension: dim,
+		Metric:    config.AutoCreateMetric,
+		Index:     collection.IndexParams{Type: config.AutoCreateIndex},
+	}
+	if _, err := parseVector(args[2 : 2+dim]); err != nil {
+		return nil, err
+	}
+	switch err := s.collections.Create(info); {
+	case errors.Is(err, collection.ErrExists):
+		// Another VADD created it first.
+	case err != nil:
+		return nil, err
+	default:
+		s.backlog.propagate([]string{"VCREATE", info.Name, "DIM", strconv.Itoa(dim), "METRIC", string(info.Metric), "INDEX", string(info.Index.Type)})
+	}
+	return s.collections.Get(info.Name)
+}
+
+// vectorArithmetic implements VSUM, VAVG and VSUB collection key [key ...]
+// [STORE dest], replying with the vector op computes from the points, or
+// with OK after writing it, without a payload, to the point dest.
+func (s *Server) vectorArithmetic(cmd string, op collection.VectorOp, args []string) string {
+	keys := args[1:]
+	var dest string
+	if n := len(keys); n >= 2 && strings.EqualFold(keys[n-2], "store") {
+		dest = keys[n-1]
+		keys = keys[:n-2]
+	}
+	if len(keys) == 0 || (op == collection.OpDifference && len(keys) < 2) {
+		return resp.Error("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+	vector, err := s.collections.Combine(args[0], op, keys)
+	if err != nil {
+		return collectionError(err)
+	}
+	if dest != "" {
+		if err := s.collections.Upsert(args[0], dest, vector, nil, s.writeMode(cmd)); err != nil {
+			return collectionError(err)
+		}
+		return resp.OK
+	}
+	components := make([]string, len(vector))
+	for i, x := range vector {
+		components[i] = strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return resp.StringArray(components)
+}
+
+// vget implements VGET collection id, replying with the vector and the
+// payload, which is nil when the point has none.
+func (s *Server) vget(args []string) string {
+	defer s.io.foregroundRead()()
+	vector, payload, err := s.collections.Point(args[0], args[1])
+	if errors.Is(err, collection.ErrPointNotFound) {
+		return resp.NilArray
+	}
+	if err != nil {
+		return collectionError(err)
+	}
+	var w resp.Writer
+	w.Array(2)
+	w.Array(len(vector))
+	for _, x := range vector {
+		w.BulkString(strconv.FormatFloat(x, 'g', -1, 64))
+	}
+	if payload == nil {
+		w.Nil()
+	} else {
+		w.BulkString(string(payload))
+	}
+	return w.String()
+}
+
+// vdel implements VDEL collection id [id ...].
+func (s *Server) vdel(args []string) string {
+	deleted, err := s.collections.Delete(args[0], s.writeMode("vdel"), args[1:]...)
+	if err != nil {
+		return collectionError(err)
+	}
+	return resp.Integer(int64(deleted))
+}
+
+// vsearch implements VSEARCH collection k x1 ... xn [EF n] [DEADLINE ms]
+// [FILTER expr] [FACET field ...] [FACET_LIMIT n] [SCOPE LOCAL|CLUSTER]
+// [SHARD_TIMEOUT ms] [ALLOW_PARTIAL 0|1] [AFTER_WRITES ms] [SCORES 0|1],
+// replying with the ids and distances of the k closest points matching the
+// filter, closest first, or their ids alone with SCORES 0.
+// With DEADLINE the reply is a pair of that array and 1 if the deadline
+// lowered the search effort, so that recall may be degraded, or 0. With
+// FACET the reply ends with the facets: for each field, its name and the
+// FACET_LIMIT (default 10) most common values among every point matching
+// the filter, not just the k closest, each followed by its count.
+//
+// In cluster mode the search covers every shard unless SCOPE is LOCAL: the
+// query is sent to the ClusterShards, which search with SCOPE LOCAL, and
+// their results are merged with the local ones. A shard that does not
+// answer within SHARD_TIMEOUT, at most the configured ShardTimeout, fails
+// the search, unless ALLOW_PARTIAL is 1: the reply then ends with the
+// addresses of the shards whose results are missing.
+//
+// Points written by text may only be searchable once their text was
+// embedded. AFTER_WRITES makes the search see the connection's own writes
+// to the collection: it first waits up to ms milliseconds for them to be
+// embedded (see awaitOwnWrites), and fails with TRYAGAIN if they were not.
+//
+// Options the connection's session defaults to (see sessionVariables) come
+// before those given, which override them.
+func (s *Server) vsearch(conn *connection, args []string) string {
+	defer s.io.foregroundRead()()
+	start := time.Now()
+	c, err := s.collections.Get(args[0])
+	if err != nil {
+		return collectionError(err)
+	}
+	k, err := strconv.Atoi(args[1])
+	if err != nil || k < 0 {
+		return resp.Error("ERR k must be a non-negative integer")
+	}
+	rest := args[2:]
+	if len(rest) < c.Dimension {
+		return resp.Errorf("ERR expected %d vector components", c.Dimension)
+	}
+	query, err := parseVector(rest[:c.Dimension])
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	opts := collection.SearchOptions{K: k}
+	hasDeadline := false
+	var facetFields []string
+	facetLimit := defaultFacetLimit
+	clusterScope := len(s.shards) > 0
+	shardTimeout := s.config.ShardTimeout
+	allowPartial := false
+	var afterWrites time.Duration
+	// forward is the query the shards are sent, without the options
+	// that only concern the receiving node.
+	forward := append([]string{"VSEARCH"}, args[:2+c.Dimension]...)
+	scores := true
+	for opt := append(conn.sessionSearchOptions(), rest[c.Dimension:]...); len(opt) > 0; opt = opt[2:] {
+		if len(opt) < 2 {
+			return resp.Error("ERR syntax error")
+		}
+		n, err := strconv.Atoi(opt[1])
+		switch strings.ToLower(opt[0]) {
+		case "ef":
+			if err != nil || n <= 0 {
+				return resp.Error("ERR EF must be a positive integer")
+			}
+			opts.EF = n
+		case "deadline":
+			if err != nil || n <= 0 {
+				return resp.Error("ERR DEADLINE must be a positive number of milliseconds")
+			}
+			opts.Deadline = start.Add(time.Duration(n) * time.Millisecond)
+			hasDeadline = true
+		case "filter":
+			if opts.Filter, err = collection.ParseFilter(opt[1]); err != nil {
+				return resp.Error("ERR " + err.Error())
+			}
+		case "facet":
+			facetFields = append(facetFields, opt[1])
+		case "facet_limit":
+			if err != nil || n <= 0 {
+				return resp.Error("ERR FACET_LIMIT must be a positive integer")
+			}
+			facetLimit = n
+		case "scope":
+			switch strings.ToLower(opt[1]) {
+			case "local":
+				clusterScope = false
+			case "cluster":
+				if len(s.shards) == 0 {
+					return resp.Error("ERR SCOPE CLUSTER requires cluster mode with shards configured")
+				}
+				clusterScope = true
+			default:
+				return resp.Error("ERR SCOPE must be LOCAL or CLUSTER")
+			}
+			continue
+		case "shard_timeout":
+			if err != nil || n <= 0 {
+				return resp.Error("ERR SHARD_TIMEOUT must be a positive number of milliseconds")
+			}
+			shardTimeout = min(shardTimeout, time.Duration(n)*time.Millisecond)
+			continue
+		case "allow_partial":
+			if err != nil || n < 0 || n > 1 {
+				return resp.Error("ERR ALLOW_PARTIAL must be 0 or 1")
+			}
+			allowPartial = n == 1
+			continue
+		case "after_writes":
+			if err != nil || n <= 0 {
+				return resp.Error("ERR AFTER_WRITES must be a positive number of milliseconds")
+			}
+			afterWrites = time.Duration(n) * time.Millisecond
+			continue
+		case "scores":
+			if err != nil || n < 0 || n > 1 {
+				return resp.Error("ERR SCORES must be 0 or 1")
+			}
+			scores = n == 1
+			continue
+		default:
+			return resp.Error("ERR syntax error")
+		}
+		forward = append(forward, opt[0], opt[1])
+	}
+	if afterWrites > 0 {
+		if err := s.awaitOwnWrites(conn, c.Name, afterWrites); err != nil {
+			return resp.Error("TRYAGAIN " + err.Error())
+		}
+	}
+	var gather func() []shardReply
+	if clusterScope {
+		gather = s.scatter(append(forward, "SCOPE", "LOCAL"), shardTimeout)
+	}
+	results, degraded, err := s.collections.Search(c.Name, query, opts)
+	if err != nil {
+		return collectionError(err)
+	}
+	var facets map[string][]collection.FacetCount
+	if facetFields != nil {
+		if facets, err = s.collections.Facets(c.Name, opts.Filter, facetFields, facetLimit); err != nil {
+			return collectionError(err)
+		}
+	}
+	var missing []string
+	if gather != nil {
+		facetSets := []map[string][]collection.FacetCount{facets}
+		var lastErr error
+		for _, r := range gather() {
+			ss, err := r.result(hasDeadline, facetFields != nil)
+			if err != nil {
+				missing = append(missing, r.addr)
+				lastErr = err
+				continue
+			}
+			results = append(results, ss.results...)
+			degraded = degraded || ss.degraded
+			facetSets = append(facetSets, ss.facets)
+		}
+		if len(missing) > 0 && !allowPartial {
+			return resp.Errorf("SHARDUNAVAILABLE %d of %d shards did not answer, e.g. %s: %v",
+				len(missing), len(s.shards), missing[0], lastErr)
+		}
+		results = mergeResults(results, k)
+		if facets != nil {
+			facets = mergeFacets(facetSets, facetLimit)
+		}
+	}
+	var w resp.Writer
+	extras := 0
+	for _, extra := range []bool{hasDeadline, facets != nil, allowPartial && clusterScope} {
+		if extra {
+			extras++
+		}
+	}
+	if extras > 0 {
+		w.Array(1 + extras)
+	}
+	if scores {
+		w.Array(2 * len(results))
+	} else {
+		w.Array(len(results))
+	}
+	for _, r := range results {
+		w.BulkString(r.ID)
+		if scores {
+			w.BulkString(strconv.FormatFloat(r.Score, 'g', -1, 64))
+		}
+	}
+	if hasDeadline {
+		if degraded {
+			w.Integer(1)
+		} else {
+			w.Integer(0)
+		}
+	}
+	if facets != nil {
+		writeFacets(&w, facetFields, facets)
+	}
+	if allowPartial && clusterScope {
+		w.Array(len(missing))
+		for _, addr := range missing {
+			w.BulkString(addr)
+		}
+	}
+	return w.String()
+}
+
+// defaultFacetLimit is how many values VSEARCH returns per FACET field
+// without FACET_LIMIT.
+const defaultFacetLimit = 10
+
+// writeFacets writes the facets of fields, in order and without repeats, as
+// an array alternating field names and arrays of value and count pairs.
+func writeFacets(w *resp.Writer, fields []string, facets map[string][]collection.FacetCount) {
+	w.Array(2 * len(facets))
+	written := make(map[string]bool)
+	for _, field := range fields {
+		if written[field] {
+			continue
+		}
+		written[field] = true
+		counts := facets[field]
+		w.BulkString(field)
+		w.Array(2 * len(counts))
+		for _, fc := range counts {
+			w.BulkString(collection.FormatFacetValue(fc.Value))
+			w.Integer(int64(fc.Count))
+		}
+	}
+}
+
+// vcount implements VCOUNT collection [FILTER expr], replying with the
+// number of points matching the filter.
+func (s *Server) vcount(args []string) string {
+	defer s.io.foregroundRead()()
+	filter, rest, err := parseFilterOption(args[1:])
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	if len(rest) != 0 {
+		return resp.Error("ERR syntax error")
+	}
+	n, err := s.collections.Count(args[0], filter)
+	if err != nil {
+		return collectionError(err)
+	}
+	return resp.Integer(int64(n))
+}
+
+// vscroll implements VSCROLL collection cursor [COUNT n] [FILTER expr],
+// replying like SCAN with the cursor of the next page, 0 after the last
+// one, and the ids of up to COUNT points matching the filter. The ids are
+// the points' own strings, so a large page is only ever encoded as it is
+// streamed.
+func (s *Server) vscroll(c *connection, args []string) string {
+	defer s.io.foregroundRead()()
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return resp.Error("ERR invalid cursor")
+	}
+	filter, rest, err := parseFilterOption(args[2:])
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	count := 10
+	switch {
+	case len(rest) == 2 && strings.ToLower(rest[0]) == "count":
+		if count, err = strconv.Atoi(rest[1]); err != nil || count <= 0 {
+			return resp.Error("ERR COUNT must be a positive integer")
+		}
+	case len(rest) != 0:
+		return resp.Error("ERR syntax error")
+	}
+	keys, next, err := s.collections.Scroll(args[0], cursor, count, filter)
+	if err != nil {
+		return collectionError(err)
+	}
+	return c.streamReply(func(w *resp.Writer, flush func() error) error {
+		w.Array(2)
+		w.BulkString(strconv.FormatUint(next, 10))
+		w.Array(len(keys))
+		for _, key := range keys {
+			w.BulkString(key)
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// vexplain implements VEXPLAIN collection [FILTER expr], replying with the
+// plan VSEARCH, VCOUNT and VSCROLL would use for the filter, one line per
+// step with the estimates it was chosen by.
+func (s *Server) vexplain(args []string) string {
+	filter, rest, err := parseFilterOption(args[1:])
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	if len(rest) != 0 {
+		return resp.Error("ERR syntax error")
+	}
+	lines, err := s.collections.Explain(args[0], filter)
+	if err != nil {
+		return collectionError(err)
+	}
+	return resp.StringArray(lines)
+}
+
+// parseFilterOption takes a FILTER expr option out of args, returning the
+// remaining arguments.
+func parseFilterOption(args []string) (*collection.Filter, []string, error) {
+	for i := 0; i < len(args); i++ {
+		if strings.ToLower(args[i]) != "filter" {
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, nil, errors.New("syntax error")
+		}
+		filter, err := collection.ParseFilter(args[i+1])
+		if err != nil {
+			return nil, nil, err
+		}
+		rest := append(append([]string{}, args[:i]...), args[i+2:]...)
+		return filter, rest, nil
+	}
+	return nil, args, nil
+}
+
+// vstats implements VSTATS collection [DIMENSIONS], replying with pairs
+// of statistic names and values describing a sample of the points: the
+// distribution of their norms, how often each payload field is present and,
+// with DIMENSIONS, the range, mean and standard deviation of each vector
+// component.
+func (s *Server) vstats(args []string) string {
+	dimensions := false
+	switch {
+	case len(args) == 2 && strings.EqualFold(args[1], "dimensions"):
+		dimensions = true
+	case len(args) != 1:
+		return resp.Error("ERR syntax error")
+	}
+	defer s.io.foregroundRead()()
+	c, err := s.collections.Get(args[0])
+	if err != nil {
+		return collectionError(err)
+	}
+	summary, err := s.collections.Summarize(c.Name, dimensions)
+	if err != nil {
+		return collectionError(err)
+	}
+	float := func(x float64) string {
+		return strconv.FormatFloat(x, 'g', 6, 64)
+	}
+
+	var w resp.Writer
+	n := 24
+	if dimensions {
+		n += 2
+	}
+	w.Array(n)
+	w.BulkString("points")
+	w.Integer(int64(summary.Points))
+	w.BulkString("sampled")
+	w.Integer(int64(summary.Sampled))
+	w.BulkString("dimension")
+	w.Integer(int64(c.Dimension))
+	w.BulkString("dtype")
+	w.BulkString("float64")
+	w.BulkString("metric")
+	w.BulkString(string(c.Metric))
+	w.BulkString("index")
+	w.BulkString(string(c.Index.Type))
+	w.BulkString("index_params")
+	if c.Index.Type == collection.IndexHNSW {
+		w.Array(6)
+		w.BulkString("m")
+		w.Integer(int64(c.Index.M))
+		w.BulkString("ef_construction")
+		w.Integer(int64(c.Index.EFConstruction))
+		w.BulkString("ef_search")
+		w.Integer(int64(c.Index.EFSearch))
+	} else {
+		w.Array(0)
+	}
+	w.BulkString("norm")
+	if summary.Sampled == 0 {
+		w.Array(0)
+	} else {
+		w.Array(2 * (len(summary.Norms) + 1))
+		for i, norm := range summary.Norms {
+			w.BulkString("p" + strconv.FormatFloat(collection.NormPercentiles[i], 'g', -1, 64))
+			w.BulkString(float(norm))
+		}
+		w.BulkString("mean")
+		w.BulkString(float(summary.MeanNorm))
+	}
+	w.BulkString("zero_vectors")
+	w.Integer(int64(summary.ZeroVectors))
+	w.BulkString("payloads_sampled")
+	w.Integer(int64(summary.PayloadsSampled))
+	w.BulkString("with_payload")
+	w.Integer(int64(summary.WithPayload))
+	fields := make([]string, 0, len(summary.Fields))
+	for field := range summary.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	w.BulkString("field_coverage")
+	w.Array(2 * len(fields))
+	for _, field := range fields {
+		w.BulkString(field)
+		w.BulkString(float(float64(summary.Fields[field]) / float64(summary.PayloadsSampled)))
+	}
+	if dimensions {
+		w.BulkString("dimensions")
+		w.Array(len(summary.Dimensions))
+		for _, d := range summary.Dimensions {
+			w.Array(4)
+			w.BulkString(float(d.Min))
+			w.BulkString(float(d.Max))
+			w.BulkString(float(d.Mean))
+			w.BulkString(float(d.StdDev))
+		}
+	}
+	return w.String()
+}
+
+// voutliers implements VOUTLIERS collection [LIMIT n], replying with the
+// number of points flagged as outliers and the keys and scores of the first
+// n of them (default 100), oldest first.
+func (s *Server) voutliers(args []string) string {
+	limit := 100
+	switch {
+	case len(args) == 3 && strings.EqualFold(args[1], "limit"):
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return resp.Error("ERR LIMIT must be a non-negative integer")
+		}
+		limit = n
+	case len(args) != 1:
+		return resp.Error("ERR syntax error")
+	}
+	outliers, total, err := s.collections.Outliers(args[0], limit)
+	if err != nil {
+		return collectionError(err)
+	}
+	var w resp.Writer
+	w.Array(2)
+	w.Integer(int64(total))
+	w.Array(2 * len(outliers))
+	for _, o := range outliers {
+		w.BulkString(o.Key)
+		w.BulkString(strconv.FormatFloat(o.Score, 'g', 4, 64))
+	}
+	return w.String()
+}
+
+// fields implements FIELDS CARDINALITY collection [field ...], replying
+// with each field, every indexed one by default, followed by its estimated
+// number of distinct values.
+func (s *Server) fields(args []string) string {
+	if strings.ToLower(args[0]) != "cardinality" {
+		return resp.Error("ERR unknown FIELDS subcommand '" + args[0] + "'")
+	}
+	fields := args[2:]
+	counts, err := s.collections.Cardinality(args[1], fields...)
+	if err != nil {
+		return collectionError(err)
+	}
+	if len(fields) == 0 {
+		for field := range counts {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+	}
+	var w resp.Writer
+	w.Array(2 * len(fields))
+	for _, field := range fields {
+		w.BulkString(field)
+		w.Integer(int64(counts[field]))
+	}
+	return w.String()
+}
+
+// tenant implements TENANT SET name QUOTA n [POLICY reject|evict-lrs] and
+// TENANT INFO name.
+func (s *Server) tenant(args []string) string {
+	name := args[1]
+	switch strings.ToLower(args[0]) {
+	case "set":
+		tenant, _ := s.collections.Tenant(name)
+		for i := 2; i < len(args); i += 2 {
+			if i+1 >= len(args) {
+				return resp.Error("ERR syntax error")
+			}
+			switch strings.ToLower(args[i]) {
+			case "quota":
+				quota, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || quota < 0 {
+					return resp.Error("ERR QUOTA must be a non-negative integer")
+				}
+				tenant.Quota = quota
+			case "policy":
+				policy, err := collection.ParsePolicy(strings.ToLower(args[i+1]))
+				if err != nil {
+					return resp.Error("ERR " + err.Error())
+				}
+				tenant.Policy = policy
+			default:
+				return resp.Error("ERR syntax error")
+			}
+		}
+		if err := s.collections.SetTenant(tenant); err != nil {
+			return collectionError(err)
+		}
+		return resp.OK
+	case "info":
+		tenant, usage := s.collections.Tenant(name)
+		return resp.Array(
+			resp.BulkString("quota"), resp.BulkString(strconv.FormatInt(tenant.Quota, 10)),
+			resp.BulkString("policy"), resp.BulkString(string(tenant.Policy)),
+			resp.BulkString("usage"), resp.Integer(int64(usage)))
+	default:
+		return resp.Error("ERR unknown TENANT subcommand '" + args[0] + "'")
+	}
+}
+
+func parseVector(args []string) ([]float64, error) {
+	vector := make([]float64, len(args))
+	for i, arg := range args {
+		x, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector component %q", arg)
+		}
+		vector[i] = x
+	}
+	return vector, nil
+}
+
+func collectionError(err error) string {
+	if errors.Is(err, collection.ErrQuotaExceeded) {
+		return resp.Error("QUOTA " + err.Error())
+	}
+	return resp.Error("ERR " + err.Error())
+}
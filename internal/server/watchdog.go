@@ -0,0 +1,167 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"readpebble/internal/metrics"
+)
+
+const (
+	subsystemAnalytics   = "analytics"
+	subsystemCapture     = "capture"
+	subsystemCheckpoint  = "checkpoint"
+	subsystemCompaction  = "compaction"
+	subsystemConnection  = "connection"
+	subsystemDisk        = "disk"
+	subsystemEmbed       = "embed"
+	subsystemExpire      = "expire"
+	subsystemHTTP        = "http"
+	subsystemLoadMonitor = "load-monitor"
+	subsystemMigration   = "migration"
+	subsystemReplication = "replication"
+	subsystemShadow      = "shadow"
+	subsystemWatchdog    = "watchdog"
+)
+
+// watchdog tracks the goroutines started by each subsystem and on behalf of
+// each connection, and reports goroutines that are still running after the
+// connection they serve has been closed.
+type watchdog struct {
+	interval time.Duration
+	grace    time.Duration
+
+	mutex       sync.Mutex
+	bySubsystem map[string]int
+	byConn      map[int64]*connGoroutines
+
+	registry *metrics.Registry
+	leaks    *metrics.Counter
+}
+
+type connGoroutines struct {
+	conn     *connection
+	running  int
+	reported bool
+}
+
+func newWatchdog(config Config, registry *metrics.Registry) *watchdog {
+	w := &watchdog{
+		interval:    config.WatchdogInterval,
+		grace:       config.WatchdogGrace,
+		bySubsystem: make(map[string]int),
+		byConn:      make(map[int64]*connGoroutines),
+		registry:    registry,
+		leaks:       registry.Counter("vecble_goroutine_leaks_total", "Goroutines detected outliving their connection."),
+	}
+	registry.GaugeFunc("vecble_goroutines", "Goroutines running in the process.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	return w
+}
+
+// track records a goroutine started by subsystem and returns the function
+// to call when it exits.
+func (w *watchdog) track(subsystem string) func() {
+	w.mutex.Lock()
+	_, known := w.bySubsystem[subsystem]
+	w.bySubsystem[subsystem]++
+	w.mutex.Unlock()
+
+	if !known {
+		w.registry.GaugeFunc("vecble_subsystem_goroutines", "Goroutines tracked per subsystem.", func() float64 {
+			w.mutex.Lock()
+			defer w.mutex.Unlock()
+			return float64(w.bySubsystem[subsystem])
+		}, "subsystem", subsystem)
+	}
+
+	return func() {
+		w.mutex.Lock()
+		w.bySubsystem[subsystem]--
+		w.mutex.Unlock()
+	}
+}
+
+// trackConn records a goroutine working on behalf of c.
+func (w *watchdog) trackConn(subsystem string, c *connection) func() {
+	done := w.track(subsystem)
+	w.mutex.Lock()
+	entry, ok := w.byConn[c.id]
+	if !ok {
+		entry = &connGoroutines{conn: c}
+		w.byConn[c.id] = entry
+	}
+	entry.running++
+	w.mutex.Unlock()
+
+	return func() {
+		done()
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		entry.running--
+		if entry.running == 0 {
+			delete(w.byConn, c.id)
+		}
+	}
+}
+
+func (w *watchdog) run(quitCh chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-quitCh:
+			return
+		}
+	}
+}
+
+func (w *watchdog) check() {
+	leaked := 0
+	w.mutex.Lock()
+	for id, entry := range w.byConn {
+		closedAt := entry.conn.closedAt()
+		if entry.reported || closedAt.IsZero() || time.Since(closedAt) < w.grace {
+			continue
+		}
+		entry.reported = true
+		leaked += entry.running
+		log.Printf("Goroutine leak: %d goroutine(s) for client id=%d addr=%s still running %s after the connection closed",
+			entry.running, id, entry.conn.conn.RemoteAddr(), time.Since(closedAt).Round(time.Second))
+	}
+	w.mutex.Unlock()
+
+	// Metrics are updated outside the watchdog lock since gauge callbacks
+	// take it while the registry lock is held.
+	if leaked > 0 {
+		w.leaks.Add(float64(leaked))
+	}
+}
@@ -0,0 +1,420 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+
+	"readpebble/internal/resp"
+	"readpebble/internal/storage"
+)
+
+// zadd implements ZADD key [NX|XX] [CH] score member [score member ...],
+// creating the sorted set if needed and replying with the number of
+// members added, or with CH of members added or given a new score. NX
+// only adds members and XX only updates them. Only the members given are
+// written, along with the set's cardinality, however large the set.
+func (s *Server) zadd(args []string) string {
+	var nx, xx, ch bool
+	pairs := args[1:]
+options:
+	for len(pairs) > 0 {
+		switch strings.ToLower(pairs[0]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ch":
+			ch = true
+		default:
+			break options
+		}
+		pairs = pairs[1:]
+	}
+	if nx && xx {
+		return resp.Error("ERR XX and NX options at the same time are not compatible")
+	}
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return resp.Error("ERR syntax error")
+	}
+	scores := make([]float64, len(pairs)/2)
+	for i := range scores {
+		score, ok := parseScore(pairs[2*i])
+		if !ok {
+			return resp.Error("ERR value is not a valid float")
+		}
+		scores[i] = score
+	}
+
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, card, exists, err := s.loadZSet(key)
+	if err != nil {
+		return typeError(err)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	if !exists {
+		// Members left over from a sorted set the key held before and lost
+		// to a command overwriting whatever the key holds, such as SET,
+		// which does not look for them, would come back otherwise.
+		storage.DeleteElements(batch, key, storage.Meta{Type: storage.ObjectTypeZSet})
+		meta = storage.NewMeta(storage.ObjectTypeZSet)
+	}
+	// The scores written so far, for members given more than once.
+	written := make(map[string]float64)
+	added := make(map[string]bool)
+	changed := 0
+	for i, score := range scores {
+		member := pairs[2*i+1]
+		old, found := written[member]
+		if !found && exists {
+			if old, found, err = s.zsetScore(key, member); err != nil {
+				return typeError(err)
+			}
+		}
+		switch {
+		case found && nx, !found && xx, found && old == score:
+			continue
+		case found:
+			batch.Delete(storage.ZSetScoreKey(key, old, []byte(member)), nil)
+			if !added[member] {
+				changed++
+			}
+		default:
+			added[member] = true
+		}
+		batch.Set(storage.ZSetMemberKey(key, []byte(member)), storage.AppendScore(nil, score), nil)
+		batch.Set(storage.ZSetScoreKey(key, score, []byte(member)), nil, nil)
+		written[member] = score
+	}
+	if len(written) == 0 {
+		return resp.Integer(0)
+	}
+	writeCardinality(batch, key, meta, card+int64(len(added)))
+	if err := s.committer.Commit(batch, s.writeMode("zadd")); err != nil {
+		return typeError(err)
+	}
+	if ch {
+		return resp.Integer(int64(len(added) + changed))
+	}
+	return resp.Integer(int64(len(added)))
+}
+
+// zrem implements ZREM key member [member ...], replying with the number
+// of members removed. The key is deleted with its last member.
+func (s *Server) zrem(args []string) string {
+	key := []byte(args[0])
+	defer s.keyLocks.lock(args[0])()
+	meta, card, exists, err := s.loadZSet(key)
+	if err != nil || !exists {
+		if err != nil {
+			return typeError(err)
+		}
+		return resp.Integer(0)
+	}
+	batch := s.db.NewBatch()
+	defer batch.Close()
+	removed := make(map[string]bool)
+	for _, member := range args[1:] {
+		if removed[member] {
+			continue
+		}
+		score, found, err := s.zsetScore(key, member)
+		if err != nil {
+			return typeError(err)
+		}
+		if found {
+			removed[member] = true
+			batch.Delete(storage.ZSetMemberKey(key, []byte(member)), nil)
+			batch.Delete(storage.ZSetScoreKey(key, score, []byte(member)), nil)
+		}
+	}
+	if len(removed) == 0 {
+		return resp.Integer(0)
+	}
+	writeCardinality(batch, key, meta, card-int64(len(removed)))
+	if err := s.committer.Commit(batch, s.writeMode("zrem")); err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(int64(len(removed)))
+}
+
+// zscore implements ZSCORE key member, replying with the score of the
+// member or nil.
+func (s *Server) zscore(args []string) string {
+	key := []byte(args[0])
+	_, _, exists, err := s.loadZSet(key)
+	if err != nil || !exists {
+		if err != nil {
+			return typeError(err)
+		}
+		return resp.Nil
+	}
+	score, found, err := s.zsetScore(key, args[1])
+	if err != nil {
+		return typeError(err)
+	}
+	if !found {
+		return resp.Nil
+	}
+	return resp.BulkString(formatScore(score))
+}
+
+// zcard implements ZCARD key, replying 0 for a sorted set that does not
+// exist.
+func (s *Server) zcard(args []string) string {
+	_, card, _, err := s.loadZSet([]byte(args[0]))
+	if err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(card)
+}
+
+// zrank implements ZRANK key member, replying with the number of members
+// ordered before it, by score then member, or nil if the set does not
+// have it. The members before it are counted by scanning them.
+func (s *Server) zrank(args []string) string {
+	key := []byte(args[0])
+	_, _, exists, err := s.loadZSet(key)
+	if err != nil || !exists {
+		if err != nil {
+			return typeError(err)
+		}
+		return resp.Nil
+	}
+	score, found, err := s.zsetScore(key, args[1])
+	if err != nil || !found {
+		if err != nil {
+			return typeError(err)
+		}
+		return resp.Nil
+	}
+	defer s.io.foregroundRead()()
+	target := storage.ZSetScoreKey(key, score, []byte(args[1]))
+	start, _ := storage.ZSetScoresSpan(key)
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: start, UpperBound: target})
+	if err != nil {
+		return typeError(err)
+	}
+	defer iter.Close()
+	var rank int64
+	for iter.First(); iter.Valid(); iter.Next() {
+		rank++
+	}
+	if err := iter.Error(); err != nil {
+		return typeError(err)
+	}
+	return resp.Integer(rank)
+}
+
+// zrange implements ZRANGE key start stop [WITHSCORES], replying with the
+// members ranked from start to stop inclusive, which count from the end
+// when negative, each followed by its score with WITHSCORES.
+func (s *Server) zrange(args []string) string {
+	start, stop, reply := parseListRange(args[1], args[2])
+	if reply != "" {
+		return reply
+	}
+	withScores := false
+	switch {
+	case len(args) == 4 && strings.EqualFold(args[3], "withscores"):
+		withScores = true
+	case len(args) != 3:
+		return resp.Error("ERR syntax error")
+	}
+	key := []byte(args[0])
+	_, card, exists, err := s.loadZSet(key)
+	if err != nil {
+		return typeError(err)
+	}
+	items := []string{}
+	if start, stop, ok := clampListRange(start, stop, card); exists && ok {
+		defer s.io.foregroundRead()()
+		rank := int64(0)
+		err := s.scanZSet(key, math.Inf(-1), func(score float64, member []byte) bool {
+			if rank >= start {
+				items = appendScored(items, member, score, withScores)
+			}
+			rank++
+			return rank <= stop
+		})
+		if err != nil {
+			return typeError(err)
+		}
+	}
+	return resp.StringArray(items)
+}
+
+// zrangeByScore implements ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT
+// offset count], replying with the members whose score is between min and
+// max in score order, each followed by its score with WITHSCORES. A bound
+// starting with "(" is exclusive, and -inf and +inf are unbounded. LIMIT
+// skips offset members and replies with up to count, or all of the rest
+// if count is negative. The members are read with a scan starting at min.
+func (s *Server) zrangeByScore(args []string) string {
+	lo, loExclusive, ok1 := parseScoreBound(args[1])
+	hi, hiExclusive, ok2 := parseScoreBound(args[2])
+	if !ok1 || !ok2 {
+		return resp.Error("ERR min or max is not a float")
+	}
+	withScores := false
+	offset, count := int64(0), int64(-1)
+	for opts := args[3:]; len(opts) > 0; {
+		switch strings.ToLower(opts[0]) {
+		case "withscores":
+			withScores = true
+			opts = opts[1:]
+		case "limit":
+			if len(opts) < 3 {
+				return resp.Error("ERR syntax error")
+			}
+			var err1, err2 error
+			offset, err1 = strconv.ParseInt(opts[1], 10, 64)
+			count, err2 = strconv.ParseInt(opts[2], 10, 64)
+			if err1 != nil || err2 != nil {
+				return resp.Error("ERR value is not an integer or out of range")
+			}
+			opts = opts[3:]
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+	key := []byte(args[0])
+	_, _, exists, err := s.loadZSet(key)
+	if err != nil {
+		return typeError(err)
+	}
+	items := []string{}
+	if exists && offset >= 0 && count != 0 {
+		defer s.io.foregroundRead()()
+		err := s.scanZSet(key, lo, func(score float64, member []byte) bool {
+			if score > hi || hiExclusive && score == hi {
+				return false
+			}
+			if loExclusive && score == lo {
+				return true
+			}
+			if offset > 0 {
+				offset--
+				return true
+			}
+			items = appendScored(items, member, score, withScores)
+			count--
+			return count != 0
+		})
+		if err != nil {
+			return typeError(err)
+		}
+	}
+	return resp.StringArray(items)
+}
+
+// scanZSet calls fn with the members of the sorted set at key, and their
+// scores, in score order from the first member scoring from or more, until
+// fn returns false.
+func (s *Server) scanZSet(key []byte, from float64, fn func(score float64, member []byte) bool) error {
+	prefix, end := storage.ZSetScoresSpan(key)
+	iter, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: storage.ZSetScoreKey(key, from, nil),
+		UpperBound: end,
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		score, member, ok := storage.DecodeZSetScoreKey(prefix, iter.Key())
+		if !ok {
+			continue
+		}
+		if !fn(score, member) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// appendScored appends member to items, followed by its score if
+// withScore is set.
+func appendScored(items []string, member []byte, score float64, withScore bool) []string {
+	items = append(items, string(member))
+	if withScore {
+		items = append(items, formatScore(score))
+	}
+	return items
+}
+
+// parseScore parses a score, which may be -inf or +inf but not NaN.
+func parseScore(arg string) (float64, bool) {
+	score, err := strconv.ParseFloat(arg, 64)
+	return score, err == nil && !math.IsNaN(score)
+}
+
+// parseScoreBound parses the min or max of ZRANGEBYSCORE, reporting
+// whether it is exclusive.
+func parseScoreBound(arg string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(arg, "(")
+	score, ok := parseScore(strings.TrimPrefix(arg, "("))
+	return score, exclusive, ok
+}
+
+// formatScore formats a score as Redis does, with inf and -inf for the
+// infinities.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// zsetScore returns the score of a member of the sorted set at key and
+// whether the set has it.
+func (s *Server) zsetScore(key []byte, member string) (float64, bool, error) {
+	value, err := s.io.get(s.db, storage.ZSetMemberKey(key, []byte(member)))
+	if err == pebble.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if len(value) != 8 {
+		return 0, false, fmt.Errorf("score of %d bytes", len(value))
+	}
+	return storage.DecodeScore(value), true, nil
+}
+
+// loadZSet returns the metadata and cardinality of the sorted set at key
+// and whether it exists. Keys holding another type fail with errWrongType.
+func (s *Server) loadZSet(key []byte) (storage.Meta, int64, bool, error) {
+	return s.loadCardinality(key, storage.ObjectTypeZSet)
+}
@@ -0,0 +1,466 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+// Package sqlite writes SQLite database files without SQLite itself. It
+// implements just enough of the file format
+// (https://www.sqlite.org/fileformat2.html) to hold one table built row by
+// row, for handing data to tools that read SQLite files, DuckDB among them.
+package sqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	pageSize = 4096
+	// headerSize is the size of the database header starting page 1.
+	headerSize = 100
+	// lockBytePage is the page holding the bytes from offset 1GiB, which
+	// SQLite uses for file locks and never stores anything in.
+	lockBytePage = 1<<30/pageSize + 1
+
+	pageTableInterior = 0x05
+	pageTableLeaf     = 0x0d
+
+	// maxLocal is the most of a row a table leaf cell holds; the rest of a
+	// larger row spills to overflow pages, leaving at least minLocal bytes
+	// in the cell.
+	maxLocal = pageSize - 35
+	minLocal = (pageSize-12)*32/255 - 23
+	// childrenPerInterior is how many children an interior page is given,
+	// one more than the cells that fit with the largest rowids.
+	childrenPerInterior = (pageSize-12)/(2+4+9) + 1
+)
+
+// ErrClosed is returned when writing to a closed Writer.
+var ErrClosed = errors.New("sqlite: writer closed")
+
+// Writer writes a database holding a single table. Rows are written out a
+// page at a time as they are inserted, and the table's schema, which grows a
+// column whenever one is added, at the end by Close. Rows inserted before a
+// column was added simply have no value for it, which SQLite reads as NULL.
+type Writer struct {
+	w       io.WriterAt
+	table   string
+	columns []string
+	// names holds the lowercased column names, since SQLite's are case
+	// insensitive.
+	names  map[string]bool
+	pages  uint32
+	rowid  int64
+	leaf   page
+	leaves []child
+	closed bool
+}
+
+// child is a page of the table and the largest rowid in it.
+type child struct {
+	page uint32
+	key  int64
+}
+
+// page holds the cells of a b-tree page being filled.
+type page struct {
+	cells [][]byte
+	size  int
+}
+
+// NewWriter starts a database on w holding a table with the given columns.
+// Page 1 is written last, by Close.
+func NewWriter(w io.WriterAt, table string, columns ...string) *Writer {
+	wr := &Writer{w: w, table: table, names: make(map[string]bool), pages: 1}
+	for _, column := range columns {
+		wr.AddColumn(column)
+	}
+	return wr
+}
+
+// AddColumn adds a column to the table and returns its index. The column is
+// called name, with a number appended if the table has a column of that
+// name already.
+func (w *Writer) AddColumn(name string) int {
+	base := name
+	if base == "" {
+		base = "column"
+	}
+	name = base
+	for i := 2; w.names[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	w.names[strings.ToLower(name)] = true
+	w.columns = append(w.columns, name)
+	return len(w.columns) - 1
+}
+
+// Columns returns the names of the table's columns.
+func (w *Writer) Columns() []string {
+	return w.columns
+}
+
+// Insert adds a row holding values, one for each column from the first,
+// with the columns past the end of values left NULL. Values may be nil, a
+// bool, an int64, a float64, a string or a []byte.
+func (w *Writer) Insert(values []any) error {
+	if w.closed {
+		return ErrClosed
+	}
+	if len(values) > len(w.columns) {
+		return fmt.Errorf("sqlite: %d values for %d columns", len(values), len(w.columns))
+	}
+	for len(values) > 0 && values[len(values)-1] == nil {
+		values = values[:len(values)-1]
+	}
+	record, err := appendRecord(nil, values)
+	if err != nil {
+		return err
+	}
+	if err := w.flushLeaf(len(record)); err != nil {
+		return err
+	}
+	w.rowid++
+	cell, err := w.cell(w.rowid, record)
+	if err != nil {
+		return err
+	}
+	w.leaf.add(cell)
+	return nil
+}
+
+// flushLeaf writes the leaf page being filled out if a row of size bytes
+// does not fit in it.
+func (w *Writer) flushLeaf(size int) error {
+	if len(w.leaf.cells) == 0 || w.leaf.fits(cellSize(w.rowid+1, size), 0, 8) {
+		return nil
+	}
+	n, err := w.writePage(encodePage(pageTableLeaf, 0, w.leaf.cells, 0))
+	if err != nil {
+		return err
+	}
+	w.leaves = append(w.leaves, child{n, w.rowid})
+	w.leaf = page{}
+	return nil
+}
+
+// Close writes the rest of the table, its interior pages and page 1, which
+// holds the database header and the schema. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	if len(w.leaf.cells) > 0 || len(w.leaves) == 0 {
+		n, err := w.writePage(encodePage(pageTableLeaf, 0, w.leaf.cells, 0))
+		if err != nil {
+			return err
+		}
+		w.leaves = append(w.leaves, child{n, w.rowid})
+	}
+	root, err := w.writeInterior(w.leaves)
+	if err != nil {
+		return err
+	}
+	return w.writeSchema(root)
+}
+
+// writeInterior writes the interior pages over children, a level at a
+// time, and returns the root page of the table.
+func (w *Writer) writeInterior(children []child) (uint32, error) {
+	for len(children) > 1 {
+		var groups [][]child
+		for len(children) > 0 {
+			n := min(len(children), childrenPerInterior)
+			groups = append(groups, children[:n])
+			children = children[n:]
+		}
+		// An interior page needs a cell besides its rightmost child.
+		if last := len(groups) - 1; last > 0 && len(groups[last]) == 1 {
+			prev := groups[last-1]
+			groups[last-1], groups[last] = prev[:len(prev)-1], append(prev[len(prev)-1:len(prev):len(prev)], groups[last]...)
+		}
+		var parents []child
+		for _, group := range groups {
+			var cells [][]byte
+			for _, c := range group[:len(group)-1] {
+				cell := binary.BigEndian.AppendUint32(nil, c.page)
+				cells = append(cells, appendVarint(cell, uint64(c.key)))
+			}
+			right := group[len(group)-1]
+			n, err := w.writePage(encodePage(pageTableInterior, 0, cells, right.page))
+			if err != nil {
+				return 0, err
+			}
+			parents = append(parents, child{n, right.key})
+		}
+		children = parents
+	}
+	return children[0].page, nil
+}
+
+// writeSchema writes page 1: the database header and the schema table, with
+// the one row describing the table rooted at root.
+func (w *Writer) writeSchema(root uint32) error {
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "CREATE TABLE %s(", quote(w.table))
+	for i, column := range w.columns {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteString(quote(column))
+	}
+	sql.WriteString(")")
+	record, err := schemaRecord(w.table, root, sql.String())
+	if err != nil {
+		return err
+	}
+	// A row of up to maxLocal bytes is kept whole in its cell, which does
+	// not fit in page 1 next to the header. Trailing spaces, which SQLite
+	// ignores, make such a schema spill to an overflow page.
+	for !(&page{}).fits(cellSize(1, len(record)), headerSize, 8) {
+		sql.WriteString(" ")
+		if record, err = schemaRecord(w.table, root, sql.String()); err != nil {
+			return err
+		}
+	}
+	cell, err := w.cell(1, record)
+	if err != nil {
+		return err
+	}
+	buf := encodePage(pageTableLeaf, headerSize, [][]byte{cell}, 0)
+	copy(buf, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(buf[16:], pageSize)
+	buf[18], buf[19] = 1, 1 // Rollback journal, not WAL.
+	buf[21], buf[22], buf[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(buf[24:], 1) // File change counter.
+	binary.BigEndian.PutUint32(buf[28:], w.pages)
+	binary.BigEndian.PutUint32(buf[40:], 1) // Schema cookie.
+	binary.BigEndian.PutUint32(buf[44:], 4) // Schema format.
+	binary.BigEndian.PutUint32(buf[56:], 1) // UTF-8.
+	// The page count is valid for the file change counter it matches.
+	binary.BigEndian.PutUint32(buf[92:], 1)
+	binary.BigEndian.PutUint32(buf[96:], 3046000)
+	_, err = w.w.WriteAt(buf, 0)
+	return err
+}
+
+func schemaRecord(table string, root uint32, sql string) ([]byte, error) {
+	return appendRecord(nil, []any{"table", table, table, int64(root), sql})
+}
+
+// writePage writes the next page of the file and returns its number.
+func (w *Writer) writePage(buf []byte) (uint32, error) {
+	n := w.allocate()
+	_, err := w.w.WriteAt(buf, int64(n-1)*pageSize)
+	return n, err
+}
+
+// allocate returns the number of the next page of the file.
+func (w *Writer) allocate() uint32 {
+	w.pages++
+	if w.pages == lockBytePage {
+		w.pages++
+	}
+	return w.pages
+}
+
+// cell returns the table leaf cell holding record as row rowid, writing the
+// part of it that does not fit to overflow pages.
+func (w *Writer) cell(rowid int64, record []byte) ([]byte, error) {
+	local := localSize(len(record))
+	cell := appendVarint(appendVarint(nil, uint64(len(record))), uint64(rowid))
+	cell = append(cell, record[:local]...)
+	if local == len(record) {
+		return cell, nil
+	}
+	// Overflow pages are chained, each starting with the number of the
+	// next.
+	var chunks [][]byte
+	for rest := record[local:]; len(rest) > 0; rest = rest[min(len(rest), pageSize-4):] {
+		chunks = append(chunks, rest[:min(len(rest), pageSize-4)])
+	}
+	pages := make([]uint32, len(chunks)+1)
+	for i := range chunks {
+		pages[i] = w.allocate()
+	}
+	for i, chunk := range chunks {
+		buf := make([]byte, pageSize)
+		binary.BigEndian.PutUint32(buf, pages[i+1])
+		copy(buf[4:], chunk)
+		if _, err := w.w.WriteAt(buf, int64(pages[i]-1)*pageSize); err != nil {
+			return nil, err
+		}
+	}
+	return binary.BigEndian.AppendUint32(cell, pages[0]), nil
+}
+
+// localSize returns how many bytes of a row of size bytes its cell holds.
+func localSize(size int) int {
+	if size <= maxLocal {
+		return size
+	}
+	if local := minLocal + (size-minLocal)%(pageSize-4); local <= maxLocal {
+		return local
+	}
+	return minLocal
+}
+
+// cellSize returns the size of the cell holding a row of size bytes.
+func cellSize(rowid int64, size int) int {
+	n := varintLen(uint64(size)) + varintLen(uint64(rowid)) + localSize(size)
+	if localSize(size) < size {
+		n += 4
+	}
+	return n
+}
+
+// fits reports whether a cell of size bytes can be added to p, on a page
+// whose b-tree header of headerLen bytes starts at offset start.
+func (p *page) fits(size, start, headerLen int) bool {
+	return start+headerLen+p.size+2+size <= pageSize
+}
+
+func (p *page) add(cell []byte) {
+	p.cells = append(p.cells, cell)
+	p.size += 2 + len(cell)
+}
+
+// encodePage returns a b-tree page holding cells, whose b-tree header
+// starts at offset start. right is the rightmost child of interior pages.
+func encodePage(kind byte, start int, cells [][]byte, right uint32) []byte {
+	buf := make([]byte, pageSize)
+	header := buf[start:]
+	header[0] = kind
+	binary.BigEndian.PutUint16(header[3:], uint16(len(cells)))
+	pointers := start + 8
+	if kind == pageTableInterior {
+		binary.BigEndian.PutUint32(header[8:], right)
+		pointers += 4
+	}
+	// Cell content is packed at the end of the page, first cell last.
+	end := pageSize
+	for i, cell := range cells {
+		end -= len(cell)
+		copy(buf[end:], cell)
+		binary.BigEndian.PutUint16(buf[pointers+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(header[5:], uint16(end))
+	return buf
+}
+
+// appendRecord appends values in SQLite's record format: a header with the
+// serial type of each value, then the values.
+func appendRecord(buf []byte, values []any) ([]byte, error) {
+	var types, body []byte
+	for _, v := range values {
+		var t uint64
+		switch v := v.(type) {
+		case nil:
+			t = 0
+		case bool:
+			t = 8
+			if v {
+				t = 9
+			}
+		case int64:
+			t, body = appendInteger(body, v)
+		case float64:
+			t, body = 7, binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			t, body = 13+2*uint64(len(v)), append(body, v...)
+		case []byte:
+			t, body = 12+2*uint64(len(v)), append(body, v...)
+		default:
+			return nil, fmt.Errorf("sqlite: unsupported value type %T", v)
+		}
+		types = appendVarint(types, t)
+	}
+	// The header size counts itself.
+	size := len(types) + 1
+	for varintLen(uint64(size)) != size-len(types) {
+		size++
+	}
+	buf = appendVarint(buf, uint64(size))
+	return append(append(buf, types...), body...), nil
+}
+
+// appendInteger appends v in the fewest bytes it fits in and returns its
+// serial type.
+func appendInteger(buf []byte, v int64) (uint64, []byte) {
+	switch {
+	case v == 0:
+		return 8, buf
+	case v == 1:
+		return 9, buf
+	}
+	for t, n := range []int{1, 2, 3, 4, 6} {
+		if limit := int64(1) << (8*n - 1); -limit <= v && v < limit {
+			for i := n - 1; i >= 0; i-- {
+				buf = append(buf, byte(v>>(8*i)))
+			}
+			return uint64(t + 1), buf
+		}
+	}
+	return 6, binary.BigEndian.AppendUint64(buf, uint64(v))
+}
+
+// appendVarint appends v as a SQLite varint: big-endian groups of 7 bits,
+// all but the last with the high bit set, and a ninth byte of 8 bits for
+// values that need it.
+func appendVarint(buf []byte, v uint64) []byte {
+	if v >= 1<<56 {
+		var b [9]byte
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(buf, b[:]...)
+	}
+	n := varintLen(v)
+	for i := n - 1; i >= 0; i-- {
+		b := byte(v>>(7*i)) & 0x7f
+		if i > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
+
+func varintLen(v uint64) int {
+	n := 1
+	for ; n < 9 && v >= 1<<(7*n); n++ {
+	}
+	return n
+}
+
+// quote quotes an identifier for SQL.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,123 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// The types made of many elements keep each element under its own key in
+// the reserved keyspace, so that an element is read and written without
+// the rest of the value:
+//
+//	\x00h:<len(key)><key><field>   field of the hash at key (see hash.go)
+//	\x00l:<len(key)><key><index>   item of the list at key (see list.go)
+//	\x00S:<len(key)><key><member>  member of the set at key (see set.go)
+//	\x00z:<len(key)><key><tag>...  member or score of the sorted set at key
+//	                               (see zset.go)
+//	\x00x:<len(key)><key><id>      entry of the stream at key (see stream.go)
+//
+// The length of the key, a uvarint, keeps the elements of a key from
+// sharing a prefix with those of longer keys starting the same way. The
+// key itself holds what describes the value as a whole, if anything.
+var elementPrefixes = map[ObjectType]string{
+	ObjectTypeHash:   "\x00h:",
+	ObjectTypeList:   "\x00l:",
+	ObjectTypeSet:    "\x00S:",
+	ObjectTypeZSet:   "\x00z:",
+	ObjectTypeStream: "\x00x:",
+}
+
+// HasElements reports whether values of type t are made of elements.
+func HasElements(t ObjectType) bool {
+	_, ok := elementPrefixes[t]
+	return ok
+}
+
+// ElementsPrefix returns the prefix of the keys of the elements of the
+// value of type t at key.
+func ElementsPrefix(key []byte, t ObjectType) []byte {
+	buf := binary.AppendUvarint([]byte(elementPrefixes[t]), uint64(len(key)))
+	return append(buf, key...)
+}
+
+// ElementsSpan returns the start and end of the span holding the elements
+// of the value of type t at key, and false for types without elements.
+func ElementsSpan(key []byte, t ObjectType) (start, end []byte, ok bool) {
+	if !HasElements(t) {
+		return nil, nil, false
+	}
+	start = ElementsPrefix(key, t)
+	return start, prefixEnd(start), true
+}
+
+// ElementSpans returns the start and end of the spans of the reserved
+// keyspace holding the elements of every key.
+func ElementSpans() [][2][]byte {
+	var spans [][2][]byte
+	for _, prefix := range elementPrefixes {
+		spans = append(spans, [2][]byte{[]byte(prefix), prefixEnd([]byte(prefix))})
+	}
+	sort.Slice(spans, func(i, j int) bool { return bytes.Compare(spans[i][0], spans[j][0]) < 0 })
+	return spans
+}
+
+// DeleteElements deletes the elements of the value at key, whose metadata
+// is meta, if its type has any.
+func DeleteElements(w pebble.Writer, key []byte, meta Meta) error {
+	start, end, ok := ElementsSpan(key, meta.Type)
+	if !ok {
+		return nil
+	}
+	return w.DeleteRange(start, end, nil)
+}
+
+// DeleteValue deletes key, whose metadata is meta: its value, its elements
+// and its metadata. The expiry index entry is left to the sweeper.
+func DeleteValue(w pebble.Writer, key []byte, meta Meta) error {
+	if err := DeleteElements(w, key, meta); err != nil {
+		return err
+	}
+	if err := w.Delete(key, nil); err != nil {
+		return err
+	}
+	return DeleteMeta(w, key)
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package storage
+
+// A hash keeps an empty value at its key, with the hash type in its
+// metadata, and each field as an element (see elements.go) holding the
+// field's value.
+
+// HashFieldsPrefix returns the prefix of the keys of the fields of the hash
+// at key.
+func HashFieldsPrefix(key []byte) []byte {
+	return ElementsPrefix(key, ObjectTypeHash)
+}
+
+// HashFieldKey returns the key of a field of the hash at key.
+func HashFieldKey(key, field []byte) []byte {
+	return append(HashFieldsPrefix(key), field...)
+}
+
+// HashFieldsSpan returns the start and end of the span holding the fields
+// of the hash at key.
+func HashFieldsSpan(key []byte) (start, end []byte) {
+	start, end, _ = ElementsSpan(key, ObjectTypeHash)
+	return start, end
+}
@@ -0,0 +1,76 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A list keeps each item as an element (see elements.go) numbered by a
+// sequence number, big-endian so that items sort in list order, and the
+// bounds of the numbers in use as its value, so that pushing or popping an
+// item at either end writes just the item and the bounds:
+//
+//	<key>   head (uint64), tail (uint64): the items are numbered from head
+//	        to tail-1
+//
+// New lists start in the middle of the numbers, leaving room to grow
+// either way.
+const listStart = 1 << 63
+
+// ListBounds are the sequence numbers of the first item of a list and of
+// the one after its last.
+type ListBounds struct {
+	Head, Tail uint64
+}
+
+// NewListBounds returns the bounds of an empty list.
+func NewListBounds() ListBounds {
+	return ListBounds{Head: listStart, Tail: listStart}
+}
+
+// Len returns the number of items of the list.
+func (b ListBounds) Len() int64 {
+	return int64(b.Tail - b.Head)
+}
+
+// Encode returns the value the bounds are stored as.
+func (b ListBounds) Encode() []byte {
+	buf := binary.BigEndian.AppendUint64(nil, b.Head)
+	return binary.BigEndian.AppendUint64(buf, b.Tail)
+}
+
+// DecodeListBounds is the inverse of ListBounds.Encode.
+func DecodeListBounds(data []byte) (ListBounds, error) {
+	if len(data) != 16 {
+		return ListBounds{}, fmt.Errorf("list bounds of %d bytes", len(data))
+	}
+	return ListBounds{Head: binary.BigEndian.Uint64(data), Tail: binary.BigEndian.Uint64(data[8:])}, nil
+}
+
+// ListItemKey returns the key of the item numbered seq of the list at key.
+func ListItemKey(key []byte, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(ElementsPrefix(key, ObjectTypeList), seq)
+}
@@ -0,0 +1,167 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Every key of the user keyspace has a metadata record in the reserved
+// keyspace, written in the same batch as its value. Keys with an expiry
+// also have an entry in the expiry index, sorted by expiry time so that the
+// keys due can be found with a range scan:
+//
+//	\x00m:<key>               type (1 byte), last access (unix milliseconds,
+//	                          uint64), then the expiry time if any (unix
+//	                          milliseconds, uint64)
+//	\x00e:<expiry> <key>      expiry index entry (empty)
+//
+// Keys written before metadata was kept have none and are strings. Index
+// entries are not deleted when the expiry of their key changes: the
+// sweeper drops the ones that no longer match the key's metadata.
+const (
+	metaPrefix   = "\x00m:"
+	expiryPrefix = "\x00e:"
+)
+
+// Meta is the metadata of a key.
+type Meta struct {
+	Type ObjectType
+	// LastAccess is when the key was last written or touched.
+	LastAccess time.Time
+	// ExpireAt is when the key expires, zero if it does not.
+	ExpireAt time.Time
+}
+
+// MetaSpans returns the start and end of the spans of the reserved keyspace
+// holding the metadata records and the expiry index.
+func MetaSpans() [][2][]byte {
+	return [][2][]byte{
+		{[]byte(metaPrefix), []byte("\x00m;")},
+		{[]byte(expiryPrefix), []byte("\x00e;")},
+	}
+}
+
+// MetaKey returns the key of the metadata record of key.
+func MetaKey(key []byte) []byte {
+	return append([]byte(metaPrefix), key...)
+}
+
+// NewMeta returns the metadata of a key of the given type written now.
+func NewMeta(objectType ObjectType) Meta {
+	return Meta{Type: objectType, LastAccess: time.Now()}
+}
+
+// Expired reports whether the key has expired by now.
+func (m Meta) Expired(now time.Time) bool {
+	return !m.ExpireAt.IsZero() && !m.ExpireAt.After(now)
+}
+
+func (m Meta) encode() []byte {
+	buf := []byte{byte(m.Type)}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(m.LastAccess.UnixMilli()))
+	if !m.ExpireAt.IsZero() {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(m.ExpireAt.UnixMilli()))
+	}
+	return buf
+}
+
+func decodeMeta(data []byte) (Meta, error) {
+	if len(data) < 9 {
+		return Meta{}, fmt.Errorf("metadata record of %d bytes", len(data))
+	}
+	meta := Meta{
+		Type:       ObjectType(data[0]),
+		LastAccess: time.UnixMilli(int64(binary.BigEndian.Uint64(data[1:9]))),
+	}
+	if len(data) >= 17 {
+		meta.ExpireAt = time.UnixMilli(int64(binary.BigEndian.Uint64(data[9:17])))
+	}
+	return meta, nil
+}
+
+// ExpiryKey returns the expiry index entry of key expiring at at.
+func ExpiryKey(at time.Time, key []byte) []byte {
+	buf := binary.BigEndian.AppendUint64([]byte(expiryPrefix), uint64(at.UnixMilli()))
+	return append(buf, key...)
+}
+
+// ExpiryBounds returns the bounds of the expiry index entries of the keys
+// due by now.
+func ExpiryBounds(now time.Time) (lower, upper []byte) {
+	return []byte(expiryPrefix), ExpiryKey(now.Add(time.Millisecond), nil)
+}
+
+// DecodeExpiryKey returns the expiry time and the key of an expiry index
+// entry.
+func DecodeExpiryKey(k []byte) (time.Time, []byte, bool) {
+	if len(k) < len(expiryPrefix)+8 || string(k[:len(expiryPrefix)]) != expiryPrefix {
+		return time.Time{}, nil, false
+	}
+	at := binary.BigEndian.Uint64(k[len(expiryPrefix):])
+	return time.UnixMilli(int64(at)), k[len(expiryPrefix)+8:], true
+}
+
+// SetMeta writes the metadata of key, and its expiry index entry if it has
+// an expiry.
+func SetMeta(w pebble.Writer, key []byte, meta Meta) error {
+	if !meta.ExpireAt.IsZero() {
+		if err := w.Set(ExpiryKey(meta.ExpireAt, key), nil, nil); err != nil {
+			return err
+		}
+	}
+	return w.Set(MetaKey(key), meta.encode(), nil)
+}
+
+// DeleteMeta deletes the metadata of key, along with its value.
+func DeleteMeta(w pebble.Writer, key []byte) error {
+	return w.Delete(MetaKey(key), nil)
+}
+
+// LoadMeta returns the metadata of key and whether the key exists. A key
+// with a value but no metadata record is a string.
+func LoadMeta(r pebble.Reader, key []byte) (Meta, bool, error) {
+	data, closer, err := r.Get(MetaKey(key))
+	if err == nil {
+		defer closer.Close()
+		meta, err := decodeMeta(data)
+		return meta, err == nil, err
+	}
+	if err != pebble.ErrNotFound {
+		return Meta{}, false, err
+	}
+	_, closer, err = r.Get(key)
+	if err == pebble.ErrNotFound {
+		return Meta{}, false, nil
+	}
+	if err != nil {
+		return Meta{}, false, err
+	}
+	closer.Close()
+	return Meta{Type: ObjecTypeString}, true, nil
+}
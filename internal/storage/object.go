@@ -32,15 +32,23 @@ type Object struct {
 type ObjectType uint
 
 const (
-	ObjecTypeString ObjectType = 1
-	ObjectTypeInt   ObjectType = 2
-	ObjectTypeSet   ObjectType = 3
-	ObjectTypeArray ObjectType = 4
-	ObjectTypeList  ObjectType = 5
+	ObjecTypeString  ObjectType = 1
+	ObjectTypeInt    ObjectType = 2
+	ObjectTypeSet    ObjectType = 3
+	ObjectTypeArray  ObjectType = 4
+	ObjectTypeList   ObjectType = 5
+	ObjectTypeHash   ObjectType = 6
+	ObjectTypeZSet   ObjectType = 7
+	ObjectTypeStream ObjectType = 8
 )
 
 func (o Object) String() string {
-	switch o.ObjectType {
+	return o.ObjectType.String()
+}
+
+// String returns the name TYPE replies with.
+func (t ObjectType) String() string {
+	switch t {
 	case ObjectTypeInt:
 		return "int"
 	case ObjectTypeSet:
@@ -49,6 +57,12 @@ func (o Object) String() string {
 		return "array"
 	case ObjectTypeList:
 		return "list"
+	case ObjectTypeHash:
+		return "hash"
+	case ObjectTypeZSet:
+		return "zset"
+	case ObjectTypeStream:
+		return "stream"
 	default:
 		return "string"
 	}
@@ -0,0 +1,65 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A set keeps its number of members at its key, as a big-endian uint64,
+// with the set type in its metadata, and each member as an element (see
+// elements.go) with an empty value. Members sort in byte order, so sets
+// are combined by walking their members side by side.
+
+// SetMembersPrefix returns the prefix of the keys of the members of the set
+// at key.
+func SetMembersPrefix(key []byte) []byte {
+	return ElementsPrefix(key, ObjectTypeSet)
+}
+
+// SetMemberKey returns the key of a member of the set at key.
+func SetMemberKey(key, member []byte) []byte {
+	return append(SetMembersPrefix(key), member...)
+}
+
+// SetMembersSpan returns the start and end of the span holding the members
+// of the set at key.
+func SetMembersSpan(key []byte) (start, end []byte) {
+	start, end, _ = ElementsSpan(key, ObjectTypeSet)
+	return start, end
+}
+
+// EncodeSetCard returns the value a set of n members is stored as.
+func EncodeSetCard(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+// DecodeSetCard is the inverse of EncodeSetCard.
+func DecodeSetCard(data []byte) (int64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("set cardinality of %d bytes", len(data))
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
@@ -41,6 +41,18 @@ func (s *storage) Get(key []byte) ([]float64, error) {
 	return resFloat, nil
 }
 
+// EncodeVector returns the on-disk encoding of a vector: each component as a
+// little-endian float64.
+func EncodeVector(vector []float64) []byte {
+	data, _ := serializeFloat64Array(vector)
+	return data
+}
+
+// DecodeVector is the inverse of EncodeVector.
+func DecodeVector(data []byte) ([]float64, error) {
+	return deserializeFloat64Array(data)
+}
+
 func serializeFloat64Array(arr []float64) ([]byte, error) {
 	size := len(arr) * 8
 	bytes := make([]byte, size)
@@ -82,10 +94,11 @@ func (s *storage) Insert(entry Entry) error {
 		if err != nil {
 			log.Print(err)
 		}
-		err = s.db.Set([]byte(entry.Key), dataToInsert, &pebble.WriteOptions{
-			Sync: true,
-		})
-		if err != nil {
+		batch := s.db.NewBatch()
+		defer batch.Close()
+		batch.Set([]byte(entry.Key), dataToInsert, nil)
+		SetMeta(batch, []byte(entry.Key), NewMeta(ObjectTypeArray))
+		if err := batch.Commit(pebble.Sync); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,233 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// A stream keeps at its key its length and the ID of the last entry ever
+// added, which later IDs must exceed even once the entry is trimmed, with
+// the stream type in its metadata, and as elements (see elements.go) its
+// entries and the state of its consumer groups, told apart by a tag byte:
+//
+//	e<id>                             entry
+//	g<group>                          last ID delivered to the group
+//	c<len(group)><group><consumer>    consumer of the group, empty
+//	p<len(group)><group><id>          entry delivered to the group and not
+//	                                  acknowledged yet
+//
+// IDs are stored as their two parts, 8 big-endian bytes each, so entries
+// sort by ID and a range of IDs is a span. An entry's value is its fields
+// and values, each a uvarint length followed by the bytes. A pending
+// entry's value is when it was last delivered, in Unix milliseconds, how
+// many times it was, 8 bytes each, and the consumer it was delivered to.
+const (
+	streamEntryTag    = 'e'
+	streamGroupTag    = 'g'
+	streamConsumerTag = 'c'
+	streamPendingTag  = 'p'
+)
+
+// StreamID identifies a stream entry: the Unix time in milliseconds it was
+// added at, unless a client chose it, and a sequence number among the
+// entries of that millisecond.
+type StreamID struct {
+	Ms, Seq uint64
+}
+
+// String returns the ID as clients see it, <ms>-<seq>.
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id comes before other.
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || id.Ms == other.Ms && id.Seq < other.Seq
+}
+
+func (id StreamID) append(buf []byte) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(buf, id.Ms), id.Seq)
+}
+
+func decodeStreamID(data []byte) StreamID {
+	return StreamID{Ms: binary.BigEndian.Uint64(data), Seq: binary.BigEndian.Uint64(data[8:])}
+}
+
+// StreamEntriesPrefix returns the prefix of the keys of the entries of the
+// stream at key.
+func StreamEntriesPrefix(key []byte) []byte {
+	return append(ElementsPrefix(key, ObjectTypeStream), streamEntryTag)
+}
+
+// StreamEntriesSpan returns the start and end of the span holding the
+// entries of the stream at key.
+func StreamEntriesSpan(key []byte) (start, end []byte) {
+	start = StreamEntriesPrefix(key)
+	return start, prefixEnd(start)
+}
+
+// StreamEntryKey returns the key of the entry with the given ID of the
+// stream at key.
+func StreamEntryKey(key []byte, id StreamID) []byte {
+	return id.append(StreamEntriesPrefix(key))
+}
+
+// DecodeStreamEntryKey returns the ID of an entry key, or of a pending
+// entry key, whose prefix is prefix, and false if it does not hold one.
+func DecodeStreamEntryKey(prefix, entryKey []byte) (StreamID, bool) {
+	rest := entryKey[len(prefix):]
+	if len(rest) != 16 {
+		return StreamID{}, false
+	}
+	return decodeStreamID(rest), true
+}
+
+// StreamGroupKey returns the key holding the last ID delivered to a
+// consumer group of the stream at key.
+func StreamGroupKey(key []byte, group string) []byte {
+	return append(append(ElementsPrefix(key, ObjectTypeStream), streamGroupTag), group...)
+}
+
+// StreamGroupsSpan returns the start and end of the span holding the
+// consumer groups of the stream at key.
+func StreamGroupsSpan(key []byte) (start, end []byte) {
+	start = append(ElementsPrefix(key, ObjectTypeStream), streamGroupTag)
+	return start, prefixEnd(start)
+}
+
+func streamGroupPrefix(key []byte, tag byte, group string) []byte {
+	buf := append(ElementsPrefix(key, ObjectTypeStream), tag)
+	buf = binary.AppendUvarint(buf, uint64(len(group)))
+	return append(buf, group...)
+}
+
+// StreamConsumersPrefix returns the prefix of the keys of the consumers of
+// a consumer group of the stream at key.
+func StreamConsumersPrefix(key []byte, group string) []byte {
+	return streamGroupPrefix(key, streamConsumerTag, group)
+}
+
+// StreamConsumerKey returns the key of a consumer of a consumer group of
+// the stream at key.
+func StreamConsumerKey(key []byte, group, consumer string) []byte {
+	return append(StreamConsumersPrefix(key, group), consumer...)
+}
+
+// StreamPendingPrefix returns the prefix of the keys of the entries
+// pending in a consumer group of the stream at key.
+func StreamPendingPrefix(key []byte, group string) []byte {
+	return streamGroupPrefix(key, streamPendingTag, group)
+}
+
+// StreamPendingKey returns the key of an entry pending in a consumer group
+// of the stream at key.
+func StreamPendingKey(key []byte, group string, id StreamID) []byte {
+	return id.append(StreamPendingPrefix(key, group))
+}
+
+// PendingEntry is an entry delivered to a consumer and not acknowledged.
+type PendingEntry struct {
+	Consumer    string
+	DeliveredAt time.Time
+	Deliveries  int64
+}
+
+// Encode returns the value the pending entry is stored as.
+func (p PendingEntry) Encode() []byte {
+	buf := binary.BigEndian.AppendUint64(nil, uint64(p.DeliveredAt.UnixMilli()))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(p.Deliveries))
+	return append(buf, p.Consumer...)
+}
+
+// DecodePendingEntry is the inverse of PendingEntry.Encode.
+func DecodePendingEntry(data []byte) (PendingEntry, error) {
+	if len(data) < 16 {
+		return PendingEntry{}, fmt.Errorf("pending entry of %d bytes", len(data))
+	}
+	return PendingEntry{
+		Consumer:    string(data[16:]),
+		DeliveredAt: time.UnixMilli(int64(binary.BigEndian.Uint64(data))),
+		Deliveries:  int64(binary.BigEndian.Uint64(data[8:])),
+	}, nil
+}
+
+// EncodeStreamGroup returns the value a consumer group that was delivered
+// the entries up to last is stored as.
+func EncodeStreamGroup(last StreamID) []byte {
+	return last.append(nil)
+}
+
+// DecodeStreamGroup is the inverse of EncodeStreamGroup.
+func DecodeStreamGroup(data []byte) (StreamID, error) {
+	if len(data) != 16 {
+		return StreamID{}, fmt.Errorf("consumer group of %d bytes", len(data))
+	}
+	return decodeStreamID(data), nil
+}
+
+// EncodeStreamHeader returns the value a stream of length entries, the
+// last added of which has ID last, is stored as.
+func EncodeStreamHeader(length int64, last StreamID) []byte {
+	return last.append(binary.BigEndian.AppendUint64(nil, uint64(length)))
+}
+
+// DecodeStreamHeader is the inverse of EncodeStreamHeader.
+func DecodeStreamHeader(data []byte) (int64, StreamID, error) {
+	if len(data) != 24 {
+		return 0, StreamID{}, fmt.Errorf("stream header of %d bytes", len(data))
+	}
+	return int64(binary.BigEndian.Uint64(data)), decodeStreamID(data[8:]), nil
+}
+
+var errBadStreamEntry = errors.New("malformed stream entry")
+
+// EncodeStreamEntry returns the value an entry made of fields, alternately
+// field names and values, is stored as.
+func EncodeStreamEntry(fields []string) []byte {
+	var buf []byte
+	for _, f := range fields {
+		buf = binary.AppendUvarint(buf, uint64(len(f)))
+		buf = append(buf, f...)
+	}
+	return buf
+}
+
+// DecodeStreamEntry is the inverse of EncodeStreamEntry.
+func DecodeStreamEntry(data []byte) ([]string, error) {
+	var fields []string
+	for len(data) > 0 {
+		n, size := binary.Uvarint(data)
+		if size <= 0 || uint64(len(data)-size) < n {
+			return nil, errBadStreamEntry
+		}
+		fields = append(fields, string(data[size:size+int(n)]))
+		data = data[size+int(n):]
+	}
+	return fields, nil
+}
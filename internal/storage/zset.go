@@ -0,0 +1,109 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// A sorted set keeps its number of members at its key, encoded like a
+// set's, with the sorted set type in its metadata, and two elements (see
+// elements.go) per member, told apart by a tag byte:
+//
+//	m<member>         the member's score
+//	s<score><member>  empty, ordering the members by score, then member
+//
+// Scores are stored as 8 bytes that sort like the numbers they encode, so
+// the members within a range of scores are a span of the second kind and
+// a query by score is a scan.
+const (
+	zsetMemberTag = 'm'
+	zsetScoreTag  = 's'
+)
+
+// ZSetMemberKey returns the key holding the score of a member of the
+// sorted set at key.
+func ZSetMemberKey(key, member []byte) []byte {
+	return append(append(ElementsPrefix(key, ObjectTypeZSet), zsetMemberTag), member...)
+}
+
+// ZSetScoresPrefix returns the prefix of the keys ordering the members of
+// the sorted set at key by score.
+func ZSetScoresPrefix(key []byte) []byte {
+	return append(ElementsPrefix(key, ObjectTypeZSet), zsetScoreTag)
+}
+
+// ZSetScoreKey returns the key ordering member by its score in the sorted
+// set at key. With a nil member, it is the first key of the members of
+// that score.
+func ZSetScoreKey(key []byte, score float64, member []byte) []byte {
+	return append(AppendScore(ZSetScoresPrefix(key), score), member...)
+}
+
+// ZSetScoresSpan returns the start and end of the span ordering the members
+// of the sorted set at key by score.
+func ZSetScoresSpan(key []byte) (start, end []byte) {
+	start = ZSetScoresPrefix(key)
+	return start, prefixEnd(start)
+}
+
+// DecodeZSetScoreKey returns the score and member of a key of the span
+// starting with prefix (see ZSetScoresSpan), and false if it is too short
+// to hold a score.
+func DecodeZSetScoreKey(prefix, scoreKey []byte) (float64, []byte, bool) {
+	rest := scoreKey[len(prefix):]
+	if len(rest) < 8 {
+		return 0, nil, false
+	}
+	return DecodeScore(rest[:8]), rest[8:], true
+}
+
+// AppendScore appends the 8 bytes score is stored as to buf. The sign bit
+// of positive numbers is flipped, and every bit of negative ones, so that
+// the bytes sort in numeric order, from -inf to +inf.
+func AppendScore(buf []byte, score float64) []byte {
+	if score == 0 {
+		// -0 and 0 are the same score.
+		score = 0
+	}
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(buf, bits)
+}
+
+// DecodeScore is the inverse of AppendScore.
+func DecodeScore(data []byte) float64 {
+	bits := binary.BigEndian.Uint64(data)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
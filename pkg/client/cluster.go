@@ -0,0 +1,226 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"readpebble/internal/slot"
+)
+
+// maxRedirects bounds how many times BulkUpsert follows MOVED redirections
+// for the same points before giving up.
+const maxRedirects = 3
+
+// ClusterOptions configures a client of a cluster of nodes that each own
+// part of the hash slots.
+type ClusterOptions struct {
+	// Addrs are the nodes of the cluster. The slot map is read from the
+	// first that answers CLUSTER SLOTS; when none does, as with vecble
+	// nodes, which accept any key, the slots are split evenly between
+	// Addrs in order, which every client given the same list agrees on.
+	Addrs []string
+	// Options configures the connections to each node. Its Addr is
+	// ignored.
+	Options Options
+}
+
+// ClusterClient routes keys to the node owning their hash slot, computed
+// locally, so that large loads go straight to the right node instead of
+// bouncing off MOVED redirections.
+type ClusterClient struct {
+	opts ClusterOptions
+
+	mutex sync.Mutex
+	// owners maps each slot to the address of the node owning it.
+	owners []string
+	nodes  map[string]*remoteClient
+}
+
+func NewClusterClient(opts ClusterOptions) (*ClusterClient, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, fmt.Errorf("client: a cluster needs at least one address")
+	}
+	c := &ClusterClient{opts: opts, nodes: make(map[string]*remoteClient)}
+	c.refreshSlots()
+	return c, nil
+}
+
+// node returns the client of the node at addr.
+func (c *ClusterClient) node(addr string) *remoteClient {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n, ok := c.nodes[addr]
+	if !ok {
+		opts := c.opts.Options
+		opts.Addr = addr
+		n = NewRemoteClient(opts)
+		c.nodes[addr] = n
+	}
+	return n
+}
+
+// refreshSlots reloads the slot map, falling back to an even split of the
+// slots between Addrs when no node reports one.
+func (c *ClusterClient) refreshSlots() {
+	owners := make([]string, slot.Count)
+	for _, addr := range c.opts.Addrs {
+		reply, err := c.node(addr).DoIdempotent("CLUSTER", "SLOTS")
+		if err != nil {
+			continue
+		}
+		if parseClusterSlots(reply, owners) {
+			c.mutex.Lock()
+			c.owners = owners
+			c.mutex.Unlock()
+			return
+		}
+	}
+	n := len(c.opts.Addrs)
+	for i, addr := range c.opts.Addrs {
+		for s := i * slot.Count / n; s < (i+1)*slot.Count/n; s++ {
+			owners[s] = addr
+		}
+	}
+	c.mutex.Lock()
+	c.owners = owners
+	c.mutex.Unlock()
+}
+
+// parseClusterSlots fills owners from a CLUSTER SLOTS reply, an array of
+// [start, end, [host, port, ...], replicas...] ranges, reporting whether
+// every slot is covered.
+func parseClusterSlots(reply interface{}, owners []string) bool {
+	ranges, ok := reply.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, r := range ranges {
+		fields, ok := r.([]interface{})
+		if !ok || len(fields) < 3 {
+			return false
+		}
+		start, _ := fields[0].(int64)
+		end, _ := fields[1].(int64)
+		primary, _ := fields[2].([]interface{})
+		if len(primary) < 2 || start < 0 || end >= slot.Count || start > end {
+			return false
+		}
+		host, _ := primary[0].(string)
+		port, _ := primary[1].(int64)
+		for s := start; s <= end; s++ {
+			owners[s] = host + ":" + strconv.FormatInt(port, 10)
+		}
+	}
+	for _, owner := range owners {
+		if owner == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMoved decodes a "MOVED slot host:port" error reply.
+func parseMoved(err Error) (redirect, bool) {
+	fields := strings.Fields(string(err))
+	if len(fields) != 3 || fields[0] != "MOVED" {
+		return redirect{}, false
+	}
+	s, convErr := strconv.Atoi(fields[1])
+	if convErr != nil || s < 0 || s >= slot.Count {
+		return redirect{}, false
+	}
+	return redirect{slot: s, addr: fields[2], err: err}, true
+}
+
+// BulkUpsert adds or replaces points in collection. Points are grouped by
+// the node owning the slot of their id, and each node is sent its share in
+// pipelined batches, the nodes in parallel. Points a node redirects with
+// MOVED are sent again to their new owner once the slot map was reloaded,
+// a single time per round rather than once per point.
+func (c *ClusterClient) BulkUpsert(collection string, points []Point) error {
+	pending := points
+	for round := 0; len(pending) > 0; round++ {
+		if round > maxRedirects {
+			return fmt.Errorf("client: %d points still redirected after %d attempts", len(pending), maxRedirects)
+		}
+		c.mutex.Lock()
+		byNode := make(map[string][]Point)
+		for _, p := range pending {
+			owner := c.owners[slot.ForKey(p.ID)]
+			byNode[owner] = append(byNode[owner], p)
+		}
+		c.mutex.Unlock()
+
+		var (
+			wg       sync.WaitGroup
+			mutex    sync.Mutex
+			moved    []redirect
+			firstErr error
+		)
+		for addr, share := range byNode {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				redirected, err := c.node(addr).bulkUpsert(collection, share)
+				mutex.Lock()
+				defer mutex.Unlock()
+				moved = append(moved, redirected...)
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("node %s: %w", addr, err)
+				}
+			}()
+		}
+		wg.Wait()
+		if firstErr != nil {
+			return firstErr
+		}
+		if len(moved) == 0 {
+			return nil
+		}
+		c.refreshSlots()
+		c.mutex.Lock()
+		pending = pending[:0:0]
+		for _, r := range moved {
+			// The redirection is more recent than a map read from a
+			// node that may not have learned of it yet.
+			c.owners[r.slot] = r.addr
+			pending = append(pending, r.point)
+		}
+		c.mutex.Unlock()
+	}
+	return nil
+}
+
+// Close closes the connections to every node.
+func (c *ClusterClient) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, n := range c.nodes {
+		n.Close()
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"readpebble/internal/resp"
+)
+
+// Error is an error reply (-ERR ...) sent by the server. It means the server
+// is reachable, so it is never treated as a broken connection.
+type Error = resp.ErrorReply
+
+// conn is a single RESP connection to the server.
+type conn struct {
+	netConn  net.Conn
+	reader   *resp.Reader
+	writer   *bufio.Writer
+	lastUsed time.Time
+	// lastChecked is refreshed by liveness pings only, so that pings do not
+	// keep an otherwise unused connection from being pruned.
+	lastChecked time.Time
+	broken      bool
+	// sent is whether any byte of the last command reached the socket, so
+	// that the server may have run it even though it failed.
+	sent bool
+	// written counts the bytes written to the socket.
+	written *countingWriter
+	// pressure is the load reported by the server in a RESP3 attribute on
+	// the last reply, or 0 if the reply carried none.
+	pressure int64
+}
+
+func dial(addr string, timeout time.Duration) (*conn, error) {
+	netConn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	written := &countingWriter{w: netConn}
+	c := &conn{
+		netConn:     netConn,
+		reader:      resp.NewReader(netConn),
+		writer:      bufio.NewWriter(written),
+		written:     written,
+		lastUsed:    time.Now(),
+		lastChecked: time.Now(),
+	}
+	c.reader.OnAttribute = c.recordAttributes
+	return c, nil
+}
+
+// do sends a command and reads its reply. Any failure other than a server
+// error reply marks the connection as broken so the pool discards it.
+func (c *conn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		c.netConn.SetDeadline(time.Now().Add(timeout))
+	}
+	before := c.written.n
+	err := c.writeCommand(args)
+	c.sent = c.written.n > before
+	if err != nil {
+		c.broken = true
+		return nil, err
+	}
+	c.pressure = 0
+	reply, err := c.readReply()
+	if err != nil {
+		var serverErr Error
+		if !errors.As(err, &serverErr) {
+			c.broken = true
+		}
+		return nil, err
+	}
+	c.lastUsed = time.Now()
+	return reply, nil
+}
+
+// pipeline sends every command before reading any reply, with a single
+// write. Error replies are returned in place of the replies they stand for;
+// any other failure marks the connection as broken.
+func (c *conn) pipeline(timeout time.Duration, cmds [][]string) ([]interface{}, error) {
+	if timeout > 0 {
+		c.netConn.SetDeadline(time.Now().Add(timeout))
+	}
+	for _, args := range cmds {
+		if _, err := c.writer.WriteString(resp.StringArray(args)); err != nil {
+			c.broken = true
+			return nil, err
+		}
+	}
+	if err := c.writer.Flush(); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	c.pressure = 0
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := c.readReply()
+		if err != nil {
+			var serverErr Error
+			if !errors.As(err, &serverErr) {
+				c.broken = true
+				return nil, err
+			}
+			reply = serverErr
+		}
+		replies[i] = reply
+	}
+	c.lastUsed = time.Now()
+	return replies, nil
+}
+
+func (c *conn) ping(timeout time.Duration) error {
+	lastUsed := c.lastUsed
+	reply, err := c.do(timeout, "PING")
+	c.lastUsed = lastUsed
+	if err != nil {
+		return err
+	}
+	c.lastChecked = time.Now()
+	if reply != "PONG" {
+		c.broken = true
+		return fmt.Errorf("unexpected PING reply: %v", reply)
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (c *conn) close() error {
+	return c.netConn.Close()
+}
+
+func (c *conn) writeCommand(args []string) error {
+	if _, err := c.writer.WriteString(resp.StringArray(args)); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+// hello switches the connection to RESP3 so that the server can attach load
+// attributes to its replies.
+func (c *conn) hello(timeout time.Duration) error {
+	_, err := c.do(timeout, "HELLO", "3")
+	return err
+}
+
+// readReply decodes one RESP2 or RESP3 reply, as resp.Reader.ReadReply
+// does. The load signal carried by attributes is recorded on the connection.
+func (c *conn) readReply() (interface{}, error) {
+	return c.reader.ReadReply()
+}
+
+// recordAttributes records the load pressure reported in the attributes of
+// a reply.
+func (c *conn) recordAttributes(pairs []interface{}) {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] != "load" {
+			continue
+		}
+		load, _ := pairs[i+1].([]interface{})
+		for j := 0; j+1 < len(load); j += 2 {
+			if load[j] == "pressure" {
+				c.pressure, _ = load[j+1].(int64)
+			}
+		}
+	}
+}
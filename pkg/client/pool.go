@@ -0,0 +1,244 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package client
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrPoolClosed = errors.New("client: connection pool is closed")
+
+type pool struct {
+	opts   Options
+	mutex  sync.Mutex
+	idle   []*conn
+	sem    chan struct{}
+	closed bool
+	quitCh chan struct{}
+}
+
+func newPool(opts Options) *pool {
+	p := &pool{
+		opts:   opts,
+		sem:    make(chan struct{}, opts.PoolSize),
+		quitCh: make(chan struct{}),
+	}
+	if opts.HealthCheckInterval > 0 {
+		go p.reaper()
+	}
+	return p
+}
+
+// get returns a healthy connection. Idle connections that have not been used
+// for longer than HealthCheckInterval are pinged before being handed out, and
+// new connections are dialed with exponential backoff.
+func (p *pool) get() (*conn, error) {
+	p.sem <- struct{}{}
+	for {
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			<-p.sem
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mutex.Unlock()
+			break
+		}
+		cn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mutex.Unlock()
+
+		if p.isStale(cn) {
+			cn.close()
+			continue
+		}
+		if p.opts.HealthCheckInterval > 0 && time.Since(cn.lastChecked) > p.opts.HealthCheckInterval {
+			if err := cn.ping(p.opts.ReadTimeout); err != nil {
+				log.Printf("client: dropping unhealthy connection: %v", err)
+				cn.close()
+				continue
+			}
+		}
+		return cn, nil
+	}
+
+	cn, err := p.dialWithBackoff()
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+	return cn, nil
+}
+
+// put returns a connection to the pool, discarding it if it is broken.
+func (p *pool) put(cn *conn) {
+	defer func() { <-p.sem }()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if cn.broken || p.closed {
+		cn.close()
+		return
+	}
+	p.idle = append(p.idle, cn)
+}
+
+func (p *pool) dialWithBackoff() (*conn, error) {
+	var lastErr error
+	for attempt := 0; attempt <= p.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(p.backoff(attempt)):
+			case <-p.quitCh:
+				return nil, ErrPoolClosed
+			}
+		}
+		cn, err := dial(p.opts.Addr, p.opts.DialTimeout)
+		if err == nil {
+			if err = p.setup(cn); err != nil {
+				cn.close()
+				// A rejected AUTH or READCONSISTENCY will not succeed on retry.
+				var serverErr Error
+				if errors.As(err, &serverErr) {
+					return nil, err
+				}
+			}
+		}
+		if err == nil && p.opts.LoadShedding {
+			if err = cn.hello(p.opts.ReadTimeout); err != nil {
+				cn.close()
+			}
+		}
+		if err == nil {
+			return cn, nil
+		}
+		lastErr = err
+		log.Printf("client: dial %s failed (attempt %d): %v", p.opts.Addr, attempt+1, err)
+	}
+	return nil, lastErr
+}
+
+// setup authenticates and sets the read consistency on a new connection.
+func (p *pool) setup(cn *conn) error {
+	if p.opts.Password != "" {
+		args := []string{"AUTH", p.opts.Password}
+		if p.opts.Username != "" {
+			args = []string{"AUTH", p.opts.Username, p.opts.Password}
+		}
+		if _, err := cn.do(p.opts.ReadTimeout, args...); err != nil {
+			return err
+		}
+	}
+	if p.opts.ReadConsistency != "" {
+		args := []string{"READCONSISTENCY", p.opts.ReadConsistency}
+		if strings.EqualFold(p.opts.ReadConsistency, "bounded") {
+			args = append(args, strconv.FormatInt(p.opts.MaxLag.Milliseconds(), 10))
+		}
+		if _, err := cn.do(p.opts.ReadTimeout, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backoff returns the delay before the given retry attempt: MinBackoff doubled
+// per attempt, capped at MaxBackoff, with up to 50% random jitter.
+func (p *pool) backoff(attempt int) time.Duration {
+	delay := p.opts.MinBackoff << uint(attempt-1)
+	if delay <= 0 || delay > p.opts.MaxBackoff {
+		delay = p.opts.MaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (p *pool) isStale(cn *conn) bool {
+	return p.opts.IdleTimeout > 0 && time.Since(cn.lastUsed) > p.opts.IdleTimeout
+}
+
+// reaper periodically prunes connections idle for longer than IdleTimeout and
+// pings the remaining ones so that dead connections are noticed before a
+// caller picks them up.
+func (p *pool) reaper() {
+	ticker := time.NewTicker(p.opts.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.prune()
+		case <-p.quitCh:
+			return
+		}
+	}
+}
+
+func (p *pool) prune() {
+	p.mutex.Lock()
+	candidates := p.idle
+	p.idle = nil
+	p.mutex.Unlock()
+
+	healthy := make([]*conn, 0, len(candidates))
+	for _, cn := range candidates {
+		if p.isStale(cn) {
+			cn.close()
+			continue
+		}
+		if err := cn.ping(p.opts.ReadTimeout); err != nil {
+			log.Printf("client: pruning unhealthy connection: %v", err)
+			cn.close()
+			continue
+		}
+		healthy = append(healthy, cn)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		for _, cn := range healthy {
+			cn.close()
+		}
+		return
+	}
+	p.idle = append(p.idle, healthy...)
+}
+
+func (p *pool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.quitCh)
+	for _, cn := range p.idle {
+		cn.close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,303 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Options configures a remote client talking RESP to a vecble server.
+type Options struct {
+	Addr string
+	// Username and Password are sent with AUTH on every new connection when
+	// Password is set.
+	Username string
+	Password string
+	// PoolSize is the maximum number of connections in use at the same time.
+	PoolSize    int
+	DialTimeout time.Duration
+	ReadTimeout time.Duration
+	// HealthCheckInterval is how often idle connections are pinged. Zero
+	// disables liveness pings.
+	HealthCheckInterval time.Duration
+	// IdleTimeout is how long a connection may sit unused before it is
+	// pruned from the pool. Zero keeps idle connections forever.
+	IdleTimeout time.Duration
+	// MaxRetries is how many times dials and commands failing on a broken
+	// connection are retried before the error is returned to the caller.
+	// Zero means the default of 3, a negative value disables retries.
+	// Commands sent with Do are only retried if none of them reached the
+	// server; see DoIdempotent.
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// LoadShedding negotiates RESP3 on every connection and delays commands
+	// while the server reports pressure in its reply attributes.
+	LoadShedding bool
+	// MaxShedDelay bounds the delay added to a command under full pressure.
+	MaxShedDelay time.Duration
+	// ReadConsistency, when set, is the consistency of reads on every new
+	// connection: "local", "leader" or "bounded", which lets a replica
+	// serve reads only while it heard from its master within MaxLag.
+	ReadConsistency string
+	MaxLag          time.Duration
+	// BulkBatchSize is how many commands BulkUpsert pipelines per round
+	// trip.
+	BulkBatchSize int
+}
+
+func (o *Options) setDefaults() {
+	if o.Addr == "" {
+		o.Addr = "localhost:6379"
+	}
+	if o.PoolSize <= 0 {
+		o.PoolSize = 10
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	if o.ReadTimeout <= 0 {
+		o.ReadTimeout = 3 * time.Second
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 3
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 8 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 512 * time.Millisecond
+	}
+	if o.MaxShedDelay <= 0 {
+		o.MaxShedDelay = 100 * time.Millisecond
+	}
+	if o.BulkBatchSize <= 0 {
+		o.BulkBatchSize = 256
+	}
+}
+
+type remoteClient struct {
+	opts    Options
+	pool    *pool
+	shedder *loadShedder
+}
+
+// Do runs a command on a pooled connection. If the connection turns out to
+// be broken before the command was sent, it is retried on a fresh one, with
+// backoff, so transient network blips do not surface to the caller. Once
+// sent it is not, since the server may have run it: the caller gets the
+// error. Server error replies are returned as Error and never retried.
+func (c *remoteClient) Do(args ...string) (interface{}, error) {
+	return c.do(false, args)
+}
+
+// DoIdempotent runs a command like Do, but also retries it when the
+// connection broke after it was sent, for commands the caller knows are
+// safe to run twice, such as reads.
+func (c *remoteClient) DoIdempotent(args ...string) (interface{}, error) {
+	return c.do(true, args)
+}
+
+func (c *remoteClient) do(idempotent bool, args []string) (interface{}, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(c.pool.backoff(attempt))
+		}
+		c.shedder.wait()
+		cn, err := c.pool.get()
+		if err != nil {
+			return nil, err
+		}
+		reply, err := cn.do(c.opts.ReadTimeout, args...)
+		sent := cn.sent
+		if !cn.broken {
+			c.shedder.observe(cn.pressure)
+		}
+		c.pool.put(cn)
+		if err == nil {
+			return reply, nil
+		}
+		var serverErr Error
+		if errors.As(err, &serverErr) {
+			return nil, err
+		}
+		if sent && !idempotent {
+			return nil, err
+		}
+		lastErr = err
+		log.Printf("client: %s failed on broken connection (attempt %d): %v", args[0], attempt+1, err)
+	}
+	return nil, lastErr
+}
+
+// pipeline runs commands on a pooled connection with a single round trip,
+// retrying them on a fresh connection like DoIdempotent if the connection is
+// broken, even once sent, so the commands must be safe to run twice, as the
+// upserts of BulkUpsert are. Error replies are returned in place of the
+// replies they stand for.
+func (c *remoteClient) pipeline(cmds [][]string) ([]interface{}, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(c.pool.backoff(attempt))
+		}
+		c.shedder.wait()
+		cn, err := c.pool.get()
+		if err != nil {
+			return nil, err
+		}
+		replies, err := cn.pipeline(c.opts.ReadTimeout, cmds)
+		if !cn.broken {
+			c.shedder.observe(cn.pressure)
+		}
+		c.pool.put(cn)
+		if err == nil {
+			return replies, nil
+		}
+		lastErr = err
+		log.Printf("client: pipeline of %d commands failed on broken connection (attempt %d): %v", len(cmds), attempt+1, err)
+	}
+	return nil, lastErr
+}
+
+// Point is a vector added or replaced by BulkUpsert.
+type Point struct {
+	ID     string
+	Vector []float64
+	// Payload is a JSON object stored with the point, or empty for none.
+	Payload string
+}
+
+func (p Point) vaddArgs(collection string) []string {
+	args := make([]string, 0, 5+len(p.Vector))
+	args = append(args, "VADD", collection, p.ID)
+	for _, x := range p.Vector {
+		args = append(args, strconv.FormatFloat(x, 'g', -1, 64))
+	}
+	if p.Payload != "" {
+		args = append(args, "PAYLOAD", p.Payload)
+	}
+	return args
+}
+
+// BulkUpsert adds or replaces points in collection, pipelining them in
+// batches of BulkBatchSize so that a large load takes a round trip per
+// batch rather than per point. It stops at the first point refused.
+func (c *remoteClient) BulkUpsert(collection string, points []Point) error {
+	moved, err := c.bulkUpsert(collection, points)
+	if err == nil && len(moved) > 0 {
+		err = fmt.Errorf("point %q: %w", moved[0].point.ID, moved[0].err)
+	}
+	return err
+}
+
+// redirect is a point a cluster node refused with MOVED, naming the node
+// owning its slot.
+type redirect struct {
+	point Point
+	slot  int
+	addr  string
+	err   Error
+}
+
+// bulkUpsert sends points in pipelined batches, returning the ones
+// redirected with MOVED so that a cluster client can send them to their
+// owner.
+func (c *remoteClient) bulkUpsert(collection string, points []Point) ([]redirect, error) {
+	var moved []redirect
+	for start := 0; start < len(points); start += c.opts.BulkBatchSize {
+		batch := points[start:min(start+c.opts.BulkBatchSize, len(points))]
+		cmds := make([][]string, len(batch))
+		for i, p := range batch {
+			cmds[i] = p.vaddArgs(collection)
+		}
+		replies, err := c.pipeline(cmds)
+		if err != nil {
+			return moved, err
+		}
+		for i, reply := range replies {
+			serverErr, ok := reply.(Error)
+			if !ok {
+				continue
+			}
+			if r, ok := parseMoved(serverErr); ok {
+				r.point = batch[i]
+				moved = append(moved, r)
+				continue
+			}
+			return moved, fmt.Errorf("point %q: %w", batch[i].ID, serverErr)
+		}
+	}
+	return moved, nil
+}
+
+func (c *remoteClient) Ping() error {
+	reply, err := c.DoIdempotent("PING")
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected PING reply: %v", reply)
+	}
+	return nil
+}
+
+func (c *remoteClient) Set(key string, value string) error {
+	_, err := c.Do("SET", key, value)
+	return err
+}
+
+// Get returns the value stored at key and false if the key does not exist.
+func (c *remoteClient) Get(key string) (string, bool, error) {
+	reply, err := c.DoIdempotent("GET", key)
+	if err != nil || reply == nil {
+		return "", false, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return "", false, fmt.Errorf("unexpected GET reply: %v", reply)
+	}
+	return value, true, nil
+}
+
+// Close closes all pooled connections and stops the health checker.
+func (c *remoteClient) Close() {
+	c.pool.close()
+}
+
+func NewRemoteClient(opts Options) *remoteClient {
+	opts.setDefaults()
+	return &remoteClient{
+		opts:    opts,
+		pool:    newPool(opts),
+		shedder: newLoadShedder(opts.MinBackoff, opts.MaxShedDelay),
+	}
+}
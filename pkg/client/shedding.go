@@ -0,0 +1,71 @@
+/*
+ *   Copyright (c) 2025 Vecble
+ *   All rights reserved.
+
+ *   Permission is hereby granted, free of charge, to any person obtaining a copy
+ *   of this software and associated documentation files (the "Software"), to deal
+ *   in the Software without restriction, including without limitation the rights
+ *   to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *   copies of the Software, and to permit persons to whom the Software is
+ *   furnished to do so, subject to the following conditions:
+
+ *   The above copyright notice and this permission notice shall be included in all
+ *   copies or substantial portions of the Software.
+
+ *   THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *   IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *   FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *   AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *   LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *   OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ *   SOFTWARE.
+ */
+
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// loadShedder delays outgoing commands while the server reports pressure.
+// The delay grows multiplicatively while pressure persists, bounded by the
+// reported pressure as a fraction of maxDelay, and halves on every reply that
+// carries no pressure, so clients ease off together instead of piling onto an
+// overloaded server and recover smoothly once it drains.
+type loadShedder struct {
+	mutex    sync.Mutex
+	delay    time.Duration
+	minDelay time.Duration
+	maxDelay time.Duration
+}
+
+func newLoadShedder(minDelay, maxDelay time.Duration) *loadShedder {
+	return &loadShedder{
+		minDelay: minDelay,
+		maxDelay: maxDelay,
+	}
+}
+
+func (l *loadShedder) observe(pressure int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if pressure <= 0 {
+		l.delay /= 2
+		if l.delay < l.minDelay/2 {
+			l.delay = 0
+		}
+		return
+	}
+	ceiling := l.maxDelay * time.Duration(min(pressure, 100)) / 100
+	l.delay = min(max(l.delay*2, l.minDelay), max(ceiling, l.minDelay))
+}
+
+func (l *loadShedder) wait() {
+	l.mutex.Lock()
+	delay := l.delay
+	l.mutex.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}